@@ -103,6 +103,35 @@ func getActiveMountpoints() (map[string]bool, error) {
 	return mounts, nil
 }
 
+// BlockDevice is a block device of the host with its filesystem as
+// reported by lsblk
+type BlockDevice struct {
+	Name        string        `json:"name"`
+	Type        string        `json:"type"`
+	Size        uint64        `json:"size"`
+	FSType      *string       `json:"fstype"`
+	Label       *string       `json:"label"`
+	Mountpoints []*string     `json:"mountpoints"`
+	Children    []BlockDevice `json:"children"`
+}
+
+// BlockDevices returns the block devices of the host, the sizes are in
+// bytes.
+func BlockDevices() ([]BlockDevice, error) {
+	stdout, stderr, exitCode, err := Exec("lsblk", "-J", "-b", "-o", "NAME,TYPE,SIZE,FSTYPE,LABEL,MOUNTPOINTS")
+	if err != nil {
+		return nil, fmt.Errorf("failed to run lsblk: %w (exit %d, stderr: %s)", err, exitCode, string(stderr))
+	}
+
+	var output struct {
+		BlockDevices []BlockDevice `json:"blockdevices"`
+	}
+	if err := json.Unmarshal(stdout, &output); err != nil {
+		return nil, fmt.Errorf("failed to parse lsblk JSON: %w", err)
+	}
+	return output.BlockDevices, nil
+}
+
 func filesystemCheck(meta *Metadata, config *buildconfig.BuildConfig) error {
 	expected := collectExpectedMountpoints(config)
 	if len(expected) == 0 {
@@ -199,3 +199,36 @@ func TestFilesystemCheck(t *testing.T) {
 		})
 	}
 }
+
+func TestBlockDevices(t *testing.T) {
+	t.Run("parses lsblk output", func(t *testing.T) {
+		installMockExec(t, map[string]ExecResult{
+			"lsblk -J -b -o NAME,TYPE,SIZE,FSTYPE,LABEL,MOUNTPOINTS": {Stdout: []byte(`{"blockdevices": [
+				{"name": "vda", "type": "disk", "size": 10737418240, "fstype": null, "label": null, "mountpoints": [null], "children": [
+					{"name": "vda1", "type": "part", "size": 1073741824, "fstype": "xfs", "label": "boot", "mountpoints": ["/boot"]},
+					{"name": "vda2", "type": "part", "size": 9663676416, "fstype": "xfs", "label": null, "mountpoints": ["/"]}
+				]}
+			]}`)},
+		})
+
+		devices, err := check.BlockDevices()
+		require.NoError(t, err)
+		require.Len(t, devices, 1)
+		assert.Equal(t, "vda", devices[0].Name)
+		assert.Nil(t, devices[0].FSType)
+		require.Len(t, devices[0].Children, 2)
+		boot := devices[0].Children[0]
+		assert.Equal(t, uint64(1073741824), boot.Size)
+		assert.Equal(t, "xfs", *boot.FSType)
+		assert.Equal(t, "boot", *boot.Label)
+		assert.Equal(t, "/boot", *boot.Mountpoints[0])
+	})
+
+	t.Run("fails when lsblk fails", func(t *testing.T) {
+		installMockExec(t, map[string]ExecResult{
+			"lsblk -J -b -o NAME,TYPE,SIZE,FSTYPE,LABEL,MOUNTPOINTS": {Code: 1, Err: fmt.Errorf("exit status 1")},
+		})
+		_, err := check.BlockDevices()
+		assert.ErrorContains(t, err, "failed to run lsblk: exit status 1")
+	})
+}
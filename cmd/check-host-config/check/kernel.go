@@ -16,6 +16,15 @@ func init() {
 	}, kernelCheck)
 }
 
+// KernelCmdline returns the command line of the running kernel
+func KernelCmdline() (string, error) {
+	cmdline, err := ReadFile("/proc/cmdline")
+	if err != nil {
+		return "", err
+	}
+	return strings.TrimSpace(string(cmdline)), nil
+}
+
 func kernelCheck(meta *Metadata, config *buildconfig.BuildConfig) error {
 	expected := config.Blueprint.Customizations.Kernel
 	if expected == nil {
@@ -35,12 +44,12 @@ func kernelCheck(meta *Metadata, config *buildconfig.BuildConfig) error {
 	}
 
 	if len(expected.Append) > 0 {
-		cmdline, err := ReadFile("/proc/cmdline")
+		cmdline, err := KernelCmdline()
 		if err != nil {
 			return Fail("failed to read /proc/cmdline:", err)
 		}
 
-		if !strings.Contains(string(cmdline), expected.Append) {
+		if !strings.Contains(cmdline, expected.Append) {
 			return Fail("kernel options append does not match:", expected.Append)
 		}
 	}
@@ -170,6 +170,8 @@ type fakeAwsUploader struct {
 	uploadAndRegisterRead  bytes.Buffer
 	uploadAndRegisterCalls int
 	uploadAndRegisterErr   error
+	// partialResult is returned together with uploadAndRegisterErr
+	partialResult *cloud.UploadResult
 }
 
 var _ = cloud.Uploader(&fakeAwsUploader{})
@@ -186,7 +188,7 @@ func (fa *fakeAwsUploader) UploadAndRegister(r io.Reader, size uint64, status io
 		panic(err)
 	}
 	if fa.uploadAndRegisterErr != nil {
-		return nil, fa.uploadAndRegisterErr
+		return fa.partialResult, fa.uploadAndRegisterErr
 	}
 	return &cloud.UploadResult{Provider: "aws"}, nil
 }
@@ -0,0 +1,71 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+
+	"github.com/BurntSushi/toml"
+	"github.com/osbuild/blueprint/pkg/blueprint"
+	"github.com/spf13/cobra"
+
+	"github.com/osbuild/image-builder/pkg/customizations/kickstart"
+)
+
+// writeBlueprint writes the blueprint as toml (the default) or json
+func writeBlueprint(out io.Writer, bp *blueprint.Blueprint, format string) error {
+	if format == "json" {
+		output, err := json.MarshalIndent(bp, "", "  ")
+		if err != nil {
+			return err
+		}
+		_, err = fmt.Fprintf(out, "%s\n", output)
+		return err
+	}
+	return toml.NewEncoder(out).Encode(bp)
+}
+
+func cmdBlueprintFromKickstart(cmd *cobra.Command, args []string) error {
+	format, err := cmd.Flags().GetString("format")
+	if err != nil {
+		return err
+	}
+	if !slices.Contains([]string{"", "toml", "json"}, format) {
+		return fmt.Errorf("unsupported format %q, supported formats: toml, json", format)
+	}
+	name, err := cmd.Flags().GetString("name")
+	if err != nil {
+		return err
+	}
+
+	ksPath := args[0]
+	f, err := os.Open(ksPath)
+	if err != nil {
+		return fmt.Errorf("cannot open kickstart file: %w", err)
+	}
+	defer f.Close()
+	bp, unmapped, err := kickstart.ToBlueprint(f)
+	if err != nil {
+		return fmt.Errorf("cannot read kickstart file %s: %w", ksPath, err)
+	}
+	if name == "" {
+		name = strings.TrimSuffix(filepath.Base(ksPath), filepath.Ext(ksPath))
+	}
+	bp.Name = name
+
+	if err := writeBlueprint(cmd.OutOrStdout(), bp, format); err != nil {
+		return err
+	}
+
+	if len(unmapped) > 0 {
+		fmt.Fprintf(osStderr, "The following kickstart directives could not be mapped to the blueprint:\n")
+		for _, u := range unmapped {
+			fmt.Fprintf(osStderr, "%s:%d: %s: %s\n", ksPath, u.Line, u.Directive, u.Reason)
+		}
+	}
+	return nil
+}
@@ -0,0 +1,107 @@
+package main_test
+
+import (
+	"bytes"
+	"encoding/json"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	main "github.com/osbuild/image-builder/cmd/image-builder"
+)
+
+func runBlueprintFromKickstart(t *testing.T, args ...string) (string, string, error) {
+	var fakeStdout, fakeStderr bytes.Buffer
+	restore := main.MockOsStdout(&fakeStdout)
+	defer restore()
+	restore = main.MockOsStderr(&fakeStderr)
+	defer restore()
+	restore = main.MockOsArgs(append([]string{"blueprint", "from-kickstart"}, args...))
+	defer restore()
+	err := main.Run()
+	return fakeStdout.String(), fakeStderr.String(), err
+}
+
+var testKickstart = `text
+lang en_US.UTF-8
+timezone UTC --utc
+user --name=alice --groups=wheel
+sshkey --username=alice "ssh-ed25519 AAAA alice@example"
+part / --fstype=xfs --size=4096 --grow --ondisk=vda
+
+%packages
+@core
+vim-enhanced
+%end
+`
+
+func TestBlueprintFromKickstart(t *testing.T) {
+	ksPath := writeBlueprintFile(t, "ks.cfg", testKickstart)
+
+	stdout, stderr, err := runBlueprintFromKickstart(t, ksPath)
+	require.NoError(t, err)
+	assert.Equal(t, `name = "ks"
+
+[[packages]]
+  name = "vim-enhanced"
+
+[[groups]]
+  name = "core"
+
+[customizations]
+
+  [[customizations.user]]
+    name = "alice"
+    key = "ssh-ed25519 AAAA alice@example"
+    groups = ["wheel"]
+  [customizations.timezone]
+    timezone = "UTC"
+  [customizations.locale]
+    languages = ["en_US.UTF-8"]
+  [customizations.disk]
+    start_offset = 0
+    sector_size = 0
+
+    [[customizations.disk.partitions]]
+      type = "plain"
+      minsize = 4294967296
+      mountpoint = "/"
+      fs_type = "xfs"
+`, stdout)
+	assert.Equal(t, `The following kickstart directives could not be mapped to the blueprint:
+`+ksPath+`:1: text: only relevant when installing
+`+ksPath+`:6: part / --fstype=xfs --size=4096 --grow --ondisk=vda: options not supported by blueprints: --ondisk
+`, stderr)
+}
+
+func TestBlueprintFromKickstartJSON(t *testing.T) {
+	ksPath := writeBlueprintFile(t, "ks.cfg", "lang de_DE.UTF-8\n")
+
+	stdout, stderr, err := runBlueprintFromKickstart(t, "--format", "json", "--name", "from-ks", ksPath)
+	require.NoError(t, err)
+	assert.Equal(t, "", stderr)
+
+	var bp struct {
+		Name           string
+		Customizations struct {
+			Locale struct {
+				Languages []string
+			}
+		}
+	}
+	require.NoError(t, json.Unmarshal([]byte(stdout), &bp))
+	assert.Equal(t, "from-ks", bp.Name)
+	assert.Equal(t, []string{"de_DE.UTF-8"}, bp.Customizations.Locale.Languages)
+}
+
+func TestBlueprintFromKickstartErrors(t *testing.T) {
+	ksPath := writeBlueprintFile(t, "ks.cfg", "user --uid=1000\n")
+
+	_, _, err := runBlueprintFromKickstart(t, ksPath)
+	assert.EqualError(t, err, "cannot read kickstart file "+ksPath+": line 1: user without --name")
+
+	ksPath = writeBlueprintFile(t, "ks.cfg", "text\n")
+	_, _, err = runBlueprintFromKickstart(t, "--format", "yaml", ksPath)
+	assert.EqualError(t, err, `unsupported format "yaml", supported formats: toml, json`)
+}
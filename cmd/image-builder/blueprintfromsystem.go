@@ -0,0 +1,733 @@
+package main
+
+import (
+	"encoding/xml"
+	"fmt"
+	"io"
+	"io/fs"
+	"log"
+	"os"
+	"path"
+	"path/filepath"
+	"slices"
+	"strings"
+
+	"github.com/osbuild/blueprint/pkg/blueprint"
+	"github.com/spf13/cobra"
+
+	"github.com/osbuild/image-builder/cmd/check-host-config/check"
+	"github.com/osbuild/image-builder/internal/common"
+	"github.com/osbuild/image-builder/pkg/depsolvednf"
+	"github.com/osbuild/image-builder/pkg/disk"
+	"github.com/osbuild/image-builder/pkg/distro"
+	"github.com/osbuild/image-builder/pkg/manifestgen"
+	"github.com/osbuild/image-builder/pkg/policies"
+	"github.com/osbuild/image-builder/pkg/rpmmd"
+)
+
+// variables so that they can be overridden in tests
+var (
+	hostRoot          = "/"
+	hostKernelCmdline = check.KernelCmdline
+	hostBlockDevices  = check.BlockDevices
+)
+
+// systemKernelArgs are the kernel arguments that the installation of a
+// system sets, they are not part of the customized kernel arguments
+var systemKernelArgs = []string{
+	"BOOT_IMAGE",
+	"boot",
+	"initrd",
+	"rd.luks.uuid",
+	"rd.lvm.lv",
+	"rd.lvm.vg",
+	"rd.md.uuid",
+	"resume",
+	"ro",
+	"root",
+	"rootflags",
+	"rootfstype",
+	"rw",
+}
+
+// hasRpmdb checks if the tree has an rpm database
+func hasRpmdb(tree *inspectTree) bool {
+	for _, p := range []string{"/usr/lib/sysimage/rpm", "/var/lib/rpm"} {
+		if _, err := os.Stat(tree.path(p)); err == nil {
+			return true
+		}
+	}
+	return false
+}
+
+// leafPackages returns the installed packages that no other installed
+// package requires, these are the packages that were installed on
+// purpose
+func leafPackages(tree *inspectTree) ([]string, error) {
+	if !hasRpmdb(tree) {
+		return nil, nil
+	}
+	output, err := commandOutput("rpm", "--root", tree.path("/"), "--query", "--all", "--queryformat",
+		`[%{NAME}\tR\t%{REQUIRENAME}\n][%{NAME}\tP\t%{PROVIDENAME}\n][%{NAME}\tF\t%{FILENAMES}\n]`)
+	if err != nil {
+		return nil, fmt.Errorf("cannot list package dependencies: %w", err)
+	}
+
+	installed := make(map[string]bool)
+	requires := make(map[string][]string)
+	// the packages that provide a capability or file
+	providers := make(map[string][]string)
+	for _, line := range strings.Split(string(output), "\n") {
+		fields := strings.SplitN(line, "\t", 3)
+		if len(fields) != 3 || fields[0] == "gpg-pubkey" {
+			continue
+		}
+		name, kind, value := fields[0], fields[1], fields[2]
+		installed[name] = true
+		switch kind {
+		case "R":
+			// rich dependencies and rpmlib features are not resolved
+			if strings.HasPrefix(value, "(") || strings.HasPrefix(value, "rpmlib(") {
+				continue
+			}
+			requires[name] = append(requires[name], value)
+		case "P", "F":
+			providers[value] = append(providers[value], name)
+		}
+	}
+
+	required := make(map[string]bool)
+	for name, reqs := range requires {
+		for _, req := range reqs {
+			for _, provider := range providers[req] {
+				if provider != name {
+					required[provider] = true
+				}
+			}
+		}
+	}
+	var leaves []string
+	for name := range installed {
+		if !required[name] {
+			leaves = append(leaves, name)
+		}
+	}
+	slices.Sort(leaves)
+	return leaves, nil
+}
+
+// defaultPackagesFor returns the packages that the default package set
+// of the operating system of the image type installs. The package set
+// is depsolved, so the members of its groups (e.g. @core) and their
+// dependencies are part of it.
+func defaultPackagesFor(imgType distro.ImageType, repoDir, rpmmdCacheDir string) ([]string, error) {
+	a := imgType.Arch()
+	d := a.Distro()
+	registry, err := newRepoRegistry(repoDir, nil)
+	if err != nil {
+		return nil, err
+	}
+	repos, err := registry.ReposByImageTypeName(d.Name(), a.Name(), imgType.Name())
+	if err != nil {
+		return nil, err
+	}
+	manifest, err := dummyManifestFor(imgType, repos)
+	if err != nil {
+		return nil, err
+	}
+	pkgSetChains, err := manifest.GetPackageSetChains()
+	if err != nil {
+		return nil, err
+	}
+	osPkgSets, ok := pkgSetChains["os"]
+	if !ok {
+		return nil, nil
+	}
+
+	if rpmmdCacheDir == "" {
+		if rpmmdCacheDir, err = manifestgen.DefaultCacheDir(); err != nil {
+			return nil, err
+		}
+	}
+	depsolve := manifestgenDepsolver
+	if depsolve == nil {
+		depsolve = manifestgen.DefaultDepsolve
+	}
+	solver := depsolvednf.NewSolver(d.ModulePlatformID(), d.Releasever(), a.Name(), d.Name(), rpmmdCacheDir)
+	res, err := depsolve(solver, rpmmdCacheDir, io.Discard, map[string][]rpmmd.PackageSet{"os": osPkgSets}, d, a.Name())
+	if err != nil {
+		return nil, fmt.Errorf("cannot depsolve the default packages of %s: %w", imgType.Name(), err)
+	}
+	var names []string
+	for _, pkg := range res["os"].Transactions.AllPackages() {
+		names = append(names, pkg.Name)
+	}
+	return names, nil
+}
+
+type presetRule struct {
+	enable  bool
+	pattern string
+}
+
+// readPresets returns the systemd preset rules of the tree in the order
+// in which they apply, see systemd.preset(5)
+func readPresets(tree *inspectTree) []presetRule {
+	// files in /etc override the files with the same name in /usr/lib
+	files := make(map[string]string)
+	for _, dir := range []string{"/usr/lib/systemd/system-preset", "/etc/systemd/system-preset"} {
+		matches, _ := filepath.Glob(filepath.Join(tree.path(dir), "*.preset"))
+		for _, match := range matches {
+			files[filepath.Base(match)] = match
+		}
+	}
+	names := make([]string, 0, len(files))
+	for name := range files {
+		names = append(names, name)
+	}
+	slices.Sort(names)
+
+	var rules []presetRule
+	for _, name := range names {
+		data, err := os.ReadFile(files[name])
+		if err != nil {
+			continue
+		}
+		for _, line := range strings.Split(string(data), "\n") {
+			fields := strings.Fields(line)
+			if len(fields) < 2 || (fields[0] != "enable" && fields[0] != "disable") {
+				continue
+			}
+			rules = append(rules, presetRule{enable: fields[0] == "enable", pattern: fields[1]})
+		}
+	}
+	return rules
+}
+
+func presetEnables(rules []presetRule, unit string) bool {
+	for _, rule := range rules {
+		if match, _ := path.Match(rule.pattern, unit); match {
+			return rule.enable
+		}
+	}
+	// units without a preset are enabled
+	return true
+}
+
+// isInstallableUnit checks if the unit file can be enabled
+func isInstallableUnit(unitPath string) bool {
+	data, err := os.ReadFile(unitPath)
+	if err != nil {
+		return false
+	}
+	for _, line := range strings.Split(string(data), "\n") {
+		key, _, _ := strings.Cut(strings.TrimSpace(line), "=")
+		if key == "WantedBy" || key == "RequiredBy" || key == "UpheldBy" {
+			return true
+		}
+	}
+	return false
+}
+
+// systemServices returns the services that are enabled or disabled
+// differently than the presets of the system and the masked services.
+// Instances of template units are not included.
+func systemServices(tree *inspectTree) *blueprint.ServicesCustomization {
+	rules := readPresets(tree)
+	services := &blueprint.ServicesCustomization{}
+
+	enabled := make(map[string]bool)
+	for _, unit := range inspectEnabledUnits(tree) {
+		if strings.Contains(unit, "@") {
+			continue
+		}
+		enabled[unit] = true
+		if !presetEnables(rules, unit) {
+			services.Enabled = append(services.Enabled, unit)
+		}
+	}
+
+	masked := make(map[string]bool)
+	if entries, err := os.ReadDir(tree.path("/etc/systemd/system")); err == nil {
+		for _, entry := range entries {
+			if entry.Type()&fs.ModeSymlink == 0 {
+				continue
+			}
+			target, err := os.Readlink(filepath.Join(tree.path("/etc/systemd/system"), entry.Name()))
+			if err == nil && target == "/dev/null" {
+				masked[entry.Name()] = true
+				services.Masked = append(services.Masked, entry.Name())
+			}
+		}
+	}
+
+	// units that the presets enable but that are not enabled were
+	// disabled
+	if entries, err := os.ReadDir(tree.path("/usr/lib/systemd/system")); err == nil {
+		for _, entry := range entries {
+			unit := entry.Name()
+			if !entry.Type().IsRegular() || strings.Contains(unit, "@") || enabled[unit] || masked[unit] {
+				continue
+			}
+			if presetEnables(rules, unit) && isInstallableUnit(filepath.Join(tree.path("/usr/lib/systemd/system"), unit)) {
+				services.Disabled = append(services.Disabled, unit)
+			}
+		}
+	}
+
+	if len(services.Enabled)+len(services.Disabled)+len(services.Masked) == 0 {
+		return nil
+	}
+	return services
+}
+
+// blueprintUsersAndGroups converts the users and groups of the system,
+// the private groups of the users are left out as they are created with
+// the users
+func blueprintUsersAndGroups(tree *inspectTree, users []inspectUser, groups []inspectGroup) ([]blueprint.UserCustomization, []blueprint.GroupCustomization) {
+	privateGroups := make(map[string]bool)
+	var bpUsers []blueprint.UserCustomization
+	for _, user := range users {
+		bpUser := blueprint.UserCustomization{
+			Name:   user.Name,
+			UID:    common.ToPtr(user.UID),
+			Groups: user.Groups,
+		}
+		if slices.Contains(groups, inspectGroup{Name: user.Name, GID: user.GID}) && user.GID == user.UID {
+			privateGroups[user.Name] = true
+		} else {
+			bpUser.GID = common.ToPtr(user.GID)
+		}
+		if user.Home != "" && user.Home != "/home/"+user.Name {
+			bpUser.Home = common.ToPtr(user.Home)
+		}
+		if user.Shell != "" {
+			bpUser.Shell = common.ToPtr(user.Shell)
+		}
+		if keys, err := tree.readFile(path.Join(user.Home, ".ssh/authorized_keys")); err == nil && strings.TrimSpace(string(keys)) != "" {
+			bpUser.Key = common.ToPtr(strings.TrimSpace(string(keys)))
+		}
+		bpUsers = append(bpUsers, bpUser)
+	}
+
+	var bpGroups []blueprint.GroupCustomization
+	for _, group := range groups {
+		if !privateGroups[group.Name] {
+			bpGroups = append(bpGroups, blueprint.GroupCustomization{Name: group.Name, GID: common.ToPtr(group.GID)})
+		}
+	}
+	return bpUsers, bpGroups
+}
+
+func systemHostname(tree *inspectTree) *string {
+	data, err := tree.readFile("/etc/hostname")
+	if err != nil {
+		return nil
+	}
+	hostname := strings.TrimSpace(string(data))
+	if hostname == "" || hostname == "localhost" || hostname == "localhost.localdomain" {
+		return nil
+	}
+	return &hostname
+}
+
+func systemTimezone(tree *inspectTree) *blueprint.TimezoneCustomization {
+	target, err := os.Readlink(tree.path("/etc/localtime"))
+	if err != nil {
+		return nil
+	}
+	_, timezone, ok := strings.Cut(target, "zoneinfo/")
+	if !ok || timezone == "" {
+		return nil
+	}
+	return &blueprint.TimezoneCustomization{Timezone: &timezone}
+}
+
+func systemLocale(tree *inspectTree) *blueprint.LocaleCustomization {
+	locale := &blueprint.LocaleCustomization{}
+	if data, err := tree.readFile("/etc/locale.conf"); err == nil {
+		if lang := parseKeyValues(data)["LANG"]; lang != "" {
+			locale.Languages = []string{lang}
+		}
+	}
+	if data, err := tree.readFile("/etc/vconsole.conf"); err == nil {
+		if keymap := parseKeyValues(data)["KEYMAP"]; keymap != "" {
+			locale.Keyboard = &keymap
+		}
+	}
+	if len(locale.Languages) == 0 && locale.Keyboard == nil {
+		return nil
+	}
+	return locale
+}
+
+type firewalldZone struct {
+	Services []struct {
+		Name string `xml:"name,attr"`
+	} `xml:"service"`
+	Ports []struct {
+		Port     string `xml:"port,attr"`
+		Protocol string `xml:"protocol,attr"`
+	} `xml:"port"`
+}
+
+func (z *firewalldZone) services() []string {
+	var services []string
+	for _, service := range z.Services {
+		services = append(services, service.Name)
+	}
+	return services
+}
+
+func (z *firewalldZone) ports() []string {
+	var ports []string
+	for _, port := range z.Ports {
+		ports = append(ports, port.Port+":"+port.Protocol)
+	}
+	return ports
+}
+
+func readFirewalldZone(tree *inspectTree, p string) (*firewalldZone, error) {
+	data, err := tree.readFile(p)
+	if err != nil {
+		return nil, err
+	}
+	var zone firewalldZone
+	if err := xml.Unmarshal(data, &zone); err != nil {
+		return nil, fmt.Errorf("cannot parse firewalld zone %s: %w", p, err)
+	}
+	return &zone, nil
+}
+
+// systemFirewall returns the changes of the default firewalld zone of the
+// system, nil is returned if the zone was not changed
+func systemFirewall(tree *inspectTree) (*blueprint.FirewallCustomization, error) {
+	zoneName := "public"
+	if conf, err := tree.readFile("/etc/firewalld/firewalld.conf"); err == nil {
+		if name := parseKeyValues(conf)["DefaultZone"]; name != "" {
+			zoneName = name
+		}
+	}
+	zone, err := readFirewalldZone(tree, "/etc/firewalld/zones/"+zoneName+".xml")
+	if err != nil {
+		if os.IsNotExist(err) {
+			return nil, nil
+		}
+		return nil, err
+	}
+	defaults, err := readFirewalldZone(tree, "/usr/lib/firewalld/zones/"+zoneName+".xml")
+	if err != nil {
+		if !os.IsNotExist(err) {
+			return nil, err
+		}
+		defaults = &firewalldZone{}
+	}
+
+	firewall := &blueprint.FirewallCustomization{}
+	services := &blueprint.FirewallServicesCustomization{}
+	for _, service := range zone.services() {
+		if !slices.Contains(defaults.services(), service) {
+			services.Enabled = append(services.Enabled, service)
+		}
+	}
+	for _, service := range defaults.services() {
+		if !slices.Contains(zone.services(), service) {
+			services.Disabled = append(services.Disabled, service)
+		}
+	}
+	if len(services.Enabled)+len(services.Disabled) > 0 {
+		firewall.Services = services
+	}
+	for _, port := range zone.ports() {
+		if !slices.Contains(defaults.ports(), port) {
+			firewall.Ports = append(firewall.Ports, port)
+		}
+	}
+	if firewall.Services == nil && len(firewall.Ports) == 0 {
+		return nil, nil
+	}
+	return firewall, nil
+}
+
+// kernelAppend returns the arguments of the kernel command line that
+// were not set by the installation of the system
+func kernelAppend(cmdline string) *blueprint.KernelCustomization {
+	var args []string
+	for _, arg := range strings.Fields(cmdline) {
+		key, _, _ := strings.Cut(arg, "=")
+		if !slices.Contains(systemKernelArgs, key) {
+			args = append(args, arg)
+		}
+	}
+	if len(args) == 0 {
+		return nil
+	}
+	return &blueprint.KernelCustomization{Append: strings.Join(args, " ")}
+}
+
+// addFilesystem adds the mountpoint if it can be customized, the ESP is
+// always created by the image type
+func addFilesystem(filesystems []blueprint.FilesystemCustomization, mountpoint string, size uint64) []blueprint.FilesystemCustomization {
+	if mountpoint == "/boot/efi" || policies.MountpointPolicies.Check(mountpoint) != nil {
+		return filesystems
+	}
+	if slices.ContainsFunc(filesystems, func(fs blueprint.FilesystemCustomization) bool { return fs.Mountpoint == mountpoint }) {
+		return filesystems
+	}
+	filesystems = append(filesystems, blueprint.FilesystemCustomization{Mountpoint: mountpoint, MinSize: size})
+	slices.SortFunc(filesystems, func(a, b blueprint.FilesystemCustomization) int {
+		return strings.Compare(a.Mountpoint, b.Mountpoint)
+	})
+	return filesystems
+}
+
+// imageFilesystems returns the mountpoints of the image with the size of
+// their partitions
+func imageFilesystems(pt *disk.PartitionTable) []blueprint.FilesystemCustomization {
+	var filesystems []blueprint.FilesystemCustomization
+	if pt == nil {
+		return nil
+	}
+	for _, part := range pt.Partitions {
+		if fsys, ok := part.Payload.(*disk.Filesystem); ok && fsys.Mountpoint != "" {
+			filesystems = addFilesystem(filesystems, fsys.Mountpoint, uint64(part.Size))
+		}
+	}
+	return filesystems
+}
+
+// hostFilesystems returns the mountpoints of the host with the size of
+// their devices. For filesystems that are mounted more than once (e.g.
+// btrfs subvolumes) only the first mountpoint is used.
+func hostFilesystems(devices []check.BlockDevice) []blueprint.FilesystemCustomization {
+	var filesystems []blueprint.FilesystemCustomization
+	var walk func(devices []check.BlockDevice)
+	walk = func(devices []check.BlockDevice) {
+		for _, dev := range devices {
+			if dev.FSType != nil && *dev.FSType != "swap" {
+				var mountpoints []string
+				for _, mp := range dev.Mountpoints {
+					if mp != nil && *mp != "" {
+						mountpoints = append(mountpoints, *mp)
+					}
+				}
+				slices.Sort(mountpoints)
+				if len(mountpoints) > 0 {
+					filesystems = addFilesystem(filesystems, mountpoints[0], dev.Size)
+				}
+			}
+			walk(dev.Children)
+		}
+	}
+	walk(devices)
+	return filesystems
+}
+
+// blueprintFromTree returns a blueprint with the packages and the
+// configuration of the installed system in the tree, the packages of
+// the default package set are left out
+func blueprintFromTree(tree *inspectTree, defaultPackages []string) (*blueprint.Blueprint, error) {
+	bp := &blueprint.Blueprint{}
+	leaves, err := leafPackages(tree)
+	if err != nil {
+		return nil, err
+	}
+	for _, name := range leaves {
+		if !slices.Contains(defaultPackages, name) {
+			bp.Packages = append(bp.Packages, blueprint.Package{Name: name})
+		}
+	}
+
+	cust := &blueprint.Customizations{
+		Hostname: systemHostname(tree),
+		Services: systemServices(tree),
+		Timezone: systemTimezone(tree),
+		Locale:   systemLocale(tree),
+	}
+	users, groups, err := inspectUsersAndGroups(tree)
+	if err != nil {
+		return nil, err
+	}
+	cust.User, cust.Group = blueprintUsersAndGroups(tree, users, groups)
+	if cust.Firewall, err = systemFirewall(tree); err != nil {
+		return nil, err
+	}
+	bp.Customizations = cust
+	return bp, nil
+}
+
+// packagesArch returns the architecture of the installed packages. The
+// kernel and rpm always have the architecture of the system, other
+// packages can be noarch or multilib packages (e.g. i686 on x86_64).
+func packagesArch(pkgs []inspectPackage) string {
+	for _, name := range []string{"kernel-core", "kernel", "rpm"} {
+		if idx := slices.IndexFunc(pkgs, func(p inspectPackage) bool { return p.Name == name }); idx >= 0 {
+			return pkgs[idx].Arch
+		}
+	}
+	return ""
+}
+
+// systemDefaultPackages returns the default packages of the image type
+// that are used as the base of the blueprint
+func systemDefaultPackages(cmd *cobra.Command, distroStr, archStr string) ([]string, error) {
+	repoDir, err := cmd.Flags().GetString("force-repo-dir")
+	if err != nil {
+		return nil, err
+	}
+	forceDefsDir, err := cmd.Flags().GetString("force-defs-dir")
+	if err != nil {
+		return nil, err
+	}
+	imgTypeStr, err := cmd.Flags().GetString("image-type")
+	if err != nil {
+		return nil, err
+	}
+	rpmmdCacheDir, err := cmd.Flags().GetString("rpmmd-cache")
+	if err != nil {
+		return nil, err
+	}
+	res, err := getOneImage(distroStr, imgTypeStr, archStr, &repoOptions{RepoDir: repoDir, ForceDefsDir: forceDefsDir})
+	if err != nil {
+		return nil, err
+	}
+	return defaultPackagesFor(res.ImgType, repoDir, rpmmdCacheDir)
+}
+
+func systemBlueprintFlags(cmd *cobra.Command) (distroStr, archStr, name, format string, err error) {
+	if distroStr, err = cmd.Flags().GetString("distro"); err != nil {
+		return
+	}
+	if archStr, err = cmd.Flags().GetString("arch"); err != nil {
+		return
+	}
+	if name, err = cmd.Flags().GetString("name"); err != nil {
+		return
+	}
+	if format, err = cmd.Flags().GetString("format"); err != nil {
+		return
+	}
+	if !slices.Contains([]string{"", "toml", "json"}, format) {
+		err = fmt.Errorf("unsupported format %q, supported formats: toml, json", format)
+	}
+	return
+}
+
+func cmdBlueprintFromHost(cmd *cobra.Command, args []string) error {
+	distroStr, archStr, name, format, err := systemBlueprintFlags(cmd)
+	if err != nil {
+		return err
+	}
+	if name == "" {
+		name = "from-host"
+	}
+	if archStr == "" {
+		archStr = getHostArch()
+	}
+	distroStr, err = findDistro(distroStr, "")
+	if err != nil {
+		return err
+	}
+	defaultPackages, err := systemDefaultPackages(cmd, distroStr, archStr)
+	if err != nil {
+		return err
+	}
+
+	// the host readers of check-host-config log every command and file
+	if verbose, _ := cmd.Flags().GetBool("verbose"); !verbose {
+		log.SetOutput(io.Discard)
+		defer log.SetOutput(os.Stderr)
+	}
+
+	tree := &inspectTree{mounts: map[string]string{"/": hostRoot}}
+	bp, err := blueprintFromTree(tree, defaultPackages)
+	if err != nil {
+		return err
+	}
+	cmdline, err := hostKernelCmdline()
+	if err != nil {
+		return err
+	}
+	bp.Customizations.Kernel = kernelAppend(cmdline)
+	devices, err := hostBlockDevices()
+	if err != nil {
+		return err
+	}
+	bp.Customizations.Filesystem = hostFilesystems(devices)
+
+	bp.Name = name
+	bp.Distro = distroStr
+	return writeBlueprint(cmd.OutOrStdout(), bp, format)
+}
+
+func cmdBlueprintFromImage(cmd *cobra.Command, args []string) error {
+	distroStr, archStr, name, format, err := systemBlueprintFlags(cmd)
+	if err != nil {
+		return err
+	}
+	imagePath := args[0]
+	if name == "" {
+		name = strings.TrimSuffix(filepath.Base(imagePath), filepath.Ext(imagePath))
+	}
+	cacheDir, err := cmd.Flags().GetString("cache")
+	if err != nil {
+		return err
+	}
+	if _, err := os.Stat(imagePath); err != nil {
+		return err
+	}
+	if err := os.MkdirAll(cacheDir, 0o755); err != nil {
+		return err
+	}
+	workDir, err := os.MkdirTemp(cacheDir, "from-image-")
+	if err != nil {
+		return err
+	}
+	defer os.RemoveAll(workDir)
+
+	var bp *blueprint.Blueprint
+	report, err := mountImage(imagePath, workDir, func(report *inspectReport, tree *inspectTree) error {
+		if distroStr == "" {
+			osRelease, err := tree.readFile("/etc/os-release")
+			if err != nil {
+				if osRelease, err = tree.readFile("/usr/lib/os-release"); err != nil {
+					return err
+				}
+			}
+			if distroStr, err = distro.DistroNameFromOSRelease(parseKeyValues(osRelease)); err != nil {
+				return fmt.Errorf("cannot get the distro name of the image: %w", err)
+			}
+		}
+		if archStr == "" {
+			pkgs, err := inspectPackages(tree)
+			if err != nil {
+				return err
+			}
+			if archStr = packagesArch(pkgs); archStr == "" {
+				archStr = getHostArch()
+			}
+		}
+		defaultPackages, err := systemDefaultPackages(cmd, distroStr, archStr)
+		if err != nil {
+			return err
+		}
+		if bp, err = blueprintFromTree(tree, defaultPackages); err != nil {
+			return err
+		}
+		if kernel := inspectTreeKernel(tree); kernel != nil {
+			bp.Customizations.Kernel = kernelAppend(kernel.Cmdline)
+		}
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+	if bp == nil {
+		return fmt.Errorf("cannot find the root filesystem of %s", imagePath)
+	}
+	bp.Customizations.Filesystem = imageFilesystems(report.PartitionTable)
+
+	bp.Name = name
+	bp.Distro = distroStr
+	return writeBlueprint(cmd.OutOrStdout(), bp, format)
+}
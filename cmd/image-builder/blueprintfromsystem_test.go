@@ -0,0 +1,293 @@
+package main_test
+
+import (
+	"bytes"
+	"io"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"testing"
+
+	"github.com/BurntSushi/toml"
+	"github.com/osbuild/blueprint/pkg/blueprint"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/osbuild/image-builder/cmd/check-host-config/check"
+	main "github.com/osbuild/image-builder/cmd/image-builder"
+	"github.com/osbuild/image-builder/internal/common"
+	"github.com/osbuild/image-builder/internal/testutil"
+	"github.com/osbuild/image-builder/pkg/depsolvednf"
+	"github.com/osbuild/image-builder/pkg/distro"
+	"github.com/osbuild/image-builder/pkg/manifestgen"
+	"github.com/osbuild/image-builder/pkg/rpmmd"
+	testrepos "github.com/osbuild/image-builder/test/data/repositories"
+)
+
+// fakeRpmScript lists the packages and their dependencies of the fake
+// system, tmux requires bash via /bin/sh
+const fakeRpmScript = `
+case "$*" in
+  *REQUIRENAME*)
+    printf 'bash\tP\tbash\nbash\tF\t/bin/sh\nbash\tF\t/usr/bin/bash\n'
+    printf 'chrony\tP\tchrony\nchrony\tR\trpmlib(CompressedFileNames)\n'
+    printf 'tmux\tP\ttmux\ntmux\tR\t/bin/sh\ntmux\tR\t(libevent if foo)\n'
+    printf 'vim-common\tP\tvim-common\nvim-common\tP\tvim-data\n'
+    printf 'vim-enhanced\tP\tvim-enhanced\nvim-enhanced\tR\tvim-data\n'
+    printf 'gpg-pubkey\tP\tgpg(CentOS)\n'
+    ;;
+  *)
+    printf 'bash\t0\t5.1.8\t9.el9\tx86_64\n'
+    printf 'chrony\t0\t4.5\t1.el9\tx86_64\n'
+    ;;
+esac
+`
+
+func writeTree(t *testing.T, root string, files map[string]string) {
+	for name, content := range files {
+		p := filepath.Join(root, name)
+		require.NoError(t, os.MkdirAll(filepath.Dir(p), 0755))
+		require.NoError(t, os.WriteFile(p, []byte(content), 0644))
+	}
+}
+
+func symlinkTree(t *testing.T, root string, links map[string]string) {
+	for name, target := range links {
+		p := filepath.Join(root, name)
+		require.NoError(t, os.MkdirAll(filepath.Dir(p), 0755))
+		require.NoError(t, os.Symlink(target, p))
+	}
+}
+
+func makeFakeSystem(t *testing.T) string {
+	root := t.TempDir()
+	require.NoError(t, os.MkdirAll(filepath.Join(root, "var/lib/rpm"), 0755))
+	writeTree(t, root, map[string]string{
+		"etc/hostname":                    "my-host\n",
+		"etc/passwd":                      "root:x:0:0:root:/root:/bin/bash\nalice:x:1000:1000::/home/alice:/bin/bash\nbob:x:1001:2000::/srv/bob:/bin/zsh\n",
+		"etc/group":                       "root:x:0:\nwheel:x:10:alice\nalice:x:1000:\nadmins:x:2000:\n",
+		"home/alice/.ssh/authorized_keys": "ssh-ed25519 AAAA alice@example\n",
+		"etc/locale.conf":                 "LANG=\"de_DE.UTF-8\"\n",
+		"etc/vconsole.conf":               "KEYMAP=de\n",
+		"etc/firewalld/firewalld.conf":    "DefaultZone=public\n",
+		"usr/lib/firewalld/zones/public.xml": `<?xml version="1.0" encoding="utf-8"?>
+<zone><short>Public</short><service name="ssh"/><service name="dhcpv6-client"/><service name="cockpit"/></zone>`,
+		"etc/firewalld/zones/public.xml": `<?xml version="1.0" encoding="utf-8"?>
+<zone><short>Public</short><service name="ssh"/><service name="dhcpv6-client"/><service name="http"/><port port="8080" protocol="tcp"/></zone>`,
+		"usr/lib/systemd/system-preset/90-default.preset":         "# defaults\nenable sshd.service\nenable chronyd.service\nenable getty@.service\n",
+		"usr/lib/systemd/system-preset/99-default-disable.preset": "disable *\n",
+		"usr/lib/systemd/system/sshd.service":                     "[Install]\nWantedBy=multi-user.target\n",
+		"usr/lib/systemd/system/chronyd.service":                  "[Install]\nWantedBy=multi-user.target\n",
+		"usr/lib/systemd/system/httpd.service":                    "[Install]\nWantedBy=multi-user.target\n",
+		"usr/lib/systemd/system/kdump.service":                    "[Install]\nWantedBy=multi-user.target\n",
+		"usr/lib/systemd/system/getty@.service":                   "[Install]\nWantedBy=getty.target\n",
+	})
+	symlinkTree(t, root, map[string]string{
+		"etc/localtime": "../usr/share/zoneinfo/Europe/Berlin",
+		"etc/systemd/system/multi-user.target.wants/sshd.service":  "/usr/lib/systemd/system/sshd.service",
+		"etc/systemd/system/multi-user.target.wants/httpd.service": "/usr/lib/systemd/system/httpd.service",
+		"etc/systemd/system/getty.target.wants/getty@tty1.service": "/usr/lib/systemd/system/getty@.service",
+		"etc/systemd/system/kdump.service":                         "/dev/null",
+	})
+	return root
+}
+
+var fakeSystemCustomizations = blueprint.Customizations{
+	Hostname: common.ToPtr("my-host"),
+	Kernel:   &blueprint.KernelCustomization{Append: "console=ttyS0 quiet"},
+	User: []blueprint.UserCustomization{
+		{
+			Name:   "alice",
+			Key:    common.ToPtr("ssh-ed25519 AAAA alice@example"),
+			Shell:  common.ToPtr("/bin/bash"),
+			Groups: []string{"wheel"},
+			UID:    common.ToPtr(1000),
+		},
+		{
+			Name:  "bob",
+			Home:  common.ToPtr("/srv/bob"),
+			Shell: common.ToPtr("/bin/zsh"),
+			UID:   common.ToPtr(1001),
+			GID:   common.ToPtr(2000),
+		},
+	},
+	Group: []blueprint.GroupCustomization{
+		{Name: "admins", GID: common.ToPtr(2000)},
+	},
+	Timezone: &blueprint.TimezoneCustomization{Timezone: common.ToPtr("Europe/Berlin")},
+	Locale: &blueprint.LocaleCustomization{
+		Languages: []string{"de_DE.UTF-8"},
+		Keyboard:  common.ToPtr("de"),
+	},
+	Firewall: &blueprint.FirewallCustomization{
+		Ports: []string{"8080:tcp"},
+		Services: &blueprint.FirewallServicesCustomization{
+			Enabled:  []string{"http"},
+			Disabled: []string{"cockpit"},
+		},
+	},
+	Services: &blueprint.ServicesCustomization{
+		Enabled:  []string{"httpd.service"},
+		Disabled: []string{"chronyd.service"},
+		Masked:   []string{"kdump.service"},
+	},
+}
+
+func runBlueprintFrom(t *testing.T, args ...string) (*blueprint.Blueprint, error) {
+	return runBlueprintFromWithDepsolve(t, fakeDepsolve, args...)
+}
+
+// runBlueprintFromWithDepsolve runs "blueprint" with the given
+// depsolver for the default package set of the image type
+func runBlueprintFromWithDepsolve(t *testing.T, depsolve manifestgen.DepsolveFunc, args ...string) (*blueprint.Blueprint, error) {
+	restore := main.MockNewRepoRegistry(testrepos.New)
+	defer restore()
+	restore = main.MockManifestgenDepsolver(depsolve)
+	defer restore()
+
+	var fakeStdout bytes.Buffer
+	restore = main.MockOsStdout(&fakeStdout)
+	defer restore()
+	restore = main.MockOsArgs(append([]string{"blueprint"}, args...))
+	defer restore()
+	if err := main.Run(); err != nil {
+		return nil, err
+	}
+	var bp blueprint.Blueprint
+	_, err := toml.Decode(fakeStdout.String(), &bp)
+	require.NoError(t, err)
+	return &bp, nil
+}
+
+func TestBlueprintFromHost(t *testing.T) {
+	testutil.MockCommand(t, "rpm", fakeRpmScript)
+	root := makeFakeSystem(t)
+	restore := main.MockHostRoot(root, "BOOT_IMAGE=(hd0,gpt3)/vmlinuz root=UUID=6bf-root ro console=ttyS0 quiet", []check.BlockDevice{
+		{
+			Name: "vda",
+			Type: "disk",
+			Size: 10 * 1024 * 1024 * 1024,
+			Children: []check.BlockDevice{
+				{Name: "vda1", Type: "part", Size: 200 * 1024 * 1024, FSType: common.ToPtr("vfat"), Mountpoints: []*string{common.ToPtr("/boot/efi")}},
+				{Name: "vda2", Type: "part", Size: 1024 * 1024 * 1024, FSType: common.ToPtr("xfs"), Mountpoints: []*string{common.ToPtr("/boot")}},
+				{Name: "vda3", Type: "part", Size: 8 * 1024 * 1024 * 1024, FSType: common.ToPtr("btrfs"), Mountpoints: []*string{common.ToPtr("/home"), common.ToPtr("/")}},
+				{Name: "vda4", Type: "part", Size: 512 * 1024 * 1024, FSType: common.ToPtr("swap"), Mountpoints: []*string{common.ToPtr("[SWAP]")}},
+			},
+		},
+	})
+	defer restore()
+
+	bp, err := runBlueprintFrom(t, "from-host", "--distro", "centos-9", "--arch", "x86_64")
+	require.NoError(t, err)
+	assert.Equal(t, "from-host", bp.Name)
+	assert.Equal(t, "centos-9", bp.Distro)
+	// chrony is part of the default packages of qcow2
+	assert.Equal(t, []blueprint.Package{{Name: "tmux"}, {Name: "vim-enhanced"}}, bp.Packages)
+
+	expected := fakeSystemCustomizations
+	expected.Filesystem = []blueprint.FilesystemCustomization{
+		{Mountpoint: "/", MinSize: 8 * 1024 * 1024 * 1024},
+		{Mountpoint: "/boot", MinSize: 1024 * 1024 * 1024},
+	}
+	assert.Equal(t, &expected, bp.Customizations)
+}
+
+func TestBlueprintFromHostImageType(t *testing.T) {
+	testutil.MockCommand(t, "rpm", fakeRpmScript)
+	restore := main.MockHostRoot(makeFakeSystem(t), "", nil)
+	defer restore()
+
+	// chrony is not part of the default packages of the minimal-raw
+	// image type
+	bp, err := runBlueprintFrom(t, "from-host", "--distro", "centos-9", "--arch", "x86_64", "--image-type", "minimal-raw", "--name", "my-host")
+	require.NoError(t, err)
+	assert.Equal(t, "my-host", bp.Name)
+	assert.Equal(t, []blueprint.Package{{Name: "chrony"}, {Name: "tmux"}, {Name: "vim-enhanced"}}, bp.Packages)
+	assert.Nil(t, bp.Customizations.Kernel)
+	assert.Nil(t, bp.Customizations.Filesystem)
+
+	_, err = runBlueprintFrom(t, "from-host", "--distro", "centos-9", "--format", "yaml")
+	assert.EqualError(t, err, `unsupported format "yaml", supported formats: toml, json`)
+}
+
+func TestBlueprintFromHostGroupMembers(t *testing.T) {
+	testutil.MockCommand(t, "rpm", fakeRpmScript)
+	restore := main.MockHostRoot(makeFakeSystem(t), "", nil)
+	defer restore()
+
+	// vim-enhanced is installed by the @core group of the default
+	// packages of qcow2, it is not part of the package set itself
+	var withCore bool
+	depsolve := func(solver *depsolvednf.Solver, cacheDir string, depsolveWarningsOutput io.Writer, packageSets map[string][]rpmmd.PackageSet, d distro.Distro, arch string) (map[string]depsolvednf.DepsolveResult, error) {
+		res, err := fakeDepsolve(solver, cacheDir, depsolveWarningsOutput, packageSets, d, arch)
+		if err != nil {
+			return nil, err
+		}
+		for _, set := range packageSets["os"] {
+			if slices.Contains(set.Include, "@core") {
+				withCore = true
+				os := res["os"]
+				os.Transactions[0] = append(os.Transactions[0], rpmmd.Package{Name: "vim-enhanced", Arch: arch})
+			}
+		}
+		return res, nil
+	}
+	bp, err := runBlueprintFromWithDepsolve(t, depsolve, "from-host", "--distro", "centos-9", "--arch", "x86_64")
+	require.NoError(t, err)
+	assert.True(t, withCore)
+	assert.Equal(t, []blueprint.Package{{Name: "tmux"}}, bp.Packages)
+}
+
+func TestBlueprintFromImageArch(t *testing.T) {
+	mockInspectTools(t, "qcow2")
+	// the first package is a multilib package, the arch of the image
+	// is the arch of rpm
+	testutil.MockCommand(t, "rpm", strings.Replace(fakeRpmScript, `    printf 'bash\t0\t5.1.8\t9.el9\tx86_64\n'`, `    printf 'glibc\t0\t2.34\t100.el9\ti686\n'
+    printf 'rpm\t0\t4.16.1.3\t29.el9\taarch64\n'`, 1))
+	imagePath := filepath.Join(t.TempDir(), "disk.qcow2")
+	require.NoError(t, os.WriteFile(imagePath, []byte("fake-img-qcow2"), 0644))
+
+	var depsolveArch string
+	depsolve := func(solver *depsolvednf.Solver, cacheDir string, depsolveWarningsOutput io.Writer, packageSets map[string][]rpmmd.PackageSet, d distro.Distro, arch string) (map[string]depsolvednf.DepsolveResult, error) {
+		depsolveArch = arch
+		return fakeDepsolve(solver, cacheDir, depsolveWarningsOutput, packageSets, d, arch)
+	}
+	_, err := runBlueprintFromWithDepsolve(t, depsolve, "from-image", "--cache", t.TempDir(), imagePath)
+	require.NoError(t, err)
+	assert.Equal(t, "aarch64", depsolveArch)
+}
+
+func TestBlueprintFromImage(t *testing.T) {
+	mocks := mockInspectTools(t, "qcow2")
+	testutil.MockCommand(t, "rpm", fakeRpmScript)
+	imagePath := filepath.Join(t.TempDir(), "disk.qcow2")
+	require.NoError(t, os.WriteFile(imagePath, []byte("fake-img-qcow2"), 0644))
+
+	bp, err := runBlueprintFrom(t, "from-image", "--cache", t.TempDir(), imagePath)
+	require.NoError(t, err)
+	assert.Equal(t, "disk", bp.Name)
+	// the distro and arch come from the image, without presets the
+	// enabled sshd.service is the default
+	assert.Equal(t, "centos-9", bp.Distro)
+	assert.Equal(t, []blueprint.Package{{Name: "tmux"}, {Name: "vim-enhanced"}}, bp.Packages)
+	assert.Equal(t, &blueprint.Customizations{
+		Hostname: common.ToPtr("my-host"),
+		Kernel:   &blueprint.KernelCustomization{Append: "console=ttyS0"},
+		User: []blueprint.UserCustomization{
+			{
+				Name:   "alice",
+				Shell:  common.ToPtr("/bin/bash"),
+				Groups: []string{"wheel"},
+				UID:    common.ToPtr(1000),
+			},
+		},
+		Filesystem: []blueprint.FilesystemCustomization{
+			{Mountpoint: "/", MinSize: 18460639 * 512},
+			{Mountpoint: "/boot", MinSize: 1024 * 1024 * 1024},
+		},
+	}, bp.Customizations)
+	// everything is cleaned up
+	assert.Len(t, mocks["umount"].CallArgsList(), 3)
+	assert.Equal(t, []string{"--detach", fakeLoopDev(mocks)}, mocks["losetup"].CallArgsList()[1])
+}
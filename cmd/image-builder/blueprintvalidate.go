@@ -0,0 +1,402 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"path"
+	"slices"
+	"strings"
+
+	"github.com/spf13/cobra"
+	"go.yaml.in/yaml/v3"
+
+	"github.com/osbuild/blueprint/pkg/blueprint"
+	"github.com/osbuild/image-builder/internal/blueprintload"
+	"github.com/osbuild/image-builder/pkg/distro"
+	"github.com/osbuild/image-builder/pkg/ostree"
+	"github.com/osbuild/image-builder/pkg/pathpolicy"
+	"github.com/osbuild/image-builder/pkg/policies"
+)
+
+// blueprintProblem is a single problem of a blueprint for an image type
+type blueprintProblem struct {
+	// Line is the line of the blueprint file that the problem is
+	// about, 0 if it is not known
+	Line int `json:"line,omitempty" yaml:"line,omitempty"`
+	// Path is the path to the option, e.g. "customizations.user[1].key"
+	Path    string `json:"path,omitempty" yaml:"path,omitempty"`
+	Message string `json:"message" yaml:"message"`
+	// Hint is a suggestion how to fix the problem
+	Hint string `json:"hint,omitempty" yaml:"hint,omitempty"`
+}
+
+type blueprintValidation struct {
+	Blueprint string             `json:"blueprint" yaml:"blueprint"`
+	Distro    string             `json:"distro" yaml:"distro"`
+	Arch      string             `json:"arch" yaml:"arch"`
+	Type      string             `json:"type" yaml:"type"`
+	Problems  []blueprintProblem `json:"problems" yaml:"problems"`
+}
+
+// blueprintValidator collects the problems of a blueprint for an image
+// type, all checks work offline (without repositories)
+type blueprintValidator struct {
+	imgType  distro.ImageType
+	bp       *blueprint.Blueprint
+	problems []blueprintProblem
+}
+
+func (v *blueprintValidator) add(path, message, hint string) {
+	v.problems = append(v.problems, blueprintProblem{Path: path, Message: message, Hint: hint})
+}
+
+// supportedBy returns the other image types of the same distro and
+// architecture that support the option at the given path
+func (v *blueprintValidator) supportedBy(optionPath string) []string {
+	var names []string
+	arch := v.imgType.Arch()
+	for _, name := range arch.ListImageTypes() {
+		if name == v.imgType.Name() {
+			continue
+		}
+		imgType, err := arch.GetImageType(name)
+		if err != nil {
+			continue
+		}
+		supported := true
+		for _, err := range distro.ValidateConfigAll(imgType, *v.bp) {
+			if err.Path() == optionPath && err.Message() == "not supported" {
+				supported = false
+			}
+		}
+		if supported {
+			names = append(names, name)
+		}
+	}
+	return names
+}
+
+func (v *blueprintValidator) checkOptions() {
+	for _, err := range distro.ValidateConfigAll(v.imgType, *v.bp) {
+		var hint string
+		switch err.Message() {
+		case "not supported":
+			if others := v.supportedBy(err.Path()); len(others) > 0 {
+				hint = fmt.Sprintf("remove it or use an image type that supports it: %s", strings.Join(others, ", "))
+			} else {
+				hint = fmt.Sprintf("remove it, no %s image type supports it", v.imgType.Arch().Distro().Name())
+			}
+		case "required":
+			hint = fmt.Sprintf("add it, %q images cannot be built without it", v.imgType.Name())
+		}
+		v.add(err.Path(), err.Message(), hint)
+	}
+}
+
+// checkPath checks the given path against a path policy
+func (v *blueprintValidator) checkPath(optionPath, fsPath string, policy *pathpolicy.PathPolicies, deniedHint string) {
+	err := policy.Check(fsPath)
+	switch {
+	case err == nil:
+		return
+	case !strings.HasPrefix(fsPath, "/"):
+		v.add(optionPath, err.Error(), "use an absolute path")
+	case fsPath != path.Clean(fsPath):
+		v.add(optionPath, err.Error(), fmt.Sprintf("use %q", path.Clean(fsPath)))
+	default:
+		v.add(optionPath, err.Error(), deniedHint)
+	}
+}
+
+func (v *blueprintValidator) checkPartitioning() {
+	const mountpointHint = "this directory must be on the root filesystem or cannot be a mountpoint, use a different mountpoint"
+
+	for idx, fs := range v.bp.Customizations.GetFilesystems() {
+		v.checkPath(fmt.Sprintf("customizations.filesystem[%d].mountpoint", idx), fs.Mountpoint, policies.MountpointPolicies, mountpointHint)
+	}
+
+	// GetPartitioning() stops at invalid partitioning, it is validated
+	// below
+	if v.bp.Customizations == nil || v.bp.Customizations.Disk == nil {
+		return
+	}
+	partitioning := v.bp.Customizations.Disk
+	if len(v.bp.Customizations.GetFilesystems()) > 0 {
+		v.add("customizations.disk", "cannot be used with customizations.filesystem", "move the mountpoints of customizations.filesystem to customizations.disk")
+	}
+	for pIdx, part := range partitioning.Partitions {
+		partPath := fmt.Sprintf("customizations.disk.partitions[%d]", pIdx)
+		if part.Mountpoint != "" {
+			v.checkPath(partPath+".mountpoint", part.Mountpoint, policies.MountpointPolicies, mountpointHint)
+		}
+		for lvIdx, lv := range part.LogicalVolumes {
+			if lv.Mountpoint != "" {
+				v.checkPath(fmt.Sprintf("%s.logical_volumes[%d].mountpoint", partPath, lvIdx), lv.Mountpoint, policies.MountpointPolicies, mountpointHint)
+			}
+		}
+		for svIdx, sv := range part.Subvolumes {
+			v.checkPath(fmt.Sprintf("%s.subvolumes[%d].mountpoint", partPath, svIdx), sv.Mountpoint, policies.MountpointPolicies, mountpointHint)
+		}
+	}
+	if err := partitioning.Validate(); err != nil {
+		// the partitioning errors are joined with newlines
+		msgs := strings.Split(err.Error(), "\n")
+		if len(msgs) > 1 {
+			msgs = msgs[1:]
+		}
+		for _, msg := range msgs {
+			v.add("customizations.disk", msg, "")
+		}
+	}
+	if err := partitioning.ValidateLayoutConstraints(); err != nil {
+		v.add("customizations.disk", err.Error(), "use a single LVM volume group or btrfs volume")
+	}
+}
+
+func (v *blueprintValidator) checkFilesAndDirectories() {
+	dirPolicy := policies.CustomDirectoriesPolicies
+	filePolicy := policies.CustomFilesPolicies
+	deniedHint := "this is a system directory that cannot be customized, use e.g. a path in /etc, /var or /usr/local"
+	if v.imgType.OSTreeRef() != "" {
+		dirPolicy = policies.OstreeCustomDirectoriesPolicies
+		filePolicy = policies.OstreeCustomFilesPolicies
+		deniedHint = "ostree based images can only customize paths in /etc (and files in /root and /usr/local/bin)"
+	}
+	for idx, dir := range v.bp.Customizations.GetDirectories() {
+		v.checkPath(fmt.Sprintf("customizations.directories[%d].path", idx), dir.Path, dirPolicy, deniedHint)
+	}
+	for idx, file := range v.bp.Customizations.GetFiles() {
+		v.checkPath(fmt.Sprintf("customizations.files[%d].path", idx), file.Path, filePolicy, deniedHint)
+	}
+	if err := blueprint.ValidateDirFileCustomizations(v.bp.Customizations.GetDirectories(), v.bp.Customizations.GetFiles()); err != nil {
+		v.add("customizations.files", err.Error(), "")
+	}
+}
+
+func (v *blueprintValidator) checkUsersAndGroups() {
+	if v.bp.Customizations == nil {
+		return
+	}
+	users := v.bp.Customizations.User
+	groups := v.bp.Customizations.Group
+
+	userNames := make(map[string]int)
+	uids := make(map[int]int)
+	for idx, user := range users {
+		userPath := fmt.Sprintf("customizations.user[%d]", idx)
+		if other, ok := userNames[user.Name]; ok {
+			v.add(userPath+".name", fmt.Sprintf("duplicate user name: %s", user.Name), fmt.Sprintf("merge it with customizations.user[%d]", other))
+		} else {
+			userNames[user.Name] = idx
+		}
+		if user.UID != nil {
+			if other, ok := uids[*user.UID]; ok {
+				v.add(userPath+".uid", fmt.Sprintf("duplicate user ID: %d", *user.UID), fmt.Sprintf("customizations.user[%d] (%s) has the same uid", other, users[other].Name))
+			} else {
+				uids[*user.UID] = idx
+			}
+		}
+	}
+
+	groupNames := make(map[string]int)
+	gids := make(map[int]int)
+	for idx, group := range groups {
+		groupPath := fmt.Sprintf("customizations.group[%d]", idx)
+		if other, ok := groupNames[group.Name]; ok {
+			v.add(groupPath+".name", fmt.Sprintf("duplicate group name: %s", group.Name), fmt.Sprintf("merge it with customizations.group[%d]", other))
+		} else {
+			groupNames[group.Name] = idx
+		}
+		if group.GID != nil {
+			if other, ok := gids[*group.GID]; ok {
+				v.add(groupPath+".gid", fmt.Sprintf("duplicate group ID: %d", *group.GID), fmt.Sprintf("customizations.group[%d] (%s) has the same gid", other, groups[other].Name))
+			} else {
+				gids[*group.GID] = idx
+			}
+		}
+	}
+
+	// the primary group of a user has the name of the user
+	for idx, user := range users {
+		groupIdx, ok := groupNames[user.Name]
+		if !ok || user.GID == nil || groups[groupIdx].GID == nil || *user.GID == *groups[groupIdx].GID {
+			continue
+		}
+		v.add(fmt.Sprintf("customizations.user[%d].gid", idx), fmt.Sprintf("gid %d differs from the gid %d of group %q", *user.GID, *groups[groupIdx].GID, user.Name), "use the same gid for the user and the group")
+	}
+
+	if installer, err := v.bp.Customizations.GetInstaller(); err == nil && installer != nil && installer.Kickstart != nil && installer.Kickstart.Contents != "" {
+		if len(users) > 0 || len(groups) > 0 {
+			v.add("customizations.installer.kickstart.contents", "cannot be used with customizations.user or customizations.group", "create the users and groups in the kickstart contents")
+		}
+	}
+}
+
+// checkManifest generates the manifest without repositories to catch
+// the problems that the checks above do not know about, e.g. the
+// partition table of the image type cannot be created
+func (v *blueprintValidator) checkManifest() {
+	var imgOpts distro.ImageOptions
+	if v.imgType.OSTreeRef() != "" {
+		// the ostree url is an option, not part of the blueprint
+		imgOpts.OSTree = &ostree.ImageOptions{
+			URL: "http://example.com/repo",
+		}
+	}
+	if _, _, err := v.imgType.Manifest(v.bp, imgOpts, nil, nil); err != nil {
+		msg := err.Error()
+		prefix := fmt.Sprintf("blueprint validation failed for image type %q: ", v.imgType.Name())
+		msg = strings.TrimPrefix(msg, prefix)
+		// most errors start with the path to the option
+		var optionPath string
+		if key, rest, ok := strings.Cut(msg, ": "); ok && strings.HasPrefix(key, "customizations.") && !strings.Contains(key, " ") {
+			optionPath, msg = key, rest
+		}
+		v.add(optionPath, msg, "")
+	}
+}
+
+func validateBlueprint(imgType distro.ImageType, bp *blueprint.Blueprint, lines map[string]int) []blueprintProblem {
+	v := &blueprintValidator{imgType: imgType, bp: bp}
+	v.checkOptions()
+	v.checkPartitioning()
+	v.checkFilesAndDirectories()
+	v.checkUsersAndGroups()
+	// only try the manifest when everything else is fine, it stops at
+	// the first error and would repeat the problems from above
+	if len(v.problems) == 0 {
+		v.checkManifest()
+	}
+
+	for idx := range v.problems {
+		v.problems[idx].Line = lineFor(lines, v.problems[idx].Path)
+	}
+	slices.SortStableFunc(v.problems, func(a, b blueprintProblem) int {
+		return a.Line - b.Line
+	})
+	return v.problems
+}
+
+// lineFor finds the line of the option or the closest parent option
+// that is in the blueprint file
+func lineFor(lines map[string]int, optionPath string) int {
+	for optionPath != "" {
+		if line, ok := lines[optionPath]; ok {
+			return line
+		}
+		idx := strings.LastIndexAny(optionPath, ".[")
+		if idx < 0 {
+			break
+		}
+		optionPath = optionPath[:idx]
+	}
+	return 0
+}
+
+func writeBlueprintValidationText(out io.Writer, res *blueprintValidation) error {
+	for _, p := range res.Problems {
+		pos := res.Blueprint
+		if p.Line > 0 {
+			pos = fmt.Sprintf("%s:%d", res.Blueprint, p.Line)
+		}
+		msg := p.Message
+		if p.Path != "" {
+			msg = p.Path + ": " + msg
+		}
+		if _, err := fmt.Fprintf(out, "%s: %s\n", pos, msg); err != nil {
+			return err
+		}
+		if p.Hint != "" {
+			if _, err := fmt.Fprintf(out, "    hint: %s\n", p.Hint); err != nil {
+				return err
+			}
+		}
+	}
+	if len(res.Problems) == 0 {
+		_, err := fmt.Fprintf(out, "%s: no problems found for %s %s (%s)\n", res.Blueprint, res.Distro, res.Type, res.Arch)
+		return err
+	}
+	return nil
+}
+
+func cmdBlueprintValidate(cmd *cobra.Command, args []string) error {
+	repoDir, err := cmd.Flags().GetString("force-repo-dir")
+	if err != nil {
+		return err
+	}
+	forceDefsDir, err := cmd.Flags().GetString("force-defs-dir")
+	if err != nil {
+		return err
+	}
+	distroStr, err := cmd.Flags().GetString("distro")
+	if err != nil {
+		return err
+	}
+	archStr, err := cmd.Flags().GetString("arch")
+	if err != nil {
+		return err
+	}
+	if archStr == "" {
+		archStr = getHostArch()
+	}
+	format, err := cmd.Flags().GetString("format")
+	if err != nil {
+		return err
+	}
+	if !slices.Contains([]string{"", "text", "yaml", "json"}, format) {
+		return fmt.Errorf("unsupported format %q, supported formats: text, yaml, json", format)
+	}
+
+	imgTypeStr, bpPath := args[0], args[1]
+	bp, err := blueprintload.Load(bpPath)
+	if err != nil {
+		return err
+	}
+	lines, err := blueprintload.KeyLines(bpPath)
+	if err != nil {
+		return err
+	}
+	distroStr, err = findDistro(distroStr, bp.Distro)
+	if err != nil {
+		return err
+	}
+	res, err := getOneImage(distroStr, imgTypeStr, archStr, &repoOptions{RepoDir: repoDir, ForceDefsDir: forceDefsDir})
+	if err != nil {
+		return err
+	}
+
+	validation := &blueprintValidation{
+		Blueprint: bpPath,
+		Distro:    res.ImgType.Arch().Distro().Name(),
+		Arch:      res.ImgType.Arch().Name(),
+		Type:      res.ImgType.Name(),
+		Problems:  validateBlueprint(res.ImgType, bp, lines),
+	}
+	if validation.Problems == nil {
+		validation.Problems = []blueprintProblem{}
+	}
+
+	out := cmd.OutOrStdout()
+	switch format {
+	case "", "text":
+		err = writeBlueprintValidationText(out, validation)
+	case "yaml":
+		var output []byte
+		if output, err = yaml.Marshal(validation); err == nil {
+			_, err = out.Write(output)
+		}
+	case "json":
+		enc := json.NewEncoder(out)
+		enc.SetIndent("", "  ")
+		err = enc.Encode(validation)
+	}
+	if err != nil {
+		return err
+	}
+
+	if n := len(validation.Problems); n > 0 {
+		return fmt.Errorf("found %d problem(s) in %s for %s %s (%s)", n, bpPath, validation.Distro, validation.Type, validation.Arch)
+	}
+	return nil
+}
@@ -0,0 +1,142 @@
+package main_test
+
+import (
+	"bytes"
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	main "github.com/osbuild/image-builder/cmd/image-builder"
+	testrepos "github.com/osbuild/image-builder/test/data/repositories"
+)
+
+func runBlueprintValidate(t *testing.T, args ...string) (string, error) {
+	restore := main.MockNewRepoRegistry(testrepos.New)
+	defer restore()
+
+	var fakeStdout bytes.Buffer
+	restore = main.MockOsStdout(&fakeStdout)
+	defer restore()
+	restore = main.MockOsArgs(append([]string{"blueprint", "validate", "--distro", "centos-9", "--arch", "x86_64"}, args...))
+	defer restore()
+	err := main.Run()
+	return fakeStdout.String(), err
+}
+
+func writeBlueprintFile(t *testing.T, name, content string) string {
+	path := filepath.Join(t.TempDir(), name)
+	require.NoError(t, os.WriteFile(path, []byte(content), 0644))
+	return path
+}
+
+var problematicBlueprintTOML = `name = "problematic"
+
+[customizations.installer.kickstart]
+contents = "text"
+
+[[customizations.user]]
+name = "alice"
+uid = 1000
+
+[[customizations.user]]
+name = "alice"
+uid = 1000
+
+[[customizations.filesystem]]
+mountpoint = "/etc"
+minsize = "1 GiB"
+
+[[customizations.directories]]
+path = "/usr/lib/foo"
+`
+
+func TestBlueprintValidateProblems(t *testing.T) {
+	bpPath := writeBlueprintFile(t, "bp.toml", problematicBlueprintTOML)
+
+	output, err := runBlueprintValidate(t, "qcow2", bpPath)
+	assert.EqualError(t, err, "found 6 problem(s) in "+bpPath+" for centos-9 qcow2 (x86_64)")
+	assert.Equal(t, bpPath+`:3: customizations.installer: not supported
+    hint: remove it or use an image type that supports it: edge-installer, image-installer
+`+bpPath+`:4: customizations.installer.kickstart.contents: cannot be used with customizations.user or customizations.group
+    hint: create the users and groups in the kickstart contents
+`+bpPath+`:11: customizations.user[1].name: duplicate user name: alice
+    hint: merge it with customizations.user[0]
+`+bpPath+`:12: customizations.user[1].uid: duplicate user ID: 1000
+    hint: customizations.user[0] (alice) has the same uid
+`+bpPath+`:15: customizations.filesystem[0].mountpoint: path "/etc" is not allowed
+    hint: this directory must be on the root filesystem or cannot be a mountpoint, use a different mountpoint
+`+bpPath+`:19: customizations.directories[0].path: path "/usr/lib/foo" is not allowed
+    hint: this is a system directory that cannot be customized, use e.g. a path in /etc, /var or /usr/local
+`, output)
+}
+
+var problematicBlueprintJSON = `{
+  "customizations": {
+    "disk": {
+      "partitions": [
+        {
+          "type": "plain",
+          "mountpoint": "/data",
+          "fs_type": "ext4",
+          "minsize": 1073741824
+        },
+        {
+          "type": "plain",
+          "mountpoint": "/data/",
+          "fs_type": "ext4",
+          "minsize": 1073741824
+        }
+      ]
+    }
+  }
+}`
+
+func TestBlueprintValidateJSON(t *testing.T) {
+	bpPath := writeBlueprintFile(t, "bp.json", problematicBlueprintJSON)
+
+	output, err := runBlueprintValidate(t, "qcow2", bpPath, "--format", "json")
+	assert.ErrorContains(t, err, "found 2 problem(s) in "+bpPath)
+
+	var res struct {
+		Blueprint string
+		Distro    string
+		Type      string
+		Problems  []struct {
+			Line    int
+			Path    string
+			Message string
+			Hint    string
+		}
+	}
+	require.NoError(t, json.Unmarshal([]byte(output), &res))
+	assert.Equal(t, bpPath, res.Blueprint)
+	assert.Equal(t, "centos-9", res.Distro)
+	assert.Equal(t, "qcow2", res.Type)
+	require.Len(t, res.Problems, 2)
+	assert.Equal(t, 3, res.Problems[0].Line)
+	assert.Equal(t, "customizations.disk", res.Problems[0].Path)
+	assert.Contains(t, res.Problems[0].Message, `"/data/"`)
+	assert.Equal(t, 13, res.Problems[1].Line)
+	assert.Equal(t, "customizations.disk.partitions[1].mountpoint", res.Problems[1].Path)
+	assert.Equal(t, `path "/data/" must be canonical`, res.Problems[1].Message)
+	assert.Equal(t, `use "/data"`, res.Problems[1].Hint)
+}
+
+func TestBlueprintValidateNoProblems(t *testing.T) {
+	bpPath := makeTestBlueprint(t, testBlueprint)
+
+	output, err := runBlueprintValidate(t, "qcow2", bpPath)
+	assert.NoError(t, err)
+	assert.Equal(t, bpPath+": no problems found for centos-9 qcow2 (x86_64)\n", output)
+
+	output, err = runBlueprintValidate(t, "qcow2", bpPath, "--format", "yaml")
+	assert.NoError(t, err)
+	assert.Contains(t, output, "problems: []\n")
+
+	_, err = runBlueprintValidate(t, "qcow2", bpPath, "--format", "toml")
+	assert.EqualError(t, err, `unsupported format "toml", supported formats: text, yaml, json`)
+}
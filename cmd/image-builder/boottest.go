@@ -0,0 +1,550 @@
+package main
+
+import (
+	"bytes"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net"
+	"net/http"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/spf13/cobra"
+
+	"github.com/osbuild/blueprint/pkg/blueprint"
+	"github.com/osbuild/image-builder/internal/blueprintload"
+	"github.com/osbuild/image-builder/internal/buildconfig"
+	"github.com/osbuild/image-builder/pkg/arch"
+	"github.com/osbuild/image-builder/pkg/imagefilter"
+	"github.com/osbuild/image-builder/pkg/platform"
+)
+
+// bootTestSSHInterval is the time between two attempts to connect to
+// the booted image, mocked in tests
+var bootTestSSHInterval = 5 * time.Second
+
+// bootTestUser is created via cloud-init, images without cloud-init
+// get the key for root via a systemd credential instead
+const bootTestUser = "osbuild"
+
+// bootTestSerialLogLines is the number of lines of the serial console
+// that are shown when the boot test fails
+const bootTestSerialLogLines = 50
+
+// uefiFirmware is a UEFI firmware that is split into the (read-only)
+// code and a template of the variable store or, when Vars is empty, a
+// combined image that is loaded with -bios
+type uefiFirmware struct {
+	Code string
+	Vars string
+}
+
+// firmwarePaths are the UEFI firmware locations of the various distros
+var firmwarePaths = map[string][]uefiFirmware{
+	"x86_64": {
+		{"/usr/share/edk2/ovmf/OVMF_CODE.fd", "/usr/share/edk2/ovmf/OVMF_VARS.fd"}, // Fedora
+		{"/usr/share/OVMF/OVMF_CODE.fd", "/usr/share/OVMF/OVMF_VARS.fd"},           // Debian
+		{Code: "/usr/share/ovmf/OVMF.fd"},
+	},
+	"aarch64": {
+		{"/usr/share/edk2/aarch64/QEMU_EFI-pflash.raw", "/usr/share/edk2/aarch64/vars-template-pflash.raw"}, // Fedora
+		{"/usr/share/AAVMF/AAVMF_CODE.fd", "/usr/share/AAVMF/AAVMF_VARS.fd"},                                // Debian
+		{Code: "/usr/share/edk2/aarch64/QEMU_EFI.fd"},
+	},
+}
+
+var sshNonInteractiveArgs = []string{
+	"-o", "UserKnownHostsFile=/dev/null",
+	"-o", "StrictHostKeyChecking=no",
+	"-o", "LogLevel=ERROR",
+	// only ever use the key of the boot test
+	"-o", "IdentitiesOnly=yes",
+	"-o", "ConnectTimeout=10",
+}
+
+func findFirmware(archStr string) (*uefiFirmware, error) {
+	var tried []string
+	for _, fw := range firmwarePaths[archStr] {
+		paths := []string{fw.Code}
+		if fw.Vars != "" {
+			paths = append(paths, fw.Vars)
+		}
+		found := true
+		for _, p := range paths {
+			if _, err := os.Stat(p); err != nil {
+				found = false
+			}
+		}
+		if found {
+			return &fw, nil
+		}
+		tried = append(tried, paths...)
+	}
+	return nil, fmt.Errorf("cannot find UEFI firmware for %s, tried: %s", archStr, strings.Join(tried, ", "))
+}
+
+// firmwareArgs returns the QEMU arguments for the firmware. The code
+// of a split firmware is used read-only via pflash, the variables are
+// copied into workDir so that every boot starts with a fresh writable
+// variable store.
+func firmwareArgs(fw *uefiFirmware, workDir string) ([]string, error) {
+	if fw.Vars == "" {
+		return []string{"-bios", fw.Code}, nil
+	}
+	vars, err := os.ReadFile(fw.Vars)
+	if err != nil {
+		return nil, err
+	}
+	varsPath := filepath.Join(workDir, filepath.Base(fw.Vars))
+	if err := os.WriteFile(varsPath, vars, 0o600); err != nil {
+		return nil, err
+	}
+	return []string{
+		"-drive", fmt.Sprintf("if=pflash,format=raw,unit=0,readonly=on,file=%s", fw.Code),
+		"-drive", fmt.Sprintf("if=pflash,format=raw,unit=1,file=%s", varsPath),
+	}, nil
+}
+
+// imageFromFilename splits the default output name of a build
+// "<distro>-<image-type>-<arch>.<ext>" into its components
+func imageFromFilename(imagePath string) (distroName, imgType, archStr string, err error) {
+	name := strings.Split(filepath.Base(imagePath), ".")[0]
+	frags := strings.Split(name, "-")
+	if len(frags) < 4 {
+		return "", "", "", fmt.Errorf("cannot detect the image type from %q, use --distro and --type", imagePath)
+	}
+	if _, err := arch.FromString(frags[len(frags)-1]); err != nil {
+		return "", "", "", fmt.Errorf("cannot detect the image type from %q, use --distro and --type", imagePath)
+	}
+	return strings.Join(frags[:2], "-"), strings.Join(frags[2:len(frags)-1], "-"), frags[len(frags)-1], nil
+}
+
+// bootTestConfig returns the build config for check-host-config, it is
+// either given directly or made from the blueprint of the build
+func bootTestConfig(configPath string, bp *blueprint.Blueprint, name string) ([]byte, error) {
+	if configPath != "" {
+		if _, err := buildconfig.New(configPath, nil); err != nil {
+			return nil, err
+		}
+		return os.ReadFile(configPath)
+	}
+	return json.Marshal(&buildconfig.BuildConfig{Name: name, Blueprint: bp})
+}
+
+// cloudInitUserData creates the boot test user with the given key
+func cloudInitUserData(pubKey string) string {
+	return fmt.Sprintf(`#cloud-config
+users:
+  - name: root
+    ssh_authorized_keys:
+      - %[2]s
+  - name: %[1]s
+    groups: [wheel]
+    sudo: ALL=(ALL) NOPASSWD:ALL
+    ssh_authorized_keys:
+      - %[2]s
+`, bootTestUser, pubKey)
+}
+
+// startSeedServer serves a cloud-init NoCloud seed on the host, the
+// guest reaches it via the gateway of the QEMU user network
+func startSeedServer(pubKey string) (url string, stop func(), err error) {
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		return "", nil, err
+	}
+	mux := http.NewServeMux()
+	mux.HandleFunc("/meta-data", func(w http.ResponseWriter, r *http.Request) {
+		fmt.Fprint(w, "instance-id: image-builder-boot-test\n")
+	})
+	mux.HandleFunc("/user-data", func(w http.ResponseWriter, r *http.Request) {
+		fmt.Fprint(w, cloudInitUserData(pubKey))
+	})
+	mux.HandleFunc("/vendor-data", func(w http.ResponseWriter, r *http.Request) {})
+	srv := &http.Server{Handler: mux, ReadHeaderTimeout: 10 * time.Second}
+	go func() {
+		_ = srv.Serve(l)
+	}()
+	port := l.Addr().(*net.TCPAddr).Port
+	return fmt.Sprintf("http://10.0.2.2:%d/", port), func() { _ = srv.Close() }, nil
+}
+
+func freePort() (int, error) {
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		return 0, err
+	}
+	defer l.Close()
+	return l.Addr().(*net.TCPAddr).Port, nil
+}
+
+type qemuOptions struct {
+	Arch      string
+	BootMode  platform.BootMode
+	ImagePath string
+	Format    string
+	SSHPort   int
+	SerialLog string
+	SeedURL   string
+	PubKey    string
+	Memory    string
+	// WorkDir gets the writable copy of the UEFI variables
+	WorkDir string
+}
+
+// qemuCommand returns the QEMU command line to boot the image, the
+// image itself is never modified (snapshot=on). KVM is used when
+// available, otherwise QEMU falls back to TCG.
+func qemuCommand(opts *qemuOptions) ([]string, error) {
+	var args []string
+	switch opts.Arch {
+	case "x86_64":
+		args = []string{"qemu-system-x86_64", "-machine", "q35"}
+	case "aarch64":
+		args = []string{"qemu-system-aarch64", "-machine", "virt"}
+	default:
+		return nil, fmt.Errorf("boot-test is not supported for %s images", opts.Arch)
+	}
+	if opts.Arch == arch.Current().String() {
+		args = append(args, "-accel", "kvm")
+	}
+	args = append(args, "-accel", "tcg", "-cpu", "max", "-smp", "2", "-m", opts.Memory)
+
+	switch opts.BootMode {
+	case platform.BOOT_NONE:
+		return nil, fmt.Errorf("image is not bootable")
+	case platform.BOOT_LEGACY:
+		if opts.Arch != "x86_64" {
+			return nil, fmt.Errorf("cannot boot %s images in legacy BIOS mode", opts.Arch)
+		}
+	default:
+		// hybrid images boot with UEFI on x86_64 if the firmware is
+		// available and everything else needs UEFI
+		firmware, err := findFirmware(opts.Arch)
+		if err != nil && (opts.BootMode == platform.BOOT_UEFI || opts.Arch != "x86_64") {
+			return nil, err
+		}
+		if err == nil {
+			fwArgs, err := firmwareArgs(firmware, opts.WorkDir)
+			if err != nil {
+				return nil, err
+			}
+			args = append(args, fwArgs...)
+		}
+	}
+
+	format := opts.Format
+	if format == "vhd" {
+		format = "vpc"
+	}
+	args = append(args,
+		"-drive", fmt.Sprintf("file=%s,if=none,id=disk0,format=%s,snapshot=on", opts.ImagePath, format),
+		"-device", "virtio-scsi-pci,id=scsi",
+		"-device", "scsi-hd,drive=disk0",
+		"-netdev", fmt.Sprintf("user,id=net0,hostfwd=tcp:127.0.0.1:%d-:22", opts.SSHPort),
+		"-device", "virtio-net-pci,netdev=net0",
+		// make sure ssh key generation does not block on entropy
+		"-object", "rng-random,filename=/dev/urandom,id=rng0",
+		"-device", "virtio-rng-pci,rng=rng0",
+		"-display", "none",
+		"-monitor", "none",
+		"-serial", "file:"+opts.SerialLog,
+		// cloud-init NoCloud seed
+		"-smbios", fmt.Sprintf("type=1,serial=ds=nocloud;s=%s", opts.SeedURL),
+		// key for root for images without cloud-init, see
+		// systemd.system-credentials(7)
+		"-smbios", fmt.Sprintf("type=11,value=io.systemd.credential.binary:ssh.authorized_keys.root=%s", base64.StdEncoding.EncodeToString([]byte(opts.PubKey))),
+	)
+	return args, nil
+}
+
+// sshTarget is a booted image that is reachable via ssh
+type sshTarget struct {
+	port int
+	key  string
+	user string
+}
+
+func (t *sshTarget) sshArgs(command ...string) []string {
+	args := append([]string{"-p", fmt.Sprintf("%d", t.port), "-i", t.key}, sshNonInteractiveArgs...)
+	args = append(args, fmt.Sprintf("%s@127.0.0.1", t.user))
+	return append(args, command...)
+}
+
+func (t *sshTarget) copy(src, dst string) error {
+	args := append([]string{"-P", fmt.Sprintf("%d", t.port), "-i", t.key}, sshNonInteractiveArgs...)
+	args = append(args, src, fmt.Sprintf("%s@127.0.0.1:%s", t.user, dst))
+	return runCommand("scp", args...)
+}
+
+// waitForSSH waits until the image can be reached with either the
+// boot test user (cloud-init) or root (systemd credential)
+func waitForSSH(port int, key string, timeout time.Duration, qemuDone <-chan error) (*sshTarget, error) {
+	deadline := time.Now().Add(timeout)
+	for {
+		for _, user := range []string{bootTestUser, "root"} {
+			target := &sshTarget{port: port, key: key, user: user}
+			if err := exec.Command("ssh", target.sshArgs("true")...).Run(); err == nil {
+				return target, nil
+			}
+		}
+		if time.Now().After(deadline) {
+			return nil, fmt.Errorf("cannot connect via ssh within %v", timeout)
+		}
+		select {
+		case err := <-qemuDone:
+			return nil, fmt.Errorf("qemu exited before the image was reachable: %v", err)
+		case <-time.After(bootTestSSHInterval):
+		}
+	}
+}
+
+func serialLogTail(path string, n int) string {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return ""
+	}
+	lines := strings.Split(strings.TrimRight(string(data), "\n"), "\n")
+	if len(lines) > n {
+		lines = lines[len(lines)-n:]
+	}
+	return strings.Join(lines, "\n")
+}
+
+func bootTestImage(cmd *cobra.Command, imagePath string) (*imagefilter.Result, *blueprint.Blueprint, error) {
+	repoDir, err := cmd.Flags().GetString("force-repo-dir")
+	if err != nil {
+		return nil, nil, err
+	}
+	forceDefsDir, err := cmd.Flags().GetString("force-defs-dir")
+	if err != nil {
+		return nil, nil, err
+	}
+	distroStr, err := cmd.Flags().GetString("distro")
+	if err != nil {
+		return nil, nil, err
+	}
+	imgTypeStr, err := cmd.Flags().GetString("type")
+	if err != nil {
+		return nil, nil, err
+	}
+	archStr, err := cmd.Flags().GetString("arch")
+	if err != nil {
+		return nil, nil, err
+	}
+	blueprintPath, err := cmd.Flags().GetString("blueprint")
+	if err != nil {
+		return nil, nil, err
+	}
+	var bp *blueprint.Blueprint
+	if blueprintPath != "" {
+		if bp, err = blueprintload.Load(blueprintPath); err != nil {
+			return nil, nil, err
+		}
+	}
+
+	if imgTypeStr == "" {
+		var fnDistro, fnArch string
+		fnDistro, imgTypeStr, fnArch, err = imageFromFilename(imagePath)
+		if err != nil {
+			return nil, nil, err
+		}
+		if distroStr == "" {
+			distroStr = fnDistro
+		}
+		if archStr == "" {
+			archStr = fnArch
+		}
+	}
+	if archStr == "" {
+		archStr = arch.Current().String()
+	}
+	var bpDistroName string
+	if bp != nil {
+		bpDistroName = bp.Distro
+	}
+	distroStr, err = findDistro(distroStr, bpDistroName)
+	if err != nil {
+		return nil, nil, err
+	}
+	img, err := getOneImage(distroStr, imgTypeStr, archStr, &repoOptions{RepoDir: repoDir, ForceDefsDir: forceDefsDir})
+	if err != nil {
+		return nil, nil, err
+	}
+	return img, bp, nil
+}
+
+func cmdBootTest(cmd *cobra.Command, args []string) error {
+	imagePath := args[0]
+	configPath, err := cmd.Flags().GetString("config")
+	if err != nil {
+		return err
+	}
+	checkHostConfig, err := cmd.Flags().GetString("check-host-config")
+	if err != nil {
+		return err
+	}
+	sshKey, err := cmd.Flags().GetString("ssh-key")
+	if err != nil {
+		return err
+	}
+	timeout, err := cmd.Flags().GetDuration("timeout")
+	if err != nil {
+		return err
+	}
+	memory, err := cmd.Flags().GetString("memory")
+	if err != nil {
+		return err
+	}
+	serialLog, err := cmd.Flags().GetString("serial-log")
+	if err != nil {
+		return err
+	}
+	if configPath != "" && cmd.Flags().Changed("blueprint") {
+		return fmt.Errorf("cannot use --config with --blueprint")
+	}
+	if _, err := os.Stat(imagePath); err != nil {
+		return err
+	}
+	if strings.HasSuffix(imagePath, ".xz") || strings.HasSuffix(imagePath, ".zst") || strings.HasSuffix(imagePath, ".gz") {
+		return fmt.Errorf("cannot boot compressed image %s, decompress it first", imagePath)
+	}
+	if checkHostConfig == "" {
+		if checkHostConfig, err = exec.LookPath("check-host-config"); err != nil {
+			return fmt.Errorf("cannot find check-host-config, build it with \"go build ./cmd/check-host-config\" and use --check-host-config")
+		}
+	}
+
+	img, bp, err := bootTestImage(cmd, imagePath)
+	if err != nil {
+		return err
+	}
+	format, err := detectImageFormat(imagePath)
+	if err != nil {
+		return err
+	}
+	if format == "iso" {
+		return fmt.Errorf("boot-test of installer images is not supported yet")
+	}
+
+	workDir, err := os.MkdirTemp("", "image-builder-boot-test-")
+	if err != nil {
+		return err
+	}
+	defer os.RemoveAll(workDir)
+
+	config, err := bootTestConfig(configPath, bp, basenameFor(img, ""))
+	if err != nil {
+		return err
+	}
+	configFile := filepath.Join(workDir, "config.json")
+	if err := os.WriteFile(configFile, config, 0o600); err != nil {
+		return err
+	}
+
+	if sshKey == "" {
+		sshKey = filepath.Join(workDir, "id_ed25519")
+		if err := runCommand("ssh-keygen", "-q", "-t", "ed25519", "-N", "", "-f", sshKey); err != nil {
+			return err
+		}
+	}
+	pubKey, err := os.ReadFile(sshKey + ".pub")
+	if err != nil {
+		return fmt.Errorf("cannot read public ssh key: %w", err)
+	}
+
+	seedURL, stopSeed, err := startSeedServer(strings.TrimSpace(string(pubKey)))
+	if err != nil {
+		return err
+	}
+	defer stopSeed()
+	sshPort, err := freePort()
+	if err != nil {
+		return err
+	}
+	if serialLog == "" {
+		dir, base := filepath.Split(imagePath)
+		serialLog = filepath.Join(dir, strings.Split(base, ".")[0]+".serial.log")
+	}
+	qemuArgs, err := qemuCommand(&qemuOptions{
+		Arch:      img.ImgType.Arch().Name(),
+		BootMode:  img.ImgType.BootMode(),
+		ImagePath: imagePath,
+		Format:    format,
+		SSHPort:   sshPort,
+		SerialLog: serialLog,
+		SeedURL:   seedURL,
+		PubKey:    strings.TrimSpace(string(pubKey)),
+		Memory:    memory,
+		WorkDir:   workDir,
+	})
+	if err != nil {
+		return err
+	}
+
+	fmt.Fprintf(osStdout, "Booting %s (%s)\n", imagePath, img.ImgType.BootMode())
+	var qemuStderr bytes.Buffer
+	qemu := exec.Command(qemuArgs[0], qemuArgs[1:]...)
+	qemu.Stderr = &qemuStderr
+	if err := qemu.Start(); err != nil {
+		return fmt.Errorf("cannot start qemu: %w", err)
+	}
+	qemuDone := make(chan error, 1)
+	go func() {
+		err := qemu.Wait()
+		if err != nil && qemuStderr.Len() > 0 {
+			err = fmt.Errorf("%w\n%s", err, qemuStderr.String())
+		}
+		qemuDone <- err
+	}()
+	defer func() {
+		_ = qemu.Process.Kill()
+		<-qemuDone
+	}()
+
+	testErr := runBootTest(sshPort, sshKey, timeout, qemuDone, checkHostConfig, configFile)
+	fmt.Fprintf(osStdout, "Serial console log: %s\n", serialLog)
+	if testErr != nil {
+		if tail := serialLogTail(serialLog, bootTestSerialLogLines); tail != "" {
+			fmt.Fprintf(osStderr, "Last lines of the serial console:\n%s\n", tail)
+		}
+		return fmt.Errorf("boot test failed: %w", testErr)
+	}
+	fmt.Fprintf(osStdout, "Boot test passed\n")
+	return nil
+}
+
+func runBootTest(sshPort int, sshKey string, timeout time.Duration, qemuDone <-chan error, checkHostConfig, configFile string) error {
+	start := time.Now()
+	target, err := waitForSSH(sshPort, sshKey, timeout, qemuDone)
+	if err != nil {
+		return err
+	}
+	fmt.Fprintf(osStdout, "Connected as %s after %v\n", target.user, time.Since(start).Round(time.Second))
+	if err := target.copy(checkHostConfig, "/tmp/check-host-config"); err != nil {
+		return err
+	}
+	if err := target.copy(configFile, "/tmp/config.json"); err != nil {
+		return err
+	}
+	remaining := time.Until(start.Add(timeout))
+	if remaining < time.Minute {
+		remaining = time.Minute
+	}
+	check := exec.Command("ssh", target.sshArgs("/tmp/check-host-config", "-wait-timeout", remaining.Round(time.Second).String(), "/tmp/config.json")...)
+	check.Stdout = osStdout
+	check.Stderr = osStderr
+	if err := check.Run(); err != nil {
+		var exitErr *exec.ExitError
+		if errors.As(err, &exitErr) {
+			return fmt.Errorf("check-host-config failed with exit code %d", exitErr.ExitCode())
+		}
+		return err
+	}
+	return nil
+}
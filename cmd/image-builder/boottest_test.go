@@ -0,0 +1,194 @@
+package main_test
+
+import (
+	"bytes"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	main "github.com/osbuild/image-builder/cmd/image-builder"
+	"github.com/osbuild/image-builder/internal/testutil"
+	testrepos "github.com/osbuild/image-builder/test/data/repositories"
+)
+
+// fakeQemuScript writes a boot log to the serial console file and
+// waits to be killed like a running VM
+const fakeQemuScript = `
+for arg in "$@"; do
+  case "$arg" in
+    file:*) log="${arg#file:}";;
+  esac
+done
+printf 'Booting Linux\nCentOS Stream 9\nlocalhost login:\n' > "$log"
+exec sleep 600
+`
+
+// fakeScpScript keeps a copy of the uploaded build config
+const fakeScpScript = `
+case "${@: -1}" in
+  *:/tmp/config.json) cp "${@: -2:1}" "$(dirname "$0")/config.json";;
+esac
+`
+
+func mockBootTestTools(t *testing.T, checkExitCode int) map[string]*testutil.MockCmd {
+	return map[string]*testutil.MockCmd{
+		"qemu-img":           testutil.MockCommand(t, "qemu-img", `echo '{"format": "qcow2"}'`),
+		"qemu-system-x86_64": testutil.MockCommand(t, "qemu-system-x86_64", fakeQemuScript),
+		"ssh-keygen":         testutil.MockCommand(t, "ssh-keygen", `echo private > "${@: -1}"; echo "ssh-ed25519 AAAAfake" > "${@: -1}.pub"`),
+		"scp":                testutil.MockCommand(t, "scp", fakeScpScript),
+		"ssh": testutil.MockCommand(t, "ssh", `
+if [ "${@: -1}" = "/tmp/config.json" ]; then
+  echo "check-host-config output"
+  exit `+fmt.Sprintf("%d", checkExitCode)+`
+fi
+`),
+	}
+}
+
+func runBootTest(t *testing.T, args ...string) (string, string, error) {
+	restore := main.MockNewRepoRegistry(testrepos.New)
+	defer restore()
+	restore = main.MockBootTestSSHInterval(10 * time.Millisecond)
+	defer restore()
+
+	var fakeStdout, fakeStderr bytes.Buffer
+	restore = main.MockOsStdout(&fakeStdout)
+	defer restore()
+	restore = main.MockOsStderr(&fakeStderr)
+	defer restore()
+	restore = main.MockOsArgs(append([]string{"boot-test"}, args...))
+	defer restore()
+	err := main.Run()
+	return fakeStdout.String(), fakeStderr.String(), err
+}
+
+func makeBootTestImage(t *testing.T) (imagePath, checkHostConfig string) {
+	tmpdir := t.TempDir()
+	imagePath = filepath.Join(tmpdir, "centos-9-qcow2-x86_64.qcow2")
+	require.NoError(t, os.WriteFile(imagePath, []byte("fake-img"), 0644))
+	checkHostConfig = filepath.Join(tmpdir, "check-host-config")
+	require.NoError(t, os.WriteFile(checkHostConfig, []byte("fake-binary"), 0755))
+	return imagePath, checkHostConfig
+}
+
+func TestBootTestPasses(t *testing.T) {
+	mocks := mockBootTestTools(t, 0)
+	imagePath, checkHostConfig := makeBootTestImage(t)
+	bpPath := makeTestBlueprint(t, testBlueprint)
+
+	stdout, _, err := runBootTest(t, imagePath, "--check-host-config", checkHostConfig, "--blueprint", bpPath)
+	require.NoError(t, err)
+
+	serialLog := filepath.Join(filepath.Dir(imagePath), "centos-9-qcow2-x86_64.serial.log")
+	assert.Contains(t, stdout, "Booting "+imagePath)
+	assert.Contains(t, stdout, "Connected as osbuild after")
+	assert.Contains(t, stdout, "check-host-config output\n")
+	assert.Contains(t, stdout, "Serial console log: "+serialLog+"\n")
+	assert.Contains(t, stdout, "Boot test passed\n")
+	assert.FileExists(t, serialLog)
+
+	// the image is booted as a snapshot with the key injected
+	qemuArgs := strings.Join(mocks["qemu-system-x86_64"].CallArgsList()[0], " ")
+	assert.Contains(t, qemuArgs, "-drive file="+imagePath+",if=none,id=disk0,format=qcow2,snapshot=on")
+	assert.Contains(t, qemuArgs, "-netdev user,id=net0,hostfwd=tcp:127.0.0.1:")
+	assert.Contains(t, qemuArgs, "-serial file:"+serialLog)
+	assert.Regexp(t, `-smbios type=1,serial=ds=nocloud;s=http://10\.0\.2\.2:[0-9]+/`, qemuArgs)
+	assert.Contains(t, qemuArgs, "io.systemd.credential.binary:ssh.authorized_keys.root="+base64.StdEncoding.EncodeToString([]byte("ssh-ed25519 AAAAfake")))
+
+	scpCalls := mocks["scp"].CallArgsList()
+	require.Len(t, scpCalls, 2)
+	assert.Equal(t, checkHostConfig, scpCalls[0][len(scpCalls[0])-2])
+	assert.Equal(t, "osbuild@127.0.0.1:/tmp/check-host-config", scpCalls[0][len(scpCalls[0])-1])
+	assert.Equal(t, "osbuild@127.0.0.1:/tmp/config.json", scpCalls[1][len(scpCalls[1])-1])
+
+	// the build config is made from the blueprint
+	var config struct {
+		Name      string         `json:"name"`
+		Blueprint map[string]any `json:"blueprint"`
+	}
+	configData, err := os.ReadFile(filepath.Join(filepath.Dir(mocks["scp"].Path()), "config.json"))
+	require.NoError(t, err)
+	require.NoError(t, json.Unmarshal(configData, &config))
+	assert.Equal(t, "centos-9-qcow2-x86_64", config.Name)
+	assert.NotNil(t, config.Blueprint["customizations"])
+
+	sshCalls := mocks["ssh"].CallArgsList()
+	checkCall := sshCalls[len(sshCalls)-1]
+	assert.Equal(t, "osbuild@127.0.0.1", checkCall[len(checkCall)-5])
+	assert.Equal(t, []string{"/tmp/check-host-config", "-wait-timeout"}, checkCall[len(checkCall)-4:len(checkCall)-2])
+	assert.Equal(t, "/tmp/config.json", checkCall[len(checkCall)-1])
+}
+
+func TestBootTestFirmware(t *testing.T) {
+	for _, tc := range []struct {
+		name     string
+		vars     bool
+		expected string
+	}{
+		{"split", true, `-drive if=pflash,format=raw,unit=0,readonly=on,file=.*/OVMF_CODE\.fd -drive if=pflash,format=raw,unit=1,file=.*/image-builder-boot-test-[0-9]+/OVMF_VARS\.fd `},
+		{"combined", false, `-bios .*/OVMF_CODE\.fd `},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			mocks := mockBootTestTools(t, 0)
+			imagePath, checkHostConfig := makeBootTestImage(t)
+			fwDir := t.TempDir()
+			code := filepath.Join(fwDir, "OVMF_CODE.fd")
+			require.NoError(t, os.WriteFile(code, []byte("code"), 0644))
+			var vars string
+			if tc.vars {
+				vars = filepath.Join(fwDir, "OVMF_VARS.fd")
+				require.NoError(t, os.WriteFile(vars, []byte("vars"), 0444))
+			}
+			restore := main.MockFirmwarePaths("x86_64", code, vars)
+			defer restore()
+
+			_, _, err := runBootTest(t, imagePath, "--check-host-config", checkHostConfig)
+			require.NoError(t, err)
+
+			qemuArgs := strings.Join(mocks["qemu-system-x86_64"].CallArgsList()[0], " ")
+			assert.Regexp(t, tc.expected, qemuArgs)
+			if tc.vars {
+				assert.NotContains(t, qemuArgs, "-bios")
+				// the template of the variables is never used directly
+				assert.NotContains(t, qemuArgs, "file="+vars)
+			}
+		})
+	}
+}
+
+func TestBootTestFails(t *testing.T) {
+	mockBootTestTools(t, 1)
+	imagePath, checkHostConfig := makeBootTestImage(t)
+	serialLog := filepath.Join(t.TempDir(), "serial.log")
+
+	stdout, stderr, err := runBootTest(t, imagePath, "--check-host-config", checkHostConfig, "--serial-log", serialLog)
+	assert.EqualError(t, err, "boot test failed: check-host-config failed with exit code 1")
+	assert.Contains(t, stdout, "Serial console log: "+serialLog+"\n")
+	assert.NotContains(t, stdout, "Boot test passed")
+	assert.Contains(t, stderr, "Last lines of the serial console:\nBooting Linux\nCentOS Stream 9\nlocalhost login:\n")
+}
+
+func TestBootTestErrors(t *testing.T) {
+	imagePath, checkHostConfig := makeBootTestImage(t)
+
+	_, _, err := runBootTest(t, imagePath, "--config", "config.toml", "--blueprint", "bp.toml")
+	assert.EqualError(t, err, "cannot use --config with --blueprint")
+
+	compressed := imagePath + ".xz"
+	require.NoError(t, os.WriteFile(compressed, nil, 0644))
+	_, _, err = runBootTest(t, compressed, "--check-host-config", checkHostConfig)
+	assert.EqualError(t, err, "cannot boot compressed image "+compressed+", decompress it first")
+
+	unknown := filepath.Join(t.TempDir(), "disk.qcow2")
+	require.NoError(t, os.WriteFile(unknown, nil, 0644))
+	_, _, err = runBootTest(t, unknown, "--check-host-config", checkHostConfig)
+	assert.EqualError(t, err, `cannot detect the image type from "`+unknown+`", use --distro and --type`)
+}
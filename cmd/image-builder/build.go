@@ -20,6 +20,12 @@ type buildOptions struct {
 	WriteManifest bool
 	WriteBuildlog bool
 	Metrics       bool
+
+	// Checksums are the algorithms of the checksum files that are
+	// written for every artifact
+	Checksums []string
+	// Signer creates detached signatures for every artifact
+	Signer *artifactSigner
 }
 
 func buildImage(pbar progress.ProgressBar, res *imagefilter.Result, osbuildManifest []byte, opts *buildOptions) (string, error) {
@@ -67,13 +73,29 @@ func buildImage(pbar progress.ProgressBar, res *imagefilter.Result, osbuildManif
 	// similar code like this.
 	pipelineDir := filepath.Join(opts.OutputDir, res.ImgType.Exports()[0])
 	srcName := filepath.Join(pipelineDir, res.ImgType.Filename())
-	imgExt := strings.SplitN(res.ImgType.Filename(), ".", 2)[1]
-	dstName := filepath.Join(opts.OutputDir, fmt.Sprintf("%s.%v", basename, imgExt))
+	dstName := imagePathFor(res, opts.OutputDir, opts.OutputBasename)
 	if err := os.Rename(srcName, dstName); err != nil {
 		return "", fmt.Errorf("cannot rename artifact to final name: %w", err)
 	}
 	// best effort, remove the now empty pipeline export dir from osbuild
 	_ = os.Remove(pipelineDir)
 
+	if len(opts.Checksums) > 0 || opts.Signer != nil {
+		artifacts, err := buildArtifacts(dstName, opts.OutputDir, basename, opts)
+		if err != nil {
+			return "", err
+		}
+		if err := checksumAndSignArtifacts(artifacts, opts.Checksums, opts.Signer); err != nil {
+			return "", err
+		}
+	}
+
 	return dstName, nil
 }
+
+// imagePathFor returns the path of the image that buildImage
+// produces for the given output directory and basename
+func imagePathFor(res *imagefilter.Result, outputDir, outputBasename string) string {
+	imgExt := strings.SplitN(res.ImgType.Filename(), ".", 2)[1]
+	return filepath.Join(outputDir, fmt.Sprintf("%s.%v", basenameFor(res, outputBasename), imgExt))
+}
@@ -0,0 +1,458 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"slices"
+	"strconv"
+	"strings"
+	"syscall"
+	"time"
+
+	"github.com/spf13/cobra"
+	"go.yaml.in/yaml/v3"
+
+	"github.com/osbuild/image-builder/pkg/datasizes"
+	"github.com/osbuild/image-builder/pkg/depsolvednf"
+	"github.com/osbuild/image-builder/pkg/manifestgen"
+)
+
+// cacheEntry is a single removable entry of the osbuild store or of the
+// rpm metadata cache
+type cacheEntry struct {
+	// Kind is "object" for the osbuild store objects, "source" for
+	// downloaded sources (e.g. rpms) and "repository" for the
+	// metadata of a repository in the rpmmd cache
+	Kind string `json:"kind" yaml:"kind"`
+	Name string `json:"name" yaml:"name"`
+	// Distros are the distros that use the repository
+	Distros  []string  `json:"distros,omitempty" yaml:"distros,omitempty"`
+	Size     int64     `json:"size" yaml:"size"`
+	LastUsed time.Time `json:"last_used" yaml:"last_used"`
+
+	paths []string
+}
+
+type cacheSection struct {
+	Path    string       `json:"path" yaml:"path"`
+	Size    int64        `json:"size" yaml:"size"`
+	Entries []cacheEntry `json:"entries" yaml:"entries"`
+}
+
+type cacheListing struct {
+	Store cacheSection `json:"store" yaml:"store"`
+	RPMMD cacheSection `json:"rpmmd" yaml:"rpmmd"`
+}
+
+type cacheRemoval struct {
+	DryRun  bool         `json:"dry_run,omitempty" yaml:"dry_run,omitempty"`
+	Removed []cacheEntry `json:"removed" yaml:"removed"`
+	Freed   int64        `json:"freed" yaml:"freed"`
+}
+
+// readStoreEntries returns the objects and sources of the osbuild store
+func readStoreEntries(storeDir string) ([]cacheEntry, error) {
+	var entries []cacheEntry
+	add := func(kind, dir, prefix string) error {
+		dents, err := os.ReadDir(dir)
+		if errors.Is(err, os.ErrNotExist) {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+		for _, dent := range dents {
+			path := filepath.Join(dir, dent.Name())
+			info, err := dent.Info()
+			if err != nil {
+				return err
+			}
+			size, err := calcDirSize(path)
+			if err != nil {
+				return err
+			}
+			entries = append(entries, cacheEntry{
+				Kind:     kind,
+				Name:     prefix + dent.Name(),
+				Size:     size,
+				LastUsed: info.ModTime(),
+				paths:    []string{path},
+			})
+		}
+		return nil
+	}
+
+	if err := add("object", filepath.Join(storeDir, "objects"), ""); err != nil {
+		return nil, err
+	}
+	sourceTypes, err := os.ReadDir(filepath.Join(storeDir, "sources"))
+	if err != nil && !errors.Is(err, os.ErrNotExist) {
+		return nil, err
+	}
+	for _, st := range sourceTypes {
+		if !st.IsDir() {
+			continue
+		}
+		if err := add("source", filepath.Join(storeDir, "sources", st.Name()), st.Name()+"/"); err != nil {
+			return nil, err
+		}
+	}
+	return entries, nil
+}
+
+// repoDistros maps the ids of repositories (the hash of their
+// configuration that names their entries in the rpmmd cache) to the
+// distros that use them
+func repoDistros(repoDir string) (map[string][]string, error) {
+	registry, err := newRepoRegistry(repoDir, nil)
+	if err != nil {
+		return nil, err
+	}
+	distros := make(map[string][]string)
+	for _, name := range registry.ListDistros() {
+		for _, archName := range registry.ListArches(name) {
+			repos, err := registry.ReposByArchName(name, archName, true)
+			if err != nil {
+				return nil, err
+			}
+			for _, repo := range repos {
+				id := repo.Hash()
+				if !slices.Contains(distros[id], name) {
+					distros[id] = append(distros[id], name)
+				}
+			}
+		}
+	}
+	for _, names := range distros {
+		slices.Sort(names)
+	}
+	return distros, nil
+}
+
+func readRPMMDEntries(rpmmdDir string, distros map[string][]string) []cacheEntry {
+	var entries []cacheEntry
+	for _, e := range depsolvednf.ReadCache(rpmmdDir) {
+		entries = append(entries, cacheEntry{
+			Kind:     "repository",
+			Name:     e.Dir + "/" + e.RepoID,
+			Distros:  distros[e.RepoID],
+			Size:     int64(e.Size),
+			LastUsed: e.MTime,
+			paths:    e.Paths,
+		})
+	}
+	return entries
+}
+
+func newCacheSection(path string, entries []cacheEntry) cacheSection {
+	sec := cacheSection{Path: path, Entries: entries}
+	for _, e := range entries {
+		sec.Size += e.Size
+	}
+	if sec.Entries == nil {
+		sec.Entries = []cacheEntry{}
+	}
+	return sec
+}
+
+// lockStore takes the lock of the osbuild store, osbuild holds a
+// shared lock on it while it uses the store so this fails when a
+// build is running
+func lockStore(storeDir string) (unlock func(), err error) {
+	f, err := os.OpenFile(filepath.Join(storeDir, "cache.lock"), os.O_RDWR, 0)
+	if errors.Is(err, os.ErrNotExist) {
+		return func() {}, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	if err := syscall.Flock(int(f.Fd()), syscall.LOCK_EX|syscall.LOCK_NB); err != nil {
+		f.Close()
+		return nil, fmt.Errorf("cannot lock the osbuild store %s, is a build running? %w", storeDir, err)
+	}
+	return func() { f.Close() }, nil
+}
+
+// parseCacheAge parses a duration that can also be given in days,
+// e.g. "30d"
+func parseCacheAge(s string) (time.Duration, error) {
+	if days, ok := strings.CutSuffix(s, "d"); ok {
+		n, err := strconv.Atoi(days)
+		if err != nil || n < 0 {
+			return 0, fmt.Errorf("invalid age %q", s)
+		}
+		return time.Duration(n) * 24 * time.Hour, nil
+	}
+	d, err := time.ParseDuration(s)
+	if err != nil {
+		return 0, fmt.Errorf("invalid age %q: %w", s, err)
+	}
+	return d, nil
+}
+
+type cacheDirs struct {
+	store string
+	rpmmd string
+	// distros of the repositories in the rpmmd cache
+	distros map[string][]string
+}
+
+func cacheDirsFromCmd(cmd *cobra.Command) (*cacheDirs, error) {
+	storeDir, err := cmd.Flags().GetString("cache")
+	if err != nil {
+		return nil, err
+	}
+	rpmmdDir, err := cmd.Flags().GetString("rpmmd-cache")
+	if err != nil {
+		return nil, err
+	}
+	repoDir, err := cmd.Flags().GetString("force-repo-dir")
+	if err != nil {
+		return nil, err
+	}
+	if rpmmdDir == "" {
+		if rpmmdDir, err = manifestgen.DefaultCacheDir(); err != nil {
+			return nil, err
+		}
+	}
+	distros, err := repoDistros(repoDir)
+	if err != nil {
+		return nil, err
+	}
+	return &cacheDirs{store: storeDir, rpmmd: rpmmdDir, distros: distros}, nil
+}
+
+func (c *cacheDirs) list() (*cacheListing, error) {
+	storeEntries, err := readStoreEntries(c.store)
+	if err != nil {
+		return nil, err
+	}
+	return &cacheListing{
+		Store: newCacheSection(c.store, storeEntries),
+		RPMMD: newCacheSection(c.rpmmd, readRPMMDEntries(c.rpmmd, c.distros)),
+	}, nil
+}
+
+// remove removes the given entries (unless dryRun is set), the store is
+// locked while entries are removed from it
+func (c *cacheDirs) remove(entries []cacheEntry, dryRun bool) (*cacheRemoval, error) {
+	res := &cacheRemoval{DryRun: dryRun, Removed: []cacheEntry{}}
+	if !dryRun && slices.ContainsFunc(entries, func(e cacheEntry) bool { return e.Kind != "repository" }) {
+		unlock, err := lockStore(c.store)
+		if err != nil {
+			return nil, err
+		}
+		defer unlock()
+	}
+	var removeErr error
+	removedObjects := false
+	for _, e := range entries {
+		if !dryRun {
+			for _, p := range e.paths {
+				if removeErr = os.RemoveAll(p); removeErr != nil {
+					break
+				}
+			}
+			removedObjects = removedObjects || e.Kind == "object"
+			if removeErr != nil {
+				break
+			}
+		}
+		res.Removed = append(res.Removed, e)
+		res.Freed += e.Size
+	}
+	// the size is also updated when only some of the objects could be
+	// removed, the store is still locked here
+	if removedObjects {
+		if err := updateStoreSize(c.store); err != nil {
+			return res, errors.Join(removeErr, fmt.Errorf("cannot update the size of the osbuild store: %w", err))
+		}
+	}
+	return res, removeErr
+}
+
+// updateStoreSize recomputes the size of the objects that osbuild keeps
+// in the cache.size file of the store (to enforce the maximum size of
+// the store), the store must be locked
+func updateStoreSize(storeDir string) error {
+	sizePath := filepath.Join(storeDir, "cache.size")
+	if _, err := os.Stat(sizePath); errors.Is(err, os.ErrNotExist) {
+		return nil
+	} else if err != nil {
+		return err
+	}
+	objects, err := os.ReadDir(filepath.Join(storeDir, "objects"))
+	if err != nil && !errors.Is(err, os.ErrNotExist) {
+		return err
+	}
+	var size int64
+	for _, obj := range objects {
+		objSize, err := osbuildObjectSize(filepath.Join(storeDir, "objects", obj.Name()))
+		if err != nil {
+			return err
+		}
+		size += objSize
+	}
+	return os.WriteFile(sizePath, []byte(strconv.FormatInt(size, 10)), 0o644)
+}
+
+// osbuildObjectSize returns the size of a store object the way osbuild
+// accounts it, the apparent size of all its files and directories
+func osbuildObjectSize(path string) (int64, error) {
+	var total int64
+	err := filepath.WalkDir(path, func(_ string, d fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+		info, err := d.Info()
+		if err != nil {
+			return err
+		}
+		total += info.Size()
+		return nil
+	})
+	return total, err
+}
+
+func writeCacheOutput(cmd *cobra.Command, v any) error {
+	format, err := cmd.Flags().GetString("format")
+	if err != nil {
+		return err
+	}
+	var output []byte
+	switch format {
+	case "", "yaml":
+		output, err = yaml.Marshal(v)
+	case "json":
+		output, err = json.MarshalIndent(v, "", "  ")
+		output = append(output, '\n')
+	default:
+		return fmt.Errorf("unsupported format %q, supported formats: yaml, json", format)
+	}
+	if err != nil {
+		return err
+	}
+	_, err = cmd.OutOrStdout().Write(output)
+	return err
+}
+
+func cmdCacheList(cmd *cobra.Command, args []string) error {
+	dirs, err := cacheDirsFromCmd(cmd)
+	if err != nil {
+		return err
+	}
+	listing, err := dirs.list()
+	if err != nil {
+		return err
+	}
+	return writeCacheOutput(cmd, listing)
+}
+
+func cmdCachePrune(cmd *cobra.Command, args []string) error {
+	olderThanStr, err := cmd.Flags().GetString("older-than")
+	if err != nil {
+		return err
+	}
+	var maxSize datasizes.Size
+	if err := cmd.Flags().GetText("max-size", &maxSize); err != nil {
+		return err
+	}
+	dryRun, err := cmd.Flags().GetBool("dry-run")
+	if err != nil {
+		return err
+	}
+	if olderThanStr == "" && !cmd.Flags().Changed("max-size") {
+		return fmt.Errorf("cache prune needs --older-than and/or --max-size")
+	}
+
+	dirs, err := cacheDirsFromCmd(cmd)
+	if err != nil {
+		return err
+	}
+	listing, err := dirs.list()
+	if err != nil {
+		return err
+	}
+	// the least recently used entries of both caches are pruned first
+	entries := append(listing.Store.Entries, listing.RPMMD.Entries...)
+	slices.SortStableFunc(entries, func(a, b cacheEntry) int {
+		return a.LastUsed.Compare(b.LastUsed)
+	})
+	total := listing.Store.Size + listing.RPMMD.Size
+
+	var prune []cacheEntry
+	if olderThanStr != "" {
+		olderThan, err := parseCacheAge(olderThanStr)
+		if err != nil {
+			return err
+		}
+		cutoff := time.Now().Add(-olderThan)
+		for len(entries) > 0 && entries[0].LastUsed.Before(cutoff) {
+			prune = append(prune, entries[0])
+			total -= entries[0].Size
+			entries = entries[1:]
+		}
+	}
+	if cmd.Flags().Changed("max-size") {
+		for len(entries) > 0 && total > int64(maxSize) {
+			prune = append(prune, entries[0])
+			total -= entries[0].Size
+			entries = entries[1:]
+		}
+	}
+
+	res, err := dirs.remove(prune, dryRun)
+	if err != nil {
+		return err
+	}
+	return writeCacheOutput(cmd, res)
+}
+
+func cmdCacheClean(cmd *cobra.Command, args []string) error {
+	distroName, err := cmd.Flags().GetString("distro")
+	if err != nil {
+		return err
+	}
+	dryRun, err := cmd.Flags().GetBool("dry-run")
+	if err != nil {
+		return err
+	}
+
+	dirs, err := cacheDirsFromCmd(cmd)
+	if err != nil {
+		return err
+	}
+	listing, err := dirs.list()
+	if err != nil {
+		return err
+	}
+	var clean []cacheEntry
+	if distroName == "" {
+		clean = append(listing.Store.Entries, listing.RPMMD.Entries...)
+	} else {
+		known := false
+		for _, names := range dirs.distros {
+			known = known || slices.Contains(names, distroName)
+		}
+		if !known {
+			return fmt.Errorf("unknown distro %q", distroName)
+		}
+		// store objects and sources are not specific to a distro,
+		// only the repository metadata is cleaned
+		for _, e := range listing.RPMMD.Entries {
+			if slices.Contains(e.Distros, distroName) {
+				clean = append(clean, e)
+			}
+		}
+	}
+
+	res, err := dirs.remove(clean, dryRun)
+	if err != nil {
+		return err
+	}
+	return writeCacheOutput(cmd, res)
+}
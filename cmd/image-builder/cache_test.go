@@ -0,0 +1,258 @@
+package main_test
+
+import (
+	"bytes"
+	"encoding/json"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"syscall"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	main "github.com/osbuild/image-builder/cmd/image-builder"
+	testrepos "github.com/osbuild/image-builder/test/data/repositories"
+)
+
+const unknownRepoID = "ffffffffffffffffffffffffffffffffffffffffffffffffffffffffffffffff"
+
+type fakeCacheFile struct {
+	size int
+	age  time.Duration
+}
+
+func writeFakeCacheFiles(t *testing.T, root string, files map[string]fakeCacheFile) {
+	for name, f := range files {
+		path := filepath.Join(root, name)
+		require.NoError(t, os.MkdirAll(filepath.Dir(path), 0755))
+		require.NoError(t, os.WriteFile(path, bytes.Repeat([]byte("x"), f.size), 0644))
+		mtime := time.Now().Add(-f.age)
+		require.NoError(t, os.Chtimes(path, mtime, mtime))
+	}
+}
+
+// makeFakeCaches creates an osbuild store and a rpmmd cache with entries
+// of different ages, it returns the directories and the id of the
+// cached centos-9 repository
+func makeFakeCaches(t *testing.T) (storeDir, rpmmdDir, centosRepoID string) {
+	registry, err := testrepos.New()
+	require.NoError(t, err)
+	repos, err := registry.ReposByArchName("centos-9", "x86_64", true)
+	require.NoError(t, err)
+	centosRepoID = repos[0].Hash()
+
+	storeDir = t.TempDir()
+	writeFakeCacheFiles(t, storeDir, map[string]fakeCacheFile{
+		"objects/aaaa/data/tree/file":                 {100, 0},
+		"objects/bbbb/data/tree/file":                 {300, 0},
+		"sources/org.osbuild.files/sha256:cccc":       {50, 40 * 24 * time.Hour},
+		"cache.lock":                                  {0, 0},
+		"stage/uuid-in-progress/data/tree/unfinished": {1000, 0},
+	})
+	// osbuild accounts the size of the objects in cache.size
+	require.NoError(t, os.WriteFile(filepath.Join(storeDir, "cache.size"), []byte("12345"), 0644))
+	// the mtime of the object directories is the last use
+	old := time.Now().Add(-10 * 24 * time.Hour)
+	require.NoError(t, os.Chtimes(filepath.Join(storeDir, "objects/aaaa"), old, old))
+
+	rpmmdDir = t.TempDir()
+	writeFakeCacheFiles(t, rpmmdDir, map[string]fakeCacheFile{
+		"platform:el9-9-x86_64/" + centosRepoID + ".solv":            {1000, 20 * 24 * time.Hour},
+		"platform:el9-9-x86_64/" + centosRepoID + "-filenames.solvx": {30, 20 * 24 * time.Hour},
+		"platform:el9-9-x86_64/" + unknownRepoID + ".solv":           {20, 0},
+	})
+	return storeDir, rpmmdDir, centosRepoID
+}
+
+func assertStoreSize(t *testing.T, storeDir, expected string) {
+	t.Helper()
+	data, err := os.ReadFile(filepath.Join(storeDir, "cache.size"))
+	require.NoError(t, err)
+	assert.Equal(t, expected, string(data))
+}
+
+func runCache(t *testing.T, args ...string) ([]byte, error) {
+	restore := main.MockNewRepoRegistry(testrepos.New)
+	defer restore()
+
+	var fakeStdout bytes.Buffer
+	restore = main.MockOsStdout(&fakeStdout)
+	defer restore()
+	restore = main.MockOsArgs(append([]string{"cache"}, args...))
+	defer restore()
+	err := main.Run()
+	return fakeStdout.Bytes(), err
+}
+
+type cacheEntryJSON struct {
+	Kind     string    `json:"kind"`
+	Name     string    `json:"name"`
+	Distros  []string  `json:"distros"`
+	Size     int64     `json:"size"`
+	LastUsed time.Time `json:"last_used"`
+}
+
+func entryNames(entries []cacheEntryJSON) []string {
+	var names []string
+	for _, e := range entries {
+		names = append(names, e.Kind+":"+e.Name)
+	}
+	return names
+}
+
+func TestCacheList(t *testing.T) {
+	storeDir, rpmmdDir, centosRepoID := makeFakeCaches(t)
+
+	output, err := runCache(t, "list", "--cache", storeDir, "--rpmmd-cache", rpmmdDir, "--format", "json")
+	require.NoError(t, err)
+
+	var listing struct {
+		Store struct {
+			Path    string           `json:"path"`
+			Size    int64            `json:"size"`
+			Entries []cacheEntryJSON `json:"entries"`
+		} `json:"store"`
+		RPMMD struct {
+			Path    string           `json:"path"`
+			Size    int64            `json:"size"`
+			Entries []cacheEntryJSON `json:"entries"`
+		} `json:"rpmmd"`
+	}
+	require.NoError(t, json.Unmarshal(output, &listing))
+	assert.Equal(t, storeDir, listing.Store.Path)
+	assert.Equal(t, int64(450), listing.Store.Size)
+	assert.Equal(t, []string{"object:aaaa", "object:bbbb", "source:org.osbuild.files/sha256:cccc"}, entryNames(listing.Store.Entries))
+	assert.WithinDuration(t, time.Now().Add(-10*24*time.Hour), listing.Store.Entries[0].LastUsed, time.Minute)
+
+	assert.Equal(t, rpmmdDir, listing.RPMMD.Path)
+	assert.Equal(t, int64(1050), listing.RPMMD.Size)
+	// least recently used first
+	require.Len(t, listing.RPMMD.Entries, 2)
+	assert.Equal(t, cacheEntryJSON{
+		Kind:     "repository",
+		Name:     "platform:el9-9-x86_64/" + centosRepoID,
+		Distros:  []string{"centos-9"},
+		Size:     1030,
+		LastUsed: listing.RPMMD.Entries[0].LastUsed,
+	}, listing.RPMMD.Entries[0])
+	assert.Equal(t, "platform:el9-9-x86_64/"+unknownRepoID, listing.RPMMD.Entries[1].Name)
+	assert.Nil(t, listing.RPMMD.Entries[1].Distros)
+
+	output, err = runCache(t, "list", "--cache", storeDir, "--rpmmd-cache", rpmmdDir)
+	require.NoError(t, err)
+	assert.Contains(t, string(output), "store:\n    path: "+storeDir+"\n    size: 450\n")
+}
+
+type cacheRemovalJSON struct {
+	DryRun  bool             `json:"dry_run"`
+	Removed []cacheEntryJSON `json:"removed"`
+	Freed   int64            `json:"freed"`
+}
+
+func TestCachePrune(t *testing.T) {
+	storeDir, rpmmdDir, centosRepoID := makeFakeCaches(t)
+	centosSolv := filepath.Join(rpmmdDir, "platform:el9-9-x86_64", centosRepoID+".solv")
+
+	output, err := runCache(t, "prune", "--cache", storeDir, "--rpmmd-cache", rpmmdDir, "--older-than", "15d", "--dry-run", "--format", "json")
+	require.NoError(t, err)
+	var res cacheRemovalJSON
+	require.NoError(t, json.Unmarshal(output, &res))
+	assert.True(t, res.DryRun)
+	assert.Equal(t, []string{"source:org.osbuild.files/sha256:cccc", "repository:platform:el9-9-x86_64/" + centosRepoID}, entryNames(res.Removed))
+	assert.Equal(t, int64(1080), res.Freed)
+	assert.FileExists(t, centosSolv)
+	assertStoreSize(t, storeDir, "12345")
+
+	// --max-size removes the least recently used entries on top
+	output, err = runCache(t, "prune", "--cache", storeDir, "--rpmmd-cache", rpmmdDir, "--older-than", "15d", "--max-size", "400", "--format", "json")
+	require.NoError(t, err)
+	res = cacheRemovalJSON{}
+	require.NoError(t, json.Unmarshal(output, &res))
+	assert.False(t, res.DryRun)
+	assert.Equal(t, []string{"source:org.osbuild.files/sha256:cccc", "repository:platform:el9-9-x86_64/" + centosRepoID, "object:aaaa"}, entryNames(res.Removed))
+	assert.Equal(t, int64(1180), res.Freed)
+	assert.NoFileExists(t, centosSolv)
+	assert.NoDirExists(t, filepath.Join(storeDir, "objects/aaaa"))
+	assert.DirExists(t, filepath.Join(storeDir, "objects/bbbb"))
+	// objects that are being built are never touched
+	assert.DirExists(t, filepath.Join(storeDir, "stage/uuid-in-progress"))
+	// the size of the remaining objects is accounted again
+	var remaining int64
+	require.NoError(t, filepath.WalkDir(filepath.Join(storeDir, "objects/bbbb"), func(_ string, d fs.DirEntry, err error) error {
+		require.NoError(t, err)
+		info, err := d.Info()
+		require.NoError(t, err)
+		remaining += info.Size()
+		return nil
+	}))
+	assertStoreSize(t, storeDir, strconv.FormatInt(remaining, 10))
+
+	output, err = runCache(t, "prune", "--cache", storeDir, "--rpmmd-cache", rpmmdDir, "--older-than", "15d")
+	require.NoError(t, err)
+	assert.Equal(t, "removed: []\nfreed: 0\n", string(output))
+}
+
+func TestCachePruneLockedStore(t *testing.T) {
+	storeDir, rpmmdDir, _ := makeFakeCaches(t)
+
+	// osbuild holds a shared lock while it uses the store
+	f, err := os.Open(filepath.Join(storeDir, "cache.lock"))
+	require.NoError(t, err)
+	defer f.Close()
+	require.NoError(t, syscall.Flock(int(f.Fd()), syscall.LOCK_SH))
+
+	_, err = runCache(t, "prune", "--cache", storeDir, "--rpmmd-cache", rpmmdDir, "--max-size", "0")
+	assert.ErrorContains(t, err, "cannot lock the osbuild store "+storeDir+", is a build running?")
+	assert.DirExists(t, filepath.Join(storeDir, "objects/aaaa"))
+}
+
+func TestCacheClean(t *testing.T) {
+	storeDir, rpmmdDir, centosRepoID := makeFakeCaches(t)
+
+	output, err := runCache(t, "clean", "--cache", storeDir, "--rpmmd-cache", rpmmdDir, "--distro", "centos-9", "--format", "json")
+	require.NoError(t, err)
+	var res cacheRemovalJSON
+	require.NoError(t, json.Unmarshal(output, &res))
+	assert.Equal(t, []string{"repository:platform:el9-9-x86_64/" + centosRepoID}, entryNames(res.Removed))
+	assert.NoFileExists(t, filepath.Join(rpmmdDir, "platform:el9-9-x86_64", centosRepoID+".solv"))
+	assert.FileExists(t, filepath.Join(rpmmdDir, "platform:el9-9-x86_64", unknownRepoID+".solv"))
+	assert.DirExists(t, filepath.Join(storeDir, "objects/aaaa"))
+	assertStoreSize(t, storeDir, "12345")
+
+	output, err = runCache(t, "clean", "--cache", storeDir, "--rpmmd-cache", rpmmdDir, "--format", "json")
+	require.NoError(t, err)
+	res = cacheRemovalJSON{}
+	require.NoError(t, json.Unmarshal(output, &res))
+	assert.Len(t, res.Removed, 4)
+	assert.Equal(t, int64(470), res.Freed)
+	entries, err := os.ReadDir(filepath.Join(storeDir, "objects"))
+	require.NoError(t, err)
+	assert.Empty(t, entries)
+	assertStoreSize(t, storeDir, "0")
+	assert.NoFileExists(t, filepath.Join(rpmmdDir, "platform:el9-9-x86_64", unknownRepoID+".solv"))
+}
+
+func TestCacheErrors(t *testing.T) {
+	storeDir, rpmmdDir, _ := makeFakeCaches(t)
+
+	for _, tc := range []struct {
+		args        []string
+		expectedErr string
+	}{
+		{[]string{"prune"}, "cache prune needs --older-than and/or --max-size"},
+		{[]string{"prune", "--older-than", "a while"}, `invalid age "a while": time: invalid duration "a while"`},
+		{[]string{"prune", "--older-than", "xd"}, `invalid age "xd"`},
+		{[]string{"clean", "--distro", "foo-1"}, `unknown distro "foo-1"`},
+		{[]string{"list", "--format", "toml"}, `unsupported format "toml", supported formats: yaml, json`},
+	} {
+		t.Run(strings.Join(tc.args, " "), func(t *testing.T) {
+			_, err := runCache(t, append(tc.args, "--cache", storeDir, "--rpmmd-cache", rpmmdDir)...)
+			assert.EqualError(t, err, tc.expectedErr)
+		})
+	}
+}
@@ -4,6 +4,7 @@ import (
 	"fmt"
 	"log"
 	"os"
+	"time"
 
 	"github.com/osbuild/image-builder/internal/olog"
 	"github.com/osbuild/image-builder/pkg/datasizes"
@@ -42,24 +43,9 @@ operating systems like Fedora, CentOS and RHEL with easy customizations support.
 	if err := rootCmd.Flags().MarkHidden("version"); err != nil {
 		return nil, err
 	}
-	var forceRepoDir string
-	rootCmd.PersistentFlags().StringVar(&forceRepoDir, "force-repo-dir", "", "Override the default repository search path for custom repository files")
-	rootCmd.PersistentFlags().StringVar(&forceRepoDir, "force-data-dir", "", `Override the default data directory for e.g. custom repositories/*.json data`)
-	if err := rootCmd.PersistentFlags().MarkDeprecated("force-data-dir", `Use --force-repo-dir instead`); err != nil {
+	if err := setupRootPersistentFlags(rootCmd); err != nil {
 		return nil, err
 	}
-	rootCmd.PersistentFlags().StringVar(&forceRepoDir, "data-dir", "", `Override the default data directory for e.g. custom repositories/*.json data`)
-	if err := rootCmd.PersistentFlags().MarkDeprecated("data-dir", `Use --force-repo-dir instead`); err != nil {
-		return nil, err
-	}
-	rootCmd.PersistentFlags().String("force-defs-dir", "", "Override the path to load YAML distro definitions from")
-	if err := rootCmd.PersistentFlags().MarkHidden("force-defs-dir"); err != nil {
-		return nil, err
-	}
-	rootCmd.PersistentFlags().StringArray("extra-repo", nil, `Add an extra repository during build (will *not* be gpg checked and not be part of the final image)`)
-	rootCmd.PersistentFlags().StringArray("force-repo", nil, `Override the base repositories during build (these will not be part of the final image)`)
-	rootCmd.PersistentFlags().String("output-dir", "", `Put output into the specified directory`)
-	rootCmd.PersistentFlags().BoolP("verbose", "v", false, `Switch to verbose mode (more logging on stderr and verbose progress)`)
 	registerMemProfileFlags(rootCmd)
 	rootCmd.PersistentPreRun = memProfilePersistentPreRun
 
@@ -87,30 +73,61 @@ operating systems like Fedora, CentOS and RHEL with easy customizations support.
 	}
 	rootCmd.AddCommand(manifestCmd)
 
+	lockCmd, err := setupLockCmd()
+	if err != nil {
+		return nil, err
+	}
+	rootCmd.AddCommand(lockCmd)
+
+	fetchCmd, err := setupFetchCmd()
+	if err != nil {
+		return nil, err
+	}
+	rootCmd.AddCommand(fetchCmd)
+
+	diffCmd, err := setupDiffCmd()
+	if err != nil {
+		return nil, err
+	}
+	rootCmd.AddCommand(diffCmd)
+
+	serveCmd := setupServeCmd()
+	rootCmd.AddCommand(serveCmd)
+
+	verifyCmd := setupVerifyCmd()
+	rootCmd.AddCommand(verifyCmd)
+
+	inspectCmd := setupInspectCmd()
+	rootCmd.AddCommand(inspectCmd)
+
+	bootTestCmd := setupBootTestCmd()
+	rootCmd.AddCommand(bootTestCmd)
+
 	uploadCmd := setupUploadCmd()
+	uploadCmd.Flags().String("to", "", "upload to the given cloud or registry://<target>")
 	rootCmd.AddCommand(uploadCmd)
 
-	buildCmd, err := setupBuildCmd()
+	buildCmd, err := setupBuildCmdWithFlags()
 	if err != nil {
 		return nil, err
 	}
-	buildCmd.Flags().AddFlagSet(manifestCmd.Flags())
-	// The build command can upload images to the appropriate cloud provider,
-	// so it should support the upload options as well
-	buildCmd.Flags().AddFlagSet(uploadCmd.Flags())
 	rootCmd.AddCommand(buildCmd)
 
-	buildCmd.Flags().AddFlagSet(uploadCmd.Flags())
-	// add after the rest of the uploadCmd flag set is added to avoid
-	// that build gets a "--to" parameter
-	uploadCmd.Flags().String("to", "", "upload to the given cloud")
-
 	describeCmd := setupDescribeCmd()
 	rootCmd.AddCommand(describeCmd)
 
 	pkgSearchCmd := setupPkgSearchCmd()
 	rootCmd.AddCommand(pkgSearchCmd)
 
+	reposCmd := setupReposCmd()
+	rootCmd.AddCommand(reposCmd)
+
+	cacheCmd := setupCacheCmd()
+	rootCmd.AddCommand(cacheCmd)
+
+	blueprintCmd := setupBlueprintCmd()
+	rootCmd.AddCommand(blueprintCmd)
+
 	docCmd := setupDocCmd(rootCmd)
 	rootCmd.AddCommand(docCmd)
 
@@ -126,6 +143,30 @@ operating systems like Fedora, CentOS and RHEL with easy customizations support.
 	return rootCmd, nil
 }
 
+// setupRootPersistentFlags adds the flags that are shared by all
+// commands to the given root command
+func setupRootPersistentFlags(rootCmd *cobra.Command) error {
+	var forceRepoDir string
+	rootCmd.PersistentFlags().StringVar(&forceRepoDir, "force-repo-dir", "", "Override the default repository search path for custom repository files")
+	rootCmd.PersistentFlags().StringVar(&forceRepoDir, "force-data-dir", "", `Override the default data directory for e.g. custom repositories/*.json data`)
+	if err := rootCmd.PersistentFlags().MarkDeprecated("force-data-dir", `Use --force-repo-dir instead`); err != nil {
+		return err
+	}
+	rootCmd.PersistentFlags().StringVar(&forceRepoDir, "data-dir", "", `Override the default data directory for e.g. custom repositories/*.json data`)
+	if err := rootCmd.PersistentFlags().MarkDeprecated("data-dir", `Use --force-repo-dir instead`); err != nil {
+		return err
+	}
+	rootCmd.PersistentFlags().String("force-defs-dir", "", "Override the path to load YAML distro definitions from")
+	if err := rootCmd.PersistentFlags().MarkHidden("force-defs-dir"); err != nil {
+		return err
+	}
+	rootCmd.PersistentFlags().StringArray("extra-repo", nil, `Add an extra repository during build (will *not* be gpg checked and not be part of the final image)`)
+	rootCmd.PersistentFlags().StringArray("force-repo", nil, `Override the base repositories during build (these will not be part of the final image)`)
+	rootCmd.PersistentFlags().String("output-dir", "", `Put output into the specified directory`)
+	rootCmd.PersistentFlags().BoolP("verbose", "v", false, `Switch to verbose mode (more logging on stderr and verbose progress)`)
+	return nil
+}
+
 func setupBootcCmd() (*cobra.Command, error) {
 	bootcCmd := &cobra.Command{
 		Use:   "bootc",
@@ -190,13 +231,13 @@ func setupSystemCmd() *cobra.Command {
 
 func setupManifestCmd() (*cobra.Command, error) {
 	manifestCmd := &cobra.Command{
-		Use:          "manifest <image-type>",
-		Short:        "Build manifest for the given image-type, e.g. qcow2 (tip: combine with --distro, --arch)",
+		Use:          "manifest <image-type> [<image-type>...]",
+		Short:        "Build manifest for the given image-types, e.g. qcow2 (tip: combine with --distro, --arch)",
 		Long:         "Build a manifest for the selected image type. The --image-size flag accepts bytes or a value with a data-size unit.",
 		Example:      "  image-builder manifest qcow2 --image-size \"1 GiB\"",
 		RunE:         cmdManifest,
 		SilenceUsage: true,
-		Args:         cobra.ExactArgs(1),
+		Args:         cobra.MinimumNArgs(1),
 		Hidden:       true,
 	}
 	manifestCmd.Flags().String("blueprint", "", `filename of a blueprint to customize an image`)
@@ -226,6 +267,7 @@ func setupManifestCmd() (*cobra.Command, error) {
 	manifestCmd.Flags().Bool("ignore-warnings", false, `ignore warnings during manifest generation`)
 	manifestCmd.Flags().String("registrations", "", `filename of a registrations file with e.g. subscription details`)
 	manifestCmd.Flags().String("rpmmd-cache", "", `osbuild directory to cache rpm metadata`)
+	manifestCmd.Flags().String("lockfile", "", `use the packages and resolved content of the given lockfile instead of depsolving (see "image-builder lock")`)
 	manifestCmd.Flags().Bool("preview", true, `override distro default preview state if passed`)
 	if err := manifestCmd.Flags().MarkHidden("preview"); err != nil {
 		return nil, err
@@ -233,6 +275,166 @@ func setupManifestCmd() (*cobra.Command, error) {
 	return manifestCmd, nil
 }
 
+func setupLockCmd() (*cobra.Command, error) {
+	lockCmd := &cobra.Command{
+		Use:          "lock <image-type> [<image-type>...]",
+		Short:        "Write the depsolved packages and resolved content of the given image-types to a lockfile",
+		Long:         "Write the depsolved packages, containers, ostree commits and flatpaks of the selected image types to a lockfile. Pass the lockfile via --lockfile to \"build\" or \"manifest\" to use the exact same content later.",
+		Example:      "  image-builder lock qcow2 --distro centos-9 --lockfile centos-9.lock",
+		RunE:         cmdLock,
+		SilenceUsage: true,
+		Args:         cobra.MinimumNArgs(1),
+	}
+	lockCmd.Flags().String("lockfile", "image-builder.lock", `write the lockfile to the given path`)
+
+	manifestCmd, err := setupManifestCmd()
+	if err != nil {
+		return nil, err
+	}
+	// "lockfile" is already defined above and is not overridden
+	lockCmd.Flags().AddFlagSet(manifestCmd.Flags())
+	return lockCmd, nil
+}
+
+func setupFetchCmd() (*cobra.Command, error) {
+	fetchCmd := &cobra.Command{
+		Use:   "fetch <image-type> [<image-type>...]",
+		Short: "Download all the sources of the given image-types into a sources bundle for offline builds",
+		Long: `Download the packages, containers, ostree commits and inline data of the
+selected image types together with a lockfile into a sources bundle. The
+bundle is a directory (or a tarball if the path ends in ".tar") that can be
+passed via --sources-bundle to "build" to build the images without network
+access.`,
+		Example:      "  image-builder fetch qcow2 --distro centos-9 --sources-bundle centos-9-sources.tar",
+		RunE:         cmdFetch,
+		SilenceUsage: true,
+		Args:         cobra.MinimumNArgs(1),
+	}
+	fetchCmd.Flags().String("sources-bundle", "image-builder-sources", `write the sources bundle to the given directory, or tarball if it ends in ".tar"`)
+
+	manifestCmd, err := setupManifestCmd()
+	if err != nil {
+		return nil, err
+	}
+	fetchCmd.Flags().AddFlagSet(manifestCmd.Flags())
+	return fetchCmd, nil
+}
+
+func setupDiffCmd() (*cobra.Command, error) {
+	diffCmd := &cobra.Command{
+		Use:   "diff <old> <new>",
+		Short: "Compare the packages and configuration of two images",
+		Long: `Compare the packages and stage options of two images. Each image is
+either an osbuild manifest, a lockfile (see "image-builder lock") or an
+image spec like "distro=centos-9,type=qcow2,arch=x86_64,blueprint=./config.toml"
+that is generated on the fly. Values missing from an image spec are taken
+from the --distro, --arch and --blueprint options.`,
+		Example:      "  image-builder diff old.json new.json\n  image-builder diff --distro centos-9 type=qcow2 type=qcow2,blueprint=./config.toml",
+		RunE:         cmdDiff,
+		SilenceUsage: true,
+		Args:         cobra.ExactArgs(2),
+	}
+	diffCmd.Flags().String("format", "", "Output in a specific format (text, json)")
+
+	manifestCmd, err := setupManifestCmd()
+	if err != nil {
+		return nil, err
+	}
+	diffCmd.Flags().AddFlagSet(manifestCmd.Flags())
+	return diffCmd, nil
+}
+
+func setupServeCmd() *cobra.Command {
+	serveCmd := &cobra.Command{
+		Use:   "serve",
+		Short: "Serve the list, describe, manifest, build and upload commands over a local HTTP API",
+		Long: `Serve a local HTTP/JSON API that lists and describes images, generates
+manifests and queues builds and uploads. The progress of a job is streamed
+as server-sent events and the artifacts of a build can be downloaded when
+it is done. The jobs are kept in the cache directory so that a restart of
+the server does not lose their history.`,
+		Example:      "  image-builder serve --concurrency 2\n  curl --unix-socket /run/image-builder.sock http://localhost/api/v1/jobs",
+		RunE:         cmdServe,
+		SilenceUsage: true,
+		Args:         cobra.NoArgs,
+	}
+	serveCmd.Flags().String("socket", defaultServeSocket(), "listen on the given unix socket")
+	serveCmd.Flags().String("listen", "", "listen on the given TCP address instead of a unix socket (e.g. 127.0.0.1:8080)")
+	serveCmd.Flags().Int("concurrency", 1, "maximum number of jobs that run at the same time")
+	serveCmd.Flags().String("cache", defaultCacheDir(), `osbuild directory to cache intermediate build artifacts and the jobs of the server`)
+
+	return serveCmd
+}
+
+func setupVerifyCmd() *cobra.Command {
+	verifyCmd := &cobra.Command{
+		Use:   "verify <output-dir>",
+		Short: "Verify the checksums and signatures of the build outputs in the given directory",
+		Long: `Verify the checksum files and detached signatures that "build" writes with
+--checksum and --sign-key. Gpg signatures are verified with the local
+keyring unless --key is given, sigstore signatures need the public key
+given via --key.`,
+		Example:      "  image-builder verify ./centos-9-qcow2-x86_64 --key cosign.pub",
+		RunE:         cmdVerify,
+		SilenceUsage: true,
+		Args:         cobra.ExactArgs(1),
+	}
+	verifyCmd.Flags().String("key", "", "verify the signatures with the given gpg key (id or armored key file) or sigstore public key file")
+
+	return verifyCmd
+}
+
+func setupInspectCmd() *cobra.Command {
+	inspectCmd := &cobra.Command{
+		Use:   "inspect <image-file>",
+		Short: "Show the partitions, packages and configuration of a built image",
+		Long: `Show the partition table, filesystems, installed packages, kernel command
+line, enabled services and users of a built raw, qcow2, vmdk, vhd or iso
+image. The image is attached read-only to a loop device, this needs root
+privileges. The partition table has the layout of "describe" and the
+customizations the layout of a blueprint so that they can be compared.`,
+		Example:      "  sudo image-builder inspect ./centos-9-qcow2-x86_64/centos-9-qcow2-x86_64.qcow2",
+		RunE:         cmdInspect,
+		SilenceUsage: true,
+		Args:         cobra.ExactArgs(1),
+	}
+	inspectCmd.Flags().String("format", "", "Output in a specific format (yaml, json)")
+	inspectCmd.Flags().String("cache", defaultCacheDir(), `directory for the raw copy of non-raw images`)
+
+	return inspectCmd
+}
+
+func setupBootTestCmd() *cobra.Command {
+	bootTestCmd := &cobra.Command{
+		Use:   "boot-test <image-file>",
+		Short: "Boot the given image in QEMU and check it against its build configuration",
+		Long: `Boot the given image in QEMU (with UEFI or BIOS depending on the image type)
+and run check-host-config in it to validate the customizations of the
+blueprint or build config that produced the image. An ssh key is injected
+via a cloud-init seed and a systemd credential, the image file itself is
+not modified. KVM is used when available, otherwise the image is emulated.
+
+The image type is detected from the default output name of "build"
+unless --distro, --type and --arch are given.`,
+		Example:      "  image-builder boot-test ./centos-9-qcow2-x86_64/centos-9-qcow2-x86_64.qcow2 --blueprint ./config.toml --check-host-config ./check-host-config",
+		RunE:         cmdBootTest,
+		SilenceUsage: true,
+		Args:         cobra.ExactArgs(1),
+	}
+	bootTestCmd.Flags().String("distro", "", `distribution of the image (e.g. centos-9)`)
+	bootTestCmd.Flags().String("type", "", `image type of the image (e.g. qcow2)`)
+	bootTestCmd.Flags().String("arch", "", `architecture of the image`)
+	bootTestCmd.Flags().String("blueprint", "", `blueprint that was used to build the image`)
+	bootTestCmd.Flags().String("config", "", `build config (as used by check-host-config) that was used to build the image`)
+	bootTestCmd.Flags().String("check-host-config", "", `path to the check-host-config binary for the architecture of the image, defaults to the one in $PATH`)
+	bootTestCmd.Flags().String("ssh-key", "", `private ssh key to inject (the public key is read from <key>.pub), defaults to a temporary key`)
+	bootTestCmd.Flags().Duration("timeout", 15*time.Minute, `time to wait for the image to boot`)
+	bootTestCmd.Flags().String("memory", "2048", `memory of the virtual machine in MiB`)
+	bootTestCmd.Flags().String("serial-log", "", `write the serial console to the given file, defaults to <image>.serial.log`)
+
+	return bootTestCmd
+}
+
 func setupUploadCmd() *cobra.Command {
 	uploadCmd := &cobra.Command{
 		Use:          "upload <image-path>",
@@ -247,6 +449,8 @@ func setupUploadCmd() *cobra.Command {
 	uploadCmd.Flags().String("aws-profile", "", "name of the AWS credentials profile (only for type=aws)")
 	uploadCmd.Flags().StringArray("aws-tag", []string{}, "tag the AMI with this Key=Value (only for type=aws)")
 	uploadCmd.Flags().String("aws-boot-mode", "", "boot mode for the AMI: legacy-bios, uefi, uefi-preferred (only for type=aws)")
+	uploadCmd.Flags().StringArray("aws-copy-to-region", nil, "copy the AMI to this additional region (only for type=aws)")
+	uploadCmd.Flags().StringArray("aws-share-with", nil, "share the AMI with this account ID or organization ARN (only for type=aws)")
 	uploadCmd.Flags().String("libvirt-connection", "", "connection URI (only for type=libvirt)")
 	uploadCmd.Flags().String("libvirt-pool", "", "pool name (only for type=libvirt)")
 	uploadCmd.Flags().String("libvirt-volume", "", "volume name (only for type=libvirt)")
@@ -262,7 +466,52 @@ func setupUploadCmd() *cobra.Command {
 	uploadCmd.Flags().String("azure-subscription", "", "Azure subscription ID (only for type=azure)")
 	uploadCmd.Flags().String("azure-resource-group", "", "Azure resource group (only for type=azure)")
 	uploadCmd.Flags().String("azure-image-name", "", "name for the uploaded image (only for type=azure)")
+	uploadCmd.Flags().String("azure-gallery", "", "publish the image into this existing Azure Compute Gallery (only for type=azure)")
+	uploadCmd.Flags().String("azure-gallery-image-definition", "", "existing image definition in the gallery to publish into (only for type=azure)")
+	uploadCmd.Flags().String("azure-gallery-version", "", "version of the published gallery image, e.g. 1.0.0 (only for type=azure)")
+	uploadCmd.Flags().StringArray("azure-gallery-region", nil, "replicate the gallery image version to this region (only for type=azure)")
+	uploadCmd.Flags().String("gcp-bucket", "", "target Cloud Storage bucket name for intermediate storage when importing the image (only for type=gcp)")
+	uploadCmd.Flags().String("gcp-image-name", "", "name for the imported image (only for type=gcp)")
+	uploadCmd.Flags().String("gcp-credentials", "", "path to a file with service account credentials, defaults to $GOOGLE_APPLICATION_CREDENTIALS (only for type=gcp)")
+	uploadCmd.Flags().StringArray("gcp-region", []string{}, "store the image in the given region, defaults to the region of the bucket (only for type=gcp)")
+	uploadCmd.Flags().StringArray("gcp-share-with", []string{}, "share the image with this account, e.g. user:alice@example.com (only for type=gcp)")
+	uploadCmd.Flags().String("oci-compartment", "", "compartment ID of the created image (only for type=oci)")
+	uploadCmd.Flags().String("oci-bucket", "", "target bucket name for intermediate storage when creating the image (only for type=oci)")
+	uploadCmd.Flags().String("oci-namespace", "", "namespace of the target bucket (only for type=oci)")
+	uploadCmd.Flags().String("oci-image-name", "", "name for the created image (only for type=oci)")
+	uploadCmd.Flags().String("vsphere-host", "", "vSphere server host name (only for type=vsphere)")
+	uploadCmd.Flags().String("vsphere-username", "", "vSphere user name (only for type=vsphere)")
+	uploadCmd.Flags().String("vsphere-password", "", "vSphere password (only for type=vsphere)")
+	uploadCmd.Flags().String("vsphere-datacenter", "", "target datacenter (only for type=vsphere)")
+	uploadCmd.Flags().String("vsphere-cluster", "", "target cluster, its resource pool is used for the import (only for type=vsphere)")
+	uploadCmd.Flags().String("vsphere-datastore", "", "target datastore (only for type=vsphere)")
+	uploadCmd.Flags().String("vsphere-folder", "", "target VM folder, defaults to the datacenter VM folder (only for type=vsphere)")
+	uploadCmd.Flags().String("vsphere-template-name", "", "name for the registered VM template (only for type=vsphere)")
+	uploadCmd.Flags().Bool("vsphere-insecure", false, "do not verify the vSphere server certificate (only for type=vsphere)")
+	uploadCmd.Flags().String("s3-endpoint", "", "URL of an S3-compatible object storage, defaults to AWS S3 (only for type=s3)")
+	uploadCmd.Flags().String("s3-region", "us-east-1", "region of the S3 bucket (only for type=s3)")
+	uploadCmd.Flags().String("s3-bucket", "", "target bucket name (only for type=s3)")
+	uploadCmd.Flags().String("s3-key", "", "object key for the uploaded image, defaults to the image file name (only for type=s3)")
+	uploadCmd.Flags().String("s3-profile", "", "name of the AWS credentials profile (only for type=s3)")
+	uploadCmd.Flags().String("s3-ca-bundle", "", "path to a PEM file with additional CA certificates to trust (only for type=s3)")
+	uploadCmd.Flags().Bool("s3-insecure", false, "do not verify the server certificate (only for type=s3)")
+	uploadCmd.Flags().Bool("s3-path-style", false, "use path-style addressing, needed by most S3-compatible storage like MinIO (only for type=s3)")
+	var s3PartSize datasizes.Size
+	uploadCmd.Flags().TextVar(&s3PartSize, "s3-part-size", s3PartSize, "part size for multipart uploads, at least 5 MiB (e.g. 64 MiB) (only for type=s3)")
+	uploadCmd.Flags().Bool("s3-presign", false, "generate a presigned download URL that is valid for 7 days (only for type=s3)")
+	uploadCmd.Flags().String("registry-auth-file", "", "path to a containers-auth.json file, defaults to $REGISTRY_AUTH_FILE (only for type=registry)")
+	uploadCmd.Flags().Bool("registry-insecure", false, "do not verify the registry certificate (only for type=registry)")
+	uploadCmd.Flags().String("koji-server", "", "URL of the koji hub, e.g. https://koji.example.com/kojihub (only for type=koji)")
+	uploadCmd.Flags().String("koji-name", "", "name of the koji build, defaults to the blueprint name (only for type=koji)")
+	uploadCmd.Flags().String("koji-version", "", "version of the koji build, defaults to the blueprint version (only for type=koji)")
+	uploadCmd.Flags().String("koji-release", "", "release of the koji build (only for type=koji)")
+	uploadCmd.Flags().String("koji-principal", "", "kerberos principal for the koji login, defaults to the credentials cache (only for type=koji)")
+	uploadCmd.Flags().String("koji-keytab", "", "kerberos keytab for the koji login (only for type=koji)")
+	uploadCmd.Flags().String("koji-manifest", "", "osbuild manifest to import together with the image (only for type=koji)")
+	uploadCmd.Flags().String("koji-buildlog", "", "buildlog to import together with the image (only for type=koji)")
+	uploadCmd.Flags().StringArray("koji-sbom", []string{}, "SBOM document to import together with the image (only for type=koji)")
 	uploadCmd.Flags().String("arch", "", "upload for the given architecture")
+	uploadCmd.Flags().String("distro", "", "distribution of the image, used e.g. to select the GCP guest OS features")
 	uploadCmd.Flags().String("format", "", "output in a specific format (yaml, json)")
 
 	return uploadCmd
@@ -270,11 +519,17 @@ func setupUploadCmd() *cobra.Command {
 
 func setupBuildCmd() (*cobra.Command, error) {
 	buildCmd := &cobra.Command{
-		Use:          "build <image-type>",
-		Short:        "Build the given image-type, e.g. qcow2 (tip: combine with --distro, --arch)",
+		Use:          "build <image-type> [<image-type>...]",
+		Short:        "Build the given image-types, e.g. qcow2 (tip: combine with --distro, --arch)",
 		RunE:         cmdBuild,
 		SilenceUsage: true,
-		Args:         cobra.ExactArgs(1),
+		Args: func(cmd *cobra.Command, args []string) error {
+			// the image types are part of the plan
+			if cmd.Flags().Changed("plan") {
+				return cobra.NoArgs(cmd, args)
+			}
+			return cobra.MinimumNArgs(1)(cmd, args)
+		},
 	}
 	buildCmd.Flags().Bool("with-manifest", false, `export osbuild manifest`)
 	buildCmd.Flags().Bool("with-buildlog", false, `export osbuild buildlog`)
@@ -286,6 +541,14 @@ func setupBuildCmd() (*cobra.Command, error) {
 	buildCmd.Flags().String("output-name", "", "set specific output basename")
 	buildCmd.Flags().Bool("in-vm", false, `run the osbuild pipeline in a virtual machine`)
 	buildCmd.Flags().String("format", "", "Output in a specific format (json)")
+	buildCmd.Flags().StringArray("to", nil, "upload to the given target instead of the default cloud of the image type, can be given multiple times (e.g. registry://quay.io/example/image:tag)")
+	buildCmd.Flags().String("targets-file", "", "read additional upload targets from the given file, one per line")
+	buildCmd.Flags().String("upload-results", "", "write the upload result of every target as a JSON array to the given file")
+	buildCmd.Flags().String("sources-bundle", "", `build with the sources of the given bundle (see "image-builder fetch") instead of downloading them`)
+	buildCmd.Flags().String("plan", "", "build the matrix of images described in the given YAML or TOML plan file")
+	buildCmd.Flags().StringArray("checksum", nil, "write a checksum file for the image, manifest, SBOMs and buildlog with the given algorithm (sha256, sha512), can be given multiple times")
+	buildCmd.Flags().String("sign-key", "", "create detached signatures of the image, manifest, SBOMs and buildlog with the given gpg key (id or armored key file) or sigstore key file")
+	buildCmd.Flags().Bool("verify-reproducible", false, "build the image twice in separate stores and report the files that differ between the builds")
 	// hide this flag for now, this is only relevant for cockpit-image-builder
 	buildCmd.Flags().Bool("with-upload-result", false, `export upload result`)
 	if err := buildCmd.Flags().MarkHidden("with-upload-result"); err != nil {
@@ -295,6 +558,25 @@ func setupBuildCmd() (*cobra.Command, error) {
 	return buildCmd, nil
 }
 
+// setupBuildCmdWithFlags returns the build command together with
+// the flags of the manifest and upload commands
+func setupBuildCmdWithFlags() (*cobra.Command, error) {
+	manifestCmd, err := setupManifestCmd()
+	if err != nil {
+		return nil, err
+	}
+	uploadCmd := setupUploadCmd()
+	buildCmd, err := setupBuildCmd()
+	if err != nil {
+		return nil, err
+	}
+	buildCmd.Flags().AddFlagSet(manifestCmd.Flags())
+	// The build command can upload images to the appropriate cloud provider,
+	// so it should support the upload options as well
+	buildCmd.Flags().AddFlagSet(uploadCmd.Flags())
+	return buildCmd, nil
+}
+
 func setupDescribeCmd() *cobra.Command {
 	// XXX: add --format=json too?
 	describeCmd := &cobra.Command{
@@ -330,6 +612,178 @@ func setupPkgSearchCmd() *cobra.Command {
 	return pkgSearchCmd
 }
 
+func setupReposCmd() *cobra.Command {
+	reposCmd := &cobra.Command{
+		Use:   "repos",
+		Short: "Show the repositories used for the given distro (tip: combine with --distro, --arch, --type)",
+		Long: `Show the repositories that are used to build images for the given distro
+and architecture, including the repositories of --extra-repo and
+--force-repo. With --type only the repositories for the given image type
+are shown. With --check the repomd.xml of every repository is fetched
+(via its baseurl, metalink or mirrorlist) and its gpg keys are verified,
+the latency and the age of the metadata are reported.`,
+		Example:      "  image-builder repos --distro fedora-43 --type qcow2 --check",
+		RunE:         cmdRepos,
+		SilenceUsage: true,
+		Args:         cobra.NoArgs,
+	}
+	reposCmd.Flags().String("distro", "", `show the repositories of the given distro (e.g. centos-9)`)
+	reposCmd.Flags().String("arch", "", `show the repositories of the given architecture`)
+	reposCmd.Flags().String("type", "", `show only the repositories for the given image type (e.g. qcow2)`)
+	reposCmd.Flags().String("format", "", "Output in a specific format (yaml, json)")
+	reposCmd.Flags().Bool("check", false, `fetch the metadata and gpg keys of every repository`)
+	reposCmd.Flags().Duration("timeout", 30*time.Second, `timeout for every request of --check`)
+
+	return reposCmd
+}
+
+func setupCacheCmd() *cobra.Command {
+	cacheCmd := &cobra.Command{
+		Use:   "cache",
+		Short: "List and prune the osbuild store and the rpm metadata cache",
+		Long: `List and prune the objects and sources of the osbuild store (--cache of
+"build") and the repository metadata of the rpm metadata cache
+(--rpmmd-cache of "build" and "manifest").`,
+		Args: cobra.NoArgs,
+	}
+	cacheCmd.PersistentFlags().String("cache", defaultCacheDir(), `osbuild directory to cache intermediate build artifacts`)
+	cacheCmd.PersistentFlags().String("rpmmd-cache", "", `osbuild directory to cache rpm metadata`)
+	cacheCmd.PersistentFlags().String("format", "", "Output in a specific format (yaml, json)")
+
+	cacheListCmd := &cobra.Command{
+		Use:          "list",
+		Short:        "List the entries of the caches with their size and the time they were last used",
+		RunE:         cmdCacheList,
+		SilenceUsage: true,
+		Args:         cobra.NoArgs,
+	}
+	cacheCmd.AddCommand(cacheListCmd)
+
+	cachePruneCmd := &cobra.Command{
+		Use:   "prune",
+		Short: "Remove the least recently used entries of the caches",
+		Long: `Remove the entries of the caches that were not used within --older-than
+and then the least recently used entries until both caches together are
+smaller than --max-size.`,
+		Example:      "  image-builder cache prune --older-than 30d --max-size \"20 GiB\" --format json",
+		RunE:         cmdCachePrune,
+		SilenceUsage: true,
+		Args:         cobra.NoArgs,
+	}
+	cachePruneCmd.Flags().String("older-than", "", `remove entries that were not used within the given time (e.g. 30d or 12h)`)
+	var maxSize datasizes.Size
+	cachePruneCmd.Flags().TextVar(&maxSize, "max-size", maxSize, `remove the least recently used entries until the caches are smaller than the given size (e.g. 20 GiB)`)
+	cachePruneCmd.Flags().Bool("dry-run", false, `only show what would be removed`)
+	cacheCmd.AddCommand(cachePruneCmd)
+
+	cacheCleanCmd := &cobra.Command{
+		Use:   "clean",
+		Short: "Remove all entries of the caches",
+		Long: `Remove all entries of the caches. With --distro only the repository
+metadata of the given distro is removed, the objects of the osbuild
+store are not specific to a distro.`,
+		Example:      "  image-builder cache clean --distro fedora-42",
+		RunE:         cmdCacheClean,
+		SilenceUsage: true,
+		Args:         cobra.NoArgs,
+	}
+	cacheCleanCmd.Flags().String("distro", "", `only remove the repository metadata of the given distro (e.g. centos-9)`)
+	cacheCleanCmd.Flags().Bool("dry-run", false, `only show what would be removed`)
+	cacheCmd.AddCommand(cacheCleanCmd)
+
+	return cacheCmd
+}
+
+func setupBlueprintCmd() *cobra.Command {
+	blueprintCmd := &cobra.Command{
+		Use:   "blueprint",
+		Short: "Work with blueprints",
+		Args:  cobra.NoArgs,
+	}
+
+	blueprintValidateCmd := &cobra.Command{
+		Use:   "validate <image-type> <blueprint>",
+		Short: "Check a blueprint against an image type without building it",
+		Long: `Check a blueprint against an image type without building it. The
+blueprint is checked for options that the image type does not support or
+requires, for invalid partitioning, for paths of files, directories and
+mountpoints that are not allowed and for conflicting users and groups.
+All problems are reported at once with their line in the blueprint and a
+hint how to fix them. No network access is needed.`,
+		Example:      "  image-builder blueprint validate --distro centos-9 qcow2 ./config.toml",
+		RunE:         cmdBlueprintValidate,
+		SilenceUsage: true,
+		Args:         cobra.ExactArgs(2),
+	}
+	blueprintValidateCmd.Flags().String("distro", "", `validate for the given distro (e.g. centos-9), defaults to the distro of the blueprint or the host`)
+	blueprintValidateCmd.Flags().String("arch", "", `validate for the given architecture, defaults to the host architecture`)
+	blueprintValidateCmd.Flags().String("format", "", "Output in a specific format (text, yaml, json)")
+	blueprintCmd.AddCommand(blueprintValidateCmd)
+
+	blueprintFromKickstartCmd := &cobra.Command{
+		Use:   "from-kickstart <kickstart>",
+		Short: "Convert a kickstart file to a blueprint",
+		Long: `Convert a kickstart file to a blueprint. The packages, users, groups,
+ssh keys, timezone, language, keyboard, services, firewall, hostname,
+kernel arguments and partitioning (part, volgroup and logvol) of the
+kickstart are converted. The blueprint is written to stdout and every
+directive that cannot be converted is listed on stderr.`,
+		Example:      "  image-builder blueprint from-kickstart ./ks.cfg > config.toml",
+		RunE:         cmdBlueprintFromKickstart,
+		SilenceUsage: true,
+		Args:         cobra.ExactArgs(1),
+	}
+	blueprintFromKickstartCmd.Flags().String("format", "", "Output in a specific format (toml, json)")
+	blueprintFromKickstartCmd.Flags().String("name", "", `Name of the blueprint, defaults to the name of the kickstart file`)
+	blueprintCmd.AddCommand(blueprintFromKickstartCmd)
+
+	blueprintFromHostCmd := &cobra.Command{
+		Use:   "from-host",
+		Short: "Generate a blueprint from the running system",
+		Long: `Generate a blueprint that reproduces the running system. The blueprint
+contains the packages that were installed on purpose (packages that no
+other package requires and that are not installed by the depsolved
+default package set of the image type), the services that are enabled, disabled or masked
+differently than the presets, the users and groups, the timezone,
+locale, firewall, kernel arguments and the filesystem layout. Run it as
+root to read the complete configuration.`,
+		Example:      "  sudo image-builder blueprint from-host --image-type qcow2 > config.toml",
+		RunE:         cmdBlueprintFromHost,
+		SilenceUsage: true,
+		Args:         cobra.NoArgs,
+	}
+	blueprintFromHostCmd.Flags().String("image-type", "qcow2", `image type whose default packages are left out of the blueprint`)
+	blueprintFromHostCmd.Flags().String("distro", "", `distro of the image type, defaults to the distro of the host`)
+	blueprintFromHostCmd.Flags().String("arch", "", `architecture of the image type, defaults to the host architecture`)
+	blueprintFromHostCmd.Flags().String("name", "", `Name of the blueprint, defaults to "from-host"`)
+	blueprintFromHostCmd.Flags().String("format", "", "Output in a specific format (toml, json)")
+	blueprintFromHostCmd.Flags().String("rpmmd-cache", "", `osbuild directory to cache rpm metadata`)
+	blueprintCmd.AddCommand(blueprintFromHostCmd)
+
+	blueprintFromImageCmd := &cobra.Command{
+		Use:   "from-image <image-file>",
+		Short: "Generate a blueprint from an existing disk image",
+		Long: `Generate a blueprint that reproduces the system of an existing disk
+image. The same packages and configuration as with "from-host" are read
+from the image, the filesystem layout comes from its partition table.
+The image is attached and mounted read-only so this needs root.`,
+		Example:      "  sudo image-builder blueprint from-image ./disk.qcow2 > config.toml",
+		RunE:         cmdBlueprintFromImage,
+		SilenceUsage: true,
+		Args:         cobra.ExactArgs(1),
+	}
+	blueprintFromImageCmd.Flags().String("image-type", "qcow2", `image type whose default packages are left out of the blueprint`)
+	blueprintFromImageCmd.Flags().String("distro", "", `distro of the image type, defaults to the distro of the image`)
+	blueprintFromImageCmd.Flags().String("arch", "", `architecture of the image type, defaults to the architecture of the image`)
+	blueprintFromImageCmd.Flags().String("name", "", `Name of the blueprint, defaults to the name of the image file`)
+	blueprintFromImageCmd.Flags().String("format", "", "Output in a specific format (toml, json)")
+	blueprintFromImageCmd.Flags().String("rpmmd-cache", "", `osbuild directory to cache rpm metadata`)
+	blueprintFromImageCmd.Flags().String("cache", defaultCacheDir(), `directory for the raw copy of non-raw images`)
+	blueprintCmd.AddCommand(blueprintFromImageCmd)
+
+	return blueprintCmd
+}
+
 func setupDocCmd(rootCmd *cobra.Command) *cobra.Command {
 	docCmd := &cobra.Command{
 		Use:    "doc <output-dir>",
@@ -16,6 +16,7 @@ import (
 	"github.com/osbuild/image-builder/pkg/imagefilter"
 	"github.com/osbuild/image-builder/pkg/manifest"
 	"github.com/osbuild/image-builder/pkg/ostree"
+	"github.com/osbuild/image-builder/pkg/rpmmd"
 )
 
 // Use yaml output by default because it is both nicely human and
@@ -52,7 +53,10 @@ type blueprintYAML struct {
 	RequiredOptions  []string `yaml:"required_options,omitempty"`
 }
 
-func dummyManifestFor(imgType distro.ImageType) (*manifest.Manifest, error) {
+// dummyManifestFor returns the manifest of the image type without
+// customizations, the repositories are only needed to depsolve its
+// package sets
+func dummyManifestFor(imgType distro.ImageType, repos []rpmmd.RepoConfig) (*manifest.Manifest, error) {
 	var bp blueprint.Blueprint
 	// XXX: '*-simplified-installer' images require the installation device to be specified as a BP customization.
 	// Workaround this for now by setting a dummy device. We should ideally have a way to get image type pkg sets
@@ -71,7 +75,7 @@ func dummyManifestFor(imgType distro.ImageType) (*manifest.Manifest, error) {
 		}
 	}
 
-	manifest, _, err := imgType.Manifest(&bp, imgOpts, nil, nil)
+	manifest, _, err := imgType.Manifest(&bp, imgOpts, repos, nil)
 	if err != nil {
 		return nil, err
 	}
@@ -79,7 +83,7 @@ func dummyManifestFor(imgType distro.ImageType) (*manifest.Manifest, error) {
 }
 
 func packageSetsFor(imgType distro.ImageType) (map[string]*packagesYAML, error) {
-	manifest, err := dummyManifestFor(imgType)
+	manifest, err := dummyManifestFor(imgType, nil)
 	if err != nil {
 		return nil, err
 	}
@@ -133,7 +137,7 @@ func describeImage(img *imagefilter.Result, out io.Writer) error {
 	if err != nil && !errors.Is(err, defs.ErrNoPartitionTableForImgType) {
 		return err
 	}
-	m, err := dummyManifestFor(img.ImgType)
+	m, err := dummyManifestFor(img.ImgType, nil)
 	if err != nil {
 		return err
 	}
@@ -0,0 +1,759 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+
+	"github.com/spf13/cobra"
+
+	"github.com/osbuild/image-builder/pkg/osbuild"
+	"github.com/osbuild/image-builder/pkg/progress"
+	"github.com/osbuild/image-builder/pkg/rpmmd"
+)
+
+// diffImage is the content of a single image that can be compared
+type diffImage struct {
+	name string
+	// pipelines in the order of the manifest
+	pipelines []string
+	packages  map[string]rpmmd.PackageList
+	// stages is nil when the image comes from a lockfile
+	stages map[string][]diffStage
+	// withoutEpochs is set when the packages come from a manifest,
+	// the package filenames have no epoch
+	withoutEpochs bool
+}
+
+type diffStage struct {
+	Type    string          `json:"type"`
+	Inputs  json.RawMessage `json:"inputs,omitempty"`
+	Options json.RawMessage `json:"options,omitempty"`
+}
+
+// diffManifest is the subset of an osbuild manifest that is needed
+// to compare images, the stage options stay raw as there are no
+// unmarshallers for all of them
+type diffManifest struct {
+	Pipelines []struct {
+		Name   string      `json:"name"`
+		Stages []diffStage `json:"stages"`
+	} `json:"pipelines"`
+	Sources map[string]json.RawMessage `json:"sources"`
+}
+
+// packageFromFilename returns the package for a filename like
+// "tmux-3.2a-4.el9.x86_64.rpm", the epoch is not part of the
+// filename and is left unset
+func packageFromFilename(filename string) (rpmmd.Package, error) {
+	nvra := strings.TrimSuffix(filepath.Base(filename), ".rpm")
+	dot := strings.LastIndex(nvra, ".")
+	if dot < 0 {
+		return rpmmd.Package{}, fmt.Errorf("cannot parse package filename %q", filename)
+	}
+	nvr, arch := nvra[:dot], nvra[dot+1:]
+	l := strings.Split(nvr, "-")
+	if len(l) < 3 {
+		return rpmmd.Package{}, fmt.Errorf("cannot parse package filename %q", filename)
+	}
+	return rpmmd.Package{
+		Name:    strings.Join(l[:len(l)-2], "-"),
+		Version: l[len(l)-2],
+		Release: l[len(l)-1],
+		Arch:    arch,
+	}, nil
+}
+
+// packageFilenames returns the filename of every rpm in the curl and
+// librepo sources of the manifest keyed by their checksum
+func (mf *diffManifest) packageFilenames() (map[string]string, error) {
+	filenames := make(map[string]string)
+	if raw, ok := mf.Sources[osbuild.SourceNameCurl]; ok {
+		var curl osbuild.CurlSource
+		if err := json.Unmarshal(raw, &curl); err != nil {
+			return nil, err
+		}
+		for checksum, item := range curl.Items {
+			switch it := item.(type) {
+			case osbuild.URL:
+				filenames[checksum] = string(it)
+			case osbuild.CurlSourceOptions:
+				filenames[checksum] = it.URL
+			}
+		}
+	}
+	if raw, ok := mf.Sources[osbuild.SourceNameLibrepo]; ok {
+		var librepo osbuild.LibrepoSource
+		if err := json.Unmarshal(raw, &librepo); err != nil {
+			return nil, err
+		}
+		for checksum, item := range librepo.Items {
+			filenames[checksum] = item.Path
+		}
+	}
+	return filenames, nil
+}
+
+// rpmStageChecksums returns the checksums of the packages that are
+// installed by the given rpm stage
+func rpmStageChecksums(stage diffStage) ([]string, error) {
+	var inputs struct {
+		Packages struct {
+			References json.RawMessage `json:"references"`
+		} `json:"packages"`
+	}
+	if err := json.Unmarshal(stage.Inputs, &inputs); err != nil {
+		return nil, err
+	}
+	refs := inputs.Packages.References
+
+	// references are either a plain list of checksums, a list of
+	// objects with an id or an object keyed by the checksum
+	var plain []string
+	if err := json.Unmarshal(refs, &plain); err == nil {
+		return plain, nil
+	}
+	var array []struct {
+		ID string `json:"id"`
+	}
+	if err := json.Unmarshal(refs, &array); err == nil {
+		var checksums []string
+		for _, ref := range array {
+			checksums = append(checksums, ref.ID)
+		}
+		return checksums, nil
+	}
+	var object map[string]json.RawMessage
+	if err := json.Unmarshal(refs, &object); err != nil {
+		return nil, fmt.Errorf("cannot parse package references of %s stage", stage.Type)
+	}
+	checksums := make([]string, 0, len(object))
+	for checksum := range object {
+		checksums = append(checksums, checksum)
+	}
+	slices.Sort(checksums)
+	return checksums, nil
+}
+
+func diffImageFromManifest(name string, data []byte) (*diffImage, error) {
+	var mf diffManifest
+	if err := json.Unmarshal(data, &mf); err != nil {
+		return nil, fmt.Errorf("cannot parse manifest %s: %w", name, err)
+	}
+	filenames, err := mf.packageFilenames()
+	if err != nil {
+		return nil, fmt.Errorf("cannot parse sources of manifest %s: %w", name, err)
+	}
+
+	img := &diffImage{
+		name:          name,
+		packages:      make(map[string]rpmmd.PackageList),
+		stages:        make(map[string][]diffStage),
+		withoutEpochs: true,
+	}
+	for _, pl := range mf.Pipelines {
+		img.pipelines = append(img.pipelines, pl.Name)
+		img.stages[pl.Name] = pl.Stages
+		for _, stage := range pl.Stages {
+			if stage.Type != "org.osbuild.rpm" {
+				continue
+			}
+			checksums, err := rpmStageChecksums(stage)
+			if err != nil {
+				return nil, err
+			}
+			for _, checksum := range checksums {
+				filename, ok := filenames[checksum]
+				if !ok {
+					return nil, fmt.Errorf("cannot find source for package %s in manifest %s", checksum, name)
+				}
+				pkg, err := packageFromFilename(filename)
+				if err != nil {
+					return nil, err
+				}
+				img.packages[pl.Name] = append(img.packages[pl.Name], pkg)
+			}
+		}
+	}
+	return img, nil
+}
+
+func diffImagesFromLockfile(path string) ([]*diffImage, error) {
+	lf, err := readLockfile(path)
+	if err != nil {
+		return nil, err
+	}
+	var imgs []*diffImage
+	for name, locked := range lf.Images {
+		img := &diffImage{
+			name:     name,
+			packages: make(map[string]rpmmd.PackageList),
+		}
+		for plName, res := range locked.Depsolved {
+			img.pipelines = append(img.pipelines, plName)
+			img.packages[plName] = res.Transactions.AllPackages()
+		}
+		slices.Sort(img.pipelines)
+		imgs = append(imgs, img)
+	}
+	slices.SortFunc(imgs, func(a, b *diffImage) int { return strings.Compare(a.name, b.name) })
+	return imgs, nil
+}
+
+// imageSpec describes an image that is generated for the diff, e.g.
+// "distro=centos-9,type=qcow2,blueprint=./config.toml"
+type imageSpec map[string]string
+
+func parseImageSpec(s string) (imageSpec, error) {
+	spec := make(imageSpec)
+	for _, kv := range strings.Split(s, ",") {
+		k, v, ok := strings.Cut(kv, "=")
+		if !ok || v == "" {
+			return nil, fmt.Errorf("invalid image spec %q: expected key=value, got %q", s, kv)
+		}
+		if !slices.Contains([]string{"distro", "type", "arch", "blueprint"}, k) {
+			return nil, fmt.Errorf("invalid image spec %q: unknown key %q (supported: distro, type, arch, blueprint)", s, k)
+		}
+		spec[k] = v
+	}
+	if spec["type"] == "" {
+		return nil, fmt.Errorf("invalid image spec %q: missing type", s)
+	}
+	return spec, nil
+}
+
+// diffImageFromSpec generates the manifest for the given spec, the
+// values of the spec override the options given on the commandline
+func diffImageFromSpec(cmd *cobra.Command, spec imageSpec, defaults map[string]string) (*diffImage, error) {
+	for _, k := range []string{"distro", "arch", "blueprint"} {
+		v, ok := spec[k]
+		if !ok {
+			v = defaults[k]
+		}
+		if err := cmd.Flags().Set(k, v); err != nil {
+			return nil, err
+		}
+	}
+	imgs, err := getImages(cmd, []string{spec["type"]})
+	if err != nil {
+		return nil, err
+	}
+	pbar, err := progress.New("", progress.ProgressConfig{})
+	if err != nil {
+		return nil, err
+	}
+	mfs, err := generateManifests(pbar, cmd, imgs, io.Discard, nil)
+	if err != nil {
+		return nil, err
+	}
+	return diffImageFromManifest(basenameFor(imgs[0], ""), mfs[0])
+}
+
+// loadDiffImages loads the images of a manifest, a lockfile or an
+// image spec
+func loadDiffImages(cmd *cobra.Command, arg string, defaults map[string]string) ([]*diffImage, error) {
+	st, err := os.Stat(arg)
+	if err != nil || st.IsDir() {
+		if !strings.Contains(arg, "=") {
+			return nil, fmt.Errorf("cannot use %q: not a manifest, lockfile or image spec (e.g. \"distro=centos-9,type=qcow2\")", arg)
+		}
+		spec, err := parseImageSpec(arg)
+		if err != nil {
+			return nil, err
+		}
+		img, err := diffImageFromSpec(cmd, spec, defaults)
+		if err != nil {
+			return nil, err
+		}
+		return []*diffImage{img}, nil
+	}
+
+	data, err := os.ReadFile(arg)
+	if err != nil {
+		return nil, err
+	}
+	var probe struct {
+		Pipelines json.RawMessage `json:"pipelines"`
+		Images    json.RawMessage `json:"images"`
+	}
+	if err := json.Unmarshal(data, &probe); err != nil {
+		return nil, fmt.Errorf("cannot parse %s: %w", arg, err)
+	}
+	switch {
+	case probe.Pipelines != nil:
+		img, err := diffImageFromManifest(arg, data)
+		if err != nil {
+			return nil, err
+		}
+		return []*diffImage{img}, nil
+	case probe.Images != nil:
+		return diffImagesFromLockfile(arg)
+	default:
+		return nil, fmt.Errorf("%s is neither a manifest nor a lockfile", arg)
+	}
+}
+
+type packageChange struct {
+	Name string `json:"name"`
+	Arch string `json:"arch"`
+	Old  string `json:"old,omitempty"`
+	New  string `json:"new,omitempty"`
+}
+
+type packageDiff struct {
+	Added      []packageChange `json:"added,omitempty"`
+	Removed    []packageChange `json:"removed,omitempty"`
+	Upgraded   []packageChange `json:"upgraded,omitempty"`
+	Downgraded []packageChange `json:"downgraded,omitempty"`
+}
+
+func (pd *packageDiff) empty() bool {
+	return len(pd.Added)+len(pd.Removed)+len(pd.Upgraded)+len(pd.Downgraded) == 0
+}
+
+type optionChange struct {
+	Path string `json:"path"`
+	Old  string `json:"old,omitempty"`
+	New  string `json:"new,omitempty"`
+}
+
+type stageDiff struct {
+	Stage string `json:"stage"`
+	// Status is one of "added", "removed" or "changed"
+	Status  string         `json:"status"`
+	Options []optionChange `json:"options,omitempty"`
+}
+
+type pipelineDiff struct {
+	Name string `json:"name"`
+	// Status is one of "added", "removed" or "changed"
+	Status   string       `json:"status"`
+	Packages *packageDiff `json:"packages,omitempty"`
+	Stages   []stageDiff  `json:"stages,omitempty"`
+}
+
+type imageDiff struct {
+	Old       string         `json:"old,omitempty"`
+	New       string         `json:"new,omitempty"`
+	Pipelines []pipelineDiff `json:"pipelines,omitempty"`
+}
+
+type diffResult struct {
+	Images []imageDiff `json:"images"`
+}
+
+func evr(pkg rpmmd.Package) string {
+	if pkg.Epoch == 0 {
+		return fmt.Sprintf("%s-%s", pkg.Version, pkg.Release)
+	}
+	return fmt.Sprintf("%d:%s-%s", pkg.Epoch, pkg.Version, pkg.Release)
+}
+
+// diffPackages compares the packages by name and architecture,
+// packages that are installed in multiple versions (e.g. kernels)
+// are only reported as added or removed. With ignoreEpochs the
+// packages are compared without their epoch.
+func diffPackages(oldPkgs, newPkgs rpmmd.PackageList, ignoreEpochs bool) *packageDiff {
+	if ignoreEpochs {
+		stripEpochs := func(pkgs rpmmd.PackageList) rpmmd.PackageList {
+			stripped := slices.Clone(pkgs)
+			for i := range stripped {
+				stripped[i].Epoch = 0
+			}
+			return stripped
+		}
+		oldPkgs, newPkgs = stripEpochs(oldPkgs), stripEpochs(newPkgs)
+	}
+	type key struct{ name, arch string }
+	group := func(pkgs rpmmd.PackageList) (map[key]rpmmd.PackageList, []key) {
+		m := make(map[key]rpmmd.PackageList)
+		var keys []key
+		for _, pkg := range pkgs {
+			k := key{pkg.Name, pkg.Arch}
+			if _, ok := m[k]; !ok {
+				keys = append(keys, k)
+			}
+			m[k] = append(m[k], pkg)
+		}
+		return m, keys
+	}
+	oldByKey, oldKeys := group(oldPkgs)
+	newByKey, newKeys := group(newPkgs)
+
+	keys := oldKeys
+	for _, k := range newKeys {
+		if _, ok := oldByKey[k]; !ok {
+			keys = append(keys, k)
+		}
+	}
+	slices.SortFunc(keys, func(a, b key) int {
+		if c := strings.Compare(a.name, b.name); c != 0 {
+			return c
+		}
+		return strings.Compare(a.arch, b.arch)
+	})
+
+	var pd packageDiff
+	for _, k := range keys {
+		olds, news := oldByKey[k], newByKey[k]
+		if len(olds) == 1 && len(news) == 1 {
+			change := packageChange{Name: k.name, Arch: k.arch, Old: evr(olds[0]), New: evr(news[0])}
+			switch c := olds[0].CompareEVR(news[0]); {
+			case c < 0:
+				pd.Upgraded = append(pd.Upgraded, change)
+			case c > 0:
+				pd.Downgraded = append(pd.Downgraded, change)
+			}
+			continue
+		}
+		for _, pkg := range olds {
+			if !slices.ContainsFunc(news, func(p rpmmd.Package) bool { return p.CompareEVR(pkg) == 0 }) {
+				pd.Removed = append(pd.Removed, packageChange{Name: k.name, Arch: k.arch, Old: evr(pkg)})
+			}
+		}
+		for _, pkg := range news {
+			if !slices.ContainsFunc(olds, func(p rpmmd.Package) bool { return p.CompareEVR(pkg) == 0 }) {
+				pd.Added = append(pd.Added, packageChange{Name: k.name, Arch: k.arch, New: evr(pkg)})
+			}
+		}
+	}
+	return &pd
+}
+
+// flattenOptions returns the options as a map of paths to their
+// json encoded values, e.g. "users.alice.groups" -> `["wheel"]`.
+// Lists of objects are flattened by index, lists of plain values are
+// kept as a whole.
+func flattenOptions(prefix string, v any, res map[string]string) {
+	join := func(k string) string {
+		if prefix == "" {
+			return k
+		}
+		return prefix + "." + k
+	}
+	switch val := v.(type) {
+	case map[string]any:
+		for k, sub := range val {
+			flattenOptions(join(k), sub, res)
+		}
+		return
+	case []any:
+		if slices.ContainsFunc(val, func(e any) bool {
+			_, isMap := e.(map[string]any)
+			_, isList := e.([]any)
+			return isMap || isList
+		}) {
+			for i, sub := range val {
+				flattenOptions(fmt.Sprintf("%s[%d]", prefix, i), sub, res)
+			}
+			return
+		}
+	}
+	data, err := json.Marshal(v)
+	if err != nil {
+		// cannot happen, the value was unmarshalled from json
+		panic(err)
+	}
+	res[prefix] = string(data)
+}
+
+func diffStageOptions(oldOpts, newOpts json.RawMessage) ([]optionChange, error) {
+	flatten := func(raw json.RawMessage) (map[string]string, error) {
+		res := make(map[string]string)
+		if len(raw) == 0 {
+			return res, nil
+		}
+		var v any
+		if err := json.Unmarshal(raw, &v); err != nil {
+			return nil, err
+		}
+		flattenOptions("", v, res)
+		return res, nil
+	}
+	oldFlat, err := flatten(oldOpts)
+	if err != nil {
+		return nil, err
+	}
+	newFlat, err := flatten(newOpts)
+	if err != nil {
+		return nil, err
+	}
+
+	var changes []optionChange
+	for path, oldVal := range oldFlat {
+		if newVal := newFlat[path]; newVal != oldVal {
+			changes = append(changes, optionChange{Path: path, Old: oldVal, New: newVal})
+		}
+	}
+	for path, newVal := range newFlat {
+		if _, ok := oldFlat[path]; !ok {
+			changes = append(changes, optionChange{Path: path, New: newVal})
+		}
+	}
+	slices.SortFunc(changes, func(a, b optionChange) int { return strings.Compare(a.Path, b.Path) })
+	return changes, nil
+}
+
+// stageKeys returns a unique key for every stage, stages that are
+// used multiple times in a pipeline get their occurrence appended
+// (e.g. "org.osbuild.mkdir#2")
+func stageKeys(stages []diffStage) []string {
+	seen := make(map[string]int)
+	keys := make([]string, len(stages))
+	for i, stage := range stages {
+		seen[stage.Type]++
+		keys[i] = stage.Type
+		if n := seen[stage.Type]; n > 1 {
+			keys[i] = fmt.Sprintf("%s#%d", stage.Type, n)
+		}
+	}
+	return keys
+}
+
+func diffStages(oldStages, newStages []diffStage) ([]stageDiff, error) {
+	oldKeys, newKeys := stageKeys(oldStages), stageKeys(newStages)
+
+	var diffs []stageDiff
+	for i, key := range newKeys {
+		j := slices.Index(oldKeys, key)
+		if j < 0 {
+			changes, err := diffStageOptions(nil, newStages[i].Options)
+			if err != nil {
+				return nil, fmt.Errorf("cannot compare options of %s: %w", key, err)
+			}
+			diffs = append(diffs, stageDiff{Stage: key, Status: "added", Options: changes})
+			continue
+		}
+		changes, err := diffStageOptions(oldStages[j].Options, newStages[i].Options)
+		if err != nil {
+			return nil, fmt.Errorf("cannot compare options of %s: %w", key, err)
+		}
+		if len(changes) > 0 {
+			diffs = append(diffs, stageDiff{Stage: key, Status: "changed", Options: changes})
+		}
+	}
+	for j, key := range oldKeys {
+		if !slices.Contains(newKeys, key) {
+			changes, err := diffStageOptions(oldStages[j].Options, nil)
+			if err != nil {
+				return nil, fmt.Errorf("cannot compare options of %s: %w", key, err)
+			}
+			diffs = append(diffs, stageDiff{Stage: key, Status: "removed", Options: changes})
+		}
+	}
+	return diffs, nil
+}
+
+func diffImages(oldImg, newImg *diffImage) (*imageDiff, error) {
+	res := &imageDiff{}
+	if oldImg != nil {
+		res.Old = oldImg.name
+	}
+	if newImg != nil {
+		res.New = newImg.name
+	}
+	if oldImg == nil || newImg == nil {
+		return res, nil
+	}
+
+	pipelines := slices.Clone(newImg.pipelines)
+	for _, name := range oldImg.pipelines {
+		if !slices.Contains(pipelines, name) {
+			pipelines = append(pipelines, name)
+		}
+	}
+	for _, name := range pipelines {
+		inOld, inNew := slices.Contains(oldImg.pipelines, name), slices.Contains(newImg.pipelines, name)
+		switch {
+		case !inOld:
+			res.Pipelines = append(res.Pipelines, pipelineDiff{Name: name, Status: "added"})
+			continue
+		case !inNew:
+			res.Pipelines = append(res.Pipelines, pipelineDiff{Name: name, Status: "removed"})
+			continue
+		}
+
+		pd := pipelineDiff{Name: name, Status: "changed"}
+		// only compare epochs if both sides have them
+		ignoreEpochs := oldImg.withoutEpochs || newImg.withoutEpochs
+		if pkgs := diffPackages(oldImg.packages[name], newImg.packages[name], ignoreEpochs); !pkgs.empty() {
+			pd.Packages = pkgs
+		}
+		// lockfiles have no stages
+		if oldImg.stages != nil && newImg.stages != nil {
+			stages, err := diffStages(oldImg.stages[name], newImg.stages[name])
+			if err != nil {
+				return nil, err
+			}
+			pd.Stages = stages
+		}
+		if pd.Packages != nil || len(pd.Stages) > 0 {
+			res.Pipelines = append(res.Pipelines, pd)
+		}
+	}
+	return res, nil
+}
+
+// diffImageSets compares two sets of images, single images are
+// always compared with each other, otherwise images are matched by
+// name
+func diffImageSets(olds, news []*diffImage) (*diffResult, error) {
+	res := &diffResult{}
+	if len(olds) == 1 && len(news) == 1 {
+		d, err := diffImages(olds[0], news[0])
+		if err != nil {
+			return nil, err
+		}
+		res.Images = append(res.Images, *d)
+		return res, nil
+	}
+
+	find := func(imgs []*diffImage, name string) *diffImage {
+		for _, img := range imgs {
+			if img.name == name {
+				return img
+			}
+		}
+		return nil
+	}
+	for _, oldImg := range olds {
+		d, err := diffImages(oldImg, find(news, oldImg.name))
+		if err != nil {
+			return nil, err
+		}
+		res.Images = append(res.Images, *d)
+	}
+	for _, newImg := range news {
+		if find(olds, newImg.name) == nil {
+			d, err := diffImages(nil, newImg)
+			if err != nil {
+				return nil, err
+			}
+			res.Images = append(res.Images, *d)
+		}
+	}
+	return res, nil
+}
+
+func (res *diffResult) empty() bool {
+	for _, img := range res.Images {
+		if img.Old == "" || img.New == "" || len(img.Pipelines) > 0 {
+			return false
+		}
+	}
+	return true
+}
+
+func writeDiffText(w io.Writer, res *diffResult) error {
+	var buf bytes.Buffer
+	if res.empty() {
+		fmt.Fprintln(&buf, "No differences")
+	}
+	for _, img := range res.Images {
+		switch {
+		case img.Old == "":
+			fmt.Fprintf(&buf, "image %s added\n", img.New)
+			continue
+		case img.New == "":
+			fmt.Fprintf(&buf, "image %s removed\n", img.Old)
+			continue
+		case len(img.Pipelines) == 0:
+			continue
+		}
+		fmt.Fprintf(&buf, "--- %s\n+++ %s\n", img.Old, img.New)
+		for _, pl := range img.Pipelines {
+			if pl.Status != "changed" {
+				fmt.Fprintf(&buf, "pipeline %s %s\n", pl.Name, pl.Status)
+				continue
+			}
+			fmt.Fprintf(&buf, "pipeline %s:\n", pl.Name)
+			if pkgs := pl.Packages; pkgs != nil {
+				for _, c := range pkgs.Added {
+					fmt.Fprintf(&buf, "  + %s-%s.%s\n", c.Name, c.New, c.Arch)
+				}
+				for _, c := range pkgs.Removed {
+					fmt.Fprintf(&buf, "  - %s-%s.%s\n", c.Name, c.Old, c.Arch)
+				}
+				for _, c := range pkgs.Upgraded {
+					fmt.Fprintf(&buf, "  ^ %s.%s %s -> %s\n", c.Name, c.Arch, c.Old, c.New)
+				}
+				for _, c := range pkgs.Downgraded {
+					fmt.Fprintf(&buf, "  v %s.%s %s -> %s\n", c.Name, c.Arch, c.Old, c.New)
+				}
+			}
+			for _, st := range pl.Stages {
+				if st.Status == "changed" {
+					fmt.Fprintf(&buf, "  stage %s:\n", st.Stage)
+				} else {
+					fmt.Fprintf(&buf, "  stage %s %s:\n", st.Stage, st.Status)
+				}
+				for _, opt := range st.Options {
+					switch {
+					case opt.Old == "":
+						fmt.Fprintf(&buf, "    + %s: %s\n", opt.Path, opt.New)
+					case opt.New == "":
+						fmt.Fprintf(&buf, "    - %s: %s\n", opt.Path, opt.Old)
+					default:
+						fmt.Fprintf(&buf, "    ~ %s: %s -> %s\n", opt.Path, opt.Old, opt.New)
+					}
+				}
+			}
+		}
+	}
+	_, err := w.Write(buf.Bytes())
+	return err
+}
+
+func cmdDiff(cmd *cobra.Command, args []string) error {
+	format, err := cmd.Flags().GetString("format")
+	if err != nil {
+		return err
+	}
+	if !slices.Contains([]string{"", "text", "json"}, format) {
+		return fmt.Errorf("unsupported format %q, supported formats: text, json", format)
+	}
+
+	// image specs are generated with a fixed seed so that random
+	// values (e.g. filesystem UUIDs) do not show up as differences
+	if !cmd.Flags().Changed("seed") {
+		if err := cmd.Flags().Set("seed", "0"); err != nil {
+			return err
+		}
+	}
+	// image specs fall back to the options of the commandline
+	defaults := make(map[string]string)
+	for _, k := range []string{"distro", "arch", "blueprint"} {
+		defaults[k], err = cmd.Flags().GetString(k)
+		if err != nil {
+			return err
+		}
+	}
+
+	var sides [2][]*diffImage
+	for i, arg := range args {
+		sides[i], err = loadDiffImages(cmd, arg, defaults)
+		if err != nil {
+			return err
+		}
+	}
+	if len(sides[0]) == 0 && len(sides[1]) == 0 {
+		return errors.New("no images to compare")
+	}
+	res, err := diffImageSets(sides[0], sides[1])
+	if err != nil {
+		return err
+	}
+
+	if format == "json" {
+		enc := json.NewEncoder(osStdout)
+		enc.SetIndent("", "  ")
+		return enc.Encode(res)
+	}
+	return writeDiffText(osStdout, res)
+}
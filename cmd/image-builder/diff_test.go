@@ -0,0 +1,297 @@
+package main_test
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	main "github.com/osbuild/image-builder/cmd/image-builder"
+	"github.com/osbuild/image-builder/internal/testutil"
+	"github.com/osbuild/image-builder/pkg/depsolvednf"
+	"github.com/osbuild/image-builder/pkg/distro"
+	"github.com/osbuild/image-builder/pkg/manifestgen"
+	"github.com/osbuild/image-builder/pkg/rpmmd"
+	testrepos "github.com/osbuild/image-builder/test/data/repositories"
+)
+
+// makeVersionedDepsolve returns a depsolver that resolves every
+// package set to the given packages, the n-th call uses the n-th
+// list of packages
+func makeVersionedDepsolve(versions ...[]string) manifestgen.DepsolveFunc {
+	calls := 0
+	return func(solver *depsolvednf.Solver, cacheDir string, depsolveWarningsOutput io.Writer, packageSets map[string][]rpmmd.PackageSet, d distro.Distro, arch string) (map[string]depsolvednf.DepsolveResult, error) {
+		nevras := versions[calls]
+		calls++
+
+		res := make(map[string]depsolvednf.DepsolveResult)
+		for plName, sets := range packageSets {
+			repo := sets[0].Repositories[0]
+			var pkgs rpmmd.PackageList
+			for _, nevra := range nevras {
+				// name-epoch:version-release.arch
+				l := strings.Split(nevra, "-")
+				ev := strings.Split(l[len(l)-2], ":")
+				ra := strings.Split(l[len(l)-1], ".")
+				epoch, err := strconv.Atoi(ev[0])
+				if err != nil {
+					return nil, err
+				}
+				pkg := rpmmd.Package{
+					Name:     strings.Join(l[:len(l)-2], "-"),
+					Epoch:    uint(epoch),
+					Version:  ev[1],
+					Release:  strings.Join(ra[:len(ra)-1], "."),
+					Arch:     ra[len(ra)-1],
+					Checksum: rpmmd.Checksum{Type: "sha256", Value: testutil.SHA256For(nevra)},
+					RepoID:   repo.Id,
+					Repo:     &repo,
+				}
+				pkg.Location = fmt.Sprintf("Packages/%s-%s-%s.%s.rpm", pkg.Name, pkg.Version, pkg.Release, pkg.Arch)
+				pkg.RemoteLocations = []string{repo.BaseURLs[0] + "/" + pkg.Location}
+				pkgs = append(pkgs, pkg)
+			}
+			res[plName] = depsolvednf.DepsolveResult{
+				Transactions: depsolvednf.TransactionList{pkgs},
+				Repos:        []rpmmd.RepoConfig{repo},
+			}
+		}
+		return res, nil
+	}
+}
+
+func TestDiffImageSpecs(t *testing.T) {
+	restore := main.MockManifestgenDepsolver(makeVersionedDepsolve(
+		[]string{"kernel-0:5.14.0-1.el9.x86_64", "bash-0:5.1-2.el9.x86_64", "cups-1:2.3-1.el9.x86_64"},
+		[]string{"kernel-0:5.14.0-2.el9.x86_64", "bash-0:5.1-1.el9.x86_64", "tmux-0:3.2a-4.el9.x86_64"},
+	))
+	defer restore()
+	restore = main.MockManifestgenContainerResolver(fakeContainerResolver)
+	defer restore()
+	restore = main.MockNewRepoRegistry(testrepos.New)
+	defer restore()
+	var fakeStdout bytes.Buffer
+	restore = main.MockOsStdout(&fakeStdout)
+	defer restore()
+
+	bpPath := makeTestBlueprint(t, `
+[customizations.kernel]
+append = "quiet"
+
+[[customizations.user]]
+name = "alice"
+groups = ["wheel"]
+
+[customizations.services]
+enabled = ["sshd"]
+`)
+	restore = main.MockOsArgs([]string{
+		"diff",
+		"--distro", "centos-9",
+		"--arch", "x86_64",
+		"type=qcow2",
+		"type=qcow2,blueprint=" + bpPath,
+	})
+	defer restore()
+
+	err := main.Run()
+	require.NoError(t, err)
+
+	out := fakeStdout.String()
+	for _, expected := range []string{
+		"--- centos-9-qcow2-x86_64\n+++ centos-9-qcow2-x86_64\n",
+		"pipeline os:\n",
+		"  + tmux-3.2a-4.el9.x86_64\n",
+		// the epoch is not part of the manifest
+		"  - cups-2.3-1.el9.x86_64\n",
+		"  ^ kernel.x86_64 5.14.0-1.el9 -> 5.14.0-2.el9\n",
+		"  v bash.x86_64 5.1-2.el9 -> 5.1-1.el9\n",
+		"  stage org.osbuild.kernel-cmdline:\n    ~ kernel_opts: \"console=tty0 console=ttyS0,115200n8 net.ifnames=0\" -> \"console=tty0 console=ttyS0,115200n8 net.ifnames=0 quiet\"\n",
+		"  stage org.osbuild.users added:\n    + users.alice.groups: [\"wheel\"]\n",
+		"  stage org.osbuild.systemd:\n    + enabled_services: [\"sshd\"]\n",
+	} {
+		assert.Contains(t, out, expected)
+	}
+	// a fixed seed is used so the random UUIDs are the same
+	assert.NotContains(t, out, "uuid")
+}
+
+func writeTestLockfile(t *testing.T, images map[string][]string) string {
+	lf := map[string]any{"version": 1}
+	lockedImages := make(map[string]any)
+	for name, nevras := range images {
+		var pkgs rpmmd.PackageList
+		for _, nevra := range nevras {
+			var pkg rpmmd.Package
+			_, err := fmt.Sscanf(strings.ReplaceAll(nevra, "|", " "), "%s %d %s %s %s", &pkg.Name, &pkg.Epoch, &pkg.Version, &pkg.Release, &pkg.Arch)
+			require.NoError(t, err)
+			pkgs = append(pkgs, pkg)
+		}
+		lockedImages[name] = map[string]any{
+			"package_sets_digest": "sha256:1234",
+			"depsolved": map[string]depsolvednf.DepsolveResult{
+				"os": {Transactions: depsolvednf.TransactionList{pkgs}},
+			},
+		}
+	}
+	lf["images"] = lockedImages
+	data, err := json.Marshal(lf)
+	require.NoError(t, err)
+	path := filepath.Join(t.TempDir(), "test.lock")
+	require.NoError(t, os.WriteFile(path, data, 0644))
+	return path
+}
+
+func TestDiffLockfilesJSON(t *testing.T) {
+	var fakeStdout bytes.Buffer
+	restore := main.MockOsStdout(&fakeStdout)
+	defer restore()
+
+	oldLock := writeTestLockfile(t, map[string][]string{
+		"centos-9-qcow2-x86_64": {"bash|0|5.1|2.el9|x86_64", "shadow-utils|2|4.9|8.el9|x86_64", "kernel|0|5.14.0|1.el9|x86_64"},
+		"centos-9-ami-x86_64":   {"bash|0|5.1|2.el9|x86_64"},
+	})
+	newLock := writeTestLockfile(t, map[string][]string{
+		"centos-9-qcow2-x86_64": {"bash|0|5.1|2.el9|x86_64", "shadow-utils|1|4.10|1.el9|x86_64", "kernel|0|5.14.0|1.el9|x86_64", "kernel|0|5.14.0|2.el9|x86_64"},
+		"centos-9-vhd-x86_64":   {"bash|0|5.1|2.el9|x86_64"},
+	})
+	restore = main.MockOsArgs([]string{"diff", "--format=json", oldLock, newLock})
+	defer restore()
+
+	err := main.Run()
+	require.NoError(t, err)
+	assert.JSONEq(t, `{
+  "images": [
+    {"old": "centos-9-ami-x86_64"},
+    {
+      "old": "centos-9-qcow2-x86_64",
+      "new": "centos-9-qcow2-x86_64",
+      "pipelines": [
+        {
+          "name": "os",
+          "status": "changed",
+          "packages": {
+            "added": [{"name": "kernel", "arch": "x86_64", "new": "5.14.0-2.el9"}],
+            "downgraded": [{"name": "shadow-utils", "arch": "x86_64", "old": "2:4.9-8.el9", "new": "1:4.10-1.el9"}]
+          }
+        }
+      ]
+    },
+    {"new": "centos-9-vhd-x86_64"}
+  ]
+}`, fakeStdout.String())
+}
+
+func TestDiffManifestWithLockfile(t *testing.T) {
+	var fakeStdout bytes.Buffer
+	restore := main.MockOsStdout(&fakeStdout)
+	defer restore()
+
+	// the manifest has no epochs, the lockfile has them
+	mf := map[string]any{
+		"version": "2",
+		"pipelines": []any{
+			map[string]any{
+				"name": "os",
+				"stages": []any{
+					map[string]any{
+						"type": "org.osbuild.rpm",
+						"inputs": map[string]any{
+							"packages": map[string]any{
+								"references": []string{"sha256:1111", "sha256:2222"},
+							},
+						},
+					},
+				},
+			},
+		},
+		"sources": map[string]any{
+			"org.osbuild.curl": map[string]any{
+				"items": map[string]any{
+					"sha256:1111": "https://example.com/Packages/shadow-utils-4.9-8.el9.x86_64.rpm",
+					"sha256:2222": "https://example.com/Packages/bash-5.1-3.el9.x86_64.rpm",
+				},
+			},
+		},
+	}
+	data, err := json.Marshal(mf)
+	require.NoError(t, err)
+	mfPath := filepath.Join(t.TempDir(), "manifest.json")
+	require.NoError(t, os.WriteFile(mfPath, data, 0644))
+	lock := writeTestLockfile(t, map[string][]string{
+		"centos-9-qcow2-x86_64": {"bash|0|5.1|2.el9|x86_64", "shadow-utils|2|4.9|8.el9|x86_64"},
+	})
+	restore = main.MockOsArgs([]string{"diff", "--format=json", lock, mfPath})
+	defer restore()
+
+	err = main.Run()
+	require.NoError(t, err)
+	assert.JSONEq(t, fmt.Sprintf(`{
+  "images": [
+    {
+      "old": "centos-9-qcow2-x86_64",
+      "new": %q,
+      "pipelines": [
+        {
+          "name": "os",
+          "status": "changed",
+          "packages": {
+            "upgraded": [{"name": "bash", "arch": "x86_64", "old": "5.1-2.el9", "new": "5.1-3.el9"}]
+          }
+        }
+      ]
+    }
+  ]
+}`, mfPath), fakeStdout.String())
+}
+
+func TestDiffNoDifferences(t *testing.T) {
+	var fakeStdout bytes.Buffer
+	restore := main.MockOsStdout(&fakeStdout)
+	defer restore()
+
+	lock := writeTestLockfile(t, map[string][]string{
+		"centos-9-qcow2-x86_64": {"bash|0|5.1|2.el9|x86_64"},
+	})
+	restore = main.MockOsArgs([]string{"diff", lock, lock})
+	defer restore()
+
+	err := main.Run()
+	require.NoError(t, err)
+	assert.Equal(t, "No differences\n", fakeStdout.String())
+}
+
+func TestDiffErrors(t *testing.T) {
+	lock := writeTestLockfile(t, map[string][]string{
+		"centos-9-qcow2-x86_64": {"bash|0|5.1|2.el9|x86_64"},
+	})
+	notAManifest := filepath.Join(t.TempDir(), "other.json")
+	require.NoError(t, os.WriteFile(notAManifest, []byte(`{"foo": "bar"}`), 0644))
+
+	for _, tc := range []struct {
+		args        []string
+		expectedErr string
+	}{
+		{[]string{lock, "no-such-file"}, `cannot use "no-such-file": not a manifest, lockfile or image spec (e.g. "distro=centos-9,type=qcow2")`},
+		{[]string{lock, "distro=centos-9"}, `invalid image spec "distro=centos-9": missing type`},
+		{[]string{lock, "type=qcow2,color=red"}, `invalid image spec "type=qcow2,color=red": unknown key "color" (supported: distro, type, arch, blueprint)`},
+		{[]string{lock, notAManifest}, notAManifest + " is neither a manifest nor a lockfile"},
+		{[]string{"--format=yaml", lock, lock}, `unsupported format "yaml", supported formats: text, json`},
+	} {
+		t.Run(tc.expectedErr, func(t *testing.T) {
+			restore := main.MockOsArgs(append([]string{"diff"}, tc.args...))
+			defer restore()
+
+			err := main.Run()
+			assert.EqualError(t, err, tc.expectedErr)
+		})
+	}
+}
@@ -1,17 +1,28 @@
 package main
 
 import (
+	"context"
 	"fmt"
 	"io"
 	"os"
+	"time"
 
+	"github.com/osbuild/image-builder/cmd/check-host-config/check"
+	"github.com/osbuild/image-builder/pkg/arch"
 	"github.com/osbuild/image-builder/pkg/bootc"
 	"github.com/osbuild/image-builder/pkg/cloud"
 	"github.com/osbuild/image-builder/pkg/cloud/awscloud"
+	"github.com/osbuild/image-builder/pkg/cloud/azure"
+	"github.com/osbuild/image-builder/pkg/cloud/gcp"
+	"github.com/osbuild/image-builder/pkg/container"
+	"github.com/osbuild/image-builder/pkg/depsolvednf"
 	"github.com/osbuild/image-builder/pkg/distro"
 	"github.com/osbuild/image-builder/pkg/manifestgen"
 	"github.com/osbuild/image-builder/pkg/reporegistry"
 	"github.com/osbuild/image-builder/pkg/rpmmd"
+	"github.com/osbuild/image-builder/pkg/upload/koji"
+	"github.com/osbuild/image-builder/pkg/upload/oci"
+	"github.com/osbuild/image-builder/pkg/upload/vmware"
 )
 
 var (
@@ -24,6 +35,8 @@ var (
 	BasenameFor           = basenameFor
 	CacheDirForUid        = cacheDirForUid
 	NewPkgSearchFormatter = newPkgSearchFormatter
+	SubcommandError       = subcommandError
+	OnSignal              = onSignal
 )
 
 type DescribeImgYAML describeImgYAML
@@ -81,6 +94,62 @@ func MockAwscloudNewUploader(f func(string, string, string, *awscloud.UploaderOp
 	}
 }
 
+func MockGcpNewUploader(f func(string, string, *gcp.UploaderOptions) (cloud.Uploader, error)) (restore func()) {
+	saved := gcpNewUploader
+	gcpNewUploader = f
+	return func() {
+		gcpNewUploader = saved
+	}
+}
+
+func MockOciNewUploader(f func(string, string, string, string, *oci.UploaderOptions) (cloud.Uploader, error)) (restore func()) {
+	saved := ociNewUploader
+	ociNewUploader = f
+	return func() {
+		ociNewUploader = saved
+	}
+}
+
+func MockVmwareNewUploader(f func(vmware.Credentials, string, *vmware.UploaderOptions) (cloud.Uploader, error)) (restore func()) {
+	saved := vmwareNewUploader
+	vmwareNewUploader = f
+	return func() {
+		vmwareNewUploader = saved
+	}
+}
+
+func MockAzureNewUploader(f func(string, string, string, string, string, string, string, arch.Arch, *azure.UploaderOptions) (cloud.Uploader, error)) (restore func()) {
+	saved := azureNewUploader
+	azureNewUploader = f
+	return func() {
+		azureNewUploader = saved
+	}
+}
+
+func MockS3NewUploader(f func(string, string, *awscloud.S3UploaderOptions) (cloud.Uploader, error)) (restore func()) {
+	saved := s3NewUploader
+	s3NewUploader = f
+	return func() {
+		s3NewUploader = saved
+	}
+}
+
+func MockRegistryNewUploader(f func(string, *container.UploaderOptions) (cloud.Uploader, error)) (restore func()) {
+	saved := registryNewUploader
+	registryNewUploader = f
+	return func() {
+		registryNewUploader = saved
+	}
+}
+
+func MockKojiNewUploader(f func(string, string, string, string, *koji.UploaderOptions) (cloud.Uploader, error)) (restore func()) {
+	saved := kojiNewUploader
+	kojiNewUploader = f
+	return func() {
+		kojiNewUploader = saved
+	}
+}
+
 func MockBootcResolveInfo(f func(string) (*bootc.Info, error)) (restore func()) {
 	saved := bootcResolveInfo
 	bootcResolveInfo = f
@@ -136,3 +205,61 @@ func MockPkgSearcher(f func(distro.Distro, string, string, []rpmmd.RepoConfig, [
 		pkgSearcher = saved
 	}
 }
+
+func MockFetchRepoMetadata(f func([]rpmmd.RepoConfig) (rpmmd.PackageList, error)) (restore func()) {
+	saved := fetchRepoMetadata
+	fetchRepoMetadata = func(_ *depsolvednf.Solver, repos []rpmmd.RepoConfig) (rpmmd.PackageList, error) {
+		return f(repos)
+	}
+	return func() {
+		fetchRepoMetadata = saved
+	}
+}
+
+func MockServeContext(ctx context.Context) (restore func()) {
+	saved := serveContext
+	serveContext = func() (context.Context, context.CancelFunc) {
+		return context.WithCancel(ctx)
+	}
+	return func() {
+		serveContext = saved
+	}
+}
+
+func MockBootTestSSHInterval(d time.Duration) (restore func()) {
+	saved := bootTestSSHInterval
+	bootTestSSHInterval = d
+	return func() {
+		bootTestSSHInterval = saved
+	}
+}
+
+func MockHostRoot(root string, kernelCmdline string, blockDevices []check.BlockDevice) (restore func()) {
+	savedRoot, savedCmdline, savedDevices := hostRoot, hostKernelCmdline, hostBlockDevices
+	hostRoot = root
+	hostKernelCmdline = func() (string, error) {
+		return kernelCmdline, nil
+	}
+	hostBlockDevices = func() ([]check.BlockDevice, error) {
+		return blockDevices, nil
+	}
+	return func() {
+		hostRoot, hostKernelCmdline, hostBlockDevices = savedRoot, savedCmdline, savedDevices
+	}
+}
+
+func MockPartitionDevTimeout(d time.Duration) (restore func()) {
+	saved := partitionDevTimeout
+	partitionDevTimeout = d
+	return func() {
+		partitionDevTimeout = saved
+	}
+}
+
+func MockFirmwarePaths(archStr, code, vars string) (restore func()) {
+	saved := firmwarePaths
+	firmwarePaths = map[string][]uefiFirmware{archStr: {{Code: code, Vars: vars}}}
+	return func() {
+		firmwarePaths = saved
+	}
+}
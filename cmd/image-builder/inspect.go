@@ -0,0 +1,828 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/fs"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"slices"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/spf13/cobra"
+	"go.yaml.in/yaml/v3"
+
+	"github.com/osbuild/image-builder/pkg/datasizes"
+	"github.com/osbuild/image-builder/pkg/disk"
+)
+
+// inspectReport is the content of an already built image. The
+// partition table uses the layout of disk.PartitionTable (as shown by
+// "describe") and the customizations the layout of a blueprint so that
+// the image can be compared with what produced it.
+type inspectReport struct {
+	Image  string `json:"image" yaml:"image"`
+	Format string `json:"format" yaml:"format"`
+
+	OSRelease *inspectOSRelease `json:"os_release,omitempty" yaml:"os_release,omitempty"`
+
+	PartitionTable *disk.PartitionTable `json:"partition_table,omitempty" yaml:"partition_table,omitempty"`
+	// Filesystem is set for images without a partition table (e.g. iso)
+	Filesystem *disk.Filesystem `json:"filesystem,omitempty" yaml:"filesystem,omitempty"`
+	// Unsupported are the volumes that cannot be inspected (LVM and
+	// LUKS), the filesystems inside of them are not part of the report
+	Unsupported []string `json:"unsupported,omitempty" yaml:"unsupported,omitempty"`
+
+	Kernel         *inspectKernel         `json:"kernel,omitempty" yaml:"kernel,omitempty"`
+	Packages       []inspectPackage       `json:"packages,omitempty" yaml:"packages,omitempty"`
+	Customizations *inspectCustomizations `json:"customizations,omitempty" yaml:"customizations,omitempty"`
+}
+
+type inspectOSRelease struct {
+	ID         string `json:"id" yaml:"id"`
+	VersionID  string `json:"version_id,omitempty" yaml:"version_id,omitempty"`
+	PrettyName string `json:"pretty_name,omitempty" yaml:"pretty_name,omitempty"`
+}
+
+type inspectKernel struct {
+	Versions []string `json:"versions,omitempty" yaml:"versions,omitempty"`
+	// Cmdline is the complete kernel command line, the "append" of
+	// the blueprint is a part of it
+	Cmdline string `json:"cmdline,omitempty" yaml:"cmdline,omitempty"`
+}
+
+type inspectPackage struct {
+	Name    string `json:"name" yaml:"name"`
+	Version string `json:"version" yaml:"version"`
+	Arch    string `json:"arch" yaml:"arch"`
+}
+
+type inspectCustomizations struct {
+	Hostname string           `json:"hostname,omitempty" yaml:"hostname,omitempty"`
+	Services *inspectServices `json:"services,omitempty" yaml:"services,omitempty"`
+	User     []inspectUser    `json:"user,omitempty" yaml:"user,omitempty"`
+	Group    []inspectGroup   `json:"group,omitempty" yaml:"group,omitempty"`
+}
+
+type inspectServices struct {
+	Enabled []string `json:"enabled,omitempty" yaml:"enabled,omitempty"`
+}
+
+type inspectUser struct {
+	Name   string   `json:"name" yaml:"name"`
+	UID    int      `json:"uid" yaml:"uid"`
+	GID    int      `json:"gid" yaml:"gid"`
+	Home   string   `json:"home,omitempty" yaml:"home,omitempty"`
+	Shell  string   `json:"shell,omitempty" yaml:"shell,omitempty"`
+	Groups []string `json:"groups,omitempty" yaml:"groups,omitempty"`
+}
+
+type inspectGroup struct {
+	Name string `json:"name" yaml:"name"`
+	GID  int    `json:"gid" yaml:"gid"`
+}
+
+// firstNormalID is the first uid/gid that is not a system user or group,
+// only those can come from blueprint customizations
+const firstNormalID = 1000
+
+const nobodyID = 65534
+
+// commandOutput runs the given command and returns its stdout, stderr
+// is part of the error
+func commandOutput(name string, args ...string) ([]byte, error) {
+	cmd := exec.Command(name, args...)
+	var stderr bytes.Buffer
+	cmd.Stderr = &stderr
+	output, err := cmd.Output()
+	if err != nil {
+		return nil, fmt.Errorf("running %s failed: %w\n%s", strings.Join(cmd.Args, " "), err, stderr.Bytes())
+	}
+	return output, nil
+}
+
+// isISO checks for the signature of the primary volume descriptor
+func isISO(imagePath string) (bool, error) {
+	f, err := os.Open(imagePath)
+	if err != nil {
+		return false, err
+	}
+	defer f.Close()
+	buf := make([]byte, 5)
+	if _, err := f.ReadAt(buf, 0x8001); err != nil {
+		return false, nil
+	}
+	return string(buf) == "CD001", nil
+}
+
+func detectImageFormat(imagePath string) (string, error) {
+	iso, err := isISO(imagePath)
+	if err != nil {
+		return "", err
+	}
+	if iso {
+		return "iso", nil
+	}
+	output, err := commandOutput("qemu-img", "info", "--output=json", imagePath)
+	if err != nil {
+		return "", err
+	}
+	var info struct {
+		Format string `json:"format"`
+	}
+	if err := json.Unmarshal(output, &info); err != nil {
+		return "", fmt.Errorf("cannot parse image info: %w", err)
+	}
+	switch info.Format {
+	case "raw", "qcow2", "vmdk", "vhdx":
+		return info.Format, nil
+	case "vpc":
+		return "vhd", nil
+	default:
+		return "", fmt.Errorf("unsupported image format %q", info.Format)
+	}
+}
+
+var (
+	// partitionDevTimeout is how long to wait for the partition
+	// devices of an attached image
+	partitionDevTimeout = 10 * time.Second
+	// partitionDevInterval is the interval in which the partition
+	// devices are checked
+	partitionDevInterval = 50 * time.Millisecond
+)
+
+// imageMounter attaches an image read-only to a loop device and mounts
+// its filesystems read-only
+type imageMounter struct {
+	workDir string
+	loopDev string
+	mounts  []string
+}
+
+func (m *imageMounter) attach(rawPath string, partscan bool) error {
+	args := []string{"--read-only", "--find", "--show"}
+	if partscan {
+		args = append(args, "--partscan")
+	}
+	output, err := commandOutput("losetup", append(args, rawPath)...)
+	if err != nil {
+		return err
+	}
+	m.loopDev = strings.TrimSpace(string(output))
+	return nil
+}
+
+// partitionDev returns the device of the partition with the given
+// number of the attached image
+func (m *imageMounter) partitionDev(number string) string {
+	return fmt.Sprintf("%sp%s", m.loopDev, number)
+}
+
+// waitForPartitions waits until the devices of the partitions with the
+// given numbers exist, udev creates them asynchronously after the
+// partition scan of "losetup --partscan"
+func (m *imageMounter) waitForPartitions(numbers []string) error {
+	// udevadm is not available (or fails) in some containers, the
+	// devices are waited for below anyway
+	_ = runCommand("udevadm", "settle")
+	deadline := time.Now().Add(partitionDevTimeout)
+	for _, number := range numbers {
+		dev := m.partitionDev(number)
+		for {
+			_, err := os.Stat(dev)
+			if err == nil {
+				break
+			}
+			if !errors.Is(err, os.ErrNotExist) {
+				return err
+			}
+			if time.Now().After(deadline) {
+				return fmt.Errorf("cannot find partition device %s after %v", dev, partitionDevTimeout)
+			}
+			time.Sleep(partitionDevInterval)
+		}
+	}
+	return nil
+}
+
+func (m *imageMounter) mount(dev, fsType string) (string, error) {
+	dir := filepath.Join(m.workDir, fmt.Sprintf("mnt-%d", len(m.mounts)))
+	if err := os.MkdirAll(dir, 0o755); err != nil {
+		return "", err
+	}
+	// do not replay the journals, the image must not be modified
+	opts := "ro"
+	switch fsType {
+	case "xfs":
+		opts += ",norecovery"
+	case "ext3", "ext4":
+		opts += ",noload"
+	}
+	if err := runCommand("mount", "-o", opts, "-t", fsType, dev, dir); err != nil {
+		return "", err
+	}
+	m.mounts = append(m.mounts, dir)
+	return dir, nil
+}
+
+func (m *imageMounter) cleanup() {
+	for i := len(m.mounts) - 1; i >= 0; i-- {
+		_ = runCommand("umount", m.mounts[i])
+	}
+	if m.loopDev != "" {
+		_ = runCommand("losetup", "--detach", m.loopDev)
+	}
+}
+
+// blkidProbe returns the filesystem type, uuid and label of the device,
+// an empty map is returned for devices without a (known) filesystem
+func blkidProbe(dev string) (map[string]string, error) {
+	output, err := commandOutput("blkid", "--output", "export", dev)
+	if err != nil {
+		// blkid exits with 2 when nothing was found
+		var exitErr *exec.ExitError
+		if errors.As(err, &exitErr) && exitErr.ExitCode() == 2 {
+			return map[string]string{}, nil
+		}
+		return nil, err
+	}
+	return parseKeyValues(output), nil
+}
+
+// parseKeyValues parses KEY=value lines like os-release(5) or the
+// export format of blkid
+func parseKeyValues(data []byte) map[string]string {
+	values := make(map[string]string)
+	scanner := bufio.NewScanner(bytes.NewReader(data))
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		key, value, ok := strings.Cut(line, "=")
+		if !ok {
+			continue
+		}
+		values[key] = strings.Trim(value, `"'`)
+	}
+	return values
+}
+
+type sfdiskTable struct {
+	PartitionTable struct {
+		Label      string `json:"label"`
+		ID         string `json:"id"`
+		SectorSize uint64 `json:"sectorsize"`
+		Partitions []struct {
+			Node     string `json:"node"`
+			Start    uint64 `json:"start"`
+			Size     uint64 `json:"size"`
+			Type     string `json:"type"`
+			UUID     string `json:"uuid"`
+			Name     string `json:"name"`
+			Attrs    string `json:"attrs"`
+			Bootable bool   `json:"bootable"`
+		} `json:"partitions"`
+	} `json:"partitiontable"`
+}
+
+// readPartitionTable reads the partition table of the raw image, nil is
+// returned for images without one
+func readPartitionTable(rawPath string) (*disk.PartitionTable, []string, error) {
+	output, err := commandOutput("sfdisk", "--json", rawPath)
+	if err != nil && strings.Contains(err.Error(), "does not contain a recognized partition table") {
+		return nil, nil, nil
+	}
+	if err != nil {
+		return nil, nil, err
+	}
+	var table sfdiskTable
+	if err := json.Unmarshal(output, &table); err != nil {
+		return nil, nil, fmt.Errorf("cannot parse partition table: %w", err)
+	}
+	ptType, err := disk.NewPartitionTableType(table.PartitionTable.Label)
+	if err != nil {
+		return nil, nil, err
+	}
+	sectorSize := table.PartitionTable.SectorSize
+	if sectorSize == 0 {
+		sectorSize = disk.DefaultSectorSize
+	}
+	st, err := os.Stat(rawPath)
+	if err != nil {
+		return nil, nil, err
+	}
+	pt := &disk.PartitionTable{
+		Size: datasizes.Size(st.Size()),
+		Type: ptType,
+	}
+	if ptType == disk.PT_GPT {
+		pt.UUID = table.PartitionTable.ID
+	}
+	if sectorSize != disk.DefaultSectorSize {
+		pt.SectorSize = sectorSize
+	}
+	var numbers []string
+	for _, p := range table.PartitionTable.Partitions {
+		part := disk.Partition{
+			Start:    p.Start * sectorSize,
+			Size:     datasizes.Size(p.Size * sectorSize),
+			Type:     p.Type,
+			UUID:     p.UUID,
+			Label:    p.Name,
+			Bootable: p.Bootable || strings.Contains(p.Attrs, "LegacyBIOSBootable"),
+		}
+		pt.Partitions = append(pt.Partitions, part)
+		// the partition number is the suffix of the node, e.g. "disk.raw3"
+		numbers = append(numbers, p.Node[len(strings.TrimRight(p.Node, "0123456789")):])
+	}
+	return pt, numbers, nil
+}
+
+// inspectTree is the (read-only) filesystem tree of the image, the
+// filesystems are mounted separately
+type inspectTree struct {
+	mounts map[string]string
+}
+
+// path returns the host path of the given path in the tree
+func (t *inspectTree) path(p string) string {
+	var best string
+	for mountpoint := range t.mounts {
+		if (p == mountpoint || strings.HasPrefix(p, strings.TrimSuffix(mountpoint, "/")+"/")) && len(mountpoint) > len(best) {
+			best = mountpoint
+		}
+	}
+	if best == "" {
+		return ""
+	}
+	return filepath.Join(t.mounts[best], strings.TrimPrefix(p, best))
+}
+
+func (t *inspectTree) readFile(p string) ([]byte, error) {
+	hostPath := t.path(p)
+	if hostPath == "" {
+		return nil, fs.ErrNotExist
+	}
+	return os.ReadFile(hostPath)
+}
+
+type fstabEntry struct {
+	spec, mountpoint, options string
+	freq, passno              uint64
+}
+
+func parseFstab(data []byte) []fstabEntry {
+	var entries []fstabEntry
+	scanner := bufio.NewScanner(bytes.NewReader(data))
+	for scanner.Scan() {
+		fields := strings.Fields(scanner.Text())
+		if len(fields) < 2 || strings.HasPrefix(fields[0], "#") {
+			continue
+		}
+		entry := fstabEntry{spec: fields[0], mountpoint: fields[1]}
+		if len(fields) > 3 {
+			entry.options = fields[3]
+		}
+		if len(fields) > 4 {
+			entry.freq, _ = strconv.ParseUint(fields[4], 10, 64)
+		}
+		if len(fields) > 5 {
+			entry.passno, _ = strconv.ParseUint(fields[5], 10, 64)
+		}
+		entries = append(entries, entry)
+	}
+	return entries
+}
+
+// matches checks if the fstab entry refers to the given filesystem or
+// swap area
+func (e *fstabEntry) matches(uuid, label, partUUID string) bool {
+	kind, value, ok := strings.Cut(e.spec, "=")
+	if !ok {
+		return false
+	}
+	switch kind {
+	case "UUID":
+		return uuid != "" && strings.EqualFold(value, uuid)
+	case "LABEL":
+		return label != "" && value == label
+	case "PARTUUID":
+		return partUUID != "" && strings.EqualFold(value, partUUID)
+	}
+	return false
+}
+
+func inspectImage(imagePath, workDir string) (*inspectReport, error) {
+	return mountImage(imagePath, workDir, inspectTreeContent)
+}
+
+// mountImage reads the partition table and filesystems of the image and
+// calls fn with the filesystem tree of the image while it is mounted. fn
+// is not called if the image has no root filesystem.
+func mountImage(imagePath, workDir string, fn func(report *inspectReport, tree *inspectTree) error) (*inspectReport, error) {
+	format, err := detectImageFormat(imagePath)
+	if err != nil {
+		return nil, err
+	}
+	report := &inspectReport{
+		Image:  imagePath,
+		Format: format,
+	}
+
+	rawPath := imagePath
+	if format != "raw" && format != "iso" {
+		rawPath = filepath.Join(workDir, "disk.raw")
+		if err := runCommand("qemu-img", "convert", "-O", "raw", imagePath, rawPath); err != nil {
+			return nil, fmt.Errorf("cannot convert image to raw: %w", err)
+		}
+	}
+
+	m := &imageMounter{workDir: workDir}
+	defer m.cleanup()
+
+	if format == "iso" {
+		if err := m.attach(rawPath, false); err != nil {
+			return nil, err
+		}
+		probe, err := blkidProbe(m.loopDev)
+		if err != nil {
+			return nil, err
+		}
+		report.Filesystem = &disk.Filesystem{Type: probe["TYPE"], UUID: probe["UUID"], Label: probe["LABEL"]}
+		dir, err := m.mount(m.loopDev, probe["TYPE"])
+		if err != nil {
+			return nil, err
+		}
+		report.Kernel = inspectISOKernel(dir)
+		return report, nil
+	}
+
+	pt, numbers, err := readPartitionTable(rawPath)
+	if err != nil {
+		return nil, err
+	}
+	if err := m.attach(rawPath, pt != nil); err != nil {
+		return nil, err
+	}
+	if err := m.waitForPartitions(numbers); err != nil {
+		return nil, err
+	}
+	report.PartitionTable = pt
+
+	type mountedFS struct {
+		fs       *disk.Filesystem
+		partUUID string
+		dir      string
+	}
+	var filesystems []mountedFS
+	var swaps []*disk.Swap
+	probeAndMount := func(dev, name, partUUID string) (disk.PayloadEntity, error) {
+		probe, err := blkidProbe(dev)
+		if err != nil {
+			return nil, err
+		}
+		switch probe["TYPE"] {
+		case "":
+			return nil, nil
+		case "swap":
+			swap := &disk.Swap{UUID: probe["UUID"], Label: probe["LABEL"]}
+			swaps = append(swaps, swap)
+			return swap, nil
+		case "LVM2_member":
+			report.Unsupported = append(report.Unsupported, fmt.Sprintf("%s: LVM physical volume", name))
+			return nil, nil
+		case "crypto_LUKS":
+			report.Unsupported = append(report.Unsupported, fmt.Sprintf("%s: LUKS container", name))
+			return nil, nil
+		}
+		fsys := &disk.Filesystem{Type: probe["TYPE"], UUID: probe["UUID"], Label: probe["LABEL"]}
+		dir, err := m.mount(dev, fsys.Type)
+		if err != nil {
+			return nil, err
+		}
+		filesystems = append(filesystems, mountedFS{fsys, partUUID, dir})
+		return fsys, nil
+	}
+	if pt == nil {
+		fsys, err := probeAndMount(m.loopDev, "image", "")
+		if err != nil {
+			return nil, err
+		}
+		if f, ok := fsys.(*disk.Filesystem); ok {
+			report.Filesystem = f
+		}
+	} else {
+		for i := range pt.Partitions {
+			payload, err := probeAndMount(m.partitionDev(numbers[i]), "partition "+numbers[i], pt.Partitions[i].UUID)
+			if err != nil {
+				return nil, err
+			}
+			pt.Partitions[i].Payload = payload
+		}
+	}
+
+	// the root filesystem is the one with an os-release, the mountpoints
+	// of the others come from its fstab
+	tree := &inspectTree{mounts: make(map[string]string)}
+	var root *mountedFS
+	for i, f := range filesystems {
+		for _, p := range []string{"etc/os-release", "usr/lib/os-release"} {
+			if _, err := os.Stat(filepath.Join(f.dir, p)); err == nil && root == nil {
+				root = &filesystems[i]
+			}
+		}
+	}
+	if root == nil {
+		return report, nil
+	}
+	root.fs.Mountpoint = "/"
+	tree.mounts["/"] = root.dir
+	if data, err := os.ReadFile(filepath.Join(root.dir, "etc/fstab")); err == nil {
+		for _, entry := range parseFstab(data) {
+			for _, f := range filesystems {
+				if entry.matches(f.fs.UUID, f.fs.Label, f.partUUID) {
+					f.fs.Mountpoint = entry.mountpoint
+					f.fs.FSTabOptions = entry.options
+					f.fs.FSTabFreq = entry.freq
+					f.fs.FSTabPassNo = entry.passno
+					tree.mounts[entry.mountpoint] = f.dir
+				}
+			}
+			for _, swap := range swaps {
+				if entry.matches(swap.UUID, swap.Label, "") {
+					swap.FSTabOptions = entry.options
+				}
+			}
+		}
+	}
+
+	if err := fn(report, tree); err != nil {
+		return nil, err
+	}
+	return report, nil
+}
+
+func inspectTreeContent(report *inspectReport, tree *inspectTree) error {
+	osRelease, err := tree.readFile("/etc/os-release")
+	if err != nil {
+		if osRelease, err = tree.readFile("/usr/lib/os-release"); err != nil {
+			return err
+		}
+	}
+	values := parseKeyValues(osRelease)
+	report.OSRelease = &inspectOSRelease{
+		ID:         values["ID"],
+		VersionID:  values["VERSION_ID"],
+		PrettyName: values["PRETTY_NAME"],
+	}
+
+	report.Kernel = inspectTreeKernel(tree)
+	if report.Packages, err = inspectPackages(tree); err != nil {
+		return err
+	}
+
+	custom := &inspectCustomizations{}
+	if hostname, err := tree.readFile("/etc/hostname"); err == nil {
+		custom.Hostname = strings.TrimSpace(string(hostname))
+	}
+	if enabled := inspectEnabledUnits(tree); len(enabled) > 0 {
+		custom.Services = &inspectServices{Enabled: enabled}
+	}
+	if custom.User, custom.Group, err = inspectUsersAndGroups(tree); err != nil {
+		return err
+	}
+	report.Customizations = custom
+	return nil
+}
+
+func inspectPackages(tree *inspectTree) ([]inspectPackage, error) {
+	root := tree.path("/")
+	if !hasRpmdb(tree) {
+		return nil, nil
+	}
+	output, err := commandOutput("rpm", "--root", root, "--query", "--all", "--queryformat", `%{NAME}\t%{EPOCHNUM}\t%{VERSION}\t%{RELEASE}\t%{ARCH}\n`)
+	if err != nil {
+		return nil, fmt.Errorf("cannot list packages: %w", err)
+	}
+	var pkgs []inspectPackage
+	for _, line := range strings.Split(strings.TrimSpace(string(output)), "\n") {
+		fields := strings.Split(line, "\t")
+		if len(fields) != 5 || fields[0] == "gpg-pubkey" {
+			continue
+		}
+		version := fields[2] + "-" + fields[3]
+		if fields[1] != "0" && fields[1] != "" {
+			version = fields[1] + ":" + version
+		}
+		pkgs = append(pkgs, inspectPackage{Name: fields[0], Version: version, Arch: fields[4]})
+	}
+	sort.Slice(pkgs, func(i, j int) bool {
+		if pkgs[i].Name != pkgs[j].Name {
+			return pkgs[i].Name < pkgs[j].Name
+		}
+		return pkgs[i].Arch < pkgs[j].Arch
+	})
+	return pkgs, nil
+}
+
+// inspectTreeKernel returns the installed kernels and the command line
+// of the default boot entry
+func inspectTreeKernel(tree *inspectTree) *inspectKernel {
+	kernel := &inspectKernel{}
+	if entries, err := os.ReadDir(tree.path("/usr/lib/modules")); err == nil {
+		for _, entry := range entries {
+			if entry.IsDir() {
+				kernel.Versions = append(kernel.Versions, entry.Name())
+			}
+		}
+	}
+
+	if cmdline, err := tree.readFile("/etc/kernel/cmdline"); err == nil {
+		kernel.Cmdline = strings.TrimSpace(string(cmdline))
+	} else if entries, err := filepath.Glob(filepath.Join(tree.path("/boot/loader/entries"), "*.conf")); err == nil && len(entries) > 0 {
+		// the newest kernel is the default entry
+		sort.Strings(entries)
+		if data, err := os.ReadFile(entries[len(entries)-1]); err == nil {
+			scanner := bufio.NewScanner(bytes.NewReader(data))
+			for scanner.Scan() {
+				if options, ok := strings.CutPrefix(scanner.Text(), "options "); ok {
+					kernel.Cmdline = strings.TrimSpace(options)
+				}
+			}
+		}
+	} else if grub, err := tree.readFile("/etc/default/grub"); err == nil {
+		kernel.Cmdline = parseKeyValues(grub)["GRUB_CMDLINE_LINUX"]
+	}
+
+	if len(kernel.Versions) == 0 && kernel.Cmdline == "" {
+		return nil
+	}
+	return kernel
+}
+
+// inspectISOKernel returns the kernel command line of the first boot
+// entry of the iso
+func inspectISOKernel(dir string) *inspectKernel {
+	for _, p := range []string{"EFI/BOOT/grub.cfg", "boot/grub2/grub.cfg", "isolinux/isolinux.cfg"} {
+		data, err := os.ReadFile(filepath.Join(dir, p))
+		if err != nil {
+			continue
+		}
+		scanner := bufio.NewScanner(bytes.NewReader(data))
+		for scanner.Scan() {
+			fields := strings.Fields(scanner.Text())
+			if len(fields) > 2 && slices.Contains([]string{"linux", "linuxefi", "append"}, fields[0]) {
+				args := fields[2:]
+				if fields[0] == "append" {
+					args = fields[1:]
+				}
+				return &inspectKernel{Cmdline: strings.Join(args, " ")}
+			}
+		}
+	}
+	return nil
+}
+
+// inspectEnabledUnits returns the systemd units that are enabled via
+// symlinks in the .wants/.requires directories of /etc/systemd/system
+func inspectEnabledUnits(tree *inspectTree) []string {
+	dirs, err := filepath.Glob(filepath.Join(tree.path("/etc/systemd/system"), "*"))
+	if err != nil {
+		return nil
+	}
+	units := make(map[string]bool)
+	for _, dir := range dirs {
+		if !strings.HasSuffix(dir, ".wants") && !strings.HasSuffix(dir, ".requires") {
+			continue
+		}
+		entries, err := os.ReadDir(dir)
+		if err != nil {
+			continue
+		}
+		for _, entry := range entries {
+			if entry.Type()&fs.ModeSymlink != 0 {
+				units[entry.Name()] = true
+			}
+		}
+	}
+	var enabled []string
+	for unit := range units {
+		enabled = append(enabled, unit)
+	}
+	sort.Strings(enabled)
+	return enabled
+}
+
+// inspectUsersAndGroups returns the users and groups that are not
+// system users or groups
+func inspectUsersAndGroups(tree *inspectTree) ([]inspectUser, []inspectGroup, error) {
+	groupData, err := tree.readFile("/etc/group")
+	if err != nil {
+		return nil, nil, nil
+	}
+	var groups []inspectGroup
+	groupNames := make(map[int]string)
+	memberOf := make(map[string][]string)
+	for _, line := range strings.Split(string(groupData), "\n") {
+		fields := strings.Split(line, ":")
+		if len(fields) < 4 {
+			continue
+		}
+		gid, err := strconv.Atoi(fields[2])
+		if err != nil {
+			continue
+		}
+		groupNames[gid] = fields[0]
+		for _, member := range strings.Split(fields[3], ",") {
+			if member != "" {
+				memberOf[member] = append(memberOf[member], fields[0])
+			}
+		}
+		if gid >= firstNormalID && gid != nobodyID {
+			groups = append(groups, inspectGroup{Name: fields[0], GID: gid})
+		}
+	}
+
+	passwdData, err := tree.readFile("/etc/passwd")
+	if err != nil {
+		return nil, nil, err
+	}
+	var users []inspectUser
+	for _, line := range strings.Split(string(passwdData), "\n") {
+		fields := strings.Split(line, ":")
+		if len(fields) < 7 {
+			continue
+		}
+		uid, err := strconv.Atoi(fields[2])
+		if err != nil || uid < firstNormalID || uid == nobodyID {
+			continue
+		}
+		gid, err := strconv.Atoi(fields[3])
+		if err != nil {
+			continue
+		}
+		user := inspectUser{
+			Name:   fields[0],
+			UID:    uid,
+			GID:    gid,
+			Home:   fields[5],
+			Shell:  fields[6],
+			Groups: memberOf[fields[0]],
+		}
+		users = append(users, user)
+	}
+	return users, groups, nil
+}
+
+func cmdInspect(cmd *cobra.Command, args []string) error {
+	imagePath := args[0]
+	format, err := cmd.Flags().GetString("format")
+	if err != nil {
+		return err
+	}
+	if format != "" && format != "yaml" && format != "json" {
+		return fmt.Errorf("unsupported format %q, supported formats: yaml, json", format)
+	}
+	cacheDir, err := cmd.Flags().GetString("cache")
+	if err != nil {
+		return err
+	}
+	if _, err := os.Stat(imagePath); err != nil {
+		return err
+	}
+	if err := os.MkdirAll(cacheDir, 0o755); err != nil {
+		return err
+	}
+	workDir, err := os.MkdirTemp(cacheDir, "inspect-")
+	if err != nil {
+		return err
+	}
+	defer os.RemoveAll(workDir)
+
+	report, err := inspectImage(imagePath, workDir)
+	if err != nil {
+		return err
+	}
+
+	var output []byte
+	if format == "json" {
+		output, err = json.MarshalIndent(report, "", "  ")
+		output = append(output, '\n')
+	} else {
+		output, err = yaml.Marshal(report)
+	}
+	if err != nil {
+		return err
+	}
+	_, err = cmd.OutOrStdout().Write(output)
+	return err
+}
@@ -0,0 +1,319 @@
+package main_test
+
+import (
+	"bytes"
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"go.yaml.in/yaml/v3"
+
+	main "github.com/osbuild/image-builder/cmd/image-builder"
+	"github.com/osbuild/image-builder/internal/testutil"
+	"github.com/osbuild/image-builder/pkg/datasizes"
+	"github.com/osbuild/image-builder/pkg/disk"
+)
+
+const fakeSfdiskJSON = `{
+   "partitiontable": {
+      "label": "gpt",
+      "id": "D209C89E-EA5E-4FBD-B161-B461CCE297E0",
+      "device": "disk.raw",
+      "unit": "sectors",
+      "firstlba": 34,
+      "lastlba": 20971486,
+      "sectorsize": 512,
+      "partitions": [
+         {"node": "disk.raw1", "start": 2048, "size": 2048, "type": "21686148-6449-6E6F-744E-656564454649", "uuid": "FAC7F1FB-3E8D-4137-A512-961DE09A5549", "attrs": "LegacyBIOSBootable"},
+         {"node": "disk.raw2", "start": 4096, "size": 409600, "type": "C12A7328-F81F-11D2-BA4B-00A0C93EC93B", "uuid": "68B2905B-DF3E-4FB3-80FA-49D1E773AA33", "name": "EFI System Partition"},
+         {"node": "disk.raw3", "start": 413696, "size": 2097152, "type": "0FC63DAF-8483-4772-8E79-3D69D8477DE4", "uuid": "CB07C243-BC44-4717-853E-28852021225B"},
+         {"node": "disk.raw4", "start": 2510848, "size": 18460639, "type": "0FC63DAF-8483-4772-8E79-3D69D8477DE4", "uuid": "6264D520-3FB9-423F-8AB8-7A0A8E3D3562"}
+      ]
+   }
+}`
+
+// fakeMountScript populates the mountpoint for the fake partitions
+// of fakeSfdiskJSON
+const fakeMountScript = `
+dev="${@: -2:1}"
+dir="${@: -1}"
+case "$dev" in
+  *p3)
+    mkdir -p "$dir/loader/entries"
+    echo "options root=UUID=6bf-root ro console=ttyS0" > "$dir/loader/entries/a-5.14.0-1.conf"
+    ;;
+  *p4)
+    mkdir -p "$dir/etc/systemd/system/multi-user.target.wants" "$dir/usr/lib/modules/5.14.0-1.el9.x86_64" "$dir/var/lib/rpm"
+    printf 'NAME="CentOS Stream"\nID="centos"\nVERSION_ID="9"\nPRETTY_NAME="CentOS Stream 9"\n' > "$dir/etc/os-release"
+    printf 'UUID=6bf-root / xfs defaults 0 0\nUUID=6bf-boot /boot xfs defaults 0 0\nUUID=7B77-95E7 /boot/efi vfat defaults,uid=0,gid=0,umask=077,shortname=winnt 0 2\n' > "$dir/etc/fstab"
+    printf 'root:x:0:0:root:/root:/bin/bash\nnobody:x:65534:65534:Kernel Overflow User:/:/sbin/nologin\nalice:x:1000:1000::/home/alice:/bin/bash\n' > "$dir/etc/passwd"
+    printf 'root:x:0:\nwheel:x:10:alice\nnobody:x:65534:\nalice:x:1000:\n' > "$dir/etc/group"
+    echo "my-host" > "$dir/etc/hostname"
+    ln -s /usr/lib/systemd/system/sshd.service "$dir/etc/systemd/system/multi-user.target.wants/sshd.service"
+    ;;
+esac
+`
+
+const fakeBlkidScript = `
+case "$3" in
+  *p1) exit 2;;
+  *p2) printf 'UUID=7B77-95E7\nTYPE=vfat\n';;
+  *p3) printf 'UUID=6bf-boot\nTYPE=xfs\n';;
+  *p4) printf 'UUID=6bf-root\nTYPE=xfs\nLABEL=root\n';;
+esac
+`
+
+func mockInspectTools(t *testing.T, format string) map[string]*testutil.MockCmd {
+	mocks := map[string]*testutil.MockCmd{
+		"qemu-img": testutil.MockCommand(t, "qemu-img", `
+if [ "$1" = "info" ]; then echo '{"format": "`+format+`"}'; fi
+if [ "$1" = "convert" ]; then echo converted > "${@: -1}"; fi
+`),
+		"sfdisk": testutil.MockCommand(t, "sfdisk", "cat <<'EOF'\n"+fakeSfdiskJSON+"\nEOF\n"),
+		// the partition devices are created next to the fake losetup
+		"losetup": testutil.MockCommand(t, "losetup", `
+if [ "$1" = "--detach" ]; then exit 0; fi
+dev="$(dirname "$0")/loop7"
+if [ "$4" = "--partscan" ]; then touch "$dev"p1 "$dev"p2 "$dev"p3 "$dev"p4; fi
+echo "$dev"
+`),
+		"udevadm": testutil.MockCommand(t, "udevadm", ""),
+		"blkid":   testutil.MockCommand(t, "blkid", fakeBlkidScript),
+		"mount":   testutil.MockCommand(t, "mount", fakeMountScript),
+		"umount":  testutil.MockCommand(t, "umount", ""),
+		"rpm": testutil.MockCommand(t, "rpm", `
+printf 'bash\t0\t5.1.8\t9.el9\tx86_64\n'
+printf 'gpg-pubkey\t0\t8483c65d\t5ccc5b19\t(none)\n'
+printf 'dbus\t1\t1.12.20\t8.el9\tx86_64\n'
+`),
+	}
+	return mocks
+}
+
+// fakeLoopDev returns the loop device of the fake losetup
+func fakeLoopDev(mocks map[string]*testutil.MockCmd) string {
+	return filepath.Join(filepath.Dir(mocks["losetup"].Path()), "loop7")
+}
+
+func runInspect(t *testing.T, args ...string) ([]byte, error) {
+	var fakeStdout bytes.Buffer
+	restore := main.MockOsStdout(&fakeStdout)
+	defer restore()
+	restore = main.MockOsArgs(append([]string{"inspect", "--cache", t.TempDir()}, args...))
+	defer restore()
+	err := main.Run()
+	return fakeStdout.Bytes(), err
+}
+
+func TestInspectQcow2(t *testing.T) {
+	mocks := mockInspectTools(t, "qcow2")
+	imagePath := filepath.Join(t.TempDir(), "disk.qcow2")
+	require.NoError(t, os.WriteFile(imagePath, []byte("fake-img-qcow2"), 0644))
+
+	output, err := runInspect(t, imagePath, "--format", "json")
+	require.NoError(t, err)
+
+	var report map[string]any
+	require.NoError(t, json.Unmarshal(output, &report))
+	assert.Equal(t, "qcow2", report["format"])
+	assert.Equal(t, map[string]any{"id": "centos", "version_id": "9", "pretty_name": "CentOS Stream 9"}, report["os_release"])
+	assert.Equal(t, map[string]any{
+		"versions": []any{"5.14.0-1.el9.x86_64"},
+		"cmdline":  "root=UUID=6bf-root ro console=ttyS0",
+	}, report["kernel"])
+	assert.Equal(t, []any{
+		map[string]any{"name": "bash", "version": "5.1.8-9.el9", "arch": "x86_64"},
+		map[string]any{"name": "dbus", "version": "1:1.12.20-8.el9", "arch": "x86_64"},
+	}, report["packages"])
+	assert.Equal(t, map[string]any{
+		"hostname": "my-host",
+		"services": map[string]any{"enabled": []any{"sshd.service"}},
+		"user": []any{
+			map[string]any{"name": "alice", "uid": float64(1000), "gid": float64(1000), "home": "/home/alice", "shell": "/bin/bash", "groups": []any{"wheel"}},
+		},
+		"group": []any{map[string]any{"name": "alice", "gid": float64(1000)}},
+	}, report["customizations"])
+
+	// the partition table can be read back like the partition tables
+	// of image definitions
+	var withPT struct {
+		PartitionTable *disk.PartitionTable `json:"partition_table"`
+	}
+	require.NoError(t, json.Unmarshal(output, &withPT))
+	assert.Equal(t, &disk.PartitionTable{
+		Size: datasizes.Size(len("converted\n")),
+		UUID: "D209C89E-EA5E-4FBD-B161-B461CCE297E0",
+		Type: disk.PT_GPT,
+		Partitions: []disk.Partition{
+			{
+				Start:    1 * datasizes.MiB,
+				Size:     1 * datasizes.MiB,
+				Type:     disk.BIOSBootPartitionGUID,
+				UUID:     "FAC7F1FB-3E8D-4137-A512-961DE09A5549",
+				Bootable: true,
+			},
+			{
+				Start: 2 * datasizes.MiB,
+				Size:  200 * datasizes.MiB,
+				Type:  disk.EFISystemPartitionGUID,
+				UUID:  "68B2905B-DF3E-4FB3-80FA-49D1E773AA33",
+				Label: "EFI System Partition",
+				Payload: &disk.Filesystem{
+					Type:         "vfat",
+					UUID:         "7B77-95E7",
+					Mountpoint:   "/boot/efi",
+					FSTabOptions: "defaults,uid=0,gid=0,umask=077,shortname=winnt",
+					FSTabPassNo:  2,
+				},
+			},
+			{
+				Start: 202 * datasizes.MiB,
+				Size:  1 * datasizes.GiB,
+				Type:  disk.FilesystemDataGUID,
+				UUID:  "CB07C243-BC44-4717-853E-28852021225B",
+				Payload: &disk.Filesystem{
+					Type:         "xfs",
+					UUID:         "6bf-boot",
+					Mountpoint:   "/boot",
+					FSTabOptions: "defaults",
+				},
+			},
+			{
+				Start: 1226 * datasizes.MiB,
+				Size:  18460639 * 512,
+				Type:  disk.FilesystemDataGUID,
+				UUID:  "6264D520-3FB9-423F-8AB8-7A0A8E3D3562",
+				Payload: &disk.Filesystem{
+					Type:         "xfs",
+					UUID:         "6bf-root",
+					Label:        "root",
+					Mountpoint:   "/",
+					FSTabOptions: "defaults",
+				},
+			},
+		},
+	}, withPT.PartitionTable)
+
+	// the image is converted to raw and everything is attached read-only
+	convertArgs := mocks["qemu-img"].CallArgsList()[1]
+	assert.Equal(t, []string{"convert", "-O", "raw", imagePath}, convertArgs[:4])
+	rawPath := convertArgs[4]
+	loopDev := fakeLoopDev(mocks)
+	assert.Equal(t, [][]string{
+		{"--read-only", "--find", "--show", "--partscan", rawPath},
+		{"--detach", loopDev},
+	}, mocks["losetup"].CallArgsList())
+	// the partition devices are waited for before they are used
+	assert.Equal(t, [][]string{{"settle"}}, mocks["udevadm"].CallArgsList())
+	mountCalls := mocks["mount"].CallArgsList()
+	require.Len(t, mountCalls, 3)
+	assert.Equal(t, []string{"-o", "ro", "-t", "vfat", loopDev + "p2"}, mountCalls[0][:5])
+	assert.Equal(t, []string{"-o", "ro,norecovery", "-t", "xfs", loopDev + "p4"}, mountCalls[2][:5])
+	assert.Len(t, mocks["umount"].CallArgsList(), 3)
+	// the raw copy is removed
+	assert.NoFileExists(t, rawPath)
+}
+
+func TestInspectRawYAML(t *testing.T) {
+	mocks := mockInspectTools(t, "raw")
+	imagePath := filepath.Join(t.TempDir(), "disk.raw")
+	require.NoError(t, os.WriteFile(imagePath, []byte("fake-img-raw"), 0644))
+
+	output, err := runInspect(t, imagePath)
+	require.NoError(t, err)
+
+	var report map[string]any
+	require.NoError(t, yaml.Unmarshal(output, &report))
+	assert.Equal(t, "raw", report["format"])
+	assert.Contains(t, string(output), "partition_table:\n")
+	// raw images are used directly
+	assert.Len(t, mocks["qemu-img"].CallArgsList(), 1)
+	assert.Equal(t, imagePath, mocks["sfdisk"].CallArgsList()[0][1])
+}
+
+func TestInspectLVMAndLUKS(t *testing.T) {
+	mocks := mockInspectTools(t, "raw")
+	testutil.MockCommand(t, "blkid", `
+case "$3" in
+  *p1) exit 2;;
+  *p2) printf 'UUID=7B77-95E7\nTYPE=vfat\n';;
+  *p3) printf 'UUID=b1f-luks\nTYPE=crypto_LUKS\n';;
+  *p4) printf 'UUID=c3e-pv\nTYPE=LVM2_member\n';;
+esac
+`)
+	imagePath := filepath.Join(t.TempDir(), "disk.raw")
+	require.NoError(t, os.WriteFile(imagePath, []byte("fake-img-raw"), 0644))
+
+	output, err := runInspect(t, imagePath, "--format", "json")
+	require.NoError(t, err)
+
+	// the volumes are reported as unsupported instead of silently
+	// reporting no root filesystem
+	var report map[string]any
+	require.NoError(t, json.Unmarshal(output, &report))
+	assert.Equal(t, []any{"partition 3: LUKS container", "partition 4: LVM physical volume"}, report["unsupported"])
+	assert.Nil(t, report["os_release"])
+	assert.Len(t, mocks["mount"].CallArgsList(), 1)
+}
+
+func TestInspectMissingPartitionDevices(t *testing.T) {
+	mocks := mockInspectTools(t, "raw")
+	testutil.MockCommand(t, "losetup", `if [ "$1" != "--detach" ]; then echo "$(dirname "$0")/loop7"; fi`)
+	restore := main.MockPartitionDevTimeout(100 * time.Millisecond)
+	defer restore()
+	imagePath := filepath.Join(t.TempDir(), "disk.raw")
+	require.NoError(t, os.WriteFile(imagePath, []byte("fake-img-raw"), 0644))
+
+	_, err := runInspect(t, imagePath)
+	assert.ErrorContains(t, err, "cannot find partition device ")
+	assert.ErrorContains(t, err, "loop7p1 after 100ms")
+	assert.Len(t, mocks["mount"].CallArgsList(), 0)
+}
+
+func TestInspectISO(t *testing.T) {
+	mocks := mockInspectTools(t, "raw")
+	testutil.MockCommand(t, "blkid", `printf 'UUID=2024-05-01-10-00-00-00\nLABEL=CentOS-Stream-9-BaseOS-x86_64\nTYPE=iso9660\n'`)
+	testutil.MockCommand(t, "mount", `
+mkdir -p "${@: -1}/EFI/BOOT"
+printf "menuentry 'Install' {\n\tlinuxefi /images/pxeboot/vmlinuz inst.stage2=hd:LABEL=CentOS-Stream-9-BaseOS-x86_64 quiet\n}\n" > "${@: -1}/EFI/BOOT/grub.cfg"
+`)
+	imagePath := filepath.Join(t.TempDir(), "installer.iso")
+	iso := make([]byte, 0x8010)
+	copy(iso[0x8001:], "CD001")
+	require.NoError(t, os.WriteFile(imagePath, iso, 0644))
+
+	output, err := runInspect(t, imagePath, "--format", "json")
+	require.NoError(t, err)
+
+	var report map[string]any
+	require.NoError(t, json.Unmarshal(output, &report))
+	assert.Equal(t, "iso", report["format"])
+	fs := report["filesystem"].(map[string]any)
+	assert.Equal(t, "iso9660", fs["type"])
+	assert.Equal(t, "2024-05-01-10-00-00-00", fs["uuid"])
+	assert.Equal(t, "CentOS-Stream-9-BaseOS-x86_64", fs["label"])
+	assert.Equal(t, map[string]any{
+		"cmdline": "inst.stage2=hd:LABEL=CentOS-Stream-9-BaseOS-x86_64 quiet",
+	}, report["kernel"])
+	assert.Nil(t, report["partition_table"])
+	assert.Len(t, mocks["qemu-img"].CallArgsList(), 0)
+	assert.Len(t, mocks["sfdisk"].CallArgsList(), 0)
+}
+
+func TestInspectErrors(t *testing.T) {
+	_, err := runInspect(t, "/does/not/exist.qcow2")
+	assert.ErrorContains(t, err, "no such file or directory")
+
+	imagePath := filepath.Join(t.TempDir(), "disk.raw")
+	require.NoError(t, os.WriteFile(imagePath, nil, 0644))
+	_, err = runInspect(t, imagePath, "--format", "toml")
+	assert.EqualError(t, err, `unsupported format "toml", supported formats: yaml, json`)
+
+	mockInspectTools(t, "qed")
+	_, err = runInspect(t, imagePath)
+	assert.EqualError(t, err, `unsupported image format "qed"`)
+}
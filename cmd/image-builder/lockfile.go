@@ -0,0 +1,352 @@
+package main
+
+import (
+	"crypto/sha256"
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"slices"
+	"strings"
+
+	"github.com/osbuild/image-builder/pkg/container"
+	"github.com/osbuild/image-builder/pkg/depsolvednf"
+	"github.com/osbuild/image-builder/pkg/distro"
+	"github.com/osbuild/image-builder/pkg/flatpak"
+	"github.com/osbuild/image-builder/pkg/imagefilter"
+	"github.com/osbuild/image-builder/pkg/manifestgen"
+	"github.com/osbuild/image-builder/pkg/ostree"
+	"github.com/osbuild/image-builder/pkg/rpmmd"
+)
+
+const lockfileVersion = 1
+
+// lockfile contains everything that is resolved during the manifest
+// generation (packages, containers, ostree commits and flatpaks) so
+// that later manifests can be generated from the exact same content
+type lockfile struct {
+	Version int `json:"version"`
+	// Images is keyed by the basename of the image, e.g.
+	// "centos-9-qcow2-x86_64"
+	Images map[string]*lockedImage `json:"images"`
+
+	// fromSourcesBundle is set for the lockfile of a sources bundle,
+	// the packages come from the bundle and not from the repositories
+	fromSourcesBundle bool
+}
+
+type lockedImage struct {
+	// PackageSetsDigest is the digest of the package sets (without
+	// the repositories) that got depsolved, it is used to detect
+	// outdated lockfiles
+	PackageSetsDigest string                                `json:"package_sets_digest"`
+	Depsolved         map[string]depsolvednf.DepsolveResult `json:"depsolved"`
+	Containers        map[string][]container.Spec           `json:"containers,omitempty"`
+	OSTreeCommits     map[string][]ostree.CommitSpec        `json:"ostree_commits,omitempty"`
+	Flatpaks          map[string][]flatpak.Spec             `json:"flatpaks,omitempty"`
+	// Sources are the sources the containers, ostree commits and
+	// flatpaks got resolved from, in the same order as the specs,
+	// they are used to detect changed sources
+	ContainerSources map[string][]string `json:"container_sources,omitempty"`
+	OSTreeSources    map[string][]string `json:"ostree_sources,omitempty"`
+	FlatpakSources   map[string][]string `json:"flatpak_sources,omitempty"`
+}
+
+func newLockfile() *lockfile {
+	return &lockfile{
+		Version: lockfileVersion,
+		Images:  make(map[string]*lockedImage),
+	}
+}
+
+func readLockfile(path string) (*lockfile, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, fmt.Errorf("cannot open lockfile: %w", err)
+	}
+	defer f.Close()
+
+	var lf lockfile
+	dec := json.NewDecoder(f)
+	dec.DisallowUnknownFields()
+	if err := dec.Decode(&lf); err != nil {
+		return nil, fmt.Errorf("cannot parse lockfile %s: %w", path, err)
+	}
+	if lf.Version != lockfileVersion {
+		return nil, fmt.Errorf("unsupported lockfile version %d, supported: %d", lf.Version, lockfileVersion)
+	}
+	return &lf, nil
+}
+
+func (lf *lockfile) write(path string) error {
+	data, err := json.MarshalIndent(lf, "", "  ")
+	if err != nil {
+		return err
+	}
+	// #nosec: G306
+	return os.WriteFile(path, append(data, '\n'), 0644)
+}
+
+// packageSetsDigest returns a digest of the given package sets, the
+// repositories are not part of the digest as they are checked
+// separately. The order of the packages is not stable between runs
+// (and does not matter for the depsolve) so the lists are sorted.
+func packageSetsDigest(packageSets map[string][]rpmmd.PackageSet) (string, error) {
+	stripped := make(map[string][]rpmmd.PackageSet, len(packageSets))
+	for plName, sets := range packageSets {
+		for _, set := range sets {
+			stripped[plName] = append(stripped[plName], rpmmd.PackageSet{
+				Include:         slices.Sorted(slices.Values(set.Include)),
+				Exclude:         slices.Sorted(slices.Values(set.Exclude)),
+				EnabledModules:  slices.Sorted(slices.Values(set.EnabledModules)),
+				InstallWeakDeps: set.InstallWeakDeps,
+			})
+		}
+	}
+	// json sorts map keys so this is stable
+	data, err := json.Marshal(stripped)
+	if err != nil {
+		return "", err
+	}
+	return fmt.Sprintf("sha256:%x", sha256.Sum256(data)), nil
+}
+
+// record wraps the resolvers of the given options so that all the
+// resolved content is added to the lockfile, currentImg returns the
+// image that is currently generated
+func (lf *lockfile) record(mgOptions *manifestgen.Options, currentImg func() *imagefilter.Result) {
+	entry := func() *lockedImage {
+		key := basenameFor(currentImg(), "")
+		if lf.Images[key] == nil {
+			lf.Images[key] = &lockedImage{}
+		}
+		return lf.Images[key]
+	}
+
+	depsolve := mgOptions.Depsolve
+	if depsolve == nil {
+		depsolve = manifestgen.DefaultDepsolve
+	}
+	mgOptions.Depsolve = func(solver *depsolvednf.Solver, cacheDir string, depsolveWarningsOutput io.Writer, packageSets map[string][]rpmmd.PackageSet, d distro.Distro, arch string) (map[string]depsolvednf.DepsolveResult, error) {
+		res, err := depsolve(solver, cacheDir, depsolveWarningsOutput, packageSets, d, arch)
+		if err != nil {
+			return nil, err
+		}
+		digest, err := packageSetsDigest(packageSets)
+		if err != nil {
+			return nil, err
+		}
+		e := entry()
+		e.PackageSetsDigest = digest
+		e.Depsolved = res
+		return res, nil
+	}
+
+	containerResolver := mgOptions.ContainerResolver
+	if containerResolver == nil {
+		containerResolver = manifestgen.DefaultContainerResolver
+	}
+	mgOptions.ContainerResolver = func(containerSources map[string][]container.SourceSpec, archName string) (map[string][]container.Spec, error) {
+		res, err := containerResolver(containerSources, archName)
+		if err != nil {
+			return nil, err
+		}
+		e := entry()
+		e.Containers = res
+		e.ContainerSources = sourceIDs(containerSources, containerSourceID)
+		return res, nil
+	}
+
+	commitResolver := mgOptions.CommitResolver
+	if commitResolver == nil {
+		commitResolver = ostree.ResolveAll
+	}
+	mgOptions.CommitResolver = func(commitSources map[string][]ostree.SourceSpec) (map[string][]ostree.CommitSpec, error) {
+		res, err := commitResolver(commitSources)
+		if err != nil {
+			return nil, err
+		}
+		e := entry()
+		e.OSTreeCommits = res
+		e.OSTreeSources = sourceIDs(commitSources, ostreeSourceID)
+		return res, nil
+	}
+
+	flatpakResolver := mgOptions.FlatpakResolver
+	if flatpakResolver == nil {
+		flatpakResolver = flatpak.ResolveAll
+	}
+	mgOptions.FlatpakResolver = func(flatpakSources map[string][]flatpak.SourceSpec) (map[string][]flatpak.Spec, error) {
+		res, err := flatpakResolver(flatpakSources)
+		if err != nil {
+			return nil, err
+		}
+		e := entry()
+		e.Flatpaks = res
+		e.FlatpakSources = sourceIDs(flatpakSources, flatpakSourceID)
+		return res, nil
+	}
+}
+
+// used in tests
+var fetchRepoMetadata = func(solver *depsolvednf.Solver, repos []rpmmd.RepoConfig) (rpmmd.PackageList, error) {
+	return solver.FetchMetadata(repos)
+}
+
+// checkLockedPackagesAvailable ensures that all the locked packages
+// can still be found in the configured repositories of the package sets
+func checkLockedPackagesAvailable(solver *depsolvednf.Solver, key string, packageSets map[string][]rpmmd.PackageSet, locked map[string]depsolvednf.DepsolveResult) error {
+	var missing []string
+	for plName, sets := range packageSets {
+		var repos []rpmmd.RepoConfig
+		for _, set := range sets {
+			for _, repo := range set.Repositories {
+				if !slices.ContainsFunc(repos, func(r rpmmd.RepoConfig) bool { return r.Hash() == repo.Hash() }) {
+					repos = append(repos, repo)
+				}
+			}
+		}
+		if len(repos) == 0 {
+			continue
+		}
+		available, err := fetchRepoMetadata(solver, repos)
+		if err != nil {
+			return fmt.Errorf("cannot fetch repository metadata to check the lockfile: %w", err)
+		}
+		checksums := make(map[string][]rpmmd.Checksum, len(available))
+		for _, pkg := range available {
+			checksums[pkg.FullNEVRA()] = append(checksums[pkg.FullNEVRA()], pkg.Checksum)
+		}
+		for _, pkg := range locked[plName].Transactions.AllPackages() {
+			nevra := pkg.FullNEVRA()
+			candidates, ok := checksums[nevra]
+			// not all repositories provide checksums in their
+			// metadata, the NEVRA has to be enough then
+			found := ok && (pkg.Checksum.Value == "" || slices.ContainsFunc(candidates, func(c rpmmd.Checksum) bool {
+				return c.Value == "" || c == pkg.Checksum
+			}))
+			if !found && !slices.Contains(missing, nevra) {
+				missing = append(missing, nevra)
+			}
+		}
+	}
+	if len(missing) > 0 {
+		slices.Sort(missing)
+		return fmt.Errorf("locked packages for %q are no longer available from the configured repositories: %s", key, strings.Join(missing, ", "))
+	}
+	return nil
+}
+
+// rerunHint tells how to write an up to date lockfile
+func (lf *lockfile) rerunHint() string {
+	if lf.fromSourcesBundle {
+		return `re-run "image-builder fetch"`
+	}
+	return `re-run "image-builder lock"`
+}
+
+func containerSourceID(src container.SourceSpec) string {
+	id := src.Source
+	if src.Name != "" {
+		id += " as " + src.Name
+	}
+	if src.Local {
+		id += " (local)"
+	}
+	return id
+}
+
+func ostreeSourceID(src ostree.SourceSpec) string {
+	return src.Ref + "@" + src.URL
+}
+
+func flatpakSourceID(src flatpak.SourceSpec) string {
+	return src.Reference.String() + "@" + src.Registry.URI
+}
+
+// sourceIDs returns the ids of the given sources for every pipeline
+func sourceIDs[S any](sources map[string][]S, sourceID func(S) string) map[string][]string {
+	res := make(map[string][]string, len(sources))
+	for plName, srcs := range sources {
+		for _, src := range srcs {
+			res[plName] = append(res[plName], sourceID(src))
+		}
+	}
+	return res
+}
+
+// lockedSpecs returns the locked specs for every pipeline with sources,
+// every locked spec must have been resolved from the same source
+func lockedSpecs[S, T any](lf *lockfile, key, kind string, sources map[string][]S, sourceID func(S) string, lockedSources map[string][]string, locked map[string][]T) (map[string][]T, error) {
+	res := make(map[string][]T, len(sources))
+	for plName, srcs := range sources {
+		if len(srcs) == 0 {
+			continue
+		}
+		if len(locked[plName]) != len(srcs) || len(lockedSources[plName]) != len(srcs) {
+			return nil, fmt.Errorf("lockfile entry for %q does not match the %s of pipeline %q, %s", key, kind, plName, lf.rerunHint())
+		}
+		for i, src := range srcs {
+			if id := sourceID(src); id != lockedSources[plName][i] {
+				return nil, fmt.Errorf("lockfile entry for %q does not match the %s of pipeline %q (%s is not locked), %s", key, kind, plName, id, lf.rerunHint())
+			}
+		}
+		res[plName] = locked[plName]
+	}
+	return res, nil
+}
+
+// apply replaces the resolvers of the given options so that the
+// locked content is used instead of resolving it again, currentImg
+// returns the image that is currently generated
+func (lf *lockfile) apply(mgOptions *manifestgen.Options, currentImg func() *imagefilter.Result) {
+	entry := func() (string, *lockedImage, error) {
+		key := basenameFor(currentImg(), "")
+		e := lf.Images[key]
+		if e == nil {
+			return key, nil, fmt.Errorf("lockfile has no entry for %q, %s", key, lf.rerunHint())
+		}
+		return key, e, nil
+	}
+
+	mgOptions.Depsolve = func(solver *depsolvednf.Solver, cacheDir string, depsolveWarningsOutput io.Writer, packageSets map[string][]rpmmd.PackageSet, d distro.Distro, arch string) (map[string]depsolvednf.DepsolveResult, error) {
+		key, e, err := entry()
+		if err != nil {
+			return nil, err
+		}
+		digest, err := packageSetsDigest(packageSets)
+		if err != nil {
+			return nil, err
+		}
+		if digest != e.PackageSetsDigest {
+			return nil, fmt.Errorf("lockfile entry for %q is out of date (the package sets changed), %s", key, lf.rerunHint())
+		}
+		if !lf.fromSourcesBundle {
+			if err := checkLockedPackagesAvailable(solver, key, packageSets, e.Depsolved); err != nil {
+				return nil, err
+			}
+		}
+		return e.Depsolved, nil
+	}
+	mgOptions.ContainerResolver = func(containerSources map[string][]container.SourceSpec, archName string) (map[string][]container.Spec, error) {
+		key, e, err := entry()
+		if err != nil {
+			return nil, err
+		}
+		return lockedSpecs(lf, key, "containers", containerSources, containerSourceID, e.ContainerSources, e.Containers)
+	}
+	mgOptions.CommitResolver = func(commitSources map[string][]ostree.SourceSpec) (map[string][]ostree.CommitSpec, error) {
+		key, e, err := entry()
+		if err != nil {
+			return nil, err
+		}
+		return lockedSpecs(lf, key, "ostree commits", commitSources, ostreeSourceID, e.OSTreeSources, e.OSTreeCommits)
+	}
+	mgOptions.FlatpakResolver = func(flatpakSources map[string][]flatpak.SourceSpec) (map[string][]flatpak.Spec, error) {
+		key, e, err := entry()
+		if err != nil {
+			return nil, err
+		}
+		return lockedSpecs(lf, key, "flatpaks", flatpakSources, flatpakSourceID, e.FlatpakSources, e.Flatpaks)
+	}
+}
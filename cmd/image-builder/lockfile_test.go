@@ -0,0 +1,214 @@
+package main_test
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	main "github.com/osbuild/image-builder/cmd/image-builder"
+	"github.com/osbuild/image-builder/pkg/depsolvednf"
+	"github.com/osbuild/image-builder/pkg/distro"
+	"github.com/osbuild/image-builder/pkg/rpmmd"
+	testrepos "github.com/osbuild/image-builder/test/data/repositories"
+)
+
+func failingDepsolve(solver *depsolvednf.Solver, cacheDir string, depsolveWarningsOutput io.Writer, packageSets map[string][]rpmmd.PackageSet, d distro.Distro, arch string) (map[string]depsolvednf.DepsolveResult, error) {
+	return nil, fmt.Errorf("depsolve must not be called")
+}
+
+type testLockfile struct {
+	Version int `json:"version"`
+	Images  map[string]struct {
+		PackageSetsDigest string                                `json:"package_sets_digest"`
+		Depsolved         map[string]depsolvednf.DepsolveResult `json:"depsolved"`
+		Containers        map[string][]json.RawMessage          `json:"containers"`
+	} `json:"images"`
+}
+
+func readTestLockfile(t *testing.T, path string) *testLockfile {
+	data, err := os.ReadFile(path)
+	require.NoError(t, err)
+	var lf testLockfile
+	require.NoError(t, json.Unmarshal(data, &lf))
+	return &lf
+}
+
+// lockedPackages returns all the packages of the given lockfile
+func lockedPackages(lf *testLockfile) rpmmd.PackageList {
+	var pkgs rpmmd.PackageList
+	for _, img := range lf.Images {
+		for _, res := range img.Depsolved {
+			pkgs = append(pkgs, res.Transactions.AllPackages()...)
+		}
+	}
+	return pkgs
+}
+
+func runManifest(t *testing.T, args ...string) ([]byte, error) {
+	var fakeStdout bytes.Buffer
+	restore := main.MockOsStdout(&fakeStdout)
+	defer restore()
+
+	restore = main.MockOsArgs(append([]string{"manifest"}, args...))
+	defer restore()
+
+	err := main.Run()
+	return fakeStdout.Bytes(), err
+}
+
+func lockForTest(t *testing.T, args ...string) string {
+	restore := main.MockManifestgenDepsolver(fakeDepsolve)
+	defer restore()
+
+	lockfilePath := filepath.Join(t.TempDir(), "test.lock")
+	restore = main.MockOsArgs(append([]string{"lock", "--lockfile", lockfilePath}, args...))
+	defer restore()
+
+	err := main.Run()
+	require.NoError(t, err)
+	return lockfilePath
+}
+
+func TestLockManifestFromLockfile(t *testing.T) {
+	restore := main.MockManifestgenContainerResolver(fakeContainerResolver)
+	defer restore()
+	restore = main.MockNewRepoRegistry(testrepos.New)
+	defer restore()
+
+	bpPath := makeTestBlueprint(t, testBlueprint)
+	lockfilePath := lockForTest(t, "qcow2", "ami", "--distro", "centos-9", "--arch", "x86_64", "--blueprint", bpPath)
+
+	lf := readTestLockfile(t, lockfilePath)
+	assert.Equal(t, 1, lf.Version)
+	require.Len(t, lf.Images, 2)
+	for _, key := range []string{"centos-9-qcow2-x86_64", "centos-9-ami-x86_64"} {
+		img := lf.Images[key]
+		assert.Regexp(t, "^sha256:[0-9a-f]{64}$", img.PackageSetsDigest)
+		assert.Contains(t, img.Depsolved, "os")
+		assert.NotEmpty(t, img.Depsolved["os"].Transactions.AllPackages())
+		assert.Len(t, img.Containers["os"], 1)
+	}
+
+	restore = main.MockManifestgenDepsolver(fakeDepsolve)
+	defer restore()
+	expected, err := runManifest(t, "qcow2", "--distro", "centos-9", "--arch", "x86_64", "--blueprint", bpPath, "--seed", "0")
+	require.NoError(t, err)
+
+	// the lockfile is used, nothing gets depsolved or resolved
+	restore = main.MockManifestgenDepsolver(failingDepsolve)
+	defer restore()
+	restore = main.MockManifestgenContainerResolver(nil)
+	defer restore()
+	var checkedRepos [][]rpmmd.RepoConfig
+	restore = main.MockFetchRepoMetadata(func(repos []rpmmd.RepoConfig) (rpmmd.PackageList, error) {
+		checkedRepos = append(checkedRepos, repos)
+		return lockedPackages(lf), nil
+	})
+	defer restore()
+
+	mf, err := runManifest(t, "qcow2", "--distro", "centos-9", "--arch", "x86_64", "--blueprint", bpPath, "--seed", "0", "--lockfile", lockfilePath)
+	require.NoError(t, err)
+	assert.Equal(t, string(expected), string(mf))
+	assert.NotEmpty(t, checkedRepos)
+	for _, repos := range checkedRepos {
+		assert.NotEmpty(t, repos)
+	}
+}
+
+func TestManifestLockfileErrors(t *testing.T) {
+	restore := main.MockManifestgenContainerResolver(fakeContainerResolver)
+	defer restore()
+	restore = main.MockNewRepoRegistry(testrepos.New)
+	defer restore()
+
+	lockfilePath := lockForTest(t, "qcow2", "--distro", "centos-9", "--arch", "x86_64")
+	lf := readTestLockfile(t, lockfilePath)
+
+	restore = main.MockManifestgenDepsolver(failingDepsolve)
+	defer restore()
+
+	for _, tc := range []struct {
+		name        string
+		available   func() rpmmd.PackageList
+		args        []string
+		expectedErr string
+	}{
+		{
+			name: "missing-package",
+			available: func() rpmmd.PackageList {
+				pkgs := lockedPackages(lf)
+				for i := range pkgs {
+					pkgs[i].Checksum.Value = "0000"
+				}
+				return pkgs
+			},
+			args:        []string{"qcow2"},
+			expectedErr: `locked packages for "centos-9-qcow2-x86_64" are no longer available from the configured repositories: `,
+		},
+		{
+			name:        "other-image-type",
+			available:   func() rpmmd.PackageList { return lockedPackages(lf) },
+			args:        []string{"ami"},
+			expectedErr: `lockfile has no entry for "centos-9-ami-x86_64", re-run "image-builder lock"`,
+		},
+		{
+			name:        "outdated",
+			available:   func() rpmmd.PackageList { return lockedPackages(lf) },
+			args:        []string{"qcow2", "--blueprint", makeTestBlueprint(t, "packages = [{name = \"tmux\"}]\n")},
+			expectedErr: `lockfile entry for "centos-9-qcow2-x86_64" is out of date (the package sets changed), re-run "image-builder lock"`,
+		},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			restore := main.MockFetchRepoMetadata(func(repos []rpmmd.RepoConfig) (rpmmd.PackageList, error) {
+				return tc.available(), nil
+			})
+			defer restore()
+
+			_, err := runManifest(t, append(tc.args, "--distro", "centos-9", "--arch", "x86_64", "--lockfile", lockfilePath)...)
+			require.Error(t, err)
+			assert.Contains(t, err.Error(), tc.expectedErr)
+		})
+	}
+}
+
+func TestManifestLockfileChangedContainer(t *testing.T) {
+	restore := main.MockManifestgenContainerResolver(fakeContainerResolver)
+	defer restore()
+	restore = main.MockNewRepoRegistry(testrepos.New)
+	defer restore()
+
+	lockfilePath := lockForTest(t, "qcow2", "--distro", "centos-9", "--arch", "x86_64", "--blueprint", makeTestBlueprint(t, testBlueprint))
+	lf := readTestLockfile(t, lockfilePath)
+
+	restore = main.MockManifestgenDepsolver(failingDepsolve)
+	defer restore()
+	restore = main.MockFetchRepoMetadata(func(repos []rpmmd.RepoConfig) (rpmmd.PackageList, error) {
+		return lockedPackages(lf), nil
+	})
+	defer restore()
+
+	// same number of containers but a different one
+	bpPath := makeTestBlueprint(t, strings.Replace(testBlueprint, "fedora-minimal", "fedora-other", 1))
+	_, err := runManifest(t, "qcow2", "--distro", "centos-9", "--arch", "x86_64", "--blueprint", bpPath, "--lockfile", lockfilePath)
+	assert.EqualError(t, err, `lockfile entry for "centos-9-qcow2-x86_64" does not match the containers of pipeline "os" (registry.gitlab.com/redhat/services/products/image-builder/ci/osbuild-composer/fedora-other is not locked), re-run "image-builder lock"`)
+}
+
+func TestManifestLockfileUnsupportedVersion(t *testing.T) {
+	lockfilePath := filepath.Join(t.TempDir(), "test.lock")
+	err := os.WriteFile(lockfilePath, []byte(`{"version": 2, "images": {}}`), 0644)
+	require.NoError(t, err)
+
+	restore := main.MockNewRepoRegistry(testrepos.New)
+	defer restore()
+
+	_, err = runManifest(t, "qcow2", "--distro", "centos-9", "--lockfile", lockfilePath)
+	assert.EqualError(t, err, "unsupported lockfile version 2, supported: 1")
+}
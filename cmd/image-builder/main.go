@@ -2,6 +2,7 @@ package main
 
 import (
 	"bytes"
+	"context"
 	"encoding/json"
 	"errors"
 	"fmt"
@@ -10,7 +11,9 @@ import (
 	"os"
 	"os/signal"
 	"path/filepath"
+	"slices"
 	"strings"
+	"sync"
 	"syscall"
 
 	"github.com/spf13/cobra"
@@ -28,6 +31,7 @@ import (
 	"github.com/osbuild/image-builder/pkg/manifestgen"
 	"github.com/osbuild/image-builder/pkg/osbuild"
 	"github.com/osbuild/image-builder/pkg/ostree"
+	"github.com/osbuild/image-builder/pkg/platform"
 	"github.com/osbuild/image-builder/pkg/progress"
 	"github.com/osbuild/image-builder/pkg/rhsm/facts"
 	"github.com/osbuild/image-builder/pkg/sbom"
@@ -241,13 +245,21 @@ func subscriptionImageOptions(cmd *cobra.Command) (*subscription.ImageOptions, e
 
 type cmdManifestWrapperOptions struct {
 	useBootstrapIfNeeded bool
+	// recordLock collects all the resolved content into the given
+	// lockfile, the "--lockfile" option is ignored then
+	recordLock *lockfile
+	// sourcesBundle makes the manifests use the lockfile and the
+	// sources of the given bundle, nothing gets downloaded then
+	sourcesBundle *sourcesBundle
 }
 
 // used in tests
 var manifestgenDepsolver manifestgen.DepsolveFunc
 var manifestgenContainerResolver manifestgen.ContainerResolverFunc
 
-func getImage(cmd *cobra.Command, args []string) (*imagefilter.Result, error) {
+// getImages returns the images for the given image types, all
+// images share the same distro and architecture
+func getImages(cmd *cobra.Command, imgTypeStrs []string) ([]*imagefilter.Result, error) {
 	repoDir, err := cmd.Flags().GetString("force-repo-dir")
 	if err != nil {
 		return nil, err
@@ -290,14 +302,18 @@ func getImage(cmd *cobra.Command, args []string) (*imagefilter.Result, error) {
 	if err != nil {
 		return nil, err
 	}
-	imgTypeStr := args[0]
+	for i, imgTypeStr := range imgTypeStrs {
+		if slices.Contains(imgTypeStrs[:i], imgTypeStr) {
+			return nil, fmt.Errorf("image type %q given more than once", imgTypeStr)
+		}
+	}
 
-	var img *imagefilter.Result
+	var imgs []*imagefilter.Result
 	if bootcRef != "" {
 		// The behavior of anaconda-iso without special mTLS setup is different
 		// from bib so instead of introducing subtle incompatibilities just error
 		// here
-		if imgTypeStr == "anaconda-iso" {
+		if slices.Contains(imgTypeStrs, "anaconda-iso") {
 			return nil, fmt.Errorf(`image type bootc "anaconda-iso" is not supported with image-builder, please consider switching to "bootc-installer" or use bootc-image-builder`)
 		}
 		bootcInfo, err := bootc.ResolveBootcInfo(bootcRef)
@@ -345,11 +361,13 @@ func getImage(cmd *cobra.Command, args []string) (*imagefilter.Result, error) {
 		if err != nil {
 			return nil, err
 		}
-		imgType, err := archi.GetImageType(imgTypeStr)
-		if err != nil {
-			return nil, err
+		for _, imgTypeStr := range imgTypeStrs {
+			imgType, err := archi.GetImageType(imgTypeStr)
+			if err != nil {
+				return nil, err
+			}
+			imgs = append(imgs, &imagefilter.Result{ImgType: imgType, Repos: nil})
 		}
-		img = &imagefilter.Result{ImgType: imgType, Repos: nil}
 	} else {
 		var bpDistroName string
 		blueprintPath, err := cmd.Flags().GetString("blueprint")
@@ -373,18 +391,25 @@ func getImage(cmd *cobra.Command, args []string) (*imagefilter.Result, error) {
 			ForceRepos:   forceRepos,
 			ForceDefsDir: forceDefsDir,
 		}
-		img, err = getOneImage(distroStr, imgTypeStr, archStr, repoOpts)
-		if err != nil {
-			return nil, err
+		for _, imgTypeStr := range imgTypeStrs {
+			img, err := getOneImage(distroStr, imgTypeStr, archStr, repoOpts)
+			if err != nil {
+				return nil, err
+			}
+			imgs = append(imgs, img)
 		}
 	}
-	if len(img.ImgType.Exports()) > 1 {
-		return nil, fmt.Errorf("image %q has multiple exports: this is current unsupport: please report this as a bug", basenameFor(img, ""))
+	for _, img := range imgs {
+		if len(img.ImgType.Exports()) > 1 {
+			return nil, fmt.Errorf("image %q has multiple exports: this is current unsupport: please report this as a bug", basenameFor(img, ""))
+		}
 	}
-	return img, err
+	return imgs, nil
 }
 
-func generateManifest(pbar progress.ProgressBar, cmd *cobra.Command, args []string, img *imagefilter.Result, wd io.Writer, wrapperOpts *cmdManifestWrapperOptions) ([]byte, error) {
+// generateManifests generates the manifests for all the given images
+// with a single manifest generator
+func generateManifests(pbar progress.ProgressBar, cmd *cobra.Command, imgs []*imagefilter.Result, wd io.Writer, wrapperOpts *cmdManifestWrapperOptions) ([][]byte, error) {
 	if wrapperOpts == nil {
 		wrapperOpts = &cmdManifestWrapperOptions{}
 	}
@@ -462,6 +487,10 @@ func generateManifest(pbar progress.ProgressBar, cmd *cobra.Command, args []stri
 	if err != nil {
 		return nil, err
 	}
+	lockfilePath, err := cmd.Flags().GetString("lockfile")
+	if err != nil {
+		return nil, err
+	}
 	var customSeed *int64
 	if cmd.Flags().Changed("seed") {
 		seedFlagVal, err := cmd.Flags().GetInt64("seed")
@@ -512,9 +541,7 @@ func generateManifest(pbar progress.ProgressBar, cmd *cobra.Command, args []stri
 		forceRepos = []string{"https://example.com/not-used"}
 	}
 
-	imgTypeStr := args[0]
 	pbar.SetPulseMsgf("Manifest generation step")
-	pbar.SetMessagef("Building manifest for %s-%s", distroStr, imgTypeStr)
 
 	mgOptions := manifestgen.Options{
 		Cachedir:               rpmmdCacheDir,
@@ -525,9 +552,29 @@ func generateManifest(pbar progress.ProgressBar, cmd *cobra.Command, args []stri
 		ContainerResolver:      manifestgenContainerResolver,
 	}
 
-	mgOptions.UseBootstrapContainer = wrapperOpts.useBootstrapIfNeeded && (img.ImgType.Arch().Name() != arch.Current().String())
+	// all images have the same architecture
+	imgArch := imgs[0].ImgType.Arch().Name()
+	mgOptions.UseBootstrapContainer = wrapperOpts.useBootstrapIfNeeded && (imgArch != arch.Current().String())
 	if mgOptions.UseBootstrapContainer {
-		fmt.Fprintf(os.Stderr, "WARNING: using experimental cross-architecture building to build %q\n", img.ImgType.Arch().Name())
+		fmt.Fprintf(os.Stderr, "WARNING: using experimental cross-architecture building to build %q\n", imgArch)
+	}
+
+	// the writers are shared by all images, img is the image
+	// that the manifest is currently generated for
+	var img *imagefilter.Result
+	currentImg := func() *imagefilter.Result { return img }
+
+	switch {
+	case wrapperOpts.recordLock != nil:
+		wrapperOpts.recordLock.record(&mgOptions, currentImg)
+	case wrapperOpts.sourcesBundle != nil:
+		wrapperOpts.sourcesBundle.apply(&mgOptions, currentImg)
+	case lockfilePath != "":
+		lf, err := readLockfile(lockfilePath)
+		if err != nil {
+			return nil, err
+		}
+		lf.apply(&mgOptions, currentImg)
 	}
 
 	repos, err := newRepoRegistry(repoDir, extraRepos)
@@ -535,8 +582,8 @@ func generateManifest(pbar progress.ProgressBar, cmd *cobra.Command, args []stri
 		return nil, err
 	}
 	if withSBOM {
-		outputDir := basenameFor(img, outputDir)
 		mgOptions.SBOMWriter = func(filename string, content io.Reader, docType sbom.StandardType) error {
+			outputDir := basenameFor(img, outputDir)
 			filename = fmt.Sprintf("%s.%s", basenameFor(img, outputFilename), strings.SplitN(filename, ".", 2)[1])
 			return fileWriter(outputDir, filename, content)
 		}
@@ -553,8 +600,8 @@ func generateManifest(pbar progress.ProgressBar, cmd *cobra.Command, args []stri
 	}
 
 	if withRPMList {
-		outputDir := basenameFor(img, outputDir)
 		mgOptions.RPMListWriter = func(filename string, content io.Reader) error {
+			outputDir := basenameFor(img, outputDir)
 			filename = fmt.Sprintf("%s.%s", basenameFor(img, outputFilename), filename)
 			return fileWriter(outputDir, filename, content)
 		}
@@ -565,46 +612,115 @@ func generateManifest(pbar progress.ProgressBar, cmd *cobra.Command, args []stri
 		return nil, err
 	}
 
-	imgOpts := &distro.ImageOptions{
-		Facts:        &facts.ImageOptions{APIType: facts.IBCLI_APITYPE},
-		OSTree:       ostreeImgOpts,
-		Subscription: subscription,
-		Size:         imageSize.Uint64(),
-		Bootc: &distro.BootcImageOptions{
-			InstallerPayloadRef:      bootcInstallerPayloadRef,
-			OmitDefaultKernelArgs:    bootcOmitDefaultKernelArgs,
-			UseRemoteContainerSource: bootcRemote,
-		},
-		Preview: preview,
+	var mfs [][]byte
+	for _, img = range imgs {
+		pbar.SetMessagef("Building manifest for %s-%s", distroStr, img.ImgType.Name())
+		imgOpts := &distro.ImageOptions{
+			Facts:        &facts.ImageOptions{APIType: facts.IBCLI_APITYPE},
+			OSTree:       ostreeImgOpts,
+			Subscription: subscription,
+			Size:         imageSize.Uint64(),
+			Bootc: &distro.BootcImageOptions{
+				InstallerPayloadRef:      bootcInstallerPayloadRef,
+				OmitDefaultKernelArgs:    bootcOmitDefaultKernelArgs,
+				UseRemoteContainerSource: bootcRemote,
+			},
+			Preview: preview,
+		}
+
+		mf, err := mg.Generate(bp, img.ImgType, imgOpts)
+		if err != nil {
+			return nil, err
+		}
+		if wrapperOpts.sourcesBundle != nil {
+			if err := wrapperOpts.sourcesBundle.checkManifest(basenameFor(img, ""), mf); err != nil {
+				return nil, err
+			}
+		}
+		var pretty bytes.Buffer
+		if err := json.Indent(&pretty, []byte(mf), "", "    "); err != nil {
+			return nil, err
+		}
+		mfs = append(mfs, append(pretty.Bytes(), '\n'))
 	}
 
-	mf, err := mg.Generate(bp, img.ImgType, imgOpts)
+	return mfs, nil
+}
+
+func cmdManifest(cmd *cobra.Command, args []string) error {
+	pbar, err := progress.New("", progress.ProgressConfig{})
 	if err != nil {
-		return nil, err
+		return err
 	}
-	var pretty bytes.Buffer
-	if err := json.Indent(&pretty, []byte(mf), "", "    "); err != nil {
-		return nil, err
+	imgs, err := getImages(cmd, args)
+	if err != nil {
+		return err
+	}
+	mfs, err := generateManifests(pbar, cmd, imgs, io.Discard, nil)
+	if err != nil {
+		return err
 	}
+	// multiple manifests are written one after the other
+	for _, mf := range mfs {
+		if _, err := cmd.OutOrStdout().Write(mf); err != nil {
+			return err
+		}
+	}
+	return nil
+}
 
-	return append(pretty.Bytes(), '\n'), nil
+func cmdLock(cmd *cobra.Command, args []string) error {
+	lockfilePath, err := cmd.Flags().GetString("lockfile")
+	if err != nil {
+		return err
+	}
+	pbar, err := progress.New("", progress.ProgressConfig{})
+	if err != nil {
+		return err
+	}
+	imgs, err := getImages(cmd, args)
+	if err != nil {
+		return err
+	}
+	lf := newLockfile()
+	// lock the same content that "build" would use
+	opts := &cmdManifestWrapperOptions{
+		useBootstrapIfNeeded: true,
+		recordLock:           lf,
+	}
+	if _, err := generateManifests(pbar, cmd, imgs, io.Discard, opts); err != nil {
+		return err
+	}
+	return lf.write(lockfilePath)
 }
 
-func cmdManifest(cmd *cobra.Command, args []string) error {
+func cmdFetch(cmd *cobra.Command, args []string) error {
+	bundlePath, err := cmd.Flags().GetString("sources-bundle")
+	if err != nil {
+		return err
+	}
+	if cmd.Flags().Changed("lockfile") {
+		return fmt.Errorf("cannot use --lockfile with \"fetch\", the sources bundle contains its own lockfile")
+	}
 	pbar, err := progress.New("", progress.ProgressConfig{})
 	if err != nil {
 		return err
 	}
-	img, err := getImage(cmd, args)
+	imgs, err := getImages(cmd, args)
 	if err != nil {
 		return err
 	}
-	mf, err := generateManifest(pbar, cmd, args, img, io.Discard, nil)
+	lf := newLockfile()
+	// fetch the same content that "build" would use
+	opts := &cmdManifestWrapperOptions{
+		useBootstrapIfNeeded: true,
+		recordLock:           lf,
+	}
+	mfs, err := generateManifests(pbar, cmd, imgs, io.Discard, opts)
 	if err != nil {
 		return err
 	}
-	_, err = osStdout.Write(mf)
-	return err
+	return writeSourcesBundle(pbar, bundlePath, lf, mfs)
 }
 
 func progressFromCmd(cmd *cobra.Command, conf progress.ProgressConfig) (progress.ProgressBar, error) {
@@ -623,7 +739,33 @@ func progressFromCmd(cmd *cobra.Command, conf progress.ProgressConfig) (progress
 	return progress.New(progressType, conf)
 }
 
+// onSignal calls f when the process is interrupted or terminated. The
+// signal handler is removed again when the returned function is called.
+func onSignal(f func()) (stop func()) {
+	ctx, cancel := context.WithCancel(context.Background())
+	c := make(chan os.Signal, 1)
+	signal.Notify(c, os.Interrupt, syscall.SIGTERM)
+	go func() {
+		select {
+		case <-c:
+			f()
+		case <-ctx.Done():
+		}
+	}()
+	return func() {
+		signal.Stop(c)
+		cancel()
+	}
+}
+
 func cmdBuild(cmd *cobra.Command, args []string) error {
+	planPath, err := cmd.Flags().GetString("plan")
+	if err != nil {
+		return err
+	}
+	if planPath != "" {
+		return cmdBuildPlan(cmd, planPath)
+	}
 	cacheDir, err := cmd.Flags().GetString("cache")
 	if err != nil {
 		return err
@@ -660,6 +802,72 @@ func cmdBuild(cmd *cobra.Command, args []string) error {
 	if err != nil {
 		return err
 	}
+	uploadTargets, err := uploadTargetsFromCmd(cmd)
+	if err != nil {
+		return err
+	}
+	uploadResultsPath, err := cmd.Flags().GetString("upload-results")
+	if err != nil {
+		return err
+	}
+	sourcesBundlePath, err := cmd.Flags().GetString("sources-bundle")
+	if err != nil {
+		return err
+	}
+	if sourcesBundlePath != "" && cmd.Flags().Changed("lockfile") {
+		return fmt.Errorf("cannot use --lockfile with --sources-bundle, the sources bundle contains its own lockfile")
+	}
+	checksums, err := cmd.Flags().GetStringArray("checksum")
+	if err != nil {
+		return err
+	}
+	if err := validateChecksumAlgorithms(checksums); err != nil {
+		return err
+	}
+	signKey, err := cmd.Flags().GetString("sign-key")
+	if err != nil {
+		return err
+	}
+	verifyReproducible, err := cmd.Flags().GetBool("verify-reproducible")
+	if err != nil {
+		return err
+	}
+	if verifyReproducible {
+		if len(uploadTargets) > 0 {
+			return fmt.Errorf("--verify-reproducible cannot be used with upload targets")
+		}
+		// the reproducibility check only keeps the image, it does
+		// not write any of the other artifacts
+		withSBOM, err := cmd.Flags().GetBool("with-sbom")
+		if err != nil {
+			return err
+		}
+		for _, opt := range []struct {
+			flag string
+			set  bool
+		}{
+			{"with-manifest", withManifest},
+			{"with-sbom", withSBOM},
+			{"with-buildlog", withBuildlog},
+			{"checksum", len(checksums) > 0},
+			{"sign-key", signKey != ""},
+		} {
+			if opt.set {
+				return fmt.Errorf("--verify-reproducible cannot be used with --%s", opt.flag)
+			}
+		}
+	}
+	if withUploadResult && len(uploadTargets) > 1 {
+		return fmt.Errorf("--with-upload-result only supports a single upload target, use --upload-results")
+	}
+	if len(args) > 1 {
+		if outputBasename != "" {
+			return fmt.Errorf("--output-name cannot be used with multiple image types")
+		}
+		if len(uploadTargets) > 0 {
+			return fmt.Errorf("upload targets cannot be used with multiple image types")
+		}
+	}
 	// Fail early if the cache directory is not writable, instead of
 	// waiting for osbuild to fail after slow manifest generation.
 	if err := os.MkdirAll(cacheDir, 0o755); err != nil {
@@ -677,18 +885,44 @@ func cmdBuild(cmd *cobra.Command, args []string) error {
 		return fmt.Errorf("running in VM outside container is not supported yet")
 	}
 
-	img, err := getImage(cmd, args)
+	// Fail early if the signing key cannot be used
+	var signer *artifactSigner
+	if signKey != "" {
+		var cleanup func()
+		signer, cleanup, err = newArtifactSigner(signKey)
+		if err != nil {
+			return err
+		}
+		defer cleanup()
+	}
+
+	opts := &cmdManifestWrapperOptions{
+		useBootstrapIfNeeded: true,
+	}
+	if sourcesBundlePath != "" {
+		bundle, cleanup, err := openSourcesBundle(sourcesBundlePath, cacheDir)
+		if err != nil {
+			return err
+		}
+		defer cleanup()
+		opts.sourcesBundle = bundle
+	}
+
+	imgs, err := getImages(cmd, args)
 	if err != nil {
 		return err
 	}
-	// Ensure the output directory exists before (file) progress starts.
-	outputDir = basenameFor(img, outputDir)
-	if err := os.MkdirAll(outputDir, 0o755); err != nil {
-		return fmt.Errorf("cannot create output base directory %s: %w", outputDir, err)
+	builds := make([]*imageBuild, len(imgs))
+	for i, img := range imgs {
+		// Ensure the output directory exists before (file) progress starts.
+		builds[i] = &imageBuild{img: img, outputDir: basenameFor(img, outputDir)}
+		if err := os.MkdirAll(builds[i].outputDir, 0o755); err != nil {
+			return fmt.Errorf("cannot create output base directory %s: %w", builds[i].outputDir, err)
+		}
 	}
 
 	pbar, err := progressFromCmd(cmd, progress.ProgressConfig{
-		FilePath: filepath.Join(outputDir, fmt.Sprintf("%s.progress", basenameFor(img, outputBasename))),
+		FilePath: builds[0].progressPath(outputBasename),
 		WithMsg:  true,
 	})
 	if err != nil {
@@ -697,97 +931,220 @@ func cmdBuild(cmd *cobra.Command, args []string) error {
 
 	pbar.Start()
 	defer pbar.Stop()
-	go func() {
-		c := make(chan os.Signal, 1)
-		signal.Notify(c, os.Interrupt, syscall.SIGTERM)
-		<-c
+	// the progress bar of the image that is built is replaced for
+	// every image, pbarMu guards the replacement
+	var pbarMu sync.Mutex
+	stopSignals := onSignal(func() {
+		pbarMu.Lock()
+		defer pbarMu.Unlock()
 		pbar.Stop()
-	}()
-
-	opts := &cmdManifestWrapperOptions{
-		useBootstrapIfNeeded: true,
+	})
+	defer stopSignals()
+
+	// koji imports the manifest, buildlog and SBOMs together with the image
+	withKoji := slices.Contains(uploadTargets, "koji")
+	if withKoji {
+		withManifest = true
+		withBuildlog = true
+		if err := cmd.Flags().Set("with-sbom", "true"); err != nil {
+			return err
+		}
 	}
 
 	// We discard any warnings from the depsolver until we figure out a better
 	// idea (likely in manifestgen)
-	mf, err := generateManifest(pbar, cmd, args, img, io.Discard, opts)
+	mfs, err := generateManifests(pbar, cmd, imgs, io.Discard, opts)
 	if err != nil {
 		return err
 	}
 
-	bootMode := img.ImgType.BootMode()
-	uploader, err := uploaderFor(cmd, img.ImgType.Name(), img.ImgType.Arch().Name(), &bootMode, "")
-	if errors.Is(err, ErrUploadTypeUnsupported) || errors.Is(err, ErrUploadConfigNotProvided) {
-		err = nil
+	if verifyReproducible {
+		var reports []*reproducibilityReport
+		var differences int
+		for i, b := range builds {
+			b.manifest = mfs[i]
+			report, err := verifyReproducibleBuild(pbar, b, cacheDir, outputBasename)
+			if err != nil {
+				return err
+			}
+			reports = append(reports, report)
+			differences += len(report.Differences)
+		}
+		pbar.Stop()
+		if err := writeReproducibilityReports(osStdout, reports, format == "json"); err != nil {
+			return err
+		}
+		if differences > 0 {
+			return fmt.Errorf("build is not reproducible: %d differences", differences)
+		}
+		return nil
 	}
 
-	if err != nil {
-		return err
+	explicitTargets := len(uploadTargets) > 0
+	for i, b := range builds {
+		b.manifest = mfs[i]
+		b.bootMode = b.img.ImgType.BootMode()
+
+		targets := uploadTargets
+		if !explicitTargets {
+			targets = []string{b.img.ImgType.Name()}
+		}
+		if withKoji {
+			if err := setKojiBuildOutputs(cmd, b.outputDir, basenameFor(b.img, outputBasename)); err != nil {
+				return err
+			}
+		}
+		for _, typeOrCloud := range targets {
+			uploader, err := uploaderFor(cmd, typeOrCloud, b.img.ImgType.Arch().Distro().Name(), b.img.ImgType.Arch().Name(), &b.bootMode, imagePathFor(b.img, b.outputDir, outputBasename))
+			// an explicit --to must always result in an upload
+			if !explicitTargets && (errors.Is(err, ErrUploadTypeUnsupported) || errors.Is(err, ErrUploadConfigNotProvided)) {
+				continue
+			}
+			if err != nil {
+				return err
+			}
+			b.uploads = append(b.uploads, uploadTarget{name: typeOrCloud, uploader: uploader})
+		}
 	}
 
-	if uploader != nil {
-		pbar.SetPulseMsgf("Checking cloud access")
-		if err := uploaderCheckWithProgress(pbar, uploader); err != nil {
-			return err
+	for _, b := range builds {
+		for _, upload := range b.uploads {
+			pbar.SetPulseMsgf("Checking cloud access")
+			if len(b.uploads) > 1 {
+				pbar.SetPulseMsgf("Checking access to %s", upload.name)
+			}
+			if err := uploaderCheckWithProgress(pbar, upload.uploader); err != nil {
+				return err
+			}
 		}
 	}
 
 	buildOpts := &buildOptions{
-		OutputDir:      outputDir,
 		OutputBasename: outputBasename,
 		StoreDir:       cacheDir,
 		WriteManifest:  withManifest,
 		WriteBuildlog:  withBuildlog,
 		Metrics:        withMetrics,
 		JSONOutput:     format == "json",
+		Checksums:      checksums,
+		Signer:         signer,
 	}
 	if runInVm {
 		buildOpts.InVm = []string{"image"}
 	}
+
+	// all images are built with the same store so that the
+	// downloaded sources and shared pipelines are reused
+	var results []uploadTargetResult
+	var buildErr error
+	for i, b := range builds {
+		if i > 0 {
+			nextPbar, err := progressFromCmd(cmd, progress.ProgressConfig{
+				FilePath: b.progressPath(outputBasename),
+				WithMsg:  true,
+			})
+			if err != nil {
+				return err
+			}
+			pbarMu.Lock()
+			pbar = nextPbar
+			pbarMu.Unlock()
+			pbar.Start()
+		}
+		var buildResults []uploadTargetResult
+		buildResults, buildErr = buildAndUploadImage(cmd, pbar, b, buildOpts, withUploadResult)
+		// stopping an already stopped progress bar is a no-op
+		pbar.Stop()
+		results = append(results, buildResults...)
+		if buildErr != nil {
+			break
+		}
+	}
+	if uploadResultsPath != "" && len(results) > 0 {
+		if err := writeUploadResults(uploadResultsPath, results); err != nil {
+			return err
+		}
+	}
+	return buildErr
+}
+
+// imageBuild is a single image of a build with (possibly) multiple
+// image types
+type imageBuild struct {
+	img       *imagefilter.Result
+	outputDir string
+	manifest  []byte
+	bootMode  platform.BootMode
+	uploads   []uploadTarget
+}
+
+func (b *imageBuild) progressPath(outputBasename string) string {
+	return filepath.Join(b.outputDir, fmt.Sprintf("%s.progress", basenameFor(b.img, outputBasename)))
+}
+
+// buildAndUploadImage builds the image and uploads it to its upload
+// targets. The upload results are returned even when the upload failed.
+func buildAndUploadImage(cmd *cobra.Command, pbar progress.ProgressBar, b *imageBuild, buildOpts *buildOptions, withUploadResult bool) ([]uploadTargetResult, error) {
+	opts := *buildOpts
+	opts.OutputDir = b.outputDir
+
 	pbar.SetPulseMsgf("Image building step")
-	imagePath, err := buildImage(pbar, img, mf, buildOpts)
+	imagePath, err := buildImage(pbar, b.img, b.manifest, &opts)
 	if err != nil {
-		return err
+		return nil, err
 	}
 	pbar.Stop()
 	fmt.Fprintf(osStdout, "Image build successful: %s\n", imagePath)
 
 	pbar, err = progressFromCmd(cmd, progress.ProgressConfig{
-		FilePath: filepath.Join(outputDir, fmt.Sprintf("%s.progress", basenameFor(img, outputBasename))),
+		FilePath: b.progressPath(opts.OutputBasename),
 		Bytes:    true,
 		Speed:    true,
 	})
 	if err != nil {
-		return err
+		return nil, err
 	}
 	// Default upload result to write out in case no uploader was specified
 	uploadResult := &cloud.UploadResult{
 		Provider: "LocalPath",
 		ImageID:  imagePath,
 	}
-	if uploader != nil {
+	results := []uploadTargetResult{{UploadResult: uploadResult}}
+	var uploadErr error
+	switch len(b.uploads) {
+	case 0:
+		// nothing to upload
+	case 1:
 		pbar.Start()
 		pbar.SetPulseMsgf("Uploading")
 		// XXX: integrate better into the progress, see bib
-		uploadResult, err = uploadImageWithProgress(uploader, pbar, imagePath)
-		if err != nil {
-			return err
+		uploadResult, uploadErr = uploadImageWithProgress(b.uploads[0].uploader, pbar, imagePath)
+		results = []uploadTargetResult{{Target: b.uploads[0].name, UploadResult: uploadResult}}
+		if uploadErr != nil {
+			results[0].Error = uploadErr.Error()
 		}
 		pbar.Stop()
+	default:
+		results = uploadImageToTargets(b.uploads, pbar, imagePath)
+		uploadErr = uploadResultsErr(results)
+		pbar.Stop()
+	}
+	if uploadErr != nil {
+		return results, uploadErr
 	}
 	if withUploadResult {
-		p := filepath.Join(outputDir, fmt.Sprintf("%s.upload-result", basenameFor(img, outputBasename)))
+		p := filepath.Join(b.outputDir, fmt.Sprintf("%s.upload-result", basenameFor(b.img, opts.OutputBasename)))
 		data, err := json.Marshal(uploadResult)
 		if err != nil {
-			return err
+			return results, err
 		}
 		// #nosec: G306
 		if err := os.WriteFile(p, data, 0640); err != nil {
-			return err
+			return results, err
 		}
 	}
 
-	return nil
+	return results, nil
 }
 
 func cmdDescribeImg(cmd *cobra.Command, args []string) error {
@@ -11,7 +11,9 @@ import (
 	"path/filepath"
 	"slices"
 	"strings"
+	"syscall"
 	"testing"
+	"time"
 
 	"github.com/spf13/cobra"
 	"github.com/stretchr/testify/assert"
@@ -1068,6 +1070,137 @@ func TestBuildIntegrationOutputFilename(t *testing.T) {
 	}
 }
 
+func TestBuildIntegrationMultipleImageTypes(t *testing.T) {
+	restore := main.MockManifestgenDepsolver(fakeDepsolve)
+	defer restore()
+
+	restore = main.MockManifestgenContainerResolver(fakeContainerResolver)
+	defer restore()
+
+	restore = main.MockNewRepoRegistry(testrepos.New)
+	defer restore()
+
+	var fakeStdout bytes.Buffer
+	restore = main.MockOsStdout(&fakeStdout)
+	defer restore()
+
+	tmpdir := t.TempDir()
+	outputDir := filepath.Join(tmpdir, "output")
+	restore = main.MockOsArgs([]string{
+		"build",
+		"qcow2",
+		"ami",
+		fmt.Sprintf("--blueprint=%s", makeTestBlueprint(t, testBlueprint)),
+		"--distro", "centos-9",
+		"--arch", "x86_64",
+		"--cache", tmpdir,
+		"--output-dir", outputDir,
+		"--with-manifest",
+	})
+	defer restore()
+
+	script := makeFakeOsbuildScript()
+	fakeOsbuildCmd := testutil.MockCommand(t, "osbuild", script)
+
+	err := main.Run()
+	require.NoError(t, err)
+
+	assert.Contains(t, fakeStdout.String(), "Image build successful: "+filepath.Join(outputDir, "centos-9-qcow2-x86_64.qcow2"))
+	assert.Contains(t, fakeStdout.String(), "Image build successful: "+filepath.Join(outputDir, "centos-9-ami-x86_64.raw"))
+
+	// osbuild runs once per image type, all with the same store
+	require.Equal(t, 2, len(fakeOsbuildCmd.CallArgsList()))
+	for _, osbuildCall := range fakeOsbuildCmd.CallArgsList() {
+		storePos := slices.Index(osbuildCall, "--store")
+		require.True(t, storePos > -1)
+		assert.Equal(t, tmpdir, osbuildCall[storePos+1])
+	}
+
+	expectedFiles := []string{
+		"centos-9-qcow2-x86_64.osbuild-manifest.json",
+		"centos-9-qcow2-x86_64.qcow2",
+		"centos-9-ami-x86_64.osbuild-manifest.json",
+		"centos-9-ami-x86_64.raw",
+	}
+	for _, expected := range expectedFiles {
+		assert.FileExists(t, filepath.Join(outputDir, expected))
+	}
+}
+
+func TestBuildIntegrationMultipleImageTypesErrors(t *testing.T) {
+	for _, tc := range []struct {
+		args        []string
+		expectedErr string
+	}{
+		{
+			[]string{"qcow2", "ami", "--output-name=foo"},
+			"--output-name cannot be used with multiple image types",
+		},
+		{
+			[]string{"qcow2", "ami", "--to=aws"},
+			"upload targets cannot be used with multiple image types",
+		},
+		{
+			[]string{"qcow2", "qcow2"},
+			`image type "qcow2" given more than once`,
+		},
+	} {
+		t.Run(tc.expectedErr, func(t *testing.T) {
+			restore := main.MockNewRepoRegistry(testrepos.New)
+			defer restore()
+
+			restore = main.MockOsArgs(append([]string{
+				"build",
+				"--distro=centos-9",
+				"--cache", t.TempDir(),
+			}, tc.args...))
+			defer restore()
+
+			err := main.Run()
+			assert.EqualError(t, err, tc.expectedErr)
+		})
+	}
+}
+
+func TestManifestIntegrationMultipleImageTypes(t *testing.T) {
+	restore := main.MockManifestgenDepsolver(fakeDepsolve)
+	defer restore()
+
+	restore = main.MockManifestgenContainerResolver(fakeContainerResolver)
+	defer restore()
+
+	restore = main.MockNewRepoRegistry(testrepos.New)
+	defer restore()
+
+	restore = main.MockOsArgs([]string{
+		"manifest",
+		"qcow2",
+		"ami",
+		"--arch=x86_64",
+		"--distro=centos-9",
+	})
+	defer restore()
+
+	var fakeStdout bytes.Buffer
+	restore = main.MockOsStdout(&fakeStdout)
+	defer restore()
+
+	err := main.Run()
+	require.NoError(t, err)
+
+	// the manifests are written one after the other
+	dec := json.NewDecoder(&fakeStdout)
+	var exports []string
+	for dec.More() {
+		var mf json.RawMessage
+		require.NoError(t, dec.Decode(&mf))
+		pipelineNames, err := manifesttest.PipelineNamesFrom(mf)
+		require.NoError(t, err)
+		exports = append(exports, pipelineNames[len(pipelineNames)-1])
+	}
+	assert.Equal(t, []string{"qcow2", "image"}, exports)
+}
+
 func TestBasenameFor(t *testing.T) {
 	restore := main.MockNewRepoRegistry(testrepos.New)
 	defer restore()
@@ -1313,3 +1446,16 @@ func TestCacheDirForUidNonRootFallback(t *testing.T) {
 	expected := filepath.Join(home, ".cache", "image-builder", "store")
 	assert.Equal(t, expected, main.CacheDirForUid(1000))
 }
+
+func TestOnSignal(t *testing.T) {
+	called := make(chan struct{})
+	stop := main.OnSignal(func() { close(called) })
+	defer stop()
+
+	require.NoError(t, syscall.Kill(os.Getpid(), syscall.SIGTERM))
+	select {
+	case <-called:
+	case <-time.After(10 * time.Second):
+		t.Fatal("signal handler was not called")
+	}
+}
@@ -0,0 +1,438 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/BurntSushi/toml"
+	"github.com/spf13/cobra"
+	"github.com/spf13/pflag"
+	"go.yaml.in/yaml/v3"
+
+	"github.com/osbuild/image-builder/internal/blueprintload"
+	"github.com/osbuild/image-builder/pkg/arch"
+)
+
+const planVersion = 1
+
+// buildPlan describes a matrix of builds, every combination of
+// distro, arch, image type and blueprint is built and uploaded to
+// all the targets
+type buildPlan struct {
+	Version int `yaml:"version" toml:"version"`
+	// Concurrency is the number of builds that run at the same
+	// time, defaults to 1
+	Concurrency int `yaml:"concurrency" toml:"concurrency"`
+	// OutputDir is the base directory, every build gets its own
+	// directory in there, defaults to the directory of the plan
+	OutputDir string `yaml:"output-dir" toml:"output-dir"`
+
+	Distros    []string `yaml:"distros" toml:"distros"`
+	Archs      []string `yaml:"archs" toml:"archs"`
+	ImageTypes []string `yaml:"image-types" toml:"image-types"`
+	Blueprints []string `yaml:"blueprints" toml:"blueprints"`
+	Targets    []string `yaml:"targets" toml:"targets"`
+
+	Registrations string   `yaml:"registrations" toml:"registrations"`
+	ExtraRepos    []string `yaml:"extra-repos" toml:"extra-repos"`
+}
+
+// planBuild is a single build of the expanded plan matrix
+type planBuild struct {
+	Distro    string `json:"distro"`
+	Arch      string `json:"arch"`
+	ImageType string `json:"image_type"`
+	Blueprint string `json:"blueprint,omitempty"`
+	OutputDir string `json:"output_dir"`
+}
+
+func (pb *planBuild) String() string {
+	s := fmt.Sprintf("%s-%s-%s", pb.Distro, pb.ImageType, pb.Arch)
+	if pb.Blueprint != "" {
+		s += fmt.Sprintf(" (%s)", filepath.Base(pb.Blueprint))
+	}
+	return s
+}
+
+// planBuildResult is the entry of a single build in the summary report
+type planBuildResult struct {
+	planBuild
+	Success  bool                 `json:"success"`
+	Error    string               `json:"error,omitempty"`
+	Duration string               `json:"duration"`
+	Uploads  []uploadTargetResult `json:"uploads,omitempty"`
+}
+
+// flags that are set by the plan and cannot be given on the
+// commandline together with --plan
+var planControlledFlags = []string{
+	"distro",
+	"arch",
+	"blueprint",
+	"to",
+	"targets-file",
+	"output-name",
+	"registrations",
+	"upload-results",
+	"with-upload-result",
+}
+
+// loadBuildPlan loads the plan from the given YAML or TOML (detected
+// by the .toml extension) file. Relative paths in the plan are
+// relative to the directory of the plan file.
+func loadBuildPlan(path string) (*buildPlan, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("cannot read plan: %w", err)
+	}
+
+	var plan buildPlan
+	if strings.HasSuffix(path, ".toml") {
+		md, err := toml.Decode(string(data), &plan)
+		if err != nil {
+			return nil, fmt.Errorf("cannot parse plan %s: %w", path, err)
+		}
+		if undecoded := md.Undecoded(); len(undecoded) > 0 {
+			return nil, fmt.Errorf("cannot parse plan %s: unknown keys %v", path, undecoded)
+		}
+	} else {
+		dec := yaml.NewDecoder(bytes.NewReader(data))
+		dec.KnownFields(true)
+		if err := dec.Decode(&plan); err != nil {
+			return nil, fmt.Errorf("cannot parse plan %s: %w", path, err)
+		}
+	}
+	if plan.Version != planVersion {
+		return nil, fmt.Errorf("unsupported plan version %d, supported: %d", plan.Version, planVersion)
+	}
+	if plan.Concurrency < 0 {
+		return nil, fmt.Errorf("invalid plan concurrency %d", plan.Concurrency)
+	}
+	if plan.Concurrency == 0 {
+		plan.Concurrency = 1
+	}
+	if len(plan.Distros) == 0 {
+		return nil, fmt.Errorf("plan %s has no distros", path)
+	}
+	if len(plan.ImageTypes) == 0 {
+		return nil, fmt.Errorf("plan %s has no image-types", path)
+	}
+	if len(plan.Archs) == 0 {
+		plan.Archs = []string{arch.Current().String()}
+	}
+
+	if plan.OutputDir == "" {
+		plan.OutputDir = "."
+	}
+
+	planDir := filepath.Dir(path)
+	relToPlan := func(p string) string {
+		if p == "" || filepath.IsAbs(p) {
+			return p
+		}
+		return filepath.Join(planDir, p)
+	}
+	plan.OutputDir = relToPlan(plan.OutputDir)
+	plan.Registrations = relToPlan(plan.Registrations)
+	for i, bp := range plan.Blueprints {
+		plan.Blueprints[i] = relToPlan(bp)
+	}
+	return &plan, nil
+}
+
+// expand returns all the builds of the plan matrix
+func (plan *buildPlan) expand(baseOutputDir string) []planBuild {
+	blueprints := plan.Blueprints
+	if len(blueprints) == 0 {
+		blueprints = []string{""}
+	}
+
+	var builds []planBuild
+	for _, distro := range plan.Distros {
+		for _, archStr := range plan.Archs {
+			for _, imgType := range plan.ImageTypes {
+				for _, bp := range blueprints {
+					name := fmt.Sprintf("%s-%s-%s", distro, imgType, archStr)
+					// the blueprint name is only needed to
+					// tell the builds apart
+					if len(blueprints) > 1 {
+						name += "-" + strings.TrimSuffix(filepath.Base(bp), filepath.Ext(bp))
+					}
+					builds = append(builds, planBuild{
+						Distro:    distro,
+						Arch:      archStr,
+						ImageType: imgType,
+						Blueprint: bp,
+						OutputDir: filepath.Join(baseOutputDir, name),
+					})
+				}
+			}
+		}
+	}
+	return builds
+}
+
+// validatePlanBuilds ensures that every build of the plan can be
+// done before the first one is started
+func validatePlanBuilds(cmd *cobra.Command, plan *buildPlan, builds []planBuild) error {
+	repoDir, err := cmd.Flags().GetString("force-repo-dir")
+	if err != nil {
+		return err
+	}
+	forceDefsDir, err := cmd.Flags().GetString("force-defs-dir")
+	if err != nil {
+		return err
+	}
+	for i, target := range plan.Targets {
+		if target == "" {
+			return fmt.Errorf("empty upload target in plan")
+		}
+		if slices.Contains(plan.Targets[:i], target) {
+			return fmt.Errorf("duplicated upload target %q in plan", target)
+		}
+	}
+	for _, bp := range plan.Blueprints {
+		if _, err := blueprintload.Load(bp); err != nil {
+			return err
+		}
+	}
+
+	var errs []error
+	for i, b := range builds {
+		if slices.ContainsFunc(builds[:i], func(other planBuild) bool { return other.OutputDir == b.OutputDir }) {
+			errs = append(errs, fmt.Errorf("%s: duplicated build", b.String()))
+			continue
+		}
+		repoOpts := &repoOptions{
+			RepoDir:      repoDir,
+			ExtraRepos:   plan.ExtraRepos,
+			ForceDefsDir: forceDefsDir,
+		}
+		if _, err := getOneImage(b.Distro, b.ImageType, b.Arch, repoOpts); err != nil {
+			errs = append(errs, fmt.Errorf("%s: %w", b.String(), err))
+		}
+	}
+	if len(errs) > 0 {
+		return fmt.Errorf("invalid plan: %w", errors.Join(errs...))
+	}
+	return nil
+}
+
+// forwardedFlags returns the flags given on the commandline as
+// arguments, so that they can be passed on to every build of the plan
+func forwardedFlags(cmd *cobra.Command) ([]string, error) {
+	planCmd, err := newPlanBuildCmd()
+	if err != nil {
+		return nil, err
+	}
+	buildCmd, _, err := planCmd.Find([]string{"build"})
+	if err != nil {
+		return nil, err
+	}
+	known := func(name string) bool {
+		return buildCmd.Flags().Lookup(name) != nil || planCmd.PersistentFlags().Lookup(name) != nil
+	}
+
+	var args []string
+	cmd.Flags().Visit(func(f *pflag.Flag) {
+		// global flags like --memprofile only apply to the
+		// plan itself
+		if f.Name == "plan" || f.Name == "output-dir" || !known(f.Name) {
+			return
+		}
+		if sv, ok := f.Value.(pflag.SliceValue); ok {
+			for _, v := range sv.GetSlice() {
+				args = append(args, fmt.Sprintf("--%s=%s", f.Name, v))
+			}
+			return
+		}
+		args = append(args, fmt.Sprintf("--%s=%s", f.Name, f.Value.String()))
+	})
+	return args, nil
+}
+
+// argsFor returns the "build" arguments for the given build of the plan
+func (plan *buildPlan) argsFor(b *planBuild, forwarded []string) []string {
+	args := []string{
+		"build", b.ImageType,
+		"--distro", b.Distro,
+		"--arch", b.Arch,
+		"--output-dir", b.OutputDir,
+		"--upload-results", filepath.Join(b.OutputDir, "upload-results.json"),
+	}
+	if b.Blueprint != "" {
+		args = append(args, "--blueprint", b.Blueprint)
+	}
+	if plan.Registrations != "" {
+		args = append(args, "--registrations", plan.Registrations)
+	}
+	for _, repo := range plan.ExtraRepos {
+		args = append(args, "--extra-repo", repo)
+	}
+	for _, target := range plan.Targets {
+		args = append(args, "--to", target)
+	}
+	return append(args, forwarded...)
+}
+
+// newPlanBuildCmd returns a command tree with only the "build"
+// command, it knows the flags that can be passed to the builds
+func newPlanBuildCmd() (*cobra.Command, error) {
+	rootCmd := &cobra.Command{
+		Use:           "image-builder",
+		SilenceErrors: true,
+		SilenceUsage:  true,
+	}
+	if err := setupRootPersistentFlags(rootCmd); err != nil {
+		return nil, err
+	}
+	rootCmd.SetOut(osStdout)
+	rootCmd.SetErr(osStderr)
+
+	buildCmd, err := setupBuildCmdWithFlags()
+	if err != nil {
+		return nil, err
+	}
+	rootCmd.AddCommand(buildCmd)
+	return rootCmd, nil
+}
+
+// runPlanBuild runs a single build of the plan in its own process,
+// its output is prefixed with the name of the build
+func runPlanBuild(ctx context.Context, b *planBuild, args []string, outMu *sync.Mutex) error {
+	prefix := fmt.Sprintf("[%s] ", b.String())
+	return runSubcommand(ctx, args, newPrefixWriter(outMu, osStdout, prefix), newPrefixWriter(outMu, osStderr, prefix))
+}
+
+func readUploadResults(path string) ([]uploadTargetResult, error) {
+	data, err := os.ReadFile(path)
+	if errors.Is(err, os.ErrNotExist) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	var results []uploadTargetResult
+	if err := json.Unmarshal(data, &results); err != nil {
+		return nil, fmt.Errorf("cannot read upload results: %w", err)
+	}
+	return results, nil
+}
+
+// cmdBuildPlan expands the matrix of the given plan and runs every
+// build as "image-builder build" in its own process
+func cmdBuildPlan(cmd *cobra.Command, planPath string) error {
+	for _, name := range planControlledFlags {
+		if cmd.Flags().Changed(name) {
+			return fmt.Errorf("cannot use --%s with --plan", name)
+		}
+	}
+	plan, err := loadBuildPlan(planPath)
+	if err != nil {
+		return err
+	}
+	outputDir := plan.OutputDir
+	if cmd.Flags().Changed("output-dir") {
+		outputDir, err = cmd.Flags().GetString("output-dir")
+		if err != nil {
+			return err
+		}
+	}
+	builds := plan.expand(outputDir)
+	if err := validatePlanBuilds(cmd, plan, builds); err != nil {
+		return err
+	}
+
+	forwarded, err := forwardedFlags(cmd)
+	if err != nil {
+		return err
+	}
+	// progress bars of concurrent builds would overwrite each other
+	if plan.Concurrency > 1 && !cmd.Flags().Changed("progress") {
+		forwarded = append(forwarded, "--progress=verbose")
+	}
+
+	fmt.Fprintf(osStdout, "Building %d images from plan %s\n", len(builds), planPath)
+	results := make([]planBuildResult, len(builds))
+	sem := make(chan struct{}, plan.Concurrency)
+	var outMu sync.Mutex
+	var wg sync.WaitGroup
+	for i := range builds {
+		b := &builds[i]
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			sem <- struct{}{}
+			defer func() { <-sem }()
+
+			// do not report the uploads of an earlier run
+			resultsPath := filepath.Join(b.OutputDir, "upload-results.json")
+			if err := os.Remove(resultsPath); err != nil && !errors.Is(err, os.ErrNotExist) {
+				results[i] = planBuildResult{planBuild: *b, Error: err.Error()}
+				return
+			}
+
+			start := time.Now()
+			err := runPlanBuild(cmd.Context(), b, plan.argsFor(b, forwarded), &outMu)
+			res := planBuildResult{
+				planBuild: *b,
+				Success:   err == nil,
+				Duration:  time.Since(start).Round(time.Second).String(),
+			}
+			if err != nil {
+				res.Error = err.Error()
+			}
+			uploads, uploadsErr := readUploadResults(resultsPath)
+			if uploadsErr != nil && err == nil {
+				res.Success = false
+				res.Error = uploadsErr.Error()
+			}
+			res.Uploads = uploads
+			results[i] = res
+		}()
+	}
+	wg.Wait()
+
+	return writePlanReport(filepath.Join(outputDir, "plan-report.json"), results)
+}
+
+// writePlanReport writes the summary of all builds of the plan as
+// JSON to the given path and as text to stdout
+func writePlanReport(path string, results []planBuildResult) error {
+	data, err := json.MarshalIndent(results, "", "  ")
+	if err != nil {
+		return err
+	}
+	if err := os.MkdirAll(filepath.Dir(path), 0o755); err != nil {
+		return err
+	}
+	// #nosec: G306
+	if err := os.WriteFile(path, data, 0640); err != nil {
+		return err
+	}
+
+	var failed int
+	fmt.Fprintf(osStdout, "\nPlan summary:\n")
+	for _, res := range results {
+		status := "ok"
+		if !res.Success {
+			status = "FAILED: " + res.Error
+			failed++
+		}
+		fmt.Fprintf(osStdout, "  %s [%s]: %s\n", res.planBuild.String(), res.Duration, status)
+	}
+	fmt.Fprintf(osStdout, "Report written to %s\n", path)
+	if failed > 0 {
+		return fmt.Errorf("%d of %d plan builds failed", failed, len(results))
+	}
+	return nil
+}
@@ -0,0 +1,239 @@
+package main_test
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	main "github.com/osbuild/image-builder/cmd/image-builder"
+	"github.com/osbuild/image-builder/internal/testutil"
+	testrepos "github.com/osbuild/image-builder/test/data/repositories"
+)
+
+func makeTestPlan(t *testing.T, name, content string) string {
+	planDir := t.TempDir()
+	planPath := filepath.Join(planDir, name)
+	err := os.WriteFile(planPath, []byte(content), 0644)
+	require.NoError(t, err)
+	err = os.WriteFile(filepath.Join(planDir, "base.toml"), []byte(testBlueprint), 0644)
+	require.NoError(t, err)
+	return planPath
+}
+
+type planReportEntry struct {
+	Distro    string `json:"distro"`
+	Arch      string `json:"arch"`
+	ImageType string `json:"image_type"`
+	Blueprint string `json:"blueprint"`
+	OutputDir string `json:"output_dir"`
+	Success   bool   `json:"success"`
+	Error     string `json:"error"`
+	Uploads   []struct {
+		Provider string `json:"provider"`
+		ImageID  string `json:"image_id"`
+	} `json:"uploads"`
+}
+
+func readPlanReport(t *testing.T, path string) []planReportEntry {
+	data, err := os.ReadFile(path)
+	require.NoError(t, err)
+	var report []planReportEntry
+	require.NoError(t, json.Unmarshal(data, &report))
+	return report
+}
+
+func TestBuildPlanHappy(t *testing.T) {
+	for _, tc := range []struct {
+		name    string
+		content string
+	}{
+		{"plan.yaml", `
+version: 1
+concurrency: 2
+output-dir: out
+distros: [centos-9]
+archs: [x86_64]
+image-types: [qcow2, ami]
+blueprints: [base.toml]
+`},
+		{"plan.toml", `
+version = 1
+concurrency = 2
+output-dir = "out"
+distros = ["centos-9"]
+archs = ["x86_64"]
+image-types = ["qcow2", "ami"]
+blueprints = ["base.toml"]
+`},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			// the builds run in subprocesses with their own mocks
+			mockSubcommand(t)
+			restore := main.MockNewRepoRegistry(testrepos.New)
+			defer restore()
+
+			var fakeStdout bytes.Buffer
+			restore = main.MockOsStdout(&fakeStdout)
+			defer restore()
+
+			testutil.MockCommand(t, "osbuild", makeFakeOsbuildScript())
+
+			planPath := makeTestPlan(t, tc.name, tc.content)
+			restore = main.MockOsArgs([]string{
+				"build",
+				"--plan", planPath,
+				"--cache", t.TempDir(),
+				"--with-manifest",
+			})
+			defer restore()
+
+			err := main.Run()
+			require.NoError(t, err)
+
+			outputDir := filepath.Join(filepath.Dir(planPath), "out")
+			for _, expected := range []string{
+				"centos-9-qcow2-x86_64/centos-9-qcow2-x86_64.qcow2",
+				"centos-9-qcow2-x86_64/centos-9-qcow2-x86_64.osbuild-manifest.json",
+				"centos-9-ami-x86_64/centos-9-ami-x86_64.raw",
+				"centos-9-ami-x86_64/centos-9-ami-x86_64.osbuild-manifest.json",
+			} {
+				assert.FileExists(t, filepath.Join(outputDir, expected))
+			}
+
+			report := readPlanReport(t, filepath.Join(outputDir, "plan-report.json"))
+			require.Len(t, report, 2)
+			for i, imgType := range []string{"qcow2", "ami"} {
+				assert.Equal(t, "centos-9", report[i].Distro)
+				assert.Equal(t, "x86_64", report[i].Arch)
+				assert.Equal(t, imgType, report[i].ImageType)
+				assert.Equal(t, filepath.Join(filepath.Dir(planPath), "base.toml"), report[i].Blueprint)
+				assert.True(t, report[i].Success)
+				require.Len(t, report[i].Uploads, 1)
+				assert.Equal(t, "LocalPath", report[i].Uploads[0].Provider)
+			}
+			assert.Contains(t, fakeStdout.String(), "Building 2 images from plan")
+			// the output of the builds is prefixed with their name
+			assert.Contains(t, fakeStdout.String(), "[centos-9-ami-x86_64 (base.toml)] Image build successful: ")
+			assert.Contains(t, fakeStdout.String(), "Plan summary:")
+		})
+	}
+}
+
+func TestBuildPlanReportsFailedBuilds(t *testing.T) {
+	mockSubcommand(t)
+	restore := main.MockNewRepoRegistry(testrepos.New)
+	defer restore()
+
+	var fakeStdout bytes.Buffer
+	restore = main.MockOsStdout(&fakeStdout)
+	defer restore()
+
+	// the fake osbuild only knows the qcow2 and image exports
+	testutil.MockCommand(t, "osbuild", makeFakeOsbuildScript())
+
+	planPath := makeTestPlan(t, "plan.yaml", `
+version: 1
+distros: [centos-9]
+archs: [x86_64]
+image-types: [qcow2, vhd]
+`)
+	restore = main.MockOsArgs([]string{
+		"build",
+		"--plan", planPath,
+		"--cache", t.TempDir(),
+	})
+	defer restore()
+
+	err := main.Run()
+	assert.EqualError(t, err, "1 of 2 plan builds failed")
+
+	report := readPlanReport(t, filepath.Join(filepath.Dir(planPath), "plan-report.json"))
+	require.Len(t, report, 2)
+	assert.True(t, report[0].Success)
+	assert.False(t, report[1].Success)
+	assert.Equal(t, "error running osbuild: exit status 1", report[1].Error)
+	assert.Contains(t, fakeStdout.String(), "centos-9-vhd-x86_64 [")
+}
+
+func TestBuildPlanErrors(t *testing.T) {
+	restore := main.MockNewRepoRegistry(testrepos.New)
+	defer restore()
+
+	for _, tc := range []struct {
+		plan        string
+		extraArgs   []string
+		expectedErr string
+	}{
+		{
+			"version: 2\ndistros: [centos-9]\nimage-types: [qcow2]\n",
+			nil,
+			"unsupported plan version 2, supported: 1",
+		},
+		{
+			"version: 1\ndistros: [centos-9]\n",
+			nil,
+			"plan %s has no image-types",
+		},
+		{
+			"version: 1\ndistros: [centos-9]\nimage-types: [qcow2]\nflavor: spicy\n",
+			nil,
+			"cannot parse plan %s: yaml: unmarshal errors:\n  line 4: field flavor not found in type main.buildPlan",
+		},
+		{
+			"version: 1\ndistros: [centos-9]\narchs: [x86_64]\nimage-types: [qcow2, no-such-type]\n",
+			nil,
+			`invalid plan: centos-9-no-such-type-x86_64: cannot find image for: distro:"centos-9" type:"no-such-type" arch:"x86_64"`,
+		},
+		{
+			"version: 1\ndistros: [centos-9]\nimage-types: [qcow2]\ntargets: [aws, aws]\n",
+			nil,
+			`duplicated upload target "aws" in plan`,
+		},
+		{
+			"version: 1\ndistros: [centos-9]\nimage-types: [qcow2]\n",
+			[]string{"--distro=centos-9"},
+			"cannot use --distro with --plan",
+		},
+		{
+			"version: 1\ndistros: [centos-9]\nimage-types: [qcow2]\n",
+			[]string{"qcow2"},
+			`unknown command "qcow2" for "image-builder build"`,
+		},
+	} {
+		t.Run(tc.expectedErr, func(t *testing.T) {
+			planPath := makeTestPlan(t, "plan.yaml", tc.plan)
+			restore := main.MockOsArgs(append([]string{"build", "--plan", planPath}, tc.extraArgs...))
+			defer restore()
+
+			err := main.Run()
+			expectedErr := tc.expectedErr
+			if strings.Contains(expectedErr, "%s") {
+				expectedErr = fmt.Sprintf(expectedErr, planPath)
+			}
+			assert.EqualError(t, err, expectedErr)
+		})
+	}
+}
+
+func TestBuildPlanRejectsLegacyBlueprint(t *testing.T) {
+	restore := main.MockNewRepoRegistry(testrepos.New)
+	defer restore()
+
+	// the legacy bootc-image-builder config is not a blueprint that
+	// "build" accepts
+	planPath := makeTestPlan(t, "plan.yaml", "version: 1\ndistros: [centos-9]\nimage-types: [qcow2]\nblueprints: [legacy.json]\n")
+	legacyPath := filepath.Join(filepath.Dir(planPath), "legacy.json")
+	require.NoError(t, os.WriteFile(legacyPath, []byte(`{"blueprint": {"name": "legacy"}}`), 0644))
+	restore = main.MockOsArgs([]string{"build", "--plan", planPath})
+	defer restore()
+
+	err := main.Run()
+	assert.ErrorContains(t, err, `unknown field "blueprint"`)
+}
@@ -1,11 +1,22 @@
 package main
 
 import (
+	"encoding/base64"
+	"encoding/json"
+	"encoding/xml"
 	"fmt"
+	"io"
 	"io/fs"
+	"net/http"
 	"net/url"
 	"os"
 	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/spf13/cobra"
+	"go.yaml.in/yaml/v3"
 
 	"github.com/osbuild/image-builder/data/repositories"
 	"github.com/osbuild/image-builder/pkg/reporegistry"
@@ -101,3 +112,410 @@ func newRepoRegistryImpl(repoDir string, extraRepos []string) (*reporegistry.Rep
 
 // this is a variable to make it overridable in tests
 var newRepoRegistry = newRepoRegistryImpl
+
+// reposEntry is a repository as it is used for the build
+type reposEntry struct {
+	Id   string `json:"id,omitempty" yaml:"id,omitempty"`
+	Name string `json:"name,omitempty" yaml:"name,omitempty"`
+	// Source is where the repository comes from: "distro" for the
+	// repository files, "extra" for --extra-repo and "forced" for
+	// --force-repo
+	Source        string   `json:"source" yaml:"source"`
+	BaseURLs      []string `json:"baseurls,omitempty" yaml:"baseurls,omitempty"`
+	Metalink      string   `json:"metalink,omitempty" yaml:"metalink,omitempty"`
+	MirrorList    string   `json:"mirrorlist,omitempty" yaml:"mirrorlist,omitempty"`
+	GPGKeys       []string `json:"gpgkeys,omitempty" yaml:"gpgkeys,omitempty"`
+	CheckGPG      *bool    `json:"check_gpg,omitempty" yaml:"check_gpg,omitempty"`
+	CheckRepoGPG  *bool    `json:"check_repo_gpg,omitempty" yaml:"check_repo_gpg,omitempty"`
+	RHSM          bool     `json:"rhsm,omitempty" yaml:"rhsm,omitempty"`
+	ImageTypeTags []string `json:"image_type_tags,omitempty" yaml:"image_type_tags,omitempty"`
+	PackageSets   []string `json:"package_sets,omitempty" yaml:"package_sets,omitempty"`
+
+	Check *repoCheck `json:"check,omitempty" yaml:"check,omitempty"`
+}
+
+type reposReport struct {
+	Distro       string       `json:"distro" yaml:"distro"`
+	Arch         string       `json:"arch" yaml:"arch"`
+	ImageType    string       `json:"image_type,omitempty" yaml:"image_type,omitempty"`
+	Repositories []reposEntry `json:"repositories" yaml:"repositories"`
+}
+
+// repoCheck is the result of probing a repository
+type repoCheck struct {
+	OK bool `json:"ok" yaml:"ok"`
+	// Skipped is set when the repository cannot be probed
+	Skipped string `json:"skipped,omitempty" yaml:"skipped,omitempty"`
+	// URL is the repomd.xml that was fetched
+	URL         string        `json:"url,omitempty" yaml:"url,omitempty"`
+	Latency     string        `json:"latency,omitempty" yaml:"latency,omitempty"`
+	Revision    string        `json:"revision,omitempty" yaml:"revision,omitempty"`
+	MetadataAge string        `json:"metadata_age,omitempty" yaml:"metadata_age,omitempty"`
+	GPGKeys     []gpgKeyCheck `json:"gpgkeys,omitempty" yaml:"gpgkeys,omitempty"`
+	Errors      []string      `json:"errors,omitempty" yaml:"errors,omitempty"`
+}
+
+type gpgKeyCheck struct {
+	Key   string `json:"key" yaml:"key"`
+	OK    bool   `json:"ok" yaml:"ok"`
+	Error string `json:"error,omitempty" yaml:"error,omitempty"`
+}
+
+const inlineGPGKey = "(inline key)"
+
+func gpgKeyName(key string) string {
+	if strings.Contains(key, "-----BEGIN PGP") {
+		return inlineGPGKey
+	}
+	return key
+}
+
+func newReposEntry(repo rpmmd.RepoConfig, source string) reposEntry {
+	entry := reposEntry{
+		Id:            repo.Id,
+		Name:          repo.Name,
+		Source:        source,
+		BaseURLs:      repo.BaseURLs,
+		Metalink:      repo.Metalink,
+		MirrorList:    repo.MirrorList,
+		CheckGPG:      repo.CheckGPG,
+		CheckRepoGPG:  repo.CheckRepoGPG,
+		RHSM:          repo.RHSM,
+		ImageTypeTags: repo.ImageTypeTags,
+		PackageSets:   repo.PackageSets,
+	}
+	for _, key := range repo.GPGKeys {
+		entry.GPGKeys = append(entry.GPGKeys, gpgKeyName(key))
+	}
+	return entry
+}
+
+// fetchRepoURL fetches the given http(s) or file url and returns the
+// content and the time to the first byte
+func fetchRepoURL(client *http.Client, u string) ([]byte, time.Duration, error) {
+	start := time.Now()
+	if path, ok := strings.CutPrefix(u, "file://"); ok {
+		data, err := os.ReadFile(path)
+		return data, time.Since(start), err
+	}
+	resp, err := client.Get(u)
+	if err != nil {
+		return nil, 0, err
+	}
+	defer resp.Body.Close()
+	latency := time.Since(start)
+	if resp.StatusCode != http.StatusOK {
+		return nil, latency, fmt.Errorf("unexpected status %s", resp.Status)
+	}
+	data, err := io.ReadAll(resp.Body)
+	return data, latency, err
+}
+
+type repomdXML struct {
+	Revision string `xml:"revision"`
+	Data     []struct {
+		Type      string `xml:"type,attr"`
+		Timestamp string `xml:"timestamp"`
+	} `xml:"data"`
+}
+
+// metadataTime returns the time of the newest metadata in the repomd
+func (r *repomdXML) metadataTime() (time.Time, error) {
+	var newest float64
+	for _, d := range r.Data {
+		ts, err := strconv.ParseFloat(strings.TrimSpace(d.Timestamp), 64)
+		if err != nil {
+			return time.Time{}, fmt.Errorf("cannot parse timestamp of %q metadata: %w", d.Type, err)
+		}
+		newest = max(newest, ts)
+	}
+	if newest == 0 {
+		return time.Time{}, fmt.Errorf("no metadata timestamps in repomd.xml")
+	}
+	return time.Unix(int64(newest), 0), nil
+}
+
+type metalinkXML struct {
+	Files []struct {
+		Name string `xml:"name,attr"`
+		URLs []struct {
+			Protocol string `xml:"protocol,attr"`
+			URL      string `xml:",chardata"`
+		} `xml:"resources>url"`
+	} `xml:"files>file"`
+}
+
+// repomdURLs returns the repomd.xml urls of the repository in the
+// order they should be tried
+func repomdURLs(client *http.Client, repo *rpmmd.RepoConfig) ([]string, error) {
+	var urls []string
+	for _, baseURL := range repo.BaseURLs {
+		urls = append(urls, strings.TrimSuffix(baseURL, "/")+"/repodata/repomd.xml")
+	}
+	switch {
+	case repo.Metalink != "":
+		data, _, err := fetchRepoURL(client, repo.Metalink)
+		if err != nil {
+			return nil, fmt.Errorf("cannot fetch metalink %s: %w", repo.Metalink, err)
+		}
+		var ml metalinkXML
+		if err := xml.Unmarshal(data, &ml); err != nil {
+			return nil, fmt.Errorf("cannot parse metalink %s: %w", repo.Metalink, err)
+		}
+		for _, f := range ml.Files {
+			if f.Name != "repomd.xml" {
+				continue
+			}
+			for _, u := range f.URLs {
+				if u.Protocol == "http" || u.Protocol == "https" {
+					urls = append(urls, strings.TrimSpace(u.URL))
+				}
+			}
+		}
+		if len(urls) == 0 {
+			return nil, fmt.Errorf("metalink %s contains no mirrors", repo.Metalink)
+		}
+	case repo.MirrorList != "":
+		data, _, err := fetchRepoURL(client, repo.MirrorList)
+		if err != nil {
+			return nil, fmt.Errorf("cannot fetch mirrorlist %s: %w", repo.MirrorList, err)
+		}
+		for _, line := range strings.Split(string(data), "\n") {
+			line = strings.TrimSpace(line)
+			if line == "" || strings.HasPrefix(line, "#") {
+				continue
+			}
+			urls = append(urls, strings.TrimSuffix(line, "/")+"/repodata/repomd.xml")
+		}
+		if len(urls) == 0 {
+			return nil, fmt.Errorf("mirrorlist %s contains no mirrors", repo.MirrorList)
+		}
+	}
+	if len(urls) == 0 {
+		return nil, fmt.Errorf("repository has no baseurl, metalink or mirrorlist")
+	}
+	return urls, nil
+}
+
+// checkGPGKey verifies that the key (or the key behind the url) is an
+// armored PGP public key
+func checkGPGKey(client *http.Client, key string) error {
+	data := []byte(key)
+	if gpgKeyName(key) != inlineGPGKey {
+		var err error
+		if data, _, err = fetchRepoURL(client, key); err != nil {
+			return err
+		}
+	}
+	const begin = "-----BEGIN PGP PUBLIC KEY BLOCK-----"
+	const end = "-----END PGP PUBLIC KEY BLOCK-----"
+	armored := string(data)
+	start := strings.Index(armored, begin)
+	stop := strings.Index(armored, end)
+	if start < 0 || stop < start {
+		return fmt.Errorf("not an armored PGP public key")
+	}
+	// the armor headers are separated by an empty line from the
+	// base64 data which ends with an optional "=" checksum line
+	_, body, ok := strings.Cut(armored[start+len(begin):stop], "\n\n")
+	if !ok {
+		return fmt.Errorf("invalid PGP armor: missing empty line after the header")
+	}
+	var b64 strings.Builder
+	for _, line := range strings.Split(strings.TrimSpace(body), "\n") {
+		line = strings.TrimSpace(line)
+		if strings.HasPrefix(line, "=") && len(line) == 5 {
+			break
+		}
+		b64.WriteString(line)
+	}
+	decoded, err := base64.StdEncoding.DecodeString(b64.String())
+	if err != nil {
+		return fmt.Errorf("invalid PGP armor: %w", err)
+	}
+	if len(decoded) == 0 {
+		return fmt.Errorf("empty PGP public key")
+	}
+	return nil
+}
+
+// checkRepo fetches the repomd.xml of the repository (from the first
+// working mirror) and verifies its gpg keys
+func checkRepo(repo *rpmmd.RepoConfig, timeout time.Duration, now time.Time) *repoCheck {
+	res := &repoCheck{}
+	if repo.RHSM && repo.SSLClientCert == "" {
+		res.Skipped = "needs a subscription"
+		res.OK = true
+		return res
+	}
+	client, err := httpClientForRepo(repo)
+	if err != nil {
+		res.Errors = append(res.Errors, err.Error())
+		return res
+	}
+	client.Timeout = timeout
+
+	urls, err := repomdURLs(client, repo)
+	if err != nil {
+		res.Errors = append(res.Errors, err.Error())
+	}
+	for _, u := range urls {
+		data, latency, err := fetchRepoURL(client, u)
+		if err == nil {
+			var repomd repomdXML
+			if err = xml.Unmarshal(data, &repomd); err == nil {
+				var mtime time.Time
+				if mtime, err = repomd.metadataTime(); err == nil {
+					res.URL = u
+					res.Latency = latency.Round(time.Millisecond).String()
+					res.Revision = repomd.Revision
+					res.MetadataAge = now.Sub(mtime).Round(time.Second).String()
+					break
+				}
+			}
+		}
+		res.Errors = append(res.Errors, fmt.Sprintf("%s: %v", u, err))
+	}
+	if res.URL != "" {
+		// only failing mirrors are not an error when another one works
+		res.Errors = nil
+	}
+
+	keysOK := true
+	for _, key := range repo.GPGKeys {
+		kc := gpgKeyCheck{Key: gpgKeyName(key), OK: true}
+		if err := checkGPGKey(client, key); err != nil {
+			kc.OK = false
+			kc.Error = err.Error()
+			keysOK = false
+		}
+		res.GPGKeys = append(res.GPGKeys, kc)
+	}
+	res.OK = res.URL != "" && keysOK
+	return res
+}
+
+func cmdRepos(cmd *cobra.Command, args []string) error {
+	repoDir, err := cmd.Flags().GetString("force-repo-dir")
+	if err != nil {
+		return err
+	}
+	forceDefsDir, err := cmd.Flags().GetString("force-defs-dir")
+	if err != nil {
+		return err
+	}
+	extraRepos, err := cmd.Flags().GetStringArray("extra-repo")
+	if err != nil {
+		return err
+	}
+	forceRepos, err := cmd.Flags().GetStringArray("force-repo")
+	if err != nil {
+		return err
+	}
+	distroStr, err := cmd.Flags().GetString("distro")
+	if err != nil {
+		return err
+	}
+	archStr, err := cmd.Flags().GetString("arch")
+	if err != nil {
+		return err
+	}
+	imgTypeStr, err := cmd.Flags().GetString("type")
+	if err != nil {
+		return err
+	}
+	format, err := cmd.Flags().GetString("format")
+	if err != nil {
+		return err
+	}
+	if format != "" && format != "yaml" && format != "json" {
+		return fmt.Errorf("unsupported format %q, supported formats: yaml, json", format)
+	}
+	check, err := cmd.Flags().GetBool("check")
+	if err != nil {
+		return err
+	}
+	timeout, err := cmd.Flags().GetDuration("timeout")
+	if err != nil {
+		return err
+	}
+
+	distroStr, err = findDistro(distroStr, "")
+	if err != nil {
+		return err
+	}
+	if archStr == "" {
+		archStr = getHostArch()
+	}
+
+	var repos []rpmmd.RepoConfig
+	if imgTypeStr != "" {
+		img, err := getOneImage(distroStr, imgTypeStr, archStr, &repoOptions{
+			RepoDir:      repoDir,
+			ExtraRepos:   extraRepos,
+			ForceDefsDir: forceDefsDir,
+		})
+		if err != nil {
+			return err
+		}
+		repos = img.Repos
+	} else {
+		registry, err := newRepoRegistry(repoDir, extraRepos)
+		if err != nil {
+			return err
+		}
+		repos, err = registry.ReposByArchName(distroStr, archStr, true)
+		if err != nil {
+			return err
+		}
+	}
+	if len(forceRepos) > 0 {
+		if repos, err = parseRepoURLs(forceRepos, "forced"); err != nil {
+			return err
+		}
+	}
+
+	report := reposReport{
+		Distro:    distroStr,
+		Arch:      archStr,
+		ImageType: imgTypeStr,
+	}
+	var failed int
+	for i := range repos {
+		source := "distro"
+		switch {
+		case len(forceRepos) > 0:
+			source = "forced"
+		case strings.HasPrefix(repos[i].Id, "extra-repo-"):
+			source = "extra"
+		}
+		entry := newReposEntry(repos[i], source)
+		if check {
+			entry.Check = checkRepo(&repos[i], timeout, time.Now())
+			if !entry.Check.OK {
+				failed++
+			}
+		}
+		report.Repositories = append(report.Repositories, entry)
+	}
+
+	if format == "json" {
+		// keep the "&" of metalink urls readable
+		enc := json.NewEncoder(cmd.OutOrStdout())
+		enc.SetEscapeHTML(false)
+		enc.SetIndent("", "  ")
+		err = enc.Encode(report)
+	} else {
+		var output []byte
+		if output, err = yaml.Marshal(report); err == nil {
+			_, err = cmd.OutOrStdout().Write(output)
+		}
+	}
+	if err != nil {
+		return err
+	}
+	if failed > 0 {
+		return fmt.Errorf("%d of %d repositories failed the check", failed, len(repos))
+	}
+	return nil
+}
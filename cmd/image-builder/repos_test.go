@@ -1,9 +1,15 @@
 package main
 
 import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
 	"os"
 	"path/filepath"
 	"testing"
+	"time"
 
 	"github.com/stretchr/testify/assert"
 	"github.com/stretchr/testify/require"
@@ -128,3 +134,199 @@ func TestNewRepoRegistryImplRepodirNoSubDir(t *testing.T) {
 	assert.Len(t, repos, 1)
 	assert.Equal(t, repos[0].Name, "testdistro-1-repo")
 }
+
+const fakeRepomd = `<?xml version="1.0" encoding="UTF-8"?>
+<repomd xmlns="http://linux.duke.edu/metadata/repo" xmlns:rpm="http://linux.duke.edu/metadata/rpm">
+  <revision>1700000000</revision>
+  <data type="primary">
+    <location href="repodata/primary.xml.gz"/>
+    <timestamp>1699990000</timestamp>
+  </data>
+  <data type="filelists">
+    <location href="repodata/filelists.xml.gz"/>
+    <timestamp>1700000000</timestamp>
+  </data>
+</repomd>
+`
+
+const fakeGPGKey = `-----BEGIN PGP PUBLIC KEY BLOCK-----
+
+bWRJTkJHYTIzTThCRUFDNDdOd0tMaS9nMlM5STJwNUp0VWJKMHkzbTJTdDl6cWtT
+RU5tWXcvK1IrV0t2YVAzUw==
+=aBcD
+-----END PGP PUBLIC KEY BLOCK-----
+`
+
+func newFakeRepoServer(t *testing.T) *httptest.Server {
+	mux := http.NewServeMux()
+	mux.HandleFunc("/good/repodata/repomd.xml", func(w http.ResponseWriter, r *http.Request) {
+		fmt.Fprint(w, fakeRepomd)
+	})
+	mux.HandleFunc("/key.asc", func(w http.ResponseWriter, r *http.Request) {
+		fmt.Fprint(w, fakeGPGKey)
+	})
+	mux.HandleFunc("/not-a-key.asc", func(w http.ResponseWriter, r *http.Request) {
+		fmt.Fprint(w, "<html>moved</html>")
+	})
+	mux.HandleFunc("/metalink", func(w http.ResponseWriter, r *http.Request) {
+		fmt.Fprintf(w, `<?xml version="1.0" encoding="utf-8"?>
+<metalink version="3.0" xmlns="http://www.metalinker.org/" xmlns:mm0="http://fedorahosted.org/mirrormanager">
+  <files>
+    <file name="repomd.xml">
+      <resources maxconnections="1">
+        <url protocol="rsync" type="rsync" location="DE" preference="100">rsync://mirror.example.com/good/repodata/repomd.xml</url>
+        <url protocol="http" type="http" location="DE" preference="99">http://%[1]s/broken/repodata/repomd.xml</url>
+        <url protocol="http" type="http" location="US" preference="98">http://%[1]s/good/repodata/repomd.xml</url>
+      </resources>
+    </file>
+  </files>
+</metalink>
+`, r.Host)
+	})
+	mux.HandleFunc("/mirrorlist", func(w http.ResponseWriter, r *http.Request) {
+		fmt.Fprintf(w, "# mirrors\nhttp://%[1]s/broken/\nhttp://%[1]s/good/\n", r.Host)
+	})
+	srv := httptest.NewServer(mux)
+	t.Cleanup(srv.Close)
+	return srv
+}
+
+func TestCheckRepoHappy(t *testing.T) {
+	srv := newFakeRepoServer(t)
+	now := time.Unix(1700000000, 0).Add(90 * time.Minute)
+
+	for _, tc := range []struct {
+		name string
+		repo rpmmd.RepoConfig
+	}{
+		{"baseurl", rpmmd.RepoConfig{BaseURLs: []string{srv.URL + "/good/"}}},
+		{"metalink", rpmmd.RepoConfig{Metalink: srv.URL + "/metalink"}},
+		{"mirrorlist", rpmmd.RepoConfig{MirrorList: srv.URL + "/mirrorlist"}},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			tc.repo.GPGKeys = []string{srv.URL + "/key.asc", fakeGPGKey}
+			res := checkRepo(&tc.repo, 10*time.Second, now)
+			assert.True(t, res.OK)
+			assert.Empty(t, res.Errors)
+			assert.Equal(t, srv.URL+"/good/repodata/repomd.xml", res.URL)
+			assert.Equal(t, "1700000000", res.Revision)
+			assert.Equal(t, "1h30m0s", res.MetadataAge)
+			assert.NotEmpty(t, res.Latency)
+			assert.Equal(t, []gpgKeyCheck{
+				{Key: srv.URL + "/key.asc", OK: true},
+				{Key: "(inline key)", OK: true},
+			}, res.GPGKeys)
+		})
+	}
+}
+
+func TestCheckRepoFile(t *testing.T) {
+	repoDir := t.TempDir()
+	require.NoError(t, os.MkdirAll(filepath.Join(repoDir, "repodata"), 0755))
+	require.NoError(t, os.WriteFile(filepath.Join(repoDir, "repodata/repomd.xml"), []byte(fakeRepomd), 0644))
+
+	res := checkRepo(&rpmmd.RepoConfig{BaseURLs: []string{"file://" + repoDir}}, 10*time.Second, time.Unix(1700000000, 0))
+	assert.True(t, res.OK)
+	assert.Equal(t, "file://"+repoDir+"/repodata/repomd.xml", res.URL)
+	assert.Equal(t, "0s", res.MetadataAge)
+}
+
+func TestCheckRepoSad(t *testing.T) {
+	srv := newFakeRepoServer(t)
+
+	res := checkRepo(&rpmmd.RepoConfig{
+		BaseURLs: []string{srv.URL + "/broken/"},
+		GPGKeys:  []string{srv.URL + "/not-a-key.asc", srv.URL + "/missing.asc"},
+	}, 10*time.Second, time.Now())
+	assert.False(t, res.OK)
+	assert.Equal(t, []string{srv.URL + "/broken/repodata/repomd.xml: unexpected status 404 Not Found"}, res.Errors)
+	assert.Equal(t, []gpgKeyCheck{
+		{Key: srv.URL + "/not-a-key.asc", Error: "not an armored PGP public key"},
+		{Key: srv.URL + "/missing.asc", Error: "unexpected status 404 Not Found"},
+	}, res.GPGKeys)
+
+	// a good repository with a bad key fails too
+	res = checkRepo(&rpmmd.RepoConfig{
+		BaseURLs: []string{srv.URL + "/good/"},
+		GPGKeys:  []string{"-----BEGIN PGP PUBLIC KEY BLOCK-----\n\n!!!\n-----END PGP PUBLIC KEY BLOCK-----\n"},
+	}, 10*time.Second, time.Now())
+	assert.False(t, res.OK)
+	assert.Empty(t, res.Errors)
+	assert.Contains(t, res.GPGKeys[0].Error, "invalid PGP armor: ")
+
+	res = checkRepo(&rpmmd.RepoConfig{Metalink: srv.URL + "/missing"}, 10*time.Second, time.Now())
+	assert.False(t, res.OK)
+	assert.Equal(t, []string{"cannot fetch metalink " + srv.URL + "/missing: unexpected status 404 Not Found"}, res.Errors)
+
+	// rhsm repositories cannot be fetched without a subscription
+	res = checkRepo(&rpmmd.RepoConfig{BaseURLs: []string{srv.URL + "/broken/"}, RHSM: true}, 10*time.Second, time.Now())
+	assert.True(t, res.OK)
+	assert.Equal(t, "needs a subscription", res.Skipped)
+}
+
+func runRepos(t *testing.T, args ...string) (string, error) {
+	var fakeStdout bytes.Buffer
+	saved := osStdout
+	osStdout = &fakeStdout
+	defer func() { osStdout = saved }()
+	savedArgs := os.Args
+	os.Args = append([]string{"argv0", "repos"}, args...)
+	defer func() { os.Args = savedArgs }()
+
+	err := run()
+	return fakeStdout.String(), err
+}
+
+func TestReposCmd(t *testing.T) {
+	srv := newFakeRepoServer(t)
+	repoDir := t.TempDir()
+	repoContents := fmt.Sprintf(`{
+	"x86_64": [
+		{
+			"name": "testdistro-1-base",
+			"baseurl": "%[1]s/good/",
+			"gpgkeys": ["%[1]s/key.asc"],
+			"check_gpg": true
+		},
+		{
+			"name": "testdistro-1-broken",
+			"baseurl": "%[1]s/broken/",
+			"image_type_tags": ["qcow2"]
+		}
+	]
+}
+`, srv.URL)
+	require.NoError(t, os.WriteFile(filepath.Join(repoDir, "testdistro-1.json"), []byte(repoContents), 0644)) // #nosec: G306
+
+	output, err := runRepos(t, "--force-repo-dir", repoDir, "--distro", "testdistro-1", "--arch", "x86_64", "--extra-repo", srv.URL+"/good/", "--format", "json")
+	require.NoError(t, err)
+	var report reposReport
+	require.NoError(t, json.Unmarshal([]byte(output), &report))
+	assert.Equal(t, "testdistro-1", report.Distro)
+	assert.Equal(t, "x86_64", report.Arch)
+	require.Len(t, report.Repositories, 3)
+	assert.Equal(t, "testdistro-1-base", report.Repositories[0].Name)
+	assert.Equal(t, "distro", report.Repositories[0].Source)
+	assert.Equal(t, []string{srv.URL + "/key.asc"}, report.Repositories[0].GPGKeys)
+	assert.Equal(t, []string{"qcow2"}, report.Repositories[1].ImageTypeTags)
+	assert.Equal(t, "extra-repo-0", report.Repositories[2].Id)
+	assert.Equal(t, "extra", report.Repositories[2].Source)
+	assert.Nil(t, report.Repositories[0].Check)
+
+	output, err = runRepos(t, "--force-repo-dir", repoDir, "--distro", "testdistro-1", "--arch", "x86_64", "--check")
+	assert.EqualError(t, err, "1 of 2 repositories failed the check")
+	assert.Contains(t, output, "    - name: testdistro-1-base\n      source: distro\n")
+	assert.Contains(t, output, "      check:\n        ok: true\n")
+	assert.Contains(t, output, "        errors:\n            - '"+srv.URL+"/broken/repodata/repomd.xml: unexpected status 404 Not Found'\n")
+
+	// forced repositories replace all others
+	output, err = runRepos(t, "--force-repo-dir", repoDir, "--distro", "testdistro-1", "--arch", "x86_64", "--force-repo", srv.URL+"/good/", "--check", "--format", "json")
+	require.NoError(t, err)
+	require.NoError(t, json.Unmarshal([]byte(output), &report))
+	require.Len(t, report.Repositories, 1)
+	assert.Equal(t, "forced", report.Repositories[0].Source)
+	assert.True(t, report.Repositories[0].Check.OK)
+
+	_, err = runRepos(t, "--distro", "centos-9", "--format", "toml")
+	assert.EqualError(t, err, `unsupported format "toml", supported formats: yaml, json`)
+}
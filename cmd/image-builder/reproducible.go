@@ -0,0 +1,366 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/fs"
+	"os"
+	"path"
+	"path/filepath"
+	"slices"
+	"sort"
+	"strings"
+	"syscall"
+
+	"github.com/osbuild/image-builder/pkg/progress"
+)
+
+// reproducibilityTreePipeline is the pipeline of the filesystem tree
+// of an image, it is exported in addition to the image so that the
+// differences can be reported file-by-file
+const reproducibilityTreePipeline = "os"
+
+// stageOutputs are the paths that stages are known to write to, they
+// are used to find the stage that most likely produced a difference
+// in the filesystem tree. Patterns that end in "/" match everything
+// below the directory.
+var stageOutputs = map[string][]string{
+	"org.osbuild.rpm":                 {"/usr/", "/etc/", "/var/", "/boot/", "/opt/"},
+	"org.osbuild.ldconfig":            {"/etc/ld.so.cache"},
+	"org.osbuild.machine-id":          {"/etc/machine-id"},
+	"org.osbuild.hostname":            {"/etc/hostname"},
+	"org.osbuild.locale":              {"/etc/locale.conf"},
+	"org.osbuild.timezone":            {"/etc/localtime"},
+	"org.osbuild.keymap":              {"/etc/vconsole.conf", "/etc/X11/xorg.conf.d/"},
+	"org.osbuild.users":               {"/etc/passwd", "/etc/passwd-", "/etc/shadow", "/etc/shadow-", "/etc/group", "/etc/group-", "/etc/gshadow", "/etc/gshadow-", "/home/", "/root/"},
+	"org.osbuild.groups":              {"/etc/group", "/etc/group-", "/etc/gshadow", "/etc/gshadow-"},
+	"org.osbuild.fstab":               {"/etc/fstab"},
+	"org.osbuild.kernel-cmdline":      {"/etc/kernel/cmdline"},
+	"org.osbuild.grub2":               {"/boot/grub2/", "/boot/efi/", "/etc/default/grub"},
+	"org.osbuild.grub2.legacy":        {"/boot/grub2/", "/boot/efi/", "/etc/default/grub"},
+	"org.osbuild.fix-bls":             {"/boot/loader/entries/"},
+	"org.osbuild.dracut":              {"/boot/initramfs-*"},
+	"org.osbuild.systemd":             {"/etc/systemd/system/", "/usr/lib/systemd/system-preset/"},
+	"org.osbuild.systemd.unit":        {"/etc/systemd/system/", "/usr/lib/systemd/system/"},
+	"org.osbuild.systemd.unit.create": {"/etc/systemd/system/", "/usr/lib/systemd/system/"},
+	"org.osbuild.sysconfig":           {"/etc/sysconfig/"},
+	"org.osbuild.chrony":              {"/etc/chrony.conf"},
+	"org.osbuild.cloud-init":          {"/etc/cloud/"},
+	"org.osbuild.rpm.macros":          {"/etc/rpm/"},
+}
+
+func stageOutputMatches(pattern, p string) bool {
+	if strings.HasSuffix(pattern, "/") {
+		return strings.HasPrefix(p, pattern)
+	}
+	ok, _ := path.Match(pattern, p)
+	return ok
+}
+
+type manifestPipelines struct {
+	Pipelines []struct {
+		Name   string `json:"name"`
+		Stages []struct {
+			Type string `json:"type"`
+		} `json:"stages"`
+	} `json:"pipelines"`
+}
+
+// stagesOf returns the stage types of the given pipeline
+func (m *manifestPipelines) stagesOf(name string) []string {
+	for _, p := range m.Pipelines {
+		if p.Name == name {
+			var stages []string
+			for _, s := range p.Stages {
+				stages = append(stages, s.Type)
+			}
+			return stages
+		}
+	}
+	return nil
+}
+
+// stageFor returns the stage of the pipeline that most likely
+// produced the given path. Images are produced by the last stage of
+// their pipeline, for the filesystem tree the last stage that is
+// known to write the path is used.
+func (m *manifestPipelines) stageFor(pipeline, p string) string {
+	stages := m.stagesOf(pipeline)
+	if len(stages) == 0 {
+		return ""
+	}
+	if pipeline != reproducibilityTreePipeline {
+		return stages[len(stages)-1]
+	}
+	for i := len(stages) - 1; i >= 0; i-- {
+		for _, pattern := range stageOutputs[stages[i]] {
+			if stageOutputMatches(pattern, p) {
+				return stages[i]
+			}
+		}
+	}
+	return ""
+}
+
+// reproducibilityDiff is a difference between the two builds
+type reproducibilityDiff struct {
+	Pipeline string `json:"pipeline"`
+	Path     string `json:"path"`
+	// What differs: "missing", "type", "mode", "owner", "symlink"
+	// or "content"
+	What   string `json:"what"`
+	First  string `json:"first,omitempty"`
+	Second string `json:"second,omitempty"`
+	Stage  string `json:"stage,omitempty"`
+}
+
+func (d *reproducibilityDiff) String() string {
+	stage := d.Stage
+	if stage == "" {
+		stage = "unknown stage"
+	}
+	s := fmt.Sprintf("%s: %s: %s differs", d.Pipeline, d.Path, d.What)
+	switch {
+	case d.What == "missing" && d.First == "":
+		s = fmt.Sprintf("%s: %s: %s only in build 2", d.Pipeline, d.Path, d.Second)
+	case d.What == "missing":
+		s = fmt.Sprintf("%s: %s: %s only in build 1", d.Pipeline, d.Path, d.First)
+	case d.First != "" || d.Second != "":
+		s += fmt.Sprintf(" (%s vs %s)", orNone(d.First), orNone(d.Second))
+	}
+	return fmt.Sprintf("%s [%s]", s, stage)
+}
+
+func orNone(s string) string {
+	if s == "" {
+		return "none"
+	}
+	return s
+}
+
+type reproducibilityReport struct {
+	Image        string                `json:"image"`
+	Reproducible bool                  `json:"reproducible"`
+	Differences  []reproducibilityDiff `json:"differences"`
+}
+
+type treeEntry struct {
+	path string
+	mode fs.FileMode
+	uid  uint32
+	gid  uint32
+	size int64
+	link string
+}
+
+func fileTypeName(mode fs.FileMode) string {
+	switch {
+	case mode.IsRegular():
+		return "file"
+	case mode.IsDir():
+		return "directory"
+	case mode&fs.ModeSymlink != 0:
+		return "symlink"
+	default:
+		return "special file"
+	}
+}
+
+func walkTree(root string) (map[string]*treeEntry, error) {
+	entries := make(map[string]*treeEntry)
+	err := filepath.WalkDir(root, func(p string, d fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+		rel, err := filepath.Rel(root, p)
+		if err != nil {
+			return err
+		}
+		info, err := d.Info()
+		if err != nil {
+			return err
+		}
+		entry := &treeEntry{
+			path: "/" + filepath.ToSlash(rel),
+			mode: info.Mode(),
+			size: info.Size(),
+		}
+		if rel == "." {
+			entry.path = "/"
+		}
+		if st, ok := info.Sys().(*syscall.Stat_t); ok {
+			entry.uid, entry.gid = st.Uid, st.Gid
+		}
+		if info.Mode()&fs.ModeSymlink != 0 {
+			if entry.link, err = os.Readlink(p); err != nil {
+				return err
+			}
+		}
+		entries[entry.path] = entry
+		return nil
+	})
+	return entries, err
+}
+
+// compareTrees compares the two trees file-by-file, modification times
+// are ignored
+func compareTrees(first, second string) ([]reproducibilityDiff, error) {
+	firstEntries, err := walkTree(first)
+	if err != nil {
+		return nil, err
+	}
+	secondEntries, err := walkTree(second)
+	if err != nil {
+		return nil, err
+	}
+	var paths []string
+	for p := range firstEntries {
+		paths = append(paths, p)
+	}
+	for p := range secondEntries {
+		if _, ok := firstEntries[p]; !ok {
+			paths = append(paths, p)
+		}
+	}
+	sort.Strings(paths)
+
+	var diffs []reproducibilityDiff
+	for _, p := range paths {
+		a, b := firstEntries[p], secondEntries[p]
+		switch {
+		case a == nil:
+			diffs = append(diffs, reproducibilityDiff{Path: p, What: "missing", Second: fileTypeName(b.mode)})
+			continue
+		case b == nil:
+			diffs = append(diffs, reproducibilityDiff{Path: p, What: "missing", First: fileTypeName(a.mode)})
+			continue
+		case a.mode.Type() != b.mode.Type():
+			diffs = append(diffs, reproducibilityDiff{Path: p, What: "type", First: fileTypeName(a.mode), Second: fileTypeName(b.mode)})
+			continue
+		}
+		if a.mode.Perm() != b.mode.Perm() || a.mode&(fs.ModeSetuid|fs.ModeSetgid|fs.ModeSticky) != b.mode&(fs.ModeSetuid|fs.ModeSetgid|fs.ModeSticky) {
+			diffs = append(diffs, reproducibilityDiff{Path: p, What: "mode", First: a.mode.String(), Second: b.mode.String()})
+		}
+		if a.uid != b.uid || a.gid != b.gid {
+			diffs = append(diffs, reproducibilityDiff{Path: p, What: "owner", First: fmt.Sprintf("%d:%d", a.uid, a.gid), Second: fmt.Sprintf("%d:%d", b.uid, b.gid)})
+		}
+		if a.link != b.link {
+			diffs = append(diffs, reproducibilityDiff{Path: p, What: "symlink", First: a.link, Second: b.link})
+		}
+		if a.mode.IsRegular() {
+			same, err := sameContent(filepath.Join(first, p), filepath.Join(second, p), a.size, b.size)
+			if err != nil {
+				return nil, err
+			}
+			if !same {
+				diffs = append(diffs, reproducibilityDiff{Path: p, What: "content"})
+			}
+		}
+	}
+	return diffs, nil
+}
+
+func sameContent(first, second string, firstSize, secondSize int64) (bool, error) {
+	if firstSize != secondSize {
+		return false, nil
+	}
+	var sums []string
+	for _, p := range []string{first, second} {
+		sum, err := checksumFile(p, "sha256")
+		if err != nil {
+			return false, err
+		}
+		sums = append(sums, sum)
+	}
+	return sums[0] == sums[1], nil
+}
+
+// verifyReproducibleBuild runs the pipelines of the image twice with
+// the same manifest (and thus the same seed and content) in separate
+// stores and compares the exported trees and images
+func verifyReproducibleBuild(pbar progress.ProgressBar, b *imageBuild, cacheDir, outputBasename string) (*reproducibilityReport, error) {
+	var pipelines manifestPipelines
+	if err := json.Unmarshal(b.manifest, &pipelines); err != nil {
+		return nil, fmt.Errorf("cannot read manifest: %w", err)
+	}
+	exports := b.img.ImgType.Exports()
+	if !slices.Contains(exports, reproducibilityTreePipeline) && pipelines.stagesOf(reproducibilityTreePipeline) != nil {
+		exports = append(slices.Clone(exports), reproducibilityTreePipeline)
+	}
+
+	basename := basenameFor(b.img, outputBasename)
+	workDir := filepath.Join(b.outputDir, basename+".reproducibility")
+	defer os.RemoveAll(workDir)
+	var runDirs []string
+	for i := 1; i <= 2; i++ {
+		storeDir, err := os.MkdirTemp(cacheDir, "reproducibility-")
+		if err != nil {
+			return nil, err
+		}
+		defer os.RemoveAll(storeDir)
+		runDir := filepath.Join(workDir, fmt.Sprintf("build-%d", i))
+		pbar.SetPulseMsgf("Reproducibility build %d/2", i)
+		osbuildOpts := &progress.OSBuildOptions{
+			StoreDir:  storeDir,
+			OutputDir: runDir,
+		}
+		if err := progress.RunOSBuild(pbar, b.manifest, exports, osbuildOpts); err != nil {
+			return nil, fmt.Errorf("reproducibility build %d failed: %w", i, err)
+		}
+		runDirs = append(runDirs, runDir)
+	}
+
+	report := &reproducibilityReport{
+		Image:       basename,
+		Differences: []reproducibilityDiff{},
+	}
+	for _, export := range exports {
+		pbar.SetPulseMsgf("Comparing %s", export)
+		diffs, err := compareTrees(filepath.Join(runDirs[0], export), filepath.Join(runDirs[1], export))
+		if err != nil {
+			return nil, err
+		}
+		for _, diff := range diffs {
+			diff.Pipeline = export
+			diff.Stage = pipelines.stageFor(export, diff.Path)
+			report.Differences = append(report.Differences, diff)
+		}
+	}
+	report.Reproducible = len(report.Differences) == 0
+
+	// keep the image of the first build as the result of the build
+	srcName := filepath.Join(runDirs[0], b.img.ImgType.Exports()[0], b.img.ImgType.Filename())
+	if err := os.Rename(srcName, imagePathFor(b.img, b.outputDir, outputBasename)); err != nil {
+		return nil, fmt.Errorf("cannot rename artifact to final name: %w", err)
+	}
+	reportPath := filepath.Join(b.outputDir, basename+".reproducibility.json")
+	data, err := json.MarshalIndent(report, "", "  ")
+	if err != nil {
+		return nil, err
+	}
+	// #nosec: G306
+	if err := os.WriteFile(reportPath, data, 0644); err != nil {
+		return nil, err
+	}
+	return report, nil
+}
+
+func writeReproducibilityReports(w io.Writer, reports []*reproducibilityReport, jsonOutput bool) error {
+	if jsonOutput {
+		enc := json.NewEncoder(w)
+		enc.SetIndent("", "  ")
+		return enc.Encode(reports)
+	}
+	for _, report := range reports {
+		if report.Reproducible {
+			fmt.Fprintf(w, "%s is reproducible\n", report.Image)
+			continue
+		}
+		fmt.Fprintf(w, "%s is not reproducible, %d differences:\n", report.Image, len(report.Differences))
+		for _, diff := range report.Differences {
+			fmt.Fprintf(w, "  %s\n", diff.String())
+		}
+	}
+	return nil
+}
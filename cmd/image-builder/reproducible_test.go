@@ -0,0 +1,167 @@
+package main_test
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	main "github.com/osbuild/image-builder/cmd/image-builder"
+	"github.com/osbuild/image-builder/internal/testutil"
+	"github.com/osbuild/image-builder/pkg/arch"
+	testrepos "github.com/osbuild/image-builder/test/data/repositories"
+)
+
+// fakeReproducibleOsbuildScript exports an image and an os tree for
+// every --export, the machine-id of the tree depends on the store
+// unless "$0.reproducible" exists
+const fakeReproducibleOsbuildScript = `
+cat - > /dev/null
+output_dir=""
+store=""
+exports=()
+while [[ $# -gt 0 ]]; do
+  case "$1" in
+    --output-directory) output_dir="$2"; shift 2;;
+    --store) store="$2"; shift 2;;
+    --export) exports+=("$2"); shift 2;;
+    *) shift;;
+  esac
+done
+for export in "${exports[@]}"; do
+  mkdir -p "$output_dir/$export"
+  case $export in
+    qcow2)
+      echo "fake-img-qcow2" > "$output_dir/$export/disk.qcow2"
+      ;;
+    os)
+      mkdir -p "$output_dir/os/etc" "$output_dir/os/usr/bin"
+      echo "bash" > "$output_dir/os/usr/bin/bash"
+      ln -s bash "$output_dir/os/usr/bin/sh"
+      if [ -e "$0.reproducible" ]; then
+        echo "fixed" > "$output_dir/os/etc/machine-id"
+      else
+        echo "$store" > "$output_dir/os/etc/machine-id"
+        echo "$store" > "$output_dir/os/etc/$(basename "$store")"
+      fi
+      ;;
+  esac
+done
+`
+
+func runReproducibleBuild(t *testing.T, outputDir string, args ...string) (string, error) {
+	restore := main.MockManifestgenDepsolver(fakeDepsolve)
+	defer restore()
+	restore = main.MockManifestgenContainerResolver(fakeContainerResolver)
+	defer restore()
+	restore = main.MockNewRepoRegistry(testrepos.New)
+	defer restore()
+	var fakeStdout bytes.Buffer
+	restore = main.MockOsStdout(&fakeStdout)
+	defer restore()
+
+	restore = main.MockOsArgs(append([]string{
+		"build", "qcow2",
+		"--distro", "centos-9",
+		"--cache", t.TempDir(),
+		"--output-dir", outputDir,
+		"--verify-reproducible",
+	}, args...))
+	defer restore()
+	err := main.Run()
+	return fakeStdout.String(), err
+}
+
+func TestBuildVerifyReproducible(t *testing.T) {
+	fakeOsbuild := testutil.MockCommand(t, "osbuild", fakeReproducibleOsbuildScript)
+	require.NoError(t, os.WriteFile(fakeOsbuild.Path()+".reproducible", nil, 0644))
+
+	outputDir := t.TempDir()
+	output, err := runReproducibleBuild(t, outputDir)
+	require.NoError(t, err)
+
+	basename := fmt.Sprintf("centos-9-qcow2-%s", arch.Current())
+	assert.Equal(t, basename+" is reproducible\n", output)
+	// the image of the first build is kept
+	assert.FileExists(t, filepath.Join(outputDir, basename+".qcow2"))
+	assert.NoDirExists(t, filepath.Join(outputDir, basename+".reproducibility"))
+	assert.FileExists(t, filepath.Join(outputDir, basename+".reproducibility.json"))
+
+	calls := fakeOsbuild.CallArgsList()
+	require.Len(t, calls, 2)
+	var stores []string
+	for _, call := range calls {
+		assert.Contains(t, call, "qcow2")
+		assert.Contains(t, call, "os")
+		for i, arg := range call {
+			if arg == "--store" {
+				stores = append(stores, call[i+1])
+			}
+		}
+	}
+	require.Len(t, stores, 2)
+	assert.NotEqual(t, stores[0], stores[1])
+}
+
+func TestBuildVerifyReproducibleDifferences(t *testing.T) {
+	testutil.MockCommand(t, "osbuild", fakeReproducibleOsbuildScript)
+
+	outputDir := t.TempDir()
+	output, err := runReproducibleBuild(t, outputDir)
+	assert.EqualError(t, err, "build is not reproducible: 3 differences")
+
+	basename := fmt.Sprintf("centos-9-qcow2-%s", arch.Current())
+	assert.Contains(t, output, basename+" is not reproducible, 3 differences:\n")
+	assert.Contains(t, output, "  os: /etc/machine-id: content differs [org.osbuild.rpm]\n")
+	assert.Regexp(t, `  os: /etc/reproducibility-[0-9]+: file only in build 1 \[org.osbuild.rpm\]\n`, output)
+
+	data, err := os.ReadFile(filepath.Join(outputDir, basename+".reproducibility.json"))
+	require.NoError(t, err)
+	var report struct {
+		Reproducible bool `json:"reproducible"`
+		Differences  []struct {
+			Pipeline string `json:"pipeline"`
+			Path     string `json:"path"`
+			What     string `json:"what"`
+			Stage    string `json:"stage"`
+		} `json:"differences"`
+	}
+	require.NoError(t, json.Unmarshal(data, &report))
+	assert.False(t, report.Reproducible)
+	var whats []string
+	for _, diff := range report.Differences {
+		assert.Equal(t, "os", diff.Pipeline)
+		whats = append(whats, diff.What)
+	}
+	assert.ElementsMatch(t, []string{"content", "missing", "missing"}, whats)
+}
+
+func TestBuildVerifyReproducibleWithUpload(t *testing.T) {
+	_, err := runReproducibleBuild(t, t.TempDir(), "--to", "aws")
+	assert.EqualError(t, err, "--verify-reproducible cannot be used with upload targets")
+}
+
+func TestBuildVerifyReproducibleWithArtifacts(t *testing.T) {
+	for _, tc := range []struct {
+		args        []string
+		expectedErr string
+	}{
+		{[]string{"--with-manifest"}, "--verify-reproducible cannot be used with --with-manifest"},
+		{[]string{"--with-sbom"}, "--verify-reproducible cannot be used with --with-sbom"},
+		{[]string{"--with-buildlog"}, "--verify-reproducible cannot be used with --with-buildlog"},
+		{[]string{"--checksum", "sha256"}, "--verify-reproducible cannot be used with --checksum"},
+		{[]string{"--sign-key", "/path/to/key"}, "--verify-reproducible cannot be used with --sign-key"},
+	} {
+		t.Run(tc.args[0], func(t *testing.T) {
+			fakeOsbuild := testutil.MockCommand(t, "osbuild", fakeReproducibleOsbuildScript)
+			_, err := runReproducibleBuild(t, t.TempDir(), tc.args...)
+			assert.EqualError(t, err, tc.expectedErr)
+			assert.Len(t, fakeOsbuild.CallArgsList(), 0)
+		})
+	}
+}
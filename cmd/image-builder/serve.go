@@ -0,0 +1,1091 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"os"
+	"os/signal"
+	"path/filepath"
+	"slices"
+	"sort"
+	"sync"
+	"syscall"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/spf13/cobra"
+	"github.com/spf13/pflag"
+
+	"github.com/osbuild/image-builder/internal/blueprintload"
+	"github.com/osbuild/image-builder/pkg/arch"
+	"github.com/osbuild/image-builder/pkg/cloud"
+	"github.com/osbuild/image-builder/pkg/imagefilter"
+	"github.com/osbuild/image-builder/pkg/osbuild"
+)
+
+const (
+	serveJobKindBuild  = "build"
+	serveJobKindUpload = "upload"
+
+	serveJobStateQueued  = "queued"
+	serveJobStateRunning = "running"
+	serveJobStateSuccess = "success"
+	serveJobStateFailure = "failure"
+
+	// serveEventsBuffer is the number of events that are buffered for
+	// a subscriber of the events of a job, the stream of a subscriber
+	// that falls further behind is ended
+	serveEventsBuffer = 64
+)
+
+var (
+	// serveContext returns the context that stops "image-builder serve"
+	serveContext = func() (context.Context, context.CancelFunc) {
+		return signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	}
+)
+
+// serveUploadControlledFlags are the upload flags that are set by
+// the server and cannot be given as upload options
+var serveUploadControlledFlags = []string{"to", "arch", "distro", "format"}
+
+func defaultServeSocket() string {
+	if os.Getuid() == 0 {
+		return "/run/image-builder.sock"
+	}
+	if runtimeDir := os.Getenv("XDG_RUNTIME_DIR"); runtimeDir != "" {
+		return filepath.Join(runtimeDir, "image-builder.sock")
+	}
+	return filepath.Join(defaultCacheDir(), "image-builder.sock")
+}
+
+// serveImageRequest selects the image of a manifest or build request
+type serveImageRequest struct {
+	Distro    string          `json:"distro,omitempty"`
+	Arch      string          `json:"arch,omitempty"`
+	ImageType string          `json:"image_type"`
+	Blueprint json.RawMessage `json:"blueprint,omitempty"`
+	Seed      *int64          `json:"seed,omitempty"`
+}
+
+type serveBuildRequest struct {
+	serveImageRequest
+	To            []string       `json:"to,omitempty"`
+	UploadOptions map[string]any `json:"upload_options,omitempty"`
+}
+
+type serveUploadRequest struct {
+	BuildID string         `json:"build_id"`
+	To      string         `json:"to"`
+	Options map[string]any `json:"options,omitempty"`
+}
+
+// serveJob is a build or upload job of the server, it is persisted
+// as job.json in the job directory so that the history survives a
+// restart of the server
+type serveJob struct {
+	ID       string     `json:"id"`
+	Kind     string     `json:"kind"`
+	State    string     `json:"state"`
+	Error    string     `json:"error,omitempty"`
+	Created  time.Time  `json:"created"`
+	Started  *time.Time `json:"started,omitempty"`
+	Finished *time.Time `json:"finished,omitempty"`
+
+	Build  *serveBuildRequest  `json:"build,omitempty"`
+	Upload *serveUploadRequest `json:"upload,omitempty"`
+
+	// Image is the file name of the image of a build job
+	Image     string               `json:"image,omitempty"`
+	Artifacts []string             `json:"artifacts,omitempty"`
+	Uploads   []uploadTargetResult `json:"uploads,omitempty"`
+}
+
+func (job *serveJob) done() bool {
+	return job.State == serveJobStateSuccess || job.State == serveJobStateFailure
+}
+
+// serveEvent is a server-sent event of a job
+type serveEvent struct {
+	name string
+	data []byte
+}
+
+// jobEvents broadcasts the events of a job to the channels of its
+// subscribers until the job is done
+type jobEvents struct {
+	subscribers map[chan serveEvent]struct{}
+	// progress is the last progress event, it is sent to new
+	// subscribers after the state
+	progress []byte
+}
+
+func newJobEvents() *jobEvents {
+	return &jobEvents{subscribers: make(map[chan serveEvent]struct{})}
+}
+
+// publish sends the event to all subscribers without blocking, a
+// subscriber whose channel is full is removed and its channel closed
+func (ev *jobEvents) publish(event serveEvent) {
+	if event.name == "progress" {
+		ev.progress = event.data
+	}
+	for ch := range ev.subscribers {
+		select {
+		case ch <- event:
+		default:
+			close(ch)
+			delete(ev.subscribers, ch)
+		}
+	}
+}
+
+// close ends the streams of all subscribers, the last progress is
+// kept for later requests
+func (ev *jobEvents) close() {
+	for ch := range ev.subscribers {
+		close(ch)
+	}
+	ev.subscribers = nil
+}
+
+// serveProgress is the data of a progress event, it is converted
+// from the osbuild status of the build
+type serveProgress struct {
+	Message  string             `json:"message,omitempty"`
+	Pipeline string             `json:"pipeline,omitempty"`
+	Progress *serveProgressItem `json:"progress,omitempty"`
+}
+
+type serveProgressItem struct {
+	Message     string             `json:"message"`
+	Done        int                `json:"done"`
+	Total       int                `json:"total"`
+	SubProgress *serveProgressItem `json:"subprogress,omitempty"`
+}
+
+func newServeProgress(st *osbuild.Status) *serveProgress {
+	progress := &serveProgress{
+		Message:  st.Message,
+		Pipeline: st.Pipeline,
+	}
+	item := &progress.Progress
+	for p := st.Progress; p != nil; p = p.SubProgress {
+		*item = &serveProgressItem{
+			Message: p.Message,
+			Done:    p.Done,
+			Total:   p.Total,
+		}
+		item = &(*item).SubProgress
+	}
+	return progress
+}
+
+// jsonSeqRecordReader reads only the lines of r that are JSON text
+// sequence records (i.e. start with a record separator), the other
+// output of the build like "Image build successful" is skipped
+type jsonSeqRecordReader struct {
+	r   *bufio.Reader
+	buf []byte
+}
+
+func (rr *jsonSeqRecordReader) Read(p []byte) (int, error) {
+	for len(rr.buf) == 0 {
+		line, err := rr.r.ReadBytes('\n')
+		if len(line) > 0 && line[0] == '\x1e' {
+			rr.buf = line
+		}
+		if err != nil {
+			if len(rr.buf) > 0 {
+				break
+			}
+			return 0, err
+		}
+	}
+	n := copy(p, rr.buf)
+	rr.buf = rr.buf[n:]
+	return n, nil
+}
+
+type server struct {
+	cacheDir string
+	jobsDir  string
+	repoOpts *repoOptions
+	// forwarded are the global flags that are passed to every
+	// command that the server runs
+	forwarded []string
+
+	ctx    context.Context
+	cancel context.CancelFunc
+	sem    chan struct{}
+
+	mu   sync.Mutex
+	jobs map[string]*serveJob
+	// events are the event broadcasts of the jobs that were queued
+	// since the server started
+	events map[string]*jobEvents
+}
+
+func newServer(cacheDir string, concurrency int, repoOpts *repoOptions, forwarded []string) (*server, error) {
+	s := &server{
+		cacheDir:  cacheDir,
+		jobsDir:   filepath.Join(cacheDir, "serve-jobs"),
+		repoOpts:  repoOpts,
+		forwarded: forwarded,
+		sem:       make(chan struct{}, concurrency),
+		jobs:      make(map[string]*serveJob),
+		events:    make(map[string]*jobEvents),
+	}
+	s.ctx, s.cancel = context.WithCancel(context.Background())
+	if err := os.MkdirAll(s.jobsDir, 0o700); err != nil {
+		return nil, fmt.Errorf("cannot create jobs directory: %w", err)
+	}
+	if err := s.loadJobs(); err != nil {
+		return nil, err
+	}
+	return s, nil
+}
+
+// loadJobs loads the jobs of a previous run of the server. Jobs that
+// were running are marked as failed, queued jobs are queued again.
+func (s *server) loadJobs() error {
+	entries, err := os.ReadDir(s.jobsDir)
+	if err != nil {
+		return err
+	}
+	var queued []*serveJob
+	for _, entry := range entries {
+		data, err := os.ReadFile(filepath.Join(s.jobsDir, entry.Name(), "job.json"))
+		if errors.Is(err, os.ErrNotExist) {
+			continue
+		}
+		if err != nil {
+			return err
+		}
+		var job serveJob
+		if err := json.Unmarshal(data, &job); err != nil {
+			return fmt.Errorf("cannot load job %s: %w", entry.Name(), err)
+		}
+		switch job.State {
+		case serveJobStateRunning:
+			now := time.Now()
+			job.State = serveJobStateFailure
+			job.Error = "interrupted by a restart of the server"
+			job.Finished = &now
+			if err := s.saveJob(&job); err != nil {
+				return err
+			}
+		case serveJobStateQueued:
+			queued = append(queued, &job)
+			s.events[job.ID] = newJobEvents()
+		}
+		s.jobs[job.ID] = &job
+	}
+	sort.Slice(queued, func(i, j int) bool {
+		return queued[i].Created.Before(queued[j].Created)
+	})
+	for _, job := range queued {
+		s.enqueue(job)
+	}
+	return nil
+}
+
+func (s *server) jobDir(id string) string {
+	return filepath.Join(s.jobsDir, id)
+}
+
+// saveJob writes the job atomically, s.mu must be held or the job
+// must not be visible to other goroutines yet
+func (s *server) saveJob(job *serveJob) error {
+	data, err := json.MarshalIndent(job, "", "  ")
+	if err != nil {
+		return err
+	}
+	path := filepath.Join(s.jobDir(job.ID), "job.json")
+	if err := os.WriteFile(path+".tmp", data, 0o600); err != nil {
+		return err
+	}
+	return os.Rename(path+".tmp", path)
+}
+
+// updateJob changes the job with the given function and saves it. A
+// state change is published to the subscribers of the job events, the
+// streams end once the job is done. Once the server is stopped
+// the state is no longer saved, running jobs are marked as interrupted
+// on the next start instead.
+func (s *server) updateJob(job *serveJob, f func(job *serveJob)) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	state := job.State
+	f(job)
+	if ev, ok := s.events[job.ID]; ok && job.State != state {
+		if data, err := json.Marshal(job); err == nil {
+			ev.publish(serveEvent{name: "state", data: data})
+		}
+		if job.done() {
+			ev.close()
+		}
+	}
+	if s.ctx.Err() != nil {
+		return
+	}
+	if err := s.saveJob(job); err != nil {
+		fmt.Fprintf(osStderr, "WARNING: cannot save job %s: %v\n", job.ID, err)
+	}
+}
+
+// snapshot returns a copy of the job with the given id
+func (s *server) snapshot(id string) (*serveJob, bool) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	job, ok := s.jobs[id]
+	if !ok {
+		return nil, false
+	}
+	cpy := *job
+	return &cpy, true
+}
+
+// addJob creates the directory of a new job, prepare can write
+// additional files into it before the job is queued
+func (s *server) addJob(job *serveJob, prepare func(jobDir string) error) error {
+	job.ID = uuid.NewString()
+	job.State = serveJobStateQueued
+	job.Created = time.Now()
+	if err := os.MkdirAll(s.jobDir(job.ID), 0o700); err != nil {
+		return err
+	}
+	if prepare != nil {
+		if err := prepare(s.jobDir(job.ID)); err != nil {
+			_ = os.RemoveAll(s.jobDir(job.ID))
+			return err
+		}
+	}
+	if err := s.saveJob(job); err != nil {
+		return err
+	}
+	s.mu.Lock()
+	s.jobs[job.ID] = job
+	s.events[job.ID] = newJobEvents()
+	s.mu.Unlock()
+	s.enqueue(job)
+	return nil
+}
+
+// enqueue runs the job as soon as fewer than the configured number of
+// jobs are running. Jobs that are still queued when the server stops
+// are queued again on the next start.
+func (s *server) enqueue(job *serveJob) {
+	go func() {
+		select {
+		case s.sem <- struct{}{}:
+		case <-s.ctx.Done():
+			return
+		}
+		defer func() { <-s.sem }()
+		s.runJob(job)
+	}()
+}
+
+func (s *server) runJob(job *serveJob) {
+	s.updateJob(job, func(job *serveJob) {
+		now := time.Now()
+		job.State = serveJobStateRunning
+		job.Started = &now
+	})
+
+	var err error
+	switch job.Kind {
+	case serveJobKindBuild:
+		err = s.runBuild(job)
+	case serveJobKindUpload:
+		err = s.runUpload(job)
+	default:
+		err = fmt.Errorf("unknown job kind %q", job.Kind)
+	}
+
+	s.updateJob(job, func(job *serveJob) {
+		now := time.Now()
+		job.Finished = &now
+		job.State = serveJobStateSuccess
+		if err != nil {
+			job.State = serveJobStateFailure
+			job.Error = err.Error()
+		}
+	})
+}
+
+func (s *server) runBuild(job *serveJob) error {
+	jobDir := s.jobDir(job.ID)
+	outputDir := filepath.Join(jobDir, "output")
+	uploadResultsPath := filepath.Join(jobDir, "upload-results.json")
+
+	args := append([]string{"build"}, job.Build.args(jobDir)...)
+	args = append(args,
+		"--output-dir", outputDir,
+		"--cache", s.cacheDir,
+		"--progress", "jsonseq",
+		"--with-manifest",
+		"--upload-results", uploadResultsPath,
+	)
+	for _, to := range job.Build.To {
+		args = append(args, "--to", to)
+	}
+	optArgs, err := uploadOptionArgs(job.Build.UploadOptions)
+	if err != nil {
+		return err
+	}
+	args = append(args, optArgs...)
+	args = append(args, s.forwarded...)
+
+	// the build writes the osbuild status stream to stdout
+	pr, pw := io.Pipe()
+	forwarded := make(chan struct{})
+	go func() {
+		defer close(forwarded)
+		s.forwardStatus(job.ID, pr)
+	}()
+	buildErr := runSubcommand(s.ctx, args, pw, osStderr)
+	pw.Close()
+	<-forwarded
+
+	uploads, err := readUploadResults(uploadResultsPath)
+	if err != nil {
+		return err
+	}
+	artifacts, err := listArtifacts(outputDir)
+	if err != nil && !errors.Is(err, os.ErrNotExist) {
+		return err
+	}
+	s.updateJob(job, func(job *serveJob) {
+		job.Artifacts = artifacts
+		job.Uploads = uploads
+	})
+	return buildErr
+}
+
+func (s *server) runUpload(job *serveJob) error {
+	build, ok := s.snapshot(job.Upload.BuildID)
+	if !ok {
+		return fmt.Errorf("cannot find build %s", job.Upload.BuildID)
+	}
+	args := []string{
+		"upload", filepath.Join(s.jobDir(build.ID), "output", build.Image),
+		"--to", job.Upload.To,
+		"--arch", build.Build.Arch,
+		"--distro", build.Build.Distro,
+		"--format", "json",
+	}
+	optArgs, err := uploadOptionArgs(job.Upload.Options)
+	if err != nil {
+		return err
+	}
+	args = append(args, optArgs...)
+	args = append(args, s.forwarded...)
+
+	var out bytes.Buffer
+	if err := runSubcommand(s.ctx, args, &out, osStderr); err != nil {
+		s.updateJob(job, func(job *serveJob) {
+			job.Uploads = []uploadTargetResult{{Target: job.Upload.To, Error: err.Error()}}
+		})
+		return err
+	}
+	var result cloud.UploadResult
+	if err := json.Unmarshal(out.Bytes(), &result); err != nil {
+		return fmt.Errorf("cannot read upload result: %w", err)
+	}
+	s.updateJob(job, func(job *serveJob) {
+		job.Uploads = []uploadTargetResult{{Target: job.Upload.To, UploadResult: &result}}
+	})
+	return nil
+}
+
+// forwardStatus reads the osbuild status stream of a build and
+// publishes the messages and the progress changes as progress events,
+// the stage output is not forwarded. The stream is read until its end
+// even if it cannot be parsed so that the build is never blocked.
+func (s *server) forwardStatus(id string, r io.Reader) {
+	defer func() { _, _ = io.Copy(io.Discard, r) }()
+
+	scanner := osbuild.NewStatusScanner(&jsonSeqRecordReader{r: bufio.NewReader(r)})
+	var last []byte
+	for {
+		st, err := scanner.Status()
+		if err != nil {
+			fmt.Fprintf(osStderr, "WARNING: cannot read the osbuild status of job %s: %v\n", id, err)
+			return
+		}
+		if st == nil {
+			return
+		}
+		data, err := json.Marshal(newServeProgress(st))
+		if err != nil || (st.Message == "" && bytes.Equal(data, last)) {
+			continue
+		}
+		last = data
+		s.mu.Lock()
+		if ev, ok := s.events[id]; ok {
+			ev.publish(serveEvent{name: "progress", data: data})
+		}
+		s.mu.Unlock()
+	}
+}
+
+// listArtifacts returns the names of the files that a build wrote
+func listArtifacts(outputDir string) ([]string, error) {
+	entries, err := os.ReadDir(outputDir)
+	if err != nil {
+		return nil, err
+	}
+	var artifacts []string
+	for _, entry := range entries {
+		if !entry.Type().IsRegular() {
+			continue
+		}
+		artifacts = append(artifacts, entry.Name())
+	}
+	return artifacts, nil
+}
+
+// args returns the arguments of the "manifest" or "build" command for
+// the request, the blueprint is read from the given directory
+func (req *serveImageRequest) args(dir string) []string {
+	args := []string{req.ImageType, "--distro", req.Distro, "--arch", req.Arch}
+	if len(req.Blueprint) > 0 {
+		args = append(args, "--blueprint", filepath.Join(dir, "blueprint.json"))
+	}
+	if req.Seed != nil {
+		args = append(args, "--seed", fmt.Sprintf("%d", *req.Seed))
+	}
+	return args
+}
+
+// resolve writes the blueprint of the request into the given
+// directory and fills in the distro and architecture that are
+// selected by default
+func (s *server) resolve(req *serveImageRequest, dir string) (*imagefilter.Result, error) {
+	if req.ImageType == "" {
+		return nil, fmt.Errorf("missing image_type")
+	}
+	var bpDistroName string
+	if len(req.Blueprint) > 0 {
+		path := filepath.Join(dir, "blueprint.json")
+		if err := os.WriteFile(path, req.Blueprint, 0o600); err != nil {
+			return nil, err
+		}
+		bp, err := blueprintload.Load(path)
+		if err != nil {
+			return nil, err
+		}
+		bpDistroName = bp.Distro
+	}
+	distroName, err := findDistro(req.Distro, bpDistroName)
+	if err != nil {
+		return nil, err
+	}
+	if req.Arch == "" {
+		req.Arch = arch.Current().String()
+	}
+	img, err := getOneImage(distroName, req.ImageType, req.Arch, s.repoOpts)
+	if err != nil {
+		return nil, err
+	}
+	req.Distro = img.ImgType.Arch().Distro().Name()
+	req.Arch = img.ImgType.Arch().Name()
+	req.ImageType = img.ImgType.Name()
+	return img, nil
+}
+
+// uploadOptionArgs converts the given upload options to the flags of
+// the "upload" command
+func uploadOptionArgs(opts map[string]any) ([]string, error) {
+	uploadCmd := setupUploadCmd()
+	names := make([]string, 0, len(opts))
+	for name := range opts {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	var args []string
+	for _, name := range names {
+		if slices.Contains(serveUploadControlledFlags, name) || uploadCmd.Flags().Lookup(name) == nil {
+			return nil, fmt.Errorf("unknown upload option %q", name)
+		}
+		switch v := opts[name].(type) {
+		case string, bool, json.Number:
+			args = append(args, fmt.Sprintf("--%s=%v", name, v))
+		case []any:
+			for _, item := range v {
+				s, ok := item.(string)
+				if !ok {
+					return nil, fmt.Errorf("invalid value for upload option %q: lists must contain strings", name)
+				}
+				args = append(args, fmt.Sprintf("--%s=%s", name, s))
+			}
+		default:
+			return nil, fmt.Errorf("invalid value for upload option %q", name)
+		}
+	}
+	return args, nil
+}
+
+// newServeJobCmd returns the commands that the server runs in this
+// process, build and upload jobs run in a subprocess instead (see
+// runSubcommand)
+func newServeJobCmd(out io.Writer) (*cobra.Command, error) {
+	rootCmd := &cobra.Command{
+		Use:           "image-builder",
+		SilenceErrors: true,
+		SilenceUsage:  true,
+	}
+	if err := setupRootPersistentFlags(rootCmd); err != nil {
+		return nil, err
+	}
+	rootCmd.SetOut(out)
+	rootCmd.SetErr(osStderr)
+
+	manifestCmd, err := setupManifestCmd()
+	if err != nil {
+		return nil, err
+	}
+	rootCmd.AddCommand(manifestCmd)
+	return rootCmd, nil
+}
+
+func runServeCommand(args []string, out io.Writer) error {
+	rootCmd, err := newServeJobCmd(out)
+	if err != nil {
+		return err
+	}
+	rootCmd.SetArgs(args)
+	return rootCmd.Execute()
+}
+
+// serveForwardedFlags returns the global flags of the serve command
+// that are passed to the commands of the jobs
+func serveForwardedFlags(cmd *cobra.Command) []string {
+	var args []string
+	cmd.Flags().Visit(func(f *pflag.Flag) {
+		if f.Name == "output-dir" || cmd.Root().PersistentFlags().Lookup(f.Name) == nil {
+			return
+		}
+		if sv, ok := f.Value.(pflag.SliceValue); ok {
+			for _, v := range sv.GetSlice() {
+				args = append(args, fmt.Sprintf("--%s=%s", f.Name, v))
+			}
+			return
+		}
+		args = append(args, fmt.Sprintf("--%s=%s", f.Name, f.Value.String()))
+	})
+	return args
+}
+
+func (s *server) handler() http.Handler {
+	mux := http.NewServeMux()
+	mux.HandleFunc("GET /api/v1/images", s.handleListImages)
+	mux.HandleFunc("GET /api/v1/images/{distro}/{arch}/{image_type}", s.handleDescribeImage)
+	mux.HandleFunc("POST /api/v1/manifests", s.handleManifest)
+	mux.HandleFunc("POST /api/v1/builds", s.handleBuild)
+	mux.HandleFunc("POST /api/v1/uploads", s.handleUpload)
+	mux.HandleFunc("GET /api/v1/jobs", s.handleListJobs)
+	mux.HandleFunc("GET /api/v1/jobs/{id}", s.handleGetJob)
+	mux.HandleFunc("GET /api/v1/jobs/{id}/events", s.handleJobEvents)
+	mux.HandleFunc("GET /api/v1/jobs/{id}/artifacts/{name}", s.handleArtifact)
+	return mux
+}
+
+func writeJSON(w http.ResponseWriter, status int, v any) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	if err := json.NewEncoder(w).Encode(v); err != nil {
+		fmt.Fprintf(osStderr, "WARNING: cannot write response: %v\n", err)
+	}
+}
+
+func writeError(w http.ResponseWriter, status int, err error) {
+	writeJSON(w, status, map[string]string{"error": err.Error()})
+}
+
+func decodeRequest(r *http.Request, v any) error {
+	dec := json.NewDecoder(r.Body)
+	dec.DisallowUnknownFields()
+	dec.UseNumber()
+	if err := dec.Decode(v); err != nil {
+		return fmt.Errorf("cannot decode request: %w", err)
+	}
+	return nil
+}
+
+func (s *server) handleListImages(w http.ResponseWriter, r *http.Request) {
+	imageFilter, err := newImageFilterDefault(s.repoOpts.RepoDir, s.repoOpts.ExtraRepos, s.repoOpts.ForceDefsDir)
+	if err != nil {
+		writeError(w, http.StatusInternalServerError, err)
+		return
+	}
+	res, err := imageFilter.Filter(r.URL.Query()["filter"]...)
+	if err != nil {
+		writeError(w, http.StatusBadRequest, err)
+		return
+	}
+	fmter, err := imagefilter.NewResultsFormatter(imagefilter.OutputFormatJSON)
+	if err != nil {
+		writeError(w, http.StatusInternalServerError, err)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	if err := fmter.Output(w, res); err != nil {
+		fmt.Fprintf(osStderr, "WARNING: cannot write response: %v\n", err)
+	}
+}
+
+func (s *server) handleDescribeImage(w http.ResponseWriter, r *http.Request) {
+	img, err := getOneImage(r.PathValue("distro"), r.PathValue("image_type"), r.PathValue("arch"), s.repoOpts)
+	if err != nil {
+		writeError(w, http.StatusNotFound, err)
+		return
+	}
+	var out bytes.Buffer
+	if err := describeImage(img, &out); err != nil {
+		writeError(w, http.StatusInternalServerError, err)
+		return
+	}
+	w.Header().Set("Content-Type", "application/yaml")
+	_, _ = w.Write(out.Bytes())
+}
+
+func (s *server) handleManifest(w http.ResponseWriter, r *http.Request) {
+	var req serveImageRequest
+	if err := decodeRequest(r, &req); err != nil {
+		writeError(w, http.StatusBadRequest, err)
+		return
+	}
+	tmpDir, err := os.MkdirTemp("", "image-builder-serve-")
+	if err != nil {
+		writeError(w, http.StatusInternalServerError, err)
+		return
+	}
+	defer os.RemoveAll(tmpDir)
+	if _, err := s.resolve(&req, tmpDir); err != nil {
+		writeError(w, http.StatusBadRequest, err)
+		return
+	}
+
+	args := append([]string{"manifest"}, req.args(tmpDir)...)
+	args = append(args, s.forwarded...)
+	var out bytes.Buffer
+	if err := runServeCommand(args, &out); err != nil {
+		writeError(w, http.StatusUnprocessableEntity, err)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	_, _ = w.Write(out.Bytes())
+}
+
+func (s *server) handleBuild(w http.ResponseWriter, r *http.Request) {
+	var req serveBuildRequest
+	if err := decodeRequest(r, &req); err != nil {
+		writeError(w, http.StatusBadRequest, err)
+		return
+	}
+	if _, err := uploadOptionArgs(req.UploadOptions); err != nil {
+		writeError(w, http.StatusBadRequest, err)
+		return
+	}
+	job := &serveJob{Kind: serveJobKindBuild, Build: &req}
+	err := s.addJob(job, func(jobDir string) error {
+		img, err := s.resolve(&req.serveImageRequest, jobDir)
+		if err != nil {
+			return err
+		}
+		job.Image = filepath.Base(imagePathFor(img, "", ""))
+		return nil
+	})
+	if err != nil {
+		writeError(w, http.StatusBadRequest, err)
+		return
+	}
+	s.writeJobCreated(w, job.ID)
+}
+
+func (s *server) handleUpload(w http.ResponseWriter, r *http.Request) {
+	var req serveUploadRequest
+	if err := decodeRequest(r, &req); err != nil {
+		writeError(w, http.StatusBadRequest, err)
+		return
+	}
+	if req.To == "" {
+		writeError(w, http.StatusBadRequest, fmt.Errorf("missing to"))
+		return
+	}
+	if _, err := uploadOptionArgs(req.Options); err != nil {
+		writeError(w, http.StatusBadRequest, err)
+		return
+	}
+	build, ok := s.snapshot(req.BuildID)
+	if !ok || build.Kind != serveJobKindBuild {
+		writeError(w, http.StatusNotFound, fmt.Errorf("cannot find build %q", req.BuildID))
+		return
+	}
+	if build.State != serveJobStateSuccess {
+		writeError(w, http.StatusConflict, fmt.Errorf("cannot upload build %s in state %s", build.ID, build.State))
+		return
+	}
+	job := &serveJob{Kind: serveJobKindUpload, Upload: &req}
+	if err := s.addJob(job, nil); err != nil {
+		writeError(w, http.StatusInternalServerError, err)
+		return
+	}
+	s.writeJobCreated(w, job.ID)
+}
+
+func (s *server) writeJobCreated(w http.ResponseWriter, id string) {
+	job, _ := s.snapshot(id)
+	w.Header().Set("Location", "/api/v1/jobs/"+id)
+	writeJSON(w, http.StatusAccepted, job)
+}
+
+func (s *server) handleListJobs(w http.ResponseWriter, r *http.Request) {
+	s.mu.Lock()
+	jobs := make([]serveJob, 0, len(s.jobs))
+	for _, job := range s.jobs {
+		jobs = append(jobs, *job)
+	}
+	s.mu.Unlock()
+	sort.Slice(jobs, func(i, j int) bool {
+		return jobs[i].Created.Before(jobs[j].Created)
+	})
+	writeJSON(w, http.StatusOK, jobs)
+}
+
+func (s *server) handleGetJob(w http.ResponseWriter, r *http.Request) {
+	job, ok := s.snapshot(r.PathValue("id"))
+	if !ok {
+		writeError(w, http.StatusNotFound, fmt.Errorf("cannot find job %q", r.PathValue("id")))
+		return
+	}
+	writeJSON(w, http.StatusOK, job)
+}
+
+// handleJobEvents streams the state changes of the job and the build
+// progress as server-sent events until the job is done. The current
+// state is sent first, the progress events are the osbuild status
+// messages that the job forwards while the build runs.
+func (s *server) handleJobEvents(w http.ResponseWriter, r *http.Request) {
+	id := r.PathValue("id")
+	flusher, ok := w.(http.Flusher)
+	if !ok {
+		writeError(w, http.StatusInternalServerError, fmt.Errorf("streaming is not supported"))
+		return
+	}
+
+	// subscribe together with taking the snapshot so that no state
+	// change is missed in between
+	var events chan serveEvent
+	var data, progress []byte
+	var err error
+	s.mu.Lock()
+	job, ok := s.jobs[id]
+	if ok {
+		data, err = json.Marshal(job)
+		if ev, ok := s.events[id]; ok && err == nil {
+			progress = ev.progress
+			if !job.done() {
+				events = make(chan serveEvent, serveEventsBuffer)
+				ev.subscribers[events] = struct{}{}
+			}
+		}
+	}
+	s.mu.Unlock()
+	if !ok {
+		writeError(w, http.StatusNotFound, fmt.Errorf("cannot find job %q", id))
+		return
+	}
+	if err != nil {
+		writeError(w, http.StatusInternalServerError, err)
+		return
+	}
+	if events != nil {
+		defer s.unsubscribe(id, events)
+	}
+
+	w.Header().Set("Content-Type", "text/event-stream")
+	w.Header().Set("Cache-Control", "no-cache")
+	w.WriteHeader(http.StatusOK)
+	writeEvent(w, "state", data)
+	if progress != nil {
+		writeEvent(w, "progress", progress)
+	}
+	flusher.Flush()
+	if events == nil {
+		return
+	}
+	for {
+		select {
+		case event, ok := <-events:
+			if !ok {
+				return
+			}
+			writeEvent(w, event.name, event.data)
+			flusher.Flush()
+		case <-r.Context().Done():
+			return
+		case <-s.ctx.Done():
+			return
+		}
+	}
+}
+
+// unsubscribe removes the subscriber from the events of the job unless
+// its stream was already ended
+func (s *server) unsubscribe(id string, events chan serveEvent) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if ev, ok := s.events[id]; ok {
+		if _, ok := ev.subscribers[events]; ok {
+			delete(ev.subscribers, events)
+			close(events)
+		}
+	}
+}
+
+func writeEvent(w io.Writer, event string, data []byte) {
+	fmt.Fprintf(w, "event: %s\ndata: %s\n\n", event, data)
+}
+
+func (s *server) handleArtifact(w http.ResponseWriter, r *http.Request) {
+	job, ok := s.snapshot(r.PathValue("id"))
+	if !ok {
+		writeError(w, http.StatusNotFound, fmt.Errorf("cannot find job %q", r.PathValue("id")))
+		return
+	}
+	name := r.PathValue("name")
+	if !slices.Contains(job.Artifacts, name) {
+		writeError(w, http.StatusNotFound, fmt.Errorf("cannot find artifact %q of job %s", name, job.ID))
+		return
+	}
+	f, err := os.Open(filepath.Join(s.jobDir(job.ID), "output", name))
+	if err != nil {
+		writeError(w, http.StatusInternalServerError, err)
+		return
+	}
+	defer f.Close()
+	st, err := f.Stat()
+	if err != nil {
+		writeError(w, http.StatusInternalServerError, err)
+		return
+	}
+	w.Header().Set("Content-Disposition", fmt.Sprintf("attachment; filename=%q", name))
+	http.ServeContent(w, r, name, st.ModTime(), f)
+}
+
+func listenForServe(socketPath, listenAddr string) (net.Listener, error) {
+	if listenAddr != "" {
+		return net.Listen("tcp", listenAddr)
+	}
+	if err := os.MkdirAll(filepath.Dir(socketPath), 0o755); err != nil {
+		return nil, err
+	}
+	// remove the socket of a previous run that did not shut down
+	if err := os.Remove(socketPath); err != nil && !errors.Is(err, os.ErrNotExist) {
+		return nil, err
+	}
+	l, err := net.Listen("unix", socketPath)
+	if err != nil {
+		return nil, err
+	}
+	// the API can build and upload images, only the owner can use it
+	if err := os.Chmod(socketPath, 0o600); err != nil {
+		l.Close()
+		return nil, err
+	}
+	return l, nil
+}
+
+func cmdServe(cmd *cobra.Command, args []string) error {
+	socketPath, err := cmd.Flags().GetString("socket")
+	if err != nil {
+		return err
+	}
+	listenAddr, err := cmd.Flags().GetString("listen")
+	if err != nil {
+		return err
+	}
+	concurrency, err := cmd.Flags().GetInt("concurrency")
+	if err != nil {
+		return err
+	}
+	cacheDir, err := cmd.Flags().GetString("cache")
+	if err != nil {
+		return err
+	}
+	repoDir, err := cmd.Flags().GetString("force-repo-dir")
+	if err != nil {
+		return err
+	}
+	extraRepos, err := cmd.Flags().GetStringArray("extra-repo")
+	if err != nil {
+		return err
+	}
+	forceRepos, err := cmd.Flags().GetStringArray("force-repo")
+	if err != nil {
+		return err
+	}
+	forceDefsDir, err := cmd.Flags().GetString("force-defs-dir")
+	if err != nil {
+		return err
+	}
+	if concurrency < 1 {
+		return fmt.Errorf("--concurrency must be at least 1, got %d", concurrency)
+	}
+	if listenAddr != "" && cmd.Flags().Changed("socket") {
+		return fmt.Errorf("cannot use --socket with --listen")
+	}
+
+	repoOpts := &repoOptions{
+		RepoDir:      repoDir,
+		ExtraRepos:   extraRepos,
+		ForceRepos:   forceRepos,
+		ForceDefsDir: forceDefsDir,
+	}
+	srv, err := newServer(cacheDir, concurrency, repoOpts, serveForwardedFlags(cmd))
+	if err != nil {
+		return err
+	}
+	defer srv.cancel()
+
+	l, err := listenForServe(socketPath, listenAddr)
+	if err != nil {
+		return err
+	}
+	ctx, stop := serveContext()
+	defer stop()
+
+	httpSrv := &http.Server{
+		Handler:           srv.handler(),
+		ReadHeaderTimeout: 10 * time.Second,
+	}
+	errCh := make(chan error, 1)
+	go func() {
+		errCh <- httpSrv.Serve(l)
+	}()
+	fmt.Fprintf(osStderr, "Serving the image-builder API on %s\n", l.Addr())
+
+	select {
+	case err := <-errCh:
+		return err
+	case <-ctx.Done():
+	}
+	// stop the event streams and queued jobs, running builds are
+	// marked as interrupted on the next start
+	srv.cancel()
+	shutdownCtx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	return httpSrv.Shutdown(shutdownCtx)
+}
@@ -0,0 +1,339 @@
+package main_test
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"io"
+	"net"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	main "github.com/osbuild/image-builder/cmd/image-builder"
+	"github.com/osbuild/image-builder/internal/testutil"
+	"github.com/osbuild/image-builder/pkg/cloud"
+	"github.com/osbuild/image-builder/pkg/cloud/awscloud"
+	testrepos "github.com/osbuild/image-builder/test/data/repositories"
+)
+
+type serveClient struct {
+	t      *testing.T
+	client *http.Client
+}
+
+// startServe runs "image-builder serve" on a unix socket until the
+// returned stop function is called
+func startServe(t *testing.T, cacheDir string, args ...string) (*serveClient, func()) {
+	socketPath := filepath.Join(t.TempDir(), "ib.sock")
+	ctx, cancel := context.WithCancel(context.Background())
+	restoreCtx := main.MockServeContext(ctx)
+	restoreArgs := main.MockOsArgs(append([]string{"serve", "--socket", socketPath, "--cache", cacheDir}, args...))
+
+	done := make(chan error, 1)
+	go func() {
+		done <- main.Run()
+	}()
+	require.Eventually(t, func() bool {
+		_, err := os.Stat(socketPath)
+		return err == nil
+	}, 10*time.Second, 10*time.Millisecond)
+	restoreArgs()
+
+	client := &http.Client{
+		Transport: &http.Transport{
+			DialContext: func(ctx context.Context, _, _ string) (net.Conn, error) {
+				var d net.Dialer
+				return d.DialContext(ctx, "unix", socketPath)
+			},
+		},
+	}
+	stop := func() {
+		cancel()
+		require.NoError(t, <-done)
+		restoreCtx()
+	}
+	return &serveClient{t: t, client: client}, stop
+}
+
+func (c *serveClient) do(method, path string, body any) (int, []byte) {
+	var r io.Reader
+	if body != nil {
+		data, err := json.Marshal(body)
+		require.NoError(c.t, err)
+		r = bytes.NewReader(data)
+	}
+	req, err := http.NewRequest(method, "http://localhost"+path, r)
+	require.NoError(c.t, err)
+	resp, err := c.client.Do(req)
+	require.NoError(c.t, err)
+	defer resp.Body.Close()
+	data, err := io.ReadAll(resp.Body)
+	require.NoError(c.t, err)
+	return resp.StatusCode, data
+}
+
+type serveTestJob struct {
+	ID        string   `json:"id"`
+	Kind      string   `json:"kind"`
+	State     string   `json:"state"`
+	Error     string   `json:"error"`
+	Image     string   `json:"image"`
+	Artifacts []string `json:"artifacts"`
+	Uploads   []struct {
+		Target string `json:"target"`
+		cloud.UploadResult
+	} `json:"uploads"`
+}
+
+func (c *serveClient) job(id string) *serveTestJob {
+	status, data := c.do("GET", "/api/v1/jobs/"+id, nil)
+	require.Equal(c.t, http.StatusOK, status, string(data))
+	var job serveTestJob
+	require.NoError(c.t, json.Unmarshal(data, &job))
+	return &job
+}
+
+func (c *serveClient) submit(path string, body any) *serveTestJob {
+	status, data := c.do("POST", path, body)
+	require.Equal(c.t, http.StatusAccepted, status, string(data))
+	var job serveTestJob
+	require.NoError(c.t, json.Unmarshal(data, &job))
+	return &job
+}
+
+func (c *serveClient) waitDone(id string) *serveTestJob {
+	var job *serveTestJob
+	require.Eventually(c.t, func() bool {
+		job = c.job(id)
+		return job.State == "success" || job.State == "failure"
+	}, 30*time.Second, 20*time.Millisecond)
+	return job
+}
+
+func mockServeBuildDeps(t *testing.T) {
+	mockSubcommand(t)
+	restore := main.MockManifestgenDepsolver(fakeDepsolve)
+	t.Cleanup(restore)
+	restore = main.MockNewRepoRegistry(testrepos.New)
+	t.Cleanup(restore)
+	restore = main.MockOsStdout(io.Discard)
+	t.Cleanup(restore)
+	restore = main.MockOsStderr(io.Discard)
+	t.Cleanup(restore)
+}
+
+var serveBuildRequest = map[string]any{
+	"distro":     "centos-9",
+	"arch":       "x86_64",
+	"image_type": "qcow2",
+	"blueprint": map[string]any{
+		"packages": []map[string]string{{"name": "tmux"}},
+	},
+}
+
+func TestServeImagesAndManifests(t *testing.T) {
+	mockServeBuildDeps(t)
+	client, stop := startServe(t, t.TempDir())
+	defer stop()
+
+	status, data := client.do("GET", "/api/v1/images?filter=distro:centos-9&filter=type:qcow2&filter=arch:x86_64", nil)
+	require.Equal(t, http.StatusOK, status, string(data))
+	var images []struct {
+		Distro struct {
+			Name string `json:"name"`
+		} `json:"distro"`
+		ImgType struct {
+			Name string `json:"name"`
+		} `json:"image_type"`
+	}
+	require.NoError(t, json.Unmarshal(data, &images))
+	require.Len(t, images, 1)
+	assert.Equal(t, "centos-9", images[0].Distro.Name)
+	assert.Equal(t, "qcow2", images[0].ImgType.Name)
+
+	status, data = client.do("GET", "/api/v1/images/centos-9/x86_64/qcow2", nil)
+	require.Equal(t, http.StatusOK, status, string(data))
+	assert.Contains(t, string(data), "type: qcow2\n")
+
+	status, data = client.do("GET", "/api/v1/images/centos-9/x86_64/no-such-type", nil)
+	assert.Equal(t, http.StatusNotFound, status, string(data))
+
+	status, data = client.do("POST", "/api/v1/manifests", serveBuildRequest)
+	require.Equal(t, http.StatusOK, status, string(data))
+	var mf struct {
+		Version string `json:"version"`
+	}
+	require.NoError(t, json.Unmarshal(data, &mf))
+	assert.Equal(t, "2", mf.Version)
+}
+
+func TestServeBadRequests(t *testing.T) {
+	mockServeBuildDeps(t)
+	client, stop := startServe(t, t.TempDir())
+	defer stop()
+
+	for _, tc := range []struct {
+		path        string
+		body        any
+		status      int
+		expectedErr string
+	}{
+		{"/api/v1/manifests", map[string]any{"image_type": "qcow2", "distro": "centos-9", "color": "red"}, http.StatusBadRequest, `unknown field "color"`},
+		{"/api/v1/manifests", map[string]any{"distro": "centos-9"}, http.StatusBadRequest, "missing image_type"},
+		{"/api/v1/builds", map[string]any{"image_type": "no-such-type", "distro": "centos-9"}, http.StatusBadRequest, "no-such-type"},
+		{"/api/v1/builds", map[string]any{"image_type": "qcow2", "distro": "centos-9", "upload_options": map[string]any{"format": "json"}}, http.StatusBadRequest, `unknown upload option "format"`},
+		{"/api/v1/builds", map[string]any{"image_type": "qcow2", "distro": "centos-9", "upload_options": map[string]any{"aws-tag": []any{1}}}, http.StatusBadRequest, `invalid value for upload option "aws-tag"`},
+		{"/api/v1/uploads", map[string]any{"build_id": "no-such-build", "to": "s3"}, http.StatusNotFound, `cannot find build "no-such-build"`},
+		{"/api/v1/uploads", map[string]any{"build_id": "no-such-build"}, http.StatusBadRequest, "missing to"},
+	} {
+		t.Run(tc.expectedErr, func(t *testing.T) {
+			status, data := client.do("POST", tc.path, tc.body)
+			assert.Equal(t, tc.status, status)
+			var res struct {
+				Error string `json:"error"`
+			}
+			require.NoError(t, json.Unmarshal(data, &res))
+			assert.Contains(t, res.Error, tc.expectedErr)
+		})
+	}
+
+	status, _ := client.do("GET", "/api/v1/jobs/no-such-job", nil)
+	assert.Equal(t, http.StatusNotFound, status)
+}
+
+func TestServeBuildAndUpload(t *testing.T) {
+	mockServeBuildDeps(t)
+	// emit some osbuild progress that is streamed as an event
+	script := strings.Replace(makeFakeOsbuildScript(), "\ncase $export in", "\n"+`printf '\036{"message": "building", "progress": {"name": "pipelines", "total": 4, "done": 1}}\n' >&3`+"\ncase $export in", 1)
+	fakeOsbuildCmd := testutil.MockCommand(t, "osbuild", script)
+
+	s3Dir := mockSubcommandS3(t)
+
+	cacheDir := t.TempDir()
+	client, stop := startServe(t, cacheDir)
+	defer func() { stop() }()
+
+	job := client.submit("/api/v1/builds", serveBuildRequest)
+	assert.Equal(t, "build", job.Kind)
+	assert.Equal(t, "centos-9-qcow2-x86_64.qcow2", job.Image)
+
+	// the events are streamed until the job is done
+	status, events := client.do("GET", "/api/v1/jobs/"+job.ID+"/events", nil)
+	require.Equal(t, http.StatusOK, status)
+	assert.Contains(t, string(events), "event: progress\ndata: {")
+	assert.Contains(t, string(events), `"total":4`)
+	assert.Contains(t, string(events), "event: state\ndata: {")
+	assert.Contains(t, string(events), `"state":"success"`)
+	require.Len(t, fakeOsbuildCmd.CallArgsList(), 1)
+
+	job = client.job(job.ID)
+	assert.Equal(t, "success", job.State, job.Error)
+	// the last progress is also sent for a finished job
+	status, events = client.do("GET", "/api/v1/jobs/"+job.ID+"/events", nil)
+	require.Equal(t, http.StatusOK, status)
+	assert.Contains(t, string(events), `"state":"success"`)
+	assert.Contains(t, string(events), `"total":4`)
+	assert.Equal(t, []string{"centos-9-qcow2-x86_64.osbuild-manifest.json", "centos-9-qcow2-x86_64.qcow2"}, job.Artifacts)
+
+	status, data := client.do("GET", "/api/v1/jobs/"+job.ID+"/artifacts/centos-9-qcow2-x86_64.qcow2", nil)
+	require.Equal(t, http.StatusOK, status)
+	assert.Equal(t, "fake-img-qcow2\n", string(data))
+	status, _ = client.do("GET", "/api/v1/jobs/"+job.ID+"/artifacts/job.json", nil)
+	assert.Equal(t, http.StatusNotFound, status)
+
+	upload := client.submit("/api/v1/uploads", map[string]any{
+		"build_id": job.ID,
+		"to":       "s3",
+		"options": map[string]any{
+			"s3-bucket":     "images",
+			"s3-path-style": true,
+		},
+	})
+	assert.Equal(t, "upload", upload.Kind)
+	upload = client.waitDone(upload.ID)
+	require.Equal(t, "success", upload.State, upload.Error)
+	require.Len(t, upload.Uploads, 1)
+	assert.Equal(t, "s3", upload.Uploads[0].Target)
+	assert.Equal(t, "aws", upload.Uploads[0].Provider)
+	var s3Opts awscloud.S3UploaderOptions
+	data, err := os.ReadFile(filepath.Join(s3Dir, "s3-options.json"))
+	require.NoError(t, err)
+	require.NoError(t, json.Unmarshal(data, &s3Opts))
+	assert.True(t, s3Opts.UsePathStyle)
+	data, err = os.ReadFile(filepath.Join(s3Dir, "s3-data"))
+	require.NoError(t, err)
+	assert.Equal(t, "fake-img-qcow2\n", string(data))
+
+	// the history survives a restart
+	stop()
+	client, stop = startServe(t, cacheDir)
+	status, data = client.do("GET", "/api/v1/jobs", nil)
+	require.Equal(t, http.StatusOK, status)
+	var jobs []serveTestJob
+	require.NoError(t, json.Unmarshal(data, &jobs))
+	require.Len(t, jobs, 2)
+	assert.Equal(t, job.ID, jobs[0].ID)
+	assert.Equal(t, "success", jobs[0].State)
+	assert.Equal(t, upload.ID, jobs[1].ID)
+	status, data = client.do("GET", "/api/v1/jobs/"+job.ID+"/artifacts/centos-9-qcow2-x86_64.qcow2", nil)
+	require.Equal(t, http.StatusOK, status)
+	assert.Equal(t, "fake-img-qcow2\n", string(data))
+}
+
+func TestServeConcurrencyAndRestart(t *testing.T) {
+	mockServeBuildDeps(t)
+	// builds block until they are released by the test
+	script := `while [ ! -e "$0".release ]; do sleep 0.05; done` + makeFakeOsbuildScript()
+	fakeOsbuildCmd := testutil.MockCommand(t, "osbuild", script)
+
+	cacheDir := t.TempDir()
+	client, stop := startServe(t, cacheDir, "--concurrency", "1")
+	first := client.submit("/api/v1/builds", serveBuildRequest)
+	second := client.submit("/api/v1/builds", serveBuildRequest)
+	require.Eventually(t, func() bool {
+		return client.job(first.ID).State == "running"
+	}, 10*time.Second, 10*time.Millisecond)
+	// only one build runs at a time
+	time.Sleep(100 * time.Millisecond)
+	assert.Equal(t, "queued", client.job(second.ID).State)
+
+	// a restart fails the running build and queues the other again,
+	// the build process of the stopped server is killed and must not
+	// change the recorded state
+	stop()
+	require.NoError(t, os.WriteFile(fakeOsbuildCmd.Path()+".release", nil, 0644))
+	client, stop = startServe(t, cacheDir, "--concurrency", "1")
+	defer stop()
+
+	job := client.job(first.ID)
+	assert.Equal(t, "failure", job.State)
+	assert.Equal(t, "interrupted by a restart of the server", job.Error)
+	job = client.waitDone(second.ID)
+	assert.Equal(t, "success", job.State, job.Error)
+	assert.Contains(t, job.Artifacts, "centos-9-qcow2-x86_64.qcow2")
+	status, _ := client.do("POST", "/api/v1/uploads", map[string]any{"build_id": first.ID, "to": "s3"})
+	assert.Equal(t, http.StatusConflict, status)
+}
+
+func TestServeBadConcurrency(t *testing.T) {
+	restore := main.MockOsArgs([]string{"serve", "--concurrency", "0"})
+	defer restore()
+
+	err := main.Run()
+	assert.EqualError(t, err, "--concurrency must be at least 1, got 0")
+}
+
+func TestServeSocketAndListen(t *testing.T) {
+	restore := main.MockOsArgs([]string{"serve", "--socket", "ib.sock", "--listen", "127.0.0.1:0"})
+	defer restore()
+
+	err := main.Run()
+	assert.EqualError(t, err, "cannot use --socket with --listen")
+}
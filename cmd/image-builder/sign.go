@@ -0,0 +1,314 @@
+package main
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"crypto/sha512"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"hash"
+	"io"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+
+	"github.com/spf13/cobra"
+)
+
+var checksumAlgorithms = map[string]func() hash.Hash{
+	"sha256": sha256.New,
+	"sha512": sha512.New,
+}
+
+const (
+	signatureKindGPG      = "gpg"
+	signatureKindSigstore = "sigstore"
+)
+
+// signatureExts are the extensions of the detached signatures of the
+// artifacts, gpg signatures are ascii armored
+var signatureExts = map[string]string{
+	signatureKindGPG:      ".asc",
+	signatureKindSigstore: ".sig",
+}
+
+func validateChecksumAlgorithms(algos []string) error {
+	for _, algo := range algos {
+		if _, ok := checksumAlgorithms[algo]; !ok {
+			return fmt.Errorf("unsupported checksum %q, supported: sha256, sha512", algo)
+		}
+	}
+	return nil
+}
+
+func checksumFile(path, algo string) (string, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return "", err
+	}
+	defer f.Close()
+	h := checksumAlgorithms[algo]()
+	if _, err := io.Copy(h, f); err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(h.Sum(nil)), nil
+}
+
+// writeChecksum writes the checksum of the artifact next to it in the
+// format of sha256sum(1) so that it can also be checked with
+// e.g. "sha256sum -c"
+func writeChecksum(path, algo string) error {
+	sum, err := checksumFile(path, algo)
+	if err != nil {
+		return err
+	}
+	content := fmt.Sprintf("%s  %s\n", sum, filepath.Base(path))
+	// #nosec: G306
+	return os.WriteFile(path+"."+algo, []byte(content), 0644)
+}
+
+func looksLikeKeyFile(keyPath string) bool {
+	switch filepath.Ext(keyPath) {
+	case ".key", ".pub", ".pem", ".asc", ".gpg":
+		return true
+	}
+	return strings.Contains(keyPath, "/")
+}
+
+// keyKind detects the kind of the given (private or public) key. A
+// path that does not exist is the id of a key in the local gpg
+// keyring.
+func keyKind(keyPath string) (kind string, isFile bool, err error) {
+	data, err := os.ReadFile(keyPath)
+	if errors.Is(err, os.ErrNotExist) && !looksLikeKeyFile(keyPath) {
+		return signatureKindGPG, false, nil
+	}
+	if err != nil {
+		return "", false, fmt.Errorf("cannot read key: %w", err)
+	}
+	switch {
+	case bytes.Contains(data, []byte("-----BEGIN PGP ")):
+		return signatureKindGPG, true, nil
+	case bytes.Contains(data, []byte("-----BEGIN ")) && bytes.Contains(data, []byte(" KEY-----")):
+		return signatureKindSigstore, true, nil
+	default:
+		return "", false, fmt.Errorf("unsupported key %s: expected an armored gpg key or a sigstore (PEM) key", keyPath)
+	}
+}
+
+// importGPGKey imports the given key file into a temporary gpg home
+// so that the keyring of the user is not modified
+func importGPGKey(keyPath string) (gpgHome string, cleanup func(), err error) {
+	gpgHome, err = os.MkdirTemp("", "image-builder-gpg-")
+	if err != nil {
+		return "", nil, err
+	}
+	cleanup = func() {
+		// stop the agent that gpg started for the temporary home
+		_ = runCommand("gpgconf", "--homedir", gpgHome, "--kill", "gpg-agent")
+		_ = os.RemoveAll(gpgHome)
+	}
+	if err := runCommand("gpg", "--batch", "--homedir", gpgHome, "--import", keyPath); err != nil {
+		cleanup()
+		return "", nil, fmt.Errorf("cannot import gpg key: %w", err)
+	}
+	return gpgHome, cleanup, nil
+}
+
+// artifactSigner creates detached signatures with a gpg key (from the
+// local keyring or a key file) or with a sigstore key file via cosign
+type artifactSigner struct {
+	kind    string
+	key     string
+	gpgHome string
+}
+
+func newArtifactSigner(keyPath string) (*artifactSigner, func(), error) {
+	kind, isFile, err := keyKind(keyPath)
+	if err != nil {
+		return nil, nil, err
+	}
+	signer := &artifactSigner{kind: kind, key: keyPath}
+	cleanup := func() {}
+	if kind == signatureKindGPG && isFile {
+		signer.gpgHome, cleanup, err = importGPGKey(keyPath)
+		if err != nil {
+			return nil, nil, err
+		}
+	}
+	return signer, cleanup, nil
+}
+
+func (s *artifactSigner) sign(path string) error {
+	sigPath := path + signatureExts[s.kind]
+	switch s.kind {
+	case signatureKindGPG:
+		args := []string{"--batch", "--yes"}
+		if s.gpgHome != "" {
+			args = append(args, "--homedir", s.gpgHome)
+		} else {
+			args = append(args, "--local-user", s.key)
+		}
+		args = append(args, "--armor", "--detach-sign", "--output", sigPath, path)
+		return runCommand("gpg", args...)
+	case signatureKindSigstore:
+		// the signature is created locally, nothing is uploaded to a
+		// transparency log
+		return runCommand("cosign", "sign-blob", "--yes", "--tlog-upload=false", "--key", s.key, "--output-signature", sigPath, path)
+	default:
+		return fmt.Errorf("unknown signature kind %q", s.kind)
+	}
+}
+
+// buildArtifacts returns the files that a build wrote for the image
+// with the given basename
+func buildArtifacts(imagePath, outputDir, basename string, opts *buildOptions) ([]string, error) {
+	artifacts := []string{imagePath}
+	if opts.WriteManifest {
+		artifacts = append(artifacts, filepath.Join(outputDir, fmt.Sprintf("%s.osbuild-manifest.json", basename)))
+	}
+	sboms, err := filepath.Glob(filepath.Join(outputDir, fmt.Sprintf("%s.*.spdx.json", basename)))
+	if err != nil {
+		return nil, err
+	}
+	artifacts = append(artifacts, sboms...)
+	if opts.WriteBuildlog {
+		artifacts = append(artifacts, filepath.Join(outputDir, fmt.Sprintf("%s.buildlog", basename)))
+	}
+	return artifacts, nil
+}
+
+// checksumAndSignArtifacts writes the checksums and signatures of the
+// given artifacts
+func checksumAndSignArtifacts(artifacts []string, algos []string, signer *artifactSigner) error {
+	for _, artifact := range artifacts {
+		for _, algo := range algos {
+			if err := writeChecksum(artifact, algo); err != nil {
+				return fmt.Errorf("cannot write checksum of %s: %w", artifact, err)
+			}
+		}
+		if signer != nil {
+			if err := signer.sign(artifact); err != nil {
+				return fmt.Errorf("cannot sign %s: %w", artifact, err)
+			}
+		}
+	}
+	return nil
+}
+
+// verifyChecksum checks a checksum file in the format of sha256sum(1)
+func verifyChecksum(dir, checksumName, algo string) (string, error) {
+	data, err := os.ReadFile(filepath.Join(dir, checksumName))
+	if err != nil {
+		return "", err
+	}
+	expected, name, ok := strings.Cut(strings.TrimSpace(string(data)), "  ")
+	if !ok || strings.Contains(name, "/") {
+		return "", fmt.Errorf("invalid checksum file %s", checksumName)
+	}
+	sum, err := checksumFile(filepath.Join(dir, name), algo)
+	if err != nil {
+		return name, err
+	}
+	if sum != expected {
+		return name, fmt.Errorf("%s checksum mismatch", algo)
+	}
+	return name, nil
+}
+
+func verifySignature(dir, sigName, kind, key, gpgHome string) (string, error) {
+	name := strings.TrimSuffix(sigName, signatureExts[kind])
+	sigPath := filepath.Join(dir, sigName)
+	path := filepath.Join(dir, name)
+	if _, err := os.Stat(path); err != nil {
+		return name, err
+	}
+	switch kind {
+	case signatureKindGPG:
+		args := []string{"--batch"}
+		if gpgHome != "" {
+			args = append(args, "--homedir", gpgHome)
+		}
+		args = append(args, "--verify", sigPath, path)
+		return name, runCommand("gpg", args...)
+	case signatureKindSigstore:
+		if key == "" {
+			return name, fmt.Errorf("cannot verify sigstore signature without --key")
+		}
+		return name, runCommand("cosign", "verify-blob", "--insecure-ignore-tlog=true", "--key", key, "--signature", sigPath, path)
+	}
+	return name, fmt.Errorf("unknown signature kind %q", kind)
+}
+
+func cmdVerify(cmd *cobra.Command, args []string) error {
+	dir := args[0]
+	key, err := cmd.Flags().GetString("key")
+	if err != nil {
+		return err
+	}
+	var gpgHome string
+	if key != "" {
+		kind, isFile, err := keyKind(key)
+		if err != nil {
+			return err
+		}
+		if kind == signatureKindGPG && isFile {
+			var cleanup func()
+			gpgHome, cleanup, err = importGPGKey(key)
+			if err != nil {
+				return err
+			}
+			defer cleanup()
+		}
+	}
+
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		return err
+	}
+	var names []string
+	for _, entry := range entries {
+		if entry.Type().IsRegular() {
+			names = append(names, entry.Name())
+		}
+	}
+	sort.Strings(names)
+
+	var verified, failed int
+	report := func(name, what string, err error) {
+		if err != nil {
+			failed++
+			fmt.Fprintf(osStdout, "%s: %s FAILED: %v\n", name, what, err)
+			return
+		}
+		verified++
+		fmt.Fprintf(osStdout, "%s: %s OK\n", name, what)
+	}
+	for _, name := range names {
+		for algo := range checksumAlgorithms {
+			if strings.HasSuffix(name, "."+algo) {
+				artifact, err := verifyChecksum(dir, name, algo)
+				if artifact == "" {
+					artifact = name
+				}
+				report(artifact, algo, err)
+			}
+		}
+		for kind, ext := range signatureExts {
+			if strings.HasSuffix(name, ext) {
+				artifact, err := verifySignature(dir, name, kind, key, gpgHome)
+				report(artifact, kind+" signature", err)
+			}
+		}
+	}
+
+	if failed > 0 {
+		return fmt.Errorf("verification of %d of %d checksums and signatures failed", failed, failed+verified)
+	}
+	if verified == 0 {
+		return fmt.Errorf("no checksums or signatures found in %s", dir)
+	}
+	return nil
+}
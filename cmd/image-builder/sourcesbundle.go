@@ -0,0 +1,848 @@
+package main
+
+import (
+	"archive/tar"
+	"bufio"
+	"bytes"
+	"crypto/sha256"
+	"crypto/sha512"
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"hash"
+	"io"
+	"io/fs"
+	"maps"
+	"net/http"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"slices"
+	"strings"
+
+	"github.com/osbuild/image-builder/internal/common"
+	"github.com/osbuild/image-builder/pkg/container"
+	"github.com/osbuild/image-builder/pkg/depsolvednf"
+	"github.com/osbuild/image-builder/pkg/distro"
+	"github.com/osbuild/image-builder/pkg/flatpak"
+	"github.com/osbuild/image-builder/pkg/hashutil"
+	"github.com/osbuild/image-builder/pkg/imagefilter"
+	"github.com/osbuild/image-builder/pkg/manifestgen"
+	"github.com/osbuild/image-builder/pkg/osbuild"
+	"github.com/osbuild/image-builder/pkg/ostree"
+	"github.com/osbuild/image-builder/pkg/progress"
+	"github.com/osbuild/image-builder/pkg/rpmmd"
+)
+
+// A sources bundle contains everything that needs to be downloaded
+// to build the images of its lockfile so that they can be built
+// without network access. The layout of the bundle is:
+//
+//	image-builder.lock    the lockfile of the images
+//	files/<checksum>      the rpms and the inline data
+//	containers/<id>.tar   the containers as oci-archives
+//	ostree/               an archive repository with the commits
+//	SHA256SUMS            the checksums of all of the above
+const (
+	bundleLockfile      = "image-builder.lock"
+	bundleChecksums     = "SHA256SUMS"
+	bundleFilesDir      = "files"
+	bundleContainersDir = "containers"
+	bundleOSTreeDir     = "ostree"
+)
+
+// isTarball returns true if the sources bundle at the given path is
+// (or should be written as) a tarball instead of a directory
+func isTarball(path string) bool {
+	return strings.HasSuffix(path, ".tar")
+}
+
+func newHash(checksumType string) (hash.Hash, error) {
+	switch checksumType {
+	case "sha256":
+		return sha256.New(), nil
+	case "sha384":
+		return sha512.New384(), nil
+	case "sha512":
+		return sha512.New(), nil
+	}
+	return nil, fmt.Errorf("unsupported checksum type %q", checksumType)
+}
+
+// verifyFile checks that the file at the given path has the given
+// checksum, e.g. "sha256:0123..."
+func verifyFile(path, checksum string) error {
+	checksumType, value, ok := strings.Cut(checksum, ":")
+	if !ok {
+		return fmt.Errorf("invalid checksum %q", checksum)
+	}
+	h, err := newHash(checksumType)
+	if err != nil {
+		return err
+	}
+	f, err := os.Open(path)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	if _, err := io.Copy(h, f); err != nil {
+		return err
+	}
+	if got := fmt.Sprintf("%x", h.Sum(nil)); got != value {
+		return fmt.Errorf("checksum mismatch for %s: expected %s, got %s:%s", path, checksum, checksumType, got)
+	}
+	return nil
+}
+
+// used in tests
+var httpClientForRepo = func(repo *rpmmd.RepoConfig) (*http.Client, error) {
+	transport := http.DefaultTransport.(*http.Transport).Clone()
+	tlsConf := &tls.Config{
+		MinVersion: tls.VersionTLS12,
+	}
+	if repo != nil {
+		if repo.SSLCACert != "" {
+			caCertPEM, err := os.ReadFile(repo.SSLCACert)
+			if err != nil {
+				return nil, fmt.Errorf("cannot read ca certificate of repository %q: %w", repo.Id, err)
+			}
+			tlsConf.RootCAs = x509.NewCertPool()
+			if ok := tlsConf.RootCAs.AppendCertsFromPEM(caCertPEM); !ok {
+				return nil, fmt.Errorf("cannot add ca certificate of repository %q", repo.Id)
+			}
+		}
+		if repo.SSLClientCert != "" && repo.SSLClientKey != "" {
+			cert, err := tls.LoadX509KeyPair(repo.SSLClientCert, repo.SSLClientKey)
+			if err != nil {
+				return nil, fmt.Errorf("cannot load client certificate of repository %q: %w", repo.Id, err)
+			}
+			tlsConf.Certificates = []tls.Certificate{cert}
+		}
+		tlsConf.InsecureSkipVerify = common.ValueOrEmpty(repo.IgnoreSSL) // #nosec G402
+	}
+	transport.TLSClientConfig = tlsConf
+	return &http.Client{Transport: transport}, nil
+}
+
+// downloadFile downloads the first working url into dst and verifies
+// its checksum, an existing dst with the right checksum is kept
+func downloadFile(client *http.Client, urls []string, dst, checksum string) error {
+	if err := verifyFile(dst, checksum); err == nil {
+		return nil
+	}
+	if len(urls) == 0 {
+		return fmt.Errorf("no url to download %s", checksum)
+	}
+
+	var errs []string
+	for _, url := range urls {
+		err := func() error {
+			resp, err := client.Get(url)
+			if err != nil {
+				return err
+			}
+			defer resp.Body.Close()
+			if resp.StatusCode != http.StatusOK {
+				return fmt.Errorf("unexpected status %s", resp.Status)
+			}
+			tmp := dst + ".tmp"
+			f, err := os.Create(tmp)
+			if err != nil {
+				return err
+			}
+			defer os.Remove(tmp)
+			if _, err := io.Copy(f, resp.Body); err != nil {
+				f.Close()
+				return err
+			}
+			if err := f.Close(); err != nil {
+				return err
+			}
+			if err := verifyFile(tmp, checksum); err != nil {
+				return err
+			}
+			return os.Rename(tmp, dst)
+		}()
+		if err == nil {
+			return nil
+		}
+		errs = append(errs, fmt.Sprintf("%s: %v", url, err))
+	}
+	return fmt.Errorf("cannot download %s: %s", checksum, strings.Join(errs, ", "))
+}
+
+// lockedPackagesOf returns all the packages of the lockfile together
+// with the repositories they come from, every package is only
+// returned once
+func lockedPackagesOf(lf *lockfile) (rpmmd.PackageList, map[string]*rpmmd.RepoConfig) {
+	var pkgs rpmmd.PackageList
+	repos := make(map[string]*rpmmd.RepoConfig)
+	seen := make(map[string]bool)
+	for _, key := range slices.Sorted(maps.Keys(lf.Images)) {
+		img := lf.Images[key]
+		for _, plName := range slices.Sorted(maps.Keys(img.Depsolved)) {
+			res := img.Depsolved[plName]
+			for i := range res.Repos {
+				repos[res.Repos[i].Id] = &res.Repos[i]
+			}
+			for _, pkg := range res.Transactions.AllPackages() {
+				if seen[pkg.Checksum.String()] {
+					continue
+				}
+				seen[pkg.Checksum.String()] = true
+				pkgs = append(pkgs, pkg)
+			}
+		}
+	}
+	return pkgs, repos
+}
+
+// lockedContainersOf returns all the containers of the lockfile
+// (including the flatpaks that are containers) once
+func lockedContainersOf(lf *lockfile) []container.Spec {
+	var specs []container.Spec
+	add := func(spec container.Spec) {
+		if !slices.ContainsFunc(specs, func(s container.Spec) bool { return s.ImageID == spec.ImageID }) {
+			specs = append(specs, spec)
+		}
+	}
+	for _, key := range slices.Sorted(maps.Keys(lf.Images)) {
+		img := lf.Images[key]
+		for _, plName := range slices.Sorted(maps.Keys(img.Containers)) {
+			for _, spec := range img.Containers[plName] {
+				add(spec)
+			}
+		}
+		for _, plName := range slices.Sorted(maps.Keys(img.Flatpaks)) {
+			for _, spec := range img.Flatpaks[plName] {
+				if spec.ContainerSpec != nil {
+					add(*spec.ContainerSpec)
+				}
+			}
+		}
+	}
+	return specs
+}
+
+// lockedCommitsOf returns all the ostree commits of the lockfile
+// (including the flatpaks that are commits) once
+func lockedCommitsOf(lf *lockfile) []ostree.CommitSpec {
+	var commits []ostree.CommitSpec
+	add := func(commit ostree.CommitSpec) {
+		if !slices.ContainsFunc(commits, func(c ostree.CommitSpec) bool { return c.Checksum == commit.Checksum }) {
+			commits = append(commits, commit)
+		}
+	}
+	for _, key := range slices.Sorted(maps.Keys(lf.Images)) {
+		img := lf.Images[key]
+		for _, plName := range slices.Sorted(maps.Keys(img.OSTreeCommits)) {
+			for _, commit := range img.OSTreeCommits[plName] {
+				add(commit)
+			}
+		}
+		for _, plName := range slices.Sorted(maps.Keys(img.Flatpaks)) {
+			for _, spec := range img.Flatpaks[plName] {
+				if spec.CommitSpec != nil {
+					add(*spec.CommitSpec)
+				}
+			}
+		}
+	}
+	return commits
+}
+
+// containerArchiveName returns the name of the oci-archive of the
+// container with the given image id, the "sha256:" prefix is dropped
+// as skopeo cannot handle a ":" in the path of an oci-archive
+func containerArchiveName(imageID string) string {
+	_, id, _ := strings.Cut(imageID, ":")
+	return id + ".tar"
+}
+
+func runCommand(name string, args ...string) error {
+	cmd := exec.Command(name, args...)
+	if output, err := cmd.CombinedOutput(); err != nil {
+		return fmt.Errorf("running %s failed: %w\n%s", strings.Join(cmd.Args, " "), err, output)
+	}
+	return nil
+}
+
+func fetchPackages(pbar progress.ProgressBar, dir string, lf *lockfile) error {
+	pkgs, repos := lockedPackagesOf(lf)
+	clients := make(map[string]*http.Client)
+	for i, pkg := range pkgs {
+		pbar.SetMessagef("Downloading package %s (%d/%d)", pkg.FullNEVRA(), i+1, len(pkgs))
+		client := clients[pkg.RepoID]
+		if client == nil {
+			var err error
+			client, err = httpClientForRepo(repos[pkg.RepoID])
+			if err != nil {
+				return err
+			}
+			clients[pkg.RepoID] = client
+		}
+		dst := filepath.Join(dir, bundleFilesDir, pkg.Checksum.String())
+		if err := downloadFile(client, pkg.RemoteLocations, dst, pkg.Checksum.String()); err != nil {
+			return fmt.Errorf("cannot fetch package %s: %w", pkg.FullNEVRA(), err)
+		}
+	}
+	return nil
+}
+
+// fetchInlineData writes the data of the inline sources of the given
+// manifests into the bundle
+func fetchInlineData(dir string, mfs [][]byte) error {
+	for _, mf := range mfs {
+		var raw struct {
+			Sources struct {
+				Inline osbuild.InlineSource `json:"org.osbuild.inline"`
+			} `json:"sources"`
+		}
+		if err := json.Unmarshal(mf, &raw); err != nil {
+			return fmt.Errorf("cannot parse sources of manifest: %w", err)
+		}
+		for checksum, item := range raw.Sources.Inline.Items {
+			if item.Encoding != "base64" {
+				return fmt.Errorf("unsupported encoding %q of inline source %s", item.Encoding, checksum)
+			}
+			data, err := base64.StdEncoding.DecodeString(item.Data)
+			if err != nil {
+				return fmt.Errorf("cannot decode inline source %s: %w", checksum, err)
+			}
+			dst := filepath.Join(dir, bundleFilesDir, checksum)
+			// #nosec: G306
+			if err := os.WriteFile(dst, data, 0644); err != nil {
+				return err
+			}
+			if err := verifyFile(dst, checksum); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+func fetchContainers(pbar progress.ProgressBar, dir string, lf *lockfile) error {
+	specs := lockedContainersOf(lf)
+	for i, spec := range specs {
+		pbar.SetMessagef("Downloading container %s (%d/%d)", spec.Source, i+1, len(specs))
+		dst := filepath.Join(dir, bundleContainersDir, containerArchiveName(spec.ImageID))
+		if _, err := os.Stat(dst); err == nil {
+			continue
+		}
+		src := fmt.Sprintf("docker://%s@%s", spec.Source, spec.Digest)
+		if spec.LocalStorage {
+			src = fmt.Sprintf("containers-storage:%s", spec.ImageID)
+		}
+		args := []string{"copy", "--quiet"}
+		if spec.TLSVerify != nil && !*spec.TLSVerify {
+			args = append(args, "--src-tls-verify=false")
+		}
+		tmp := dst + ".tmp"
+		if err := runCommand("skopeo", append(args, src, "oci-archive:"+tmp)...); err != nil {
+			os.Remove(tmp)
+			return fmt.Errorf("cannot fetch container %s: %w", spec.Source, err)
+		}
+		if err := os.Rename(tmp, dst); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func fetchCommits(pbar progress.ProgressBar, dir string, lf *lockfile) error {
+	commits := lockedCommitsOf(lf)
+	if len(commits) == 0 {
+		return nil
+	}
+	repo := filepath.Join(dir, bundleOSTreeDir)
+	if _, err := os.Stat(filepath.Join(repo, "config")); os.IsNotExist(err) {
+		if err := runCommand("ostree", "init", "--repo="+repo, "--mode=archive"); err != nil {
+			return err
+		}
+	}
+	remotes := make(map[string]string)
+	for i, commit := range commits {
+		pbar.SetMessagef("Downloading ostree commit %s (%d/%d)", commit.Checksum, i+1, len(commits))
+		if commit.Secrets != "" {
+			return fmt.Errorf("cannot fetch ostree commit %s: fetching commits with %q secrets is not supported", commit.Checksum, commit.Secrets)
+		}
+		remoteKey := commit.URL + " " + commit.ContentURL
+		remote, ok := remotes[remoteKey]
+		if !ok {
+			remote = fmt.Sprintf("remote%d", len(remotes))
+			args := []string{"remote", "add", "--repo=" + repo, "--if-not-exists", "--no-gpg-verify"}
+			if commit.ContentURL != "" {
+				args = append(args, "--set=contenturl="+commit.ContentURL)
+			}
+			if err := runCommand("ostree", append(args, remote, commit.URL)...); err != nil {
+				return err
+			}
+			remotes[remoteKey] = remote
+		}
+		if err := runCommand("ostree", "pull", "--repo="+repo, remote, commit.Checksum); err != nil {
+			return fmt.Errorf("cannot fetch ostree commit %s: %w", commit.Checksum, err)
+		}
+	}
+	return nil
+}
+
+// writeChecksums writes the SHA256SUMS of all files in the bundle
+func writeChecksums(dir string) error {
+	var buf bytes.Buffer
+	err := filepath.WalkDir(dir, func(path string, d fs.DirEntry, err error) error {
+		if err != nil || d.IsDir() {
+			return err
+		}
+		rel, err := filepath.Rel(dir, path)
+		if err != nil {
+			return err
+		}
+		if rel == bundleChecksums {
+			return nil
+		}
+		sum, err := hashutil.Sha256sum(path)
+		if err != nil {
+			return err
+		}
+		fmt.Fprintf(&buf, "%s  %s\n", sum, rel)
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+	// #nosec: G306
+	return os.WriteFile(filepath.Join(dir, bundleChecksums), buf.Bytes(), 0644)
+}
+
+// writeTarball writes the content of dir as a tarball to dst
+func writeTarball(dir, dst string) error {
+	f, err := os.Create(dst)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	tw := tar.NewWriter(f)
+	err = filepath.WalkDir(dir, func(path string, d fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+		rel, err := filepath.Rel(dir, path)
+		if err != nil || rel == "." {
+			return err
+		}
+		info, err := d.Info()
+		if err != nil {
+			return err
+		}
+		hdr, err := tar.FileInfoHeader(info, "")
+		if err != nil {
+			return err
+		}
+		hdr.Name = filepath.ToSlash(rel)
+		if err := tw.WriteHeader(hdr); err != nil {
+			return err
+		}
+		if !info.Mode().IsRegular() {
+			return nil
+		}
+		src, err := os.Open(path)
+		if err != nil {
+			return err
+		}
+		defer src.Close()
+		_, err = io.Copy(tw, src)
+		return err
+	})
+	if err != nil {
+		return err
+	}
+	if err := tw.Close(); err != nil {
+		return err
+	}
+	return f.Close()
+}
+
+// writeSourcesBundle downloads all the sources of the given lockfile
+// and manifests into the sources bundle at path
+func writeSourcesBundle(pbar progress.ProgressBar, path string, lf *lockfile, mfs [][]byte) error {
+	dir := path
+	if isTarball(path) {
+		tmpdir, err := os.MkdirTemp(filepath.Dir(path), ".sources-bundle-")
+		if err != nil {
+			return err
+		}
+		defer os.RemoveAll(tmpdir)
+		dir = tmpdir
+	}
+	for _, subdir := range []string{bundleFilesDir, bundleContainersDir} {
+		if err := os.MkdirAll(filepath.Join(dir, subdir), 0755); err != nil {
+			return err
+		}
+	}
+
+	if err := fetchPackages(pbar, dir, lf); err != nil {
+		return err
+	}
+	if err := fetchInlineData(dir, mfs); err != nil {
+		return err
+	}
+	if err := fetchContainers(pbar, dir, lf); err != nil {
+		return err
+	}
+	if err := fetchCommits(pbar, dir, lf); err != nil {
+		return err
+	}
+	if err := lf.write(filepath.Join(dir, bundleLockfile)); err != nil {
+		return err
+	}
+	if err := writeChecksums(dir); err != nil {
+		return err
+	}
+	if isTarball(path) {
+		return writeTarball(dir, path)
+	}
+	return nil
+}
+
+// sourcesBundle is a verified sources bundle that is used for a build
+type sourcesBundle struct {
+	dir string
+	lf  *lockfile
+
+	// the containers that are already in the local storage
+	loaded map[string]bool
+}
+
+// extractTarball extracts the sources bundle tarball into dir
+func extractTarball(path, dir string) error {
+	f, err := os.Open(path)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	tr := tar.NewReader(bufio.NewReader(f))
+	for {
+		hdr, err := tr.Next()
+		if err == io.EOF {
+			return nil
+		}
+		if err != nil {
+			return fmt.Errorf("cannot read sources bundle %s: %w", path, err)
+		}
+		name := filepath.Clean(filepath.FromSlash(hdr.Name))
+		if filepath.IsAbs(name) || name == ".." || strings.HasPrefix(name, ".."+string(filepath.Separator)) {
+			return fmt.Errorf("invalid path %q in sources bundle %s", hdr.Name, path)
+		}
+		dst := filepath.Join(dir, name)
+		switch hdr.Typeflag {
+		case tar.TypeDir:
+			if err := os.MkdirAll(dst, 0755); err != nil {
+				return err
+			}
+		case tar.TypeReg:
+			if err := os.MkdirAll(filepath.Dir(dst), 0755); err != nil {
+				return err
+			}
+			out, err := os.OpenFile(dst, os.O_CREATE|os.O_WRONLY|os.O_EXCL, 0644)
+			if err != nil {
+				return err
+			}
+			// #nosec G110
+			if _, err := io.Copy(out, tr); err != nil {
+				out.Close()
+				return err
+			}
+			if err := out.Close(); err != nil {
+				return err
+			}
+		default:
+			return fmt.Errorf("unsupported entry %q in sources bundle %s", hdr.Name, path)
+		}
+	}
+}
+
+// verify checks that the bundle contains exactly the files of its
+// SHA256SUMS with the right checksums
+func (b *sourcesBundle) verify() error {
+	f, err := os.Open(filepath.Join(b.dir, bundleChecksums))
+	if err != nil {
+		return fmt.Errorf("cannot open checksums of sources bundle: %w", err)
+	}
+	defer f.Close()
+
+	expected := make(map[string]string)
+	scanner := bufio.NewScanner(f)
+	for scanner.Scan() {
+		sum, name, ok := strings.Cut(scanner.Text(), "  ")
+		if !ok {
+			return fmt.Errorf("invalid line %q in checksums of sources bundle", scanner.Text())
+		}
+		expected[name] = sum
+	}
+	if err := scanner.Err(); err != nil {
+		return err
+	}
+
+	err = filepath.WalkDir(b.dir, func(path string, d fs.DirEntry, err error) error {
+		if err != nil || d.IsDir() {
+			return err
+		}
+		rel, err := filepath.Rel(b.dir, path)
+		if err != nil {
+			return err
+		}
+		if rel == bundleChecksums {
+			return nil
+		}
+		if !d.Type().IsRegular() {
+			return fmt.Errorf("sources bundle contains unsupported file %s", rel)
+		}
+		sum, ok := expected[rel]
+		if !ok {
+			return fmt.Errorf("sources bundle contains file %s that has no checksum", rel)
+		}
+		if err := verifyFile(path, "sha256:"+sum); err != nil {
+			return fmt.Errorf("sources bundle file %s is corrupted: %w", rel, err)
+		}
+		delete(expected, rel)
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+	if len(expected) > 0 {
+		return fmt.Errorf("sources bundle is incomplete, missing: %s", strings.Join(slices.Sorted(maps.Keys(expected)), ", "))
+	}
+	return nil
+}
+
+// openSourcesBundle opens and verifies the sources bundle at path,
+// tarballs are extracted into tmpDir. The returned cleanup function
+// must be called when the bundle is no longer needed.
+func openSourcesBundle(path, tmpDir string) (*sourcesBundle, func(), error) {
+	cleanup := func() {}
+	st, err := os.Stat(path)
+	if err != nil {
+		return nil, nil, fmt.Errorf("cannot open sources bundle: %w", err)
+	}
+	dir := path
+	if !st.IsDir() {
+		dir, err = os.MkdirTemp(tmpDir, "sources-bundle-")
+		if err != nil {
+			return nil, nil, err
+		}
+		cleanup = func() { os.RemoveAll(dir) }
+		if err := extractTarball(path, dir); err != nil {
+			cleanup()
+			return nil, nil, err
+		}
+	}
+	dir, err = filepath.Abs(dir)
+	if err != nil {
+		cleanup()
+		return nil, nil, err
+	}
+
+	b := &sourcesBundle{
+		dir:    dir,
+		loaded: make(map[string]bool),
+	}
+	if err := b.verify(); err != nil {
+		cleanup()
+		return nil, nil, err
+	}
+	b.lf, err = readLockfile(filepath.Join(dir, bundleLockfile))
+	if err != nil {
+		cleanup()
+		return nil, nil, err
+	}
+	b.lf.fromSourcesBundle = true
+	return b, cleanup, nil
+}
+
+func (b *sourcesBundle) fileURL(checksum string) string {
+	return "file://" + filepath.Join(b.dir, bundleFilesDir, checksum)
+}
+
+func (b *sourcesBundle) ostreeURL() string {
+	return "file://" + filepath.Join(b.dir, bundleOSTreeDir)
+}
+
+// loadContainer copies the container from the bundle into the local
+// containers storage so that osbuild can use it from there
+func (b *sourcesBundle) loadContainer(spec container.Spec) (container.Spec, error) {
+	if !b.loaded[spec.ImageID] {
+		archive := filepath.Join(b.dir, bundleContainersDir, containerArchiveName(spec.ImageID))
+		if _, err := os.Stat(archive); err != nil {
+			return spec, fmt.Errorf("container %s is not part of the sources bundle", spec.Source)
+		}
+		if err := runCommand("skopeo", "copy", "--quiet", "oci-archive:"+archive, "containers-storage:"+spec.Source); err != nil {
+			return spec, fmt.Errorf("cannot load container %s from the sources bundle: %w", spec.Source, err)
+		}
+		b.loaded[spec.ImageID] = true
+	}
+	spec.LocalStorage = true
+	spec.ListDigest = ""
+	return spec, nil
+}
+
+func (b *sourcesBundle) bundledCommit(commit ostree.CommitSpec) ostree.CommitSpec {
+	commit.URL = b.ostreeURL()
+	commit.ContentURL = ""
+	commit.Secrets = ""
+	return commit
+}
+
+// apply makes the given options use the lockfile of the bundle and
+// points all the sources to the bundle so that nothing gets
+// downloaded, currentImg returns the image that is currently
+// generated
+func (b *sourcesBundle) apply(mgOptions *manifestgen.Options, currentImg func() *imagefilter.Result) {
+	b.lf.apply(mgOptions, currentImg)
+	// curl can fetch the file:// urls of the bundle
+	mgOptions.RpmDownloader = osbuild.RpmDownloaderCurl
+
+	depsolve := mgOptions.Depsolve
+	mgOptions.Depsolve = func(solver *depsolvednf.Solver, cacheDir string, depsolveWarningsOutput io.Writer, packageSets map[string][]rpmmd.PackageSet, d distro.Distro, arch string) (map[string]depsolvednf.DepsolveResult, error) {
+		res, err := depsolve(solver, cacheDir, depsolveWarningsOutput, packageSets, d, arch)
+		if err != nil {
+			return nil, err
+		}
+		bundled := make(map[string]depsolvednf.DepsolveResult, len(res))
+		for plName, dr := range res {
+			transactions := make(depsolvednf.TransactionList, len(dr.Transactions))
+			for i, pkgs := range dr.Transactions {
+				transactions[i] = make(rpmmd.PackageList, len(pkgs))
+				for j, pkg := range pkgs {
+					pkg.RemoteLocations = []string{b.fileURL(pkg.Checksum.String())}
+					pkg.Secrets = ""
+					pkg.IgnoreSSL = false
+					transactions[i][j] = pkg
+				}
+			}
+			dr.Transactions = transactions
+			bundled[plName] = dr
+		}
+		return bundled, nil
+	}
+
+	containerResolver := mgOptions.ContainerResolver
+	mgOptions.ContainerResolver = func(containerSources map[string][]container.SourceSpec, archName string) (map[string][]container.Spec, error) {
+		res, err := containerResolver(containerSources, archName)
+		if err != nil {
+			return nil, err
+		}
+		bundled := make(map[string][]container.Spec, len(res))
+		for plName, specs := range res {
+			for _, spec := range specs {
+				spec, err := b.loadContainer(spec)
+				if err != nil {
+					return nil, err
+				}
+				bundled[plName] = append(bundled[plName], spec)
+			}
+		}
+		return bundled, nil
+	}
+
+	commitResolver := mgOptions.CommitResolver
+	mgOptions.CommitResolver = func(commitSources map[string][]ostree.SourceSpec) (map[string][]ostree.CommitSpec, error) {
+		res, err := commitResolver(commitSources)
+		if err != nil {
+			return nil, err
+		}
+		bundled := make(map[string][]ostree.CommitSpec, len(res))
+		for plName, commits := range res {
+			for _, commit := range commits {
+				bundled[plName] = append(bundled[plName], b.bundledCommit(commit))
+			}
+		}
+		return bundled, nil
+	}
+
+	flatpakResolver := mgOptions.FlatpakResolver
+	mgOptions.FlatpakResolver = func(flatpakSources map[string][]flatpak.SourceSpec) (map[string][]flatpak.Spec, error) {
+		res, err := flatpakResolver(flatpakSources)
+		if err != nil {
+			return nil, err
+		}
+		bundled := make(map[string][]flatpak.Spec, len(res))
+		for plName, specs := range res {
+			for _, spec := range specs {
+				if spec.ContainerSpec != nil {
+					cs, err := b.loadContainer(*spec.ContainerSpec)
+					if err != nil {
+						return nil, err
+					}
+					spec.ContainerSpec = &cs
+				}
+				if spec.CommitSpec != nil {
+					commit := b.bundledCommit(*spec.CommitSpec)
+					spec.CommitSpec = &commit
+				}
+				bundled[plName] = append(bundled[plName], spec)
+			}
+		}
+		return bundled, nil
+	}
+}
+
+// checkManifest ensures that the given manifest only uses sources
+// from the bundle (or local files of the blueprint)
+func (b *sourcesBundle) checkManifest(key string, mf []byte) error {
+	var raw struct {
+		Sources map[string]json.RawMessage `json:"sources"`
+	}
+	if err := json.Unmarshal(mf, &raw); err != nil {
+		return fmt.Errorf("cannot parse sources of manifest: %w", err)
+	}
+	for name, rawSource := range raw.Sources {
+		switch name {
+		case osbuild.SourceNameCurl:
+			var curl osbuild.CurlSource
+			if err := json.Unmarshal(rawSource, &curl); err != nil {
+				return fmt.Errorf("cannot parse %s source of manifest: %w", name, err)
+			}
+			for checksum, item := range curl.Items {
+				var url string
+				switch item := item.(type) {
+				case osbuild.URL:
+					url = string(item)
+				case osbuild.CurlSourceOptions:
+					url = item.URL
+				}
+				if !strings.HasPrefix(url, "file:") {
+					return fmt.Errorf("cannot build %q from the sources bundle: %s needs to be downloaded from %s", key, checksum, url)
+				}
+			}
+		case osbuild.SourceNameInline:
+			var inline osbuild.InlineSource
+			if err := json.Unmarshal(rawSource, &inline); err != nil {
+				return fmt.Errorf("cannot parse %s source of manifest: %w", name, err)
+			}
+			for checksum := range inline.Items {
+				if _, err := os.Stat(filepath.Join(b.dir, bundleFilesDir, checksum)); err != nil {
+					return fmt.Errorf("inline data %s of %q is not part of the sources bundle, %s", checksum, key, b.lf.rerunHint())
+				}
+			}
+		case osbuild.SourceNameOstree:
+			var ostreeSource osbuild.OSTreeSource
+			if err := json.Unmarshal(rawSource, &ostreeSource); err != nil {
+				return fmt.Errorf("cannot parse %s source of manifest: %w", name, err)
+			}
+			for checksum, item := range ostreeSource.Items {
+				if item.Remote.URL != b.ostreeURL() {
+					return fmt.Errorf("cannot build %q from the sources bundle: ostree commit %s needs to be downloaded from %s", key, checksum, item.Remote.URL)
+				}
+			}
+		case osbuild.SourceNameContainersStorage:
+			// loaded from the bundle
+		default:
+			return fmt.Errorf("cannot build %q from the sources bundle: unsupported source %s", key, name)
+		}
+	}
+	return nil
+}
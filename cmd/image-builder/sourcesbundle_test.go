@@ -0,0 +1,244 @@
+package main_test
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	main "github.com/osbuild/image-builder/cmd/image-builder"
+	"github.com/osbuild/image-builder/internal/testutil"
+	"github.com/osbuild/image-builder/pkg/depsolvednf"
+	"github.com/osbuild/image-builder/pkg/distro"
+	"github.com/osbuild/image-builder/pkg/manifestgen"
+	"github.com/osbuild/image-builder/pkg/rpmmd"
+	testrepos "github.com/osbuild/image-builder/test/data/repositories"
+)
+
+var sourcesBundleBlueprint = testBlueprint + `
+[[customizations.files]]
+path = "/etc/motd"
+data = "hello offline world"
+`
+
+// makeServedDepsolve returns a depsolver that resolves to the given
+// packages which can be downloaded from a test server
+func makeServedDepsolve(t *testing.T, nevras ...string) manifestgen.DepsolveFunc {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		fmt.Fprintf(w, "rpm-content-of-%s", strings.TrimPrefix(r.URL.Path, "/"))
+	}))
+	t.Cleanup(srv.Close)
+
+	depsolve := func(solver *depsolvednf.Solver, cacheDir string, depsolveWarningsOutput io.Writer, packageSets map[string][]rpmmd.PackageSet, d distro.Distro, arch string) (map[string]depsolvednf.DepsolveResult, error) {
+		res := make(map[string]depsolvednf.DepsolveResult)
+		for plName, sets := range packageSets {
+			repo := sets[0].Repositories[0]
+			var pkgs rpmmd.PackageList
+			for _, nevra := range nevras {
+				var pkg rpmmd.Package
+				if _, err := fmt.Sscanf(strings.ReplaceAll(nevra, "|", " "), "%s %s %s %s", &pkg.Name, &pkg.Version, &pkg.Release, &pkg.Arch); err != nil {
+					return nil, err
+				}
+				filename := fmt.Sprintf("%s-%s-%s.%s.rpm", pkg.Name, pkg.Version, pkg.Release, pkg.Arch)
+				pkg.Location = "Packages/" + filename
+				pkg.RemoteLocations = []string{srv.URL + "/" + filename}
+				pkg.Checksum = rpmmd.Checksum{Type: "sha256", Value: testutil.SHA256For("rpm-content-of-" + filename)}
+				pkg.RepoID = repo.Id
+				pkg.Repo = &repo
+				pkgs = append(pkgs, pkg)
+			}
+			res[plName] = depsolvednf.DepsolveResult{
+				Transactions: depsolvednf.TransactionList{pkgs},
+				Repos:        []rpmmd.RepoConfig{repo},
+			}
+		}
+		return res, nil
+	}
+	return depsolve
+}
+
+// fakeSkopeoScript writes a fake oci-archive when a container is
+// copied into one
+const fakeSkopeoScript = `
+for arg in "$@"; do last="$arg"; done
+case "$last" in
+  oci-archive:*)
+    echo "fake-oci-archive" > "${last#oci-archive:}"
+    ;;
+esac
+`
+
+func fetchForTest(t *testing.T, bundlePath string, args ...string) {
+	depsolve := makeServedDepsolve(t, "kernel|5.14.0|1.el9|x86_64", "bash|5.1|2.el9|x86_64", "tmux|3.2a|4.el9|x86_64")
+	restore := main.MockManifestgenDepsolver(depsolve)
+	defer restore()
+
+	restore = main.MockOsArgs(append([]string{"fetch", "qcow2", "--distro", "centos-9", "--sources-bundle", bundlePath}, args...))
+	defer restore()
+
+	err := main.Run()
+	require.NoError(t, err)
+}
+
+func TestFetchAndBuildFromSourcesBundle(t *testing.T) {
+	restore := main.MockManifestgenContainerResolver(fakeContainerResolver)
+	defer restore()
+	restore = main.MockNewRepoRegistry(testrepos.New)
+	defer restore()
+
+	for _, name := range []string{"bundle", "bundle.tar"} {
+		t.Run(name, func(t *testing.T) {
+			fakeSkopeoCmd := testutil.MockCommand(t, "skopeo", fakeSkopeoScript)
+			tmpdir := t.TempDir()
+			bundlePath := filepath.Join(tmpdir, name)
+			bpPath := makeTestBlueprint(t, sourcesBundleBlueprint)
+			fetchForTest(t, bundlePath, "--blueprint", bpPath)
+
+			if name == "bundle" {
+				checksums, err := os.ReadFile(filepath.Join(bundlePath, "SHA256SUMS"))
+				require.NoError(t, err)
+				for _, expected := range []string{
+					testutil.SHA256For("rpm-content-of-bash-5.1-2.el9.x86_64.rpm") + "  files/sha256:" + testutil.SHA256For("rpm-content-of-bash-5.1-2.el9.x86_64.rpm") + "\n",
+					"  files/sha256:" + testutil.SHA256For("rpm-content-of-tmux-3.2a-4.el9.x86_64.rpm") + "\n",
+					"  files/sha256:" + testutil.SHA256For("hello offline world") + "\n",
+					"  containers/" + testutil.SHA256For("id:registry.gitlab.com/redhat/services/products/image-builder/ci/osbuild-composer/fedora-minimal") + ".tar\n",
+					"  image-builder.lock\n",
+				} {
+					assert.Contains(t, string(checksums), expected)
+				}
+			} else {
+				st, err := os.Stat(bundlePath)
+				require.NoError(t, err)
+				assert.True(t, st.Mode().IsRegular())
+			}
+			require.Len(t, fakeSkopeoCmd.CallArgsList(), 1)
+			assert.Equal(t, "docker://resolved-cnt-registry.gitlab.com/redhat/services/products/image-builder/ci/osbuild-composer/fedora-minimal@sha256:"+testutil.SHA256For("digest:registry.gitlab.com/redhat/services/products/image-builder/ci/osbuild-composer/fedora-minimal"), fakeSkopeoCmd.CallArgsList()[0][2])
+
+			// nothing is depsolved or downloaded when building
+			// from the bundle
+			restore := main.MockManifestgenDepsolver(failingDepsolve)
+			defer restore()
+			restore = main.MockManifestgenContainerResolver(nil)
+			defer restore()
+			fakeOsbuildCmd := testutil.MockCommand(t, "osbuild", makeFakeOsbuildScript())
+			restore = main.MockOsArgs([]string{
+				"build", "qcow2",
+				"--distro", "centos-9",
+				"--blueprint", bpPath,
+				"--sources-bundle", bundlePath,
+				"--cache", filepath.Join(tmpdir, "cache"),
+				"--output-dir", filepath.Join(tmpdir, "output"),
+			})
+			defer restore()
+			err := main.Run()
+			require.NoError(t, err)
+
+			// the container got loaded into the local storage
+			require.Len(t, fakeSkopeoCmd.CallArgsList(), 2)
+			loadCall := fakeSkopeoCmd.CallArgsList()[1]
+			assert.Equal(t, "containers-storage:resolved-cnt-registry.gitlab.com/redhat/services/products/image-builder/ci/osbuild-composer/fedora-minimal", loadCall[len(loadCall)-1])
+
+			manifest, err := os.ReadFile(fakeOsbuildCmd.Path() + ".stdin")
+			require.NoError(t, err)
+			var mf struct {
+				Sources map[string]struct {
+					Items map[string]json.RawMessage `json:"items"`
+				} `json:"sources"`
+			}
+			require.NoError(t, json.Unmarshal(manifest, &mf))
+			assert.NotContains(t, mf.Sources, "org.osbuild.skopeo")
+			assert.Contains(t, mf.Sources, "org.osbuild.containers-storage")
+			assert.Contains(t, mf.Sources, "org.osbuild.inline")
+			bashChecksum := "sha256:" + testutil.SHA256For("rpm-content-of-bash-5.1-2.el9.x86_64.rpm")
+			var bashItem struct {
+				URL string `json:"url"`
+			}
+			require.NoError(t, json.Unmarshal(mf.Sources["org.osbuild.curl"].Items[bashChecksum], &bashItem))
+			assert.Regexp(t, `^file:///.*/files/`+bashChecksum+`$`, bashItem.URL)
+		})
+	}
+}
+
+func TestBuildSourcesBundleVerifyErrors(t *testing.T) {
+	restore := main.MockManifestgenContainerResolver(fakeContainerResolver)
+	defer restore()
+	restore = main.MockNewRepoRegistry(testrepos.New)
+	defer restore()
+	testutil.MockCommand(t, "skopeo", fakeSkopeoScript)
+
+	pkgPath := "files/sha256:" + testutil.SHA256For("rpm-content-of-bash-5.1-2.el9.x86_64.rpm")
+	for _, tc := range []struct {
+		name        string
+		modify      func(bundlePath string) error
+		expectedErr string
+	}{
+		{
+			name: "corrupted",
+			modify: func(bundlePath string) error {
+				return os.WriteFile(filepath.Join(bundlePath, pkgPath), []byte("evil"), 0644)
+			},
+			expectedErr: fmt.Sprintf("sources bundle file %s is corrupted: checksum mismatch for ", pkgPath),
+		},
+		{
+			name: "missing",
+			modify: func(bundlePath string) error {
+				return os.Remove(filepath.Join(bundlePath, pkgPath))
+			},
+			expectedErr: "sources bundle is incomplete, missing: " + pkgPath,
+		},
+		{
+			name: "unlisted",
+			modify: func(bundlePath string) error {
+				return os.WriteFile(filepath.Join(bundlePath, "files", "extra"), []byte("extra"), 0644)
+			},
+			expectedErr: "sources bundle contains file files/extra that has no checksum",
+		},
+		{
+			name: "other-blueprint",
+			modify: func(bundlePath string) error {
+				return nil
+			},
+			expectedErr: `is out of date (the package sets changed), re-run "image-builder fetch"`,
+		},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			tmpdir := t.TempDir()
+			bundlePath := filepath.Join(tmpdir, "bundle")
+			fetchForTest(t, bundlePath, "--blueprint", makeTestBlueprint(t, sourcesBundleBlueprint))
+			require.NoError(t, tc.modify(bundlePath))
+
+			restore := main.MockManifestgenDepsolver(failingDepsolve)
+			defer restore()
+			fakeOsbuildCmd := testutil.MockCommand(t, "osbuild", makeFakeOsbuildScript())
+			restore = main.MockOsArgs([]string{
+				"build", "qcow2",
+				"--distro", "centos-9",
+				"--blueprint", makeTestBlueprint(t, "packages = [{name = \"tmux\"}]\n"),
+				"--sources-bundle", bundlePath,
+				"--cache", filepath.Join(tmpdir, "cache"),
+				"--output-dir", filepath.Join(tmpdir, "output"),
+			})
+			defer restore()
+			err := main.Run()
+			require.Error(t, err)
+			assert.Contains(t, err.Error(), tc.expectedErr)
+			assert.Len(t, fakeOsbuildCmd.CallArgsList(), 0)
+		})
+	}
+}
+
+func TestBuildSourcesBundleWithLockfile(t *testing.T) {
+	restore := main.MockOsArgs([]string{"build", "qcow2", "--distro", "centos-9", "--sources-bundle", "bundle", "--lockfile", "image-builder.lock"})
+	defer restore()
+
+	err := main.Run()
+	assert.EqualError(t, err, "cannot use --lockfile with --sources-bundle, the sources bundle contains its own lockfile")
+}
@@ -0,0 +1,82 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"io"
+	"os"
+	"os/exec"
+	"regexp"
+	"strings"
+	"sync"
+)
+
+// subcommandExe returns the executable that runs the builds of a plan
+// and the jobs of the server
+var subcommandExe = os.Executable
+
+// subcommandErrorRe matches the error that main() logs before a
+// failed command exits
+var subcommandErrorRe = regexp.MustCompile(`(?m)^error: `)
+
+// maxSubcommandErrorSize is the amount of stderr output that is kept
+// to find the error of a failed command
+const maxSubcommandErrorSize = 64 * 1024
+
+// tailWriter keeps the last max bytes that are written to it
+type tailWriter struct {
+	mu  sync.Mutex
+	max int
+	buf []byte
+}
+
+func (tw *tailWriter) Write(p []byte) (int, error) {
+	tw.mu.Lock()
+	defer tw.mu.Unlock()
+
+	tw.buf = append(tw.buf, p...)
+	if len(tw.buf) > tw.max {
+		tw.buf = tw.buf[len(tw.buf)-tw.max:]
+	}
+	return len(p), nil
+}
+
+func (tw *tailWriter) String() string {
+	tw.mu.Lock()
+	defer tw.mu.Unlock()
+
+	return string(tw.buf)
+}
+
+// subcommandError returns the error that a failed command logged to
+// stderr or "" if there is none
+func subcommandError(stderr string) string {
+	locs := subcommandErrorRe.FindAllStringIndex(stderr, -1)
+	if len(locs) == 0 {
+		return ""
+	}
+	return strings.TrimSpace(stderr[locs[len(locs)-1][1]:])
+}
+
+// runSubcommand runs image-builder with the given arguments in a new
+// process. Commands like "build" use process wide state (e.g. the
+// signal handlers and the progress on the terminal) so they cannot
+// run concurrently in this process. The process is killed when the
+// context is done.
+func runSubcommand(ctx context.Context, args []string, stdout, stderr io.Writer) error {
+	exe, err := subcommandExe()
+	if err != nil {
+		return err
+	}
+	errTail := &tailWriter{max: maxSubcommandErrorSize}
+	cmd := exec.CommandContext(ctx, exe, args...)
+	cmd.Stdout = stdout
+	cmd.Stderr = io.MultiWriter(stderr, errTail)
+	if err := cmd.Run(); err != nil {
+		if msg := subcommandError(errTail.String()); msg != "" {
+			return errors.New(msg)
+		}
+		return err
+	}
+	return nil
+}
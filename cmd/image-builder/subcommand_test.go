@@ -0,0 +1,109 @@
+package main_test
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+
+	main "github.com/osbuild/image-builder/cmd/image-builder"
+	"github.com/osbuild/image-builder/pkg/cloud"
+	"github.com/osbuild/image-builder/pkg/cloud/awscloud"
+	testrepos "github.com/osbuild/image-builder/test/data/repositories"
+)
+
+// the builds of a plan and the jobs of the server run image-builder in
+// a subprocess, in the tests this is the test binary that runs
+// main.Run() with the usual mocks when this environment is set
+const testSubcommandEnv = "IMAGE_BUILDER_TEST_SUBCOMMAND"
+
+// testSubcommandS3DirEnv makes the s3 uploads of the subprocesses
+// write the uploader options and the uploaded data into a directory
+const testSubcommandS3DirEnv = "IMAGE_BUILDER_TEST_SUBCOMMAND_S3_DIR"
+
+func TestMain(m *testing.M) {
+	if os.Getenv(testSubcommandEnv) != "" {
+		os.Exit(runTestSubcommand())
+	}
+	os.Exit(m.Run())
+}
+
+func runTestSubcommand() int {
+	main.MockManifestgenDepsolver(fakeDepsolve)
+	main.MockManifestgenContainerResolver(fakeContainerResolver)
+	main.MockNewRepoRegistry(testrepos.New)
+	if dir := os.Getenv(testSubcommandS3DirEnv); dir != "" {
+		main.MockS3NewUploader(func(bucket, key string, opts *awscloud.S3UploaderOptions) (cloud.Uploader, error) {
+			data, err := json.Marshal(opts)
+			if err != nil {
+				return nil, err
+			}
+			if err := os.WriteFile(filepath.Join(dir, "s3-options.json"), data, 0o644); err != nil {
+				return nil, err
+			}
+			return &fileS3Uploader{dir: dir}, nil
+		})
+	}
+
+	if err := main.Run(); err != nil {
+		fmt.Fprintf(os.Stderr, "error: %s\n", err)
+		return 1
+	}
+	return 0
+}
+
+// mockSubcommand makes the subprocesses of the test run main.Run()
+func mockSubcommand(t *testing.T) {
+	t.Setenv(testSubcommandEnv, "1")
+}
+
+// mockSubcommandS3 makes the s3 uploads of the subprocesses of the
+// test write into the returned directory
+func mockSubcommandS3(t *testing.T) string {
+	dir := t.TempDir()
+	t.Setenv(testSubcommandS3DirEnv, dir)
+	return dir
+}
+
+// fileS3Uploader is the s3 uploader of the subprocesses, it writes the
+// uploaded data into the s3-data file of its directory
+type fileS3Uploader struct {
+	dir string
+}
+
+var _ = cloud.Uploader(&fileS3Uploader{})
+
+func (fu *fileS3Uploader) Check(status io.Writer) error {
+	return nil
+}
+
+func (fu *fileS3Uploader) UploadAndRegister(r io.Reader, size uint64, status io.Writer) (*cloud.UploadResult, error) {
+	f, err := os.Create(filepath.Join(fu.dir, "s3-data"))
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	if _, err := io.Copy(f, r); err != nil {
+		return nil, err
+	}
+	return &cloud.UploadResult{Provider: "aws"}, nil
+}
+
+func TestSubcommandError(t *testing.T) {
+	for _, tc := range []struct {
+		stderr   string
+		expected string
+	}{
+		{"", ""},
+		{"some progress\n", ""},
+		{"some progress\nerror: build failed\n", "build failed"},
+		{"error: first\nmore progress\nerror: cannot upload:\nfirst target\nsecond target\n", "cannot upload:\nfirst target\nsecond target"},
+		{"no error: at the start of the line\n", ""},
+	} {
+		assert.Equal(t, tc.expected, main.SubcommandError(tc.stderr), tc.stderr)
+	}
+}
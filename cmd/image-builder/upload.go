@@ -13,16 +13,23 @@ import (
 	"github.com/spf13/cobra"
 	"go.yaml.in/yaml/v3"
 
+	"github.com/osbuild/image-builder/internal/blueprintload"
 	"github.com/osbuild/image-builder/internal/common"
 	"github.com/osbuild/image-builder/pkg/arch"
 	"github.com/osbuild/image-builder/pkg/cloud"
 	"github.com/osbuild/image-builder/pkg/cloud/awscloud"
 	"github.com/osbuild/image-builder/pkg/cloud/azure"
+	"github.com/osbuild/image-builder/pkg/cloud/gcp"
 	"github.com/osbuild/image-builder/pkg/cloud/ibmcloud"
 	"github.com/osbuild/image-builder/pkg/cloud/libvirt"
 	"github.com/osbuild/image-builder/pkg/cloud/openstack"
+	"github.com/osbuild/image-builder/pkg/container"
+	"github.com/osbuild/image-builder/pkg/datasizes"
 	"github.com/osbuild/image-builder/pkg/platform"
 	"github.com/osbuild/image-builder/pkg/progress"
+	"github.com/osbuild/image-builder/pkg/upload/koji"
+	"github.com/osbuild/image-builder/pkg/upload/oci"
+	"github.com/osbuild/image-builder/pkg/upload/vmware"
 )
 
 // ErrMissingUploadConfig is returned when the upload configuration is missing
@@ -41,26 +48,24 @@ var (
 	libvirtNewUploader   = libvirt.NewUploader
 	openstackNewUploader = openstack.NewUploader
 	ibmNewUploader       = ibmcloud.NewUploader
+	gcpNewUploader       = gcp.NewUploader
+	ociNewUploader       = oci.NewUploader
+	vmwareNewUploader    = vmware.NewUploader
+	s3NewUploader        = awscloud.NewS3Uploader
+	registryNewUploader  = container.NewUploader
+	kojiNewUploader      = koji.NewUploader
 )
 
 func uploadImageWithProgress(uploader cloud.Uploader, pbar progress.ProgressBar, imagePath string) (*cloud.UploadResult, error) {
-	f, err := os.Open(imagePath)
+	f, sizei, err := openImageForUpload(imagePath)
 	if err != nil {
 		return nil, err
 	}
 	defer f.Close()
 
 	// setup basic progress
-	st, err := f.Stat()
-	if err != nil {
-		return nil, fmt.Errorf("cannot stat upload: %v", err)
-	}
-	sizei64 := st.Size()
-	if sizei64 < 0 {
-		return nil, fmt.Errorf("invalid size read for %s: %d", imagePath, sizei64)
-	}
-	sizei := int(sizei64)
-	size := uint64(sizei64)
+	// #nosec G115
+	size := uint64(sizei)
 	r, err := progress.NewProxyReader(f, sizei, pbar)
 	if err != nil {
 		return nil, fmt.Errorf("failed to create proxy reader: %w", err)
@@ -68,7 +73,7 @@ func uploadImageWithProgress(uploader cloud.Uploader, pbar progress.ProgressBar,
 	pbar.Start()
 	pbar.SetPulseMsgf("Uploading step")
 
-	return uploader.UploadAndRegister(r, size, osStderr)
+	return cloud.UploadAndRegister(uploader, r, size, osStderr)
 }
 
 func uploaderCheckWithProgress(pbar progress.ProgressBar, uploader cloud.Uploader) error {
@@ -84,7 +89,11 @@ func uploaderCheckWithProgress(pbar progress.ProgressBar, uploader cloud.Uploade
 	return uploader.Check(pw)
 }
 
-func uploaderFor(cmd *cobra.Command, typeOrCloud string, targetArch string, bootMode *platform.BootMode, imagePath string) (cloud.Uploader, error) {
+func uploaderFor(cmd *cobra.Command, typeOrCloud string, distroName string, targetArch string, bootMode *platform.BootMode, imagePath string) (cloud.Uploader, error) {
+	if target, ok := strings.CutPrefix(typeOrCloud, "registry://"); ok {
+		return uploaderForCmdRegistry(cmd, target, targetArch, imagePath)
+	}
+
 	switch typeOrCloud {
 	case "ami", "generic-ami", "aws":
 		return uploaderForCmdAWS(cmd, targetArch, bootMode)
@@ -96,6 +105,22 @@ func uploaderFor(cmd *cobra.Command, typeOrCloud string, targetArch string, boot
 		return uploaderForCmdIbmCloud(cmd, targetArch, bootMode)
 	case "azure":
 		return uploaderForCmdAzure(cmd, targetArch, bootMode, imagePath)
+	case "gce", "gcp":
+		return uploaderForCmdGCP(cmd, distroName)
+	case "oci":
+		return uploaderForCmdOCI(cmd)
+	case "vmdk", "ova":
+		return uploaderForCmdVSphere(cmd, typeOrCloud)
+	case "vsphere":
+		format := vmware.FormatVMDK
+		if strings.HasSuffix(imagePath, ".ova") {
+			format = vmware.FormatOVA
+		}
+		return uploaderForCmdVSphere(cmd, format)
+	case "s3":
+		return uploaderForCmdS3(cmd, imagePath)
+	case "koji":
+		return uploaderForCmdKoji(cmd, targetArch, bootMode, imagePath)
 	default:
 		return nil, fmt.Errorf("%w: %q", ErrUploadTypeUnsupported, typeOrCloud)
 	}
@@ -134,6 +159,14 @@ func uploaderForCmdAWS(cmd *cobra.Command, targetArchStr string, bootMode *platf
 			Value: parts[1],
 		})
 	}
+	copyToRegions, err := cmd.Flags().GetStringArray("aws-copy-to-region")
+	if err != nil {
+		return nil, err
+	}
+	shareWith, err := cmd.Flags().GetStringArray("aws-share-with")
+	if err != nil {
+		return nil, err
+	}
 	if bootMode == nil {
 		// If unset, default to BOOT_HYBIRD which translated
 		// to "uefi-prefered" when registering the image.
@@ -173,6 +206,12 @@ func uploaderForCmdAWS(cmd *cobra.Command, targetArchStr string, bootMode *platf
 		Tags:       slicedTags,
 		Profile:    profile,
 	}
+	if len(copyToRegions) > 0 {
+		opts.CopyToRegions = copyToRegions
+	}
+	if len(shareWith) > 0 {
+		opts.ShareWith = shareWith
+	}
 
 	return awscloudNewUploader(region, bucketName, amiName, opts)
 }
@@ -270,9 +309,33 @@ func uploaderForCmdAzure(cmd *cobra.Command, targetArchStr string, bootMode *pla
 	if err != nil {
 		return nil, err
 	}
+	gallery, err := cmd.Flags().GetString("azure-gallery")
+	if err != nil {
+		return nil, err
+	}
+	galleryImageDef, err := cmd.Flags().GetString("azure-gallery-image-definition")
+	if err != nil {
+		return nil, err
+	}
+	galleryVersion, err := cmd.Flags().GetString("azure-gallery-version")
+	if err != nil {
+		return nil, err
+	}
+	galleryRegions, err := cmd.Flags().GetStringArray("azure-gallery-region")
+	if err != nil {
+		return nil, err
+	}
 
 	var missing []string
 	requiredArgs := []string{"azure-client-id", "azure-client-secret", "azure-tenant", "azure-subscription", "azure-resource-group", "azure-image-name"}
+	if gallery != "" || galleryImageDef != "" || galleryVersion != "" {
+		// the image name is only used for the uploaded blob when
+		// publishing into a gallery
+		if imageName == "" {
+			imageName = fmt.Sprintf("%s-%s", galleryImageDef, galleryVersion)
+		}
+		requiredArgs = []string{"azure-client-id", "azure-client-secret", "azure-tenant", "azure-subscription", "azure-resource-group", "azure-gallery", "azure-gallery-image-definition", "azure-gallery-version"}
+	}
 	for _, argName := range requiredArgs {
 		arg, err := cmd.Flags().GetString(argName)
 		if err != nil {
@@ -294,7 +357,393 @@ func uploaderForCmdAzure(cmd *cobra.Command, targetArchStr string, bootMode *pla
 		return nil, err
 	}
 
-	return azureNewUploader(clientID, clientSecret, tenant, subscription, resourceGroup, imageName, imagePath, targetArch)
+	opts := &azure.UploaderOptions{
+		Gallery:                gallery,
+		GalleryImageDefinition: galleryImageDef,
+		GalleryImageVersion:    galleryVersion,
+		ReplicationRegions:     galleryRegions,
+		BootMode:               bootMode,
+	}
+
+	return azureNewUploader(clientID, clientSecret, tenant, subscription, resourceGroup, imageName, imagePath, targetArch, opts)
+}
+
+func uploaderForCmdGCP(cmd *cobra.Command, distroName string) (cloud.Uploader, error) {
+	bucketName, err := cmd.Flags().GetString("gcp-bucket")
+	if err != nil {
+		return nil, err
+	}
+	imageName, err := cmd.Flags().GetString("gcp-image-name")
+	if err != nil {
+		return nil, err
+	}
+	credentialsFile, err := cmd.Flags().GetString("gcp-credentials")
+	if err != nil {
+		return nil, err
+	}
+	regions, err := cmd.Flags().GetStringArray("gcp-region")
+	if err != nil {
+		return nil, err
+	}
+	shareWith, err := cmd.Flags().GetStringArray("gcp-share-with")
+	if err != nil {
+		return nil, err
+	}
+
+	var missing []string
+	requiredArgs := []string{"gcp-bucket", "gcp-image-name"}
+	for _, argName := range requiredArgs {
+		arg, err := cmd.Flags().GetString(argName)
+		if err != nil {
+			return nil, err
+		}
+		if arg == "" {
+			missing = append(missing, fmt.Sprintf("--%s", argName))
+		}
+	}
+	if len(missing) > 0 {
+		if len(missing) == len(requiredArgs) {
+			return nil, fmt.Errorf("%w: %q", ErrUploadConfigNotProvided, missing)
+		}
+		return nil, fmt.Errorf("%w: %q", ErrMissingUploadConfig, missing)
+	}
+
+	guestOsFeatures := gcp.GuestOsFeaturesByDistro(distroName)
+	if guestOsFeatures == nil {
+		fmt.Fprintf(osStderr, "WARNING: no known GCP guest OS features for distro %q (use --distro to set)\n", distroName)
+	}
+	opts := &gcp.UploaderOptions{
+		CredentialsFile: credentialsFile,
+		Regions:         regions,
+		ShareWith:       shareWith,
+		GuestOsFeatures: guestOsFeatures,
+	}
+	return gcpNewUploader(bucketName, imageName, opts)
+}
+
+func uploaderForCmdOCI(cmd *cobra.Command) (cloud.Uploader, error) {
+	compartmentID, err := cmd.Flags().GetString("oci-compartment")
+	if err != nil {
+		return nil, err
+	}
+	bucketName, err := cmd.Flags().GetString("oci-bucket")
+	if err != nil {
+		return nil, err
+	}
+	namespace, err := cmd.Flags().GetString("oci-namespace")
+	if err != nil {
+		return nil, err
+	}
+	imageName, err := cmd.Flags().GetString("oci-image-name")
+	if err != nil {
+		return nil, err
+	}
+
+	var missing []string
+	requiredArgs := []string{"oci-compartment", "oci-bucket", "oci-namespace", "oci-image-name"}
+	for _, argName := range requiredArgs {
+		arg, err := cmd.Flags().GetString(argName)
+		if err != nil {
+			return nil, err
+		}
+		if arg == "" {
+			missing = append(missing, fmt.Sprintf("--%s", argName))
+		}
+	}
+	if len(missing) > 0 {
+		if len(missing) == len(requiredArgs) {
+			return nil, fmt.Errorf("%w: %q", ErrUploadConfigNotProvided, missing)
+		}
+		return nil, fmt.Errorf("%w: %q", ErrMissingUploadConfig, missing)
+	}
+
+	// credentials are read from the default OCI configuration,
+	// i.e. $HOME/.oci/config or $OCI_CONFIG_FILE
+	return ociNewUploader(compartmentID, bucketName, namespace, imageName, nil)
+}
+
+func uploaderForCmdVSphere(cmd *cobra.Command, format string) (cloud.Uploader, error) {
+	host, err := cmd.Flags().GetString("vsphere-host")
+	if err != nil {
+		return nil, err
+	}
+	username, err := cmd.Flags().GetString("vsphere-username")
+	if err != nil {
+		return nil, err
+	}
+	password, err := cmd.Flags().GetString("vsphere-password")
+	if err != nil {
+		return nil, err
+	}
+	datacenter, err := cmd.Flags().GetString("vsphere-datacenter")
+	if err != nil {
+		return nil, err
+	}
+	cluster, err := cmd.Flags().GetString("vsphere-cluster")
+	if err != nil {
+		return nil, err
+	}
+	datastore, err := cmd.Flags().GetString("vsphere-datastore")
+	if err != nil {
+		return nil, err
+	}
+	folder, err := cmd.Flags().GetString("vsphere-folder")
+	if err != nil {
+		return nil, err
+	}
+	templateName, err := cmd.Flags().GetString("vsphere-template-name")
+	if err != nil {
+		return nil, err
+	}
+	insecure, err := cmd.Flags().GetBool("vsphere-insecure")
+	if err != nil {
+		return nil, err
+	}
+
+	var missing []string
+	requiredArgs := []string{"vsphere-host", "vsphere-username", "vsphere-password", "vsphere-datacenter", "vsphere-cluster", "vsphere-datastore", "vsphere-template-name"}
+	for _, argName := range requiredArgs {
+		arg, err := cmd.Flags().GetString(argName)
+		if err != nil {
+			return nil, err
+		}
+		if arg == "" {
+			missing = append(missing, fmt.Sprintf("--%s", argName))
+		}
+	}
+	if len(missing) > 0 {
+		if len(missing) == len(requiredArgs) {
+			return nil, fmt.Errorf("%w: %q", ErrUploadConfigNotProvided, missing)
+		}
+		return nil, fmt.Errorf("%w: %q", ErrMissingUploadConfig, missing)
+	}
+
+	creds := vmware.Credentials{
+		Host:       host,
+		Username:   username,
+		Password:   password,
+		Datacenter: datacenter,
+		Cluster:    cluster,
+		Datastore:  datastore,
+		Folder:     folder,
+	}
+	opts := &vmware.UploaderOptions{
+		Format:   format,
+		Insecure: insecure,
+	}
+	return vmwareNewUploader(creds, templateName, opts)
+}
+
+func uploaderForCmdS3(cmd *cobra.Command, imagePath string) (cloud.Uploader, error) {
+	endpoint, err := cmd.Flags().GetString("s3-endpoint")
+	if err != nil {
+		return nil, err
+	}
+	region, err := cmd.Flags().GetString("s3-region")
+	if err != nil {
+		return nil, err
+	}
+	bucketName, err := cmd.Flags().GetString("s3-bucket")
+	if err != nil {
+		return nil, err
+	}
+	objectKey, err := cmd.Flags().GetString("s3-key")
+	if err != nil {
+		return nil, err
+	}
+	profile, err := cmd.Flags().GetString("s3-profile")
+	if err != nil {
+		return nil, err
+	}
+	caBundle, err := cmd.Flags().GetString("s3-ca-bundle")
+	if err != nil {
+		return nil, err
+	}
+	insecure, err := cmd.Flags().GetBool("s3-insecure")
+	if err != nil {
+		return nil, err
+	}
+	pathStyle, err := cmd.Flags().GetBool("s3-path-style")
+	if err != nil {
+		return nil, err
+	}
+	var partSize datasizes.Size
+	if err := cmd.Flags().GetText("s3-part-size", &partSize); err != nil {
+		return nil, err
+	}
+	presign, err := cmd.Flags().GetBool("s3-presign")
+	if err != nil {
+		return nil, err
+	}
+
+	if bucketName == "" {
+		return nil, fmt.Errorf("%w: %q", ErrUploadConfigNotProvided, []string{"--s3-bucket"})
+	}
+	if objectKey == "" {
+		objectKey = filepath.Base(imagePath)
+	}
+
+	opts := &awscloud.S3UploaderOptions{
+		S3Options: awscloud.S3Options{
+			Endpoint:            endpoint,
+			CABundle:            caBundle,
+			SkipSSLVerification: insecure,
+			UsePathStyle:        pathStyle,
+			// #nosec G115
+			PartSize: int64(partSize.Uint64()),
+		},
+		Region:  region,
+		Profile: profile,
+		Presign: presign,
+	}
+	return s3NewUploader(bucketName, objectKey, opts)
+}
+
+func uploaderForCmdRegistry(cmd *cobra.Command, target, targetArch, imagePath string) (cloud.Uploader, error) {
+	authFile, err := cmd.Flags().GetString("registry-auth-file")
+	if err != nil {
+		return nil, err
+	}
+	insecure, err := cmd.Flags().GetBool("registry-insecure")
+	if err != nil {
+		return nil, err
+	}
+
+	if target == "" {
+		return nil, fmt.Errorf("missing registry target, try --to=registry://quay.io/example/image:tag")
+	}
+	opts := &container.UploaderOptions{
+		AuthFile:  authFile,
+		Arch:      targetArch,
+		ImagePath: imagePath,
+	}
+	if insecure {
+		opts.TLSVerify = common.ToPtr(false)
+	}
+	return registryNewUploader(target, opts)
+}
+
+func uploaderForCmdKoji(cmd *cobra.Command, targetArch string, bootMode *platform.BootMode, imagePath string) (cloud.Uploader, error) {
+	server, err := cmd.Flags().GetString("koji-server")
+	if err != nil {
+		return nil, err
+	}
+	buildName, err := cmd.Flags().GetString("koji-name")
+	if err != nil {
+		return nil, err
+	}
+	buildVersion, err := cmd.Flags().GetString("koji-version")
+	if err != nil {
+		return nil, err
+	}
+	buildRelease, err := cmd.Flags().GetString("koji-release")
+	if err != nil {
+		return nil, err
+	}
+	principal, err := cmd.Flags().GetString("koji-principal")
+	if err != nil {
+		return nil, err
+	}
+	keytab, err := cmd.Flags().GetString("koji-keytab")
+	if err != nil {
+		return nil, err
+	}
+	manifest, err := cmd.Flags().GetString("koji-manifest")
+	if err != nil {
+		return nil, err
+	}
+	buildLog, err := cmd.Flags().GetString("koji-buildlog")
+	if err != nil {
+		return nil, err
+	}
+	sboms, err := cmd.Flags().GetStringArray("koji-sbom")
+	if err != nil {
+		return nil, err
+	}
+
+	if server == "" {
+		return nil, fmt.Errorf("%w: %q", ErrUploadConfigNotProvided, []string{"--koji-server"})
+	}
+	// the name and version default to the ones from the blueprint
+	// (only available when building)
+	if bpFlag := cmd.Flags().Lookup("blueprint"); bpFlag != nil && bpFlag.Value.String() != "" && (buildName == "" || buildVersion == "") {
+		bp, err := blueprintload.Load(bpFlag.Value.String())
+		if err != nil {
+			return nil, err
+		}
+		if buildName == "" {
+			buildName = bp.Name
+		}
+		if buildVersion == "" {
+			buildVersion = bp.Version
+		}
+	}
+	var missing []string
+	for _, arg := range []struct{ name, value string }{
+		{"koji-name", buildName},
+		{"koji-version", buildVersion},
+		{"koji-release", buildRelease},
+	} {
+		if arg.value == "" {
+			missing = append(missing, fmt.Sprintf("--%s", arg.name))
+		}
+	}
+	if len(missing) > 0 {
+		return nil, fmt.Errorf("%w: %q", ErrMissingUploadConfig, missing)
+	}
+
+	opts := &koji.UploaderOptions{
+		Arch:        targetArch,
+		Manifest:    manifest,
+		BuildLog:    buildLog,
+		SBOMs:       sboms,
+		ToolVersion: version,
+	}
+	if principal != "" || keytab != "" {
+		opts.Credentials = &koji.GSSAPICredentials{
+			Principal: principal,
+			KeyTab:    keytab,
+		}
+	}
+	if imagePath != "" {
+		opts.Filename = filepath.Base(imagePath)
+	}
+	if bootMode != nil {
+		opts.BootMode = bootMode.String()
+	}
+	if hostDistro, err := distroGetHostDistroName(); err == nil {
+		opts.HostOS = hostDistro
+	}
+	return kojiNewUploader(server, buildName, buildVersion, buildRelease, opts)
+}
+
+// setKojiBuildOutputs points the koji upload options to the
+// manifest, buildlog and SBOMs written by the build so that they
+// get imported together with the image
+func setKojiBuildOutputs(cmd *cobra.Command, outputDir, basename string) error {
+	for name, p := range map[string]string{
+		"koji-manifest": filepath.Join(outputDir, fmt.Sprintf("%s.osbuild-manifest.json", basename)),
+		"koji-buildlog": filepath.Join(outputDir, fmt.Sprintf("%s.buildlog", basename)),
+	} {
+		if cmd.Flags().Changed(name) {
+			continue
+		}
+		if err := cmd.Flags().Set(name, p); err != nil {
+			return err
+		}
+	}
+	if !cmd.Flags().Changed("koji-sbom") {
+		sboms, err := filepath.Glob(filepath.Join(outputDir, fmt.Sprintf("%s.*.spdx.json", basename)))
+		if err != nil {
+			return err
+		}
+		for _, sbom := range sboms {
+			if err := cmd.Flags().Set("koji-sbom", sbom); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
 }
 
 func detectArchFromImagePath(imagePath string) string {
@@ -367,7 +816,12 @@ func cmdUpload(cmd *cobra.Command, args []string) error {
 		return err
 	}
 
-	uploader, err := uploaderFor(cmd, uploadTo, targetArch, bootMode, imagePath)
+	distroName, err := cmd.Flags().GetString("distro")
+	if err != nil {
+		return err
+	}
+
+	uploader, err := uploaderFor(cmd, uploadTo, distroName, targetArch, bootMode, imagePath)
 	if err != nil {
 		return err
 	}
@@ -381,9 +835,11 @@ func cmdUpload(cmd *cobra.Command, args []string) error {
 	}
 	pbar.Start()
 	defer pbar.Stop()
-	result, err := uploadImageWithProgress(uploader, pbar, imagePath)
-	if err != nil {
-		return err
+	// a partial result (e.g. an AMI that got registered but not
+	// copied) is still shown
+	result, uploadErr := uploadImageWithProgress(uploader, pbar, imagePath)
+	if result == nil {
+		return uploadErr
 	}
 
 	format, err := cmd.Flags().GetString("format")
@@ -406,5 +862,5 @@ func cmdUpload(cmd *cobra.Command, args []string) error {
 	default:
 		return fmt.Errorf("unsupported format %q, supported formats: yaml, json", format)
 	}
-	return nil
+	return uploadErr
 }
@@ -0,0 +1,205 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"slices"
+	"strings"
+	"sync"
+
+	"github.com/spf13/cobra"
+
+	"github.com/osbuild/image-builder/pkg/cloud"
+	"github.com/osbuild/image-builder/pkg/progress"
+)
+
+// uploadTarget is a single destination of a build with multiple
+// upload targets, e.g. "aws" or "registry://quay.io/example/image"
+type uploadTarget struct {
+	name     string
+	uploader cloud.Uploader
+}
+
+// uploadTargetResult is the outcome of the upload to a single target,
+// exactly one of UploadResult and Error is set
+type uploadTargetResult struct {
+	Target string `json:"target"`
+	*cloud.UploadResult
+	Error string `json:"error,omitempty"`
+}
+
+// readTargetsFile reads upload targets from the given file, one
+// per line. Empty lines and lines starting with "#" are ignored.
+func readTargetsFile(path string) ([]string, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, fmt.Errorf("cannot read targets file: %w", err)
+	}
+	defer f.Close()
+
+	var targets []string
+	scanner := bufio.NewScanner(f)
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		targets = append(targets, line)
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, fmt.Errorf("cannot read targets file: %w", err)
+	}
+	return targets, nil
+}
+
+// uploadTargetsFromCmd returns the upload targets from the "--to"
+// and "--targets-file" options
+func uploadTargetsFromCmd(cmd *cobra.Command) ([]string, error) {
+	targets, err := cmd.Flags().GetStringArray("to")
+	if err != nil {
+		return nil, err
+	}
+	targetsFile, err := cmd.Flags().GetString("targets-file")
+	if err != nil {
+		return nil, err
+	}
+	if targetsFile != "" {
+		fromFile, err := readTargetsFile(targetsFile)
+		if err != nil {
+			return nil, err
+		}
+		targets = append(targets, fromFile...)
+	}
+
+	for i, target := range targets {
+		if target == "" {
+			return nil, fmt.Errorf("empty upload target")
+		}
+		if slices.Contains(targets[:i], target) {
+			return nil, fmt.Errorf("duplicated upload target %q", target)
+		}
+	}
+	return targets, nil
+}
+
+// prefixWriter prefixes every line written to w so that the status
+// of concurrent uploads can be told apart
+type prefixWriter struct {
+	mu     *sync.Mutex
+	w      io.Writer
+	prefix string
+
+	buf bytes.Buffer
+}
+
+func newPrefixWriter(mu *sync.Mutex, w io.Writer, prefix string) *prefixWriter {
+	return &prefixWriter{mu: mu, w: w, prefix: prefix}
+}
+
+func (pw *prefixWriter) Write(p []byte) (int, error) {
+	pw.buf.Write(p)
+	for {
+		line, err := pw.buf.ReadBytes('\n')
+		if err != nil {
+			// incomplete line, keep it for the next write
+			pw.buf.Write(line)
+			return len(p), nil
+		}
+		pw.mu.Lock()
+		_, err = fmt.Fprintf(pw.w, "%s%s", pw.prefix, line)
+		pw.mu.Unlock()
+		if err != nil {
+			return 0, err
+		}
+	}
+}
+
+func openImageForUpload(imagePath string) (*os.File, int, error) {
+	f, err := os.Open(imagePath)
+	if err != nil {
+		return nil, 0, err
+	}
+	st, err := f.Stat()
+	if err != nil {
+		f.Close()
+		return nil, 0, fmt.Errorf("cannot stat upload: %v", err)
+	}
+	if st.Size() < 0 {
+		f.Close()
+		return nil, 0, fmt.Errorf("invalid size read for %s: %d", imagePath, st.Size())
+	}
+	return f, int(st.Size()), nil
+}
+
+// uploadImageToTarget uploads the image to a single target, the
+// progress is reported as the progress of the target
+func uploadImageToTarget(target uploadTarget, pbar progress.ProgressBar, imagePath string, status io.Writer) (*cloud.UploadResult, error) {
+	f, size, err := openImageForUpload(imagePath)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	r, err := progress.NewTargetProxyReader(f, target.name, size, pbar)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create proxy reader: %w", err)
+	}
+	// #nosec G115
+	return cloud.UploadAndRegister(target.uploader, r, uint64(size), status)
+}
+
+// uploadImageToTargets uploads the image to all the given targets
+// concurrently, every target gets its own progress line. A failed
+// upload does not stop the others, its error is recorded in the
+// result of the target instead.
+func uploadImageToTargets(targets []uploadTarget, pbar progress.ProgressBar, imagePath string) []uploadTargetResult {
+	results := make([]uploadTargetResult, len(targets))
+
+	pbar.Start()
+	pbar.SetPulseMsgf("Uploading to %d targets", len(targets))
+
+	var statusMu sync.Mutex
+	var wg sync.WaitGroup
+	for i, target := range targets {
+		results[i].Target = target.name
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			status := newPrefixWriter(&statusMu, osStderr, fmt.Sprintf("[%s] ", target.name))
+			// a partial result is kept together with the error
+			res, err := uploadImageToTarget(target, pbar, imagePath, status)
+			results[i].UploadResult = res
+			if err != nil {
+				results[i].Error = err.Error()
+			}
+		}()
+	}
+	wg.Wait()
+
+	return results
+}
+
+// uploadResultsErr returns an error for every failed upload
+func uploadResultsErr(results []uploadTargetResult) error {
+	var errs []error
+	for _, res := range results {
+		if res.Error != "" {
+			errs = append(errs, fmt.Errorf("cannot upload to %s: %s", res.Target, res.Error))
+		}
+	}
+	return errors.Join(errs...)
+}
+
+func writeUploadResults(path string, results []uploadTargetResult) error {
+	data, err := json.MarshalIndent(results, "", "  ")
+	if err != nil {
+		return err
+	}
+	// #nosec: G306
+	return os.WriteFile(path, data, 0640)
+}
@@ -2,6 +2,8 @@ package main_test
 
 import (
 	"bytes"
+	"crypto/sha256"
+	"encoding/json"
 	"fmt"
 	"io"
 	"os"
@@ -16,7 +18,13 @@ import (
 	"github.com/osbuild/image-builder/pkg/arch"
 	"github.com/osbuild/image-builder/pkg/cloud"
 	"github.com/osbuild/image-builder/pkg/cloud/awscloud"
+	"github.com/osbuild/image-builder/pkg/cloud/azure"
+	"github.com/osbuild/image-builder/pkg/cloud/gcp"
+	"github.com/osbuild/image-builder/pkg/container"
 	"github.com/osbuild/image-builder/pkg/platform"
+	"github.com/osbuild/image-builder/pkg/upload/koji"
+	"github.com/osbuild/image-builder/pkg/upload/oci"
+	"github.com/osbuild/image-builder/pkg/upload/vmware"
 
 	main "github.com/osbuild/image-builder/cmd/image-builder"
 	"github.com/osbuild/image-builder/internal/testutil"
@@ -178,6 +186,470 @@ func TestUploadWithAWSBootMode(t *testing.T) {
 	}
 }
 
+func TestUploadWithGCPMock(t *testing.T) {
+	fakeDiskContent := "fake-gce-tarball"
+	fakeImageFilePath := filepath.Join(t.TempDir(), "rhel-9.6-gce-x86_64.tar.gz")
+	err := os.WriteFile(fakeImageFilePath, []byte(fakeDiskContent), 0600)
+	require.NoError(t, err)
+
+	var bucketName, imageName string
+	var uploadOpts *gcp.UploaderOptions
+	var fa fakeAwsUploader
+	restore := main.MockGcpNewUploader(func(bucket string, image string, opts *gcp.UploaderOptions) (cloud.Uploader, error) {
+		bucketName = bucket
+		imageName = image
+		uploadOpts = opts
+		return &fa, nil
+	})
+	defer restore()
+
+	var fakeStdout, fakeStderr bytes.Buffer
+	restore = main.MockOsStdout(&fakeStdout)
+	defer restore()
+	restore = main.MockOsStderr(&fakeStderr)
+	defer restore()
+
+	restore = main.MockOsArgs([]string{
+		"upload",
+		"--to=gcp",
+		"--distro=rhel-9.6",
+		"--gcp-bucket=gcp-bucket-1",
+		"--gcp-image-name=gcp-image-2",
+		"--gcp-credentials=/path/to/creds.json",
+		"--gcp-region=us-east1",
+		"--gcp-share-with=user:alice@example.com",
+		fakeImageFilePath,
+	})
+	defer restore()
+
+	err = main.Run()
+	require.NoError(t, err)
+
+	assert.Equal(t, "gcp-bucket-1", bucketName)
+	assert.Equal(t, "gcp-image-2", imageName)
+	assert.Equal(t, &gcp.UploaderOptions{
+		CredentialsFile: "/path/to/creds.json",
+		Regions:         []string{"us-east1"},
+		ShareWith:       []string{"user:alice@example.com"},
+		GuestOsFeatures: gcp.GuestOsFeaturesRHEL9,
+	}, uploadOpts)
+	assert.Equal(t, 1, fa.uploadAndRegisterCalls)
+	assert.Equal(t, fakeDiskContent, fa.uploadAndRegisterRead.String())
+}
+
+func TestUploadWithOCIMock(t *testing.T) {
+	fakeDiskContent := "fake-oci-qcow2"
+	fakeImageFilePath := filepath.Join(t.TempDir(), "centos-9-oci-x86_64.qcow2")
+	err := os.WriteFile(fakeImageFilePath, []byte(fakeDiskContent), 0600)
+	require.NoError(t, err)
+
+	var compartmentID, bucketName, namespace, imageName string
+	var fa fakeAwsUploader
+	restore := main.MockOciNewUploader(func(compartment, bucket, ns, image string, opts *oci.UploaderOptions) (cloud.Uploader, error) {
+		compartmentID = compartment
+		bucketName = bucket
+		namespace = ns
+		imageName = image
+		return &fa, nil
+	})
+	defer restore()
+
+	var fakeStdout, fakeStderr bytes.Buffer
+	restore = main.MockOsStdout(&fakeStdout)
+	defer restore()
+	restore = main.MockOsStderr(&fakeStderr)
+	defer restore()
+
+	restore = main.MockOsArgs([]string{
+		"upload",
+		"--to=oci",
+		"--oci-compartment=oci-compartment-1",
+		"--oci-bucket=oci-bucket-2",
+		"--oci-namespace=oci-namespace-3",
+		"--oci-image-name=oci-image-4",
+		fakeImageFilePath,
+	})
+	defer restore()
+
+	err = main.Run()
+	require.NoError(t, err)
+
+	assert.Equal(t, "oci-compartment-1", compartmentID)
+	assert.Equal(t, "oci-bucket-2", bucketName)
+	assert.Equal(t, "oci-namespace-3", namespace)
+	assert.Equal(t, "oci-image-4", imageName)
+	assert.Equal(t, 1, fa.uploadAndRegisterCalls)
+	assert.Equal(t, fakeDiskContent, fa.uploadAndRegisterRead.String())
+}
+
+func TestUploadWithVSphereMock(t *testing.T) {
+	for _, tc := range []struct {
+		fakeDiskName   string
+		expectedFormat string
+	}{
+		{"rhel-9.6-vmdk-x86_64.vmdk", vmware.FormatVMDK},
+		{"rhel-9.6-ova-x86_64.ova", vmware.FormatOVA},
+	} {
+		t.Run(tc.expectedFormat, func(t *testing.T) {
+			fakeDiskContent := "fake-vsphere-img"
+			fakeImageFilePath := filepath.Join(t.TempDir(), tc.fakeDiskName)
+			err := os.WriteFile(fakeImageFilePath, []byte(fakeDiskContent), 0600)
+			require.NoError(t, err)
+
+			var creds vmware.Credentials
+			var templateName string
+			var uploadOpts *vmware.UploaderOptions
+			var fa fakeAwsUploader
+			restore := main.MockVmwareNewUploader(func(c vmware.Credentials, name string, opts *vmware.UploaderOptions) (cloud.Uploader, error) {
+				creds = c
+				templateName = name
+				uploadOpts = opts
+				return &fa, nil
+			})
+			defer restore()
+
+			var fakeStdout, fakeStderr bytes.Buffer
+			restore = main.MockOsStdout(&fakeStdout)
+			defer restore()
+			restore = main.MockOsStderr(&fakeStderr)
+			defer restore()
+
+			restore = main.MockOsArgs([]string{
+				"upload",
+				"--to=vsphere",
+				"--vsphere-host=vcenter.example.com",
+				"--vsphere-username=user",
+				"--vsphere-password=secret",
+				"--vsphere-datacenter=dc",
+				"--vsphere-cluster=cluster",
+				"--vsphere-datastore=ds",
+				"--vsphere-template-name=template",
+				fakeImageFilePath,
+			})
+			defer restore()
+
+			err = main.Run()
+			require.NoError(t, err)
+
+			assert.Equal(t, vmware.Credentials{
+				Host:       "vcenter.example.com",
+				Username:   "user",
+				Password:   "secret",
+				Datacenter: "dc",
+				Cluster:    "cluster",
+				Datastore:  "ds",
+			}, creds)
+			assert.Equal(t, "template", templateName)
+			assert.Equal(t, &vmware.UploaderOptions{Format: tc.expectedFormat}, uploadOpts)
+			assert.Equal(t, 1, fa.uploadAndRegisterCalls)
+			assert.Equal(t, fakeDiskContent, fa.uploadAndRegisterRead.String())
+		})
+	}
+}
+
+func TestUploadWithS3Mock(t *testing.T) {
+	fakeDiskContent := "fake-s3-img"
+	fakeImageFilePath := filepath.Join(t.TempDir(), "fedora-42-qcow2-x86_64.qcow2")
+	err := os.WriteFile(fakeImageFilePath, []byte(fakeDiskContent), 0600)
+	require.NoError(t, err)
+
+	var bucketName, objectKey string
+	var uploadOpts *awscloud.S3UploaderOptions
+	var fa fakeAwsUploader
+	restore := main.MockS3NewUploader(func(bucket, key string, opts *awscloud.S3UploaderOptions) (cloud.Uploader, error) {
+		bucketName = bucket
+		objectKey = key
+		uploadOpts = opts
+		return &fa, nil
+	})
+	defer restore()
+
+	var fakeStdout, fakeStderr bytes.Buffer
+	restore = main.MockOsStdout(&fakeStdout)
+	defer restore()
+	restore = main.MockOsStderr(&fakeStderr)
+	defer restore()
+
+	restore = main.MockOsArgs([]string{
+		"upload",
+		"--to=s3",
+		"--s3-endpoint=https://minio.example.com",
+		"--s3-bucket=images",
+		"--s3-path-style",
+		"--s3-part-size=64 MiB",
+		"--s3-presign",
+		fakeImageFilePath,
+	})
+	defer restore()
+
+	err = main.Run()
+	require.NoError(t, err)
+
+	assert.Equal(t, "images", bucketName)
+	assert.Equal(t, "fedora-42-qcow2-x86_64.qcow2", objectKey)
+	assert.Equal(t, &awscloud.S3UploaderOptions{
+		S3Options: awscloud.S3Options{
+			Endpoint:     "https://minio.example.com",
+			UsePathStyle: true,
+			PartSize:     64 * 1024 * 1024,
+		},
+		Region:  "us-east-1",
+		Presign: true,
+	}, uploadOpts)
+	assert.Equal(t, 1, fa.uploadAndRegisterCalls)
+	assert.Equal(t, fakeDiskContent, fa.uploadAndRegisterRead.String())
+}
+
+func TestUploadWithRegistryMock(t *testing.T) {
+	fakeDiskContent := "fake-oci-archive"
+	fakeImageFilePath := filepath.Join(t.TempDir(), "fedora-42-container-x86_64.tar")
+	err := os.WriteFile(fakeImageFilePath, []byte(fakeDiskContent), 0600)
+	require.NoError(t, err)
+
+	var target string
+	var uploadOpts *container.UploaderOptions
+	var fa fakeAwsUploader
+	restore := main.MockRegistryNewUploader(func(t string, opts *container.UploaderOptions) (cloud.Uploader, error) {
+		target = t
+		uploadOpts = opts
+		return &fa, nil
+	})
+	defer restore()
+
+	var fakeStdout, fakeStderr bytes.Buffer
+	restore = main.MockOsStdout(&fakeStdout)
+	defer restore()
+	restore = main.MockOsStderr(&fakeStderr)
+	defer restore()
+
+	restore = main.MockOsArgs([]string{
+		"upload",
+		"--to=registry://quay.example/foo:tag",
+		"--registry-auth-file=/path/to/auth.json",
+		"--registry-insecure",
+		fakeImageFilePath,
+	})
+	defer restore()
+
+	err = main.Run()
+	require.NoError(t, err)
+
+	assert.Equal(t, "quay.example/foo:tag", target)
+	assert.Equal(t, &container.UploaderOptions{
+		AuthFile:  "/path/to/auth.json",
+		TLSVerify: common.ToPtr(false),
+		Arch:      "x86_64",
+		ImagePath: fakeImageFilePath,
+	}, uploadOpts)
+	assert.Equal(t, 1, fa.uploadAndRegisterCalls)
+	assert.Equal(t, fakeDiskContent, fa.uploadAndRegisterRead.String())
+}
+
+func TestUploadWithKojiMock(t *testing.T) {
+	fakeDiskContent := "fake-koji-img"
+	fakeImageFilePath := filepath.Join(t.TempDir(), "fedora-42-qcow2-x86_64.qcow2")
+	err := os.WriteFile(fakeImageFilePath, []byte(fakeDiskContent), 0600)
+	require.NoError(t, err)
+
+	var server, nvr string
+	var uploadOpts *koji.UploaderOptions
+	var fa fakeAwsUploader
+	restore := main.MockKojiNewUploader(func(s, name, version, release string, opts *koji.UploaderOptions) (cloud.Uploader, error) {
+		server = s
+		nvr = fmt.Sprintf("%s-%s-%s", name, version, release)
+		uploadOpts = opts
+		return &fa, nil
+	})
+	defer restore()
+	restore = main.MockDistroGetHostDistroName(func() (string, error) {
+		return "fedora-42", nil
+	})
+	defer restore()
+
+	var fakeStdout, fakeStderr bytes.Buffer
+	restore = main.MockOsStdout(&fakeStdout)
+	defer restore()
+	restore = main.MockOsStderr(&fakeStderr)
+	defer restore()
+
+	restore = main.MockOsArgs([]string{
+		"upload",
+		"--to=koji",
+		"--koji-server=https://koji.example.com/kojihub",
+		"--koji-name=my-image",
+		"--koji-version=1.0",
+		"--koji-release=1",
+		"--koji-principal=user@EXAMPLE.COM",
+		"--koji-keytab=/etc/user.keytab",
+		"--koji-manifest=/path/to/manifest.json",
+		fakeImageFilePath,
+	})
+	defer restore()
+
+	err = main.Run()
+	require.NoError(t, err)
+
+	assert.Equal(t, "https://koji.example.com/kojihub", server)
+	assert.Equal(t, "my-image-1.0-1", nvr)
+	assert.Equal(t, &koji.UploaderOptions{
+		Credentials: &koji.GSSAPICredentials{
+			Principal: "user@EXAMPLE.COM",
+			KeyTab:    "/etc/user.keytab",
+		},
+		Filename:    "fedora-42-qcow2-x86_64.qcow2",
+		Arch:        "x86_64",
+		Manifest:    "/path/to/manifest.json",
+		SBOMs:       []string{},
+		HostOS:      "fedora-42",
+		ToolVersion: "unknown",
+	}, uploadOpts)
+	assert.Equal(t, 1, fa.uploadAndRegisterCalls)
+	assert.Equal(t, fakeDiskContent, fa.uploadAndRegisterRead.String())
+}
+
+func TestUploadWithAWSCopyAndShareMock(t *testing.T) {
+	fakeImageFilePath := filepath.Join(t.TempDir(), "disk.raw")
+	err := os.WriteFile(fakeImageFilePath, []byte("fake-raw-img"), 0600)
+	require.NoError(t, err)
+
+	var uploadOpts *awscloud.UploaderOptions
+	var fa fakeAwsUploader
+	restore := main.MockAwscloudNewUploader(func(region string, bucket string, ami string, opts *awscloud.UploaderOptions) (cloud.Uploader, error) {
+		uploadOpts = opts
+		return &fa, nil
+	})
+	defer restore()
+
+	var fakeStdout, fakeStderr bytes.Buffer
+	restore = main.MockOsStdout(&fakeStdout)
+	defer restore()
+	restore = main.MockOsStderr(&fakeStderr)
+	defer restore()
+
+	restore = main.MockOsArgs([]string{
+		"upload",
+		"--to=aws",
+		"--aws-region=us-east-1",
+		"--aws-bucket=bucket",
+		"--aws-ami-name=ami",
+		"--aws-copy-to-region=eu-west-1",
+		"--aws-copy-to-region=ap-south-1",
+		"--aws-share-with=123456789012",
+		"--aws-share-with=arn:aws:organizations::123456789012:organization/o-123example",
+		"--arch=x86_64",
+		fakeImageFilePath,
+	})
+	defer restore()
+
+	err = main.Run()
+	require.NoError(t, err)
+
+	assert.Equal(t, []string{"eu-west-1", "ap-south-1"}, uploadOpts.CopyToRegions)
+	assert.Equal(t, []string{"123456789012", "arn:aws:organizations::123456789012:organization/o-123example"}, uploadOpts.ShareWith)
+	assert.Equal(t, 1, fa.uploadAndRegisterCalls)
+}
+
+func TestUploadWithAWSPartialResultMock(t *testing.T) {
+	fakeImageFilePath := filepath.Join(t.TempDir(), "disk.raw")
+	err := os.WriteFile(fakeImageFilePath, []byte("fake-raw-img"), 0600)
+	require.NoError(t, err)
+
+	fa := fakeAwsUploader{
+		uploadAndRegisterErr: fmt.Errorf("AMI ami-123 registered but copying failed: region eu-west-1: fake-copy-err"),
+		partialResult: &cloud.UploadResult{
+			Provider: "aws",
+			ImageID:  "ami-123",
+			ImageIDs: map[string]string{"us-east-1": "ami-123", "ap-south-1": "ami-456"},
+		},
+	}
+	restore := main.MockAwscloudNewUploader(func(region string, bucket string, ami string, opts *awscloud.UploaderOptions) (cloud.Uploader, error) {
+		return &fa, nil
+	})
+	defer restore()
+
+	var fakeStdout, fakeStderr bytes.Buffer
+	restore = main.MockOsStdout(&fakeStdout)
+	defer restore()
+	restore = main.MockOsStderr(&fakeStderr)
+	defer restore()
+
+	restore = main.MockOsArgs([]string{
+		"upload",
+		"--to=aws",
+		"--aws-region=us-east-1",
+		"--aws-bucket=bucket",
+		"--aws-ami-name=ami",
+		"--aws-copy-to-region=eu-west-1",
+		"--aws-copy-to-region=ap-south-1",
+		"--arch=x86_64",
+		"--format=json",
+		fakeImageFilePath,
+	})
+	defer restore()
+
+	err = main.Run()
+	assert.EqualError(t, err, "AMI ami-123 registered but copying failed: region eu-west-1: fake-copy-err")
+
+	// the registered AMI and the successful copy are still shown
+	var result cloud.UploadResult
+	require.NoError(t, json.Unmarshal(fakeStdout.Bytes(), &result))
+	assert.Equal(t, "ami-123", result.ImageID)
+	assert.Equal(t, map[string]string{"us-east-1": "ami-123", "ap-south-1": "ami-456"}, result.ImageIDs)
+	assert.NotEmpty(t, result.Checksum)
+}
+
+func TestUploadWithAzureGalleryMock(t *testing.T) {
+	fakeImageFilePath := filepath.Join(t.TempDir(), "disk.vhd")
+	err := os.WriteFile(fakeImageFilePath, []byte("fake-vhd-img"), 0600)
+	require.NoError(t, err)
+
+	var imageName string
+	var uploadOpts *azure.UploaderOptions
+	var fa fakeAwsUploader
+	restore := main.MockAzureNewUploader(func(clientID, clientSecret, tenant, subscription, resourceGroup, name, imagePath string, architecture arch.Arch, opts *azure.UploaderOptions) (cloud.Uploader, error) {
+		imageName = name
+		uploadOpts = opts
+		return &fa, nil
+	})
+	defer restore()
+
+	var fakeStdout, fakeStderr bytes.Buffer
+	restore = main.MockOsStdout(&fakeStdout)
+	defer restore()
+	restore = main.MockOsStderr(&fakeStderr)
+	defer restore()
+
+	restore = main.MockOsArgs([]string{
+		"upload",
+		"--to=azure",
+		"--azure-client-id=id",
+		"--azure-client-secret=secret",
+		"--azure-tenant=tenant",
+		"--azure-subscription=subscription",
+		"--azure-resource-group=rg",
+		"--azure-gallery=my_gallery",
+		"--azure-gallery-image-definition=my-def",
+		"--azure-gallery-version=1.2.3",
+		"--azure-gallery-region=westeurope",
+		"--azure-gallery-region=eastus",
+		"--arch=x86_64",
+		fakeImageFilePath,
+	})
+	defer restore()
+
+	err = main.Run()
+	require.NoError(t, err)
+
+	assert.Equal(t, "my-def-1.2.3", imageName)
+	assert.Equal(t, &azure.UploaderOptions{
+		Gallery:                "my_gallery",
+		GalleryImageDefinition: "my-def",
+		GalleryImageVersion:    "1.2.3",
+		ReplicationRegions:     []string{"westeurope", "eastus"},
+	}, uploadOpts)
+	assert.Equal(t, 1, fa.uploadAndRegisterCalls)
+}
+
 func TestUploadCmdlineErrors(t *testing.T) {
 	var fakeStderr bytes.Buffer
 	restore := main.MockOsStderr(&fakeStderr)
@@ -202,6 +674,34 @@ func TestUploadCmdlineErrors(t *testing.T) {
 			[]string{"--to=aws", "--aws-ami-name=1", "--aws-bucket=2"},
 			`missing upload configuration: ["--aws-region"]`,
 		},
+		{
+			[]string{"--to=gcp", "--gcp-bucket=1"},
+			`missing upload configuration: ["--gcp-image-name"]`,
+		},
+		{
+			[]string{"--to=oci", "--oci-bucket=1", "--oci-namespace=2"},
+			`missing upload configuration: ["--oci-compartment" "--oci-image-name"]`,
+		},
+		{
+			[]string{"--to=s3", "--s3-endpoint=https://minio.example.com"},
+			`missing all upload configuration: ["--s3-bucket"]`,
+		},
+		{
+			[]string{"--to=koji", "--koji-name=name"},
+			`missing all upload configuration: ["--koji-server"]`,
+		},
+		{
+			[]string{"--to=koji", "--koji-server=https://koji.example.com/kojihub", "--koji-name=name"},
+			`missing upload configuration: ["--koji-version" "--koji-release"]`,
+		},
+		{
+			[]string{"--to=azure", "--azure-client-id=1", "--azure-client-secret=2", "--azure-tenant=3", "--azure-subscription=4", "--azure-resource-group=5", "--azure-gallery=6"},
+			`missing upload configuration: ["--azure-gallery-image-definition" "--azure-gallery-version"]`,
+		},
+		{
+			[]string{"--to=registry://"},
+			`missing registry target, try --to=registry://quay.io/example/image:tag`,
+		},
 	} {
 		t.Run(strings.Join(tc.cmdline, ","), func(t *testing.T) {
 			cmd := append([]string{"upload"}, tc.cmdline...)
@@ -394,3 +894,274 @@ func TestBuildAndUploadWithAWSPartialCmdlineErrors(t *testing.T) {
 	err := main.Run()
 	assert.EqualError(t, err, `missing upload configuration: ["--aws-ami-name" "--aws-bucket"]`)
 }
+
+func TestBuildAndUploadToRegistryMock(t *testing.T) {
+	restore := main.MockManifestgenDepsolver(fakeDepsolve)
+	defer restore()
+
+	restore = main.MockManifestgenContainerResolver(fakeContainerResolver)
+	defer restore()
+
+	restore = main.MockNewRepoRegistry(testrepos.New)
+	defer restore()
+
+	var target string
+	var fa fakeAwsUploader
+	restore = main.MockRegistryNewUploader(func(t string, opts *container.UploaderOptions) (cloud.Uploader, error) {
+		target = t
+		return &fa, nil
+	})
+	defer restore()
+
+	outputDir := t.TempDir()
+	fakeOsbuildScript := makeFakeOsbuildScript()
+	testutil.MockCommand(t, "osbuild", fakeOsbuildScript)
+
+	var fakeStdout bytes.Buffer
+	restore = main.MockOsStdout(&fakeStdout)
+	defer restore()
+
+	restore = main.MockOsArgs([]string{
+		"build",
+		"--output-dir", outputDir,
+		"--to=registry://quay.example/foo:tag",
+		"qcow2",
+		"--distro=centos-9",
+	})
+	defer restore()
+
+	err := main.Run()
+	require.NoError(t, err)
+
+	assert.Equal(t, "quay.example/foo:tag", target)
+	assert.Equal(t, 1, fa.checkCalls)
+	assert.Equal(t, 1, fa.uploadAndRegisterCalls)
+}
+
+func TestBuildWithExplicitToErrors(t *testing.T) {
+	restore := main.MockManifestgenDepsolver(fakeDepsolve)
+	defer restore()
+
+	restore = main.MockManifestgenContainerResolver(fakeContainerResolver)
+	defer restore()
+
+	restore = main.MockNewRepoRegistry(testrepos.New)
+	defer restore()
+
+	outputDir := t.TempDir()
+	restore = main.MockOsArgs([]string{
+		"build",
+		"--output-dir", outputDir,
+		"--to=no-such-cloud",
+		"qcow2",
+		"--distro=centos-9",
+	})
+	defer restore()
+
+	err := main.Run()
+	assert.EqualError(t, err, `unsupported type: "no-such-cloud"`)
+}
+
+func TestBuildAndUploadToKojiMock(t *testing.T) {
+	restore := main.MockManifestgenDepsolver(fakeDepsolve)
+	defer restore()
+
+	restore = main.MockManifestgenContainerResolver(fakeContainerResolver)
+	defer restore()
+
+	restore = main.MockNewRepoRegistry(testrepos.New)
+	defer restore()
+
+	var nvr string
+	var uploadOpts *koji.UploaderOptions
+	var fa fakeAwsUploader
+	restore = main.MockKojiNewUploader(func(s, name, version, release string, opts *koji.UploaderOptions) (cloud.Uploader, error) {
+		nvr = fmt.Sprintf("%s-%s-%s", name, version, release)
+		uploadOpts = opts
+		return &fa, nil
+	})
+	defer restore()
+
+	outputDir := t.TempDir()
+	fakeOsbuildScript := makeFakeOsbuildScript()
+	testutil.MockCommand(t, "osbuild", fakeOsbuildScript)
+
+	blueprintPath := filepath.Join(t.TempDir(), "blueprint.toml")
+	err := os.WriteFile(blueprintPath, []byte("name = \"my-image\"\nversion = \"2.0\"\n"), 0644)
+	require.NoError(t, err)
+
+	var fakeStdout bytes.Buffer
+	restore = main.MockOsStdout(&fakeStdout)
+	defer restore()
+
+	restore = main.MockOsArgs([]string{
+		"build",
+		"--output-dir", outputDir,
+		"--to=koji",
+		"--koji-server=https://koji.example.com/kojihub",
+		"--koji-release=3",
+		"--blueprint", blueprintPath,
+		"qcow2",
+		"--distro=centos-9",
+	})
+	defer restore()
+
+	err = main.Run()
+	require.NoError(t, err)
+
+	prefix := filepath.Join(outputDir, "centos-9-qcow2-x86_64")
+	assert.Equal(t, "my-image-2.0-3", nvr)
+	assert.Equal(t, "centos-9-qcow2-x86_64.qcow2", uploadOpts.Filename)
+	assert.Equal(t, prefix+".osbuild-manifest.json", uploadOpts.Manifest)
+	assert.Equal(t, prefix+".buildlog", uploadOpts.BuildLog)
+	assert.Equal(t, []string{
+		prefix + ".buildroot-build.spdx.json",
+		prefix + ".image-os.spdx.json",
+	}, uploadOpts.SBOMs)
+	for _, p := range append([]string{uploadOpts.Manifest, uploadOpts.BuildLog}, uploadOpts.SBOMs...) {
+		assert.FileExists(t, p)
+	}
+	assert.Equal(t, 1, fa.checkCalls)
+	assert.Equal(t, 1, fa.uploadAndRegisterCalls)
+}
+
+func TestBuildAndUploadToMultipleTargetsMock(t *testing.T) {
+	restore := main.MockManifestgenDepsolver(fakeDepsolve)
+	defer restore()
+
+	restore = main.MockManifestgenContainerResolver(fakeContainerResolver)
+	defer restore()
+
+	restore = main.MockNewRepoRegistry(testrepos.New)
+	defer restore()
+
+	var registryUploader fakeAwsUploader
+	restore = main.MockRegistryNewUploader(func(t string, opts *container.UploaderOptions) (cloud.Uploader, error) {
+		return &registryUploader, nil
+	})
+	defer restore()
+	s3Uploader := fakeAwsUploader{
+		uploadAndRegisterErr: fmt.Errorf("bucket is gone"),
+	}
+	restore = main.MockS3NewUploader(func(bucket, key string, opts *awscloud.S3UploaderOptions) (cloud.Uploader, error) {
+		return &s3Uploader, nil
+	})
+	defer restore()
+
+	outputDir := t.TempDir()
+	fakeOsbuildScript := makeFakeOsbuildScript()
+	testutil.MockCommand(t, "osbuild", fakeOsbuildScript)
+
+	targetsFile := filepath.Join(t.TempDir(), "targets")
+	err := os.WriteFile(targetsFile, []byte("# more targets\n\ns3\n"), 0644)
+	require.NoError(t, err)
+	resultsPath := filepath.Join(t.TempDir(), "results.json")
+
+	var fakeStdout, fakeStderr bytes.Buffer
+	restore = main.MockOsStdout(&fakeStdout)
+	defer restore()
+	restore = main.MockOsStderr(&fakeStderr)
+	defer restore()
+
+	restore = main.MockOsArgs([]string{
+		"build",
+		"--output-dir", outputDir,
+		"--to=registry://quay.example/foo:tag",
+		"--targets-file", targetsFile,
+		"--s3-bucket=images",
+		"--upload-results", resultsPath,
+		"qcow2",
+		"--distro=centos-9",
+	})
+	defer restore()
+
+	err = main.Run()
+	assert.EqualError(t, err, "cannot upload to s3: bucket is gone")
+
+	assert.Equal(t, 1, registryUploader.checkCalls)
+	assert.Equal(t, 1, registryUploader.uploadAndRegisterCalls)
+	assert.Equal(t, 1, s3Uploader.checkCalls)
+	assert.Equal(t, 1, s3Uploader.uploadAndRegisterCalls)
+	assert.Equal(t, registryUploader.uploadAndRegisterRead.String(), s3Uploader.uploadAndRegisterRead.String())
+
+	data, err := os.ReadFile(resultsPath)
+	require.NoError(t, err)
+	var results []map[string]any
+	require.NoError(t, json.Unmarshal(data, &results))
+	// the checksum and upload time are added to every result
+	assert.Equal(t, fmt.Sprintf("sha256:%x", sha256.Sum256(registryUploader.uploadAndRegisterRead.Bytes())), results[0]["checksum"])
+	assert.NotEmpty(t, results[0]["uploaded_at"])
+	delete(results[0], "checksum")
+	delete(results[0], "uploaded_at")
+	assert.Equal(t, []map[string]any{
+		{"target": "registry://quay.example/foo:tag", "provider": "aws"},
+		{"target": "s3", "error": "bucket is gone"},
+	}, results)
+}
+
+func TestBuildWithDuplicatedTargetErrors(t *testing.T) {
+	restore := main.MockOsArgs([]string{
+		"build",
+		"--to=aws",
+		"--to=aws",
+		"qcow2",
+		"--distro=centos-9",
+	})
+	defer restore()
+
+	err := main.Run()
+	assert.EqualError(t, err, `duplicated upload target "aws"`)
+}
+
+func TestBuildAndUploadToAzureGalleryMock(t *testing.T) {
+	restore := main.MockManifestgenDepsolver(fakeDepsolve)
+	defer restore()
+
+	restore = main.MockManifestgenContainerResolver(fakeContainerResolver)
+	defer restore()
+
+	restore = main.MockNewRepoRegistry(testrepos.New)
+	defer restore()
+
+	var uploadOpts *azure.UploaderOptions
+	var fa fakeAwsUploader
+	restore = main.MockAzureNewUploader(func(clientID, clientSecret, tenant, subscription, resourceGroup, name, imagePath string, architecture arch.Arch, opts *azure.UploaderOptions) (cloud.Uploader, error) {
+		uploadOpts = opts
+		return &fa, nil
+	})
+	defer restore()
+
+	outputDir := t.TempDir()
+	fakeOsbuildScript := makeFakeOsbuildScript()
+	testutil.MockCommand(t, "osbuild", fakeOsbuildScript)
+
+	var fakeStdout bytes.Buffer
+	restore = main.MockOsStdout(&fakeStdout)
+	defer restore()
+
+	restore = main.MockOsArgs([]string{
+		"build",
+		"--output-dir", outputDir,
+		"--to=azure",
+		"--azure-client-id=id",
+		"--azure-client-secret=secret",
+		"--azure-tenant=tenant",
+		"--azure-subscription=subscription",
+		"--azure-resource-group=rg",
+		"--azure-gallery=my_gallery",
+		"--azure-gallery-image-definition=my-def",
+		"--azure-gallery-version=1.2.3",
+		"qcow2",
+		"--distro=centos-9",
+	})
+	defer restore()
+
+	err := main.Run()
+	require.NoError(t, err)
+
+	// the boot mode of the image type selects the hyper v generation
+	expectedBootMode := expectedBootModeForCurrentArch()
+	assert.Equal(t, &expectedBootMode, uploadOpts.BootMode)
+	assert.Equal(t, 1, fa.checkCalls)
+	assert.Equal(t, 1, fa.uploadAndRegisterCalls)
+}
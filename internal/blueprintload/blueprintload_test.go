@@ -83,3 +83,92 @@ func TestBlueprintLoadJSON(t *testing.T) {
 		}
 	}
 }
+
+var testBlueprintLinesTOML = `name = "test"
+packages = [
+  "vim", # [not a table]
+]
+
+[customizations]
+hostname = "my-host"
+
+[[customizations.user]]
+name = "alice"
+
+[[customizations.user]]
+name = "bob"
+key = "ssh-ed25519 AAAA"
+
+[[customizations.disk.partitions]]
+type = "lvm"
+
+[[customizations.disk.partitions.logical_volumes]]
+mountpoint = "/var"
+
+[customizations.installer.kickstart]
+contents = """
+user --name = "carol"
+"""
+
+[customizations."kernel"]
+append = "debug"
+`
+
+var testBlueprintLinesJSON = `{
+  "name": "test",
+  "customizations": {
+    "user": [
+      {
+        "name": "alice"
+      },
+      {"name": "bob",
+       "key": "ssh-ed25519 AAAA"}
+    ]
+  }
+}`
+
+func TestKeyLines(t *testing.T) {
+	lines, err := blueprintload.KeyLines(makeTestBlueprint(t, "bp.toml", testBlueprintLinesTOML))
+	assert.NoError(t, err)
+	assert.Equal(t, map[string]int{
+		"name":                                                 1,
+		"packages":                                             2,
+		"customizations":                                       6,
+		"customizations.hostname":                              7,
+		"customizations.user":                                  9,
+		"customizations.user[0]":                               9,
+		"customizations.user[0].name":                          10,
+		"customizations.user[1]":                               12,
+		"customizations.user[1].name":                          13,
+		"customizations.user[1].key":                           14,
+		"customizations.disk":                                  16,
+		"customizations.disk.partitions":                       16,
+		"customizations.disk.partitions[0]":                    16,
+		"customizations.disk.partitions[0].type":               17,
+		"customizations.disk.partitions[0].logical_volumes":    19,
+		"customizations.disk.partitions[0].logical_volumes[0]": 19,
+		"customizations.disk.partitions[0].logical_volumes[0].mountpoint": 20,
+		"customizations.installer":                    22,
+		"customizations.installer.kickstart":          22,
+		"customizations.installer.kickstart.contents": 23,
+		"customizations.kernel":                       27,
+		"customizations.kernel.append":                28,
+	}, lines)
+
+	lines, err = blueprintload.KeyLines(makeTestBlueprint(t, "bp.json", testBlueprintLinesJSON))
+	assert.NoError(t, err)
+	assert.Equal(t, map[string]int{
+		"name":                        2,
+		"customizations":              3,
+		"customizations.user":         4,
+		"customizations.user[0]":      5,
+		"customizations.user[0].name": 6,
+		"customizations.user[1]":      8,
+		"customizations.user[1].name": 8,
+		"customizations.user[1].key":  9,
+	}, lines)
+
+	lines, err = blueprintload.KeyLines("-")
+	assert.NoError(t, err)
+	assert.Empty(t, lines)
+}
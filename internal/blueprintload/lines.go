@@ -0,0 +1,221 @@
+package blueprintload
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// KeyLines returns the line numbers of the keys of the blueprint file at
+// the given path. The keys are the paths to the options as the distro
+// validation reports them, e.g. "customizations.user[1].name". Array
+// elements and tables are included, e.g. "customizations.user[1]".
+//
+// Blueprints that are read from stdin cannot be read again so no lines
+// are returned for them.
+func KeyLines(path string) (map[string]int, error) {
+	if path == "" || path == "-" {
+		return map[string]int{}, nil
+	}
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("cannot open blueprint file %q: %w", path, err)
+	}
+
+	switch filepath.Ext(path) {
+	case ".json":
+		return jsonKeyLines(data)
+	case ".toml":
+		return tomlKeyLines(data), nil
+	default:
+		return nil, fmt.Errorf("unsupported file extension for %q (please use .toml or .json)", path)
+	}
+}
+
+func joinKey(parent, key string) string {
+	if parent == "" {
+		return key
+	}
+	return parent + "." + key
+}
+
+func jsonKeyLines(data []byte) (map[string]int, error) {
+	lines := make(map[string]int)
+	dec := json.NewDecoder(bytes.NewReader(data))
+	lineAt := func() int {
+		return bytes.Count(data[:dec.InputOffset()], []byte("\n")) + 1
+	}
+
+	var walk func(path string) error
+	walk = func(path string) error {
+		tok, err := dec.Token()
+		if err != nil {
+			return err
+		}
+		// array elements start at their first token
+		if _, ok := lines[path]; !ok && path != "" {
+			lines[path] = lineAt()
+		}
+		switch tok {
+		case json.Delim('{'):
+			for dec.More() {
+				key, err := dec.Token()
+				if err != nil {
+					return err
+				}
+				keyPath := joinKey(path, fmt.Sprint(key))
+				lines[keyPath] = lineAt()
+				if err := walk(keyPath); err != nil {
+					return err
+				}
+			}
+			_, err = dec.Token()
+			return err
+		case json.Delim('['):
+			for idx := 0; dec.More(); idx++ {
+				if err := walk(fmt.Sprintf("%s[%d]", path, idx)); err != nil {
+					return err
+				}
+			}
+			_, err = dec.Token()
+			return err
+		}
+		return nil
+	}
+	if err := walk(""); err != nil {
+		return nil, fmt.Errorf("cannot decode blueprint: %w", err)
+	}
+	return lines, nil
+}
+
+// stripTomlComment removes a trailing comment that is not part of a
+// string
+func stripTomlComment(line string) string {
+	var quote rune
+	for i, c := range line {
+		switch {
+		case quote != 0 && c == quote:
+			quote = 0
+		case quote == 0 && (c == '"' || c == '\''):
+			quote = c
+		case quote == 0 && c == '#':
+			return line[:i]
+		}
+	}
+	return line
+}
+
+// tomlNesting returns by how much the line opens (or closes) arrays and
+// inline tables outside of strings
+func tomlNesting(line string) int {
+	var quote rune
+	var depth int
+	for _, c := range line {
+		switch {
+		case quote != 0 && c == quote:
+			quote = 0
+		case quote != 0:
+		case c == '"' || c == '\'':
+			quote = c
+		case c == '[' || c == '{':
+			depth++
+		case c == ']' || c == '}':
+			depth--
+		}
+	}
+	return depth
+}
+
+func unquoteTomlKey(key string) string {
+	var parts []string
+	for _, part := range strings.Split(key, ".") {
+		parts = append(parts, strings.Trim(strings.TrimSpace(part), `"'`))
+	}
+	return strings.Join(parts, ".")
+}
+
+// tomlKeyLines finds the keys of a toml document line by line, this is
+// not a full toml parser but it understands the tables, arrays of tables
+// and keys that blueprints use
+func tomlKeyLines(data []byte) map[string]int {
+	lines := make(map[string]int)
+	// the index of the last element of every array of tables
+	arrays := make(map[string]int)
+	// resolve adds the indexes of the arrays of tables to a table name
+	resolve := func(name string) string {
+		var path string
+		for _, part := range strings.Split(name, ".") {
+			path = joinKey(path, part)
+			if idx, ok := arrays[path]; ok {
+				path = fmt.Sprintf("%s[%d]", path, idx)
+			}
+		}
+		return path
+	}
+	record := func(path string, lineno int) {
+		if _, ok := lines[path]; !ok {
+			lines[path] = lineno
+		}
+	}
+
+	var table string
+	var inMultilineString bool
+	// values can span multiple lines
+	var valueDepth int
+	scanner := bufio.NewScanner(bytes.NewReader(data))
+	for lineno := 1; scanner.Scan(); lineno++ {
+		line := scanner.Text()
+		if n := strings.Count(line, `"""`) + strings.Count(line, `'''`); n%2 == 1 {
+			wasInMultilineString := inMultilineString
+			inMultilineString = !inMultilineString
+			if wasInMultilineString {
+				continue
+			}
+		} else if inMultilineString {
+			continue
+		}
+		line = strings.TrimSpace(stripTomlComment(line))
+		if valueDepth > 0 {
+			valueDepth += tomlNesting(line)
+			continue
+		}
+
+		switch {
+		case strings.HasPrefix(line, "[["):
+			name := unquoteTomlKey(strings.TrimSuffix(strings.TrimPrefix(line, "[["), "]]"))
+			parent, last := "", name
+			if idx := strings.LastIndex(name, "."); idx >= 0 {
+				parent, last = name[:idx], name[idx+1:]
+			}
+			arrayPath := joinKey(resolve(parent), last)
+			if idx, ok := arrays[arrayPath]; ok {
+				arrays[arrayPath] = idx + 1
+			} else {
+				arrays[arrayPath] = 0
+			}
+			record(arrayPath, lineno)
+			table = fmt.Sprintf("%s[%d]", arrayPath, arrays[arrayPath])
+			record(table, lineno)
+		case strings.HasPrefix(line, "["):
+			table = resolve(unquoteTomlKey(strings.TrimSuffix(strings.TrimPrefix(line, "["), "]")))
+			record(table, lineno)
+		case strings.Contains(line, "="):
+			key, value, _ := strings.Cut(line, "=")
+			record(joinKey(table, unquoteTomlKey(key)), lineno)
+			valueDepth = max(tomlNesting(value), 0)
+		default:
+			continue
+		}
+		// the parents of a table or key start at their first use
+		path := table
+		for idx := strings.LastIndex(path, "."); idx >= 0; idx = strings.LastIndex(path, ".") {
+			path = path[:idx]
+			record(path, lineno)
+		}
+	}
+	return lines
+}
@@ -1,6 +1,8 @@
 package arch
 
 import (
+	"encoding/json"
+	"fmt"
 	"testing"
 
 	"github.com/stretchr/testify/assert"
@@ -107,3 +109,20 @@ func TestUnmarshal(t *testing.T) {
 		assert.Equal(t, tc.expected, v.Arch)
 	}
 }
+
+func TestMarshalJSONRoundtrip(t *testing.T) {
+	for _, a := range []Arch{ARCH_ARM, ARCH_AARCH64, ARCH_PPC64LE, ARCH_S390X, ARCH_X86_64, ARCH_RISCV64} {
+		var v struct {
+			Arch Arch
+		}
+		v.Arch = a
+		data, err := json.Marshal(v)
+		assert.NoError(t, err)
+		assert.Equal(t, fmt.Sprintf(`{"Arch":%q}`, a.String()), string(data))
+
+		v.Arch = ARCH_UNSET
+		err = json.Unmarshal(data, &v)
+		assert.NoError(t, err)
+		assert.Equal(t, a, v.Arch)
+	}
+}
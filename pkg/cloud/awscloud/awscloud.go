@@ -8,6 +8,7 @@ import (
 	"io"
 	"net/http"
 	"os"
+	"strings"
 	"time"
 
 	"slices"
@@ -37,6 +38,11 @@ var newSnapshotImportedWaiterEC2 = func(client ec2.DescribeImportSnapshotTasksAP
 	return ec2.NewSnapshotImportedWaiter(client, optFns...)
 }
 
+// Allow to mock the EC2 ImageAvailableWaiter for testing purposes
+var newImageAvailableWaiterEC2 = func(client ec2.DescribeImagesAPIClient, optFns ...func(*ec2.ImageAvailableWaiterOptions)) imageAvailableWaiterEC2 {
+	return ec2.NewImageAvailableWaiter(client, optFns...)
+}
+
 // Allow to mock the EC2 NewInstanceRunningWaiter for testing purposes
 var newInstanceRunningWaiterEC2 = func(client ec2.DescribeInstancesAPIClient, optFns ...func(*ec2.InstanceRunningWaiterOptions)) instanceRunningWaiterEC2 {
 	return ec2.NewInstanceRunningWaiter(client, optFns...)
@@ -125,16 +131,27 @@ func NewDefault(region string, profile string) (*AWS, error) {
 	return aws, nil
 }
 
-// Create a new session from the credentials and the region and returns an *AWS object initialized with it.
-func newAwsFromCredsWithEndpoint(optsFunc config.LoadOptionsFunc, region, endpoint, caBundle string, skipSSLVerification bool) (*AWS, error) {
-	// Create a Session with a custom region
-	optionFuncs := []func(*config.LoadOptions) error{
-		config.WithRegion(region),
-		optsFunc,
-	}
-
-	if caBundle != "" {
-		caBundleReader, err := os.Open(caBundle)
+// S3Options configures the S3 client of an AWS object, this is useful
+// for S3-compatible object storage like MinIO or Ceph RGW.
+type S3Options struct {
+	// Endpoint overrides the default S3 endpoint of the region
+	Endpoint string
+	// CABundle is the path to a PEM file with additional CAs to trust
+	CABundle string
+	// SkipSSLVerification disables the verification of the server certificate
+	SkipSSLVerification bool
+	// UsePathStyle addresses buckets as https://endpoint/bucket
+	// instead of https://bucket.endpoint
+	UsePathStyle bool
+	// PartSize is the size of the parts of a multipart upload in
+	// bytes. If zero, the transfer manager default is used.
+	PartSize int64
+}
+
+// Create a new session from the given config options and the S3 options and returns an *AWS object initialized with it.
+func newAwsWithS3Options(optionFuncs []func(*config.LoadOptions) error, s3Opts *S3Options) (*AWS, error) {
+	if s3Opts.CABundle != "" {
+		caBundleReader, err := os.Open(s3Opts.CABundle)
 		if err != nil {
 			return nil, err
 		}
@@ -142,7 +159,7 @@ func newAwsFromCredsWithEndpoint(optsFunc config.LoadOptionsFunc, region, endpoi
 		optionFuncs = append(optionFuncs, config.WithCustomCABundle(caBundleReader))
 	}
 
-	if skipSSLVerification {
+	if s3Opts.SkipSSLVerification {
 		transport := http.DefaultTransport.(*http.Transport).Clone()
 		transport.TLSClientConfig = &tls.Config{InsecureSkipVerify: true} // #nosec G402
 		optionFuncs = append(optionFuncs, config.WithHTTPClient(&http.Client{
@@ -159,18 +176,52 @@ func newAwsFromCredsWithEndpoint(optsFunc config.LoadOptionsFunc, region, endpoi
 	}
 
 	s3cli := s3.NewFromConfig(cfg, func(options *s3.Options) {
-		options.BaseEndpoint = aws.String(endpoint)
-		options.UsePathStyle = true
+		if s3Opts.Endpoint != "" {
+			options.BaseEndpoint = aws.String(s3Opts.Endpoint)
+		}
+		options.UsePathStyle = s3Opts.UsePathStyle
 	})
 
 	return &AWS{
-		ec2:        ec2.NewFromConfig(cfg),
-		s3:         s3cli,
-		s3uploader: transfermanager.New(s3cli),
-		s3presign:  s3.NewPresignClient(s3cli),
+		ec2: ec2.NewFromConfig(cfg),
+		s3:  s3cli,
+		s3uploader: transfermanager.New(s3cli, func(options *transfermanager.Options) {
+			options.PartSizeBytes = s3Opts.PartSize
+		}),
+		s3presign: s3.NewPresignClient(s3cli),
 	}, nil
 }
 
+// Create a new session from the credentials and the region and returns an *AWS object initialized with it.
+func newAwsFromCredsWithEndpoint(optsFunc config.LoadOptionsFunc, region, endpoint, caBundle string, skipSSLVerification bool) (*AWS, error) {
+	// Create a Session with a custom region
+	optionFuncs := []func(*config.LoadOptions) error{
+		config.WithRegion(region),
+		optsFunc,
+	}
+	return newAwsWithS3Options(optionFuncs, &S3Options{
+		Endpoint:            endpoint,
+		CABundle:            caBundle,
+		SkipSSLVerification: skipSSLVerification,
+		UsePathStyle:        true,
+	})
+}
+
+// Initialize a new AWS object from defaults with custom S3 options.
+// Looks for env variables, shared credential file, and EC2 Instance Roles.
+func NewForS3(region, profile string, s3Opts *S3Options) (*AWS, error) {
+	if s3Opts == nil {
+		s3Opts = &S3Options{}
+	}
+	optionFuncs := []func(*config.LoadOptions) error{
+		config.WithRegion(region),
+	}
+	if profile != "" {
+		optionFuncs = append(optionFuncs, config.WithSharedConfigProfile(profile))
+	}
+	return newAwsWithS3Options(optionFuncs, s3Opts)
+}
+
 // Initialize a new AWS object targeting a specific endpoint from individual bits. SessionToken is optional
 func NewForEndpoint(endpoint, region, accessKeyID, accessKey, sessionToken, caBundle string, skipSSLVerification bool) (*AWS, error) {
 	return newAwsFromCredsWithEndpoint(config.WithCredentialsProvider(credentials.NewStaticCredentialsProvider(accessKeyID, accessKey, sessionToken)), region, endpoint, caBundle, skipSSLVerification)
@@ -377,6 +428,46 @@ func (a *AWS) Register(name, bucket, key string, tags []AWSTag, shareWith []stri
 	return imageID, snapshotID, nil
 }
 
+// CopyImage copies the AMI with the given id from the source region
+// into the region of the client, including its snapshot and tags, and
+// waits until the copy is available.
+func (a *AWS) CopyImage(name, ami, sourceRegion string) (string, error) {
+	olog.Printf("[AWS] 📋 Copying AMI %s from %s", ami, sourceRegion)
+	copyOutput, err := a.ec2.CopyImage(
+		context.TODO(),
+		&ec2.CopyImageInput{
+			Name:          aws.String(name),
+			SourceImageId: aws.String(ami),
+			SourceRegion:  aws.String(sourceRegion),
+			CopyImageTags: aws.Bool(true),
+		},
+	)
+	if err != nil {
+		return "", err
+	}
+	imageID := aws.ToString(copyOutput.ImageId)
+
+	olog.Printf("[AWS] 🚚 Waiting for AMI copy to become available: %s", imageID)
+	if err := a.WaitForImageAvailable(imageID); err != nil {
+		return "", err
+	}
+	olog.Printf("[AWS] 🎉 AMI copied: %s", imageID)
+	return imageID, nil
+}
+
+// WaitForImageAvailable waits until the AMI with the given id is
+// available, e.g. before it gets copied to other regions.
+func (a *AWS) WaitForImageAvailable(ami string) error {
+	imgWaiter := newImageAvailableWaiterEC2(a.ec2)
+	return imgWaiter.Wait(
+		context.TODO(),
+		&ec2.DescribeImagesInput{
+			ImageIds: []string{ami},
+		},
+		time.Hour*24,
+	)
+}
+
 func (a *AWS) DeleteObject(bucket, key string) error {
 	_, err := a.s3.DeleteObject(
 		context.TODO(),
@@ -388,9 +479,24 @@ func (a *AWS) DeleteObject(bucket, key string) error {
 	return err
 }
 
+// isOrganizationARN returns true if the given principal is the ARN of
+// an organization or an organizational unit instead of an account ID
+func isOrganizationARN(principal string) bool {
+	return strings.HasPrefix(principal, "arn:") && (strings.Contains(principal, ":organization/") || strings.Contains(principal, ":ou/"))
+}
+
 // ShareImage shares the AMI and its associated snapshots with the specified user IDs.
+// The user IDs may also contain organization or organizational unit ARNs, these
+// only get launch permissions for the AMI as snapshots cannot be shared with them.
 // If no snapshot IDs are provided, it will find the snapshot IDs associated with the AMI.
 func (a *AWS) ShareImage(ami string, snapshotIDs, userIDs []string) error {
+	var accountIDs []string
+	for _, userID := range userIDs {
+		if !isOrganizationARN(userID) {
+			accountIDs = append(accountIDs, userID)
+		}
+	}
+
 	// If no snapshot IDs are provided, we will try to find the snapshot IDs
 	// associated with the AMI.
 	if len(snapshotIDs) == 0 {
@@ -412,10 +518,14 @@ func (a *AWS) ShareImage(ami string, snapshotIDs, userIDs []string) error {
 		}
 	}
 
-	for _, snapshotID := range snapshotIDs {
-		err := a.shareSnapshot(snapshotID, userIDs)
-		if err != nil {
-			return err
+	// snapshots cannot be shared with organizations
+	organizationsOnly := len(userIDs) > 0 && len(accountIDs) == 0
+	if !organizationsOnly {
+		for _, snapshotID := range snapshotIDs {
+			err := a.shareSnapshot(snapshotID, accountIDs)
+			if err != nil {
+				return err
+			}
 		}
 	}
 
@@ -430,10 +540,21 @@ func (a *AWS) shareImage(ami string, userIDs []string) error {
 	olog.Println("[AWS] 💿 Sharing ec2 AMI")
 	var launchPerms []ec2types.LaunchPermission
 
-	for idx := range userIDs {
-		launchPerms = append(launchPerms, ec2types.LaunchPermission{
-			UserId: aws.String(userIDs[idx]),
-		})
+	for _, userID := range userIDs {
+		switch {
+		case isOrganizationARN(userID) && strings.Contains(userID, ":ou/"):
+			launchPerms = append(launchPerms, ec2types.LaunchPermission{
+				OrganizationalUnitArn: aws.String(userID),
+			})
+		case isOrganizationARN(userID):
+			launchPerms = append(launchPerms, ec2types.LaunchPermission{
+				OrganizationArn: aws.String(userID),
+			})
+		default:
+			launchPerms = append(launchPerms, ec2types.LaunchPermission{
+				UserId: aws.String(userID),
+			})
+		}
 	}
 	_, err := a.ec2.ModifyImageAttribute(
 		context.TODO(),
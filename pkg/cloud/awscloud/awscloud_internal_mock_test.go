@@ -81,3 +81,27 @@ func MockNewTerminateInstancesWaiterEC2(err error) (restore func()) {
 		newTerminateInstancesWaiterEC2 = original
 	}
 }
+
+type fakeNewImageAvailableWaiterEC2 struct {
+	returnDescribeImagesErr error
+}
+
+func (f *fakeNewImageAvailableWaiterEC2) Wait(ctx context.Context, params *ec2.DescribeImagesInput, maxWaitDur time.Duration, optFns ...func(*ec2.ImageAvailableWaiterOptions)) error {
+	if f.returnDescribeImagesErr != nil {
+		return f.returnDescribeImagesErr
+	}
+	return nil
+}
+
+func MockNewImageAvailableWaiterEC2(err error) (restore func()) {
+	original := newImageAvailableWaiterEC2
+	newImageAvailableWaiterEC2 = func(client ec2.DescribeImagesAPIClient, optFns ...func(*ec2.ImageAvailableWaiterOptions)) imageAvailableWaiterEC2 {
+		return &fakeNewImageAvailableWaiterEC2{
+			returnDescribeImagesErr: err,
+		}
+	}
+
+	return func() {
+		newImageAvailableWaiterEC2 = original
+	}
+}
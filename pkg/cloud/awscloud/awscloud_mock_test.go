@@ -45,6 +45,10 @@ type fakeEC2Client struct {
 	registerImage      *ec2.RegisterImageOutput
 	registerImageErr   error
 
+	copyImageCalls []*ec2.CopyImageInput
+	copyImage      *ec2.CopyImageOutput
+	copyImageErr   error
+
 	deregisterImageCalls []*ec2.DeregisterImageInput
 	deregisterImage      *ec2.DeregisterImageOutput
 	deregisterImageErr   error
@@ -144,6 +148,14 @@ func (f *fakeEC2Client) RegisterImage(ctx context.Context, input *ec2.RegisterIm
 	return f.registerImage, nil
 }
 
+func (f *fakeEC2Client) CopyImage(ctx context.Context, input *ec2.CopyImageInput, optFns ...func(*ec2.Options)) (*ec2.CopyImageOutput, error) {
+	f.copyImageCalls = append(f.copyImageCalls, input)
+	if f.copyImageErr != nil {
+		return nil, f.copyImageErr
+	}
+	return f.copyImage, nil
+}
+
 func (f *fakeEC2Client) DeregisterImage(ctx context.Context, input *ec2.DeregisterImageInput, optFns ...func(*ec2.Options)) (*ec2.DeregisterImageOutput, error) {
 	f.deregisterImageCalls = append(f.deregisterImageCalls, input)
 	if f.deregisterImageErr != nil {
@@ -508,6 +508,100 @@ func TestShareImage(t *testing.T) {
 	}
 }
 
+func TestShareImageWithOrganizations(t *testing.T) {
+	knownImageId := "ami-1234567890abcdef0"
+	knownSnapshotId := "snap-1234567890abcdef0"
+	orgARN := "arn:aws:organizations::123456789012:organization/o-123example"
+	ouARN := "arn:aws:organizations::123456789012:ou/o-123example/ou-1234-5example"
+
+	for _, tc := range []struct {
+		name                 string
+		shareWith            []string
+		expectedSnapshotUser []string
+	}{
+		{"organizations only", []string{orgARN, ouARN}, nil},
+		{"accounts and organizations", []string{"098765432109", orgARN}, []string{"098765432109"}},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			fec2 := &fakeEC2Client{}
+			client := awscloud.NewAWSForTest(fec2, nil, nil, nil)
+			err := client.ShareImage(knownImageId, []string{knownSnapshotId}, tc.shareWith)
+			require.NoError(t, err)
+
+			if tc.expectedSnapshotUser == nil {
+				require.Len(t, fec2.modifySnapshotAttributeCalls, 0)
+			} else {
+				require.Len(t, fec2.modifySnapshotAttributeCalls, 1)
+				require.Equal(t, tc.expectedSnapshotUser, fec2.modifySnapshotAttributeCalls[0].UserIds)
+			}
+
+			require.Len(t, fec2.modifyImageAttributeCalls, 1)
+			launchPerms := fec2.modifyImageAttributeCalls[0].LaunchPermission.Add
+			require.Len(t, launchPerms, len(tc.shareWith))
+			for idx, principal := range tc.shareWith {
+				switch principal {
+				case orgARN:
+					require.Equal(t, orgARN, aws.ToString(launchPerms[idx].OrganizationArn))
+				case ouARN:
+					require.Equal(t, ouARN, aws.ToString(launchPerms[idx].OrganizationalUnitArn))
+				default:
+					require.Equal(t, principal, aws.ToString(launchPerms[idx].UserId))
+				}
+			}
+		})
+	}
+}
+
+func TestCopyImage(t *testing.T) {
+	for _, tc := range []struct {
+		name      string
+		fec2      *fakeEC2Client
+		waiterErr error
+		errMsg    string
+	}{
+		{
+			name: "happy path",
+			fec2: &fakeEC2Client{
+				copyImage: &ec2.CopyImageOutput{ImageId: aws.String("ami-copy")},
+			},
+		},
+		{
+			name: "error: copy image failure",
+			fec2: &fakeEC2Client{
+				copyImageErr: fmt.Errorf("copy image error"),
+			},
+			errMsg: "copy image error",
+		},
+		{
+			name: "error: waiter failure",
+			fec2: &fakeEC2Client{
+				copyImage: &ec2.CopyImageOutput{ImageId: aws.String("ami-copy")},
+			},
+			waiterErr: fmt.Errorf("waiter error"),
+			errMsg:    "waiter error",
+		},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			restore := awscloud.MockNewImageAvailableWaiterEC2(tc.waiterErr)
+			defer restore()
+
+			client := awscloud.NewAWSForTest(tc.fec2, nil, nil, nil)
+			imageID, err := client.CopyImage("image-name", "ami-source", "us-east-1")
+			require.Len(t, tc.fec2.copyImageCalls, 1)
+			require.Equal(t, "image-name", aws.ToString(tc.fec2.copyImageCalls[0].Name))
+			require.Equal(t, "ami-source", aws.ToString(tc.fec2.copyImageCalls[0].SourceImageId))
+			require.Equal(t, "us-east-1", aws.ToString(tc.fec2.copyImageCalls[0].SourceRegion))
+			require.True(t, aws.ToBool(tc.fec2.copyImageCalls[0].CopyImageTags))
+			if tc.errMsg != "" {
+				require.EqualError(t, err, tc.errMsg)
+				return
+			}
+			require.NoError(t, err)
+			require.Equal(t, "ami-copy", imageID)
+		})
+	}
+}
+
 func TestRegions(t *testing.T) {
 	type testCase struct {
 		name      string
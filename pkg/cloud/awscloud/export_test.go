@@ -31,3 +31,13 @@ func MockNewAwsClient(f func(string, string) (awsClient, error)) (restore func()
 		newAwsClient = saved
 	}
 }
+
+type S3UploaderClient = s3UploaderClient
+
+func MockNewS3UploaderClient(f func(string, string, *S3Options) (s3UploaderClient, error)) (restore func()) {
+	saved := newS3UploaderClient
+	newS3UploaderClient = f
+	return func() {
+		newS3UploaderClient = saved
+	}
+}
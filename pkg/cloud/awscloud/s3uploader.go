@@ -0,0 +1,117 @@
+package awscloud
+
+import (
+	"fmt"
+	"io"
+	"slices"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/feature/s3/transfermanager"
+
+	"github.com/osbuild/image-builder/pkg/cloud"
+)
+
+// MinS3PartSize is the smallest part size S3 accepts for multipart uploads
+const MinS3PartSize = 5 * 1024 * 1024
+
+type s3ObjectUploader struct {
+	client s3UploaderClient
+
+	region     string
+	bucketName string
+	objectKey  string
+	presign    bool
+}
+
+type S3UploaderOptions struct {
+	S3Options
+
+	Region  string
+	Profile string
+	// Presign generates a presigned URL for the uploaded object
+	// that is valid for 7 days.
+	Presign bool
+}
+
+// testing support
+type s3UploaderClient interface {
+	Buckets() ([]string, error)
+	UploadFromReader(io.Reader, string, string) (*transfermanager.UploadObjectOutput, error)
+	S3ObjectPresignedURL(string, string) (string, error)
+}
+
+var newS3UploaderClient = func(region, profile string, s3Opts *S3Options) (s3UploaderClient, error) {
+	return NewForS3(region, profile, s3Opts)
+}
+
+// NewS3Uploader returns a cloud.Uploader that stores the image as
+// objectKey in the given bucket of an S3-compatible object storage
+// without registering it anywhere.
+func NewS3Uploader(bucketName, objectKey string, opts *S3UploaderOptions) (cloud.Uploader, error) {
+	if opts == nil {
+		opts = &S3UploaderOptions{}
+	}
+	if opts.PartSize != 0 && opts.PartSize < MinS3PartSize {
+		return nil, fmt.Errorf("S3 part size must be at least %d bytes, got %d", MinS3PartSize, opts.PartSize)
+	}
+
+	client, err := newS3UploaderClient(opts.Region, opts.Profile, &opts.S3Options)
+	if err != nil {
+		return nil, err
+	}
+
+	return &s3ObjectUploader{
+		client:     client,
+		region:     opts.Region,
+		bucketName: bucketName,
+		objectKey:  objectKey,
+		presign:    opts.Presign,
+	}, nil
+}
+
+var _ cloud.Uploader = &s3ObjectUploader{}
+
+func (su *s3ObjectUploader) Check(status io.Writer) error {
+	fmt.Fprintf(status, "Checking S3 bucket...\n")
+	buckets, err := su.client.Buckets()
+	if err != nil {
+		return fmt.Errorf("retrieving S3 list of buckets failed: %w", err)
+	}
+	if !slices.Contains(buckets, su.bucketName) {
+		return fmt.Errorf("bucket '%s' not found with the given S3 credentials", su.bucketName)
+	}
+	fmt.Fprintf(status, "Upload conditions met.\n")
+	return nil
+}
+
+func (su *s3ObjectUploader) UploadAndRegister(r io.Reader, _ uint64, status io.Writer) (*cloud.UploadResult, error) {
+	fmt.Fprintf(status, "Uploading to %s:%s\n", su.bucketName, su.objectKey)
+	res, err := su.client.UploadFromReader(r, su.bucketName, su.objectKey)
+	if err != nil {
+		return nil, err
+	}
+	location := aws.ToString(res.Location)
+	fmt.Fprintf(status, "File uploaded to %s\n", location)
+
+	url := location
+	if su.presign {
+		url, err = su.client.S3ObjectPresignedURL(su.bucketName, su.objectKey)
+		if err != nil {
+			return nil, err
+		}
+		fmt.Fprintf(status, "Presigned URL: %s\n", url)
+	}
+
+	return &cloud.UploadResult{
+		Provider: "s3",
+		ImageID:  fmt.Sprintf("%s/%s", su.bucketName, su.objectKey),
+		URL:      url,
+		S3: &cloud.S3UploadResult{
+			Region:   su.region,
+			Bucket:   su.bucketName,
+			Key:      su.objectKey,
+			Location: location,
+			ETag:     aws.ToString(res.ETag),
+		},
+	}, nil
+}
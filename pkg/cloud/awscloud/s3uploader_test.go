@@ -0,0 +1,167 @@
+package awscloud_test
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"testing"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/feature/s3/transfermanager"
+	"github.com/stretchr/testify/assert"
+
+	"github.com/osbuild/image-builder/pkg/cloud"
+	"github.com/osbuild/image-builder/pkg/cloud/awscloud"
+)
+
+type fakeS3UploaderClient struct {
+	buckets    []string
+	bucketsErr error
+
+	uploadFromReader    *transfermanager.UploadObjectOutput
+	uploadFromReaderErr error
+	uploadedBucket      string
+	uploadedKey         string
+	uploadedData        []byte
+
+	presignedURL      string
+	presignedURLErr   error
+	presignedURLCalls int
+}
+
+func (fs *fakeS3UploaderClient) Buckets() ([]string, error) {
+	return fs.buckets, fs.bucketsErr
+}
+
+func (fs *fakeS3UploaderClient) UploadFromReader(r io.Reader, bucket, key string) (*transfermanager.UploadObjectOutput, error) {
+	data, err := io.ReadAll(r)
+	if err != nil {
+		return nil, err
+	}
+	fs.uploadedBucket = bucket
+	fs.uploadedKey = key
+	fs.uploadedData = data
+	return fs.uploadFromReader, fs.uploadFromReaderErr
+}
+
+func (fs *fakeS3UploaderClient) S3ObjectPresignedURL(bucket, key string) (string, error) {
+	fs.presignedURLCalls++
+	return fs.presignedURL, fs.presignedURLErr
+}
+
+func mockS3UploaderClient(t *testing.T, fs *fakeS3UploaderClient) *awscloud.S3Options {
+	var gotOpts awscloud.S3Options
+	restore := awscloud.MockNewS3UploaderClient(func(region, profile string, s3Opts *awscloud.S3Options) (awscloud.S3UploaderClient, error) {
+		gotOpts = *s3Opts
+		return fs, nil
+	})
+	t.Cleanup(restore)
+	return &gotOpts
+}
+
+func TestS3UploaderCheck(t *testing.T) {
+	for _, tc := range []struct {
+		buckets     []string
+		bucketsErr  error
+		expectedErr string
+	}{
+		{[]string{"other", "bucket"}, nil, ""},
+		{[]string{"other"}, nil, "bucket 'bucket' not found with the given S3 credentials"},
+		{nil, fmt.Errorf("fake-err"), "retrieving S3 list of buckets failed: fake-err"},
+	} {
+		fs := &fakeS3UploaderClient{buckets: tc.buckets, bucketsErr: tc.bucketsErr}
+		mockS3UploaderClient(t, fs)
+
+		uploader, err := awscloud.NewS3Uploader("bucket", "key", nil)
+		assert.NoError(t, err)
+		var statusLog bytes.Buffer
+		err = uploader.Check(&statusLog)
+		if tc.expectedErr == "" {
+			assert.NoError(t, err)
+			assert.Equal(t, "Checking S3 bucket...\nUpload conditions met.\n", statusLog.String())
+		} else {
+			assert.EqualError(t, err, tc.expectedErr)
+		}
+	}
+}
+
+func TestS3UploaderUpload(t *testing.T) {
+	fs := &fakeS3UploaderClient{
+		uploadFromReader: &transfermanager.UploadObjectOutput{
+			Location: aws.String("https://minio.example.com/bucket/disk.qcow2"),
+		},
+	}
+	gotOpts := mockS3UploaderClient(t, fs)
+
+	uploader, err := awscloud.NewS3Uploader("bucket", "disk.qcow2", &awscloud.S3UploaderOptions{
+		S3Options: awscloud.S3Options{
+			Endpoint:     "https://minio.example.com",
+			UsePathStyle: true,
+			PartSize:     64 * 1024 * 1024,
+		},
+	})
+	assert.NoError(t, err)
+	assert.Equal(t, "https://minio.example.com", gotOpts.Endpoint)
+	assert.True(t, gotOpts.UsePathStyle)
+	assert.Equal(t, int64(64*1024*1024), gotOpts.PartSize)
+
+	var uploadLog bytes.Buffer
+	result, err := uploader.UploadAndRegister(bytes.NewBufferString("fake-image"), 0, &uploadLog)
+	assert.NoError(t, err)
+	assert.Equal(t, "s3", result.Provider)
+	assert.Equal(t, "bucket/disk.qcow2", result.ImageID)
+	assert.Equal(t, "https://minio.example.com/bucket/disk.qcow2", result.URL)
+	assert.Equal(t, &cloud.S3UploadResult{
+		Bucket:   "bucket",
+		Key:      "disk.qcow2",
+		Location: "https://minio.example.com/bucket/disk.qcow2",
+	}, result.S3)
+	assert.Equal(t, "bucket", fs.uploadedBucket)
+	assert.Equal(t, "disk.qcow2", fs.uploadedKey)
+	assert.Equal(t, "fake-image", string(fs.uploadedData))
+	assert.Equal(t, 0, fs.presignedURLCalls)
+	expectedUploadLog := `Uploading to bucket:disk.qcow2
+File uploaded to https://minio.example.com/bucket/disk.qcow2
+`
+	assert.Equal(t, expectedUploadLog, uploadLog.String())
+}
+
+func TestS3UploaderUploadPresign(t *testing.T) {
+	fs := &fakeS3UploaderClient{
+		uploadFromReader: &transfermanager.UploadObjectOutput{
+			Location: aws.String("some-location"),
+		},
+		presignedURL: "https://presigned",
+	}
+	mockS3UploaderClient(t, fs)
+
+	uploader, err := awscloud.NewS3Uploader("bucket", "key", &awscloud.S3UploaderOptions{Presign: true})
+	assert.NoError(t, err)
+	var uploadLog bytes.Buffer
+	result, err := uploader.UploadAndRegister(bytes.NewBufferString("fake-image"), 0, &uploadLog)
+	assert.NoError(t, err)
+	assert.Equal(t, "https://presigned", result.URL)
+	assert.Equal(t, 1, fs.presignedURLCalls)
+	assert.Contains(t, uploadLog.String(), "Presigned URL: https://presigned\n")
+}
+
+func TestS3UploaderUploadError(t *testing.T) {
+	fs := &fakeS3UploaderClient{
+		uploadFromReaderErr: fmt.Errorf("fake-upload-err"),
+	}
+	mockS3UploaderClient(t, fs)
+
+	uploader, err := awscloud.NewS3Uploader("bucket", "key", &awscloud.S3UploaderOptions{Presign: true})
+	assert.NoError(t, err)
+	result, err := uploader.UploadAndRegister(bytes.NewBufferString("fake-image"), 0, io.Discard)
+	assert.EqualError(t, err, "fake-upload-err")
+	assert.Nil(t, result)
+	assert.Equal(t, 0, fs.presignedURLCalls)
+}
+
+func TestS3UploaderPartSizeTooSmall(t *testing.T) {
+	_, err := awscloud.NewS3Uploader("bucket", "key", &awscloud.S3UploaderOptions{
+		S3Options: awscloud.S3Options{PartSize: 1024},
+	})
+	assert.EqualError(t, err, "S3 part size must be at least 5242880 bytes, got 1024")
+}
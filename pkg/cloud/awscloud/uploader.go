@@ -4,7 +4,10 @@ import (
 	"errors"
 	"fmt"
 	"io"
+	"maps"
 	"slices"
+	"strings"
+	"sync"
 
 	"github.com/aws/aws-sdk-go-v2/aws"
 	"github.com/aws/aws-sdk-go-v2/feature/s3/transfermanager"
@@ -26,6 +29,9 @@ type awsUploader struct {
 	tags       []AWSTag
 	targetArch arch.Arch
 	bootMode   *platform.BootMode
+
+	copyToRegions []string
+	shareWith     []string
 }
 
 type UploaderOptions struct {
@@ -34,6 +40,13 @@ type UploaderOptions struct {
 	BootMode *platform.BootMode
 	Profile  string
 	Tags     []AWSTag
+	// CopyToRegions is a list of additional regions the AMI is
+	// copied to after it got registered.
+	CopyToRegions []string
+	// ShareWith is a list of account IDs and organization or
+	// organizational unit ARNs the AMI (and all its copies) is
+	// shared with.
+	ShareWith []string
 }
 
 type AWSTag struct {
@@ -49,6 +62,9 @@ type awsClient interface {
 	UploadFromReader(io.Reader, string, string) (*transfermanager.UploadObjectOutput, error)
 	Register(name, bucket, key string, tags []AWSTag, shareWith []string, architecture arch.Arch, bootMode *platform.BootMode, importRole *string) (string, string, error)
 	DeleteObject(string, string) error
+	CopyImage(name, ami, sourceRegion string) (string, error)
+	WaitForImageAvailable(ami string) error
+	ShareImage(ami string, snapshotIDs, userIDs []string) error
 }
 
 var newAwsClient = func(region string, profile string) (awsClient, error) {
@@ -74,6 +90,9 @@ func NewUploader(region, bucketName, imageName string, opts *UploaderOptions) (c
 		tags:       opts.Tags,
 		targetArch: opts.TargetArch,
 		bootMode:   opts.BootMode,
+
+		copyToRegions: opts.CopyToRegions,
+		shareWith:     opts.ShareWith,
 	}, nil
 }
 
@@ -89,6 +108,11 @@ func (au *awsUploader) Check(status io.Writer) error {
 	if !slices.Contains(regions, au.region) {
 		return fmt.Errorf("given AWS region '%s' not found", au.region)
 	}
+	for _, region := range au.copyToRegions {
+		if !slices.Contains(regions, region) {
+			return fmt.Errorf("given AWS region '%s' to copy the AMI to not found", region)
+		}
+	}
 
 	fmt.Fprintf(status, "Checking AWS bucket...\n")
 	buckets, err := au.client.Buckets()
@@ -119,8 +143,9 @@ func (au *awsUploader) UploadAndRegister(r io.Reader, _ uint64, status io.Writer
 	if err != nil {
 		return nil, err
 	}
+	objectDeleted := false
 	defer func() {
-		if err != nil {
+		if err != nil && !objectDeleted {
 			aErr := au.client.DeleteObject(au.bucketName, keyName)
 			fmt.Fprintf(status, "Deleted S3 object %s:%s\n", au.bucketName, keyName)
 			err = errors.Join(err, aErr)
@@ -132,7 +157,7 @@ func (au *awsUploader) UploadAndRegister(r io.Reader, _ uint64, status io.Writer
 	}
 
 	fmt.Fprintf(status, "Registering AMI %s\n", au.imageName)
-	ami, snapshot, err := au.client.Register(au.imageName, au.bucketName, keyName, au.tags, nil, au.targetArch, au.bootMode, nil)
+	ami, snapshot, err := au.client.Register(au.imageName, au.bucketName, keyName, au.tags, au.shareWith, au.targetArch, au.bootMode, nil)
 	if err != nil {
 		return nil, err
 	}
@@ -141,10 +166,87 @@ func (au *awsUploader) UploadAndRegister(r io.Reader, _ uint64, status io.Writer
 	if err := au.client.DeleteObject(au.bucketName, keyName); err != nil {
 		return nil, err
 	}
+	objectDeleted = true
 	fmt.Fprintf(status, "AMI registered: %s\nSnapshot ID: %s\n", ami, snapshot)
 
-	return &cloud.UploadResult{
+	result = &cloud.UploadResult{
 		Provider: "aws",
 		ImageID:  ami,
-	}, nil
+		AWS: &cloud.AWSUploadResult{
+			Region:     au.region,
+			SnapshotID: snapshot,
+			SharedWith: au.shareWith,
+		},
+	}
+	if len(au.copyToRegions) > 0 {
+		// the registered AMI and the successful copies are returned
+		// even if some copies failed
+		copies, err := au.copyImage(ami, status)
+		result.ImageIDs = map[string]string{au.region: ami}
+		maps.Copy(result.ImageIDs, copies)
+		if err != nil {
+			return result, fmt.Errorf("AMI %s registered but copying failed: %w", ami, err)
+		}
+	}
+
+	return result, nil
+}
+
+// copyImage copies the AMI into all the regions of copyToRegions at
+// the same time and shares the copies. It returns the AMI IDs of the
+// successful copies by region, together with the errors of the failed
+// ones.
+func (au *awsUploader) copyImage(ami string, status io.Writer) (map[string]string, error) {
+	// a freshly registered AMI is pending and cannot be copied yet
+	fmt.Fprintf(status, "Waiting for AMI %s to become available\n", ami)
+	if err := au.client.WaitForImageAvailable(ami); err != nil {
+		return nil, err
+	}
+	fmt.Fprintf(status, "Copying AMI %s to %s\n", ami, strings.Join(au.copyToRegions, ", "))
+
+	var mu sync.Mutex
+	var wg sync.WaitGroup
+	errs := make([]error, len(au.copyToRegions))
+	copies := make(map[string]string, len(au.copyToRegions))
+	for i, region := range au.copyToRegions {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			copied, err := au.copyImageToRegion(ami, region)
+			mu.Lock()
+			defer mu.Unlock()
+			if err != nil {
+				errs[i] = fmt.Errorf("region %s: %w", region, err)
+			}
+			// copies that could not be shared still exist
+			if copied != "" {
+				copies[region] = copied
+			}
+		}()
+	}
+	wg.Wait()
+
+	for _, region := range au.copyToRegions {
+		if copied, ok := copies[region]; ok {
+			fmt.Fprintf(status, "AMI copied to %s: %s\n", region, copied)
+		}
+	}
+	return copies, errors.Join(errs...)
+}
+
+func (au *awsUploader) copyImageToRegion(ami, region string) (string, error) {
+	client, err := newAwsClient(region, au.profile)
+	if err != nil {
+		return "", err
+	}
+	copied, err := client.CopyImage(au.imageName, ami, au.region)
+	if err != nil {
+		return "", err
+	}
+	if len(au.shareWith) > 0 {
+		if err := client.ShareImage(copied, nil, au.shareWith); err != nil {
+			return copied, fmt.Errorf("cannot share %s: %w", copied, err)
+		}
+	}
+	return copied, nil
 }
@@ -44,6 +44,16 @@ type fakeAWSClient struct {
 
 	deleteObjectErr   error
 	deleteObjectCalls int
+
+	copyImageId     string
+	copyImageErr    error
+	copyImageSource []string
+
+	waitForImageErr   error
+	waitForImageCalls []string
+
+	shareImageErr   error
+	shareImageCalls [][]string
 }
 
 func (fa *fakeAWSClient) Regions() ([]string, error) {
@@ -77,6 +87,21 @@ func (fa *fakeAWSClient) DeleteObject(string, string) error {
 	return fa.deleteObjectErr
 }
 
+func (fa *fakeAWSClient) CopyImage(name, ami, sourceRegion string) (string, error) {
+	fa.copyImageSource = append(fa.copyImageSource, fmt.Sprintf("%s:%s", sourceRegion, ami))
+	return fa.copyImageId, fa.copyImageErr
+}
+
+func (fa *fakeAWSClient) WaitForImageAvailable(ami string) error {
+	fa.waitForImageCalls = append(fa.waitForImageCalls, ami)
+	return fa.waitForImageErr
+}
+
+func (fa *fakeAWSClient) ShareImage(ami string, snapshotIDs, userIDs []string) error {
+	fa.shareImageCalls = append(fa.shareImageCalls, append([]string{ami}, userIDs...))
+	return fa.shareImageErr
+}
+
 func TestUploaderCheckHappy(t *testing.T) {
 	fa := &fakeAWSClient{
 		regions:               []string{"region"},
@@ -161,6 +186,8 @@ func TestUploaderUploadHappy(t *testing.T) {
 			assert.NoError(t, err)
 			assert.Equal(t, "aws", result.Provider)
 			assert.Equal(t, "image-id", result.ImageID)
+			assert.Equal(t, "region", result.AWS.Region)
+			assert.Equal(t, "snapshot-id", result.AWS.SnapshotID)
 			assert.Equal(t, 1, fa.uploadFromReaderCalls)
 			assert.Equal(t, 1, fa.registerCalls)
 			assert.Equal(t, 1, fa.deleteObjectCalls)
@@ -232,3 +259,142 @@ func TestUploaderUploadButRegisterErrorAndDeleteError(t *testing.T) {
 	assert.EqualError(t, err, "fake-register-err\nfake-delete-object-err")
 	assert.Nil(t, result)
 }
+
+func TestUploaderUploadCopyAndShare(t *testing.T) {
+	uuid.SetRand(&repeatReader{})
+
+	fa := &fakeAWSClient{
+		uploadFromReader: &transfermanager.UploadObjectOutput{
+			Location: aws.String("some-location"),
+		},
+		registerImageId:    "image-id",
+		registerSnapshotId: "snapshot-id",
+	}
+	regionClients := map[string]*fakeAWSClient{
+		"region": fa,
+		"eu-west-1": {
+			copyImageId: "image-id-eu",
+		},
+		"ap-south-1": {
+			copyImageId: "image-id-ap",
+		},
+	}
+	restore := awscloud.MockNewAwsClient(func(region string, profile string) (awscloud.AwsClient, error) {
+		return regionClients[region], nil
+	})
+	defer restore()
+
+	fakeImage := bytes.NewBufferString("fake-aws-image")
+	uploader, err := awscloud.NewUploader("region", "bucket", "ami", &awscloud.UploaderOptions{
+		CopyToRegions: []string{"eu-west-1", "ap-south-1"},
+		ShareWith:     []string{"123456789012"},
+	})
+	assert.NoError(t, err)
+	var uploadLog bytes.Buffer
+	result, err := uploader.UploadAndRegister(fakeImage, 0, &uploadLog)
+	assert.NoError(t, err)
+	assert.Equal(t, "image-id", result.ImageID)
+	assert.Equal(t, map[string]string{
+		"region":     "image-id",
+		"eu-west-1":  "image-id-eu",
+		"ap-south-1": "image-id-ap",
+	}, result.ImageIDs)
+	assert.Equal(t, []string{"123456789012"}, result.AWS.SharedWith)
+	for _, region := range []string{"eu-west-1", "ap-south-1"} {
+		rc := regionClients[region]
+		assert.Equal(t, []string{"region:image-id"}, rc.copyImageSource)
+		assert.Equal(t, [][]string{{rc.copyImageId, "123456789012"}}, rc.shareImageCalls)
+	}
+	// the AMI is available before it gets copied
+	assert.Equal(t, []string{"image-id"}, fa.waitForImageCalls)
+	assert.Contains(t, uploadLog.String(), `AMI registered: image-id
+Snapshot ID: snapshot-id
+Waiting for AMI image-id to become available
+Copying AMI image-id to eu-west-1, ap-south-1
+AMI copied to eu-west-1: image-id-eu
+AMI copied to ap-south-1: image-id-ap
+`)
+}
+
+func TestUploaderUploadCopyError(t *testing.T) {
+	fa := &fakeAWSClient{
+		uploadFromReader: &transfermanager.UploadObjectOutput{
+			Location: aws.String("some-location"),
+		},
+		registerImageId: "image-id",
+	}
+	regionClients := map[string]*fakeAWSClient{
+		"region": fa,
+		"eu-west-1": {
+			copyImageErr: fmt.Errorf("fake-copy-err"),
+		},
+		"ap-south-1": {
+			copyImageId: "image-id-ap",
+		},
+	}
+	restore := awscloud.MockNewAwsClient(func(region string, profile string) (awscloud.AwsClient, error) {
+		return regionClients[region], nil
+	})
+	defer restore()
+
+	fakeImage := bytes.NewBufferString("fake-aws-image")
+	uploader, err := awscloud.NewUploader("region", "bucket", "ami", &awscloud.UploaderOptions{
+		CopyToRegions: []string{"eu-west-1", "ap-south-1"},
+	})
+	assert.NoError(t, err)
+	result, err := uploader.UploadAndRegister(fakeImage, 0, io.Discard)
+	assert.EqualError(t, err, "AMI image-id registered but copying failed: region eu-west-1: fake-copy-err")
+	// the registered AMI and the successful copies are still returned
+	assert.Equal(t, "image-id", result.ImageID)
+	assert.Equal(t, map[string]string{
+		"region":     "image-id",
+		"ap-south-1": "image-id-ap",
+	}, result.ImageIDs)
+	// the S3 object is only deleted once
+	assert.Equal(t, 1, fa.deleteObjectCalls)
+}
+
+func TestUploaderUploadCopyWaitError(t *testing.T) {
+	fa := &fakeAWSClient{
+		uploadFromReader: &transfermanager.UploadObjectOutput{
+			Location: aws.String("some-location"),
+		},
+		registerImageId: "image-id",
+		waitForImageErr: fmt.Errorf("fake-wait-err"),
+	}
+	restore := awscloud.MockNewAwsClient(func(string, string) (awscloud.AwsClient, error) {
+		return fa, nil
+	})
+	defer restore()
+
+	fakeImage := bytes.NewBufferString("fake-aws-image")
+	uploader, err := awscloud.NewUploader("region", "bucket", "ami", &awscloud.UploaderOptions{
+		CopyToRegions: []string{"eu-west-1"},
+	})
+	assert.NoError(t, err)
+	result, err := uploader.UploadAndRegister(fakeImage, 0, io.Discard)
+	assert.EqualError(t, err, "AMI image-id registered but copying failed: fake-wait-err")
+	assert.Equal(t, "image-id", result.ImageID)
+	assert.Equal(t, map[string]string{"region": "image-id"}, result.ImageIDs)
+	// nothing gets copied
+	assert.Empty(t, fa.copyImageSource)
+}
+
+func TestUploaderCheckCopyRegions(t *testing.T) {
+	fa := &fakeAWSClient{
+		regions:               []string{"region", "eu-west-1"},
+		buckets:               []string{"bucket"},
+		checkBucketPermission: true,
+	}
+	restore := awscloud.MockNewAwsClient(func(string, string) (awscloud.AwsClient, error) {
+		return fa, nil
+	})
+	defer restore()
+
+	uploader, err := awscloud.NewUploader("region", "bucket", "ami", &awscloud.UploaderOptions{
+		CopyToRegions: []string{"eu-west-1", "mars-1"},
+	})
+	assert.NoError(t, err)
+	err = uploader.Check(io.Discard)
+	assert.EqualError(t, err, "given AWS region 'mars-1' to copy the AMI to not found")
+}
@@ -44,6 +44,7 @@ type GalleriesClient interface {
 }
 
 type GalleryImagesClient interface {
+	Get(context.Context, string, string, string, *armcompute.GalleryImagesClientGetOptions) (armcompute.GalleryImagesClientGetResponse, error)
 	BeginCreateOrUpdate(context.Context, string, string, string, armcompute.GalleryImage, *armcompute.GalleryImagesClientBeginCreateOrUpdateOptions) (*runtime.Poller[armcompute.GalleryImagesClientCreateOrUpdateResponse], error)
 	BeginDelete(context.Context, string, string, string, *armcompute.GalleryImagesClientBeginDeleteOptions) (*runtime.Poller[armcompute.GalleryImagesClientDeleteResponse], error)
 }
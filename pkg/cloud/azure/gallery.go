@@ -11,6 +11,7 @@ import (
 	"github.com/osbuild/image-builder/internal/common"
 	"github.com/osbuild/image-builder/pkg/arch"
 	"github.com/osbuild/image-builder/pkg/olog"
+	"github.com/osbuild/image-builder/pkg/platform"
 )
 
 type GalleryImage struct {
@@ -75,7 +76,9 @@ func (ac Client) RegisterGalleryImage(ctx context.Context, resourceGroup, storag
 		location,
 		galleryImage.Gallery,
 		galleryImage.ImageDef,
+		"1.0.0",
 		fmt.Sprintf("/subscriptions/%s/resourceGroups/%s/providers/Microsoft.Compute/images/%s", ac.subscription, resourceGroup, managedImg),
+		nil,
 	)
 	if err != nil {
 		return nil, err
@@ -95,6 +98,127 @@ func (ac Client) RegisterGalleryImage(ctx context.Context, resourceGroup, storag
 	return &galleryImage, nil
 }
 
+// GalleryImageDefinition contains the properties of an existing gallery
+// image definition that new image versions must be compatible with.
+type GalleryImageDefinition struct {
+	HyperVGen     HyperVGenerationType
+	TrustedLaunch bool
+	Architecture  arch.Arch
+}
+
+// GetGalleryImageDefinition returns the properties of the given image
+// definition in the given gallery.
+func (ac Client) GetGalleryImageDefinition(ctx context.Context, resourceGroup, gallery, name string) (*GalleryImageDefinition, error) {
+	resp, err := ac.galleryImgs.Get(ctx, resourceGroup, gallery, name, nil)
+	if err != nil {
+		return nil, fmt.Errorf("cannot get image definition %q in gallery %q: %w", name, gallery, err)
+	}
+
+	// azure defaults to V1 and x64 if not set
+	def := &GalleryImageDefinition{
+		HyperVGen:    HyperVGenV1,
+		Architecture: arch.ARCH_X86_64,
+	}
+	props := resp.Properties
+	if props == nil {
+		return def, nil
+	}
+	if props.HyperVGeneration != nil && *props.HyperVGeneration == armcompute.HyperVGenerationV2 {
+		def.HyperVGen = HyperVGenV2
+	}
+	if props.Architecture != nil && *props.Architecture == armcompute.ArchitectureArm64 {
+		def.Architecture = arch.ARCH_AARCH64
+	}
+	for _, feature := range props.Features {
+		if feature == nil || feature.Name == nil || feature.Value == nil {
+			continue
+		}
+		// all trusted launch and confidential VM security types
+		// need UEFI (Gen2)
+		if *feature.Name == "SecurityType" && *feature.Value != "Standard" {
+			def.TrustedLaunch = true
+		}
+	}
+	return def, nil
+}
+
+// HyperVGenFor returns the hyper v generation an image with the given
+// boot mode must be registered with to be published into the image
+// definition. Images that support both boot modes (or have an unknown
+// boot mode) use the generation of the definition.
+func (gd *GalleryImageDefinition) HyperVGenFor(bootMode *platform.BootMode) (HyperVGenerationType, error) {
+	if bootMode == nil {
+		return gd.HyperVGen, nil
+	}
+	switch *bootMode {
+	case platform.BOOT_LEGACY:
+		if gd.TrustedLaunch {
+			return "", fmt.Errorf("image definition requires trusted launch but the image only supports legacy BIOS boot")
+		}
+		if gd.HyperVGen != HyperVGenV1 {
+			return "", fmt.Errorf("image definition is %s but the image only supports legacy BIOS boot", gd.HyperVGen)
+		}
+		return HyperVGenV1, nil
+	case platform.BOOT_UEFI:
+		if gd.HyperVGen != HyperVGenV2 {
+			return "", fmt.Errorf("image definition is %s but the image only supports UEFI boot", gd.HyperVGen)
+		}
+		return HyperVGenV2, nil
+	default:
+		return gd.HyperVGen, nil
+	}
+}
+
+// PublishGalleryImageVersion registers the specified blob as a managed
+// image and publishes it as the given version of an existing gallery
+// image definition. The version is replicated into the location of the
+// resource group and the given regions. It returns the reference of
+// the new image version.
+func (ac Client) PublishGalleryImageVersion(ctx context.Context, resourceGroup, storageAccount, storageContainer, blobName, gallery, imageDef, version, location string, hyperVGen HyperVGenerationType, regions []string) (string, error) {
+	var err error
+	if location == "" {
+		location, err = ac.GetResourceGroupLocation(ctx, resourceGroup)
+		if err != nil {
+			return "", fmt.Errorf("retrieving resource group location failed: %w", err)
+		}
+	}
+
+	managedImg := fmt.Sprintf("%s-%s", imageDef, version)
+	err = ac.RegisterImage(ctx, resourceGroup, storageAccount, storageContainer, blobName, managedImg, location, hyperVGen)
+	if err != nil {
+		return "", err
+	}
+
+	imgVersion, err := ac.createGalleryImageVersion(
+		ctx,
+		resourceGroup,
+		location,
+		gallery,
+		imageDef,
+		version,
+		fmt.Sprintf("/subscriptions/%s/resourceGroups/%s/providers/Microsoft.Compute/images/%s", ac.subscription, resourceGroup, managedImg),
+		regions,
+	)
+	if err != nil {
+		if dErr := ac.DeleteImage(ctx, resourceGroup, managedImg); dErr != nil {
+			olog.Printf("unable to delete managed image: %s", dErr.Error())
+		}
+		return "", err
+	}
+	if imgVersion.Name == nil {
+		return "", fmt.Errorf("Image version in image definition %s in gallery %s is empty", imageDef, gallery)
+	}
+
+	return fmt.Sprintf(
+		"/subscriptions/%s/resourceGroups/%s/providers/Microsoft.Compute/galleries/%s/images/%s/versions/%s",
+		ac.subscription,
+		resourceGroup,
+		gallery,
+		imageDef,
+		*imgVersion.Name,
+	), nil
+}
+
 func (ac Client) createGallery(ctx context.Context, resourceGroup, location, name string) (*armcompute.Gallery, error) {
 	poller, err := ac.galleries.BeginCreateOrUpdate(ctx, resourceGroup, name, armcompute.Gallery{
 		Location: &location,
@@ -155,16 +279,27 @@ func (ac Client) createGalleryImageDef(ctx context.Context, resourceGroup, locat
 	return &resp.GalleryImage, nil
 }
 
-func (ac Client) createGalleryImageVersion(ctx context.Context, resourceGroup, location, gallery, image, uri string) (*armcompute.GalleryImageVersion, error) {
-	poller, err := ac.galleryImgVs.BeginCreateOrUpdate(ctx, resourceGroup, gallery, image, "1.0.0", armcompute.GalleryImageVersion{
+func (ac Client) createGalleryImageVersion(ctx context.Context, resourceGroup, location, gallery, image, version, uri string, regions []string) (*armcompute.GalleryImageVersion, error) {
+	// the version must always be available in its own location
+	targetRegions := []*armcompute.TargetRegion{
+		{
+			Name: &location,
+		},
+	}
+	for _, region := range regions {
+		if region == location {
+			continue
+		}
+		targetRegions = append(targetRegions, &armcompute.TargetRegion{
+			Name: common.ToPtr(region),
+		})
+	}
+
+	poller, err := ac.galleryImgVs.BeginCreateOrUpdate(ctx, resourceGroup, gallery, image, version, armcompute.GalleryImageVersion{
 		Location: &location,
 		Properties: &armcompute.GalleryImageVersionProperties{
 			PublishingProfile: &armcompute.GalleryImageVersionPublishingProfile{
-				TargetRegions: []*armcompute.TargetRegion{
-					{
-						Name: &location,
-					},
-				},
+				TargetRegions: targetRegions,
 			},
 			StorageProfile: &armcompute.GalleryImageVersionStorageProfile{
 				Source: &armcompute.GalleryArtifactVersionFullSource{
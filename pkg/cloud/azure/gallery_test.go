@@ -9,6 +9,7 @@ import (
 	"github.com/osbuild/image-builder/internal/common"
 	"github.com/osbuild/image-builder/pkg/arch"
 	"github.com/osbuild/image-builder/pkg/cloud/azure"
+	"github.com/osbuild/image-builder/pkg/platform"
 )
 
 func TestRegisterGalleryImage(t *testing.T) {
@@ -122,3 +123,112 @@ func TestRegisterGalleryImage(t *testing.T) {
 	require.Equal(t, "rg", azm.im.delete[0].rg)
 	require.Equal(t, "img-name-mimg", azm.im.delete[0].name)
 }
+
+func TestPublishGalleryImageVersion(t *testing.T) {
+	azm := newAZ()
+
+	imageRef, err := azm.az.PublishGalleryImageVersion(
+		t.Context(),
+		"rg",
+		"storacc",
+		"storcontainer",
+		"blobname",
+		"my_gallery",
+		"my-def",
+		"1.2.3",
+		"",
+		azure.HyperVGenV2,
+		[]string{"test-universe", "westeurope"},
+	)
+	require.NoError(t, err)
+	require.Equal(t, "/subscriptions/test-subscription/resourceGroups/rg/providers/Microsoft.Compute/galleries/my_gallery/images/my-def/versions/1.2.3", imageRef)
+
+	// no gallery or image definition is created
+	require.Len(t, azm.gm.createOrUpdate, 0)
+	require.Len(t, azm.gim.createOrUpdate, 0)
+
+	require.Len(t, azm.im.createOrUpdate, 1)
+	require.Equal(t, "my-def-1.2.3", azm.im.createOrUpdate[0].name)
+	require.Equal(t, common.ToPtr(armcompute.HyperVGenerationTypesV2), azm.im.createOrUpdate[0].img.Properties.HyperVGeneration)
+
+	require.Len(t, azm.givm.createOrUpdate, 1)
+	require.Equal(t, "my_gallery", azm.givm.createOrUpdate[0].gallery)
+	require.Equal(t, "my-def", azm.givm.createOrUpdate[0].img)
+	require.Equal(t, "1.2.3", azm.givm.createOrUpdate[0].name)
+	require.Equal(t, []*armcompute.TargetRegion{
+		{
+			Name: common.ToPtr("test-universe"),
+		},
+		{
+			Name: common.ToPtr("westeurope"),
+		},
+	}, azm.givm.createOrUpdate[0].version.Properties.PublishingProfile.TargetRegions)
+}
+
+func TestGetGalleryImageDefinition(t *testing.T) {
+	azm := newAZ()
+	azm.gim.image = armcompute.GalleryImage{
+		Properties: &armcompute.GalleryImageProperties{
+			Architecture:     common.ToPtr(armcompute.ArchitectureArm64),
+			HyperVGeneration: common.ToPtr(armcompute.HyperVGenerationV2),
+			Features: []*armcompute.GalleryImageFeature{
+				{
+					Name:  common.ToPtr("SecurityType"),
+					Value: common.ToPtr("TrustedLaunchSupported"),
+				},
+			},
+		},
+	}
+
+	def, err := azm.az.GetGalleryImageDefinition(t.Context(), "rg", "my_gallery", "my-def")
+	require.NoError(t, err)
+	require.Equal(t, &azure.GalleryImageDefinition{
+		HyperVGen:     azure.HyperVGenV2,
+		TrustedLaunch: true,
+		Architecture:  arch.ARCH_AARCH64,
+	}, def)
+	require.Len(t, azm.gim.get, 1)
+	require.Equal(t, "my_gallery", azm.gim.get[0].gallery)
+	require.Equal(t, "my-def", azm.gim.get[0].name)
+
+	// defaults
+	azm.gim.image = armcompute.GalleryImage{}
+	def, err = azm.az.GetGalleryImageDefinition(t.Context(), "rg", "my_gallery", "my-def")
+	require.NoError(t, err)
+	require.Equal(t, &azure.GalleryImageDefinition{
+		HyperVGen:    azure.HyperVGenV1,
+		Architecture: arch.ARCH_X86_64,
+	}, def)
+}
+
+func TestGalleryImageDefinitionHyperVGenFor(t *testing.T) {
+	gen1 := &azure.GalleryImageDefinition{HyperVGen: azure.HyperVGenV1}
+	gen2 := &azure.GalleryImageDefinition{HyperVGen: azure.HyperVGenV2}
+	trustedLaunch := &azure.GalleryImageDefinition{HyperVGen: azure.HyperVGenV2, TrustedLaunch: true}
+
+	for _, tc := range []struct {
+		def         *azure.GalleryImageDefinition
+		bootMode    *platform.BootMode
+		expected    azure.HyperVGenerationType
+		expectedErr string
+	}{
+		{gen1, nil, azure.HyperVGenV1, ""},
+		{gen2, nil, azure.HyperVGenV2, ""},
+		{gen1, common.ToPtr(platform.BOOT_HYBRID), azure.HyperVGenV1, ""},
+		{gen2, common.ToPtr(platform.BOOT_HYBRID), azure.HyperVGenV2, ""},
+		{gen1, common.ToPtr(platform.BOOT_LEGACY), azure.HyperVGenV1, ""},
+		{gen2, common.ToPtr(platform.BOOT_UEFI), azure.HyperVGenV2, ""},
+		{trustedLaunch, common.ToPtr(platform.BOOT_UEFI), azure.HyperVGenV2, ""},
+		{gen2, common.ToPtr(platform.BOOT_LEGACY), "", "image definition is V2 but the image only supports legacy BIOS boot"},
+		{gen1, common.ToPtr(platform.BOOT_UEFI), "", "image definition is V1 but the image only supports UEFI boot"},
+		{trustedLaunch, common.ToPtr(platform.BOOT_LEGACY), "", "image definition requires trusted launch but the image only supports legacy BIOS boot"},
+	} {
+		hyperVGen, err := tc.def.HyperVGenFor(tc.bootMode)
+		if tc.expectedErr != "" {
+			require.EqualError(t, err, tc.expectedErr)
+			continue
+		}
+		require.NoError(t, err)
+		require.Equal(t, tc.expected, hyperVGen)
+	}
+}
@@ -488,8 +488,19 @@ func (gm *galleriesMock) BeginDelete(ctx context.Context, rg, name string, optio
 }
 
 type galleryImagesMock struct {
+	get            []galleryImagesGetArgs
 	createOrUpdate []galleryImagesCreateOrUpdateArgs
 	delete         []galleryImagesDeleteArgs
+
+	// returned by Get
+	image armcompute.GalleryImage
+}
+
+type galleryImagesGetArgs struct {
+	rg      string
+	gallery string
+	name    string
+	options *armcompute.GalleryImagesClientGetOptions
 }
 
 type galleryImagesCreateOrUpdateArgs struct {
@@ -507,6 +518,15 @@ type galleryImagesDeleteArgs struct {
 	options *armcompute.GalleryImagesClientBeginDeleteOptions
 }
 
+func (gim *galleryImagesMock) Get(ctx context.Context, rg, gallery, name string, options *armcompute.GalleryImagesClientGetOptions) (armcompute.GalleryImagesClientGetResponse, error) {
+	gim.get = append(gim.get, galleryImagesGetArgs{rg, gallery, name, options})
+	image := gim.image
+	image.Name = &name
+	return armcompute.GalleryImagesClientGetResponse{
+		GalleryImage: image,
+	}, nil
+}
+
 func (gim *galleryImagesMock) BeginCreateOrUpdate(ctx context.Context, rg, gallery, name string, image armcompute.GalleryImage, options *armcompute.GalleryImagesClientBeginCreateOrUpdateOptions) (*runtime.Poller[armcompute.GalleryImagesClientCreateOrUpdateResponse], error) {
 	gim.createOrUpdate = append(gim.createOrUpdate, galleryImagesCreateOrUpdateArgs{rg, gallery, name, image, options})
 	image.Name = &name
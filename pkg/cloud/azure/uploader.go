@@ -7,6 +7,7 @@ import (
 
 	"github.com/osbuild/image-builder/pkg/arch"
 	"github.com/osbuild/image-builder/pkg/cloud"
+	"github.com/osbuild/image-builder/pkg/platform"
 )
 
 const uploaderStorageContainer = "images"
@@ -19,9 +20,40 @@ type azureUploader struct {
 	imageName     string
 	imagePath     string
 	architecture  arch.Arch
+
+	gallery            string
+	galleryImageDef    string
+	galleryImageVer    string
+	replicationRegions []string
+	bootMode           *platform.BootMode
+}
+
+type UploaderOptions struct {
+	// Gallery, GalleryImageDefinition and GalleryImageVersion publish
+	// the image as a new version of an existing Azure Compute Gallery
+	// image definition instead of registering a managed image.
+	Gallery                string
+	GalleryImageDefinition string
+	GalleryImageVersion    string
+	// ReplicationRegions for the gallery image version, the location
+	// of the resource group is always included.
+	ReplicationRegions []string
+	// BootMode of the image, used to select the hyper v generation
+	// for the gallery image definition. If nil, the generation of
+	// the definition is used.
+	BootMode *platform.BootMode
 }
 
-func NewUploader(clientID, clientSecret, tenant, subscription, resourceGroup, imageName, imagePath string, architecture arch.Arch) (cloud.Uploader, error) {
+func NewUploader(clientID, clientSecret, tenant, subscription, resourceGroup, imageName, imagePath string, architecture arch.Arch, opts *UploaderOptions) (cloud.Uploader, error) {
+	if opts == nil {
+		opts = &UploaderOptions{}
+	}
+	if opts.Gallery != "" || opts.GalleryImageDefinition != "" || opts.GalleryImageVersion != "" {
+		if opts.Gallery == "" || opts.GalleryImageDefinition == "" || opts.GalleryImageVersion == "" {
+			return nil, fmt.Errorf("publishing into a gallery needs the gallery, the image definition and the version")
+		}
+	}
+
 	creds := Credentials{
 		ClientID:     clientID,
 		ClientSecret: clientSecret,
@@ -37,9 +69,32 @@ func NewUploader(clientID, clientSecret, tenant, subscription, resourceGroup, im
 		imageName:     imageName,
 		imagePath:     imagePath,
 		architecture:  architecture,
+
+		gallery:            opts.Gallery,
+		galleryImageDef:    opts.GalleryImageDefinition,
+		galleryImageVer:    opts.GalleryImageVersion,
+		replicationRegions: opts.ReplicationRegions,
+		bootMode:           opts.BootMode,
 	}, nil
 }
 
+// galleryHyperVGen returns the hyper v generation the image must be
+// registered with to publish it into the gallery image definition
+func (au *azureUploader) galleryHyperVGen(ctx context.Context) (HyperVGenerationType, error) {
+	def, err := au.client.GetGalleryImageDefinition(ctx, au.resourceGroup, au.gallery, au.galleryImageDef)
+	if err != nil {
+		return "", err
+	}
+	if def.Architecture != au.architecture {
+		return "", fmt.Errorf("image definition %q is for %s but the image is for %s", au.galleryImageDef, def.Architecture, au.architecture)
+	}
+	hyperVGen, err := def.HyperVGenFor(au.bootMode)
+	if err != nil {
+		return "", fmt.Errorf("cannot publish into %q: %w", au.galleryImageDef, err)
+	}
+	return hyperVGen, nil
+}
+
 func (au *azureUploader) Check(status io.Writer) error {
 	ctx := context.Background()
 	fmt.Fprintf(status, "Checking Azure resource group...\n")
@@ -47,10 +102,30 @@ func (au *azureUploader) Check(status io.Writer) error {
 	if err != nil {
 		return fmt.Errorf("cannot access resource group %q: %w", au.resourceGroup, err)
 	}
+	if au.gallery != "" {
+		fmt.Fprintf(status, "Checking Azure gallery image definition...\n")
+		if _, err := au.galleryHyperVGen(ctx); err != nil {
+			return err
+		}
+	}
 	fmt.Fprintf(status, "Upload conditions met.\n")
 	return nil
 }
 
+func (au *azureUploader) uploadResult(imageID, location, storageAccount, blobName string) *cloud.UploadResult {
+	return &cloud.UploadResult{
+		Provider: "azure",
+		ImageID:  imageID,
+		Azure: &cloud.AzureUploadResult{
+			SubscriptionID: au.client.SubscriptionID(),
+			ResourceGroup:  au.resourceGroup,
+			Location:       location,
+			StorageAccount: storageAccount,
+			BlobURL:        fmt.Sprintf("https://%s.blob.core.windows.net/%s/%s", storageAccount, uploaderStorageContainer, blobName),
+		},
+	}
+}
+
 func (au *azureUploader) UploadAndRegister(_ io.Reader, _ uint64, status io.Writer) (*cloud.UploadResult, error) {
 	ctx := context.Background()
 
@@ -107,6 +182,37 @@ func (au *azureUploader) UploadAndRegister(_ io.Reader, _ uint64, status io.Writ
 		return nil, err
 	}
 
+	if au.gallery != "" {
+		hyperVGen, err := au.galleryHyperVGen(ctx)
+		if err != nil {
+			return nil, err
+		}
+		fmt.Fprintf(status, "Publishing version %s of gallery image %s...\n", au.galleryImageVer, au.galleryImageDef)
+		imageRef, err := au.client.PublishGalleryImageVersion(
+			ctx,
+			au.resourceGroup,
+			stacc,
+			uploaderStorageContainer,
+			blobName,
+			au.gallery,
+			au.galleryImageDef,
+			au.galleryImageVer,
+			location,
+			hyperVGen,
+			au.replicationRegions,
+		)
+		if err != nil {
+			return nil, err
+		}
+		fmt.Fprintf(status, "Gallery image version published: %s\n", imageRef)
+		result := au.uploadResult(imageRef, location, stacc, blobName)
+		result.Azure.Gallery = au.gallery
+		result.Azure.GalleryImageDefinition = au.galleryImageDef
+		result.Azure.GalleryImageVersion = au.galleryImageVer
+		result.Azure.ReplicationRegions = au.replicationRegions
+		return result, nil
+	}
+
 	switch au.architecture {
 	case arch.ARCH_X86_64:
 		fmt.Fprintf(status, "Registering image %s...\n", au.imageName)
@@ -127,10 +233,7 @@ func (au *azureUploader) UploadAndRegister(_ io.Reader, _ uint64, status io.Writ
 			"/subscriptions/%s/resourceGroups/%s/providers/Microsoft.Compute/images/%s",
 			au.client.SubscriptionID(), au.resourceGroup, au.imageName)
 		fmt.Fprintf(status, "Image registered: %s\n", imageID)
-		return &cloud.UploadResult{
-			Provider: "azure",
-			ImageID:  imageID,
-		}, nil
+		return au.uploadResult(imageID, location, stacc, blobName), nil
 	case arch.ARCH_AARCH64:
 		fmt.Fprintf(status, "Registering gallery image %s...\n", au.imageName)
 		gi, err := au.client.RegisterGalleryImage(
@@ -148,10 +251,7 @@ func (au *azureUploader) UploadAndRegister(_ io.Reader, _ uint64, status io.Writ
 			return nil, err
 		}
 		fmt.Fprintf(status, "Gallery image registered: %s\n", gi.ImageRef)
-		return &cloud.UploadResult{
-			Provider: "azure",
-			ImageID:  gi.ImageRef,
-		}, nil
+		return au.uploadResult(gi.ImageRef, location, stacc, blobName), nil
 	default:
 		return nil, fmt.Errorf("unsupported architecture %q for Azure upload", au.architecture)
 	}
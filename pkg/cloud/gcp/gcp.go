@@ -8,6 +8,8 @@ import (
 	compute "cloud.google.com/go/compute/apiv1"
 	"cloud.google.com/go/storage"
 	"golang.org/x/oauth2/google"
+	"google.golang.org/api/cloudresourcemanager/v1"
+	"google.golang.org/api/option"
 )
 
 // GCPCredentialsEnvName contains name of the environment variable used
@@ -69,3 +71,24 @@ func NewFromFile(path string) (*GCP, error) {
 func (g *GCP) GetProjectID() string {
 	return g.creds.ProjectID
 }
+
+// ProjectTestPermissions returns the subset of the given permissions the
+// credentials have on the project used for all GCP operations.
+//
+// Uses:
+//   - Cloud Resource Manager API
+func (g *GCP) ProjectTestPermissions(ctx context.Context, permissions []string) ([]string, error) {
+	crmService, err := cloudresourcemanager.NewService(ctx, option.WithCredentials(g.creds))
+	if err != nil {
+		return nil, fmt.Errorf("failed to get Resource Manager client: %v", err)
+	}
+
+	req := &cloudresourcemanager.TestIamPermissionsRequest{
+		Permissions: permissions,
+	}
+	resp, err := crmService.Projects.TestIamPermissions(g.GetProjectID(), req).Context(ctx).Do()
+	if err != nil {
+		return nil, fmt.Errorf("failed to test permissions of project %q: %v", g.GetProjectID(), err)
+	}
+	return resp.Permissions, nil
+}
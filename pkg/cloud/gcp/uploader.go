@@ -0,0 +1,167 @@
+package gcp
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"slices"
+
+	"cloud.google.com/go/compute/apiv1/computepb"
+	"cloud.google.com/go/storage"
+	"github.com/google/uuid"
+
+	"github.com/osbuild/image-builder/pkg/cloud"
+)
+
+type gcpUploader struct {
+	client gcpClient
+
+	bucketName      string
+	imageName       string
+	regions         []string
+	shareWith       []string
+	guestOsFeatures []*computepb.GuestOsFeature
+}
+
+type UploaderOptions struct {
+	// CredentialsFile is the path to a file with service account
+	// credentials. If empty, the default credentials are used.
+	CredentialsFile string
+	// Regions where the resulting image should be stored. If empty
+	// the region of the bucket is used.
+	Regions []string
+	// ShareWith is a list of accounts the image is shared with,
+	// see ComputeImageShare() for the format.
+	ShareWith []string
+	// GuestOsFeatures to set on the imported image, see
+	// GuestOsFeaturesByDistro()
+	GuestOsFeatures []*computepb.GuestOsFeature
+}
+
+// testing support
+type gcpClient interface {
+	GetProjectID() string
+	StorageBucketTestPermissions(ctx context.Context, bucket string, permissions []string) ([]string, error)
+	ProjectTestPermissions(ctx context.Context, permissions []string) ([]string, error)
+	StorageObjectUploadFromReader(ctx context.Context, r io.Reader, bucket, object string, metadata map[string]string) (*storage.ObjectAttrs, error)
+	StorageObjectDelete(ctx context.Context, bucket, object string) error
+	ComputeImageInsert(ctx context.Context, bucket, object, imageName string, regions []string, guestOsFeatures []*computepb.GuestOsFeature) (*computepb.Image, error)
+	ComputeImageShare(ctx context.Context, imageName string, shareWith []string) error
+	ComputeImageURL(imageName string) string
+}
+
+var newGcpClient = func(credentialsFile string) (gcpClient, error) {
+	if credentialsFile != "" {
+		return NewFromFile(credentialsFile)
+	}
+	return New(nil)
+}
+
+func NewUploader(bucketName, imageName string, opts *UploaderOptions) (cloud.Uploader, error) {
+	if opts == nil {
+		opts = &UploaderOptions{}
+	}
+
+	client, err := newGcpClient(opts.CredentialsFile)
+	if err != nil {
+		return nil, err
+	}
+
+	return &gcpUploader{
+		client:          client,
+		bucketName:      bucketName,
+		imageName:       imageName,
+		regions:         opts.Regions,
+		shareWith:       opts.ShareWith,
+		guestOsFeatures: opts.GuestOsFeatures,
+	}, nil
+}
+
+var _ cloud.Uploader = &gcpUploader{}
+
+func missingPermissions(wanted, granted []string) []string {
+	var missing []string
+	for _, perm := range wanted {
+		if !slices.Contains(granted, perm) {
+			missing = append(missing, perm)
+		}
+	}
+	return missing
+}
+
+func (gu *gcpUploader) Check(status io.Writer) error {
+	ctx := context.Background()
+
+	fmt.Fprintf(status, "Checking GCP bucket permissions...\n")
+	bucketPerms := []string{"storage.objects.create", "storage.objects.delete"}
+	granted, err := gu.client.StorageBucketTestPermissions(ctx, gu.bucketName, bucketPerms)
+	if err != nil {
+		return err
+	}
+	if missing := missingPermissions(bucketPerms, granted); len(missing) > 0 {
+		return fmt.Errorf("missing permissions %v for bucket '%s' with the given GCP account", missing, gu.bucketName)
+	}
+
+	fmt.Fprintf(status, "Checking GCP project permissions...\n")
+	projectPerms := []string{"compute.images.create", "compute.images.get"}
+	if len(gu.shareWith) > 0 {
+		projectPerms = append(projectPerms, "compute.images.getIamPolicy", "compute.images.setIamPolicy")
+	}
+	granted, err = gu.client.ProjectTestPermissions(ctx, projectPerms)
+	if err != nil {
+		return err
+	}
+	if missing := missingPermissions(projectPerms, granted); len(missing) > 0 {
+		return fmt.Errorf("missing permissions %v for project '%s' with the given GCP account", missing, gu.client.GetProjectID())
+	}
+	fmt.Fprintf(status, "Upload conditions met.\n")
+	return nil
+}
+
+func (gu *gcpUploader) UploadAndRegister(r io.Reader, _ uint64, status io.Writer) (*cloud.UploadResult, error) {
+	ctx := context.Background()
+
+	objectName := fmt.Sprintf("%s-%s.tar.gz", uuid.New().String(), gu.imageName)
+	fmt.Fprintf(status, "Uploading %s to %s/%s\n", gu.imageName, gu.bucketName, objectName)
+	_, err := gu.client.StorageObjectUploadFromReader(ctx, r, gu.bucketName, objectName, map[string]string{MetadataKeyImageName: gu.imageName})
+	if err != nil {
+		return nil, err
+	}
+
+	fmt.Fprintf(status, "Importing image %s\n", gu.imageName)
+	image, importErr := gu.client.ComputeImageInsert(ctx, gu.bucketName, objectName, gu.imageName, gu.regions, gu.guestOsFeatures)
+
+	// the object is no longer needed once the import finished, the
+	// imported image is still returned if the delete fails
+	var deleteErr error
+	if err := gu.client.StorageObjectDelete(ctx, gu.bucketName, objectName); err != nil {
+		deleteErr = fmt.Errorf("cannot delete storage object %s/%s: %w", gu.bucketName, objectName, err)
+	} else {
+		fmt.Fprintf(status, "Deleted storage object %s/%s\n", gu.bucketName, objectName)
+	}
+	if importErr != nil {
+		return nil, errors.Join(importErr, deleteErr)
+	}
+
+	if len(gu.shareWith) > 0 {
+		fmt.Fprintf(status, "Sharing image %s with %v\n", gu.imageName, gu.shareWith)
+		if err := gu.client.ComputeImageShare(ctx, image.GetName(), gu.shareWith); err != nil {
+			return nil, errors.Join(err, deleteErr)
+		}
+	}
+	imageURL := gu.client.ComputeImageURL(image.GetName())
+	fmt.Fprintf(status, "Image imported: %s\n", imageURL)
+
+	return &cloud.UploadResult{
+		Provider: "gcp",
+		ImageID:  fmt.Sprintf("projects/%s/global/images/%s", gu.client.GetProjectID(), image.GetName()),
+		URL:      imageURL,
+		GCP: &cloud.GCPUploadResult{
+			Project:    gu.client.GetProjectID(),
+			ImageName:  image.GetName(),
+			Regions:    image.GetStorageLocations(),
+			SharedWith: gu.shareWith,
+		},
+	}, deleteErr
+}
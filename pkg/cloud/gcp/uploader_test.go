@@ -0,0 +1,224 @@
+package gcp_test
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"io"
+	"testing"
+
+	"cloud.google.com/go/compute/apiv1/computepb"
+	"cloud.google.com/go/storage"
+	"github.com/google/uuid"
+	"github.com/stretchr/testify/assert"
+
+	"github.com/osbuild/image-builder/internal/common"
+	"github.com/osbuild/image-builder/pkg/cloud"
+	"github.com/osbuild/image-builder/pkg/cloud/gcp"
+)
+
+type fakeGCPClient struct {
+	bucketPermissions  []string
+	projectPermissions []string
+
+	uploadRead  bytes.Buffer
+	uploadErr   error
+	uploadCalls int
+
+	insertErr      error
+	insertFeatures []*computepb.GuestOsFeature
+	insertRegions  []string
+	insertCalls    int
+
+	deleteErr   error
+	deleteCalls int
+
+	shareWith  []string
+	shareErr   error
+	shareCalls int
+}
+
+func (fg *fakeGCPClient) GetProjectID() string {
+	return "project"
+}
+
+func (fg *fakeGCPClient) StorageBucketTestPermissions(ctx context.Context, bucket string, permissions []string) ([]string, error) {
+	return fg.bucketPermissions, nil
+}
+
+func (fg *fakeGCPClient) ProjectTestPermissions(ctx context.Context, permissions []string) ([]string, error) {
+	return fg.projectPermissions, nil
+}
+
+func (fg *fakeGCPClient) StorageObjectUploadFromReader(ctx context.Context, r io.Reader, bucket, object string, metadata map[string]string) (*storage.ObjectAttrs, error) {
+	fg.uploadCalls++
+	if _, err := io.Copy(&fg.uploadRead, r); err != nil {
+		return nil, err
+	}
+	return &storage.ObjectAttrs{Bucket: bucket, Name: object}, fg.uploadErr
+}
+
+func (fg *fakeGCPClient) StorageObjectDelete(ctx context.Context, bucket, object string) error {
+	fg.deleteCalls++
+	return fg.deleteErr
+}
+
+func (fg *fakeGCPClient) ComputeImageInsert(ctx context.Context, bucket, object, imageName string, regions []string, guestOsFeatures []*computepb.GuestOsFeature) (*computepb.Image, error) {
+	fg.insertCalls++
+	fg.insertRegions = regions
+	fg.insertFeatures = guestOsFeatures
+	if fg.insertErr != nil {
+		return nil, fg.insertErr
+	}
+	return &computepb.Image{Name: common.ToPtr(imageName), StorageLocations: regions}, nil
+}
+
+func (fg *fakeGCPClient) ComputeImageShare(ctx context.Context, imageName string, shareWith []string) error {
+	fg.shareCalls++
+	fg.shareWith = shareWith
+	return fg.shareErr
+}
+
+func (fg *fakeGCPClient) ComputeImageURL(imageName string) string {
+	return "https://example.com/" + imageName
+}
+
+type repeatReader struct{}
+
+func (r *repeatReader) Read(p []byte) (int, error) {
+	for i := range p {
+		p[i] = 0x1
+	}
+	return len(p), nil
+}
+
+func TestUploaderCheckHappy(t *testing.T) {
+	fg := &fakeGCPClient{
+		bucketPermissions:  []string{"storage.objects.create", "storage.objects.delete"},
+		projectPermissions: []string{"compute.images.create", "compute.images.get"},
+	}
+	restore := gcp.MockNewGcpClient(func(string) (gcp.GcpClient, error) {
+		return fg, nil
+	})
+	defer restore()
+
+	uploader, err := gcp.NewUploader("bucket", "image", nil)
+	assert.NoError(t, err)
+	var statusLog bytes.Buffer
+	err = uploader.Check(&statusLog)
+	assert.NoError(t, err)
+	expectedStatusLog := `Checking GCP bucket permissions...
+Checking GCP project permissions...
+Upload conditions met.
+`
+	assert.Equal(t, expectedStatusLog, statusLog.String())
+}
+
+func TestUploaderCheckMissingPermissions(t *testing.T) {
+	fg := &fakeGCPClient{
+		bucketPermissions:  []string{"storage.objects.create", "storage.objects.delete"},
+		projectPermissions: []string{"compute.images.create", "compute.images.get"},
+	}
+	restore := gcp.MockNewGcpClient(func(string) (gcp.GcpClient, error) {
+		return fg, nil
+	})
+	defer restore()
+
+	uploader, err := gcp.NewUploader("bucket", "image", &gcp.UploaderOptions{
+		ShareWith: []string{"user:alice@example.com"},
+	})
+	assert.NoError(t, err)
+	err = uploader.Check(io.Discard)
+	assert.EqualError(t, err, "missing permissions [compute.images.getIamPolicy compute.images.setIamPolicy] for project 'project' with the given GCP account")
+
+	fg.bucketPermissions = []string{"storage.objects.create"}
+	err = uploader.Check(io.Discard)
+	assert.EqualError(t, err, "missing permissions [storage.objects.delete] for bucket 'bucket' with the given GCP account")
+}
+
+func TestUploaderUploadHappy(t *testing.T) {
+	uuid.SetRand(&repeatReader{})
+
+	fg := &fakeGCPClient{}
+	restore := gcp.MockNewGcpClient(func(string) (gcp.GcpClient, error) {
+		return fg, nil
+	})
+	defer restore()
+
+	uploader, err := gcp.NewUploader("bucket", "image", &gcp.UploaderOptions{
+		Regions:         []string{"us-east1"},
+		ShareWith:       []string{"user:alice@example.com"},
+		GuestOsFeatures: gcp.GuestOsFeaturesByDistro("rhel-9.6"),
+	})
+	assert.NoError(t, err)
+	var uploadLog bytes.Buffer
+	result, err := uploader.UploadAndRegister(bytes.NewBufferString("fake-gce-image"), 0, &uploadLog)
+	assert.NoError(t, err)
+	assert.Equal(t, "gcp", result.Provider)
+	assert.Equal(t, "projects/project/global/images/image", result.ImageID)
+	assert.Equal(t, "https://example.com/image", result.URL)
+	assert.Equal(t, &cloud.GCPUploadResult{
+		Project:    "project",
+		ImageName:  "image",
+		Regions:    []string{"us-east1"},
+		SharedWith: []string{"user:alice@example.com"},
+	}, result.GCP)
+	assert.Equal(t, "fake-gce-image", fg.uploadRead.String())
+	assert.Equal(t, 1, fg.insertCalls)
+	assert.Equal(t, []string{"us-east1"}, fg.insertRegions)
+	assert.Equal(t, gcp.GuestOsFeaturesRHEL9, fg.insertFeatures)
+	assert.Equal(t, 1, fg.deleteCalls)
+	assert.Equal(t, 1, fg.shareCalls)
+	assert.Equal(t, []string{"user:alice@example.com"}, fg.shareWith)
+	expectedUploadLog := `Uploading image to bucket/01010101-0101-4101-8101-010101010101-image.tar.gz
+Importing image image
+Deleted storage object bucket/01010101-0101-4101-8101-010101010101-image.tar.gz
+Sharing image image with [user:alice@example.com]
+Image imported: https://example.com/image
+`
+	assert.Equal(t, expectedUploadLog, uploadLog.String())
+}
+
+func TestUploaderUploadButImportError(t *testing.T) {
+	fg := &fakeGCPClient{
+		insertErr: fmt.Errorf("fake-insert-err"),
+	}
+	restore := gcp.MockNewGcpClient(func(string) (gcp.GcpClient, error) {
+		return fg, nil
+	})
+	defer restore()
+
+	uploader, err := gcp.NewUploader("bucket", "image", nil)
+	assert.NoError(t, err)
+	result, err := uploader.UploadAndRegister(bytes.NewBufferString("fake-gce-image"), 0, io.Discard)
+	assert.EqualError(t, err, "fake-insert-err")
+	assert.Nil(t, result)
+	assert.Equal(t, 1, fg.deleteCalls)
+	assert.Equal(t, 0, fg.shareCalls)
+
+	fg.deleteErr = fmt.Errorf("fake-delete-err")
+	_, err = uploader.UploadAndRegister(bytes.NewBufferString("fake-gce-image"), 0, io.Discard)
+	assert.ErrorContains(t, err, "fake-insert-err\ncannot delete storage object bucket/")
+	assert.ErrorContains(t, err, "-image.tar.gz: fake-delete-err")
+}
+
+func TestUploaderUploadButDeleteError(t *testing.T) {
+	fg := &fakeGCPClient{
+		deleteErr: fmt.Errorf("fake-delete-err"),
+	}
+	restore := gcp.MockNewGcpClient(func(string) (gcp.GcpClient, error) {
+		return fg, nil
+	})
+	defer restore()
+
+	uploader, err := gcp.NewUploader("bucket", "image", nil)
+	assert.NoError(t, err)
+	var uploadLog bytes.Buffer
+	result, err := uploader.UploadAndRegister(bytes.NewBufferString("fake-gce-image"), 0, &uploadLog)
+	assert.ErrorContains(t, err, "cannot delete storage object bucket/")
+	assert.ErrorContains(t, err, "-image.tar.gz: fake-delete-err")
+	// the image got imported so it is still returned
+	assert.Equal(t, "projects/project/global/images/image", result.ImageID)
+	assert.NotContains(t, uploadLog.String(), "Deleted storage object")
+	assert.Contains(t, uploadLog.String(), "Image imported: https://example.com/image")
+}
@@ -73,7 +73,7 @@ func (iu *ibmcloudUploader) UploadAndRegister(r io.Reader, uploadSize uint64, st
 	}
 
 	uploader := s3manager.NewUploader(session)
-	_, err = uploader.Upload(&s3manager.UploadInput{
+	res, err := uploader.Upload(&s3manager.UploadInput{
 		Bucket: aws.String(iu.bucketName),
 		Key:    aws.String(iu.imageName),
 		Body:   r,
@@ -84,6 +84,13 @@ func (iu *ibmcloudUploader) UploadAndRegister(r io.Reader, uploadSize uint64, st
 
 	return &cloud.UploadResult{
 		Provider: "ibmcloud",
+		ImageID:  fmt.Sprintf("%s/%s", iu.bucketName, iu.imageName),
+		IBMCloud: &cloud.IBMCloudUploadResult{
+			Region:   iu.region,
+			Bucket:   iu.bucketName,
+			Key:      iu.imageName,
+			Location: res.Location,
+		},
 	}, nil
 }
 
@@ -67,9 +67,20 @@ func (lu *libvirtUploader) UploadAndRegister(r io.Reader, uploadSize uint64, sta
 	if err != nil {
 		return nil, fmt.Errorf("Failed to upload the file to libvirt: %w", err)
 	}
+	volPath, err := vol.GetPath()
+	if err != nil {
+		return nil, fmt.Errorf("Failed to get the path of the libvirt volume: %w", err)
+	}
 
 	return &cloud.UploadResult{
 		Provider: "libvirt",
+		ImageID:  volPath,
+		Libvirt: &cloud.LibvirtUploadResult{
+			Connection: lu.connection,
+			Pool:       lu.pool,
+			Volume:     lu.volume,
+			Path:       volPath,
+		},
 	}, nil
 }
 
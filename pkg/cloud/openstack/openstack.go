@@ -63,8 +63,9 @@ func (ou *openstackUploader) UploadAndRegister(r io.Reader, uploadSize uint64, s
 		return nil, fmt.Errorf("Failed to authenticate to OpenStack: %w", err)
 	}
 
+	region := os.Getenv("OS_REGION_NAME")
 	client, err := ostack.NewImageV2(provider, gophercloud.EndpointOpts{
-		Region: os.Getenv("OS_REGION_NAME"),
+		Region: region,
 	})
 	if err != nil {
 		return nil, fmt.Errorf("Failed to initialize the client: %w", err)
@@ -88,5 +89,11 @@ func (ou *openstackUploader) UploadAndRegister(r io.Reader, uploadSize uint64, s
 	return &cloud.UploadResult{
 		Provider: "openstack",
 		ImageID:  img.ID,
+		OpenStack: &cloud.OpenStackUploadResult{
+			Region:          region,
+			ImageName:       ou.image,
+			DiskFormat:      ou.diskFormat,
+			ContainerFormat: ou.containerFormat,
+		},
 	}, nil
 }
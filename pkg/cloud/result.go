@@ -0,0 +1,132 @@
+package cloud
+
+import (
+	"time"
+)
+
+// UploadResult contains the result of an upload operation.
+// Different cloud providers populate different fields, the
+// provider specific details are in the field of the provider.
+type UploadResult struct {
+	Provider string `json:"provider" yaml:"provider"`
+	ImageID  string `json:"image_id,omitempty" yaml:"image_id,omitempty"`
+	URL      string `json:"url,omitempty" yaml:"url,omitempty"`
+	Digest   string `json:"digest,omitempty" yaml:"digest,omitempty"`
+	// ImageIDs contains the image ID of every region for images
+	// that got copied into multiple regions
+	ImageIDs map[string]string `json:"image_ids,omitempty" yaml:"image_ids,omitempty"`
+	// Checksum of the uploaded data in the form "sha256:<hex>"
+	Checksum string `json:"checksum,omitempty" yaml:"checksum,omitempty"`
+	// UploadedAt is the time the upload and registration finished
+	UploadedAt *time.Time `json:"uploaded_at,omitempty" yaml:"uploaded_at,omitempty"`
+
+	AWS       *AWSUploadResult       `json:"aws,omitempty" yaml:"aws,omitempty"`
+	S3        *S3UploadResult        `json:"s3,omitempty" yaml:"s3,omitempty"`
+	Azure     *AzureUploadResult     `json:"azure,omitempty" yaml:"azure,omitempty"`
+	GCP       *GCPUploadResult       `json:"gcp,omitempty" yaml:"gcp,omitempty"`
+	IBMCloud  *IBMCloudUploadResult  `json:"ibmcloud,omitempty" yaml:"ibmcloud,omitempty"`
+	Libvirt   *LibvirtUploadResult   `json:"libvirt,omitempty" yaml:"libvirt,omitempty"`
+	OpenStack *OpenStackUploadResult `json:"openstack,omitempty" yaml:"openstack,omitempty"`
+	OCI       *OCIUploadResult       `json:"oci,omitempty" yaml:"oci,omitempty"`
+	VSphere   *VSphereUploadResult   `json:"vsphere,omitempty" yaml:"vsphere,omitempty"`
+	Registry  *RegistryUploadResult  `json:"registry,omitempty" yaml:"registry,omitempty"`
+	Koji      *KojiUploadResult      `json:"koji,omitempty" yaml:"koji,omitempty"`
+}
+
+type AWSUploadResult struct {
+	Region     string `json:"region" yaml:"region"`
+	SnapshotID string `json:"snapshot_id,omitempty" yaml:"snapshot_id,omitempty"`
+	// SharedWith are the accounts and organizations the AMI (and
+	// all its copies) got shared with
+	SharedWith []string `json:"shared_with,omitempty" yaml:"shared_with,omitempty"`
+}
+
+type S3UploadResult struct {
+	Region string `json:"region,omitempty" yaml:"region,omitempty"`
+	Bucket string `json:"bucket" yaml:"bucket"`
+	Key    string `json:"key" yaml:"key"`
+	// Location is the URL of the object, unlike the URL of the
+	// result it is never presigned
+	Location string `json:"location,omitempty" yaml:"location,omitempty"`
+	ETag     string `json:"etag,omitempty" yaml:"etag,omitempty"`
+}
+
+type AzureUploadResult struct {
+	SubscriptionID string `json:"subscription_id,omitempty" yaml:"subscription_id,omitempty"`
+	ResourceGroup  string `json:"resource_group" yaml:"resource_group"`
+	Location       string `json:"location" yaml:"location"`
+	StorageAccount string `json:"storage_account" yaml:"storage_account"`
+	BlobURL        string `json:"blob_url" yaml:"blob_url"`
+	// Gallery, GalleryImageDefinition and GalleryImageVersion are
+	// set when the image got published into a compute gallery
+	Gallery                string   `json:"gallery,omitempty" yaml:"gallery,omitempty"`
+	GalleryImageDefinition string   `json:"gallery_image_definition,omitempty" yaml:"gallery_image_definition,omitempty"`
+	GalleryImageVersion    string   `json:"gallery_image_version,omitempty" yaml:"gallery_image_version,omitempty"`
+	ReplicationRegions     []string `json:"replication_regions,omitempty" yaml:"replication_regions,omitempty"`
+}
+
+type GCPUploadResult struct {
+	Project   string `json:"project" yaml:"project"`
+	ImageName string `json:"image_name" yaml:"image_name"`
+	// Regions the image is stored in, empty for the region of
+	// the bucket
+	Regions    []string `json:"regions,omitempty" yaml:"regions,omitempty"`
+	SharedWith []string `json:"shared_with,omitempty" yaml:"shared_with,omitempty"`
+}
+
+type IBMCloudUploadResult struct {
+	Region   string `json:"region" yaml:"region"`
+	Bucket   string `json:"bucket" yaml:"bucket"`
+	Key      string `json:"key" yaml:"key"`
+	Location string `json:"location,omitempty" yaml:"location,omitempty"`
+}
+
+type LibvirtUploadResult struct {
+	Connection string `json:"connection" yaml:"connection"`
+	Pool       string `json:"pool" yaml:"pool"`
+	Volume     string `json:"volume" yaml:"volume"`
+	// Path of the volume on the libvirt host
+	Path string `json:"path,omitempty" yaml:"path,omitempty"`
+}
+
+type OpenStackUploadResult struct {
+	Region          string `json:"region,omitempty" yaml:"region,omitempty"`
+	ImageName       string `json:"image_name" yaml:"image_name"`
+	DiskFormat      string `json:"disk_format" yaml:"disk_format"`
+	ContainerFormat string `json:"container_format" yaml:"container_format"`
+}
+
+type OCIUploadResult struct {
+	CompartmentID string `json:"compartment_id" yaml:"compartment_id"`
+	Namespace     string `json:"namespace" yaml:"namespace"`
+	Bucket        string `json:"bucket" yaml:"bucket"`
+	ImageName     string `json:"image_name" yaml:"image_name"`
+}
+
+type VSphereUploadResult struct {
+	Datacenter string `json:"datacenter" yaml:"datacenter"`
+	Datastore  string `json:"datastore" yaml:"datastore"`
+	Cluster    string `json:"cluster" yaml:"cluster"`
+	// Folder is the inventory path of the folder of the template
+	Folder string `json:"folder" yaml:"folder"`
+	Format string `json:"format" yaml:"format"`
+}
+
+type RegistryUploadResult struct {
+	// Reference the image got pushed to, e.g. "quay.io/example/image:latest"
+	Reference string `json:"reference" yaml:"reference"`
+}
+
+type KojiUploadResult struct {
+	Server  string `json:"server" yaml:"server"`
+	BuildID int    `json:"build_id" yaml:"build_id"`
+	NVR     string `json:"nvr" yaml:"nvr"`
+	// Outputs are all files imported into the build
+	Outputs []KojiUploadOutput `json:"outputs,omitempty" yaml:"outputs,omitempty"`
+}
+
+type KojiUploadOutput struct {
+	Filename     string `json:"filename" yaml:"filename"`
+	ChecksumType string `json:"checksum_type" yaml:"checksum_type"`
+	Checksum     string `json:"checksum" yaml:"checksum"`
+}
@@ -1,16 +1,12 @@
 package cloud
 
 import (
+	"crypto/sha256"
+	"fmt"
 	"io"
+	"time"
 )
 
-// UploadResult contains the result of an upload operation.
-// Different cloud providers populate different fields.
-type UploadResult struct {
-	Provider string `json:"provider" yaml:"provider"`
-	ImageID  string `json:"image_id,omitempty" yaml:"image_id,omitempty"`
-}
-
 // Uploader is an interface that is returned from the actual
 // cloud implementation. The uploader will be parameterized
 // by the actual cloud implemntation, e.g.
@@ -29,5 +25,42 @@ type Uploader interface {
 	// To implement progress a proxy reader can be used.
 	// For more complex scenarios an optional uploadSize can be
 	// passed.
+	// If the image got registered but a later step failed (e.g.
+	// copying it to other regions) a partial result is returned
+	// together with the error.
 	UploadAndRegister(r io.Reader, uploadSize uint64, status io.Writer) (*UploadResult, error)
 }
+
+type countingReader struct {
+	r io.Reader
+	n uint64
+}
+
+func (cr *countingReader) Read(p []byte) (int, error) {
+	n, err := cr.r.Read(p)
+	// #nosec G115
+	cr.n += uint64(n)
+	return n, err
+}
+
+// UploadAndRegister uploads and registers the image from the reader
+// with the given uploader and adds the checksum of the uploaded data
+// and the upload time to the result. The checksum is only added if
+// the uploader read the whole image from the reader, some uploaders
+// (e.g. azure) read the image from its path instead.
+func UploadAndRegister(uploader Uploader, r io.Reader, uploadSize uint64, status io.Writer) (*UploadResult, error) {
+	h := sha256.New()
+	cr := &countingReader{r: io.TeeReader(r, h)}
+	res, err := uploader.UploadAndRegister(cr, uploadSize, status)
+	if res == nil {
+		return nil, err
+	}
+	if uploadSize > 0 && cr.n == uploadSize && res.Checksum == "" {
+		res.Checksum = fmt.Sprintf("sha256:%x", h.Sum(nil))
+	}
+	if res.UploadedAt == nil {
+		now := time.Now().UTC()
+		res.UploadedAt = &now
+	}
+	return res, err
+}
@@ -0,0 +1,59 @@
+package cloud_test
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/osbuild/image-builder/pkg/cloud"
+)
+
+type fakeUploader struct {
+	read bool
+	err  error
+}
+
+func (fu *fakeUploader) Check(status io.Writer) error {
+	return nil
+}
+
+func (fu *fakeUploader) UploadAndRegister(r io.Reader, uploadSize uint64, status io.Writer) (*cloud.UploadResult, error) {
+	if fu.read {
+		if _, err := io.Copy(io.Discard, r); err != nil {
+			return nil, err
+		}
+	}
+	return &cloud.UploadResult{Provider: "fake"}, fu.err
+}
+
+func TestUploadAndRegisterAddsChecksumAndTime(t *testing.T) {
+	data := []byte("fake-image")
+	res, err := cloud.UploadAndRegister(&fakeUploader{read: true}, bytes.NewReader(data), uint64(len(data)), io.Discard)
+	require.NoError(t, err)
+	assert.Equal(t, "fake", res.Provider)
+	// sha256sum of "fake-image"
+	assert.Equal(t, "sha256:a8eb701c6f567b08661c2604364dd595455b811d2759d2029b465935b561c86b", res.Checksum)
+	assert.NotNil(t, res.UploadedAt)
+}
+
+func TestUploadAndRegisterNoChecksumIfNotRead(t *testing.T) {
+	data := []byte("fake-image")
+	res, err := cloud.UploadAndRegister(&fakeUploader{}, bytes.NewReader(data), uint64(len(data)), io.Discard)
+	require.NoError(t, err)
+	assert.Equal(t, "", res.Checksum)
+	assert.NotNil(t, res.UploadedAt)
+}
+
+func TestUploadAndRegisterPartialResult(t *testing.T) {
+	data := []byte("fake-image")
+	res, err := cloud.UploadAndRegister(&fakeUploader{read: true, err: fmt.Errorf("fake-copy-err")}, bytes.NewReader(data), uint64(len(data)), io.Discard)
+	assert.EqualError(t, err, "fake-copy-err")
+	require.NotNil(t, res)
+	assert.Equal(t, "fake", res.Provider)
+	assert.NotEmpty(t, res.Checksum)
+	assert.NotNil(t, res.UploadedAt)
+}
@@ -24,6 +24,7 @@ import (
 	"github.com/containers/image/v5/docker"
 	"github.com/containers/image/v5/docker/reference"
 	"github.com/containers/image/v5/manifest"
+	"github.com/containers/image/v5/pkg/docker/config"
 	"github.com/containers/image/v5/signature"
 	"github.com/containers/image/v5/transports"
 	"github.com/containers/image/v5/types"
@@ -339,6 +340,21 @@ func (cl *Client) UploadImage(ctx context.Context, from, tag string) (digest.Dig
 	return manifestDigest, nil
 }
 
+// CheckAuth pings the registry of the Target with the configured
+// credentials, it fails if the registry cannot be reached or if it
+// rejects the credentials.
+func (cl *Client) CheckAuth(ctx context.Context) error {
+	registry := reference.Domain(cl.Target)
+	auth, err := config.GetCredentials(cl.sysCtx, registry)
+	if err != nil {
+		return fmt.Errorf("cannot get credentials for %s: %w", registry, err)
+	}
+	if err := docker.CheckAuth(ctx, cl.sysCtx, auth.Username, auth.Password, registry); err != nil {
+		return fmt.Errorf("cannot access registry %s: %w", registry, err)
+	}
+	return nil
+}
+
 // A RawManifest contains the raw manifest Data and its MimeType
 type RawManifest struct {
 	Data     []byte
@@ -2,12 +2,16 @@ package container_test
 
 import (
 	"bytes"
+	"context"
 	"encoding/json"
 	"fmt"
+	"net/http"
+	"net/http/httptest"
 	"os"
 	"os/exec"
 	"os/user"
 	"path/filepath"
+	"strings"
 	"testing"
 	"time"
 
@@ -114,6 +118,31 @@ func TestClientAuthFilePath(t *testing.T) {
 
 }
 
+func TestClientCheckAuth(t *testing.T) {
+	srv := httptest.NewTLSServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if user, pass, ok := r.BasicAuth(); !ok || user != "alice" || pass != "secret" {
+			w.Header().Set("WWW-Authenticate", `Basic realm="test"`)
+			w.WriteHeader(http.StatusUnauthorized)
+			return
+		}
+		w.WriteHeader(http.StatusOK)
+	}))
+	defer srv.Close()
+	registry := strings.TrimPrefix(srv.URL, "https://")
+
+	client, err := container.NewClient(registry + "/osbuild/osbuild")
+	require.NoError(t, err)
+	client.SetAuthFilePath(filepath.Join(t.TempDir(), "auth.json"))
+	client.SkipTLSVerify()
+
+	client.SetCredentials("alice", "wrong")
+	err = client.CheckAuth(context.Background())
+	assert.ErrorContains(t, err, "cannot access registry "+registry+": ")
+
+	client.SetCredentials("alice", "secret")
+	assert.NoError(t, client.CheckAuth(context.Background()))
+}
+
 func TestClientGetDefaultAuthFile(t *testing.T) {
 	testCases := []struct {
 		name string
@@ -25,3 +25,13 @@ func ClientSysctx(cl *Client) *types.SystemContext {
 }
 
 var ParseImageName = parseImageName
+
+type RegistryClient = registryClient
+
+func MockNewRegistryClient(f func(string, *UploaderOptions) (registryClient, error)) (restore func()) {
+	saved := newRegistryClient
+	newRegistryClient = f
+	return func() {
+		newRegistryClient = saved
+	}
+}
@@ -0,0 +1,163 @@
+package container
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"os"
+
+	"github.com/containers/image/v5/docker/reference"
+	"github.com/opencontainers/go-digest"
+
+	"github.com/osbuild/image-builder/pkg/cloud"
+)
+
+type registryUploader struct {
+	client registryClient
+
+	target    reference.Named
+	authFile  string
+	imagePath string
+}
+
+type UploaderOptions struct {
+	// AuthFile is the path to a containers-auth.json(5) file, if
+	// empty the default location is used, see GetDefaultAuthFile()
+	AuthFile string
+	// TLSVerify controls if the registry certificate is verified,
+	// if nil the registries.conf(5) default is used
+	TLSVerify *bool
+	// Arch is the architecture of the pushed image
+	Arch string
+	// ImagePath is the path of the oci-archive, if set the archive
+	// is pushed from there instead of being spooled from the reader
+	// passed to UploadAndRegister(), the reader is only drained after
+	// the push
+	ImagePath string
+}
+
+// testing support
+type registryClient interface {
+	UploadImage(ctx context.Context, from, tag string) (digest.Digest, error)
+	CheckAuth(ctx context.Context) error
+}
+
+var newRegistryClient = func(target string, opts *UploaderOptions) (registryClient, error) {
+	client, err := NewClient(target)
+	if err != nil {
+		return nil, err
+	}
+	if opts.AuthFile != "" {
+		client.SetAuthFilePath(opts.AuthFile)
+	}
+	if opts.Arch != "" {
+		client.SetArchitectureChoice(opts.Arch)
+	}
+	client.SetTLSVerify(opts.TLSVerify)
+	// the per-blob copy reports would clash with the progress bar
+	client.ReportWriter = io.Discard
+	return client, nil
+}
+
+// NewUploader returns a cloud.Uploader that pushes an oci-archive
+// to the given target at a container registry, e.g.
+// "quay.io/example/image:tag".
+func NewUploader(target string, opts *UploaderOptions) (cloud.Uploader, error) {
+	if opts == nil {
+		opts = &UploaderOptions{}
+	}
+
+	ref, err := reference.ParseNormalizedNamed(target)
+	if err != nil {
+		return nil, fmt.Errorf("failed to parse '%s': %w", target, err)
+	}
+	ref = reference.TagNameOnly(ref)
+
+	client, err := newRegistryClient(ref.String(), opts)
+	if err != nil {
+		return nil, err
+	}
+
+	return &registryUploader{
+		client:    client,
+		target:    ref,
+		authFile:  opts.AuthFile,
+		imagePath: opts.ImagePath,
+	}, nil
+}
+
+var _ cloud.Uploader = &registryUploader{}
+
+func (ru *registryUploader) Check(status io.Writer) error {
+	if ru.authFile != "" {
+		fmt.Fprintf(status, "Checking registry auth file...\n")
+		if _, err := os.Stat(ru.authFile); err != nil {
+			return fmt.Errorf("cannot use registry auth file: %w", err)
+		}
+	}
+	fmt.Fprintf(status, "Checking registry access...\n")
+	if err := ru.client.CheckAuth(context.Background()); err != nil {
+		return err
+	}
+	fmt.Fprintf(status, "Upload conditions met.\n")
+	return nil
+}
+
+// spoolArchive writes the oci-archive from the reader to a temporary
+// file, an oci-archive cannot be read from a stream
+func spoolArchive(r io.Reader) (string, error) {
+	f, err := os.CreateTemp("", "image-builder-registry-upload-*.tar")
+	if err != nil {
+		return "", err
+	}
+	defer f.Close()
+	if _, err := io.Copy(f, r); err != nil {
+		os.Remove(f.Name())
+		return "", fmt.Errorf("cannot write oci-archive: %w", err)
+	}
+	if err := f.Close(); err != nil {
+		os.Remove(f.Name())
+		return "", err
+	}
+	return f.Name(), nil
+}
+
+func (ru *registryUploader) UploadAndRegister(r io.Reader, _ uint64, status io.Writer) (*cloud.UploadResult, error) {
+	archivePath := ru.imagePath
+	if archivePath == "" {
+		var err error
+		archivePath, err = spoolArchive(r)
+		if err != nil {
+			return nil, err
+		}
+		defer os.Remove(archivePath)
+	}
+
+	fmt.Fprintf(status, "Pushing to %s\n", ru.target)
+	manifestDigest, err := ru.client.UploadImage(context.Background(), fmt.Sprintf("oci-archive:%s", archivePath), "")
+	if err != nil {
+		return nil, err
+	}
+	pinned, err := reference.WithDigest(reference.TrimNamed(ru.target), manifestDigest)
+	if err != nil {
+		return nil, err
+	}
+	fmt.Fprintf(status, "Pushed %s\n", pinned)
+	// the reader is not used when pushing from the image path, it is
+	// drained so that the progress completes and the whole image can
+	// be checksummed by the caller
+	if ru.imagePath != "" {
+		if _, err := io.Copy(io.Discard, r); err != nil {
+			return nil, fmt.Errorf("cannot read image: %w", err)
+		}
+	}
+
+	return &cloud.UploadResult{
+		Provider: "registry",
+		ImageID:  pinned.String(),
+		Digest:   manifestDigest.String(),
+		Registry: &cloud.RegistryUploadResult{
+			Reference: ru.target.String(),
+		},
+	}, nil
+}
@@ -0,0 +1,175 @@
+package container_test
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/opencontainers/go-digest"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/osbuild/image-builder/internal/common"
+	"github.com/osbuild/image-builder/pkg/container"
+)
+
+type fakeRegistryClient struct {
+	digest    digest.Digest
+	uploadErr error
+	authErr   error
+
+	uploadCalls    int
+	uploadFrom     string
+	uploadFromData string
+}
+
+func (fc *fakeRegistryClient) UploadImage(ctx context.Context, from, tag string) (digest.Digest, error) {
+	fc.uploadCalls++
+	fc.uploadFrom = from
+	data, err := os.ReadFile(strings.TrimPrefix(from, "oci-archive:"))
+	if err != nil {
+		return "", err
+	}
+	fc.uploadFromData = string(data)
+	return fc.digest, fc.uploadErr
+}
+
+func (fc *fakeRegistryClient) CheckAuth(ctx context.Context) error {
+	return fc.authErr
+}
+
+func TestRegistryUploaderHappy(t *testing.T) {
+	fc := &fakeRegistryClient{
+		digest: digest.FromString("fake-manifest"),
+	}
+	var gotTarget string
+	var gotOpts *container.UploaderOptions
+	restore := container.MockNewRegistryClient(func(target string, opts *container.UploaderOptions) (container.RegistryClient, error) {
+		gotTarget = target
+		gotOpts = opts
+		return fc, nil
+	})
+	defer restore()
+
+	opts := &container.UploaderOptions{
+		TLSVerify: common.ToPtr(false),
+		Arch:      "aarch64",
+	}
+	uploader, err := container.NewUploader("quay.example/foo:tag", opts)
+	require.NoError(t, err)
+	assert.Equal(t, "quay.example/foo:tag", gotTarget)
+	assert.Equal(t, opts, gotOpts)
+
+	var statusLog bytes.Buffer
+	require.NoError(t, uploader.Check(&statusLog))
+	assert.Equal(t, "Checking registry access...\nUpload conditions met.\n", statusLog.String())
+
+	// the archive is spooled to TMPDIR
+	tmpDir := t.TempDir()
+	t.Setenv("TMPDIR", tmpDir)
+	statusLog.Reset()
+	result, err := uploader.UploadAndRegister(bytes.NewBufferString("fake-oci-archive"), 0, &statusLog)
+	require.NoError(t, err)
+	assert.Equal(t, 1, fc.uploadCalls)
+	assert.True(t, strings.HasPrefix(fc.uploadFrom, "oci-archive:"+tmpDir+"/"))
+	assert.Equal(t, "fake-oci-archive", fc.uploadFromData)
+	// the spooled archive is removed after the upload
+	assert.NoFileExists(t, strings.TrimPrefix(fc.uploadFrom, "oci-archive:"))
+
+	expectedDigest := digest.FromString("fake-manifest").String()
+	assert.Equal(t, "registry", result.Provider)
+	assert.Equal(t, "quay.example/foo@"+expectedDigest, result.ImageID)
+	assert.Equal(t, expectedDigest, result.Digest)
+	assert.Equal(t, "quay.example/foo:tag", result.Registry.Reference)
+	expectedStatusLog := fmt.Sprintf("Pushing to quay.example/foo:tag\nPushed quay.example/foo@%s\n", expectedDigest)
+	assert.Equal(t, expectedStatusLog, statusLog.String())
+}
+
+func TestRegistryUploaderDefaultTag(t *testing.T) {
+	var gotTarget string
+	restore := container.MockNewRegistryClient(func(target string, opts *container.UploaderOptions) (container.RegistryClient, error) {
+		gotTarget = target
+		return &fakeRegistryClient{}, nil
+	})
+	defer restore()
+
+	_, err := container.NewUploader("quay.example/foo", nil)
+	require.NoError(t, err)
+	assert.Equal(t, "quay.example/foo:latest", gotTarget)
+}
+
+func TestRegistryUploaderBadTarget(t *testing.T) {
+	_, err := container.NewUploader("Not/A/Valid:Reference:", nil)
+	assert.ErrorContains(t, err, "failed to parse 'Not/A/Valid:Reference:'")
+}
+
+func TestRegistryUploaderCheckAuthFile(t *testing.T) {
+	restore := container.MockNewRegistryClient(func(target string, opts *container.UploaderOptions) (container.RegistryClient, error) {
+		return &fakeRegistryClient{}, nil
+	})
+	defer restore()
+
+	authFile := filepath.Join(t.TempDir(), "auth.json")
+	uploader, err := container.NewUploader("quay.example/foo", &container.UploaderOptions{AuthFile: authFile})
+	require.NoError(t, err)
+	var statusLog bytes.Buffer
+	err = uploader.Check(&statusLog)
+	assert.ErrorContains(t, err, "cannot use registry auth file: ")
+
+	require.NoError(t, os.WriteFile(authFile, []byte(`{"auths":{}}`), 0600))
+	statusLog.Reset()
+	require.NoError(t, uploader.Check(&statusLog))
+	assert.Equal(t, "Checking registry auth file...\nChecking registry access...\nUpload conditions met.\n", statusLog.String())
+}
+
+func TestRegistryUploaderCheckAccess(t *testing.T) {
+	restore := container.MockNewRegistryClient(func(target string, opts *container.UploaderOptions) (container.RegistryClient, error) {
+		return &fakeRegistryClient{authErr: fmt.Errorf("fake-auth-err")}, nil
+	})
+	defer restore()
+
+	uploader, err := container.NewUploader("quay.example/foo", nil)
+	require.NoError(t, err)
+	err = uploader.Check(&bytes.Buffer{})
+	assert.EqualError(t, err, "fake-auth-err")
+}
+
+func TestRegistryUploaderImagePath(t *testing.T) {
+	fc := &fakeRegistryClient{
+		digest: digest.FromString("fake-manifest"),
+	}
+	restore := container.MockNewRegistryClient(func(target string, opts *container.UploaderOptions) (container.RegistryClient, error) {
+		return fc, nil
+	})
+	defer restore()
+
+	imagePath := filepath.Join(t.TempDir(), "image.tar")
+	require.NoError(t, os.WriteFile(imagePath, []byte("fake-oci-archive"), 0644))
+	uploader, err := container.NewUploader("quay.example/foo", &container.UploaderOptions{ImagePath: imagePath})
+	require.NoError(t, err)
+
+	// the archive is pushed from its path, the reader is only drained
+	r := bytes.NewBufferString("fake-oci-archive")
+	_, err = uploader.UploadAndRegister(r, 0, &bytes.Buffer{})
+	require.NoError(t, err)
+	assert.Equal(t, 0, r.Len())
+	assert.Equal(t, "oci-archive:"+imagePath, fc.uploadFrom)
+	assert.Equal(t, "fake-oci-archive", fc.uploadFromData)
+	assert.FileExists(t, imagePath)
+}
+
+func TestRegistryUploaderUploadError(t *testing.T) {
+	restore := container.MockNewRegistryClient(func(target string, opts *container.UploaderOptions) (container.RegistryClient, error) {
+		return &fakeRegistryClient{uploadErr: fmt.Errorf("fake-upload-err")}, nil
+	})
+	defer restore()
+
+	uploader, err := container.NewUploader("quay.example/foo", nil)
+	require.NoError(t, err)
+	_, err = uploader.UploadAndRegister(bytes.NewBufferString("fake-oci-archive"), 0, &bytes.Buffer{})
+	assert.EqualError(t, err, "fake-upload-err")
+}
@@ -0,0 +1,815 @@
+package kickstart
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"slices"
+	"strconv"
+	"strings"
+
+	"github.com/osbuild/blueprint/pkg/blueprint"
+
+	"github.com/osbuild/image-builder/internal/common"
+	"github.com/osbuild/image-builder/pkg/datasizes"
+)
+
+// Unmapped is a kickstart directive, or some of its options, that has no
+// equivalent in a blueprint.
+type Unmapped struct {
+	// Line of the directive in the kickstart file
+	Line      int    `json:"line" yaml:"line"`
+	Directive string `json:"directive" yaml:"directive"`
+	Reason    string `json:"reason" yaml:"reason"`
+}
+
+// ksFlags are the options of every directive that never take a value,
+// all other options can be given as "--opt=value" or "--opt value"
+var ksFlags = map[string][]string{
+	"%packages":  {"default", "excludedocs", "exclude-weakdeps", "ignoremissing", "multilib", "nocore"},
+	"autopart":   {"encrypted", "noboot", "nohome", "nolvm", "noswap"},
+	"bootloader": {"iscrypted", "leavebootorder", "nombr"},
+	"clearpart":  {"all", "initlabel", "linux", "none"},
+	"firewall":   {"disable", "disabled", "enable", "enabled", "no-ssh", "ssh", "use-system-defaults"},
+	"firstboot":  {"disable", "disabled", "enable", "enabled", "reconfig"},
+	"logvol":     {"encrypted", "grow", "hibernation", "noformat", "recommended", "resize", "thin", "thinpool", "useexisting"},
+	"network":    {"activate", "no-activate", "nodefroute", "nodns", "noipv4", "noipv6"},
+	"part":       {"asprimary", "encrypted", "grow", "hibernation", "noformat", "recommended", "resize"},
+	"partition":  {"asprimary", "encrypted", "grow", "hibernation", "noformat", "recommended", "resize"},
+	"reboot":     {"eject", "kexec"},
+	"poweroff":   {"eject", "kexec"},
+	"shutdown":   {"eject", "kexec"},
+	"repo":       {"install", "noverifyssl"},
+	"reqpart":    {"add-boot"},
+	"rootpw":     {"allow-ssh", "iscrypted", "lock", "plaintext"},
+	"selinux":    {"disabled", "enforcing", "permissive"},
+	"timesource": {"nts", "offline"},
+	"timezone":   {"isUtc", "nontp", "utc"},
+	"url":        {"noverifyssl"},
+	"user":       {"iscrypted", "lock", "plaintext"},
+	"volgroup":   {"noformat", "useexisting"},
+}
+
+// installOnly are directives that configure the installation itself
+// and not the installed system
+var installOnly = map[string]bool{
+	"autostep":             true,
+	"cdrom":                true,
+	"cmdline":              true,
+	"driverdisk":           true,
+	"eula":                 true,
+	"firstboot":            true,
+	"graphical":            true,
+	"halt":                 true,
+	"harddrive":            true,
+	"ignoredisk":           true,
+	"install":              true,
+	"liveimg":              true,
+	"logging":              true,
+	"mediacheck":           true,
+	"nfs":                  true,
+	"ostreesetup":          true,
+	"poweroff":             true,
+	"reboot":               true,
+	"rescue":               true,
+	"shutdown":             true,
+	"skipx":                true,
+	"sshpw":                true,
+	"text":                 true,
+	"updates":              true,
+	"url":                  true,
+	"vnc":                  true,
+	"zerombr":              true,
+	"zipl":                 true,
+	"interactive":          true,
+	"unsupported_hardware": true,
+}
+
+// directive is a single kickstart command with its options
+type directive struct {
+	line int
+	text string
+	name string
+	args []string
+	opts map[string]string
+	// used are the options that were mapped to the blueprint
+	used map[string]bool
+	// usedArgs is the number of positional arguments that were
+	// mapped to the blueprint
+	usedArgs int
+}
+
+// splitArgs splits a line into shell-like words
+func splitArgs(line string) ([]string, error) {
+	var words []string
+	var word strings.Builder
+	var inWord bool
+	var quote rune
+	escaped := false
+	for _, c := range line {
+		switch {
+		case escaped:
+			word.WriteRune(c)
+			escaped = false
+		case c == '\\' && quote != '\'':
+			escaped = true
+			inWord = true
+		case quote != 0 && c == quote:
+			quote = 0
+		case quote != 0:
+			word.WriteRune(c)
+		case c == '"' || c == '\'':
+			quote = c
+			inWord = true
+		case c == ' ' || c == '\t':
+			if inWord {
+				words = append(words, word.String())
+				word.Reset()
+				inWord = false
+			}
+		default:
+			word.WriteRune(c)
+			inWord = true
+		}
+	}
+	if quote != 0 {
+		return nil, fmt.Errorf("unterminated quote")
+	}
+	if inWord {
+		words = append(words, word.String())
+	}
+	return words, nil
+}
+
+func parseDirective(lineno int, line string) (*directive, error) {
+	words, err := splitArgs(line)
+	if err != nil {
+		return nil, fmt.Errorf("line %d: %w", lineno, err)
+	}
+	d := &directive{
+		line: lineno,
+		text: line,
+		name: words[0],
+		opts: make(map[string]string),
+		used: make(map[string]bool),
+	}
+	for i := 1; i < len(words); i++ {
+		word := words[i]
+		if !strings.HasPrefix(word, "--") {
+			d.args = append(d.args, word)
+			continue
+		}
+		name, value, hasValue := strings.Cut(strings.TrimPrefix(word, "--"), "=")
+		if !hasValue && !slices.Contains(ksFlags[d.name], name) && i+1 < len(words) && !strings.HasPrefix(words[i+1], "--") {
+			value = words[i+1]
+			i++
+		}
+		d.opts[name] = value
+	}
+	return d, nil
+}
+
+// arg returns the n-th positional argument and marks it (and all
+// arguments before it) as mapped
+func (d *directive) arg(n int) (string, bool) {
+	if n >= len(d.args) {
+		return "", false
+	}
+	d.usedArgs = max(d.usedArgs, n+1)
+	return d.args[n], true
+}
+
+// allArgs returns all positional arguments and marks them as mapped
+func (d *directive) allArgs() []string {
+	d.usedArgs = len(d.args)
+	return d.args
+}
+
+// opt returns the value of the option and marks it as mapped
+func (d *directive) opt(name string) (string, bool) {
+	value, ok := d.opts[name]
+	if ok {
+		d.used[name] = true
+	}
+	return value, ok
+}
+
+// optList returns the comma separated values of the option
+func (d *directive) optList(name string) []string {
+	value, ok := d.opt(name)
+	if !ok || value == "" {
+		return nil
+	}
+	return strings.Split(value, ",")
+}
+
+func (d *directive) flag(name string) bool {
+	_, ok := d.opt(name)
+	return ok
+}
+
+// sizeMiB returns the --size option (in MiB) in bytes
+func (d *directive) sizeMiB() (uint64, error) {
+	value, ok := d.opt("size")
+	if !ok {
+		return 0, nil
+	}
+	size, err := strconv.ParseUint(value, 10, 64)
+	if err != nil {
+		return 0, fmt.Errorf("line %d: invalid size %q", d.line, value)
+	}
+	return size * datasizes.MiB, nil
+}
+
+func (d *directive) intOpt(name string) (*int, error) {
+	value, ok := d.opt(name)
+	if !ok {
+		return nil, nil
+	}
+	i, err := strconv.Atoi(value)
+	if err != nil {
+		return nil, fmt.Errorf("line %d: invalid --%s %q", d.line, name, value)
+	}
+	return &i, nil
+}
+
+// unusedOpts returns the options that were not mapped
+func (d *directive) unusedOpts() []string {
+	var unused []string
+	for name := range d.opts {
+		if !d.used[name] {
+			unused = append(unused, "--"+name)
+		}
+	}
+	slices.Sort(unused)
+	return unused
+}
+
+// unusedArgs returns the positional arguments that were not mapped
+func (d *directive) unusedArgs() []string {
+	return d.args[d.usedArgs:]
+}
+
+// lvmPartition is a physical volume of the kickstart that becomes an
+// LVM partition of the blueprint
+type lvmPartition struct {
+	directive *directive
+	pvName    string
+	idx       int
+}
+
+type importer struct {
+	bp       *blueprint.Blueprint
+	unmapped []Unmapped
+
+	partitions []blueprint.PartitionCustomization
+	diskType   string
+	pvs        []*lvmPartition
+	volgroups  []*directive
+	logvols    []*directive
+	sshkeys    []*directive
+}
+
+func (im *importer) customizations() *blueprint.Customizations {
+	if im.bp.Customizations == nil {
+		im.bp.Customizations = &blueprint.Customizations{}
+	}
+	return im.bp.Customizations
+}
+
+func (im *importer) addUnmapped(d *directive, reason string) {
+	im.unmapped = append(im.unmapped, Unmapped{Line: d.line, Directive: d.text, Reason: reason})
+}
+
+// checkUnusedOpts lists the options and arguments of a mapped directive
+// that were not mapped
+func (im *importer) checkUnusedOpts(d *directive) {
+	if unused := d.unusedOpts(); len(unused) > 0 {
+		im.addUnmapped(d, fmt.Sprintf("options not supported by blueprints: %s", strings.Join(unused, ", ")))
+	}
+	if unused := d.unusedArgs(); len(unused) > 0 {
+		im.addUnmapped(d, fmt.Sprintf("arguments not supported by blueprints: %s", strings.Join(unused, " ")))
+	}
+}
+
+// sectionLine is a line of a %packages section
+type sectionLine struct {
+	line int
+	text string
+}
+
+func (im *importer) addPackages(header *directive, lines []sectionLine) {
+	for _, opt := range header.unusedOpts() {
+		im.addUnmapped(header, fmt.Sprintf("%%packages option %s is not supported by blueprints", opt))
+	}
+	for _, l := range lines {
+		switch {
+		case strings.HasPrefix(l.text, "@^"):
+			im.unmapped = append(im.unmapped, Unmapped{Line: l.line, Directive: l.text, Reason: "environment groups are not supported by blueprints, add their groups instead"})
+		case strings.HasPrefix(l.text, "@"):
+			im.bp.Groups = append(im.bp.Groups, blueprint.Group{Name: strings.TrimPrefix(l.text, "@")})
+		case strings.HasPrefix(l.text, "-"):
+			im.unmapped = append(im.unmapped, Unmapped{Line: l.line, Directive: l.text, Reason: "excluding packages is not supported by blueprints"})
+		default:
+			im.bp.Packages = append(im.bp.Packages, blueprint.Package{Name: l.text})
+		}
+	}
+}
+
+func (im *importer) addUser(d *directive) error {
+	name, _ := d.opt("name")
+	if name == "" {
+		return fmt.Errorf("line %d: user without --name", d.line)
+	}
+	user := blueprint.UserCustomization{
+		Name:   name,
+		Groups: d.optList("groups"),
+	}
+	if password, ok := d.opt("password"); ok {
+		user.Password = common.ToPtr(password)
+	}
+	// passwords are used as they are, crypted or not
+	d.flag("iscrypted")
+	d.flag("plaintext")
+	if homedir, ok := d.opt("homedir"); ok {
+		user.Home = common.ToPtr(homedir)
+	}
+	if shell, ok := d.opt("shell"); ok {
+		user.Shell = common.ToPtr(shell)
+	}
+	if gecos, ok := d.opt("gecos"); ok {
+		user.Description = common.ToPtr(gecos)
+	}
+	var err error
+	if user.UID, err = d.intOpt("uid"); err != nil {
+		return err
+	}
+	if user.GID, err = d.intOpt("gid"); err != nil {
+		return err
+	}
+	cust := im.customizations()
+	cust.User = append(cust.User, user)
+	im.checkUnusedOpts(d)
+	return nil
+}
+
+func (im *importer) addRootpw(d *directive) {
+	password, ok := d.arg(0)
+	if !ok {
+		im.addUnmapped(d, "locking the root account is not supported by blueprints, it is locked by default")
+		return
+	}
+	d.flag("iscrypted")
+	d.flag("plaintext")
+	cust := im.customizations()
+	cust.User = append(cust.User, blueprint.UserCustomization{
+		Name:     "root",
+		Password: common.ToPtr(password),
+	})
+	im.checkUnusedOpts(d)
+}
+
+func (im *importer) addGroup(d *directive) error {
+	name, _ := d.opt("name")
+	if name == "" {
+		return fmt.Errorf("line %d: group without --name", d.line)
+	}
+	gid, err := d.intOpt("gid")
+	if err != nil {
+		return err
+	}
+	cust := im.customizations()
+	cust.Group = append(cust.Group, blueprint.GroupCustomization{Name: name, GID: gid})
+	im.checkUnusedOpts(d)
+	return nil
+}
+
+func (im *importer) timezone() *blueprint.TimezoneCustomization {
+	cust := im.customizations()
+	if cust.Timezone == nil {
+		cust.Timezone = &blueprint.TimezoneCustomization{}
+	}
+	return cust.Timezone
+}
+
+func (im *importer) addTimezone(d *directive) {
+	tz := im.timezone()
+	if timezone, ok := d.arg(0); ok {
+		tz.Timezone = common.ToPtr(timezone)
+	}
+	tz.NTPServers = append(tz.NTPServers, d.optList("ntpservers")...)
+	// the hardware clock is in UTC by default
+	d.flag("utc")
+	d.flag("isUtc")
+	im.checkUnusedOpts(d)
+}
+
+func (im *importer) addTimesource(d *directive) {
+	server, ok := d.opt("ntp-server")
+	if !ok {
+		im.addUnmapped(d, "only --ntp-server is supported by blueprints")
+		return
+	}
+	tz := im.timezone()
+	tz.NTPServers = append(tz.NTPServers, server)
+	im.checkUnusedOpts(d)
+}
+
+func (im *importer) locale() *blueprint.LocaleCustomization {
+	cust := im.customizations()
+	if cust.Locale == nil {
+		cust.Locale = &blueprint.LocaleCustomization{}
+	}
+	return cust.Locale
+}
+
+func (im *importer) addLang(d *directive) {
+	locale := im.locale()
+	locale.Languages = append(locale.Languages, d.allArgs()...)
+	locale.Languages = append(locale.Languages, d.optList("addsupport")...)
+	im.checkUnusedOpts(d)
+}
+
+func (im *importer) addKeyboard(d *directive) {
+	keymap, _ := d.opt("vckeymap")
+	if arg, ok := d.arg(0); ok && keymap == "" {
+		keymap = arg
+	}
+	// fall back to the first X layout, that is usually also a console
+	// keymap
+	if layouts := d.optList("xlayouts"); keymap == "" && len(layouts) > 0 {
+		keymap = layouts[0]
+	}
+	if keymap == "" {
+		im.addUnmapped(d, "no keymap found")
+		return
+	}
+	im.locale().Keyboard = common.ToPtr(keymap)
+	im.checkUnusedOpts(d)
+}
+
+func (im *importer) addServices(d *directive) {
+	cust := im.customizations()
+	if cust.Services == nil {
+		cust.Services = &blueprint.ServicesCustomization{}
+	}
+	cust.Services.Enabled = append(cust.Services.Enabled, d.optList("enabled")...)
+	cust.Services.Disabled = append(cust.Services.Disabled, d.optList("disabled")...)
+	im.checkUnusedOpts(d)
+}
+
+func (im *importer) addFirewall(d *directive) {
+	if d.flag("disabled") || d.flag("disable") {
+		im.addUnmapped(d, "disabling the firewall is not supported by blueprints")
+		return
+	}
+	d.flag("enabled")
+	d.flag("enable")
+	cust := im.customizations()
+	if cust.Firewall == nil {
+		cust.Firewall = &blueprint.FirewallCustomization{}
+	}
+	fw := cust.Firewall
+	enabled := d.optList("service")
+	if d.flag("ssh") {
+		enabled = append(enabled, "ssh")
+	}
+	disabled := d.optList("remove-service")
+	if d.flag("no-ssh") {
+		disabled = append(disabled, "ssh")
+	}
+	if len(enabled)+len(disabled) > 0 {
+		if fw.Services == nil {
+			fw.Services = &blueprint.FirewallServicesCustomization{}
+		}
+		fw.Services.Enabled = append(fw.Services.Enabled, enabled...)
+		fw.Services.Disabled = append(fw.Services.Disabled, disabled...)
+	}
+	fw.Ports = append(fw.Ports, d.optList("port")...)
+	im.checkUnusedOpts(d)
+}
+
+func (im *importer) addNetwork(d *directive) {
+	hostname, ok := d.opt("hostname")
+	if !ok {
+		im.addUnmapped(d, "network configuration is not supported by blueprints")
+		return
+	}
+	im.customizations().Hostname = common.ToPtr(hostname)
+	im.checkUnusedOpts(d)
+}
+
+func (im *importer) addBootloader(d *directive) {
+	appendArgs, ok := d.opt("append")
+	if !ok {
+		im.addUnmapped(d, "only --append is supported by blueprints")
+		return
+	}
+	cust := im.customizations()
+	if cust.Kernel == nil {
+		cust.Kernel = &blueprint.KernelCustomization{}
+	}
+	cust.Kernel.Append = strings.TrimSpace(cust.Kernel.Append + " " + appendArgs)
+	im.checkUnusedOpts(d)
+}
+
+func (im *importer) addPart(d *directive) error {
+	mntpnt, ok := d.arg(0)
+	if !ok {
+		return fmt.Errorf("line %d: %s without a mountpoint", d.line, d.name)
+	}
+	fstype, _ := d.opt("fstype")
+	switch {
+	case mntpnt == "biosboot" || mntpnt == "prepboot" || mntpnt == "/boot/efi" || fstype == "efi" || fstype == "biosboot":
+		im.addUnmapped(d, "boot partitions are created by the image type")
+		return nil
+	case strings.HasPrefix(mntpnt, "raid.") || strings.HasPrefix(mntpnt, "btrfs.") || mntpnt == "none":
+		im.addUnmapped(d, "only plain partitions and LVM are supported")
+		return nil
+	}
+
+	size, err := d.sizeMiB()
+	if err != nil {
+		return err
+	}
+	if strings.HasPrefix(mntpnt, "pv.") {
+		// the root partition (or volume group) grows to fill the disk
+		d.flag("grow")
+		im.pvs = append(im.pvs, &lvmPartition{directive: d, pvName: mntpnt, idx: len(im.partitions)})
+		im.partitions = append(im.partitions, blueprint.PartitionCustomization{
+			Type:    "lvm",
+			MinSize: size,
+		})
+		im.checkUnusedOpts(d)
+		return nil
+	}
+
+	part := blueprint.PartitionCustomization{
+		Type:    "plain",
+		MinSize: size,
+	}
+	part.FSType = fstype
+	if label, ok := d.opt("label"); ok {
+		part.Label = label
+	}
+	if mntpnt == "swap" {
+		part.FSType = "swap"
+	} else {
+		part.Mountpoint = mntpnt
+	}
+	if mntpnt == "/" {
+		d.flag("grow")
+	}
+	im.partitions = append(im.partitions, part)
+	im.checkUnusedOpts(d)
+	return nil
+}
+
+// buildDisk creates the disk customization from the partitions, volume
+// groups and logical volumes
+func (im *importer) buildDisk() error {
+	vgIdx := make(map[string]int)
+	pvByName := make(map[string]*lvmPartition)
+	for _, pv := range im.pvs {
+		pvByName[pv.pvName] = pv
+	}
+	remove := make(map[int]bool)
+	for _, vg := range im.volgroups {
+		// all arguments are the name and physical volumes
+		vg.allArgs()
+		if len(vg.args) < 2 {
+			im.addUnmapped(vg, "volume groups without physical volumes are not supported")
+			continue
+		}
+		pv, ok := pvByName[vg.args[1]]
+		if !ok {
+			return fmt.Errorf("line %d: unknown physical volume %q", vg.line, vg.args[1])
+		}
+		im.partitions[pv.idx].Name = vg.args[0]
+		vgIdx[vg.args[0]] = pv.idx
+		delete(pvByName, vg.args[1])
+		// the physical volumes are combined into a single partition
+		for _, other := range vg.args[2:] {
+			otherPV, ok := pvByName[other]
+			if !ok {
+				return fmt.Errorf("line %d: unknown physical volume %q", vg.line, other)
+			}
+			im.partitions[pv.idx].MinSize += im.partitions[otherPV.idx].MinSize
+			remove[otherPV.idx] = true
+			delete(pvByName, other)
+		}
+		if len(vg.args) > 2 {
+			im.addUnmapped(vg, "the physical volumes are combined into a single partition")
+		} else {
+			im.checkUnusedOpts(vg)
+		}
+	}
+	for _, pv := range im.pvs {
+		if _, unused := pvByName[pv.pvName]; unused {
+			im.addUnmapped(pv.directive, "physical volume is not used by a volume group")
+			remove[pv.idx] = true
+		}
+	}
+
+	for _, lv := range im.logvols {
+		mntpnt, ok := lv.arg(0)
+		if !ok {
+			return fmt.Errorf("line %d: logvol without a mountpoint", lv.line)
+		}
+		vgName, _ := lv.opt("vgname")
+		idx, ok := vgIdx[vgName]
+		if !ok {
+			return fmt.Errorf("line %d: unknown volume group %q", lv.line, vgName)
+		}
+		size, err := lv.sizeMiB()
+		if err != nil {
+			return err
+		}
+		name, _ := lv.opt("name")
+		newLV := blueprint.LVCustomization{
+			Name:    name,
+			MinSize: size,
+		}
+		newLV.FSType, _ = lv.opt("fstype")
+		newLV.Label, _ = lv.opt("label")
+		if mntpnt == "swap" {
+			newLV.FSType = "swap"
+		} else {
+			newLV.Mountpoint = mntpnt
+		}
+		if mntpnt == "/" {
+			lv.flag("grow")
+		}
+		im.partitions[idx].LogicalVolumes = append(im.partitions[idx].LogicalVolumes, newLV)
+		im.checkUnusedOpts(lv)
+	}
+
+	var partitions []blueprint.PartitionCustomization
+	for idx, part := range im.partitions {
+		if !remove[idx] {
+			partitions = append(partitions, part)
+		}
+	}
+	if len(partitions) > 0 || im.diskType != "" {
+		im.customizations().Disk = &blueprint.DiskCustomization{
+			Type:       im.diskType,
+			Partitions: partitions,
+		}
+	}
+	return nil
+}
+
+func (im *importer) addSSHKeys() {
+	for _, d := range im.sshkeys {
+		username, _ := d.opt("username")
+		key, ok := d.arg(0)
+		if username == "" || !ok {
+			im.addUnmapped(d, "sshkey needs --username and a key")
+			continue
+		}
+		cust := im.customizations()
+		idx := slices.IndexFunc(cust.User, func(u blueprint.UserCustomization) bool {
+			return u.Name == username
+		})
+		switch {
+		case idx < 0:
+			cust.SSHKey = append(cust.SSHKey, blueprint.SSHKeyCustomization{User: username, Key: key})
+		case cust.User[idx].Key != nil:
+			cust.User[idx].Key = common.ToPtr(*cust.User[idx].Key + "\n" + key)
+		default:
+			cust.User[idx].Key = common.ToPtr(key)
+		}
+		im.checkUnusedOpts(d)
+	}
+}
+
+func (im *importer) addDirective(d *directive) error {
+	switch d.name {
+	case "user":
+		return im.addUser(d)
+	case "rootpw":
+		im.addRootpw(d)
+	case "group":
+		return im.addGroup(d)
+	case "sshkey":
+		// users can be defined after their keys
+		im.sshkeys = append(im.sshkeys, d)
+	case "timezone":
+		im.addTimezone(d)
+	case "timesource":
+		im.addTimesource(d)
+	case "lang":
+		im.addLang(d)
+	case "keyboard":
+		im.addKeyboard(d)
+	case "services":
+		im.addServices(d)
+	case "firewall":
+		im.addFirewall(d)
+	case "network":
+		im.addNetwork(d)
+	case "bootloader":
+		im.addBootloader(d)
+	case "part", "partition":
+		return im.addPart(d)
+	case "volgroup":
+		im.volgroups = append(im.volgroups, d)
+	case "logvol":
+		im.logvols = append(im.logvols, d)
+	case "autopart":
+		im.addUnmapped(d, "the default partitioning of the image type is used")
+	case "reqpart":
+		im.addUnmapped(d, "boot partitions are created by the image type")
+	case "clearpart":
+		// images always start with an empty disk so only the disk label
+		// is relevant
+		switch label, _ := d.opt("disklabel"); label {
+		case "":
+			im.addUnmapped(d, "only relevant when installing")
+		case "gpt":
+			im.diskType = "gpt"
+		case "msdos":
+			im.diskType = "dos"
+		default:
+			im.addUnmapped(d, fmt.Sprintf("unknown disk label %q", label))
+		}
+	case "%include", "%ksappend":
+		im.addUnmapped(d, "included files are not read")
+	case "repo":
+		im.addUnmapped(d, "use customizations.repositories or --extra-repo instead")
+	default:
+		if installOnly[d.name] {
+			im.addUnmapped(d, "only relevant when installing")
+		} else {
+			im.addUnmapped(d, "not supported by blueprints")
+		}
+	}
+	return nil
+}
+
+// ToBlueprint converts a kickstart file to a blueprint. It maps
+// %packages, users, groups, ssh keys, timezone, lang, keyboard,
+// services, firewall, the hostname, the kernel arguments of the
+// bootloader and part/volgroup/logvol (as disk customizations). All
+// directives (and options) that cannot be mapped are returned as
+// Unmapped.
+func ToBlueprint(r io.Reader) (*blueprint.Blueprint, []Unmapped, error) {
+	im := &importer{bp: &blueprint.Blueprint{}}
+
+	// the section that is read and its lines
+	var section *directive
+	var sectionLines []sectionLine
+
+	scanner := bufio.NewScanner(r)
+	for lineno := 1; scanner.Scan(); lineno++ {
+		line := strings.TrimSpace(scanner.Text())
+		if section != nil {
+			if line == "%end" {
+				if section.name == "%packages" {
+					im.addPackages(section, sectionLines)
+				}
+				section = nil
+				continue
+			}
+			if section.name == "%packages" && line != "" && !strings.HasPrefix(line, "#") {
+				sectionLines = append(sectionLines, sectionLine{line: lineno, text: line})
+			}
+			continue
+		}
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+
+		d, err := parseDirective(lineno, line)
+		if err != nil {
+			return nil, nil, err
+		}
+		if strings.HasPrefix(d.name, "%") && d.name != "%include" && d.name != "%ksappend" {
+			section = d
+			sectionLines = nil
+			if d.name != "%packages" {
+				im.addUnmapped(d, "scripts and add-on sections are not supported by blueprints")
+			}
+			continue
+		}
+		if err := im.addDirective(d); err != nil {
+			return nil, nil, err
+		}
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, nil, err
+	}
+	if section != nil {
+		return nil, nil, fmt.Errorf("line %d: %s without %%end", section.line, section.name)
+	}
+
+	if err := im.buildDisk(); err != nil {
+		return nil, nil, err
+	}
+	im.addSSHKeys()
+
+	slices.SortStableFunc(im.unmapped, func(a, b Unmapped) int {
+		return a.Line - b.Line
+	})
+	return im.bp, im.unmapped, nil
+}
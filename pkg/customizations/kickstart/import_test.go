@@ -0,0 +1,198 @@
+package kickstart_test
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/osbuild/blueprint/pkg/blueprint"
+	"github.com/osbuild/image-builder/internal/common"
+	"github.com/osbuild/image-builder/pkg/customizations/kickstart"
+	"github.com/osbuild/image-builder/pkg/datasizes"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+const testKickstart = `# a test kickstart
+text
+url --url=http://example.com/os
+lang en_US.UTF-8 --addsupport=de_DE.UTF-8
+keyboard --vckeymap=us --xlayouts='us'
+timezone Europe/Berlin --utc --ntpservers=0.pool.ntp.org
+network --bootproto=dhcp --hostname=ks-host
+rootpw --iscrypted $6$salt$hash
+user --name=alice --groups=wheel --uid=1000 --gid 1000 --password=secret --plaintext --lock
+group --name=admins --gid=2000
+sshkey --username=alice "ssh-ed25519 AAAA alice@example"
+sshkey --username=bob "ssh-ed25519 BBBB bob@example"
+services --enabled sshd,chronyd --disabled=kdump
+firewall --enabled --ssh --service=http --port=8080:tcp
+bootloader --location=mbr --append="console=ttyS0 quiet"
+selinux --enforcing
+zerombr
+clearpart --all --initlabel --disklabel=gpt
+reqpart
+part /boot --fstype=xfs --size=1024
+part swap --size=512
+part pv.01 --size=10240 --grow --ondisk=sda
+volgroup rootvg pv.01
+logvol / --vgname=rootvg --name=rootlv --fstype=xfs --size=5120 --grow
+logvol /home --vgname=rootvg --name=homelv --fstype=ext4 --size=2048 --label=home
+
+%packages --ignoremissing
+@core
+@^server-product-environment
+vim-enhanced
+# no docs
+-man-pages
+%end
+
+%post
+echo hello
+%end
+`
+
+func TestToBlueprint(t *testing.T) {
+	bp, unmapped, err := kickstart.ToBlueprint(strings.NewReader(testKickstart))
+	require.NoError(t, err)
+
+	assert.Equal(t, []blueprint.Package{{Name: "vim-enhanced"}}, bp.Packages)
+	assert.Equal(t, []blueprint.Group{{Name: "core"}}, bp.Groups)
+
+	expected := &blueprint.Customizations{
+		Hostname: common.ToPtr("ks-host"),
+		Kernel:   &blueprint.KernelCustomization{Append: "console=ttyS0 quiet"},
+		SSHKey: []blueprint.SSHKeyCustomization{
+			{User: "bob", Key: "ssh-ed25519 BBBB bob@example"},
+		},
+		User: []blueprint.UserCustomization{
+			{
+				Name:     "root",
+				Password: common.ToPtr("$6$salt$hash"),
+			},
+			{
+				Name:     "alice",
+				Password: common.ToPtr("secret"),
+				Key:      common.ToPtr("ssh-ed25519 AAAA alice@example"),
+				Groups:   []string{"wheel"},
+				UID:      common.ToPtr(1000),
+				GID:      common.ToPtr(1000),
+			},
+		},
+		Group: []blueprint.GroupCustomization{
+			{Name: "admins", GID: common.ToPtr(2000)},
+		},
+		Timezone: &blueprint.TimezoneCustomization{
+			Timezone:   common.ToPtr("Europe/Berlin"),
+			NTPServers: []string{"0.pool.ntp.org"},
+		},
+		Locale: &blueprint.LocaleCustomization{
+			Languages: []string{"en_US.UTF-8", "de_DE.UTF-8"},
+			Keyboard:  common.ToPtr("us"),
+		},
+		Firewall: &blueprint.FirewallCustomization{
+			Ports: []string{"8080:tcp"},
+			Services: &blueprint.FirewallServicesCustomization{
+				Enabled: []string{"http", "ssh"},
+			},
+		},
+		Services: &blueprint.ServicesCustomization{
+			Enabled:  []string{"sshd", "chronyd"},
+			Disabled: []string{"kdump"},
+		},
+		Disk: &blueprint.DiskCustomization{
+			Type: "gpt",
+			Partitions: []blueprint.PartitionCustomization{
+				{
+					Type:    "plain",
+					MinSize: 1 * datasizes.GiB,
+					FilesystemTypedCustomization: blueprint.FilesystemTypedCustomization{
+						Mountpoint: "/boot",
+						FSType:     "xfs",
+					},
+				},
+				{
+					Type:    "plain",
+					MinSize: 512 * datasizes.MiB,
+					FilesystemTypedCustomization: blueprint.FilesystemTypedCustomization{
+						FSType: "swap",
+					},
+				},
+				{
+					Type:    "lvm",
+					MinSize: 10 * datasizes.GiB,
+					VGCustomization: blueprint.VGCustomization{
+						Name: "rootvg",
+						LogicalVolumes: []blueprint.LVCustomization{
+							{
+								Name:    "rootlv",
+								MinSize: 5 * datasizes.GiB,
+								FilesystemTypedCustomization: blueprint.FilesystemTypedCustomization{
+									Mountpoint: "/",
+									FSType:     "xfs",
+								},
+							},
+							{
+								Name:    "homelv",
+								MinSize: 2 * datasizes.GiB,
+								FilesystemTypedCustomization: blueprint.FilesystemTypedCustomization{
+									Mountpoint: "/home",
+									Label:      "home",
+									FSType:     "ext4",
+								},
+							},
+						},
+					},
+				},
+			},
+		},
+	}
+	assert.Equal(t, expected, bp.Customizations)
+
+	assert.Equal(t, []kickstart.Unmapped{
+		{Line: 2, Directive: "text", Reason: "only relevant when installing"},
+		{Line: 3, Directive: "url --url=http://example.com/os", Reason: "only relevant when installing"},
+		{Line: 7, Directive: "network --bootproto=dhcp --hostname=ks-host", Reason: "options not supported by blueprints: --bootproto"},
+		{Line: 9, Directive: "user --name=alice --groups=wheel --uid=1000 --gid 1000 --password=secret --plaintext --lock", Reason: "options not supported by blueprints: --lock"},
+		{Line: 15, Directive: `bootloader --location=mbr --append="console=ttyS0 quiet"`, Reason: "options not supported by blueprints: --location"},
+		{Line: 16, Directive: "selinux --enforcing", Reason: "not supported by blueprints"},
+		{Line: 17, Directive: "zerombr", Reason: "only relevant when installing"},
+		{Line: 19, Directive: "reqpart", Reason: "boot partitions are created by the image type"},
+		{Line: 22, Directive: "part pv.01 --size=10240 --grow --ondisk=sda", Reason: "options not supported by blueprints: --ondisk"},
+		{Line: 27, Directive: "%packages --ignoremissing", Reason: "%packages option --ignoremissing is not supported by blueprints"},
+		{Line: 29, Directive: "@^server-product-environment", Reason: "environment groups are not supported by blueprints, add their groups instead"},
+		{Line: 32, Directive: "-man-pages", Reason: "excluding packages is not supported by blueprints"},
+		{Line: 35, Directive: "%post", Reason: "scripts and add-on sections are not supported by blueprints"},
+	}, unmapped)
+}
+
+func TestToBlueprintUnusedArgs(t *testing.T) {
+	ks := "services sshd --enabled=chronyd\ntimezone Europe/Berlin UTC\n"
+	bp, unmapped, err := kickstart.ToBlueprint(strings.NewReader(ks))
+	require.NoError(t, err)
+
+	assert.Equal(t, []string{"chronyd"}, bp.Customizations.Services.Enabled)
+	assert.Equal(t, common.ToPtr("Europe/Berlin"), bp.Customizations.Timezone.Timezone)
+	assert.Equal(t, []kickstart.Unmapped{
+		{Line: 1, Directive: "services sshd --enabled=chronyd", Reason: "arguments not supported by blueprints: sshd"},
+		{Line: 2, Directive: "timezone Europe/Berlin UTC", Reason: "arguments not supported by blueprints: UTC"},
+	}, unmapped)
+}
+
+func TestToBlueprintErrors(t *testing.T) {
+	for _, tc := range []struct {
+		ks     string
+		expErr string
+	}{
+		{"user --groups=wheel\n", "line 1: user without --name"},
+		{"text\nuser --name=alice --uid=alice\n", `line 2: invalid --uid "alice"`},
+		{"part / --size=big\n", `line 1: invalid size "big"`},
+		{"%packages\nvim\n", "line 1: %packages without %end"},
+		{"logvol / --vgname=missing --size=1\n", `line 1: unknown volume group "missing"`},
+		{`network --hostname="foo` + "\n", "line 1: unterminated quote"},
+	} {
+		t.Run(tc.expErr, func(t *testing.T) {
+			_, _, err := kickstart.ToBlueprint(strings.NewReader(tc.ks))
+			assert.EqualError(t, err, tc.expErr)
+		})
+	}
+}
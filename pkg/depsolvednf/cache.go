@@ -106,23 +106,31 @@ func newRPMCache(path string, maxSize uint64) *rpmCache {
 	return r
 }
 
-// updateInfo updates the repoPaths and repoRecency fields of the rpmCache.
-//
-// NOTE: This does not return any errors. This is because the most common one
-// will be a nonexistant directory which will be created later, during initial
-// cache creation. Any other errors like permission issues will be caught by
-// later use of the cache. eg. touchRepo
-func (r *rpmCache) updateInfo() {
-	// reset rpmCache fields used for accumulation
-	r.size = 0
-	r.repoElements = make(map[string]pathInfo)
+// CacheEntry is the cached metadata of a single repository in one of the
+// distro specific directories of the rpm metadata cache.
+type CacheEntry struct {
+	// RepoID is the hash of the repository configuration
+	RepoID string
+	// Dir is the name of the distro specific directory below the cache
+	// root, see Solver.GetCacheDir()
+	Dir   string
+	Paths []string
+	Size  uint64
+	// MTime is the last time the repository was used
+	MTime time.Time
+}
 
-	repos := make(map[string]pathInfo)
-	repoIDs := make([]string, 0)
+// ReadCache returns the repository metadata entries of the cache at root,
+// the least recently used entries first.
+//
+// NOTE: Like updateInfo() this does not return any errors, unreadable
+// entries are skipped.
+func ReadCache(root string) []CacheEntry {
+	var entries []CacheEntry
 
-	dirs, _ := os.ReadDir(r.root)
+	dirs, _ := os.ReadDir(root)
 	for _, d := range dirs {
-		path := filepath.Join(r.root, d.Name())
+		path := filepath.Join(root, d.Name())
 
 		// See updateInfo NOTE on error handling
 		cacheEntries, _ := os.ReadDir(path)
@@ -131,6 +139,7 @@ func (r *rpmCache) updateInfo() {
 		// We assume the first 64 characters of a file or directory name are the
 		// repository ID because we use a sha256 sum of the repository config to
 		// create the ID (64 hex chars)
+		byRepoID := make(map[string]int)
 		for _, entry := range cacheEntries {
 			eInfo, err := entry.Info()
 			if err != nil {
@@ -144,12 +153,6 @@ func (r *rpmCache) updateInfo() {
 				continue
 			}
 			repoID := fname[:64]
-			repo, ok := repos[repoID]
-			if !ok {
-				// new repo ID
-				repoIDs = append(repoIDs, repoID)
-			}
-			mtime := eInfo.ModTime()
 			ePath := filepath.Join(path, entry.Name())
 
 			// calculate and add entry size
@@ -158,40 +161,65 @@ func (r *rpmCache) updateInfo() {
 				// skip it
 				continue
 			}
-			repo.size += size
 
-			// add path
-			repo.paths = append(repo.paths, ePath)
+			idx, ok := byRepoID[repoID]
+			if !ok {
+				entries = append(entries, CacheEntry{RepoID: repoID, Dir: d.Name()})
+				idx = len(entries) - 1
+				byRepoID[repoID] = idx
+			}
+			e := &entries[idx]
+			e.Size += size
+			e.Paths = append(e.Paths, ePath)
 
 			// if for some reason the mtimes of the various entries of a single
 			// repository are out of sync, use the most recent one
-			if repo.mtime.Before(mtime) {
-				repo.mtime = mtime
+			if mtime := eInfo.ModTime(); e.MTime.Before(mtime) {
+				e.MTime = mtime
 			}
+		}
+	}
 
-			// update the collection
-			repos[repoID] = repo
+	// sort by mtime (oldest first)
+	slices.SortStableFunc(entries, func(a, b CacheEntry) int {
+		return a.MTime.Compare(b.MTime)
+	})
+	return entries
+}
+
+// updateInfo updates the repoPaths and repoRecency fields of the rpmCache.
+//
+// NOTE: This does not return any errors. This is because the most common one
+// will be a nonexistant directory which will be created later, during initial
+// cache creation. Any other errors like permission issues will be caught by
+// later use of the cache. eg. touchRepo
+func (r *rpmCache) updateInfo() {
+	// reset rpmCache fields used for accumulation
+	r.size = 0
+	r.repoElements = make(map[string]pathInfo)
+	r.repoRecency = nil
 
-			// update rpmCache object
-			r.repoElements[repoID] = repo
-			r.size += size
+	// the same repository can be cached for multiple distros, the
+	// entries are already sorted by mtime so the first one of a repo
+	// ID decides its position
+	for _, e := range ReadCache(r.root) {
+		repo, ok := r.repoElements[e.RepoID]
+		if !ok {
+			r.repoRecency = append(r.repoRecency, e.RepoID)
 		}
+		repo.paths = append(repo.paths, e.Paths...)
+		repo.size += e.Size
+		if repo.mtime.Before(e.MTime) {
+			repo.mtime = e.MTime
+		}
+		r.repoElements[e.RepoID] = repo
+		r.size += e.Size
 	}
 
 	// sort IDs by mtime (oldest first)
-	slices.SortFunc(repoIDs, func(a, b string) int {
-		ir := repos[a]
-		jr := repos[b]
-		if ir.mtime.Before(jr.mtime) {
-			return -1
-		}
-		if jr.mtime.Before(ir.mtime) {
-			return 1
-		}
-		return 0
+	slices.SortStableFunc(r.repoRecency, func(a, b string) int {
+		return r.repoElements[a].mtime.Compare(r.repoElements[b].mtime)
 	})
-
-	r.repoRecency = repoIDs
 }
 
 func (r *rpmCache) shrink() error {
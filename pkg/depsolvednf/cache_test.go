@@ -204,6 +204,32 @@ func TestMultiDirCacheRead(t *testing.T) {
 	})
 }
 
+func TestReadCache(t *testing.T) {
+	testCacheRoot := t.TempDir()
+	createTestCache(filepath.Join(testCacheRoot, "platform:el8-8.4-aarch64"), testCfgs["rhel84-aarch64"])
+	createTestCache(filepath.Join(testCacheRoot, "fake-real"), testCfgs["fake-real"])
+
+	entries := ReadCache(testCacheRoot)
+	assert.Len(t, entries, len(getRepoIDs(testCfgs["rhel84-aarch64"]))+len(getRepoIDs(testCfgs["fake-real"])))
+	var last time.Time
+	for _, e := range entries {
+		assert.False(t, e.MTime.Before(last), "entries are not sorted by mtime")
+		last = e.MTime
+		for _, p := range e.Paths {
+			assert.Equal(t, filepath.Join(testCacheRoot, e.Dir), filepath.Dir(p))
+			assert.True(t, strings.HasPrefix(filepath.Base(p), e.RepoID))
+		}
+	}
+	// the most recently used repository of the rhel cache
+	newest := entries[len(entries)-1]
+	assert.Equal(t, "df2665154150abf76f4d86156228a75c39f3f31a79d4a861d76b1edd89814b62", newest.RepoID)
+	assert.Equal(t, "platform:el8-8.4-aarch64", newest.Dir)
+	assert.Len(t, newest.Paths, 3)
+	assert.Equal(t, time.Unix(300, 0), newest.MTime)
+
+	assert.Empty(t, ReadCache(filepath.Join(testCacheRoot, "does-not-exist")))
+}
+
 func sizeSum(cfg testCache, repoIDFilter ...string) uint64 {
 	var sum uint64
 	for path, info := range cfg {
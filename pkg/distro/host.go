@@ -21,19 +21,29 @@ func GetHostDistroName() (string, error) {
 	if err != nil {
 		return "", fmt.Errorf("cannot get the host distro name: %w", err)
 	}
+	name, err := DistroNameFromOSRelease(osrelease)
+	if err != nil {
+		return "", fmt.Errorf("cannot get the host distro name: %w", err)
+	}
+	return name, nil
+}
 
+// DistroNameFromOSRelease returns the name of the distribution, such as
+// "fedora-32" or "rhel-8.2", that the given os-release(5) fields describe.
+func DistroNameFromOSRelease(osrelease map[string]string) (string, error) {
 	if _, ok := osrelease["ID"]; !ok {
-		return "", errors.New("cannot get the host distro name: missing ID field in os-release")
+		return "", errors.New("missing ID field in os-release")
 	}
 
 	if _, ok := osrelease["VERSION_ID"]; !ok {
-		return "", errors.New("cannot get the host distro name: missing VERSION_ID field in os-release")
+		return "", errors.New("missing VERSION_ID field in os-release")
 	}
 
+	id := osrelease["ID"]
 	// In some cases (Alma Linux Kitten) `ID + VERSION_ID` is not the correct
 	// approach to determine the distribution. For Kitten the `/etc/os-release`
 	// file contains a `VERSION_ID` without a minor. We need to special case this:
-	if osrelease["ID"] == "almalinux" {
+	if id == "almalinux" {
 		versionParts := strings.Split(osrelease["VERSION_ID"], ".")
 
 		if len(versionParts) > 2 {
@@ -58,22 +68,22 @@ func GetHostDistroName() (string, error) {
 		// not verify the major version but it's not guaranteed that with an eventual
 		// version 11 we will need to do this swap as well.
 		if majorVersion == 10 && minorVersion == -1 {
-			osrelease["ID"] = "almalinux_kitten"
+			id = "almalinux_kitten"
 		}
 	}
 
 	// Oracle Linux distro definition names only include the major version,
 	// so format the returned distro name accordingly.
-	if osrelease["ID"] == "ol" {
+	if id == "ol" {
 		versionParts := strings.Split(osrelease["VERSION_ID"], ".")
 		// We only use versionParts[0], but check os-release is valid for safety
 		if len(versionParts) < 2 {
 			return "", errors.New("Failed to parse version from os-release, not enough dotted parts")
 		}
-		return osrelease["ID"] + "-" + versionParts[0], nil
+		return id + "-" + versionParts[0], nil
 	}
 
-	name := osrelease["ID"] + "-" + osrelease["VERSION_ID"]
+	name := id + "-" + osrelease["VERSION_ID"]
 
 	return name, nil
 }
@@ -2,6 +2,7 @@ package distro
 
 import (
 	"fmt"
+	"maps"
 	"reflect"
 	"slices"
 	"strings"
@@ -17,19 +18,38 @@ type ImageTypeValidator interface {
 	SupportedBlueprintOptions() []string
 }
 
-type validationError struct {
+// ValidationError is an option of a blueprint that is not supported by
+// an image type or a required option that is missing.
+type ValidationError struct {
 	// Reverse path to the customization that caused the error.
 	revPath []string
 	message string
 }
 
-func (e validationError) Error() string {
-	path := e.revPath
+// Path returns the path to the option, e.g. "customizations.user[1].name".
+func (e ValidationError) Path() string {
+	path := slices.Clone(e.revPath)
 	slices.Reverse(path)
-	return fmt.Sprintf("%s: %s", strings.Join(path, "."), e.message)
+	return strings.Join(path, ".")
 }
 
-func validateSupportedConfig(supported []string, conf reflect.Value) *validationError {
+func (e ValidationError) Message() string {
+	return e.message
+}
+
+func (e ValidationError) Error() string {
+	return fmt.Sprintf("%s: %s", e.Path(), e.message)
+}
+
+// prependPath adds the given key to the path of all errors
+func prependPath(errs []*ValidationError, key string) []*ValidationError {
+	for _, err := range errs {
+		err.revPath = append(err.revPath, key)
+	}
+	return errs
+}
+
+func validateSupportedConfig(supported []string, conf reflect.Value) []*ValidationError {
 
 	// Construct two maps:
 	//  - subMap represents the keys on the current level of the recursion that
@@ -65,14 +85,13 @@ func validateSupportedConfig(supported []string, conf reflect.Value) *validation
 		}
 	}
 
+	var errs []*ValidationError
 	confT := conf.Type()
 	for fieldIdx := 0; fieldIdx < confT.NumField(); fieldIdx++ {
 		fieldT := confT.Field(fieldIdx)
 		if fieldT.Anonymous {
 			// embedded struct: flatten with the parent
-			if err := validateSupportedConfig(supported, conf.Field(fieldIdx)); err != nil {
-				return err
-			}
+			errs = append(errs, validateSupportedConfig(supported, conf.Field(fieldIdx))...)
 			continue
 		}
 
@@ -86,7 +105,7 @@ func validateSupportedConfig(supported []string, conf reflect.Value) *validation
 			// as empty
 			empty := field.IsZero() || (field.Kind() == reflect.Slice && field.Len() == 0)
 			if !empty && !supportedMap[tag] {
-				return &validationError{message: "not supported", revPath: []string{tag}}
+				errs = append(errs, &ValidationError{message: "not supported", revPath: []string{tag}})
 			}
 			continue
 		}
@@ -105,30 +124,25 @@ func validateSupportedConfig(supported []string, conf reflect.Value) *validation
 		case reflect.Slice:
 			// iterate over slice and validate each element as a substructure
 			for sliceIdx := 0; sliceIdx < subStruct.Len(); sliceIdx++ {
-				if err := validateSupportedConfig(subList, subStruct.Index(sliceIdx)); err != nil {
-					err.revPath = append(err.revPath, fmt.Sprintf("%s[%d]", tag, sliceIdx))
-					return err
-				}
+				subErrs := validateSupportedConfig(subList, subStruct.Index(sliceIdx))
+				errs = append(errs, prependPath(subErrs, fmt.Sprintf("%s[%d]", tag, sliceIdx))...)
 			}
 		case reflect.Struct:
 			// single element
-			if err := validateSupportedConfig(subList, subStruct); err != nil {
-				err.revPath = append(err.revPath, tag)
-				return err
-			}
+			errs = append(errs, prependPath(validateSupportedConfig(subList, subStruct), tag)...)
 		case reflect.Int, reflect.Bool, reflect.String:
 			// this can happen if the supported list contains an invalid
 			// string, where a non-container type field is followed by a
 			// period, for example, "a.b" where a is an integer
-			return &validationError{message: fmt.Sprintf("internal error: supported list specifies child element of non-container type %v: %v", subStruct.Kind(), subStruct), revPath: []string{tag}}
+			errs = append(errs, &ValidationError{message: fmt.Sprintf("internal error: supported list specifies child element of non-container type %v: %v", subStruct.Kind(), subStruct), revPath: []string{tag}})
 		default:
 			// this can happen if the config uses a container type that's
 			// not a struct or an array (e.g. a map).
-			return &validationError{message: fmt.Sprintf("internal error: unexpected field type: %v (%v)", subStruct.Kind(), subStruct), revPath: []string{tag}}
+			errs = append(errs, &ValidationError{message: fmt.Sprintf("internal error: unexpected field type: %v (%v)", subStruct.Kind(), subStruct), revPath: []string{tag}})
 		}
 	}
 
-	return nil
+	return errs
 }
 
 func jsonTagFor(f reflect.StructField) string {
@@ -156,7 +170,7 @@ func fieldByTag(p reflect.Value, tag string) (reflect.Value, error) {
 	return reflect.Value{}, fmt.Errorf("%s does not have a field with JSON tag %q", p.Type().Name(), tag)
 }
 
-func validateRequiredConfig(required []string, conf reflect.Value) *validationError {
+func validateRequiredConfig(required []string, conf reflect.Value) []*ValidationError {
 	// create two maps from the required list:
 	//
 	// 1. requiredMap contains the keys that must exist at this level as
@@ -189,7 +203,10 @@ func validateRequiredConfig(required []string, conf reflect.Value) *validationEr
 		}
 	}
 
-	for key := range requiredMap {
+	var errs []*ValidationError
+	// keys that are missing, their required subkeys are not checked
+	missing := make(map[string]bool)
+	for _, key := range slices.Sorted(maps.Keys(requiredMap)) {
 		// requiredMap contains keys that are required at this level, whether
 		// they have subkeys or not.
 		// Their values should be non-zero but only for certain types:
@@ -198,7 +215,9 @@ func validateRequiredConfig(required []string, conf reflect.Value) *validationEr
 		// shouldn't assume that a zero value is the same as a missing one.
 		value, err := fieldByTag(conf, key)
 		if err != nil {
-			return &validationError{message: err.Error(), revPath: []string{key}}
+			errs = append(errs, &ValidationError{message: err.Error(), revPath: []string{key}})
+			missing[key] = true
+			continue
 		}
 		switch value.Kind() {
 		case reflect.Pointer, reflect.Struct, reflect.String, reflect.Slice:
@@ -206,14 +225,19 @@ func validateRequiredConfig(required []string, conf reflect.Value) *validationEr
 			// For other types, the zero value can be valid and not indicate a
 			// missing value.
 			if value.IsZero() {
-				return &validationError{message: "required", revPath: []string{key}}
+				errs = append(errs, &ValidationError{message: "required", revPath: []string{key}})
+				missing[key] = true
 			}
 		default:
-			return &validationError{message: fmt.Sprintf("field of type %v cannot be marked required", value.Kind()), revPath: []string{key}}
+			errs = append(errs, &ValidationError{message: fmt.Sprintf("field of type %v cannot be marked required", value.Kind()), revPath: []string{key}})
+			missing[key] = true
 		}
 	}
 
-	for key := range subMap {
+	for _, key := range slices.Sorted(maps.Keys(subMap)) {
+		if missing[key] {
+			continue
+		}
 		// subMap contains keys that should contain specific subkeys.
 		// If the key's value is Zero, that's an error, but that should be
 		// caught by the requiredMap checks above.
@@ -221,7 +245,8 @@ func validateRequiredConfig(required []string, conf reflect.Value) *validationEr
 		// If it's s Slice, descend into each element.
 		value, err := fieldByTag(conf, key)
 		if err != nil {
-			return &validationError{message: err.Error(), revPath: []string{key}}
+			errs = append(errs, &ValidationError{message: err.Error(), revPath: []string{key}})
+			continue
 		}
 		if value.Kind() == reflect.Pointer {
 			// Dereference pointer before validating.
@@ -232,47 +257,46 @@ func validateRequiredConfig(required []string, conf reflect.Value) *validationEr
 		switch value.Kind() {
 		case reflect.Struct:
 			// Descend into map
-			if err := validateRequiredConfig(subMap[key], value); err != nil {
-				err.revPath = append(err.revPath, key)
-				return err
-			}
+			errs = append(errs, prependPath(validateRequiredConfig(subMap[key], value), key)...)
 		case reflect.Slice:
 			// iterate over slice and validate each element
 			for idx := 0; idx < value.Len(); idx++ {
-				if err := validateRequiredConfig(subMap[key], value.Index(idx)); err != nil {
-					err.revPath = append(err.revPath, fmt.Sprintf("%s[%d]", key, idx))
-					return err
-				}
+				subErrs := validateRequiredConfig(subMap[key], value.Index(idx))
+				errs = append(errs, prependPath(subErrs, fmt.Sprintf("%s[%d]", key, idx))...)
 			}
 		case reflect.String:
 			// this can happen if the required list contains an invalid
 			// string, where a non-container type field is followed by a
 			// period, for example, "a.b" where a is a string
-			return &validationError{message: fmt.Sprintf("internal error: required list specifies child element of non-container type %v: %v", value.Kind(), value), revPath: []string{key}}
+			errs = append(errs, &ValidationError{message: fmt.Sprintf("internal error: required list specifies child element of non-container type %v: %v", value.Kind(), value), revPath: []string{key}})
 		default:
 			// this should never happen, because we check above that only
 			// struct, string, and slice types can be required (and ptr types
 			// are dereferenced before the switch)
-			return &validationError{message: fmt.Sprintf("internal error: unexpected field type: %v (%v)", value.Kind(), value), revPath: []string{key}}
+			errs = append(errs, &ValidationError{message: fmt.Sprintf("internal error: unexpected field type: %v (%v)", value.Kind(), value), revPath: []string{key}})
 		}
 	}
-	return nil
+	return errs
 }
 
 func ValidateConfig(t ImageTypeValidator, bp blueprint.Blueprint) error {
-	bpv := reflect.ValueOf(bp)
-	if err := validateSupportedConfig(t.SupportedBlueprintOptions(), bpv); err != nil {
-		return err
-	}
-
-	// note that validateRequiredConfig() returns a *validationError not a
-	// normal "error", hence the special handling below
-	if err := validateRequiredConfig(t.RequiredBlueprintOptions(), bpv); err != nil {
-		return err
+	// note that ValidateConfigAll() returns *ValidationErrors not
+	// normal "errors", hence the special handling below
+	if errs := ValidateConfigAll(t, bp); len(errs) > 0 {
+		return errs[0]
 	}
 
 	// explicitly return nil when there is no error, otherwise the error type
-	// will be validationError instead of nil
+	// will be ValidationError instead of nil
 	// https://go.dev/doc/faq#nil_error
 	return nil
 }
+
+// ValidateConfigAll returns all options of the blueprint that are not
+// supported by the image type and all required options that are missing,
+// unlike ValidateConfig() which stops at the first one.
+func ValidateConfigAll(t ImageTypeValidator, bp blueprint.Blueprint) []*ValidationError {
+	bpv := reflect.ValueOf(bp)
+	errs := validateSupportedConfig(t.SupportedBlueprintOptions(), bpv)
+	return append(errs, validateRequiredConfig(t.RequiredBlueprintOptions(), bpv)...)
+}
@@ -590,6 +590,63 @@ func TestValidateConfig(t *testing.T) {
 	}
 }
 
+func TestValidateConfigAll(t *testing.T) {
+	testImage := &TestImageType{
+		name: "test",
+		supportedOptions: []string{
+			"packages",
+			"customizations.installation_device",
+			"customizations.user.name",
+		},
+		requiredOptions: []string{
+			"customizations.installation_device",
+			"customizations.user.name",
+		},
+	}
+	bp := blueprint.Blueprint{
+		Packages: []blueprint.Package{
+			{Name: "vim"},
+		},
+		Modules: []blueprint.Package{
+			{Name: "nodejs"},
+		},
+		Customizations: &blueprint.Customizations{
+			User: []blueprint.UserCustomization{
+				{
+					Name: "mario",
+					Key:  common.ToPtr("ssh-key"),
+				},
+				{
+					Key: common.ToPtr("ssh-key"),
+				},
+			},
+		},
+	}
+
+	var errs []string
+	var paths []string
+	for _, err := range distro.ValidateConfigAll(testImage, bp) {
+		errs = append(errs, err.Error())
+		paths = append(paths, err.Path())
+	}
+	assert.Equal(t, []string{
+		"modules: not supported",
+		"customizations.user[0].key: not supported",
+		"customizations.user[1].key: not supported",
+		"customizations.installation_device: required",
+		"customizations.user[1].name: required",
+	}, errs)
+	// the path is not modified by Error()
+	assert.Equal(t, "customizations.user[1].name", paths[4])
+
+	assert.Empty(t, distro.ValidateConfigAll(testImage, blueprint.Blueprint{
+		Customizations: &blueprint.Customizations{
+			InstallationDevice: "/dev/sda",
+			User:               []blueprint.UserCustomization{{Name: "mario"}},
+		},
+	}))
+}
+
 func TestValidateSupportedConfig(t *testing.T) {
 	type testCase struct {
 		supported []string
@@ -123,6 +123,7 @@ func New(reporegistry *reporegistry.RepoRegistry, opts *Options) (*Generator, er
 		depsolve:               opts.Depsolve,
 		containerResolver:      opts.ContainerResolver,
 		commitResolver:         opts.CommitResolver,
+		flatpakResolver:        opts.FlatpakResolver,
 		rpmDownloader:          opts.RpmDownloader,
 		sbomWriter:             opts.SBOMWriter,
 		warningsOutput:         opts.WarningsOutput,
@@ -136,9 +137,7 @@ func New(reporegistry *reporegistry.RepoRegistry, opts *Options) (*Generator, er
 		mg.depsolve = DefaultDepsolve
 	}
 	if mg.containerResolver == nil {
-		mg.containerResolver = func(containerSources map[string][]container.SourceSpec, archName string) (map[string][]container.Spec, error) {
-			return container.NewBlockingResolver(archName).ResolveAll(containerSources)
-		}
+		mg.containerResolver = DefaultContainerResolver
 	}
 	if mg.commitResolver == nil {
 		mg.commitResolver = ostree.ResolveAll
@@ -147,11 +146,11 @@ func New(reporegistry *reporegistry.RepoRegistry, opts *Options) (*Generator, er
 		mg.flatpakResolver = flatpak.ResolveAll
 	}
 	if mg.cacheDir == "" {
-		xdgCacheHomeDir, err := xdgCacheHome()
+		cacheDir, err := DefaultCacheDir()
 		if err != nil {
 			return nil, err
 		}
-		mg.cacheDir = filepath.Join(xdgCacheHomeDir, defaultDepsolveCacheDir)
+		mg.cacheDir = cacheDir
 	}
 
 	return mg, nil
@@ -317,6 +316,16 @@ func writeRPMList(writer RPMListWriterFunc, unique map[string]rpmmd.Package) err
 	return writer("rpmlist.json", rpmListJSON)
 }
 
+// DefaultCacheDir returns the rpm metadata cache directory that is used
+// when Options.Cachedir is unset.
+func DefaultCacheDir() (string, error) {
+	xdgCacheHomeDir, err := xdgCacheHome()
+	if err != nil {
+		return "", err
+	}
+	return filepath.Join(xdgCacheHomeDir, defaultDepsolveCacheDir), nil
+}
+
 func xdgCacheHome() (string, error) {
 	xdgCacheHome := os.Getenv("XDG_CACHE_HOME")
 	if xdgCacheHome != "" {
@@ -351,6 +360,13 @@ func DefaultDepsolve(solver *depsolvednf.Solver, cacheDir string, depsolveWarnin
 	return solver.DepsolveAll(packageSets)
 }
 
+// DefaultContainerResolver provides a default implementation for
+// resolving containers. It will be used by default by manifestgen
+// (unless overriden)
+func DefaultContainerResolver(containerSources map[string][]container.SourceSpec, archName string) (map[string][]container.Spec, error) {
+	return container.NewBlockingResolver(archName).ResolveAll(containerSources)
+}
+
 type (
 	DepsolveFunc func(solver *depsolvednf.Solver, cacheDir string, depsolveWarningsOutput io.Writer, packageSets map[string][]rpmmd.PackageSet, d distro.Distro, arch string) (map[string]depsolvednf.DepsolveResult, error)
 
@@ -45,7 +45,7 @@ func RunOSBuild(pb ProgressBar, manifest []byte, exports []string, opts *OSBuild
 	// checked with them we can remove the runOSBuildNoProgress() and
 	// just run with the new runOSBuildWithProgress() helper.
 	switch pb.(type) {
-	case *terminalProgressBar, *debugProgressBar, *fileProgressBar:
+	case *terminalProgressBar, *debugProgressBar, *fileProgressBar, *jsonSeqProgressBar:
 		return runOSBuildWithProgress(pb, manifest, exports, opts)
 	default:
 		return runOSBuildNoProgress(pb, manifest, exports, opts)
@@ -146,7 +146,12 @@ func runOSBuildWithProgress(pb ProgressBar, manifest []byte, exports []string, o
 	cmd.Stderr = mw
 	cmd.ExtraFiles = []*os.File{wp}
 
-	osbuildStatus := osbuild.NewStatusScanner(rp)
+	var monitor io.Reader = rp
+	if jb, ok := pb.(*jsonSeqProgressBar); ok {
+		// forward the status stream unchanged as it is read
+		monitor = io.TeeReader(rp, jb.w)
+	}
+	osbuildStatus := osbuild.NewStatusScanner(monitor)
 	if err := cmd.Start(); err != nil {
 		return fmt.Errorf("error starting osbuild: %v", err)
 	}
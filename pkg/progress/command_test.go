@@ -115,6 +115,31 @@ osbuild-stage-message
 	assert.Equal(t, expectedOutput, buildLog.String())
 }
 
+func TestRunOSBuildJSONSeqForwardsStatus(t *testing.T) {
+	restore := progress.MockOsbuildCmd(makeFakeOsbuild(t, `
+echo osbuild-stdout-output
+>&3 printf '\036{"message": "osbuild-stage-message"}\n'
+>&3 printf '\036{"progress": {"name": "pipelines", "total": 4, "done": 1}}\n'
+`))
+	defer restore()
+
+	var fakeStdout, fakeStderr bytes.Buffer
+	restore = progress.MockOsStdout(&fakeStdout)
+	defer restore()
+	restore = progress.MockOsStderr(&fakeStderr)
+	defer restore()
+
+	pbar, err := progress.New("jsonseq", progress.ProgressConfig{})
+	assert.NoError(t, err)
+	err = progress.RunOSBuild(pbar, []byte(`{"fake":"manifest"}`), nil, nil)
+	assert.NoError(t, err)
+	// only the status stream is written to stdout
+	expectedOutput := "\x1e" + `{"message": "osbuild-stage-message"}` + "\n" +
+		"\x1e" + `{"progress": {"name": "pipelines", "total": 4, "done": 1}}` + "\n"
+	assert.Equal(t, expectedOutput, fakeStdout.String())
+	assert.Equal(t, "", fakeStderr.String())
+}
+
 func TestRunOSBuildWithBuildlogVerbose(t *testing.T) {
 	restore := progress.MockOsbuildCmd(makeFakeOsbuild(t, `
 echo osbuild-stdout-output
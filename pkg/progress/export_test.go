@@ -9,6 +9,7 @@ type (
 	DebugProgressBar    = debugProgressBar
 	VerboseProgressBar  = verboseProgressBar
 	FileProgressItem    = fileProgressItem
+	JSONSeqProgressBar  = jsonSeqProgressBar
 )
 
 var (
@@ -7,7 +7,9 @@ import (
 	"fmt"
 	"io"
 	"log"
+	"maps"
 	"os"
+	"slices"
 	"strings"
 	"sync"
 	"time"
@@ -54,6 +56,12 @@ type ProgressBar interface {
 	// (but if required it can be added, its a SMOP)
 	SetProgress(level int, msg string, done int, total int) error
 
+	// SetTargetProgress sets the progress of the given target,
+	// targets are operations that run concurrently (like uploads
+	// to multiple clouds) and are shown side by side instead of
+	// as nested sub-progress.
+	SetTargetProgress(target string, done int, total int) error
+
 	// The high-level message that is displayed in a spinner
 	// that contains the current top level step, for bib this
 	// is really just "Manifest generation step" and
@@ -111,6 +119,8 @@ func New(typ string, config ProgressConfig) (ProgressBar, error) {
 		return NewDebugProgressBar()
 	case "file":
 		return NewFileProgressBar(&config)
+	case "jsonseq":
+		return NewJSONSeqProgressBar()
 	default:
 		return nil, fmt.Errorf("unknown progress type: %q", typ)
 	}
@@ -125,6 +135,7 @@ type terminalProgressBar struct {
 	spinnerPb   *pb.ProgressBar
 	msgPb       *pb.ProgressBar
 	subLevelPbs []*pb.ProgressBar
+	targetPbs   map[string]*pb.ProgressBar
 
 	shutdownCh chan bool
 
@@ -158,30 +169,75 @@ func (b *terminalProgressBar) SetProgress(subLevel int, msg string, done int, to
 	// i.e. adding 0 and then 2 will fail
 	switch {
 	case subLevel == len(b.subLevelPbs):
-		apb := pb.New(0)
-		progressBarTmpl := `[{{ counters . }}] {{ string . "prefix" }} {{ bar .}} {{ percent . }}`
-		if b.speed {
-			progressBarTmpl += ` {{ speed . }}`
-		}
-		apb.SetTemplateString(progressBarTmpl)
-		if err := apb.Err(); err != nil {
-			return fmt.Errorf("error setting the progressbarTemplat: %w", err)
-		}
-		// workaround bug when running tests in tmt
-		if apb.Width() == 0 {
-			// this is pb.defaultBarWidth
-			apb.SetWidth(100)
+		apb, err := b.newLevelPb()
+		if err != nil {
+			return err
 		}
 		b.subLevelPbs = append(b.subLevelPbs, apb)
 	case subLevel > len(b.subLevelPbs):
 		return fmt.Errorf("sublevel added out of order, have %v sublevels but want level %v", len(b.subLevelPbs), subLevel)
 	}
-	apb := b.subLevelPbs[subLevel]
+	b.updateLevelPb(b.subLevelPbs[subLevel], msg, done, total)
+	return nil
+}
+
+func (b *terminalProgressBar) SetTargetProgress(target string, done int, total int) error {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	apb, ok := b.targetPbs[target]
+	if !ok {
+		var err error
+		apb, err = b.newLevelPb()
+		if err != nil {
+			return err
+		}
+		if b.targetPbs == nil {
+			b.targetPbs = make(map[string]*pb.ProgressBar)
+		}
+		b.targetPbs[target] = apb
+	}
+	b.updateLevelPb(apb, target, done, total)
+	return nil
+}
+
+func (b *terminalProgressBar) newLevelPb() (*pb.ProgressBar, error) {
+	apb := pb.New(0)
+	progressBarTmpl := `[{{ counters . }}] {{ string . "prefix" }} {{ bar .}} {{ percent . }}`
+	if b.speed {
+		progressBarTmpl += ` {{ speed . }}`
+	}
+	apb.SetTemplateString(progressBarTmpl)
+	if err := apb.Err(); err != nil {
+		return nil, fmt.Errorf("error setting the progressbarTemplat: %w", err)
+	}
+	// workaround bug when running tests in tmt
+	if apb.Width() == 0 {
+		// this is pb.defaultBarWidth
+		apb.SetWidth(100)
+	}
+	return apb, nil
+}
+
+func (b *terminalProgressBar) updateLevelPb(apb *pb.ProgressBar, msg string, done int, total int) {
 	apb.SetTotal(int64(total) + 1)
 	apb.SetCurrent(int64(done) + 1)
 	apb.Set("prefix", msg)
 	apb.Set(pb.Bytes, b.bytes)
-	return nil
+}
+
+// levelPbs returns the progress bars of the sublevels followed by
+// the progress bars of the targets sorted by their name
+func (b *terminalProgressBar) levelPbs() []*pb.ProgressBar {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	pbs := make([]*pb.ProgressBar, 0, len(b.subLevelPbs)+len(b.targetPbs))
+	pbs = append(pbs, b.subLevelPbs...)
+	for _, target := range slices.Sorted(maps.Keys(b.targetPbs)) {
+		pbs = append(pbs, b.targetPbs[target])
+	}
+	return pbs
 }
 
 func (b *terminalProgressBar) SetPulseMsgf(msg string, args ...any) {
@@ -201,10 +257,7 @@ func shortenString(msg string) string {
 }
 
 func (b *terminalProgressBar) render() {
-	b.mu.Lock()
-	subPbs := make([]*pb.ProgressBar, len(b.subLevelPbs))
-	copy(subPbs, b.subLevelPbs)
-	b.mu.Unlock()
+	subPbs := b.levelPbs()
 
 	var renderedLines int
 	fmt.Fprintf(b.out, "%s%s\n", ERASE_LINE, shortenString(b.spinnerPb.String()))
@@ -229,9 +282,7 @@ func (b *terminalProgressBar) renderLoop() {
 			b.render()
 			// finally move cursor down again
 			fmt.Fprint(b.out, CURSOR_SHOW)
-			b.mu.Lock()
-			n := len(b.subLevelPbs)
-			b.mu.Unlock()
+			n := len(b.levelPbs())
 			fmt.Fprint(b.out, strings.Repeat("\n", 2+n))
 			// close last to avoid race with b.out
 			close(b.shutdownCh)
@@ -268,6 +319,11 @@ func (b *terminalProgressBar) Err() error {
 			errs = append(errs, fmt.Errorf("error on spinner progressbar: %w", err))
 		}
 	}
+	for _, pb := range b.targetPbs {
+		if err := pb.Err(); err != nil {
+			errs = append(errs, fmt.Errorf("error on spinner progressbar: %w", err))
+		}
+	}
 	return errors.Join(errs...)
 }
 
@@ -348,6 +404,10 @@ func (b *verboseProgressBar) SetProgress(subLevel int, msg string, done int, tot
 	return nil
 }
 
+func (b *verboseProgressBar) SetTargetProgress(target string, done int, total int) error {
+	return nil
+}
+
 type debugProgressBar struct {
 	w io.Writer
 }
@@ -394,18 +454,31 @@ func (b *debugProgressBar) SetProgress(subLevel int, msg string, done int, total
 	return nil
 }
 
+func (b *debugProgressBar) SetTargetProgress(target string, done int, total int) error {
+	fmt.Fprintf(b.w, "target: [%v / %v] %s", done, total, target)
+	fmt.Fprintf(b.w, "\n")
+	return nil
+}
+
 type fileProgressBar struct {
 	path string
 
+	// progress may be set from concurrent uploads
+	mu sync.Mutex
+
 	// Keeps track of different levels of (sub)progress.
 	levels []*fileProgressItem
+
+	// Keeps track of the progress of concurrent targets by name.
+	targets map[string]*fileProgressItem
 }
 
 type fileProgressItem struct {
-	Msg         string            `json:"message"`
-	SubProgress *fileProgressItem `json:"subprogress,omitempty"`
-	Done        int               `json:"done"`
-	Total       int               `json:"total"`
+	Msg         string              `json:"message"`
+	SubProgress *fileProgressItem   `json:"subprogress,omitempty"`
+	Done        int                 `json:"done"`
+	Total       int                 `json:"total"`
+	Targets     []*fileProgressItem `json:"targets,omitempty"`
 }
 
 // NewFileProgressBar starts a new "file" progressbar that will write any progress to a file.
@@ -442,6 +515,9 @@ func (b *fileProgressBar) Stop() {
 }
 
 func (b *fileProgressBar) SetProgress(level int, msg string, done int, total int) error {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
 	item := fileProgressItem{
 		Msg:   msg,
 		Done:  done,
@@ -462,7 +538,36 @@ func (b *fileProgressBar) SetProgress(level int, msg string, done int, total int
 		b.levels[i].SubProgress = b.levels[i+1]
 	}
 
-	data, err := json.Marshal(b.levels[0])
+	return b.writeProgress()
+}
+
+func (b *fileProgressBar) SetTargetProgress(target string, done int, total int) error {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	if b.targets == nil {
+		b.targets = make(map[string]*fileProgressItem)
+	}
+	b.targets[target] = &fileProgressItem{
+		Msg:   target,
+		Done:  done,
+		Total: total,
+	}
+	return b.writeProgress()
+}
+
+// writeProgress writes the top level progress with the targets
+// sorted by their name, must be called with b.mu held
+func (b *fileProgressBar) writeProgress() error {
+	var top fileProgressItem
+	if len(b.levels) > 0 {
+		top = *b.levels[0]
+	}
+	for _, target := range slices.Sorted(maps.Keys(b.targets)) {
+		top.Targets = append(top.Targets, b.targets[target])
+	}
+
+	data, err := json.Marshal(&top)
 	if err != nil {
 		return err
 	}
@@ -476,3 +581,43 @@ func (b *fileProgressBar) SetProgress(level int, msg string, done int, total int
 	}
 	return os.Rename(tmpPath, b.path)
 }
+
+type jsonSeqProgressBar struct {
+	w io.Writer
+}
+
+// NewJSONSeqProgressBar starts a new "jsonseq" progressbar that copies
+// the osbuild monitor status stream (RFC 7464 JSON text sequences)
+// unchanged to stdout so that a calling program can follow the build
+// with an osbuild.StatusScanner. All other messages are ignored.
+func NewJSONSeqProgressBar() (ProgressBar, error) {
+	b := &jsonSeqProgressBar{w: osStdout()}
+	return b, nil
+}
+
+func (b *jsonSeqProgressBar) SetPulseMsgf(msg string, args ...any) {
+	// nop: only the osbuild status stream is written
+}
+
+func (b *jsonSeqProgressBar) SetMessagef(msg string, args ...any) {
+	// nop: only the osbuild status stream is written
+}
+
+func (b *jsonSeqProgressBar) Start() {
+}
+
+func (b *jsonSeqProgressBar) Write(p []byte) (n int, err error) {
+	// keep stdout for the status stream
+	return osStderr().Write(p)
+}
+
+func (b *jsonSeqProgressBar) Stop() {
+}
+
+func (b *jsonSeqProgressBar) SetProgress(subLevel int, msg string, done int, total int) error {
+	return nil
+}
+
+func (b *jsonSeqProgressBar) SetTargetProgress(target string, done int, total int) error {
+	return nil
+}
@@ -7,6 +7,7 @@ import (
 	"os"
 	"path/filepath"
 	"reflect"
+	"strings"
 	"testing"
 
 	"github.com/stretchr/testify/assert"
@@ -23,6 +24,7 @@ func TestProgressNew(t *testing.T) {
 		{"term", &progress.TerminalProgressBar{}, ""},
 		{"debug", &progress.DebugProgressBar{}, ""},
 		{"verbose", &progress.VerboseProgressBar{}, ""},
+		{"jsonseq", &progress.JSONSeqProgressBar{}, ""},
 		// unknown progress type
 		{"bad", nil, `unknown progress type: "bad"`},
 	} {
@@ -261,3 +263,65 @@ func TestFileProgress(t *testing.T) {
 		Total: 5,
 	}, item)
 }
+
+func TestFileProgressTargets(t *testing.T) {
+	progressFile := filepath.Join(t.TempDir(), "progress")
+
+	pbar, err := progress.NewFileProgressBar(&progress.ProgressConfig{
+		FilePath: progressFile,
+	})
+	assert.NoError(t, err)
+
+	readItem := func() progress.FileProgressItem {
+		data, err := os.ReadFile(progressFile)
+		assert.NoError(t, err)
+		var item progress.FileProgressItem
+		assert.NoError(t, json.Unmarshal(data, &item))
+		return item
+	}
+
+	assert.NoError(t, pbar.SetTargetProgress("azure", 0, 4))
+	assert.NoError(t, pbar.SetTargetProgress("aws", 0, 4))
+	// advancing one target keeps the progress of the other targets
+	assert.NoError(t, pbar.SetTargetProgress("aws", 2, 4))
+	assert.Equal(t, progress.FileProgressItem{
+		Targets: []*progress.FileProgressItem{
+			{Msg: "aws", Done: 2, Total: 4},
+			{Msg: "azure", Done: 0, Total: 4},
+		},
+	}, readItem())
+
+	// targets are kept next to the (sub)progress levels
+	assert.NoError(t, pbar.SetProgress(0, "set-progress-msg", 1, 5))
+	assert.NoError(t, pbar.SetTargetProgress("azure", 4, 4))
+	assert.Equal(t, progress.FileProgressItem{
+		Msg:   "set-progress-msg",
+		Done:  1,
+		Total: 5,
+		Targets: []*progress.FileProgressItem{
+			{Msg: "aws", Done: 2, Total: 4},
+			{Msg: "azure", Done: 4, Total: 4},
+		},
+	}, readItem())
+}
+
+func TestTermProgressTargets(t *testing.T) {
+	var buf bytes.Buffer
+	restore := progress.MockOsStderr(&buf)
+	defer restore()
+
+	pbar, err := progress.NewTerminalProgressBar(&progress.ProgressConfig{})
+	assert.NoError(t, err)
+
+	pbar.Start()
+	assert.NoError(t, pbar.SetTargetProgress("azure", 1, 5))
+	assert.NoError(t, pbar.SetTargetProgress("aws", 0, 5))
+	assert.NoError(t, pbar.SetTargetProgress("aws", 3, 5))
+	pbar.Stop()
+	assert.NoError(t, pbar.(*progress.TerminalProgressBar).Err())
+	out := buf.String()
+	assert.Contains(t, out, "[4 / 6] aws")
+	assert.Contains(t, out, "[2 / 6] azure")
+	// targets are sorted by name
+	assert.Less(t, strings.LastIndex(out, "[4 / 6] aws"), strings.LastIndex(out, "[2 / 6] azure"))
+}
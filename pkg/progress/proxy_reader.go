@@ -6,9 +6,10 @@ import (
 
 type Reader struct {
 	io.Reader
-	pb    ProgressBar
-	done  int
-	total int
+	pb     ProgressBar
+	target string
+	done   int
+	total  int
 }
 
 func NewProxyReader(r io.Reader, total int, pb ProgressBar) (*Reader, error) {
@@ -24,13 +25,34 @@ func NewProxyReader(r io.Reader, total int, pb ProgressBar) (*Reader, error) {
 	}, nil
 }
 
+// NewTargetProxyReader is like NewProxyReader but reports the
+// progress as the progress of the given target so that multiple
+// concurrent readers can be told apart.
+func NewTargetProxyReader(r io.Reader, target string, total int, pb ProgressBar) (*Reader, error) {
+	err := pb.SetTargetProgress(target, 0, total)
+	if err != nil {
+		return nil, err
+	}
+	return &Reader{
+		Reader: r,
+		pb:     pb,
+		target: target,
+		done:   0,
+		total:  total,
+	}, nil
+}
+
 func (r *Reader) Read(p []byte) (int, error) {
 	n, err := r.Reader.Read(p)
 	r.done += n
 	if err != nil {
 		return n, err
 	}
-	err = r.pb.SetProgress(0, "", r.done, r.total)
+	if r.target != "" {
+		err = r.pb.SetTargetProgress(r.target, r.done, r.total)
+	} else {
+		err = r.pb.SetProgress(0, "", r.done, r.total)
+	}
 	return n, err
 }
 
@@ -35,3 +35,27 @@ func TestProxyReader(t *testing.T) {
 	assert.Equal(t, "[0 / 4] Uploading", lines[0])
 	assert.Equal(t, "[4 / 4] ", lines[1])
 }
+
+func TestTargetProxyReader(t *testing.T) {
+	var progressBuf bytes.Buffer
+	restore := progress.MockOsStderr(&progressBuf)
+	defer restore()
+
+	pbar, err := progress.NewDebugProgressBar()
+	assert.NoError(t, err)
+
+	_, err = progress.NewTargetProxyReader(strings.NewReader("cat"), "aws", 3, pbar)
+	assert.NoError(t, err)
+	proxyReader, err := progress.NewTargetProxyReader(strings.NewReader("duck"), "azure", 4, pbar)
+	assert.NoError(t, err)
+
+	out := make([]byte, 256)
+	nRead, err := proxyReader.Read(out)
+	assert.NoError(t, err)
+	assert.Equal(t, 4, nRead)
+
+	lines := strings.Split(progressBuf.String(), "\n")
+	assert.Equal(t, "target: [0 / 3] aws", lines[0])
+	assert.Equal(t, "target: [0 / 4] azure", lines[1])
+	assert.Equal(t, "target: [4 / 4] azure", lines[2])
+}
@@ -135,3 +135,13 @@ func (r *RepoRegistry) ListDistros() []string {
 	}
 	return distros
 }
+
+// ListArches returns a list of all architectures of the given distro which
+// have a repository defined in the registry.
+func (r *RepoRegistry) ListArches(distro string) []string {
+	arches := make([]string, 0, len(r.repos[distro]))
+	for name := range r.repos[distro] {
+		arches = append(arches, name)
+	}
+	return arches
+}
@@ -2,6 +2,7 @@ package reporegistry
 
 import (
 	"reflect"
+	"sort"
 	"strings"
 	"testing"
 
@@ -353,3 +354,13 @@ func TestInvalidReposByArchName(t *testing.T) {
 		})
 	}
 }
+
+func TestListArches(t *testing.T) {
+	rr := getTestingRepoRegistry()
+	td := test_distro.DistroFactory(test_distro.TestDistro1Name)
+
+	arches := rr.ListArches(td.Name())
+	sort.Strings(arches)
+	assert.Equal(t, []string{test_distro.TestArchName, test_distro.TestArch2Name}, arches)
+	assert.Empty(t, rr.ListArches(td.Name()+"-invalid"))
+}
@@ -0,0 +1,123 @@
+package rpmmd
+
+import (
+	"cmp"
+	"strings"
+)
+
+func isAlnum(c byte) bool {
+	return isDigit(c) || isAlpha(c)
+}
+
+func isDigit(c byte) bool {
+	return c >= '0' && c <= '9'
+}
+
+func isAlpha(c byte) bool {
+	return (c >= 'a' && c <= 'z') || (c >= 'A' && c <= 'Z')
+}
+
+// VersionCompare compares two version (or release) strings with the
+// same algorithm as rpm (rpmvercmp). It returns -1 if a is older than
+// b, 0 if they are equal and 1 if a is newer than b.
+func VersionCompare(a, b string) int {
+	if a == b {
+		return 0
+	}
+
+	for len(a) > 0 || len(b) > 0 {
+		a = strings.TrimLeftFunc(a, func(r rune) bool { return r > 127 || (!isAlnum(byte(r)) && r != '~' && r != '^') })
+		b = strings.TrimLeftFunc(b, func(r rune) bool { return r > 127 || (!isAlnum(byte(r)) && r != '~' && r != '^') })
+
+		// tilde sorts before everything else, even the end
+		// of the string
+		if strings.HasPrefix(a, "~") || strings.HasPrefix(b, "~") {
+			if !strings.HasPrefix(a, "~") {
+				return 1
+			}
+			if !strings.HasPrefix(b, "~") {
+				return -1
+			}
+			a, b = a[1:], b[1:]
+			continue
+		}
+		// caret sorts after the end of the string but before
+		// everything else
+		if strings.HasPrefix(a, "^") || strings.HasPrefix(b, "^") {
+			if a == "" {
+				return -1
+			}
+			if b == "" {
+				return 1
+			}
+			if !strings.HasPrefix(a, "^") {
+				return 1
+			}
+			if !strings.HasPrefix(b, "^") {
+				return -1
+			}
+			a, b = a[1:], b[1:]
+			continue
+		}
+		if a == "" || b == "" {
+			break
+		}
+
+		// compare the next segment, segments are either all
+		// digits or all letters
+		isNum := isDigit(a[0])
+		inSegment := isAlpha
+		if isNum {
+			inSegment = isDigit
+		}
+		segEnd := func(s string) int {
+			i := 0
+			for i < len(s) && inSegment(s[i]) {
+				i++
+			}
+			return i
+		}
+		i, j := segEnd(a), segEnd(b)
+		segA, segB := a[:i], b[:j]
+		a, b = a[i:], b[j:]
+
+		// numeric segments are always newer than alpha segments
+		if segB == "" {
+			if isNum {
+				return 1
+			}
+			return -1
+		}
+		if isNum {
+			segA = strings.TrimLeft(segA, "0")
+			segB = strings.TrimLeft(segB, "0")
+			if c := cmp.Compare(len(segA), len(segB)); c != 0 {
+				return c
+			}
+		}
+		if c := strings.Compare(segA, segB); c != 0 {
+			return c
+		}
+	}
+
+	if a == "" && b == "" {
+		return 0
+	}
+	if a == "" {
+		return -1
+	}
+	return 1
+}
+
+// CompareEVR compares the epoch, version and release of the package
+// with the other package. It returns -1 if the package is older than
+// the other package, 0 if they are equal and 1 if it is newer.
+func (p Package) CompareEVR(other Package) int {
+	if c := cmp.Compare(p.Epoch, other.Epoch); c != 0 {
+		return c
+	}
+	if c := VersionCompare(p.Version, other.Version); c != 0 {
+		return c
+	}
+	return VersionCompare(p.Release, other.Release)
+}
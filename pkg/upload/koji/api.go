@@ -0,0 +1,17 @@
+package koji
+
+// KOJI API STRUCTURES
+
+type CGInitBuildResult struct {
+	BuildID int    `xmlrpc:"build_id"`
+	Token   string `xmlrpc:"token"`
+}
+
+type CGImportResult struct {
+	BuildID int `xmlrpc:"build_id"`
+}
+
+type GSSAPICredentials struct {
+	Principal string
+	KeyTab    string
+}
@@ -0,0 +1,11 @@
+package koji
+
+type KojiClient = kojiClient
+
+func MockNewKojiClient(f func(string, *GSSAPICredentials) (kojiClient, error)) (restore func()) {
+	saved := newKojiClient
+	newKojiClient = f
+	return func() {
+		newKojiClient = saved
+	}
+}
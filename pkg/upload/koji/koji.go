@@ -36,22 +36,6 @@ type Koji struct {
 	logger    rh.LeveledLogger
 }
 
-// KOJI API STRUCTURES
-
-type CGInitBuildResult struct {
-	BuildID int    `xmlrpc:"build_id"`
-	Token   string `xmlrpc:"token"`
-}
-
-type CGImportResult struct {
-	BuildID int `xmlrpc:"build_id"`
-}
-
-type GSSAPICredentials struct {
-	Principal string
-	KeyTab    string
-}
-
 type loginReply struct {
 	SessionID  int64  `xmlrpc:"session-id"`
 	SessionKey string `xmlrpc:"session-key"`
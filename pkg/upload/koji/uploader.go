@@ -0,0 +1,299 @@
+package koji
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path"
+	"path/filepath"
+	"strconv"
+	"time"
+
+	"github.com/google/uuid"
+
+	"github.com/osbuild/image-builder/pkg/arch"
+	"github.com/osbuild/image-builder/pkg/cloud"
+)
+
+// the content generator metadata contains a single buildroot
+const buildRootID = 1
+
+type kojiUploader struct {
+	server      string
+	credentials *GSSAPICredentials
+
+	name     string
+	version  string
+	release  string
+	filename string
+	arch     string
+	bootMode string
+
+	manifest string
+	buildLog string
+	sboms    []string
+
+	hostOS      string
+	toolVersion string
+}
+
+type UploaderOptions struct {
+	// Credentials used for the GSSAPI login, if nil the default
+	// credentials cache is used (e.g. after "kinit")
+	Credentials *GSSAPICredentials
+	// Filename of the image in the koji build, defaults to
+	// "<name>-<version>-<release>.<arch>"
+	Filename string
+	// Arch of the image, defaults to the host architecture
+	Arch string
+	// BootMode of the image, recorded in the image metadata
+	BootMode string
+
+	// Manifest, BuildLog and SBOMs are paths to files that are
+	// imported together with the image. They are only read at
+	// upload time so they can be written after the uploader was
+	// created.
+	Manifest string
+	BuildLog string
+	SBOMs    []string
+
+	// HostOS is the operating system of the build host, e.g. "fedora-42"
+	HostOS string
+	// ToolVersion is the image-builder version that built the image
+	ToolVersion string
+}
+
+// testing support
+type kojiClient interface {
+	GetAPIVersion() (int, error)
+	CGInitBuild(name, version, release string) (*CGInitBuildResult, error)
+	Upload(file io.Reader, filepath, filename string) (string, uint64, error)
+	CGImport(build Build, buildRoots []BuildRoot, outputs []BuildOutput, directory, token string) (*CGImportResult, error)
+	CGFailBuild(buildID int, token string) error
+	Logout() error
+}
+
+// NewUploader returns a cloud.Uploader that imports the image as a
+// content generator build with the given name, version and release
+// into the koji instance at the given server URL.
+func NewUploader(server, name, version, release string, opts *UploaderOptions) (cloud.Uploader, error) {
+	if opts == nil {
+		opts = &UploaderOptions{}
+	}
+	if name == "" || version == "" || release == "" {
+		return nil, fmt.Errorf("koji build needs a name, version and release, got %q, %q, %q", name, version, release)
+	}
+
+	imgArch := opts.Arch
+	if imgArch == "" {
+		imgArch = arch.Current().String()
+	}
+	filename := opts.Filename
+	if filename == "" {
+		filename = fmt.Sprintf("%s-%s-%s.%s", name, version, release, imgArch)
+	}
+
+	return &kojiUploader{
+		server:      server,
+		credentials: opts.Credentials,
+		name:        name,
+		version:     version,
+		release:     release,
+		filename:    filename,
+		arch:        imgArch,
+		bootMode:    opts.BootMode,
+		manifest:    opts.Manifest,
+		buildLog:    opts.BuildLog,
+		sboms:       opts.SBOMs,
+		hostOS:      opts.HostOS,
+		toolVersion: opts.ToolVersion,
+	}, nil
+}
+
+var _ cloud.Uploader = &kojiUploader{}
+
+func (ku *kojiUploader) nvr() string {
+	return fmt.Sprintf("%s-%s-%s", ku.name, ku.version, ku.release)
+}
+
+func (ku *kojiUploader) Check(status io.Writer) error {
+	fmt.Fprintf(status, "Checking koji login...\n")
+	client, err := newKojiClient(ku.server, ku.credentials)
+	if err != nil {
+		return fmt.Errorf("cannot login to koji: %w", err)
+	}
+	defer func() {
+		_ = client.Logout()
+	}()
+
+	if _, err := client.GetAPIVersion(); err != nil {
+		return fmt.Errorf("cannot get koji API version: %w", err)
+	}
+	fmt.Fprintf(status, "Upload conditions met.\n")
+	return nil
+}
+
+// uploadOutput uploads the content of r and returns the
+// corresponding output metadata
+func uploadOutput(client kojiClient, r io.Reader, directory, filename, outputArch string, outputType BuildOutputType, extra *BuildOutputExtra) (*BuildOutput, error) {
+	checksum, size, err := client.Upload(r, directory, filename)
+	if err != nil {
+		return nil, fmt.Errorf("cannot upload %s: %w", filename, err)
+	}
+	return &BuildOutput{
+		BuildRootID:  buildRootID,
+		Filename:     filename,
+		FileSize:     size,
+		Arch:         outputArch,
+		ChecksumType: ChecksumTypeMD5,
+		Checksum:     checksum,
+		Type:         outputType,
+		Extra:        extra,
+	}, nil
+}
+
+func uploadFileOutput(client kojiClient, p, directory, outputArch string, outputType BuildOutputType, extra *BuildOutputExtra) (*BuildOutput, error) {
+	f, err := os.Open(p)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	return uploadOutput(client, f, directory, filepath.Base(p), outputArch, outputType, extra)
+}
+
+func (ku *kojiUploader) UploadAndRegister(r io.Reader, _ uint64, status io.Writer) (result *cloud.UploadResult, err error) {
+	startTime := time.Now()
+
+	client, err := newKojiClient(ku.server, ku.credentials)
+	if err != nil {
+		return nil, fmt.Errorf("cannot login to koji: %w", err)
+	}
+	defer func() {
+		_ = client.Logout()
+	}()
+
+	fmt.Fprintf(status, "Initializing koji build %s\n", ku.nvr())
+	initResult, err := client.CGInitBuild(ku.name, ku.version, ku.release)
+	if err != nil {
+		return nil, fmt.Errorf("cannot initialize koji build %s: %w", ku.nvr(), err)
+	}
+	defer func() {
+		if err != nil {
+			fmt.Fprintf(status, "Marking koji build %s as failed\n", ku.nvr())
+			err = errors.Join(err, client.CGFailBuild(initResult.BuildID, initResult.Token))
+		}
+	}()
+
+	directory := path.Join("osbuild-cg", fmt.Sprintf("osbuild-image-builder-%s", uuid.New().String()))
+	imageExtra := ImageExtraInfo{
+		Arch:     ku.arch,
+		BootMode: ku.bootMode,
+	}
+
+	fmt.Fprintf(status, "Uploading %s\n", ku.filename)
+	var outputs []BuildOutput
+	output, err := uploadOutput(client, r, directory, ku.filename, ku.arch, BuildOutputTypeImage, &BuildOutputExtra{ImageOutput: imageExtra})
+	if err != nil {
+		return nil, err
+	}
+	outputs = append(outputs, *output)
+
+	buildExtra := BuildExtra{
+		TypeInfo: TypeInfoBuild{
+			Image: map[string]ImageExtraInfo{
+				ku.filename: imageExtra,
+			},
+		},
+	}
+	if ku.manifest != "" {
+		fmt.Fprintf(status, "Uploading %s\n", filepath.Base(ku.manifest))
+		manifestExtra := &ManifestExtraInfo{Arch: ku.arch}
+		output, err := uploadFileOutput(client, ku.manifest, directory, ku.arch, BuildOutputTypeManifest, &BuildOutputExtra{ImageOutput: manifestExtra})
+		if err != nil {
+			return nil, err
+		}
+		outputs = append(outputs, *output)
+		buildExtra.Manifest = map[string]*ManifestExtraInfo{
+			output.Filename: manifestExtra,
+		}
+	}
+	for _, sbom := range ku.sboms {
+		fmt.Fprintf(status, "Uploading %s\n", filepath.Base(sbom))
+		output, err := uploadFileOutput(client, sbom, directory, ku.arch, BuildOutputTypeSbomDoc, &BuildOutputExtra{ImageOutput: SbomDocExtraInfo{Arch: ku.arch}})
+		if err != nil {
+			return nil, err
+		}
+		outputs = append(outputs, *output)
+	}
+	if ku.buildLog != "" {
+		fmt.Fprintf(status, "Uploading %s\n", filepath.Base(ku.buildLog))
+		// logs do not need any extra metadata
+		output, err := uploadFileOutput(client, ku.buildLog, directory, "noarch", BuildOutputTypeLog, nil)
+		if err != nil {
+			return nil, err
+		}
+		outputs = append(outputs, *output)
+	}
+
+	// #nosec G115
+	build := Build{
+		BuildID:   uint64(initResult.BuildID),
+		Name:      ku.name,
+		Version:   ku.version,
+		Release:   ku.release,
+		Source:    "image-builder",
+		StartTime: startTime.Unix(),
+		EndTime:   time.Now().Unix(),
+		Extra:     buildExtra,
+	}
+	hostArch := arch.Current().String()
+	buildRoots := []BuildRoot{
+		{
+			ID: buildRootID,
+			Host: Host{
+				Os:   ku.hostOS,
+				Arch: hostArch,
+			},
+			ContentGenerator: ContentGenerator{
+				Name:    "osbuild",
+				Version: ku.toolVersion,
+			},
+			Container: Container{
+				Type: "none",
+				Arch: hostArch,
+			},
+			Tools: []Tool{
+				{Name: "image-builder", Version: ku.toolVersion},
+			},
+		},
+	}
+
+	fmt.Fprintf(status, "Importing koji build %s\n", ku.nvr())
+	importResult, err := client.CGImport(build, buildRoots, outputs, directory, initResult.Token)
+	if err != nil {
+		return nil, fmt.Errorf("cannot import koji build %s: %w", ku.nvr(), err)
+	}
+	fmt.Fprintf(status, "Koji build imported: %d (%s)\n", importResult.BuildID, ku.nvr())
+
+	kojiOutputs := make([]cloud.KojiUploadOutput, 0, len(outputs))
+	for _, output := range outputs {
+		kojiOutputs = append(kojiOutputs, cloud.KojiUploadOutput{
+			Filename:     output.Filename,
+			ChecksumType: string(output.ChecksumType),
+			Checksum:     output.Checksum,
+		})
+	}
+
+	return &cloud.UploadResult{
+		Provider: "koji",
+		ImageID:  strconv.Itoa(importResult.BuildID),
+		Koji: &cloud.KojiUploadResult{
+			Server:  ku.server,
+			BuildID: importResult.BuildID,
+			NVR:     ku.nvr(),
+			Outputs: kojiOutputs,
+		},
+	}, nil
+}
@@ -0,0 +1,11 @@
+//go:build cgo
+
+package koji
+
+var newKojiClient = func(server string, credentials *GSSAPICredentials) (kojiClient, error) {
+	if credentials == nil {
+		credentials = &GSSAPICredentials{}
+	}
+	transport := CreateKojiTransport(0, nil)
+	return NewFromGSSAPI(server, credentials, transport, nil)
+}
@@ -0,0 +1,11 @@
+//go:build !cgo
+
+package koji
+
+import (
+	"fmt"
+)
+
+var newKojiClient = func(server string, credentials *GSSAPICredentials) (kojiClient, error) {
+	return nil, fmt.Errorf("cannot use koji: build without cgo")
+}
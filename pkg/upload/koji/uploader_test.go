@@ -0,0 +1,250 @@
+package koji_test
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/google/uuid"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/osbuild/image-builder/pkg/cloud"
+	"github.com/osbuild/image-builder/pkg/upload/koji"
+)
+
+type fakeKojiClient struct {
+	apiVersionErr error
+
+	initBuildErr error
+
+	uploaded  map[string]string
+	uploadDir string
+
+	importErr   error
+	importBuild koji.Build
+	importRoots []koji.BuildRoot
+	importOuts  []koji.BuildOutput
+	importDir   string
+	importToken string
+
+	failBuildCalls int
+	logoutCalls    int
+}
+
+func (fk *fakeKojiClient) GetAPIVersion() (int, error) {
+	return 1, fk.apiVersionErr
+}
+
+func (fk *fakeKojiClient) CGInitBuild(name, version, release string) (*koji.CGInitBuildResult, error) {
+	if fk.initBuildErr != nil {
+		return nil, fk.initBuildErr
+	}
+	return &koji.CGInitBuildResult{BuildID: 42, Token: "token"}, nil
+}
+
+func (fk *fakeKojiClient) Upload(file io.Reader, filepath, filename string) (string, uint64, error) {
+	data, err := io.ReadAll(file)
+	if err != nil {
+		return "", 0, err
+	}
+	if fk.uploaded == nil {
+		fk.uploaded = make(map[string]string)
+	}
+	fk.uploaded[filename] = string(data)
+	fk.uploadDir = filepath
+	return fmt.Sprintf("md5-%s", filename), uint64(len(data)), nil
+}
+
+func (fk *fakeKojiClient) CGImport(build koji.Build, buildRoots []koji.BuildRoot, outputs []koji.BuildOutput, directory, token string) (*koji.CGImportResult, error) {
+	fk.importBuild = build
+	fk.importRoots = buildRoots
+	fk.importOuts = outputs
+	fk.importDir = directory
+	fk.importToken = token
+	if fk.importErr != nil {
+		return nil, fk.importErr
+	}
+	return &koji.CGImportResult{BuildID: 42}, nil
+}
+
+func (fk *fakeKojiClient) CGFailBuild(buildID int, token string) error {
+	fk.failBuildCalls++
+	return nil
+}
+
+func (fk *fakeKojiClient) Logout() error {
+	fk.logoutCalls++
+	return nil
+}
+
+type repeatReader struct{}
+
+func (r *repeatReader) Read(p []byte) (int, error) {
+	for i := range p {
+		p[i] = 0x1
+	}
+	return len(p), nil
+}
+
+func mockKojiClient(t *testing.T, fk *fakeKojiClient) *koji.GSSAPICredentials {
+	var gotCreds koji.GSSAPICredentials
+	restore := koji.MockNewKojiClient(func(server string, creds *koji.GSSAPICredentials) (koji.KojiClient, error) {
+		if server != "https://koji.example.com/kojihub" {
+			return nil, fmt.Errorf("unexpected server %q", server)
+		}
+		if creds != nil {
+			gotCreds = *creds
+		}
+		return fk, nil
+	})
+	t.Cleanup(restore)
+	return &gotCreds
+}
+
+func TestKojiUploaderNeedsNVR(t *testing.T) {
+	_, err := koji.NewUploader("https://koji.example.com/kojihub", "name", "", "1", nil)
+	assert.EqualError(t, err, `koji build needs a name, version and release, got "name", "", "1"`)
+}
+
+func TestKojiUploaderCheck(t *testing.T) {
+	fk := &fakeKojiClient{}
+	gotCreds := mockKojiClient(t, fk)
+
+	uploader, err := koji.NewUploader("https://koji.example.com/kojihub", "name", "1.0", "1", &koji.UploaderOptions{
+		Credentials: &koji.GSSAPICredentials{Principal: "user@EXAMPLE.COM", KeyTab: "/etc/user.keytab"},
+	})
+	require.NoError(t, err)
+	var statusLog bytes.Buffer
+	require.NoError(t, uploader.Check(&statusLog))
+	assert.Equal(t, "Checking koji login...\nUpload conditions met.\n", statusLog.String())
+	assert.Equal(t, koji.GSSAPICredentials{Principal: "user@EXAMPLE.COM", KeyTab: "/etc/user.keytab"}, *gotCreds)
+	assert.Equal(t, 1, fk.logoutCalls)
+
+	fk.apiVersionErr = fmt.Errorf("fake-err")
+	err = uploader.Check(&statusLog)
+	assert.EqualError(t, err, "cannot get koji API version: fake-err")
+}
+
+func TestKojiUploaderUploadHappy(t *testing.T) {
+	uuid.SetRand(&repeatReader{})
+	fk := &fakeKojiClient{}
+	mockKojiClient(t, fk)
+
+	tmpdir := t.TempDir()
+	manifestPath := filepath.Join(tmpdir, "disk.osbuild-manifest.json")
+	buildlogPath := filepath.Join(tmpdir, "disk.buildlog")
+	sbomPath := filepath.Join(tmpdir, "disk.image-os.spdx.json")
+	for p, content := range map[string]string{
+		manifestPath: "fake-manifest",
+		buildlogPath: "fake-buildlog",
+		sbomPath:     "fake-sbom",
+	} {
+		require.NoError(t, os.WriteFile(p, []byte(content), 0644))
+	}
+
+	uploader, err := koji.NewUploader("https://koji.example.com/kojihub", "name", "1.0", "1", &koji.UploaderOptions{
+		Filename:    "disk.qcow2",
+		Arch:        "x86_64",
+		BootMode:    "uefi",
+		Manifest:    manifestPath,
+		BuildLog:    buildlogPath,
+		SBOMs:       []string{sbomPath},
+		HostOS:      "fedora-42",
+		ToolVersion: "1.2.3",
+	})
+	require.NoError(t, err)
+
+	var statusLog bytes.Buffer
+	result, err := uploader.UploadAndRegister(bytes.NewBufferString("fake-image"), 0, &statusLog)
+	require.NoError(t, err)
+	assert.Equal(t, "koji", result.Provider)
+	assert.Equal(t, "42", result.ImageID)
+	assert.Equal(t, "https://koji.example.com/kojihub", result.Koji.Server)
+	assert.Equal(t, 42, result.Koji.BuildID)
+	assert.Equal(t, "name-1.0-1", result.Koji.NVR)
+	assert.Contains(t, result.Koji.Outputs, cloud.KojiUploadOutput{
+		Filename:     "disk.qcow2",
+		ChecksumType: "md5",
+		Checksum:     "md5-disk.qcow2",
+	})
+
+	assert.Equal(t, map[string]string{
+		"disk.qcow2":                 "fake-image",
+		"disk.osbuild-manifest.json": "fake-manifest",
+		"disk.buildlog":              "fake-buildlog",
+		"disk.image-os.spdx.json":    "fake-sbom",
+	}, fk.uploaded)
+	expectedDir := "osbuild-cg/osbuild-image-builder-01010101-0101-4101-8101-010101010101"
+	assert.Equal(t, expectedDir, fk.uploadDir)
+	assert.Equal(t, expectedDir, fk.importDir)
+	assert.Equal(t, "token", fk.importToken)
+
+	assert.Equal(t, uint64(42), fk.importBuild.BuildID)
+	assert.Equal(t, "name", fk.importBuild.Name)
+	assert.Equal(t, "1.0", fk.importBuild.Version)
+	assert.Equal(t, "1", fk.importBuild.Release)
+	assert.Equal(t, map[string]koji.ImageExtraInfo{
+		"disk.qcow2": {Arch: "x86_64", BootMode: "uefi"},
+	}, fk.importBuild.Extra.TypeInfo.Image)
+	assert.Equal(t, map[string]*koji.ManifestExtraInfo{
+		"disk.osbuild-manifest.json": {Arch: "x86_64"},
+	}, fk.importBuild.Extra.Manifest)
+
+	require.Len(t, fk.importRoots, 1)
+	assert.Equal(t, "fedora-42", fk.importRoots[0].Host.Os)
+	assert.Equal(t, koji.ContentGenerator{Name: "osbuild", Version: "1.2.3"}, fk.importRoots[0].ContentGenerator)
+
+	require.Len(t, fk.importOuts, 4)
+	var types []koji.BuildOutputType
+	for _, out := range fk.importOuts {
+		assert.Equal(t, uint64(1), out.BuildRootID)
+		assert.Equal(t, koji.ChecksumTypeMD5, out.ChecksumType)
+		assert.Equal(t, fmt.Sprintf("md5-%s", out.Filename), out.Checksum)
+		assert.Equal(t, uint64(len(fk.uploaded[out.Filename])), out.FileSize)
+		types = append(types, out.Type)
+	}
+	assert.Equal(t, []koji.BuildOutputType{
+		koji.BuildOutputTypeImage,
+		koji.BuildOutputTypeManifest,
+		koji.BuildOutputTypeSbomDoc,
+		koji.BuildOutputTypeLog,
+	}, types)
+	assert.Equal(t, "noarch", fk.importOuts[3].Arch)
+	assert.Nil(t, fk.importOuts[3].Extra)
+
+	assert.Equal(t, 0, fk.failBuildCalls)
+	assert.Equal(t, 1, fk.logoutCalls)
+	assert.True(t, strings.HasSuffix(statusLog.String(), "Koji build imported: 42 (name-1.0-1)\n"))
+}
+
+func TestKojiUploaderUploadFailsBuild(t *testing.T) {
+	fk := &fakeKojiClient{
+		importErr: fmt.Errorf("fake-import-err"),
+	}
+	mockKojiClient(t, fk)
+
+	uploader, err := koji.NewUploader("https://koji.example.com/kojihub", "name", "1.0", "1", nil)
+	require.NoError(t, err)
+	_, err = uploader.UploadAndRegister(bytes.NewBufferString("fake-image"), 0, io.Discard)
+	assert.EqualError(t, err, "cannot import koji build name-1.0-1: fake-import-err")
+	assert.Equal(t, 1, fk.failBuildCalls)
+	assert.Equal(t, 1, fk.logoutCalls)
+}
+
+func TestKojiUploaderUploadMissingManifest(t *testing.T) {
+	fk := &fakeKojiClient{}
+	mockKojiClient(t, fk)
+
+	uploader, err := koji.NewUploader("https://koji.example.com/kojihub", "name", "1.0", "1", &koji.UploaderOptions{
+		Manifest: "/no/such/manifest.json",
+	})
+	require.NoError(t, err)
+	_, err = uploader.UploadAndRegister(bytes.NewBufferString("fake-image"), 0, io.Discard)
+	assert.ErrorContains(t, err, "open /no/such/manifest.json: no such file or directory")
+	assert.Equal(t, 1, fk.failBuildCalls)
+}
@@ -0,0 +1,11 @@
+package oci
+
+type OciUploaderClient = ociUploaderClient
+
+func MockNewOciClient(f func(*ClientParams) (ociUploaderClient, error)) (restore func()) {
+	saved := newOciClient
+	newOciClient = f
+	return func() {
+		newOciClient = saved
+	}
+}
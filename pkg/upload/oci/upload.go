@@ -3,6 +3,7 @@ package oci
 import (
 	"context"
 	"fmt"
+	"io"
 	"net/http"
 	"os"
 	"time"
@@ -38,6 +39,51 @@ func (c Client) Upload(objectName, bucketName, namespace string, file *os.File)
 	return err
 }
 
+// UploadFromReader uploads the content read from r into an objectName under
+// the bucketName in the namespace.
+func (c Client) UploadFromReader(objectName, bucketName, namespace string, r io.Reader) error {
+	req := transfer.UploadStreamRequest{
+		UploadRequest: transfer.UploadRequest{
+			NamespaceName:       common.String(namespace),
+			BucketName:          common.String(bucketName),
+			ObjectName:          common.String(objectName),
+			ObjectStorageClient: &c.storageClient,
+		},
+		StreamReader: r,
+	}
+
+	uploadManager := transfer.NewUploadManager()
+	if _, err := uploadManager.UploadStream(context.Background(), req); err != nil {
+		return fmt.Errorf("failed to upload the stream to object %s: %w", objectName, err)
+	}
+	return nil
+}
+
+// CheckBucket ensures the bucketName in the namespace can be accessed
+// with the configured credentials.
+func (c Client) CheckBucket(bucketName, namespace string) error {
+	_, err := c.storageClient.GetBucket(context.Background(), objectstorage.GetBucketRequest{
+		NamespaceName: common.String(namespace),
+		BucketName:    common.String(bucketName),
+	})
+	if err != nil {
+		return fmt.Errorf("cannot access bucket '%s' in namespace '%s': %w", bucketName, namespace, err)
+	}
+	return nil
+}
+
+// CheckCompartment ensures the compartmentID can be accessed with the
+// configured credentials.
+func (c Client) CheckCompartment(compartmentID string) error {
+	_, err := c.identityClient.GetCompartment(context.Background(), identity.GetCompartmentRequest{
+		CompartmentId: common.String(compartmentID),
+	})
+	if err != nil {
+		return fmt.Errorf("cannot access compartment '%s': %w", compartmentID, err)
+	}
+	return nil
+}
+
 // Creates an image from an existing storage object, deletes the storage object
 func (c Client) CreateImage(objectName, bucketName, namespace, compartmentID, imageName string) (string, error) {
 	// clean up the object even if we fail
@@ -302,8 +348,12 @@ func NewClient(clientParams *ClientParams) (Client, error) {
 	if err != nil {
 		return Client{}, fmt.Errorf("failed to create an Oracle workrequests client: %w", err)
 	}
+	region, err := configProvider.Region()
+	if err != nil {
+		return Client{}, fmt.Errorf("failed to get the Oracle region: %w", err)
+	}
 	return Client{ociClient: ociClient{
-		region:             clientParams.Region,
+		region:             region,
 		storageClient:      storageClient,
 		identityClient:     identityClient,
 		computeClient:      computeClient,
@@ -0,0 +1,99 @@
+package oci
+
+import (
+	"fmt"
+	"io"
+
+	"github.com/google/uuid"
+
+	"github.com/osbuild/image-builder/pkg/cloud"
+)
+
+type ociUploader struct {
+	client ociUploaderClient
+
+	compartmentID string
+	bucketName    string
+	namespace     string
+	imageName     string
+}
+
+type UploaderOptions struct {
+	// ClientParams are used to create the OCI client, if nil the
+	// default configuration (e.g. $HOME/.oci/config) is used.
+	ClientParams *ClientParams
+}
+
+// testing support
+type ociUploaderClient interface {
+	CheckBucket(bucketName, namespace string) error
+	CheckCompartment(compartmentID string) error
+	UploadFromReader(objectName, bucketName, namespace string, r io.Reader) error
+	CreateImage(objectName, bucketName, namespace, compartmentID, imageName string) (string, error)
+}
+
+var newOciClient = func(clientParams *ClientParams) (ociUploaderClient, error) {
+	return NewClient(clientParams)
+}
+
+func NewUploader(compartmentID, bucketName, namespace, imageName string, opts *UploaderOptions) (cloud.Uploader, error) {
+	if opts == nil {
+		opts = &UploaderOptions{}
+	}
+
+	client, err := newOciClient(opts.ClientParams)
+	if err != nil {
+		return nil, err
+	}
+
+	return &ociUploader{
+		client:        client,
+		compartmentID: compartmentID,
+		bucketName:    bucketName,
+		namespace:     namespace,
+		imageName:     imageName,
+	}, nil
+}
+
+var _ cloud.Uploader = &ociUploader{}
+
+func (ou *ociUploader) Check(status io.Writer) error {
+	fmt.Fprintf(status, "Checking OCI compartment access...\n")
+	if err := ou.client.CheckCompartment(ou.compartmentID); err != nil {
+		return err
+	}
+
+	fmt.Fprintf(status, "Checking OCI bucket access...\n")
+	if err := ou.client.CheckBucket(ou.bucketName, ou.namespace); err != nil {
+		return err
+	}
+	fmt.Fprintf(status, "Upload conditions met.\n")
+	return nil
+}
+
+func (ou *ociUploader) UploadAndRegister(r io.Reader, _ uint64, status io.Writer) (*cloud.UploadResult, error) {
+	objectName := fmt.Sprintf("%s-%s", uuid.New().String(), ou.imageName)
+	fmt.Fprintf(status, "Uploading %s to %s:%s\n", ou.imageName, ou.bucketName, objectName)
+	if err := ou.client.UploadFromReader(objectName, ou.bucketName, ou.namespace, r); err != nil {
+		return nil, err
+	}
+
+	// CreateImage() takes care of deleting the object again
+	fmt.Fprintf(status, "Creating image %s\n", ou.imageName)
+	imageID, err := ou.client.CreateImage(objectName, ou.bucketName, ou.namespace, ou.compartmentID, ou.imageName)
+	if err != nil {
+		return nil, err
+	}
+	fmt.Fprintf(status, "Image created: %s\n", imageID)
+
+	return &cloud.UploadResult{
+		Provider: "oci",
+		ImageID:  imageID,
+		OCI: &cloud.OCIUploadResult{
+			CompartmentID: ou.compartmentID,
+			Namespace:     ou.namespace,
+			Bucket:        ou.bucketName,
+			ImageName:     ou.imageName,
+		},
+	}, nil
+}
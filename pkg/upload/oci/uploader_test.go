@@ -0,0 +1,130 @@
+package oci_test
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"testing"
+
+	"github.com/google/uuid"
+	"github.com/stretchr/testify/assert"
+
+	"github.com/osbuild/image-builder/pkg/cloud"
+	"github.com/osbuild/image-builder/pkg/upload/oci"
+)
+
+type fakeOCIClient struct {
+	checkBucketErr      error
+	checkCompartmentErr error
+
+	uploadRead  bytes.Buffer
+	uploadErr   error
+	uploadCalls int
+
+	createImageID    string
+	createImageErr   error
+	createImageCalls int
+}
+
+func (fo *fakeOCIClient) CheckBucket(bucketName, namespace string) error {
+	return fo.checkBucketErr
+}
+
+func (fo *fakeOCIClient) CheckCompartment(compartmentID string) error {
+	return fo.checkCompartmentErr
+}
+
+func (fo *fakeOCIClient) UploadFromReader(objectName, bucketName, namespace string, r io.Reader) error {
+	fo.uploadCalls++
+	if _, err := io.Copy(&fo.uploadRead, r); err != nil {
+		return err
+	}
+	return fo.uploadErr
+}
+
+func (fo *fakeOCIClient) CreateImage(objectName, bucketName, namespace, compartmentID, imageName string) (string, error) {
+	fo.createImageCalls++
+	return fo.createImageID, fo.createImageErr
+}
+
+type repeatReader struct{}
+
+func (r *repeatReader) Read(p []byte) (int, error) {
+	for i := range p {
+		p[i] = 0x1
+	}
+	return len(p), nil
+}
+
+func TestUploaderCheck(t *testing.T) {
+	fo := &fakeOCIClient{}
+	restore := oci.MockNewOciClient(func(*oci.ClientParams) (oci.OciUploaderClient, error) {
+		return fo, nil
+	})
+	defer restore()
+
+	uploader, err := oci.NewUploader("compartment", "bucket", "namespace", "image", nil)
+	assert.NoError(t, err)
+	var statusLog bytes.Buffer
+	err = uploader.Check(&statusLog)
+	assert.NoError(t, err)
+	expectedStatusLog := `Checking OCI compartment access...
+Checking OCI bucket access...
+Upload conditions met.
+`
+	assert.Equal(t, expectedStatusLog, statusLog.String())
+
+	fo.checkBucketErr = fmt.Errorf("fake-bucket-err")
+	err = uploader.Check(io.Discard)
+	assert.EqualError(t, err, "fake-bucket-err")
+}
+
+func TestUploaderUploadHappy(t *testing.T) {
+	uuid.SetRand(&repeatReader{})
+
+	fo := &fakeOCIClient{
+		createImageID: "ocid1.image.oc1..fake",
+	}
+	restore := oci.MockNewOciClient(func(*oci.ClientParams) (oci.OciUploaderClient, error) {
+		return fo, nil
+	})
+	defer restore()
+
+	uploader, err := oci.NewUploader("compartment", "bucket", "namespace", "image", nil)
+	assert.NoError(t, err)
+	var uploadLog bytes.Buffer
+	result, err := uploader.UploadAndRegister(bytes.NewBufferString("fake-oci-image"), 0, &uploadLog)
+	assert.NoError(t, err)
+	assert.Equal(t, "oci", result.Provider)
+	assert.Equal(t, "ocid1.image.oc1..fake", result.ImageID)
+	assert.Equal(t, &cloud.OCIUploadResult{
+		CompartmentID: "compartment",
+		Namespace:     "namespace",
+		Bucket:        "bucket",
+		ImageName:     "image",
+	}, result.OCI)
+	assert.Equal(t, "fake-oci-image", fo.uploadRead.String())
+	assert.Equal(t, 1, fo.createImageCalls)
+	expectedUploadLog := `Uploading image to bucket:01010101-0101-4101-8101-010101010101-image
+Creating image image
+Image created: ocid1.image.oc1..fake
+`
+	assert.Equal(t, expectedUploadLog, uploadLog.String())
+}
+
+func TestUploaderUploadError(t *testing.T) {
+	fo := &fakeOCIClient{
+		uploadErr: fmt.Errorf("fake-upload-err"),
+	}
+	restore := oci.MockNewOciClient(func(*oci.ClientParams) (oci.OciUploaderClient, error) {
+		return fo, nil
+	})
+	defer restore()
+
+	uploader, err := oci.NewUploader("compartment", "bucket", "namespace", "image", nil)
+	assert.NoError(t, err)
+	result, err := uploader.UploadAndRegister(bytes.NewBufferString("fake-oci-image"), 0, io.Discard)
+	assert.EqualError(t, err, "fake-upload-err")
+	assert.Nil(t, result)
+	assert.Equal(t, 0, fo.createImageCalls)
+}
@@ -0,0 +1,336 @@
+package vmware
+
+import (
+	"archive/tar"
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"net/url"
+	"path"
+	"strings"
+
+	"github.com/vmware/govmomi"
+	"github.com/vmware/govmomi/find"
+	"github.com/vmware/govmomi/nfc"
+	"github.com/vmware/govmomi/object"
+	"github.com/vmware/govmomi/ovf"
+	"github.com/vmware/govmomi/vim25/soap"
+	"github.com/vmware/govmomi/vim25/types"
+	"github.com/vmware/govmomi/vmdk"
+
+	"github.com/osbuild/image-builder/pkg/cloud"
+)
+
+const (
+	FormatVMDK = "vmdk"
+	FormatOVA  = "ova"
+)
+
+type vsphereUploader struct {
+	creds     Credentials
+	imageName string
+	format    string
+	insecure  bool
+}
+
+type UploaderOptions struct {
+	// Format of the uploaded image, either FormatVMDK (a stream
+	// optimized vmdk) or FormatOVA. Defaults to FormatVMDK.
+	Format string
+	// Insecure disables the verification of the server certificate
+	Insecure bool
+}
+
+// NewUploader returns a cloud.Uploader that imports the image into the
+// datastore from the given credentials and registers it as a VM template
+// with the given imageName.
+func NewUploader(creds Credentials, imageName string, opts *UploaderOptions) (cloud.Uploader, error) {
+	if opts == nil {
+		opts = &UploaderOptions{}
+	}
+	format := opts.Format
+	if format == "" {
+		format = FormatVMDK
+	}
+	if format != FormatVMDK && format != FormatOVA {
+		return nil, fmt.Errorf("unsupported vSphere image format %q, supported: %s, %s", format, FormatVMDK, FormatOVA)
+	}
+
+	return &vsphereUploader{
+		creds:     creds,
+		imageName: imageName,
+		format:    format,
+		insecure:  opts.Insecure,
+	}, nil
+}
+
+var _ cloud.Uploader = &vsphereUploader{}
+
+// importTarget contains the vSphere objects needed to import an image
+type importTarget struct {
+	datacenter *object.Datacenter
+	datastore  *object.Datastore
+	pool       *object.ResourcePool
+	folder     *object.Folder
+}
+
+func (vu *vsphereUploader) connect(ctx context.Context) (*govmomi.Client, error) {
+	u, err := soap.ParseURL(vu.creds.Host)
+	if err != nil {
+		return nil, fmt.Errorf("cannot parse vSphere host %q: %w", vu.creds.Host, err)
+	}
+	u.User = url.UserPassword(vu.creds.Username, vu.creds.Password)
+
+	client, err := govmomi.NewClient(ctx, u, vu.insecure)
+	if err != nil {
+		return nil, fmt.Errorf("cannot connect to vSphere: %w", err)
+	}
+	return client, nil
+}
+
+func (vu *vsphereUploader) findTarget(ctx context.Context, client *govmomi.Client) (*importTarget, error) {
+	finder := find.NewFinder(client.Client)
+
+	dc, err := finder.Datacenter(ctx, vu.creds.Datacenter)
+	if err != nil {
+		return nil, fmt.Errorf("cannot find datacenter %q: %w", vu.creds.Datacenter, err)
+	}
+	finder.SetDatacenter(dc)
+
+	ds, err := finder.Datastore(ctx, vu.creds.Datastore)
+	if err != nil {
+		return nil, fmt.Errorf("cannot find datastore %q: %w", vu.creds.Datastore, err)
+	}
+	pool, err := finder.ResourcePool(ctx, fmt.Sprintf("%s/Resources", vu.creds.Cluster))
+	if err != nil {
+		return nil, fmt.Errorf("cannot find resource pool of cluster %q: %w", vu.creds.Cluster, err)
+	}
+
+	var folder *object.Folder
+	if vu.creds.Folder != "" {
+		folder, err = finder.Folder(ctx, vu.creds.Folder)
+		if err != nil {
+			return nil, fmt.Errorf("cannot find folder %q: %w", vu.creds.Folder, err)
+		}
+	} else {
+		folders, err := dc.Folders(ctx)
+		if err != nil {
+			return nil, fmt.Errorf("cannot find folders of datacenter %q: %w", vu.creds.Datacenter, err)
+		}
+		folder = folders.VmFolder
+	}
+
+	return &importTarget{
+		datacenter: dc,
+		datastore:  ds,
+		pool:       pool,
+		folder:     folder,
+	}, nil
+}
+
+func (vu *vsphereUploader) Check(status io.Writer) error {
+	ctx := context.Background()
+
+	fmt.Fprintf(status, "Checking vSphere login...\n")
+	client, err := vu.connect(ctx)
+	if err != nil {
+		return err
+	}
+	defer func() {
+		_ = client.Logout(ctx)
+	}()
+
+	fmt.Fprintf(status, "Checking vSphere datacenter, datastore and cluster...\n")
+	target, err := vu.findTarget(ctx, client)
+	if err != nil {
+		return err
+	}
+
+	fmt.Fprintf(status, "Checking vSphere template name...\n")
+	vmPath := path.Join(target.folder.InventoryPath, vu.imageName)
+	if _, err := find.NewFinder(client.Client).VirtualMachine(ctx, vmPath); err == nil {
+		return fmt.Errorf("virtual machine %q already exists", vmPath)
+	}
+	fmt.Fprintf(status, "Upload conditions met.\n")
+	return nil
+}
+
+func (vu *vsphereUploader) importVApp(ctx context.Context, client *govmomi.Client, target *importTarget, descriptor string) (*nfc.LeaseInfo, *nfc.Lease, error) {
+	params := types.OvfCreateImportSpecParams{
+		DiskProvisioning: string(types.VirtualDiskTypeThin),
+		EntityName:       vu.imageName,
+	}
+	spec, err := ovf.NewManager(client.Client).CreateImportSpec(ctx, descriptor, target.pool, target.datastore, &params)
+	if err != nil {
+		return nil, nil, fmt.Errorf("cannot create import spec: %w", err)
+	}
+	if spec.Error != nil {
+		return nil, nil, fmt.Errorf("cannot create import spec: %s", spec.Error[0].LocalizedMessage)
+	}
+
+	lease, err := target.pool.ImportVApp(ctx, spec.ImportSpec, target.folder, nil)
+	if err != nil {
+		return nil, nil, fmt.Errorf("cannot import into vSphere: %w", err)
+	}
+	info, err := lease.Wait(ctx, spec.FileItem)
+	if err != nil {
+		_ = lease.Abort(ctx, nil)
+		return nil, nil, fmt.Errorf("cannot import into vSphere: %w", err)
+	}
+	return info, lease, nil
+}
+
+// uploadVMDK imports a stream optimized vmdk by generating a minimal
+// OVF descriptor for it (just like "govc import.vmdk" does).
+func (vu *vsphereUploader) uploadVMDK(ctx context.Context, client *govmomi.Client, target *importTarget, r io.Reader, uploadSize uint64) (*types.ManagedObjectReference, error) {
+	if uploadSize == 0 {
+		return nil, fmt.Errorf("cannot upload vmdk of unknown size")
+	}
+
+	// the header is needed to generate the ovf descriptor, keep
+	// what was read so that it can be prepended for the upload
+	var header bytes.Buffer
+	di, err := vmdk.Seek(io.TeeReader(r, &header))
+	if err != nil {
+		return nil, fmt.Errorf("cannot read vmdk header: %w", err)
+	}
+	di.Name = fmt.Sprintf("%s.vmdk", vu.imageName)
+	di.ImportName = vu.imageName
+	// #nosec G115
+	di.Size = int64(uploadSize)
+	descriptor, err := di.OVF()
+	if err != nil {
+		return nil, err
+	}
+
+	info, lease, err := vu.importVApp(ctx, client, target, descriptor)
+	if err != nil {
+		return nil, err
+	}
+	updater := lease.StartUpdater(ctx, info)
+	defer updater.Done()
+
+	opts := soap.Upload{
+		ContentLength: di.Size,
+	}
+	if err := lease.Upload(ctx, info.Items[0], io.MultiReader(&header, r), opts); err != nil {
+		_ = lease.Abort(ctx, nil)
+		return nil, fmt.Errorf("cannot upload vmdk: %w", err)
+	}
+	if err := lease.Complete(ctx); err != nil {
+		return nil, err
+	}
+	return &info.Entity, nil
+}
+
+// uploadOVA imports an ova by streaming its disks in the order they
+// appear in the archive. The OVF descriptor must be the first entry.
+func (vu *vsphereUploader) uploadOVA(ctx context.Context, client *govmomi.Client, target *importTarget, r io.Reader) (*types.ManagedObjectReference, error) {
+	tr := tar.NewReader(r)
+	hdr, err := tr.Next()
+	if err != nil {
+		return nil, fmt.Errorf("cannot read ova: %w", err)
+	}
+	if !strings.HasSuffix(hdr.Name, ".ovf") {
+		return nil, fmt.Errorf("cannot read ova: first entry %q is not an ovf descriptor", hdr.Name)
+	}
+	descriptor, err := io.ReadAll(tr)
+	if err != nil {
+		return nil, fmt.Errorf("cannot read ovf descriptor: %w", err)
+	}
+
+	info, lease, err := vu.importVApp(ctx, client, target, string(descriptor))
+	if err != nil {
+		return nil, err
+	}
+	updater := lease.StartUpdater(ctx, info)
+	defer updater.Done()
+
+	items := make(map[string]nfc.FileItem, len(info.Items))
+	for _, item := range info.Items {
+		items[item.Path] = item
+	}
+	for len(items) > 0 {
+		hdr, err := tr.Next()
+		if errors.Is(err, io.EOF) {
+			break
+		}
+		if err != nil {
+			_ = lease.Abort(ctx, nil)
+			return nil, fmt.Errorf("cannot read ova: %w", err)
+		}
+		item, ok := items[hdr.Name]
+		if !ok {
+			continue
+		}
+		if err := lease.Upload(ctx, item, tr, soap.Upload{ContentLength: hdr.Size}); err != nil {
+			_ = lease.Abort(ctx, nil)
+			return nil, fmt.Errorf("cannot upload %s: %w", hdr.Name, err)
+		}
+		delete(items, hdr.Name)
+	}
+	if len(items) > 0 {
+		_ = lease.Abort(ctx, nil)
+		var missing []string
+		for name := range items {
+			missing = append(missing, name)
+		}
+		return nil, fmt.Errorf("cannot find %v in ova", missing)
+	}
+
+	if err := lease.Complete(ctx); err != nil {
+		return nil, err
+	}
+	return &info.Entity, nil
+}
+
+func (vu *vsphereUploader) UploadAndRegister(r io.Reader, uploadSize uint64, status io.Writer) (*cloud.UploadResult, error) {
+	ctx := context.Background()
+
+	client, err := vu.connect(ctx)
+	if err != nil {
+		return nil, err
+	}
+	defer func() {
+		_ = client.Logout(ctx)
+	}()
+
+	target, err := vu.findTarget(ctx, client)
+	if err != nil {
+		return nil, err
+	}
+
+	fmt.Fprintf(status, "Uploading %s to datastore %s...\n", vu.imageName, vu.creds.Datastore)
+	var ref *types.ManagedObjectReference
+	switch vu.format {
+	case FormatOVA:
+		ref, err = vu.uploadOVA(ctx, client, target, r)
+	default:
+		ref, err = vu.uploadVMDK(ctx, client, target, r, uploadSize)
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	fmt.Fprintf(status, "Registering template %s...\n", vu.imageName)
+	vm := object.NewVirtualMachine(client.Client, *ref)
+	if err := vm.MarkAsTemplate(ctx); err != nil {
+		return nil, fmt.Errorf("cannot mark %s as template: %w", vu.imageName, err)
+	}
+	inventoryPath := path.Join(target.folder.InventoryPath, vu.imageName)
+	fmt.Fprintf(status, "Template registered: %s\n", inventoryPath)
+
+	return &cloud.UploadResult{
+		Provider: "vsphere",
+		ImageID:  inventoryPath,
+		VSphere: &cloud.VSphereUploadResult{
+			Datacenter: vu.creds.Datacenter,
+			Datastore:  vu.creds.Datastore,
+			Cluster:    vu.creds.Cluster,
+			Folder:     target.folder.InventoryPath,
+			Format:     vu.format,
+		},
+	}, nil
+}
@@ -0,0 +1,133 @@
+package vmware_test
+
+import (
+	"archive/tar"
+	"bytes"
+	"crypto/tls"
+	"encoding/binary"
+	"fmt"
+	"io"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"github.com/vmware/govmomi/simulator"
+	"github.com/vmware/govmomi/vmdk"
+
+	"github.com/osbuild/image-builder/pkg/upload/vmware"
+)
+
+// fakeVMDK returns a minimal stream optimized vmdk
+func fakeVMDK(t *testing.T) []byte {
+	var di vmdk.Info
+	di.Header.MagicNumber = 0x564d444b
+	di.Header.Version = 3
+	di.Header.Flags = 1 << 16
+	di.Header.Capacity = 2048
+
+	var buf bytes.Buffer
+	require.NoError(t, binary.Write(&buf, binary.LittleEndian, di.Header))
+	descriptor := make([]byte, vmdk.SectorSize)
+	copy(descriptor, `# Disk DescriptorFile
+version=1
+CID=fffffffe
+parentCID=ffffffff
+createType="streamOptimized"
+RW 2048 SPARSE "disk.vmdk"
+`)
+	buf.Write(descriptor)
+	buf.WriteString("fake-vmdk-content")
+	return buf.Bytes()
+}
+
+func fakeOVA(t *testing.T, disk []byte) []byte {
+	di, err := vmdk.Seek(bytes.NewReader(disk))
+	require.NoError(t, err)
+	di.Name = "disk.vmdk"
+	di.ImportName = "disk"
+	di.Size = int64(len(disk))
+	descriptor, err := di.OVF()
+	require.NoError(t, err)
+
+	var buf bytes.Buffer
+	tw := tar.NewWriter(&buf)
+	for _, entry := range []struct {
+		name    string
+		content []byte
+	}{
+		{"disk.ovf", []byte(descriptor)},
+		{"disk.vmdk", disk},
+	} {
+		err := tw.WriteHeader(&tar.Header{
+			Name: entry.name,
+			Mode: 0644,
+			Size: int64(len(entry.content)),
+		})
+		require.NoError(t, err)
+		_, err = tw.Write(entry.content)
+		require.NoError(t, err)
+	}
+	require.NoError(t, tw.Close())
+	return buf.Bytes()
+}
+
+func credsForSimulator(s *simulator.Server) vmware.Credentials {
+	password, _ := s.URL.User.Password()
+	return vmware.Credentials{
+		Host:       s.URL.Host,
+		Username:   s.URL.User.Username(),
+		Password:   password,
+		Datacenter: "DC0",
+		Cluster:    "DC0_C0",
+		Datastore:  "LocalDS_0",
+	}
+}
+
+func TestUploaderVsphereSimulator(t *testing.T) {
+	disk := fakeVMDK(t)
+
+	for _, tc := range []struct {
+		format  string
+		content []byte
+	}{
+		{vmware.FormatVMDK, disk},
+		{vmware.FormatOVA, fakeOVA(t, disk)},
+	} {
+		t.Run(tc.format, func(t *testing.T) {
+			model := simulator.VPX()
+			defer model.Remove()
+			require.NoError(t, model.Create())
+			model.Service.TLS = new(tls.Config)
+			s := model.Service.NewServer()
+			defer s.Close()
+
+			imageName := fmt.Sprintf("test-%s", tc.format)
+			uploader, err := vmware.NewUploader(credsForSimulator(s), imageName, &vmware.UploaderOptions{
+				Format:   tc.format,
+				Insecure: true,
+			})
+			require.NoError(t, err)
+
+			var statusLog bytes.Buffer
+			err = uploader.Check(&statusLog)
+			require.NoError(t, err)
+			assert.Contains(t, statusLog.String(), "Upload conditions met.\n")
+
+			result, err := uploader.UploadAndRegister(bytes.NewReader(tc.content), uint64(len(tc.content)), &statusLog)
+			require.NoError(t, err)
+			assert.Equal(t, "vsphere", result.Provider)
+			assert.Equal(t, "/DC0/vm/"+imageName, result.ImageID)
+			assert.Equal(t, "/DC0/vm", result.VSphere.Folder)
+			assert.Equal(t, tc.format, result.VSphere.Format)
+
+			// the template now exists, so a second upload must fail
+			err = uploader.Check(io.Discard)
+			assert.EqualError(t, err, fmt.Sprintf(`virtual machine "/DC0/vm/%s" already exists`, imageName))
+		})
+	}
+}
+
+func TestUploaderVsphereUnsupportedFormat(t *testing.T) {
+	_, err := vmware.NewUploader(vmware.Credentials{}, "image", &vmware.UploaderOptions{Format: "qcow2"})
+	assert.EqualError(t, err, `unsupported vSphere image format "qcow2", supported: vmdk, ova`)
+}